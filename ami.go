@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// DefaultAMIParameter is the public SSM parameter of the latest Amazon
+	// Linux 2023 AMI, which exists in every region. {arch} is replaced with
+	// the architecture of the instance type.
+	DefaultAMIParameter = "/aws/service/ami-amazon-linux-latest/al2023-ami-kernel-default-{arch}"
+
+	ArchitectureX86 = "x86_64"
+	ArchitectureARM = "arm64"
+)
+
+// InstanceArchitecture guesses the CPU architecture of an instance type from
+// its name: Graviton families have a "g" among the attributes following the
+// generation number (t4g, c7gn, im4gn). ResolveAMI checks the real
+// architecture before anything is launched.
+func InstanceArchitecture(instanceType string) string {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	generation := strings.IndexFunc(family, unicode.IsDigit)
+	if generation < 0 {
+		return ArchitectureX86
+	}
+	attributes := strings.TrimLeftFunc(family[generation:], unicode.IsDigit)
+	if strings.ContainsRune(attributes, 'g') {
+		return ArchitectureARM
+	}
+	return ArchitectureX86
+}
+
+// IsBurstableInstanceType reports whether the instance type is of a
+// burstable T family, running on CPU credits.
+func IsBurstableInstanceType(instanceType string) bool {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	return len(family) > 1 && family[0] == 't' && unicode.IsDigit(rune(family[1]))
+}
+
+// amiParameterArchitecture returns the architecture the AMIs of an SSM
+// parameter are built for, judging by its name, or "" when it doesn't say.
+func amiParameterArchitecture(parameter string) string {
+	switch {
+	case strings.Contains(parameter, "arm64"), strings.Contains(parameter, "aarch64"):
+		return ArchitectureARM
+	case strings.Contains(parameter, "x86_64"), strings.Contains(parameter, "amd64"):
+		return ArchitectureX86
+	}
+	return ""
+}
+
+// AMIParameterPath returns the SSM parameter the AMI is read from.
+func (c LaunchTemplateConfig) AMIParameterPath() string {
+	return strings.ReplaceAll(c.AMIParameter, "{arch}", InstanceArchitecture(c.InstanceType))
+}
+
+// ImageSource describes where the AMI comes from without calling AWS.
+func (c LaunchTemplateConfig) ImageSource() string {
+	if c.AMIID != "" {
+		return c.AMIID
+	}
+	return "the AMI in " + c.AMIParameterPath()
+}
+
+// ResolveAMI sets the AMI ID from the SSM parameter of the region when none
+// is configured, then checks the AMI exists in the region and its
+// architecture is supported by the instance type.
+func ResolveAMI(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config) error {
+	ltConfig := &cfg.LaunchTemplate
+	// The AMI of an existing launch template is part of it.
+	if ltConfig.Existing != nil {
+		return nil
+	}
+	if ltConfig.AMIID == "" {
+		parameter := ltConfig.AMIParameterPath()
+		output, err := clients.SSM.GetParameter(ctx, &ssm.GetParameterInput{
+			Name: aws.String(parameter),
+		})
+		if err != nil {
+			return fmt.Errorf("error reading AMI ID from SSM parameter %s: %w", parameter, err)
+		}
+		ltConfig.AMIID = aws.StringValue(output.Parameter.Value)
+		logger.Printf("Resolved AMI %s from %s", ltConfig.AMIID, parameter)
+	}
+
+	imagesOutput, err := clients.EC2.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{ltConfig.AMIID},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing AMI %s: %w", ltConfig.AMIID, err)
+	}
+	if len(imagesOutput.Images) == 0 {
+		return fmt.Errorf("AMI %s does not exist in region %s", ltConfig.AMIID, cfg.Region)
+	}
+	architecture := string(imagesOutput.Images[0].Architecture)
+
+	typesOutput, err := clients.EC2.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(ltConfig.InstanceType)},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing instance type %s: %w", ltConfig.InstanceType, err)
+	}
+	if len(typesOutput.InstanceTypes) == 0 || typesOutput.InstanceTypes[0].ProcessorInfo == nil {
+		return fmt.Errorf("instance type %s is not offered in region %s", ltConfig.InstanceType, cfg.Region)
+	}
+	supported := typesOutput.InstanceTypes[0].ProcessorInfo.SupportedArchitectures
+	if !slices.Contains(supported, types.ArchitectureType(architecture)) {
+		return fmt.Errorf("AMI %s is built for %s, but instance type %s supports %v", ltConfig.AMIID, architecture, ltConfig.InstanceType, supported)
+	}
+	logger.Printf("AMI %s matches the %s architecture of %s", ltConfig.AMIID, architecture, ltConfig.InstanceType)
+
+	return nil
+}
@@ -0,0 +1,5 @@
+// Package api holds the gRPC service served by `serve --grpc-addr`, generated
+// from stacks.proto.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative stacks.proto
@@ -0,0 +1,221 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: stacks.proto
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Stacks_CreateStack_FullMethodName  = "/pzc.v1.Stacks/CreateStack"
+	Stacks_DestroyStack_FullMethodName = "/pzc.v1.Stacks/DestroyStack"
+	Stacks_WatchStack_FullMethodName   = "/pzc.v1.Stacks/WatchStack"
+)
+
+// StacksClient is the client API for Stacks service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Stacks applies, destroys and watches the stacks of the config file served
+// by `serve --grpc-addr`. Every stack name is applied like --stack, so each
+// stack gets its own resources and state file.
+type StacksClient interface {
+	// CreateStack creates the stack, or updates the existing one, in the
+	// background and returns the job right away.
+	CreateStack(ctx context.Context, in *CreateStackRequest, opts ...grpc.CallOption) (*Job, error)
+	// DestroyStack deletes every resource of the stack in the background and
+	// returns the job right away.
+	DestroyStack(ctx context.Context, in *DestroyStackRequest, opts ...grpc.CallOption) (*Job, error)
+	// WatchStack streams the steps and log lines of the jobs of the stack and
+	// the changes of its instances until the client cancels the call.
+	WatchStack(ctx context.Context, in *WatchStackRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StackEvent], error)
+}
+
+type stacksClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewStacksClient(cc grpc.ClientConnInterface) StacksClient {
+	return &stacksClient{cc}
+}
+
+func (c *stacksClient) CreateStack(ctx context.Context, in *CreateStackRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Stacks_CreateStack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stacksClient) DestroyStack(ctx context.Context, in *DestroyStackRequest, opts ...grpc.CallOption) (*Job, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Job)
+	err := c.cc.Invoke(ctx, Stacks_DestroyStack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stacksClient) WatchStack(ctx context.Context, in *WatchStackRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StackEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Stacks_ServiceDesc.Streams[0], Stacks_WatchStack_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchStackRequest, StackEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Stacks_WatchStackClient = grpc.ServerStreamingClient[StackEvent]
+
+// StacksServer is the server API for Stacks service.
+// All implementations must embed UnimplementedStacksServer
+// for forward compatibility.
+//
+// Stacks applies, destroys and watches the stacks of the config file served
+// by `serve --grpc-addr`. Every stack name is applied like --stack, so each
+// stack gets its own resources and state file.
+type StacksServer interface {
+	// CreateStack creates the stack, or updates the existing one, in the
+	// background and returns the job right away.
+	CreateStack(context.Context, *CreateStackRequest) (*Job, error)
+	// DestroyStack deletes every resource of the stack in the background and
+	// returns the job right away.
+	DestroyStack(context.Context, *DestroyStackRequest) (*Job, error)
+	// WatchStack streams the steps and log lines of the jobs of the stack and
+	// the changes of its instances until the client cancels the call.
+	WatchStack(*WatchStackRequest, grpc.ServerStreamingServer[StackEvent]) error
+	mustEmbedUnimplementedStacksServer()
+}
+
+// UnimplementedStacksServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedStacksServer struct{}
+
+func (UnimplementedStacksServer) CreateStack(context.Context, *CreateStackRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateStack not implemented")
+}
+func (UnimplementedStacksServer) DestroyStack(context.Context, *DestroyStackRequest) (*Job, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DestroyStack not implemented")
+}
+func (UnimplementedStacksServer) WatchStack(*WatchStackRequest, grpc.ServerStreamingServer[StackEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method WatchStack not implemented")
+}
+func (UnimplementedStacksServer) mustEmbedUnimplementedStacksServer() {}
+func (UnimplementedStacksServer) testEmbeddedByValue()                {}
+
+// UnsafeStacksServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to StacksServer will
+// result in compilation errors.
+type UnsafeStacksServer interface {
+	mustEmbedUnimplementedStacksServer()
+}
+
+func RegisterStacksServer(s grpc.ServiceRegistrar, srv StacksServer) {
+	// If the following call pancis, it indicates UnimplementedStacksServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Stacks_ServiceDesc, srv)
+}
+
+func _Stacks_CreateStack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateStackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StacksServer).CreateStack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Stacks_CreateStack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StacksServer).CreateStack(ctx, req.(*CreateStackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Stacks_DestroyStack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DestroyStackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StacksServer).DestroyStack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Stacks_DestroyStack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StacksServer).DestroyStack(ctx, req.(*DestroyStackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Stacks_WatchStack_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchStackRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StacksServer).WatchStack(m, &grpc.GenericServerStream[WatchStackRequest, StackEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Stacks_WatchStackServer = grpc.ServerStreamingServer[StackEvent]
+
+// Stacks_ServiceDesc is the grpc.ServiceDesc for Stacks service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Stacks_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pzc.v1.Stacks",
+	HandlerType: (*StacksServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateStack",
+			Handler:    _Stacks_CreateStack_Handler,
+		},
+		{
+			MethodName: "DestroyStack",
+			Handler:    _Stacks_DestroyStack_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchStack",
+			Handler:       _Stacks_WatchStack_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "stacks.proto",
+}
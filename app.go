@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	DefaultAppContainerName = "app"
+	DefaultAppRestartPolicy = "always"
+)
+
+// AppRestartPolicies are the restart policies docker run accepts.
+var AppRestartPolicies = []string{"no", "always", "unless-stopped", "on-failure"}
+
+// AppConfig runs a container image on the instances. The user data script
+// installing docker and starting the container is generated from it, and
+// launchTemplate.userDataFile is not used.
+type AppConfig struct {
+	// Image is a public image, e.g. ghcr.io/owner/service:1.2.3.
+	Image string `json:"image"`
+	// Ports publish container ports on the instance, by default the target
+	// group port on the same port.
+	Ports []AppPort         `json:"ports"`
+	Env   map[string]string `json:"env"`
+	// RestartPolicy is no, always, unless-stopped or on-failure.
+	RestartPolicy string `json:"restartPolicy"`
+	ContainerName string `json:"containerName"`
+	// SecretNames are passed from the environment of the user data to the
+	// container, set from the secrets config.
+	SecretNames []string `json:"-"`
+}
+
+// AppPort publishes ContainerPort on HostPort, which defaults to the same
+// port.
+type AppPort struct {
+	HostPort      int32 `json:"hostPort"`
+	ContainerPort int32 `json:"containerPort"`
+}
+
+func (p AppPort) String() string {
+	return fmt.Sprintf("%d:%d", p.HostPort, p.ContainerPort)
+}
+
+// UserData generates the user data script running the container on Amazon
+// Linux.
+func (a AppConfig) UserData() []byte {
+	args := []string{"docker", "run", "--detach", "--name", shellQuote(a.ContainerName), "--restart", a.RestartPolicy}
+	for _, port := range a.Ports {
+		args = append(args, "--publish", port.String())
+	}
+	keys := make([]string, 0, len(a.Env))
+	for key := range a.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--env", shellQuote(key+"="+a.Env[key]))
+	}
+	for _, name := range a.SecretNames {
+		args = append(args, "--env", name)
+	}
+	args = append(args, shellQuote(a.Image))
+
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n")
+	script.WriteString("set -euo pipefail\n\n")
+	script.WriteString("dnf install -y docker\n")
+	script.WriteString("systemctl enable --now docker\n\n")
+	// The network may not be fully up yet when the instance boots.
+	fmt.Fprintf(&script, "for attempt in 1 2 3 4 5; do docker pull %s && break; sleep 5; done\n", shellQuote(a.Image))
+	script.WriteString(strings.Join(args, " ") + "\n")
+	return []byte(script.String())
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
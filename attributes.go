@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// MinSlowStartSeconds and MaxSlowStartSeconds bound the slow start
+	// duration, 0 turns slow start off.
+	MinSlowStartSeconds = 30
+	MaxSlowStartSeconds = 900
+	// DefaultDeregistrationDelaySeconds is the draining time of the load
+	// balancer, MaxDeregistrationDelaySeconds the longest it allows.
+	DefaultDeregistrationDelaySeconds = 300
+	MaxDeregistrationDelaySeconds     = 3600
+)
+
+// TargetGroupAttribute is a target group attribute set from the config.
+// Setting is the name of the config field, used when reporting drift.
+type TargetGroupAttribute struct {
+	Key     string
+	Setting string
+	Value   string
+}
+
+// Attributes returns the target group attributes the config manages.
+func (t TargetGroupConfig) Attributes() []TargetGroupAttribute {
+	return []TargetGroupAttribute{
+		{Key: "slow_start.duration_seconds", Setting: "slowStartSeconds", Value: strconv.Itoa(int(t.SlowStartSeconds))},
+		{Key: "deregistration_delay.timeout_seconds", Setting: "deregistrationDelaySeconds", Value: strconv.Itoa(int(t.DeregistrationDelaySeconds))},
+	}
+}
+
+func elbTargetGroupAttributes(attributes []TargetGroupAttribute) []elbTypes.TargetGroupAttribute {
+	elbAttributes := make([]elbTypes.TargetGroupAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		elbAttributes = append(elbAttributes, elbTypes.TargetGroupAttribute{Key: aws.String(attribute.Key), Value: aws.String(attribute.Value)})
+	}
+	return elbAttributes
+}
+
+// SetTargetGroupAttributes sets the attributes of the target group.
+func SetTargetGroupAttributes(ctx context.Context, elbClient *elasticloadbalancingv2.Client, targetGroupARN string, attributes []TargetGroupAttribute) error {
+	if _, err := elbClient.ModifyTargetGroupAttributes(ctx, &elasticloadbalancingv2.ModifyTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Attributes:     elbTargetGroupAttributes(attributes),
+	}); err != nil {
+		return fmt.Errorf("error modifying target group attributes: %w", err)
+	}
+	return nil
+}
+
+// DescribeTargetGroupAttributes returns the attributes of the target group by
+// key.
+func DescribeTargetGroupAttributes(ctx context.Context, elbClient *elasticloadbalancingv2.Client, targetGroupARN string) (map[string]string, error) {
+	output, err := elbClient.DescribeTargetGroupAttributes(ctx, &elasticloadbalancingv2.DescribeTargetGroupAttributesInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing target group attributes: %w", err)
+	}
+
+	attributes := make(map[string]string, len(output.Attributes))
+	for _, attribute := range output.Attributes {
+		attributes[aws.StringValue(attribute.Key)] = aws.StringValue(attribute.Value)
+	}
+	return attributes, nil
+}
+
+// UpdateTargetGroupAttributes sets the attributes that differ from the config.
+func UpdateTargetGroupAttributes(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, tgConfig TargetGroupConfig, targetGroupARN string) error {
+	live, err := DescribeTargetGroupAttributes(ctx, elbClient, targetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	var changed []TargetGroupAttribute
+	for _, attribute := range tgConfig.Attributes() {
+		if live[attribute.Key] != attribute.Value {
+			changed = append(changed, attribute)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	if err := SetTargetGroupAttributes(ctx, elbClient, targetGroupARN, changed); err != nil {
+		return err
+	}
+	for _, attribute := range changed {
+		logger.Printf("Target group %s: %s set to %s", targetGroupARN, attribute.Key, attribute.Value)
+	}
+	return nil
+}
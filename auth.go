@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	AuthenticationOIDC    = "oidc"
+	AuthenticationCognito = "cognito"
+	// MaxSessionTimeoutSeconds is the longest authentication session the load
+	// balancer allows, 7 days.
+	MaxSessionTimeoutSeconds = 604800
+)
+
+// UnauthenticatedRequestActions are what the listener does with requests
+// without a session: authenticate redirects to the identity provider.
+var UnauthenticatedRequestActions = []string{"authenticate", "allow", "deny"}
+
+// AuthenticationConfig makes the HTTPS listener authenticate users with an
+// OpenID Connect identity provider or a Cognito user pool before forwarding
+// to the targets. The OIDC client credentials are read from the Secrets
+// Manager secret ClientSecretID, a JSON object with clientId and
+// clientSecret.
+type AuthenticationConfig struct {
+	// Type is oidc or cognito.
+	Type                  string `json:"type"`
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorizationEndpoint"`
+	TokenEndpoint         string `json:"tokenEndpoint"`
+	UserInfoEndpoint      string `json:"userInfoEndpoint"`
+	ClientSecretID        string `json:"clientSecretId"`
+	UserPoolARN           string `json:"userPoolArn"`
+	UserPoolClientID      string `json:"userPoolClientId"`
+	UserPoolDomain        string `json:"userPoolDomain"`
+	Scope                 string `json:"scope"`
+	SessionTimeoutSeconds int64  `json:"sessionTimeoutSeconds"`
+	// OnUnauthenticatedRequest is authenticate, allow or deny.
+	OnUnauthenticatedRequest string `json:"onUnauthenticatedRequest"`
+}
+
+// OIDCClient are the credentials the load balancer uses with the OIDC
+// identity provider.
+type OIDCClient struct {
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// ReadOIDCClient reads the OIDC client credentials from Secrets Manager.
+func ReadOIDCClient(ctx context.Context, secretsClient *secretsmanager.Client, secretID string) (OIDCClient, error) {
+	output, err := secretsClient.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(secretID),
+	})
+	if err != nil {
+		return OIDCClient{}, fmt.Errorf("error reading secret %s: %w", secretID, err)
+	}
+
+	var client OIDCClient
+	if err := json.Unmarshal([]byte(aws.StringValue(output.SecretString)), &client); err != nil {
+		return OIDCClient{}, fmt.Errorf("error parsing secret %s: %w", secretID, err)
+	}
+	if client.ClientID == "" || client.ClientSecret == "" {
+		return OIDCClient{}, fmt.Errorf("secret %s needs clientId and clientSecret", secretID)
+	}
+	return client, nil
+}
+
+// authenticateAction builds the authentication action that runs before the
+// forward action. client is only used for OIDC.
+func authenticateAction(authConfig AuthenticationConfig, client OIDCClient) elbTypes.Action {
+	var scope *string
+	var timeout *int64
+	if authConfig.Scope != "" {
+		scope = aws.String(authConfig.Scope)
+	}
+	if authConfig.SessionTimeoutSeconds > 0 {
+		timeout = aws.Int64(authConfig.SessionTimeoutSeconds)
+	}
+
+	if authConfig.Type == AuthenticationCognito {
+		return elbTypes.Action{
+			Type:  elbTypes.ActionTypeEnumAuthenticateCognito,
+			Order: aws.Int32(1),
+			AuthenticateCognitoConfig: &elbTypes.AuthenticateCognitoActionConfig{
+				UserPoolArn:              aws.String(authConfig.UserPoolARN),
+				UserPoolClientId:         aws.String(authConfig.UserPoolClientID),
+				UserPoolDomain:           aws.String(authConfig.UserPoolDomain),
+				Scope:                    scope,
+				SessionTimeout:           timeout,
+				OnUnauthenticatedRequest: elbTypes.AuthenticateCognitoActionConditionalBehaviorEnum(authConfig.OnUnauthenticatedRequest),
+			},
+		}
+	}
+
+	return elbTypes.Action{
+		Type:  elbTypes.ActionTypeEnumAuthenticateOidc,
+		Order: aws.Int32(1),
+		AuthenticateOidcConfig: &elbTypes.AuthenticateOidcActionConfig{
+			Issuer:                   aws.String(authConfig.Issuer),
+			AuthorizationEndpoint:    aws.String(authConfig.AuthorizationEndpoint),
+			TokenEndpoint:            aws.String(authConfig.TokenEndpoint),
+			UserInfoEndpoint:         aws.String(authConfig.UserInfoEndpoint),
+			ClientId:                 aws.String(client.ClientID),
+			ClientSecret:             aws.String(client.ClientSecret),
+			Scope:                    scope,
+			SessionTimeout:           timeout,
+			OnUnauthenticatedRequest: elbTypes.AuthenticateOidcActionConditionalBehaviorEnum(authConfig.OnUnauthenticatedRequest),
+		},
+	}
+}
+
+// ListenerOIDCClient reads the OIDC client credentials when the listener
+// authenticates users with OIDC, and returns none otherwise.
+func ListenerOIDCClient(ctx context.Context, clients *Clients, listenerConfig ListenerConfig) (OIDCClient, error) {
+	auth := listenerConfig.Authentication
+	if auth == nil || auth.Type != AuthenticationOIDC {
+		return OIDCClient{}, nil
+	}
+	return ReadOIDCClient(ctx, clients.Secrets, auth.ClientSecretID)
+}
+
+// ListenerDefaultActions returns the default actions of the listener:
+// forwarding to the target groups, after authenticating the user when
+// configured.
+func ListenerDefaultActions(listenerConfig ListenerConfig, targetGroups []elbTypes.TargetGroupTuple, client OIDCClient) []elbTypes.Action {
+	forward := elbTypes.Action{
+		Type: elbTypes.ActionTypeEnumForward,
+		ForwardConfig: &elbTypes.ForwardActionConfig{
+			TargetGroups: targetGroups,
+		},
+	}
+	if listenerConfig.Authentication == nil {
+		return []elbTypes.Action{forward}
+	}
+
+	forward.Order = aws.Int32(2)
+	return []elbTypes.Action{authenticateAction(*listenerConfig.Authentication, client), forward}
+}
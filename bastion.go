@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ResourceBastion              = "bastion"
+	ResourceBastionSecurityGroup = "bastion-sg"
+	DefaultBastionInstanceType   = "t3.micro"
+	SSHPort                      = 22
+	// ElasticIPTargetBastion associates an elastic IP with the bastion.
+	ElasticIPTargetBastion = "bastion"
+	// BastionRunningTimeout bounds the wait for the bastion to run, an
+	// elastic IP can only be associated with a running instance.
+	BastionRunningTimeout = 5 * time.Minute
+)
+
+// BastionConfig is a small instance in a public subnet that operators SSH
+// through to reach the instances of the autoscaling group.
+type BastionConfig struct {
+	// SSHCIDR is the network of the operators, the only one allowed to SSH
+	// to the bastion.
+	SSHCIDR      string `json:"sshCidr"`
+	InstanceType string `json:"instanceType"`
+	// KeyName is the EC2 key pair to log in with. Without one, keys are
+	// pushed with EC2 Instance Connect, which Amazon Linux comes with.
+	KeyName string `json:"keyName"`
+	// AMIParameter is the public SSM parameter of the AMI, {arch} is replaced
+	// by the architecture of the instance type. Amazon Linux 2023 by default.
+	AMIParameter      string `json:"amiParameter"`
+	Name              string `json:"name"`
+	SecurityGroupName string `json:"securityGroupName"`
+}
+
+func (b BastionConfig) String() string {
+	return fmt.Sprintf("%s, SSH from %s", b.InstanceType, b.SSHCIDR)
+}
+
+// AMIParameterPath returns the SSM parameter the AMI of the bastion is read
+// from.
+func (b BastionConfig) AMIParameterPath() string {
+	return strings.ReplaceAll(b.AMIParameter, "{arch}", InstanceArchitecture(b.InstanceType))
+}
+
+// sshFrom is an SSH rule naming a security group. As an ingress rule it lets
+// the members of the group in, as an egress rule it lets SSH out to them.
+func sshFrom(securityGroupID, description string) types.IpPermission {
+	return types.IpPermission{
+		IpProtocol: aws.String("tcp"),
+		FromPort:   aws.Int32(SSHPort),
+		ToPort:     aws.Int32(SSHPort),
+		UserIdGroupPairs: []types.UserIdGroupPair{{
+			GroupId:     aws.String(securityGroupID),
+			Description: aws.String(description),
+		}},
+	}
+}
+
+// sshFromBastion is the rule of the instance security group letting the
+// bastion SSH to the instances.
+func sshFromBastion(bastionSecurityGroupID string) types.IpPermission {
+	return sshFrom(bastionSecurityGroupID, "SSH from the bastion")
+}
+
+// CreateBastionSecurityGroup creates the security group of the bastion, open
+// to SSH from the operators only, and lets it SSH to the instances.
+func CreateBastionSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, bastion BastionConfig, vpcID, securityGroupID string, tags map[string]string) (string, error) {
+	output, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:         aws.String(bastion.SecurityGroupName),
+		Description:       aws.String("SSH to the bastion"),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeSecurityGroup, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating bastion security group: %w", err)
+	}
+	bastionSecurityGroupID := aws.StringValue(output.GroupId)
+	logger.Printf("Bastion security group created with ID: %s", bastionSecurityGroupID)
+
+	if _, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId: aws.String(bastionSecurityGroupID),
+		IpPermissions: []types.IpPermission{{
+			IpProtocol: aws.String("tcp"),
+			FromPort:   aws.Int32(SSHPort),
+			ToPort:     aws.Int32(SSHPort),
+			IpRanges: []types.IpRange{{
+				CidrIp:      aws.String(bastion.SSHCIDR),
+				Description: aws.String("SSH from the operators"),
+			}},
+		}},
+	}); err != nil {
+		return bastionSecurityGroupID, fmt.Errorf("error allowing SSH to the bastion from %s: %w", bastion.SSHCIDR, err)
+	}
+
+	if _, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(securityGroupID),
+		IpPermissions: []types.IpPermission{sshFromBastion(bastionSecurityGroupID)},
+	}); err != nil && !hasErrorCode(err, "InvalidPermission.Duplicate") {
+		return bastionSecurityGroupID, fmt.Errorf("error allowing SSH from the bastion to the instances: %w", err)
+	}
+	logger.Printf("Bastion allowed to SSH to the instances of security group %s", securityGroupID)
+	return bastionSecurityGroupID, nil
+}
+
+// LaunchBastion runs the bastion in the subnet and waits until it is
+// running.
+func LaunchBastion(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, bastion BastionConfig, subnetID, bastionSecurityGroupID string, tags map[string]string) (string, error) {
+	instanceTags := map[string]string{"Name": bastion.Name}
+	for key, value := range tags {
+		instanceTags[key] = value
+	}
+	input := &ec2.RunInstancesInput{
+		ImageId:      aws.String("resolve:ssm:" + bastion.AMIParameterPath()),
+		InstanceType: types.InstanceType(bastion.InstanceType),
+		MinCount:     aws.Int32(1),
+		MaxCount:     aws.Int32(1),
+		NetworkInterfaces: []types.InstanceNetworkInterfaceSpecification{{
+			DeviceIndex:              aws.Int32(0),
+			SubnetId:                 aws.String(subnetID),
+			Groups:                   []string{bastionSecurityGroupID},
+			AssociatePublicIpAddress: aws.Bool(true),
+		}},
+		MetadataOptions: &types.InstanceMetadataOptionsRequest{
+			HttpEndpoint: types.InstanceMetadataEndpointStateEnabled,
+			HttpTokens:   types.HttpTokensStateRequired,
+		},
+		TagSpecifications: []types.TagSpecification{
+			ec2TagSpecifications(types.ResourceTypeInstance, instanceTags)[0],
+			ec2TagSpecifications(types.ResourceTypeVolume, instanceTags)[0],
+		},
+	}
+	if bastion.KeyName != "" {
+		input.KeyName = aws.String(bastion.KeyName)
+	}
+	output, err := ec2Client.RunInstances(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error launching bastion: %w", err)
+	}
+	instanceID := aws.StringValue(output.Instances[0].InstanceId)
+	logger.Printf("Bastion launched with ID: %s", instanceID)
+
+	if err := ec2.NewInstanceRunningWaiter(ec2Client).Wait(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{instanceID},
+	}, BastionRunningTimeout); err != nil {
+		return instanceID, fmt.Errorf("error waiting for bastion %s to run: %w", instanceID, err)
+	}
+	logger.Printf("Bastion %s is running", instanceID)
+	return instanceID, nil
+}
+
+// CreateBastion creates the security group of the bastion and launches it in
+// the first public subnet, recording both, then associates the elastic IPs
+// meant for it.
+func CreateBastion(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State, vpcID, subnetID, securityGroupID string) (string, error) {
+	bastion := *cfg.Bastion
+	tags := cfg.StackTags()
+	if state.BastionSecurityGroupID == "" {
+		bastionSecurityGroupID, err := CreateBastionSecurityGroup(ctx, logger, ec2Client, bastion, vpcID, securityGroupID, tags)
+		if bastionSecurityGroupID != "" {
+			if saveErr := state.Record(func(s *State) { s.BastionSecurityGroupID = bastionSecurityGroupID }); saveErr != nil {
+				return "", saveErr
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	instanceID, err := LaunchBastion(ctx, logger, ec2Client, bastion, subnetID, state.BastionSecurityGroupID, tags)
+	if instanceID != "" {
+		if saveErr := state.Record(func(s *State) { s.BastionInstanceID = instanceID }); saveErr != nil {
+			return instanceID, saveErr
+		}
+	}
+	if err != nil {
+		return instanceID, err
+	}
+	return instanceID, AllocateElasticIPs(ctx, logger, ec2Client, cfg.ElasticIPs, state, tags)
+}
+
+// DeleteBastion terminates the bastion, waiting for it to be gone, and
+// deletes its security group after revoking the rule that refers to it.
+func DeleteBastion(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, state *State) error {
+	if state.BastionInstanceID != "" {
+		if _, err := ec2Client.TerminateInstances(ctx, &ec2.TerminateInstancesInput{
+			InstanceIds: []string{state.BastionInstanceID},
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error terminating bastion: %w", err)
+		}
+		if err := ec2.NewInstanceTerminatedWaiter(ec2Client).Wait(ctx, &ec2.DescribeInstancesInput{
+			InstanceIds: []string{state.BastionInstanceID},
+		}, DestroyTimeout); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error waiting for bastion %s to terminate: %w", state.BastionInstanceID, err)
+		}
+		logger.Printf("Bastion %s terminated", state.BastionInstanceID)
+		if err := state.Record(func(s *State) { s.BastionInstanceID = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.BastionSecurityGroupID == "" {
+		return nil
+	}
+	if state.SecurityGroupID != "" {
+		if _, err := ec2Client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(state.SecurityGroupID),
+			IpPermissions: []types.IpPermission{sshFromBastion(state.BastionSecurityGroupID)},
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error revoking SSH from the bastion: %w", err)
+		}
+	}
+	if err := retryDependencyViolation(ctx, func() error {
+		_, err := ec2Client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(state.BastionSecurityGroupID),
+		})
+		return err
+	}); err != nil {
+		return fmt.Errorf("error deleting bastion security group: %w", err)
+	}
+	logger.Printf("Bastion security group %s deleted", state.BastionSecurityGroupID)
+	return state.Record(func(s *State) { s.BastionSecurityGroupID = "" })
+}
+
+// UpdateBastion launches the bastion when it was added to the config after
+// the stack was applied, and deletes it when it was removed.
+func UpdateBastion(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State) error {
+	if cfg.Bastion == nil {
+		return DeleteBastion(ctx, logger, ec2Client, state)
+	}
+	if state.BastionInstanceID != "" {
+		return nil
+	}
+	if len(state.SubnetIDs) == 0 {
+		return fmt.Errorf("the stack has no public subnet to launch the bastion in")
+	}
+	_, err := CreateBastion(ctx, logger, ec2Client, cfg, state, state.VPCID, state.SubnetIDs[0], state.SecurityGroupID)
+	return err
+}
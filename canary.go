@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// MaxCanaryWeight sends all traffic to the canary target group.
+	MaxCanaryWeight = 100
+)
+
+// CanaryConfig adds a canary autoscaling group with its own target group
+// next to the main one. The canary group always launches the latest launch
+// template version, or the configured version of an existing launch
+// template, and the listener splits traffic between both target
+// groups by weight, starting with none for the canary.
+type CanaryConfig struct {
+	TargetGroupName      string `json:"targetGroupName"`
+	AutoScalingGroupName string `json:"autoScalingGroupName"`
+	// Size is the number of canary instances.
+	Size int32 `json:"size"`
+}
+
+// AutoScalingConfig derives the settings of the canary group from the main
+// group: a fixed size and no scaling policy.
+func (c CanaryConfig) AutoScalingConfig(asgConfig AutoScalingConfig) AutoScalingConfig {
+	asgConfig.Name = c.AutoScalingGroupName
+	asgConfig.MinSize = c.Size
+	asgConfig.MaxSize = c.Size
+	asgConfig.DesiredCapacity = aws.Int32(c.Size)
+	return asgConfig
+}
+
+// ForwardTargetGroups returns the target groups the listener forwards to.
+// With a canary target group, canaryWeight percent of the requests go to it
+// and the rest to the main target group.
+func ForwardTargetGroups(targetGroupARN, canaryTargetGroupARN string, canaryWeight int32) []elbTypes.TargetGroupTuple {
+	if canaryTargetGroupARN == "" {
+		return []elbTypes.TargetGroupTuple{{TargetGroupArn: aws.String(targetGroupARN)}}
+	}
+	return []elbTypes.TargetGroupTuple{
+		{TargetGroupArn: aws.String(targetGroupARN), Weight: aws.Int32(MaxCanaryWeight - canaryWeight)},
+		{TargetGroupArn: aws.String(canaryTargetGroupARN), Weight: aws.Int32(canaryWeight)},
+	}
+}
+
+// CanaryWeights returns the weights to go through from the current weight to
+// the target one, step points at a time. A step of 0 goes there at once.
+func CanaryWeights(current, target, step int32) []int32 {
+	if step <= 0 || current == target {
+		return []int32{target}
+	}
+
+	var weights []int32
+	for weight := current; weight != target; {
+		if weight < target {
+			weight = min(weight+step, target)
+		} else {
+			weight = max(weight-step, target)
+		}
+		weights = append(weights, weight)
+	}
+	return weights
+}
+
+// SetCanaryWeight shifts the traffic of the listener to the canary target
+// group gradually. After each step but the last it waits interval and checks
+// the canary targets; when some are unhealthy, all traffic goes back to the
+// main target group.
+func SetCanaryWeight(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, target, step int32, interval time.Duration) error {
+	oidcClient, err := ListenerOIDCClient(ctx, clients, cfg.Listener)
+	if err != nil {
+		return err
+	}
+
+	setWeight := func(weight int32) error {
+		targetGroups := ForwardTargetGroups(state.TargetGroupARN, state.CanaryTargetGroupARN, weight)
+		if err := SetListenerDefaultActions(ctx, clients.ELB, state.ListenerARN, ListenerDefaultActions(cfg.Listener, targetGroups, oidcClient)); err != nil {
+			return err
+		}
+		for _, listenerARN := range ForwardingListenerARNs(cfg, state) {
+			if err := SetListenerDefaultActions(ctx, clients.ELB, listenerARN, ListenerDefaultActions(ListenerConfig{}, targetGroups, OIDCClient{})); err != nil {
+				return err
+			}
+		}
+		logger.Printf("Canary target group gets %d%% of the traffic", weight)
+		return state.Record(func(s *State) { s.CanaryWeight = weight })
+	}
+
+	weights := CanaryWeights(state.CanaryWeight, target, step)
+	for i, weight := range weights {
+		if err := setWeight(weight); err != nil {
+			return err
+		}
+		if i == len(weights)-1 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		unhealthy, err := countUnhealthyTargets(ctx, clients.ELB, state.CanaryTargetGroupARN)
+		if err != nil {
+			return err
+		}
+		if unhealthy > 0 {
+			if err := setWeight(0); err != nil {
+				return err
+			}
+			return fmt.Errorf("canary stopped at %d%%, %d canary targets are unhealthy", weight, unhealthy)
+		}
+	}
+
+	return nil
+}
+
+func runCanary(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	var weight, step int
+	var interval time.Duration
+	var yes bool
+	fs := flag.NewFlagSet("canary", flag.ExitOnError)
+	opts.Register(fs)
+	fs.IntVar(&weight, "weight", -1, "percentage of the traffic to send to the canary target group")
+	fs.IntVar(&step, "step", 0, "shift the traffic this many points at a time (0 shifts it at once)")
+	fs.DurationVar(&interval, "interval", 5*time.Minute, "time to watch the canary targets between steps")
+	fs.BoolVar(&yes, "yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if weight < 0 || weight > MaxCanaryWeight {
+		return fmt.Errorf("canary requires --weight between 0 and %d", MaxCanaryWeight)
+	}
+	if step < 0 {
+		return errors.New("--step must not be negative")
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+	if state.CanaryTargetGroupARN == "" || state.ListenerARN == "" {
+		return fmt.Errorf("no canary target group recorded in %s, configure canary and apply first", state.Location())
+	}
+	if state.Maintenance {
+		return errors.New("the listener serves the maintenance page, turn maintenance off first")
+	}
+	if int32(weight) == state.CanaryWeight {
+		logger.Printf("Canary target group already gets %d%% of the traffic", weight)
+		return nil
+	}
+
+	if weight > 0 {
+		healthy, err := healthyTargets(ctx, clients.ELB, state.CanaryTargetGroupARN)
+		if err != nil {
+			return err
+		}
+		if len(healthy) == 0 {
+			return errors.New("the canary target group has no healthy targets")
+		}
+	}
+
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "canary")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	if !yes {
+		ok, err := Confirm(fmt.Sprintf("Shift the canary target group from %d%% to %d%% of the traffic?", state.CanaryWeight, weight))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Canary cancelled")
+			return nil
+		}
+	}
+
+	return SetCanaryWeight(ctx, logger, clients, cfg, state, int32(weight), int32(step), interval)
+}
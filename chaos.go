@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ChaosPollInterval = 15 * time.Second
+)
+
+// PickInServiceInstance returns a random InService instance of the group.
+func PickInServiceInstance(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string) (string, error) {
+	output, err := autoscalingClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoscalingGroupName},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return "", fmt.Errorf("autoscaling group %s not found", autoscalingGroupName)
+	}
+
+	var inService []string
+	for _, instance := range output.AutoScalingGroups[0].Instances {
+		if instance.LifecycleState == autoscalingTypes.LifecycleStateInService {
+			inService = append(inService, aws.StringValue(instance.InstanceId))
+		}
+	}
+	if len(inService) == 0 {
+		return "", fmt.Errorf("autoscaling group %s has no InService instance", autoscalingGroupName)
+	}
+
+	return inService[rand.IntN(len(inService))], nil
+}
+
+// healthyTargets returns the IDs of the healthy targets of the target group.
+func healthyTargets(ctx context.Context, elbClient *elasticloadbalancingv2.Client, targetGroupARN string) ([]string, error) {
+	output, err := elbClient.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing target health: %w", err)
+	}
+
+	var healthy []string
+	for _, description := range output.TargetHealthDescriptions {
+		if description.TargetHealth != nil && description.TargetHealth.State == elbTypes.TargetHealthStateEnumHealthy {
+			healthy = append(healthy, aws.StringValue(description.Target.Id))
+		}
+	}
+	return healthy, nil
+}
+
+// ChaosTerminate terminates a random InService instance and, unless the
+// desired capacity is decremented with it, waits until the target group has
+// as many healthy targets as before, without the terminated instance.
+func ChaosTerminate(ctx context.Context, logger *log.Logger, clients *Clients, state *State, instanceID string, decrementDesired bool) error {
+	before, err := healthyTargets(ctx, clients.ELB, state.TargetGroupARN)
+	if err != nil {
+		return err
+	}
+
+	if _, err := clients.AutoScaling.TerminateInstanceInAutoScalingGroup(ctx, &autoscaling.TerminateInstanceInAutoScalingGroupInput{
+		InstanceId:                     aws.String(instanceID),
+		ShouldDecrementDesiredCapacity: aws.Bool(decrementDesired),
+	}); err != nil {
+		return fmt.Errorf("error terminating instance %s: %w", instanceID, err)
+	}
+	logger.Printf("Instance %s terminated, %d healthy targets before", instanceID, len(before))
+	if decrementDesired {
+		logger.Println("Desired capacity decremented, no replacement to wait for")
+		return nil
+	}
+
+	started := time.Now()
+	ticker := time.NewTicker(ChaosPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("targets did not recover after terminating %s: %w", instanceID, ctx.Err())
+		case <-ticker.C:
+		}
+
+		healthy, err := healthyTargets(ctx, clients.ELB, state.TargetGroupARN)
+		if err != nil {
+			return err
+		}
+		replaced := true
+		for _, id := range healthy {
+			if id == instanceID {
+				replaced = false
+			}
+		}
+		logger.Printf("%d/%d healthy targets: %s", len(healthy), len(before), strings.Join(healthy, ", "))
+		if replaced && len(healthy) >= len(before) {
+			logger.Printf("Target group recovered in %s", time.Since(started).Truncate(time.Second))
+			return nil
+		}
+	}
+}
+
+func runChaos(ctx context.Context, logger *log.Logger, args []string) error {
+	if len(args) == 0 || args[0] != "terminate" {
+		return fmt.Errorf("chaos requires an action: terminate")
+	}
+	args = args[1:]
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("chaos terminate", flag.ExitOnError)
+	opts.Register(fs)
+	decrementDesired := fs.Bool("decrement-desired", false, "decrement the desired capacity, so the instance is not replaced")
+	timeout := fs.Duration("timeout", 15*time.Minute, "how long to wait for the target group to recover")
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	instanceID, err := PickInServiceInstance(ctx, clients.AutoScaling, state.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		ok, err := Confirm(fmt.Sprintf("Terminate instance %s of %s?", instanceID, state.AutoScalingGroupName))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Aborted")
+			return nil
+		}
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, *timeout)
+	defer cancelFunc()
+
+	return ChaosTerminate(ctx, logger, clients, state, instanceID, *decrementDesired)
+}
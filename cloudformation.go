@@ -0,0 +1,1214 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+var cloudFormationTemplate = template.Must(template.New("cloudformation").Funcs(template.FuncMap{
+	"quote":  strconv.Quote,
+	"indent": indent,
+}).Parse(`AWSTemplateFormatVersion: "2010-09-09"
+Description: Autoscaling web service behind an Application Load Balancer
+{{- if .SecretsManagerSecrets }}
+Parameters:
+{{- range $i, $secret := .Config.Secrets }}
+{{- if eq $secret.Store $.SecretStoreSecretsManager }}
+  Secret{{ $i }}Value:
+    Type: String
+    NoEcho: true
+    Description: {{ quote (printf "Value of the %s secret" $secret.Name) }}
+{{- end }}
+{{- end }}
+{{- end }}
+Resources:
+  VPC:
+    Type: AWS::EC2::VPC
+    Properties:
+      CidrBlock: {{ quote .Config.VPC.CIDRBlock }}
+      EnableDnsHostnames: true
+{{- range $i, $cidrBlock := .Config.VPC.SecondaryCIDRBlocks }}
+  VPCCidrBlock{{ $i }}:
+    Type: AWS::EC2::VPCCidrBlock
+    Properties:
+      VpcId: !Ref VPC
+      CidrBlock: {{ quote $cidrBlock }}
+{{- end }}
+{{- with .Config.VPC.FlowLogs }}{{ if .Enabled }}
+{{- if .ToCloudWatchLogs }}
+  FlowLogGroup:
+    Type: AWS::Logs::LogGroup
+    Properties:
+      LogGroupName: {{ quote .LogGroupName }}
+{{- if .RetentionDays }}
+      RetentionInDays: {{ .RetentionDays }}
+{{- end }}
+  FlowLogsRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: {{ quote .RoleName }}
+      AssumeRolePolicyDocument: {{ .TrustPolicy }}
+      Policies:
+        - PolicyName: flow-logs-delivery
+          PolicyDocument: {{ .DeliveryPolicy }}
+{{- end }}
+  FlowLog:
+    Type: AWS::EC2::FlowLog
+    Properties:
+      ResourceId: !Ref VPC
+      ResourceType: VPC
+      TrafficType: {{ .TrafficType }}
+      LogDestinationType: {{ .Destination }}
+{{- if .ToCloudWatchLogs }}
+      LogGroupName: !Ref FlowLogGroup
+      DeliverLogsPermissionArn: !GetAtt FlowLogsRole.Arn
+{{- else }}
+      LogDestination: {{ quote .BucketARN }}
+{{- end }}
+{{- end }}{{ end }}
+{{- with .Config.Logs }}
+  AppLogGroup:
+{{- template "logGroup" .App }}
+  AccessLogGroup:
+{{- template "logGroup" .Access }}
+  SystemLogGroup:
+{{- template "logGroup" .System }}
+{{- end }}
+  InternetGateway:
+    Type: AWS::EC2::InternetGateway
+  InternetGatewayAttachment:
+    Type: AWS::EC2::VPCGatewayAttachment
+    Properties:
+      VpcId: !Ref VPC
+      InternetGatewayId: !Ref InternetGateway
+  RouteTable:
+    Type: AWS::EC2::RouteTable
+    Properties:
+      VpcId: !Ref VPC
+  InternetRoute:
+    Type: AWS::EC2::Route
+    DependsOn: InternetGatewayAttachment
+    Properties:
+      RouteTableId: !Ref RouteTable
+      DestinationCidrBlock: "0.0.0.0/0"
+      GatewayId: !Ref InternetGateway
+{{- range $i, $subnet := .PublicSubnets }}
+  Subnet{{ $i }}:
+    Type: AWS::EC2::Subnet
+{{- $block := $.Config.VPC.SecondaryBlockIndex $subnet.CIDRBlock }}
+{{- if ge $block 0 }}
+    DependsOn: VPCCidrBlock{{ $block }}
+{{- end }}
+    Properties:
+      VpcId: !Ref VPC
+      CidrBlock: {{ quote $subnet.CIDRBlock }}
+      AvailabilityZone: {{ template "zone" $subnet }}
+      MapPublicIpOnLaunch: true
+  Subnet{{ $i }}RouteTableAssociation:
+    Type: AWS::EC2::SubnetRouteTableAssociation
+    Properties:
+      SubnetId: !Ref Subnet{{ $i }}
+      RouteTableId: !Ref RouteTable
+{{- end }}
+{{- range $i, $subnet := .PrivateSubnets }}
+  PrivateSubnet{{ $i }}:
+    Type: AWS::EC2::Subnet
+{{- $block := $.Config.VPC.SecondaryBlockIndex $subnet.CIDRBlock }}
+{{- if ge $block 0 }}
+    DependsOn: VPCCidrBlock{{ $block }}
+{{- end }}
+    Properties:
+      VpcId: !Ref VPC
+      CidrBlock: {{ quote $subnet.CIDRBlock }}
+      AvailabilityZone: {{ template "zone" $subnet }}
+{{- end }}
+{{- if .Config.VPC.NetworkACL.Enabled }}
+  NetworkAcl:
+    Type: AWS::EC2::NetworkAcl
+    Properties:
+      VpcId: !Ref VPC
+{{- range .Config.VPC.NetworkACL.InboundEntries }}
+  NetworkAclInbound{{ .RuleNumber }}:
+    Type: AWS::EC2::NetworkAclEntry
+    Properties:
+      NetworkAclId: !Ref NetworkAcl
+{{- template "naclEntry" . }}
+{{- end }}
+{{- range .Config.VPC.NetworkACL.OutboundEntries }}
+  NetworkAclOutbound{{ .RuleNumber }}:
+    Type: AWS::EC2::NetworkAclEntry
+    Properties:
+      NetworkAclId: !Ref NetworkAcl
+      Egress: true
+{{- template "naclEntry" . }}
+{{- end }}
+{{- $subnets := .Config.VPC.NetworkACL.Subnets }}
+{{- if ne $subnets "private" }}
+{{- range $i, $subnet := .PublicSubnets }}
+  Subnet{{ $i }}NetworkAclAssociation:
+    Type: AWS::EC2::SubnetNetworkAclAssociation
+    Properties:
+      SubnetId: !Ref Subnet{{ $i }}
+      NetworkAclId: !Ref NetworkAcl
+{{- end }}
+{{- end }}
+{{- if ne $subnets "public" }}
+{{- range $i, $subnet := .PrivateSubnets }}
+  PrivateSubnet{{ $i }}NetworkAclAssociation:
+    Type: AWS::EC2::SubnetNetworkAclAssociation
+    Properties:
+      SubnetId: !Ref PrivateSubnet{{ $i }}
+      NetworkAclId: !Ref NetworkAcl
+{{- end }}
+{{- end }}
+{{- end }}
+{{- range $i, $service := .Config.VPC.GatewayEndpoints }}
+  GatewayEndpoint{{ $i }}:
+    Type: AWS::EC2::VPCEndpoint
+    Properties:
+      VpcId: !Ref VPC
+      VpcEndpointType: Gateway
+      ServiceName: !Sub "com.amazonaws.${AWS::Region}.{{ $service }}"
+      RouteTableIds:
+        - !Ref RouteTable
+{{- if $.PrivateSubnets }}
+        # The private subnets use the main route table of the VPC, which
+        # CloudFormation can't reference; add it to the endpoint by hand.
+{{- end }}
+{{- end }}
+{{- if .Config.VPC.InterfaceEndpoints }}
+  EndpointSecurityGroup:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupDescription: {{ quote .EndpointsSecurityGroupDescription }}
+      VpcId: !Ref VPC
+      SecurityGroupIngress:
+{{- range .Config.VPC.CIDRBlocks }}
+        - IpProtocol: tcp
+          FromPort: 443
+          ToPort: 443
+          CidrIp: {{ quote . }}
+          Description: "HTTPS from the VPC"
+{{- end }}
+{{- range $i, $service := .Config.VPC.InterfaceEndpoints }}
+  InterfaceEndpoint{{ $i }}:
+    Type: AWS::EC2::VPCEndpoint
+    Properties:
+      VpcId: !Ref VPC
+      VpcEndpointType: Interface
+      ServiceName: !Sub "com.amazonaws.${AWS::Region}.{{ $service }}"
+      PrivateDnsEnabled: true
+      SecurityGroupIds:
+        - !Ref EndpointSecurityGroup
+      SubnetIds:
+{{- range $.EndpointSubnets }}
+        - !Ref {{ . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- range $i, $address := .Config.ElasticIPs }}
+  ElasticIP{{ $i }}:
+    Type: AWS::EC2::EIP
+    Properties:
+      Domain: vpc
+      Tags:
+        - Key: Name
+          Value: {{ quote $address.TagName }}
+{{- with $address.AssociateWith }}
+  ElasticIP{{ $i }}Association:
+    Type: AWS::EC2::EIPAssociation
+    Properties:
+      AllocationId: !GetAtt ElasticIP{{ $i }}.AllocationId
+{{- if $address.AssociatesBastion }}
+      InstanceId: !Ref Bastion
+{{- else if $address.AssociatesNetworkInterface }}
+      NetworkInterfaceId: {{ quote . }}
+{{- else }}
+      InstanceId: {{ quote . }}
+{{- end }}
+{{- end }}
+{{- end }}
+  SecurityGroup:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupName: {{ quote .Config.SecurityGroup.Name }}
+      GroupDescription: {{ quote .Config.SecurityGroup.Description }}
+      VpcId: !Ref VPC
+{{- with .Config.SecurityGroup.IngressRules }}
+      SecurityGroupIngress:
+{{- range . }}
+{{- $rule := . }}
+{{- range .CIDRs }}
+        - {{- template "rulePorts" $rule }}
+          CidrIp: {{ quote . }}
+{{- end }}
+{{- range .PrefixListIDs }}
+        - {{- template "rulePorts" $rule }}
+          SourcePrefixListId: {{ . }}
+{{- end }}
+{{- range .PrefixListNames }}
+        # Prefix list {{ . }} can't be looked up by name here, add it with SourcePrefixListId
+{{- end }}
+{{- end }}
+{{- end }}
+      SecurityGroupEgress:
+{{- range .Config.SecurityGroup.EgressRules }}
+{{- $rule := . }}
+{{- range .CIDRs }}
+        - {{- template "rulePorts" $rule }}
+          CidrIp: {{ quote . }}
+{{- end }}
+{{- range .PrefixListIDs }}
+        - {{- template "rulePorts" $rule }}
+          DestinationPrefixListId: {{ . }}
+{{- end }}
+{{- range .PrefixListNames }}
+        # Prefix list {{ . }} can't be looked up by name here, add it with DestinationPrefixListId
+{{- end }}
+{{- else }}
+        # No egress: CloudFormation only drops the default rule for another one
+        - IpProtocol: "-1"
+          CidrIp: "127.0.0.1/32"
+{{- end }}
+{{- with .Config.Bastion }}
+  BastionSecurityGroup:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupName: {{ quote .SecurityGroupName }}
+      GroupDescription: SSH to the bastion
+      VpcId: !Ref VPC
+      SecurityGroupIngress:
+        - IpProtocol: tcp
+          FromPort: {{ $.SSHPort }}
+          ToPort: {{ $.SSHPort }}
+          CidrIp: {{ quote .SSHCIDR }}
+          Description: SSH from the operators
+  SecurityGroupSSHFromBastion:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref SecurityGroup
+      IpProtocol: tcp
+      FromPort: {{ $.SSHPort }}
+      ToPort: {{ $.SSHPort }}
+      SourceSecurityGroupId: !Ref BastionSecurityGroup
+      Description: SSH from the bastion
+  Bastion:
+    Type: AWS::EC2::Instance
+    Properties:
+      ImageId: {{ quote $.BastionImageID }}
+      InstanceType: {{ .InstanceType }}
+{{- with .KeyName }}
+      KeyName: {{ quote . }}
+{{- end }}
+      NetworkInterfaces:
+        - DeviceIndex: "0"
+          SubnetId: !Ref Subnet0
+          GroupSet:
+            - !Ref BastionSecurityGroup
+          AssociatePublicIpAddress: true
+      MetadataOptions:
+        HttpEndpoint: enabled
+        HttpTokens: required
+      Tags:
+        - Key: Name
+          Value: {{ quote .Name }}
+{{- end }}
+{{- with .Config.InstanceConnectEndpoint }}
+  InstanceConnectSecurityGroup:
+    Type: AWS::EC2::SecurityGroup
+    Properties:
+      GroupName: {{ quote .SecurityGroupName }}
+      GroupDescription: EC2 Instance Connect Endpoint
+      VpcId: !Ref VPC
+      SecurityGroupEgress:
+        - IpProtocol: tcp
+          FromPort: {{ $.SSHPort }}
+          ToPort: {{ $.SSHPort }}
+          DestinationSecurityGroupId: !Ref SecurityGroup
+          Description: SSH to the instances
+  SecurityGroupSSHFromInstanceConnect:
+    Type: AWS::EC2::SecurityGroupIngress
+    Properties:
+      GroupId: !Ref SecurityGroup
+      IpProtocol: tcp
+      FromPort: {{ $.SSHPort }}
+      ToPort: {{ $.SSHPort }}
+      SourceSecurityGroupId: !Ref InstanceConnectSecurityGroup
+      Description: SSH from the instance connect endpoint
+  InstanceConnectEndpoint:
+    Type: AWS::EC2::InstanceConnectEndpoint
+    Properties:
+      SubnetId: !Ref {{ if $.PrivateSubnets }}PrivateSubnet0{{ else }}Subnet0{{ end }}
+      SecurityGroupIds:
+        - !Ref InstanceConnectSecurityGroup
+      PreserveClientIp: false
+{{- end }}
+{{- with .Config.LifecycleEvents }}
+{{- if .CreatesTopic }}
+  LifecycleTopic:
+    Type: AWS::SNS::Topic
+    Properties:
+      TopicName: {{ quote .TopicName }}
+  LifecycleTopicPolicy:
+    Type: AWS::SNS::TopicPolicy
+    Properties:
+      Topics:
+        - !Ref LifecycleTopic
+      PolicyDocument:
+        Version: "2012-10-17"
+        Statement:
+          - Effect: Allow
+            Principal:
+              Service: {{ $.EventsServicePrincipal }}
+            Action: sns:Publish
+            Resource: !Ref LifecycleTopic
+            Condition:
+              ArnEquals:
+                aws:SourceArn: !GetAtt LifecycleEventsRule.Arn
+{{- end }}
+  LifecycleEventsRule:
+    Type: AWS::Events::Rule
+    Properties:
+      Name: {{ quote .RuleName }}
+      EventPattern: {{ $.LifecycleEventPattern }}
+      Targets:
+        - Id: {{ $.LifecycleEventsTargetID }}
+          Arn: {{ if .CreatesTopic }}!Ref LifecycleTopic{{ else if .TopicARN }}{{ quote .TopicARN }}{{ else }}{{ quote .FunctionARN }}{{ end }}
+{{- range $i, $email := .Emails }}
+  LifecycleEmailSubscription{{ $i }}:
+    Type: AWS::SNS::Subscription
+    Properties:
+      TopicArn: {{ if $.Config.LifecycleEvents.CreatesTopic }}!Ref LifecycleTopic{{ else }}{{ quote $.Config.LifecycleEvents.TopicARN }}{{ end }}
+      Protocol: email
+      Endpoint: {{ quote $email }}
+{{- end }}
+{{- with .FunctionARN }}
+  LifecycleEventsPermission:
+    Type: AWS::Lambda::Permission
+    Properties:
+      FunctionName: {{ quote . }}
+      Action: lambda:InvokeFunction
+      Principal: {{ $.EventsServicePrincipal }}
+      SourceArn: !GetAtt LifecycleEventsRule.Arn
+{{- end }}
+{{- end }}
+{{- with .Config.ECS }}
+  ECSCluster:
+    Type: AWS::ECS::Cluster
+    Properties:
+      ClusterName: {{ quote .ClusterName }}
+{{- end }}
+{{- range $i, $secret := .Config.Secrets }}
+{{- if eq $secret.Store $.SecretStoreSecretsManager }}
+  Secret{{ $i }}:
+    Type: AWS::SecretsManager::Secret
+    Properties:
+      Name: {{ quote $secret.Path }}
+      SecretString: !Ref Secret{{ $i }}Value
+{{- else }}
+  # CloudFormation can't create SecureString parameters, publish {{ $secret.Path }}
+  # with aws ssm put-parameter --type SecureString before creating the stack.
+{{- end }}
+{{- end }}
+{{- if .Config.CreatesInstanceRole }}
+  InstanceRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: {{ quote .Config.LaunchTemplate.InstanceRoleName }}
+      AssumeRolePolicyDocument: {{ .InstanceTrustPolicy }}
+{{- with .Config.InstanceRolePolicyARNs }}
+      ManagedPolicyArns:
+{{- range . }}
+        - {{ . }}
+{{- end }}
+{{- end }}
+{{- if or .Config.Secrets .Config.Logs }}
+      Policies:
+{{- end }}
+{{- if .Config.Secrets }}
+        - PolicyName: {{ .SecretsPolicyName }}
+          PolicyDocument:
+            Version: "2012-10-17"
+            Statement:
+{{- range $i, $secret := .Config.Secrets }}
+              - Effect: Allow
+{{- if eq $secret.Store $.SecretStoreSecretsManager }}
+                Action: secretsmanager:GetSecretValue
+                Resource: !Ref Secret{{ $i }}
+{{- else }}
+                Action: ssm:GetParameter
+                Resource: !Sub "arn:${AWS::Partition}:ssm:${AWS::Region}:${AWS::AccountId}:parameter{{ $secret.Path }}"
+{{- end }}
+{{- end }}
+{{- end }}
+{{- with .Config.Logs }}
+        - PolicyName: {{ $.LogsPolicyName }}
+          PolicyDocument: {{ .Policy }}
+{{- end }}
+  InstanceProfile:
+    Type: AWS::IAM::InstanceProfile
+    Properties:
+      InstanceProfileName: {{ quote .Config.LaunchTemplate.InstanceRoleName }}
+      Roles:
+        - !Ref InstanceRole
+{{- end }}
+{{- with .Config.PlacementGroup }}
+  PlacementGroup:
+    Type: AWS::EC2::PlacementGroup
+    Properties:
+      Strategy: {{ .Strategy }}
+{{- if eq .Strategy "partition" }}
+      PartitionCount: {{ .PartitionCount }}
+{{- end }}
+{{- end }}
+{{- with .Config.VolumeEncryption }}
+{{- if .CreatesKey }}
+  # The key policy names the autoscaling service-linked role, create it with
+  # aws iam create-service-linked-role --aws-service-name autoscaling.amazonaws.com
+  # if the account has no autoscaling group yet.
+  VolumeKey:
+    Type: AWS::KMS::Key
+    Properties:
+      Description: Encryption of the EBS volumes of the autoscaling group
+      PendingWindowInDays: {{ .DeletionWindowDays }}
+      KeyPolicy:
+        Version: "2012-10-17"
+        Statement:
+          - Sid: AccountAdministration
+            Effect: Allow
+            Principal:
+              AWS: !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:root"
+            Action: kms:*
+            Resource: "*"
+          - Sid: AutoScalingVolumes
+            Effect: Allow
+            Principal:
+              AWS: !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+            Action:
+              - kms:Encrypt
+              - kms:Decrypt
+              - kms:ReEncrypt*
+              - kms:GenerateDataKey*
+              - kms:DescribeKey
+            Resource: "*"
+          - Sid: AutoScalingGrants
+            Effect: Allow
+            Principal:
+              AWS: !Sub "arn:${AWS::Partition}:iam::${AWS::AccountId}:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+            Action: kms:CreateGrant
+            Resource: "*"
+            Condition:
+              Bool:
+                kms:GrantIsForAWSResource: true
+  VolumeKeyAlias:
+    Type: AWS::KMS::Alias
+    Properties:
+      AliasName: {{ quote .Alias }}
+      TargetKeyId: !Ref VolumeKey
+{{- else }}
+  # CloudFormation can't create grants, grant the autoscaling service-linked
+  # role the use of {{ .KeyARN }} with aws kms create-grant
+  # before creating the stack.
+{{- end }}
+{{- end }}
+  LaunchTemplate:
+    Type: AWS::EC2::LaunchTemplate
+    Properties:
+      LaunchTemplateName: {{ quote .Config.LaunchTemplate.Name }}
+      LaunchTemplateData:
+        ImageId: {{ quote .ImageID }}
+        InstanceType: {{ quote .Config.LaunchTemplate.InstanceType }}
+        SecurityGroupIds:
+          - !GetAtt SecurityGroup.GroupId
+        MetadataOptions:
+          HttpEndpoint: enabled
+          HttpTokens: {{ .Config.LaunchTemplate.Metadata.HTTPTokens }}
+          HttpPutResponseHopLimit: {{ .Config.LaunchTemplate.Metadata.HopLimit }}
+          InstanceMetadataTags: {{ .Config.LaunchTemplate.Metadata.InstanceMetadataTags }}
+        Monitoring:
+          Enabled: {{ .Config.LaunchTemplate.DetailedMonitoring }}
+{{- with .Config.LaunchTemplate.CPUCredits }}
+        CreditSpecification:
+          CpuCredits: {{ . }}
+{{- end }}
+{{- if .Config.LaunchTemplate.Hibernation }}
+        HibernationOptions:
+          Configured: true
+{{- end }}
+{{- if or .Config.PlacementGroup (ne .Config.LaunchTemplate.Tenancy "default") }}
+        Placement:
+{{- if .Config.PlacementGroup }}
+          GroupName: !Ref PlacementGroup
+{{- end }}
+{{- if ne .Config.LaunchTemplate.Tenancy "default" }}
+          Tenancy: {{ .Config.LaunchTemplate.Tenancy }}
+{{- end }}
+{{- with .Config.LaunchTemplate.HostResourceGroupARN }}
+          HostResourceGroupArn: {{ quote . }}
+{{- end }}
+{{- end }}
+{{- if .Config.CreatesInstanceRole }}
+        IamInstanceProfile:
+          Name: !Ref InstanceProfile
+{{- else if .Config.LaunchTemplate.IAMInstanceProfile }}
+        IamInstanceProfile:
+          Name: {{ quote .Config.LaunchTemplate.IAMInstanceProfile }}
+{{- end }}
+{{- if or .Config.LaunchTemplate.RootVolume .Config.LaunchTemplate.DataVolumes }}
+        BlockDeviceMappings:
+{{- end }}
+{{- with .Config.LaunchTemplate.RootVolume }}
+          - DeviceName: {{ quote .Device }}
+            Ebs:
+              DeleteOnTermination: true
+              Encrypted: {{ .Encrypted }}
+{{- template "volumeKey" $.Config.VolumeEncryption }}
+{{- if .VolumeType }}
+              VolumeType: {{ quote .VolumeType }}
+{{- end }}
+{{- if .SizeGiB }}
+              VolumeSize: {{ .SizeGiB }}
+{{- end }}
+{{- if .IOPS }}
+              Iops: {{ .IOPS }}
+{{- end }}
+{{- if .Throughput }}
+              Throughput: {{ .Throughput }}
+{{- end }}
+{{- end }}
+{{- range .Config.LaunchTemplate.DataVolumes }}
+          - DeviceName: {{ quote .DeviceName }}
+            Ebs:
+              DeleteOnTermination: {{ .DeletesOnTermination }}
+              Encrypted: {{ .Encrypted }}
+{{- template "volumeKey" $.Config.VolumeEncryption }}
+              VolumeSize: {{ .SizeGiB }}
+{{- if .VolumeType }}
+              VolumeType: {{ quote .VolumeType }}
+{{- end }}
+{{- if .IOPS }}
+              Iops: {{ .IOPS }}
+{{- end }}
+{{- if .Throughput }}
+              Throughput: {{ .Throughput }}
+{{- end }}
+{{- end }}
+        UserData:
+          Fn::Base64: |
+{{ indent 12 .UserData }}
+  TargetGroup:
+    Type: AWS::ElasticLoadBalancingV2::TargetGroup
+    Properties:
+      Name: {{ quote .Config.TargetGroup.Name }}
+      Protocol: HTTP
+      Port: {{ .Config.TargetGroup.Port }}
+      VpcId: !Ref VPC
+      TargetType: {{ .Config.TargetGroup.TargetType }}
+{{- with .Config.TargetGroup.IPTargets }}
+      Targets:
+{{- range . }}
+        - Id: {{ quote .IP }}
+{{- with .Port }}
+          Port: {{ . }}
+{{- end }}
+{{- if .OutsideVPC $.Config.VPC.CIDRBlocks }}
+          AvailabilityZone: all
+{{- end }}
+{{- end }}
+{{- end }}
+      ProtocolVersion: {{ .Config.TargetGroup.ProtocolVersion }}
+      HealthCheckPath: {{ quote .Config.TargetGroup.HealthCheck.Path }}
+      HealthCheckIntervalSeconds: {{ .Config.TargetGroup.HealthCheck.IntervalSeconds }}
+      HealthCheckTimeoutSeconds: {{ .Config.TargetGroup.HealthCheck.TimeoutSeconds }}
+      HealthyThresholdCount: {{ .Config.TargetGroup.HealthCheck.HealthyThreshold }}
+      UnhealthyThresholdCount: {{ .Config.TargetGroup.HealthCheck.UnhealthyThreshold }}
+      Matcher:
+{{- if .Config.TargetGroup.UsesGRPC }}
+        GrpcCode: {{ quote .Config.TargetGroup.HealthCheck.Matcher }}
+{{- else }}
+        HttpCode: {{ quote .Config.TargetGroup.HealthCheck.Matcher }}
+{{- end }}
+      TargetGroupAttributes:
+{{- range .Config.TargetGroup.Attributes }}
+        - Key: {{ .Key }}
+          Value: {{ quote .Value }}
+{{- end }}
+  AutoScalingGroup:
+    Type: AWS::AutoScaling::AutoScalingGroup
+    Properties:
+      AutoScalingGroupName: {{ quote .Config.AutoScaling.Name }}
+{{- template "groupLaunchTemplate" $.Config.AutoScaling.InstanceRequirements }}
+      MinSize: "{{ .Config.AutoScaling.MinSize }}"
+      MaxSize: "{{ .Config.AutoScaling.MaxSize }}"
+{{- with .Config.AutoScaling.DesiredCapacity }}
+      DesiredCapacity: "{{ . }}"
+{{- end }}
+      Cooldown: "{{ .Config.AutoScaling.DefaultCooldown }}"
+      HealthCheckType: {{ .Config.AutoScaling.HealthCheckType }}
+      HealthCheckGracePeriod: {{ .Config.AutoScaling.HealthCheckGracePeriod }}
+{{- with .Config.AutoScaling.MaxInstanceLifetime }}
+      MaxInstanceLifetime: {{ . }}
+{{- end }}
+{{- if .Config.AutoScaling.NewInstancesProtectedFromScaleIn }}
+      NewInstancesProtectedFromScaleIn: true
+{{- end }}
+      TerminationPolicies:
+{{- range .Config.AutoScaling.TerminationPolicies }}
+        - {{ quote . }}
+{{- end }}
+{{- with .Config.AutoScaling.GroupMetrics }}
+      MetricsCollection:
+        - Granularity: 1Minute
+          Metrics:
+{{- range . }}
+            - {{ . }}
+{{- end }}
+{{- end }}
+{{- if .Config.AttachesTargetGroup }}
+      TargetGroupARNs:
+        - !Ref TargetGroup
+{{- end }}
+      VPCZoneIdentifier:
+{{- range $i, $subnet := .GroupSubnets }}
+        - !Ref Subnet{{ $i }}
+{{- end }}
+{{- template "groupTags" .Config.StackTags }}
+{{- with .Config.Canary }}
+  CanaryTargetGroup:
+    Type: AWS::ElasticLoadBalancingV2::TargetGroup
+    Properties:
+      Name: {{ quote .TargetGroupName }}
+      Protocol: HTTP
+      Port: {{ $.Config.TargetGroup.Port }}
+      VpcId: !Ref VPC
+      TargetType: instance
+      ProtocolVersion: {{ $.Config.TargetGroup.ProtocolVersion }}
+      HealthCheckPath: {{ quote $.Config.TargetGroup.HealthCheck.Path }}
+      HealthCheckIntervalSeconds: {{ $.Config.TargetGroup.HealthCheck.IntervalSeconds }}
+      HealthCheckTimeoutSeconds: {{ $.Config.TargetGroup.HealthCheck.TimeoutSeconds }}
+      HealthyThresholdCount: {{ $.Config.TargetGroup.HealthCheck.HealthyThreshold }}
+      UnhealthyThresholdCount: {{ $.Config.TargetGroup.HealthCheck.UnhealthyThreshold }}
+      Matcher:
+{{- if $.Config.TargetGroup.UsesGRPC }}
+        GrpcCode: {{ quote $.Config.TargetGroup.HealthCheck.Matcher }}
+{{- else }}
+        HttpCode: {{ quote $.Config.TargetGroup.HealthCheck.Matcher }}
+{{- end }}
+      TargetGroupAttributes:
+{{- range $.Config.TargetGroup.Attributes }}
+        - Key: {{ .Key }}
+          Value: {{ quote .Value }}
+{{- end }}
+  CanaryAutoScalingGroup:
+    Type: AWS::AutoScaling::AutoScalingGroup
+    Properties:
+      AutoScalingGroupName: {{ quote .AutoScalingGroupName }}
+{{- template "groupLaunchTemplate" $.Config.AutoScaling.InstanceRequirements }}
+      MinSize: "{{ .Size }}"
+      MaxSize: "{{ .Size }}"
+      DesiredCapacity: "{{ .Size }}"
+      HealthCheckType: {{ $.Config.AutoScaling.HealthCheckType }}
+      HealthCheckGracePeriod: {{ $.Config.AutoScaling.HealthCheckGracePeriod }}
+      TargetGroupARNs:
+        - !Ref CanaryTargetGroup
+      VPCZoneIdentifier:
+{{- range $i, $subnet := $.GroupSubnets }}
+        - !Ref Subnet{{ $i }}
+{{- end }}
+{{- template "groupTags" $.Config.StackTags }}
+{{- end }}
+{{- with .Config.ECS }}
+  CapacityProvider:
+    Type: AWS::ECS::CapacityProvider
+    Properties:
+      Name: {{ quote .CapacityProviderName }}
+      AutoScalingGroupProvider:
+        AutoScalingGroupArn: !Ref AutoScalingGroup
+        ManagedScaling:
+          Status: ENABLED
+          TargetCapacity: {{ .TargetCapacity }}
+        ManagedTerminationProtection: {{ .ManagedTerminationProtectionStatus }}
+  ClusterCapacityProviders:
+    Type: AWS::ECS::ClusterCapacityProviderAssociations
+    Properties:
+      Cluster: !Ref ECSCluster
+      CapacityProviders:
+        - !Ref CapacityProvider
+      DefaultCapacityProviderStrategy:
+        - CapacityProvider: !Ref CapacityProvider
+          Weight: 1
+{{- else }}
+  ScalingPolicy:
+    Type: AWS::AutoScaling::ScalingPolicy
+    Properties:
+      AutoScalingGroupName: !Ref AutoScalingGroup
+      PolicyType: {{ .PolicyType }}
+      TargetTrackingConfiguration:
+{{- with .Config.AutoScaling.CustomMetric }}
+        CustomizedMetricSpecification:
+          Namespace: {{ .Namespace }}
+          MetricName: {{ .MetricName }}
+{{- with .Dimensions }}
+          Dimensions:
+{{- range $name, $value := . }}
+            - Name: {{ $name }}
+              Value: {{ quote $value }}
+{{- end }}
+{{- end }}
+          Statistic: {{ .Statistic }}
+{{- with .Unit }}
+          Unit: {{ . }}
+{{- end }}
+{{- else }}
+{{- with .MetricQueries }}
+        CustomizedMetricSpecification:
+          Metrics:
+{{- range . }}
+            - Id: {{ .ID }}
+{{- if .Expression }}
+              Expression: {{ quote .Expression }}
+{{- else }}
+              MetricStat:
+                Metric:
+                  Namespace: {{ .Namespace }}
+                  MetricName: {{ .MetricName }}
+                  Dimensions:
+{{- range $name, $value := .Dimensions }}
+                    - Name: {{ $name }}
+                      Value: {{ quote $value }}
+{{- end }}
+                Stat: {{ .Stat }}
+{{- end }}
+              ReturnData: {{ .ReturnData }}
+{{- end }}
+{{- else }}
+        PredefinedMetricSpecification:
+          PredefinedMetricType: ASGAverageCPUUtilization
+{{- end }}
+{{- end }}
+        TargetValue: {{ .Config.AutoScaling.TargetValue }}
+{{- end }}
+{{- with .Config.CodeDeploy }}
+  CodeDeployRole:
+    Type: AWS::IAM::Role
+    Properties:
+      RoleName: {{ quote .ServiceRoleName }}
+      AssumeRolePolicyDocument: {{ .TrustPolicy }}
+      ManagedPolicyArns:
+        - {{ $.CodeDeployRolePolicyARN }}
+      Policies:
+        - PolicyName: {{ $.CodeDeployLaunchTemplatePolicyName }}
+          PolicyDocument: {{ .LaunchTemplatePolicy }}
+  CodeDeployApplication:
+    Type: AWS::CodeDeploy::Application
+    Properties:
+      ApplicationName: {{ quote .ApplicationName }}
+      ComputePlatform: Server
+  DeploymentGroup:
+    Type: AWS::CodeDeploy::DeploymentGroup
+    Properties:
+      ApplicationName: !Ref CodeDeployApplication
+      DeploymentGroupName: {{ quote .DeploymentGroupName }}
+      ServiceRoleArn: !GetAtt CodeDeployRole.Arn
+      DeploymentConfigName: {{ quote .DeploymentConfigName }}
+      AutoScalingGroups:
+        - !Ref AutoScalingGroup
+      LoadBalancerInfo:
+        TargetGroupInfoList:
+          - Name: !GetAtt TargetGroup.TargetGroupName
+      DeploymentStyle:
+{{- if .BlueGreen }}
+        # CloudFormation only creates blue/green deployment groups for
+        # Lambda, switch the group to BLUE_GREEN after creating the stack.
+{{- end }}
+        DeploymentType: IN_PLACE
+        DeploymentOption: WITH_TRAFFIC_CONTROL
+{{- end }}
+  LoadBalancer:
+    Type: AWS::ElasticLoadBalancingV2::LoadBalancer
+    Properties:
+      Name: {{ quote .Config.LoadBalancer.Name }}
+      Scheme: {{ .Config.LoadBalancer.Scheme }}
+      Type: application
+      IpAddressType: ipv4
+      SecurityGroups:
+        - !GetAtt SecurityGroup.GroupId
+      Subnets:
+{{- if .Config.LoadBalancer.Internal }}
+{{- range $i, $subnet := .PrivateSubnets }}
+        - !Ref PrivateSubnet{{ $i }}
+{{- end }}
+{{- else }}
+{{- range $i, $subnet := .PublicSubnets }}
+        - !Ref Subnet{{ $i }}
+{{- end }}
+{{- end }}
+{{- with .Config.Listener.MutualTLS }}
+  TrustStore:
+    Type: AWS::ElasticLoadBalancingV2::TrustStore
+    Properties:
+      Name: {{ quote .TrustStoreName }}
+      CaCertificatesBundleS3Bucket: {{ quote .CABundleBucket }}
+      CaCertificatesBundleS3Key: {{ quote .CABundleKey }}
+{{- end }}
+  Listener:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      LoadBalancerArn: !Ref LoadBalancer
+      Protocol: {{ .Config.Listener.Protocol }}
+      Port: {{ .Config.Listener.Port }}
+{{- with .Config.Listener.CertificateARN }}
+      Certificates:
+        - CertificateArn: {{ quote . }}
+      SslPolicy: {{ $.Config.Listener.SSLPolicy }}
+{{- end }}
+{{- with .Config.Listener.MutualTLS }}
+      MutualAuthentication:
+        Mode: verify
+        TrustStoreArn: !Ref TrustStore
+        IgnoreClientCertificateExpiry: {{ .IgnoreClientCertificateExpiry }}
+{{- end }}
+      DefaultActions:
+{{- with .Config.Listener.Authentication }}
+{{- template "authenticateAction" . }}
+{{- end }}
+        - Type: forward
+{{- if .Config.Listener.Authentication }}
+          Order: 2
+{{- end }}
+{{- template "forwardTargetGroups" . }}
+{{- with .Config.Listener.AdditionalCertificateARNs }}
+  ListenerCertificates:
+    Type: AWS::ElasticLoadBalancingV2::ListenerCertificate
+    Properties:
+      ListenerArn: !Ref Listener
+      Certificates:
+{{- range . }}
+        - CertificateArn: {{ quote . }}
+{{- end }}
+{{- end }}
+{{- range $i, $listener := .Config.AdditionalListeners }}
+  AdditionalListener{{ $i }}:
+    Type: AWS::ElasticLoadBalancingV2::Listener
+    Properties:
+      LoadBalancerArn: !Ref LoadBalancer
+      Protocol: {{ .Protocol }}
+      Port: {{ .Port }}
+{{- with .CertificateARN }}
+      Certificates:
+        - CertificateArn: {{ quote . }}
+      SslPolicy: {{ $listener.SSLPolicy }}
+{{- end }}
+      DefaultActions:
+{{- with .Redirect }}
+        - Type: redirect
+          RedirectConfig:
+            Protocol: {{ .Protocol }}
+            Port: {{ quote (print .Port) }}
+            StatusCode: {{ .StatusCodeName }}
+{{- else }}
+        - Type: forward
+{{- template "forwardTargetGroups" $ }}
+{{- end }}
+{{- with .AdditionalCertificateARNs }}
+  AdditionalListenerCertificates{{ $i }}:
+    Type: AWS::ElasticLoadBalancingV2::ListenerCertificate
+    Properties:
+      ListenerArn: !Ref AdditionalListener{{ $i }}
+      Certificates:
+{{- range . }}
+        - CertificateArn: {{ quote . }}
+{{- end }}
+{{- end }}
+{{- end }}
+{{- range $i, $lambda := .Config.LambdaTargets }}
+  LambdaPermission{{ $i }}:
+    Type: AWS::Lambda::Permission
+    Properties:
+      FunctionName: {{ quote $lambda.FunctionARN }}
+      Action: lambda:InvokeFunction
+      Principal: elasticloadbalancing.amazonaws.com
+  LambdaTargetGroup{{ $i }}:
+    Type: AWS::ElasticLoadBalancingV2::TargetGroup
+    DependsOn: LambdaPermission{{ $i }}
+    Properties:
+      Name: {{ quote $lambda.Name }}
+      TargetType: lambda
+{{- if $lambda.MultiValueHeaders }}
+      TargetGroupAttributes:
+        - Key: lambda.multi_value_headers.enabled
+          Value: "true"
+{{- end }}
+      Targets:
+        - Id: {{ quote $lambda.FunctionARN }}
+  LambdaRule{{ $i }}:
+    Type: AWS::ElasticLoadBalancingV2::ListenerRule
+    Properties:
+      ListenerArn: !Ref Listener
+      Priority: {{ $lambda.Priority }}
+      Conditions:
+        - Field: path-pattern
+          PathPatternConfig:
+            Values:
+{{- range $lambda.PathPatterns }}
+              - {{ quote . }}
+{{- end }}
+      Actions:
+{{- with $.Config.Listener.Authentication }}
+{{- template "authenticateAction" . }}
+{{- end }}
+        - Type: forward
+{{- if $.Config.Listener.Authentication }}
+          Order: 2
+{{- end }}
+          TargetGroupArn: !Ref LambdaTargetGroup{{ $i }}
+{{- end }}
+{{- range $i, $rule := .Config.HostRules }}
+  HostRule{{ $i }}:
+    Type: AWS::ElasticLoadBalancingV2::ListenerRule
+    Properties:
+      ListenerArn: !Ref Listener
+      Priority: {{ $rule.Priority }}
+      Conditions:
+        - Field: host-header
+          HostHeaderConfig:
+            Values:
+{{- range $rule.HostHeaders }}
+              - {{ quote . }}
+{{- end }}
+{{- with $rule.PathPatterns }}
+        - Field: path-pattern
+          PathPatternConfig:
+            Values:
+{{- range . }}
+              - {{ quote . }}
+{{- end }}
+{{- end }}
+      Actions:
+{{- with $.Config.Listener.Authentication }}
+{{- template "authenticateAction" . }}
+{{- end }}
+        - Type: forward
+{{- if $.Config.Listener.Authentication }}
+          Order: 2
+{{- end }}
+          TargetGroupArn: {{ quote $rule.TargetGroupARN }}
+{{- end }}
+Outputs:
+  LoadBalancerDNSName:
+    Value: !GetAtt LoadBalancer.DNSName
+{{- range $i, $address := .Config.ElasticIPs }}
+  ElasticIP{{ $i }}PublicIp:
+    Description: {{ quote $address.Name }}
+    Value: !GetAtt ElasticIP{{ $i }}.PublicIp
+{{- end }}
+{{- if .Config.Bastion }}
+  BastionPublicIp:
+    Value: !GetAtt Bastion.PublicIp
+{{- end }}
+{{- with .Config.LifecycleEvents }}{{ if .CreatesTopic }}
+  LifecycleTopicArn:
+    Value: !Ref LifecycleTopic
+{{- end }}{{ end }}
+{{- define "naclEntry" }}
+      RuleNumber: {{ .RuleNumber }}
+      Protocol: {{ .ProtocolNumber }}
+      RuleAction: {{ .Action }}
+      CidrBlock: {{ quote .CIDR }}
+{{- if .HasPorts }}
+      PortRange:
+        From: {{ .FromPort }}
+        To: {{ .ToPort }}
+{{- else if eq .Protocol "icmp" }}
+      Icmp:
+        Type: -1
+        Code: -1
+{{- end }}
+{{- end }}
+{{- define "rulePorts" }} IpProtocol: {{ quote .Protocol }}
+{{- if ne .Protocol "-1" }}
+          FromPort: {{ .FromPort }}
+          ToPort: {{ .ToPort }}
+{{- end }}
+{{- with .Description }}
+          Description: {{ quote . }}
+{{- end }}
+{{- end }}
+{{- define "authenticateAction" }}
+{{- if eq .Type "cognito" }}
+        - Type: authenticate-cognito
+          Order: 1
+          AuthenticateCognitoConfig:
+            UserPoolArn: {{ quote .UserPoolARN }}
+            UserPoolClientId: {{ quote .UserPoolClientID }}
+            UserPoolDomain: {{ quote .UserPoolDomain }}
+{{- else }}
+        - Type: authenticate-oidc
+          Order: 1
+          AuthenticateOidcConfig:
+            Issuer: {{ quote .Issuer }}
+            AuthorizationEndpoint: {{ quote .AuthorizationEndpoint }}
+            TokenEndpoint: {{ quote .TokenEndpoint }}
+            UserInfoEndpoint: {{ quote .UserInfoEndpoint }}
+            ClientId: {{ quote (printf "{{resolve:secretsmanager:%s:SecretString:clientId}}" .ClientSecretID) }}
+            ClientSecret: {{ quote (printf "{{resolve:secretsmanager:%s:SecretString:clientSecret}}" .ClientSecretID) }}
+{{- end }}
+{{- with .Scope }}
+            Scope: {{ quote . }}
+{{- end }}
+{{- with .SessionTimeoutSeconds }}
+            SessionTimeout: {{ . }}
+{{- end }}
+            OnUnauthenticatedRequest: {{ .OnUnauthenticatedRequest }}
+{{- end }}
+{{- define "forwardTargetGroups" }}
+{{- if .Config.Canary }}
+          ForwardConfig:
+            TargetGroups:
+              - TargetGroupArn: !Ref TargetGroup
+                Weight: 100
+              - TargetGroupArn: !Ref CanaryTargetGroup
+                Weight: 0
+{{- else }}
+          TargetGroupArn: !Ref TargetGroup
+{{- end }}
+{{- end }}
+{{- define "groupLaunchTemplate" }}
+{{- if . }}
+      MixedInstancesPolicy:
+        LaunchTemplate:
+          LaunchTemplateSpecification:
+            LaunchTemplateId: !Ref LaunchTemplate
+            Version: !GetAtt LaunchTemplate.LatestVersionNumber
+          Overrides:
+            - InstanceRequirements:
+                VCpuCount:
+                  Min: {{ .VCPUs.Min }}
+{{- with .VCPUs.Max }}
+                  Max: {{ . }}
+{{- end }}
+                MemoryMiB:
+                  Min: {{ .MemoryMiB.Min }}
+{{- with .MemoryMiB.Max }}
+                  Max: {{ . }}
+{{- end }}
+                CpuManufacturers:
+{{- range .CPUManufacturers }}
+                  - {{ . }}
+{{- end }}
+{{- with .ExcludedInstanceTypes }}
+                ExcludedInstanceTypes:
+{{- range . }}
+                  - {{ quote . }}
+{{- end }}
+{{- end }}
+{{- with .BurstablePerformance }}
+                BurstablePerformance: {{ . }}
+{{- end }}
+{{- else }}
+      LaunchTemplate:
+        LaunchTemplateId: !Ref LaunchTemplate
+        Version: !GetAtt LaunchTemplate.LatestVersionNumber
+{{- end }}
+{{- end }}
+{{- define "groupTags" }}
+      Tags:
+{{- range $key, $value := . }}
+        - Key: {{ $key }}
+          Value: {{ quote $value }}
+          PropagateAtLaunch: true
+{{- end }}
+{{- end }}
+{{- define "volumeKey" }}
+{{- with . }}
+              KmsKeyId: {{ if .CreatesKey }}!GetAtt VolumeKey.Arn{{ else }}{{ quote .KeyARN }}{{ end }}
+{{- end }}
+{{- end }}
+{{- define "logGroup" }}
+    Type: AWS::Logs::LogGroup
+    Properties:
+      LogGroupName: {{ quote .Name }}
+{{- with .Retention }}
+      RetentionInDays: {{ . }}
+{{- end }}
+{{- end }}
+{{- define "zone" }}{{ if .AvailabilityZone }}{{ quote .AvailabilityZone }}{{ else }}!Select [{{ .ZoneIndex }}, !GetAZs ""]{{ end }}{{ end }}
+`))
+
+// RenderCloudFormation renders the configured topology as a CloudFormation
+// YAML template.
+func RenderCloudFormation(cfg *Config) ([]byte, error) {
+	userData, err := UserData(cfg.LaunchTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets, err := SubnetLayout(cfg.VPC, nil)
+	if err != nil {
+		return nil, err
+	}
+	publicSubnets, privateSubnets := SplitSubnets(subnets)
+
+	var buf bytes.Buffer
+	if err := cloudFormationTemplate.Execute(&buf, map[string]any{
+		"Config":                             cfg,
+		"PublicSubnets":                      publicSubnets,
+		"PrivateSubnets":                     privateSubnets,
+		"GroupSubnets":                       GroupSubnets(cfg, publicSubnets),
+		"ImageID":                            cloudFormationImageID(cfg.LaunchTemplate),
+		"BastionImageID":                     cloudFormationBastionImageID(cfg.Bastion),
+		"SSHPort":                            SSHPort,
+		"LifecycleEventPattern":              LifecycleEventPattern(LifecycleEventGroups(cfg)),
+		"LifecycleEventsTargetID":            LifecycleEventsTargetID,
+		"EventsServicePrincipal":             EventsServicePrincipal,
+		"UserData":                           string(userData),
+		"PolicyType":                         AWSAutoscalingPolicyType,
+		"MetricQueries":                      cfg.AutoScaling.MetricQueries(cfg.AutoScaling.Name),
+		"EndpointSubnets":                    endpointSubnetRefs(publicSubnets, privateSubnets, "Subnet%d", "PrivateSubnet%d"),
+		"EndpointsSecurityGroupDescription":  EndpointsSecurityGroupDescription,
+		"InstanceTrustPolicy":                InstanceTrustPolicy(),
+		"CodeDeployRolePolicyARN":            CodeDeployRolePolicyARN,
+		"CodeDeployLaunchTemplatePolicyName": CodeDeployLaunchTemplatePolicyName,
+		"SecretsPolicyName":                  SecretsPolicyName,
+		"LogsPolicyName":                     LogsPolicyName,
+		"SecretStoreSecretsManager":          SecretStoreSecretsManager,
+		"SecretsManagerSecrets":              slices.ContainsFunc(cfg.Secrets, func(s SecretConfig) bool { return s.Store == SecretStoreSecretsManager }),
+	}); err != nil {
+		return nil, fmt.Errorf("error rendering CloudFormation template: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// cloudFormationImageID returns the AMI ID, or a dynamic reference to the
+// SSM parameter resolved by CloudFormation when the AMI is not pinned.
+func cloudFormationImageID(ltConfig LaunchTemplateConfig) string {
+	if ltConfig.AMIID != "" {
+		return ltConfig.AMIID
+	}
+	return "{{resolve:ssm:" + ltConfig.AMIParameterPath() + "}}"
+}
+
+// cloudFormationBastionImageID returns the dynamic reference to the SSM
+// parameter of the bastion AMI, empty without a bastion.
+func cloudFormationBastionImageID(bastion *BastionConfig) string {
+	if bastion == nil {
+		return ""
+	}
+	return "{{resolve:ssm:" + bastion.AMIParameterPath() + "}}"
+}
+
+func indent(spaces int, text string) string {
+	prefix := strings.Repeat(" ", spaces)
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	codedeployTypes "github.com/aws/aws-sdk-go-v2/service/codedeploy/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	DeploymentTypeBlueGreen = "BLUE_GREEN"
+	DeploymentTypeInPlace   = "IN_PLACE"
+
+	DefaultDeploymentConfigName = "CodeDeployDefault.OneAtATime"
+	// CodeDeployRolePolicyARN lets CodeDeploy manage the instances, the
+	// autoscaling group and the target group.
+	CodeDeployRolePolicyARN = "arn:aws:iam::aws:policy/service-role/AWSCodeDeployRole"
+	// CodeDeployLaunchTemplatePolicyName is the inline policy CodeDeploy
+	// needs on top to copy an autoscaling group using a launch template.
+	CodeDeployLaunchTemplatePolicyName = "launch-template-copy"
+	DefaultTerminationWaitMinutes      = 5
+	MaxTerminationWaitMinutes          = 2880
+)
+
+// DeploymentTypes are the deployment types of the deployment group.
+var DeploymentTypes = []string{DeploymentTypeBlueGreen, DeploymentTypeInPlace}
+
+// CodeDeployConfig creates a CodeDeploy application and a deployment group
+// for the autoscaling group behind the target group. Blue/green deployments
+// copy the autoscaling group, deploy to the copy and shift the target group
+// over to it; in-place deployments take the instances out of the target
+// group one after another while they are updated.
+type CodeDeployConfig struct {
+	ApplicationName     string `json:"applicationName"`
+	DeploymentGroupName string `json:"deploymentGroupName"`
+	ServiceRoleName     string `json:"serviceRoleName"`
+	// DeploymentType is BLUE_GREEN or IN_PLACE.
+	DeploymentType       string `json:"deploymentType"`
+	DeploymentConfigName string `json:"deploymentConfigName"`
+	// TerminationWaitMinutes is how long the old instances of a blue/green
+	// deployment keep running after the traffic moved, for a quick
+	// rollback.
+	TerminationWaitMinutes int32 `json:"terminationWaitMinutes"`
+}
+
+// BlueGreen reports whether deployments replace the autoscaling group.
+func (c CodeDeployConfig) BlueGreen() bool {
+	return c.DeploymentType == DeploymentTypeBlueGreen
+}
+
+// TrustPolicy lets CodeDeploy assume the service role.
+func (c CodeDeployConfig) TrustPolicy() string {
+	return policyJSON(map[string]any{
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": "codedeploy.amazonaws.com"},
+		"Action":    "sts:AssumeRole",
+	})
+}
+
+// LaunchTemplatePolicy allows launching the instances of a copied
+// autoscaling group from the launch template and its instance profile.
+func (c CodeDeployConfig) LaunchTemplatePolicy() string {
+	return policyJSON(map[string]any{
+		"Effect":   "Allow",
+		"Action":   []string{"ec2:RunInstances", "ec2:CreateTags", "iam:PassRole"},
+		"Resource": "*",
+	})
+}
+
+// CreateCodeDeployRole creates the service role CodeDeploy deploys with and
+// returns its ARN.
+func CreateCodeDeployRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client, cdConfig CodeDeployConfig, tags map[string]string) (string, error) {
+	output, err := iamClient.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 aws.String(cdConfig.ServiceRoleName),
+		AssumeRolePolicyDocument: aws.String(cdConfig.TrustPolicy()),
+		Description:              aws.String("Deploys application revisions with CodeDeploy"),
+		Tags:                     iamTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating CodeDeploy role: %w", err)
+	}
+	roleARN := aws.StringValue(output.Role.Arn)
+	logger.Printf("IAM role %s created", cdConfig.ServiceRoleName)
+
+	if _, err := iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(cdConfig.ServiceRoleName),
+		PolicyArn: aws.String(CodeDeployRolePolicyARN),
+	}); err != nil {
+		return roleARN, fmt.Errorf("error attaching policy to CodeDeploy role: %w", err)
+	}
+	if _, err := iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(cdConfig.ServiceRoleName),
+		PolicyName:     aws.String(CodeDeployLaunchTemplatePolicyName),
+		PolicyDocument: aws.String(cdConfig.LaunchTemplatePolicy()),
+	}); err != nil {
+		return roleARN, fmt.Errorf("error adding policy to CodeDeploy role: %w", err)
+	}
+
+	return roleARN, nil
+}
+
+func codedeployTags(tags map[string]string) []codedeployTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]codedeployTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, codedeployTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// CreateCodeDeployApplication creates the application for EC2 deployments.
+func CreateCodeDeployApplication(ctx context.Context, logger *log.Logger, codedeployClient *codedeploy.Client, applicationName string, tags map[string]string) error {
+	if _, err := codedeployClient.CreateApplication(ctx, &codedeploy.CreateApplicationInput{
+		ApplicationName: aws.String(applicationName),
+		ComputePlatform: codedeployTypes.ComputePlatformServer,
+		Tags:            codedeployTags(tags),
+	}); err != nil {
+		return fmt.Errorf("error creating CodeDeploy application: %w", err)
+	}
+	logger.Printf("CodeDeploy application %s created", applicationName)
+	return nil
+}
+
+// CreateDeploymentGroup creates the deployment group deploying to the
+// autoscaling group, with traffic control through the target group. It
+// retries while CodeDeploy can't assume the freshly created role yet.
+func CreateDeploymentGroup(ctx context.Context, logger *log.Logger, codedeployClient *codedeploy.Client, cdConfig CodeDeployConfig, roleARN, autoscalingGroupName, targetGroupName string, tags map[string]string) error {
+	input := &codedeploy.CreateDeploymentGroupInput{
+		ApplicationName:      aws.String(cdConfig.ApplicationName),
+		DeploymentGroupName:  aws.String(cdConfig.DeploymentGroupName),
+		ServiceRoleArn:       aws.String(roleARN),
+		DeploymentConfigName: aws.String(cdConfig.DeploymentConfigName),
+		AutoScalingGroups:    []string{autoscalingGroupName},
+		DeploymentStyle: &codedeployTypes.DeploymentStyle{
+			DeploymentType:   codedeployTypes.DeploymentType(cdConfig.DeploymentType),
+			DeploymentOption: codedeployTypes.DeploymentOptionWithTrafficControl,
+		},
+		LoadBalancerInfo: &codedeployTypes.LoadBalancerInfo{
+			TargetGroupInfoList: []codedeployTypes.TargetGroupInfo{{Name: aws.String(targetGroupName)}},
+		},
+		Tags: codedeployTags(tags),
+	}
+	if cdConfig.BlueGreen() {
+		input.BlueGreenDeploymentConfiguration = &codedeployTypes.BlueGreenDeploymentConfiguration{
+			DeploymentReadyOption: &codedeployTypes.DeploymentReadyOption{
+				ActionOnTimeout: codedeployTypes.DeploymentReadyActionContinueDeployment,
+			},
+			GreenFleetProvisioningOption: &codedeployTypes.GreenFleetProvisioningOption{
+				Action: codedeployTypes.GreenFleetProvisioningActionCopyAutoScalingGroup,
+			},
+			TerminateBlueInstancesOnDeploymentSuccess: &codedeployTypes.BlueInstanceTerminationOption{
+				Action:                       codedeployTypes.InstanceActionTerminate,
+				TerminationWaitTimeInMinutes: cdConfig.TerminationWaitMinutes,
+			},
+		}
+	}
+
+	err := retryIAMPropagation(ctx, "assume", func() error {
+		_, err := codedeployClient.CreateDeploymentGroup(ctx, input)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error creating deployment group: %w", err)
+	}
+	logger.Printf("Deployment group %s created for autoscaling group %s", cdConfig.DeploymentGroupName, autoscalingGroupName)
+	return nil
+}
+
+// FollowCodeDeployGroup records the autoscaling group a blue/green deployment
+// replaced the recorded one with, so the other commands act on the group
+// that serves the traffic.
+func FollowCodeDeployGroup(ctx context.Context, logger *log.Logger, codedeployClient *codedeploy.Client, state *State) error {
+	if state.CodeDeployDeploymentGroupName == "" {
+		return nil
+	}
+	output, err := codedeployClient.GetDeploymentGroup(ctx, &codedeploy.GetDeploymentGroupInput{
+		ApplicationName:     aws.String(state.CodeDeployApplicationName),
+		DeploymentGroupName: aws.String(state.CodeDeployDeploymentGroupName),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing deployment group: %w", err)
+	}
+
+	groups := output.DeploymentGroupInfo.AutoScalingGroups
+	if len(groups) != 1 {
+		return nil
+	}
+	autoscalingGroupName := aws.StringValue(groups[0].Name)
+	if autoscalingGroupName == "" || autoscalingGroupName == state.AutoScalingGroupName {
+		return nil
+	}
+	logger.Printf("CodeDeploy replaced autoscaling group %s with %s", state.AutoScalingGroupName, autoscalingGroupName)
+	return state.Record(func(s *State) { s.AutoScalingGroupName = autoscalingGroupName })
+}
+
+// deleteCodeDeploy deletes the deployment group, the application and the
+// service role, clearing each from the state.
+func deleteCodeDeploy(ctx context.Context, logger *log.Logger, clients *Clients, state *State) error {
+	if state.CodeDeployDeploymentGroupName != "" {
+		if _, err := clients.CodeDeploy.DeleteDeploymentGroup(ctx, &codedeploy.DeleteDeploymentGroupInput{
+			ApplicationName:     aws.String(state.CodeDeployApplicationName),
+			DeploymentGroupName: aws.String(state.CodeDeployDeploymentGroupName),
+		}); err != nil && !isNotFound(err) && !hasErrorCode(err, "ApplicationDoesNotExistException") {
+			return fmt.Errorf("error deleting deployment group: %w", err)
+		}
+		logger.Printf("Deployment group %s deleted", state.CodeDeployDeploymentGroupName)
+		if err := state.Record(func(s *State) { s.CodeDeployDeploymentGroupName = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.CodeDeployApplicationName != "" {
+		if _, err := clients.CodeDeploy.DeleteApplication(ctx, &codedeploy.DeleteApplicationInput{
+			ApplicationName: aws.String(state.CodeDeployApplicationName),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting CodeDeploy application: %w", err)
+		}
+		logger.Printf("CodeDeploy application %s deleted", state.CodeDeployApplicationName)
+		if err := state.Record(func(s *State) { s.CodeDeployApplicationName = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.CodeDeployRoleName != "" {
+		if err := DeleteRole(ctx, logger, clients.IAM, state.CodeDeployRoleName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.CodeDeployRoleName = "" }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
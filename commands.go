@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/codedeploy"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"golang.org/x/term"
+)
+
+// Command is a single CLI subcommand. Args holds everything after the
+// command name.
+type Command struct {
+	Description string
+	Run         func(ctx context.Context, logger *log.Logger, args []string) error
+}
+
+var commands map[string]Command
+
+func init() {
+	commands = map[string]Command{
+		"apply": {
+			Description: "create the stack described by the config, or update the existing one",
+			Run:         runApply,
+		},
+		"scale": {
+			Description: "change the min/max/desired capacity of the autoscaling group",
+			Run:         runScale,
+		},
+		"status": {
+			Description: "show instance, target and load balancer health, scaling activities, alarms and email subscriptions",
+			Run:         runStatus,
+		},
+		"refresh": {
+			Description: "roll out the latest launch template with an instance refresh",
+			Run:         runRefresh,
+		},
+		"rollback-version": {
+			Description: "point the autoscaling group back at a previous launch template version",
+			Run:         runRollbackVersion,
+		},
+		"plan": {
+			Description: "show the resources apply would create and their estimated monthly cost",
+			Run:         runPlan,
+		},
+		"protect": {
+			Description: "protect instances from scale-in, e.g. while they run a long job",
+			Run:         runProtect,
+		},
+		"unprotect": {
+			Description: "allow scale-in to terminate previously protected instances again",
+			Run:         runUnprotect,
+		},
+		"standby": {
+			Description: "move instances to Standby, out of the load balancer, to investigate them",
+			Run:         runFleetCommand("standby"),
+		},
+		"resume": {
+			Description: "return instances from Standby to service",
+			Run:         runFleetCommand("resume"),
+		},
+		"detach": {
+			Description: "remove instances from the autoscaling group, leaving them running",
+			Run:         runFleetCommand("detach"),
+		},
+		"attach": {
+			Description: "add running instances to the autoscaling group",
+			Run:         runFleetCommand("attach"),
+		},
+		"suspend-processes": {
+			Description: "freeze autoscaling processes, e.g. suspend-processes Launch Terminate (all when none given)",
+			Run:         runSuspendProcesses,
+		},
+		"resume-processes": {
+			Description: "resume suspended autoscaling processes (all when none given)",
+			Run:         runResumeProcesses,
+		},
+		"connect": {
+			Description: "open a Session Manager shell on an instance, e.g. connect i-0abc (an instance in service when none given)",
+			Run:         runConnect,
+		},
+		"watch": {
+			Description: "stream changes of instances, targets, scaling activities and alarms until interrupted",
+			Run:         runWatch,
+		},
+		"serve": {
+			Description: "serve an HTTP API applying, destroying and reporting the status of stacks as background jobs",
+			Run:         runServe,
+		},
+		"chaos": {
+			Description: "terminate a random instance and watch the target group recover (chaos terminate)",
+			Run:         runChaos,
+		},
+		"maintenance": {
+			Description: "answer every request with a maintenance page (maintenance on), or serve the app again (maintenance off)",
+			Run:         runMaintenance,
+		},
+		"canary": {
+			Description: "shift a share of the traffic to the canary target group, e.g. canary --weight 10",
+			Run:         runCanary,
+		},
+		"targets": {
+			Description: "register or deregister IP targets, e.g. targets register 10.1.0.5:8080 (ip target groups)",
+			Run:         runTargets,
+		},
+		"diff": {
+			Description: "show how the deployed stack differs from the config",
+			Run:         runDiff,
+		},
+		"doctor": {
+			Description: "check credentials, IAM permissions, the AMI and account quotas before applying",
+			Run:         runDoctor,
+		},
+		"validate": {
+			Description: "check the config for problems without calling AWS",
+			Run:         runValidate,
+		},
+		"destroy": {
+			Description: "delete every resource recorded in the state file",
+			Run:         runDestroy,
+		},
+		"unlock": {
+			Description: "remove a stale stack lock left behind by a run that is gone",
+			Run:         runUnlock,
+		},
+		"import": {
+			Description: "adopt an existing resource into the state, e.g. import vpc vpc-0abc",
+			Run:         runImport,
+		},
+		"list": {
+			Description: "list the stacks deployed in the region, found by their Stack tag",
+			Run:         runList,
+		},
+		"export": {
+			Description: "render the configured stack as infrastructure as code (cloudformation, terraform)",
+			Run:         runExport,
+		},
+	}
+}
+
+// Run dispatches args to a command. Without a command name (or when the first
+// argument is a flag) it falls back to apply.
+func Run(ctx context.Context, logger *log.Logger, args []string) error {
+	name := "apply"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		printUsage()
+		return nil
+	}
+
+	command, ok := commands[name]
+	if !ok {
+		printUsage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+
+	return command.Run(ctx, logger, args)
+}
+
+func printUsage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: aws-autoscaling-pzc <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", name, commands[name].Description)
+	}
+}
+
+// GlobalOptions are the flags shared by every command.
+type GlobalOptions struct {
+	ConfigPath  string
+	StatePath   string
+	Env         string
+	Stack       string
+	EndpointURL string
+
+	Profile         string
+	RoleARN         string
+	ExternalID      string
+	RoleSessionName string
+	MFASerial       string
+	MFAToken        string
+}
+
+func (o *GlobalOptions) Register(fs *flag.FlagSet) {
+	fs.StringVar(&o.ConfigPath, "config", DefaultConfigPath, "path to the JSON config file")
+	fs.StringVar(&o.StatePath, "state", "", "path to the state file recording created resources (default state.json, or state.<env>.json with --env)")
+	fs.StringVar(&o.Stack, "stack", "", "name of the stack, overriding naming.stack, to run several copies of the topology side by side")
+	fs.StringVar(&o.Env, "env", "", "environment from the config file to apply on top of the base settings, e.g. dev or prod")
+	fs.StringVar(&o.EndpointURL, "endpoint-url", os.Getenv(EnvEndpointURL), "custom AWS endpoint URL, e.g. http://localhost:4566 for LocalStack")
+	fs.StringVar(&o.Profile, "profile", os.Getenv(EnvProfile), "named profile from the shared AWS config and credentials files")
+	fs.StringVar(&o.RoleARN, "role-arn", "", "IAM role to assume for all AWS calls, e.g. to deploy into another account")
+	fs.StringVar(&o.ExternalID, "external-id", "", "external ID required by the trust policy of --role-arn")
+	fs.StringVar(&o.RoleSessionName, "role-session-name", DefaultRoleSessionName, "session name used when assuming --role-arn")
+	fs.StringVar(&o.MFASerial, "mfa-serial", "", "ARN of the MFA device required by the trust policy of --role-arn")
+	fs.StringVar(&o.MFAToken, "mfa-token", "", "MFA token code, prompted for when the role or profile requires MFA and it is not set")
+}
+
+// StateFile returns the state file to use. Every stack and environment gets
+// its own state file unless one is passed explicitly.
+func (o *GlobalOptions) StateFile() string {
+	if o.StatePath != "" {
+		return o.StatePath
+	}
+	parts := []string{"state"}
+	if o.Stack != "" {
+		parts = append(parts, o.Stack)
+	}
+	if o.Env != "" {
+		parts = append(parts, o.Env)
+	}
+	if len(parts) == 1 {
+		return DefaultStatePath
+	}
+	return strings.Join(parts, ".") + ".json"
+}
+
+// Clients groups the AWS service clients used by the provisioning steps.
+type Clients struct {
+	EC2         *ec2.Client
+	ELB         *elasticloadbalancingv2.Client
+	AutoScaling *autoscaling.Client
+	CloudWatch  *cloudwatch.Client
+	Logs        *cloudwatchlogs.Client
+	Pricing     *pricing.Client
+	STS         *sts.Client
+	IAM         *iam.Client
+	KMS         *kms.Client
+	Quotas      *servicequotas.Client
+	SSM         *ssm.Client
+	DynamoDB    *dynamodb.Client
+	S3          *s3.Client
+	Secrets     *secretsmanager.Client
+	Lambda      *lambda.Client
+	ECS         *ecs.Client
+	CodeDeploy  *codedeploy.Client
+	Events      *eventbridge.Client
+	SNS         *sns.Client
+}
+
+func NewClients(ctx context.Context, logger *log.Logger, cfg *Config, opts *GlobalOptions) (*Clients, error) {
+	awsConfig, err := LoadAWSConfig(ctx, cfg.Region, opts)
+	if err != nil {
+		return nil, err
+	}
+	logger.Println("AWS configuration loaded successfully")
+
+	// The clients behind a class of steps retry like the class, the others
+	// like the default one.
+	retries := cfg.Retries
+	awsConfig.Retryer = retries.Default.Retryer
+
+	return &Clients{
+		EC2: ec2.NewFromConfig(awsConfig, func(o *ec2.Options) {
+			o.Retryer = retries.Networking.Retryer()
+		}),
+		ELB: elasticloadbalancingv2.NewFromConfig(awsConfig, func(o *elasticloadbalancingv2.Options) {
+			o.Retryer = retries.LoadBalancing.Retryer()
+		}),
+		AutoScaling: autoscaling.NewFromConfig(awsConfig, func(o *autoscaling.Options) {
+			o.Retryer = retries.AutoScaling.Retryer()
+		}),
+		CloudWatch: cloudwatch.NewFromConfig(awsConfig),
+		Logs:       cloudwatchlogs.NewFromConfig(awsConfig),
+		Pricing: pricing.NewFromConfig(awsConfig, func(o *pricing.Options) {
+			o.Region = PricingRegion
+		}),
+		STS:      sts.NewFromConfig(awsConfig),
+		IAM:      iam.NewFromConfig(awsConfig),
+		KMS:      kms.NewFromConfig(awsConfig),
+		Quotas:   servicequotas.NewFromConfig(awsConfig),
+		SSM:      ssm.NewFromConfig(awsConfig),
+		DynamoDB: dynamodb.NewFromConfig(awsConfig),
+		// LocalStack and other custom endpoints don't resolve bucket
+		// subdomains, so the bucket goes into the path there.
+		S3: s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+			o.UsePathStyle = opts.EndpointURL != ""
+		}),
+		Secrets:    secretsmanager.NewFromConfig(awsConfig),
+		Lambda:     lambda.NewFromConfig(awsConfig),
+		ECS:        ecs.NewFromConfig(awsConfig),
+		CodeDeploy: codedeploy.NewFromConfig(awsConfig),
+		Events:     eventbridge.NewFromConfig(awsConfig),
+		SNS:        sns.NewFromConfig(awsConfig),
+	}, nil
+}
+
+// LoadStack loads everything a command operating on an already deployed stack
+// needs. It fails when the state file does not record a stack.
+func LoadStack(ctx context.Context, logger *log.Logger, opts *GlobalOptions) (*Config, *State, *Clients, error) {
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	clients, err := NewClients(ctx, logger, cfg, opts)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	state, err := OpenState(ctx, logger, cfg, opts, clients)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if state.IsEmpty() {
+		return nil, nil, nil, fmt.Errorf("no stack recorded in %s, run apply first", state.Location())
+	}
+	if err := FollowCodeDeployGroup(ctx, logger, clients.CodeDeploy, state); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cfg, state, clients, nil
+}
+
+// Confirm asks a yes/no question on stdin, anything but "y" or "yes" is a no.
+func Confirm(prompt string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", prompt)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, fmt.Errorf("error reading answer: %w", err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func runApply(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("apply", flag.ExitOnError)
+	opts.Register(fs)
+	showProgress := fs.Bool("progress", false, "show a live view of the steps instead of log lines when stderr is a terminal")
+	tui := fs.Bool("tui", false, "show a full-screen dashboard of the steps, instances and scaling activities when stderr is a terminal")
+	output := fs.String("output", OutputText, "format of the result printed to stdout: text or json")
+	skipQuotaCheck := fs.Bool("skip-quota-check", false, "don't compare the vCPU and load balancer quotas with max capacity before creating the stack")
+	onInterrupt := fs.String("on-interrupt", OnInterruptAsk, "what to do with created resources when apply is interrupted: ask, rollback or keep")
+	resume := fs.Bool("resume", false, "finish the first apply of a stack that failed or was interrupted, skipping the steps it completed")
+	target := fs.String("target", "", "only run this step of the first apply and the steps it depends on, e.g. \"Security group\"; apply --resume runs the rest")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *target != "" && *resume {
+		return fmt.Errorf("--target and --resume can't be combined, --target skips completed steps too")
+	}
+	if *target != "" {
+		step, err := ResolveStepName(*target)
+		if err != nil {
+			return err
+		}
+		*target = step
+	}
+	if err := ValidateOutputFormat(*output); err != nil {
+		return err
+	}
+	if err := ValidateOnInterrupt(*onInterrupt); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+	if err := CheckConfig(cfg); err != nil {
+		return err
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, ApplyTimeout)
+	defer cancelFunc()
+
+	clients, err := NewClients(ctx, logger, cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	// The state is read under the lock, so it can't be changed by another
+	// run in the meantime.
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "apply")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	state, err := OpenState(ctx, logger, cfg, &opts, clients)
+	if err != nil {
+		return err
+	}
+
+	if err := ResolveAMI(ctx, logger, clients, cfg); err != nil {
+		return err
+	}
+	if err := ResolveVPC(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	if state.IsEmpty() && !*skipQuotaCheck {
+		if err := CheckCapacityQuotas(ctx, logger, clients, cfg); err != nil {
+			return err
+		}
+	}
+	if state.IsEmpty() {
+		if err := CheckNameConflicts(ctx, logger, clients, cfg); err != nil {
+			return err
+		}
+	}
+
+	// A resumed apply runs like the first one, only without the steps it
+	// completed, a targeted one only with the target and what it needs.
+	apply, steps := Apply, ConfiguredSteps(cfg)
+	switch {
+	case *resume:
+		apply, steps = Resume, PendingSteps(cfg, state)
+	case *target != "":
+		apply = func(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, progress Progress) error {
+			return ApplyTarget(ctx, logger, clients, cfg, state, progress, *target)
+		}
+		steps = TargetSteps(cfg, state, *target)
+	}
+
+	notifier := NewNotifier(logger, cfg, "apply")
+	notifier.Started()
+	if state.IsEmpty() || *resume || *target != "" {
+		// SIGINT and SIGTERM stop the apply before its next step instead of
+		// killing it halfway through a step. A second signal kills it.
+		interruptCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-interruptCtx.Done()
+			stopSignals()
+		}()
+		if *tui && term.IsTerminal(int(os.Stderr.Fd())) {
+			dashboard := NewDashboard(os.Stderr, "apply "+cfg.Naming.Stack, steps)
+			applyLogger := dashboard.Logger()
+			pollCtx, stopPolling := context.WithCancel(ctx)
+			dashboard.Poll(pollCtx, clients, state)
+			dashboard.Start()
+			err = apply(ctx, applyLogger, clients, cfg, state, NewInterruptibleProgress(interruptCtx, NewNotifyProgress(notifier, NewHookProgress(applyLogger, cfg, state, dashboard))))
+			stopPolling()
+			dashboard.Stop()
+		} else if *showProgress && term.IsTerminal(int(os.Stderr.Fd())) {
+			progress := NewTerminalProgress(os.Stderr, steps)
+			progress.Start()
+			applyLogger := log.New(io.Discard, "", 0)
+			err = apply(ctx, applyLogger, clients, cfg, state, NewInterruptibleProgress(interruptCtx, NewNotifyProgress(notifier, NewHookProgress(applyLogger, cfg, state, progress))))
+			progress.Stop()
+		} else {
+			err = apply(ctx, logger, clients, cfg, state, NewInterruptibleProgress(interruptCtx, NewNotifyProgress(notifier, NewHookProgress(logger, cfg, state, PlainProgress{}))))
+		}
+		stopSignals()
+		if errors.Is(err, ErrInterrupted) {
+			notifier.Failed(err)
+			return HandleInterrupt(context.WithoutCancel(ctx), logger, clients, cfg, state, *onInterrupt)
+		}
+	} else {
+		logger.Printf("Updating existing stack recorded in %s", state.Location())
+		err = Update(ctx, logger, clients, cfg, state)
+	}
+	if err != nil {
+		notifier.Failed(err)
+		return err
+	}
+	notifier.Succeeded(state)
+
+	if *output == OutputJSON {
+		return WriteJSON(os.Stdout, NewStackOutputs(cfg, state))
+	}
+	logger.Print(ListenerURL(state.LoadBalancerDNSName, cfg.Listener))
+	return nil
+}
+
+func runPlan(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	var skipCost bool
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	opts.Register(fs)
+	fs.BoolVar(&skipCost, "no-cost", false, "skip the cost estimate and the AMI check (no AWS calls are made)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	var estimate *CostEstimate
+	if !skipCost {
+		clients, err := NewClients(ctx, logger, cfg, &opts)
+		if err != nil {
+			return err
+		}
+		// An AMI that doesn't match the instance type would only fail once
+		// the autoscaling group launches instances.
+		if err := ResolveAMI(ctx, logger, clients, cfg); err != nil {
+			return err
+		}
+		if estimate, err = EstimateCost(ctx, clients.Pricing, cfg); err != nil {
+			return err
+		}
+	}
+
+	resources, err := PlanResources(cfg)
+	if err != nil {
+		return err
+	}
+
+	return PrintPlan(os.Stdout, resources, estimate)
+}
+
+func runExport(ctx context.Context, logger *log.Logger, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return fmt.Errorf("export requires a format: cloudformation or terraform")
+	}
+	format, args := args[0], args[1:]
+
+	var opts GlobalOptions
+	var outPath string
+	fs := flag.NewFlagSet("export "+format, flag.ExitOnError)
+	opts.Register(fs)
+	fs.StringVar(&outPath, "out", "", "output file for cloudformation (defaults to stdout), output directory for terraform (defaults to terraform)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+	// The exports create their own VPC, security group and launch template.
+	if cfg.VPC.Existing() {
+		return fmt.Errorf("export doesn't support vpc.id and vpc.selector, the exported stack always creates its VPC")
+	}
+	if cfg.SecurityGroup.Existing != nil {
+		return fmt.Errorf("export doesn't support securityGroup.existing, the exported stack always creates its security group")
+	}
+	if cfg.LaunchTemplate.Existing != nil {
+		return fmt.Errorf("export doesn't support launchTemplate.existing, the exported stack always creates its launch template")
+	}
+
+	var rendered []byte
+	switch format {
+	case "cloudformation":
+		rendered, err = RenderCloudFormation(cfg)
+	case "terraform":
+		clients, err := NewClients(ctx, logger, cfg, &opts)
+		if err != nil {
+			return err
+		}
+		state, err := OpenState(ctx, logger, cfg, &opts, clients)
+		if err != nil {
+			return err
+		}
+		if outPath == "" {
+			outPath = DefaultTerraformDir
+		}
+		if err := ExportTerraform(cfg, state, outPath); err != nil {
+			return err
+		}
+		logger.Printf("Terraform configuration written to %s", outPath)
+		return nil
+	default:
+		return fmt.Errorf("unknown export format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if outPath == "" {
+		_, err = os.Stdout.Write(rendered)
+		return err
+	}
+	if err := os.WriteFile(outPath, rendered, 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %w", outPath, err)
+	}
+	logger.Printf("Template written to %s", outPath)
+
+	return nil
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultConfigPath     = "config.json"
+	DefaultStackName      = "webservice"
+	DefaultNamingTemplate = "{stack}-{env}-{resource}"
+)
+
+// Resource names substituted for {resource} in the naming template.
+const (
+	ResourceSecurityGroup     = "security-group"
+	ResourceLaunchTemplate    = "launch-template"
+	ResourceAutoScalingGroup  = "asg"
+	ResourceScalingPolicy     = "cpu-policy"
+	ResourceTargetGroup       = "target-group"
+	ResourceLoadBalancer      = "load-balancer"
+	ResourceFlowLogs          = "flow-logs"
+	ResourceFlowLogsRole      = "flow-logs-role"
+	ResourceEndpoints         = "endpoints"
+	ResourceTrustStore        = "trust-store"
+	ResourceCanaryTargetGroup = "canary-tg"
+	ResourceCanaryGroup       = "canary-asg"
+	ResourceLambda            = "lambda"
+	ResourceECSCluster        = "cluster"
+	ResourceCapacityProvider  = "capacity-provider"
+	ResourceInstanceRole      = "instance-role"
+	ResourceCodeDeploy        = "codedeploy"
+	ResourceCodeDeployRole    = "codedeploy-role"
+	ResourceSecrets           = "secrets"
+	ResourcePlacementGroup    = "placement-group"
+	ResourceVolumeKey         = "ebs-key"
+	ResourceElasticIP         = "eip"
+	ResourceState             = "state"
+)
+
+// Config describes the topology provisioned by the tool. Every field has a
+// default, so a config file only needs to list the values it overrides.
+type Config struct {
+	Region         string               `json:"region"`
+	Naming         NamingConfig         `json:"naming"`
+	VPC            VPCConfig            `json:"vpc"`
+	SecurityGroup  SecurityGroupConfig  `json:"securityGroup"`
+	LaunchTemplate LaunchTemplateConfig `json:"launchTemplate"`
+	TargetGroup    TargetGroupConfig    `json:"targetGroup"`
+	AutoScaling    AutoScalingConfig    `json:"autoScaling"`
+	LoadBalancer   LoadBalancerConfig   `json:"loadBalancer"`
+	Listener       ListenerConfig       `json:"listener"`
+	// AdditionalListeners are more ports on the load balancer, each
+	// forwarding to the target group or redirecting, e.g. to the main
+	// listener.
+	AdditionalListeners []ListenerConfig `json:"additionalListeners"`
+	Lock                LockConfig       `json:"lock"`
+	State               StateConfig      `json:"state"`
+	SmokeTest           SmokeTestConfig  `json:"smokeTest"`
+	Canary              *CanaryConfig    `json:"canary"`
+	// ECS makes the autoscaling group the capacity provider of an ECS
+	// cluster instead of the target of the target group.
+	ECS *ECSConfig `json:"ecs"`
+	// App generates the user data from a container image.
+	App *AppConfig `json:"app"`
+	// CodeDeploy sets up deploying application revisions to the
+	// autoscaling group with CodeDeploy.
+	CodeDeploy *CodeDeployConfig `json:"codeDeploy"`
+	// Secrets are published during apply and read by the instances at boot.
+	Secrets []SecretConfig `json:"secrets"`
+	// Windows runs Windows Server instances instead of Amazon Linux.
+	Windows *WindowsConfig `json:"windows"`
+	// PlacementGroup is created during apply and the instances are launched
+	// into it.
+	PlacementGroup *PlacementGroupConfig `json:"placementGroup"`
+	// VolumeEncryption encrypts the volumes of the instances with a
+	// customer managed KMS key, created during apply or referenced.
+	VolumeEncryption *VolumeEncryptionConfig `json:"volumeEncryption"`
+	// ElasticIPs are static public addresses allocated during apply and
+	// released on destroy.
+	ElasticIPs []ElasticIPConfig `json:"elasticIps"`
+	// Bastion launches an instance in the first public subnet to SSH to the
+	// instances through.
+	Bastion *BastionConfig `json:"bastion"`
+	// InstanceConnectEndpoint creates an EC2 Instance Connect Endpoint to
+	// SSH to the instances through without a bastion.
+	InstanceConnectEndpoint *InstanceConnectEndpointConfig `json:"instanceConnectEndpoint"`
+	// SessionManagerOnly hardens access to the instances: no SSH or remote
+	// desktop rule, only Session Manager sessions through the instance role.
+	SessionManagerOnly bool `json:"sessionManagerOnly"`
+	// LifecycleEvents sends the launch and terminate events of the
+	// autoscaling groups to an SNS topic or a Lambda function.
+	LifecycleEvents *LifecycleEventsConfig `json:"lifecycleEvents"`
+	// Logs ships the logs of the instances to CloudWatch Logs with the
+	// CloudWatch agent.
+	Logs *LogsConfig `json:"logs"`
+	// LambdaTargets route requests by path to Lambda functions.
+	LambdaTargets []LambdaTargetConfig `json:"lambdaTargets"`
+	// HostRules route requests by host name to other target groups.
+	HostRules []HostRuleConfig `json:"hostRules"`
+	Hooks     []HookConfig     `json:"hooks,omitempty"`
+	// Notifications post the start, the steps, the failure or the outputs of
+	// apply and destroy to Slack or other webhooks.
+	Notifications []NotificationConfig `json:"notifications,omitempty"`
+	// Retries sets the retry policy of the AWS calls and the steps of apply
+	// per class of steps.
+	Retries RetriesConfig `json:"retries"`
+
+	// Environments holds per-environment overrides, applied on top of the
+	// settings above when the environment is selected with --env.
+	Environments map[string]json.RawMessage `json:"environments,omitempty"`
+	// Environment is the name of the selected environment, empty when none
+	// was selected.
+	Environment string `json:"-"`
+}
+
+// NamingConfig builds the names of the named resources. Template may use the
+// {stack}, {env} and {resource} placeholders; a name set explicitly on a
+// resource takes precedence.
+type NamingConfig struct {
+	Stack    string `json:"stack"`
+	Template string `json:"template"`
+}
+
+type VPCConfig struct {
+	// ID or Selector, the tags the VPC must have, deploys the stack into an
+	// existing VPC instead of creating one. CIDRBlock and SecondaryCIDRBlocks
+	// are then taken from the VPC.
+	ID       string            `json:"id"`
+	Selector map[string]string `json:"selector"`
+	// Resolved is the existing VPC found by ResolveVPC.
+	Resolved *ExistingVPC `json:"-"`
+
+	CIDRBlock string `json:"cidrBlock"`
+	// SecondaryCIDRBlocks are associated with the VPC after it is created.
+	// Explicitly listed Subnets may be carved from them.
+	SecondaryCIDRBlocks []string `json:"secondaryCidrBlocks"`
+	// Subnets lists the subnets explicitly. When empty, PublicSubnets and
+	// PrivateSubnets subnets of SubnetPrefixLength are carved from CIDRBlock
+	// and spread over the availability zones of the region.
+	Subnets            []SubnetConfig   `json:"subnets"`
+	PublicSubnets      int              `json:"publicSubnets"`
+	PrivateSubnets     int              `json:"privateSubnets"`
+	SubnetPrefixLength int              `json:"subnetPrefixLength"`
+	NetworkACL         NetworkACLConfig `json:"networkAcl"`
+	FlowLogs           FlowLogsConfig   `json:"flowLogs"`
+	// Endpoints lists the AWS services, e.g. s3, ssm, ec2messages and
+	// ssmmessages, the instances reach through VPC endpoints instead of the
+	// internet.
+	Endpoints []string `json:"endpoints"`
+	// SubnetIDs uses existing subnets of the existing VPC instead of
+	// creating any.
+	SubnetIDs *SubnetIDsConfig `json:"subnetIds"`
+}
+
+type SubnetConfig struct {
+	CIDRBlock        string `json:"cidrBlock"`
+	AvailabilityZone string `json:"availabilityZone"`
+	// Private subnets get no public IPs and no route to the internet gateway.
+	Private bool `json:"private"`
+	// ZoneIndex is the position of the zone among the zones of the region,
+	// used by the exports when the zone itself is not known yet.
+	ZoneIndex int `json:"-"`
+}
+
+// SubnetIDsConfig lists the existing subnets of the load balancer and of the
+// autoscaling groups, each spanning at least two availability zones. They
+// may be the same subnets.
+type SubnetIDsConfig struct {
+	LoadBalancer     []string `json:"loadBalancer"`
+	AutoScalingGroup []string `json:"autoScalingGroup"`
+}
+
+type SecurityGroupConfig struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// IngressPorts are opened to the internet, unless Ingress lists the
+	// ingress rules instead.
+	IngressPorts []int32             `json:"ingressPorts"`
+	Ingress      []SecurityGroupRule `json:"ingress"`
+	// AddedIngress are the rules other settings add to the ingress rules,
+	// like remote desktop on Windows.
+	AddedIngress []SecurityGroupRule `json:"-"`
+	// Egress rules are added next to the default allow-all egress rule,
+	// unless RevokeDefaultEgress removes it.
+	Egress              []SecurityGroupRule `json:"egress"`
+	RevokeDefaultEgress bool                `json:"revokeDefaultEgress"`
+	// Existing uses a security group of the VPC instead of creating one.
+	Existing *ExistingSecurityGroupConfig `json:"existing"`
+}
+
+// ExistingSecurityGroupConfig refers to an existing security group by ID or
+// by name. With Reconcile, apply adds the configured rules it lacks and
+// reports the rules it has beyond them, otherwise it is used as is.
+type ExistingSecurityGroupConfig struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Reconcile bool   `json:"reconcile"`
+}
+
+type LaunchTemplateConfig struct {
+	Name string `json:"name"`
+	// AMIID pins the AMI. When empty, the AMI is read from the AMIParameter
+	// SSM parameter of the region on every apply.
+	AMIID        string `json:"amiId"`
+	AMIParameter string `json:"amiParameter"`
+	InstanceType string `json:"instanceType"`
+	UserDataFile string `json:"userDataFile"`
+	// UserDataParts are assembled into multipart user data for cloud-init
+	// instead of running UserDataFile.
+	UserDataParts []UserDataPart `json:"userDataParts"`
+	// IAMInstanceProfile is the name of the instance profile the instances
+	// run with.
+	IAMInstanceProfile string `json:"iamInstanceProfile"`
+	// InstanceRoleName is the IAM role and instance profile created when the
+	// instances need one, for ECS, to read the secrets, for Session Manager
+	// or to ship the logs, unless IAMInstanceProfile names an existing
+	// instance profile.
+	InstanceRoleName string `json:"instanceRoleName"`
+	// ECSCluster is the cluster the user data joins the instances to, set
+	// from the ECS config.
+	ECSCluster string `json:"-"`
+	// App is the container the generated user data runs instead of
+	// UserDataFile, set from the app config.
+	App *AppConfig `json:"-"`
+	// Secrets are read by the user data before it runs, set from the
+	// secrets config.
+	Secrets []SecretConfig `json:"-"`
+	// Windows wraps the user data in PowerShell tags, set from the Windows
+	// config.
+	Windows bool `json:"-"`
+	// PlacementGroup is the placement group the instances are launched into,
+	// set from the placement group config.
+	PlacementGroup string `json:"-"`
+	// LogGroups are the log groups the user data has the CloudWatch agent
+	// ship the logs to, set from the logs config.
+	LogGroups []LogGroupConfig `json:"-"`
+	// PinVersion points the autoscaling group at a specific launch template
+	// version instead of $Latest, so new versions only roll out on apply.
+	PinVersion bool `json:"pinVersion"`
+	// RootVolume overrides the root volume of the AMI, nil keeps the AMI
+	// defaults.
+	RootVolume *RootVolumeConfig `json:"rootVolume,omitempty"`
+	// DataVolumes are attached to every instance next to the root volume.
+	DataVolumes []DataVolumeConfig `json:"dataVolumes"`
+	Metadata    MetadataConfig     `json:"metadata"`
+	// DetailedMonitoring turns on 1-minute CloudWatch metrics for the
+	// instances, which is billed per instance.
+	DetailedMonitoring bool `json:"detailedMonitoring"`
+	// CPUCredits is standard or unlimited for burstable instance types, empty
+	// keeps the default of the instance family: unlimited for T3 and later,
+	// standard for T2.
+	CPUCredits string `json:"cpuCredits"`
+	// Tenancy is default for shared hardware, dedicated for hardware used by
+	// the account only, or host for Dedicated Hosts.
+	Tenancy string `json:"tenancy"`
+	// HostResourceGroupARN is the License Manager host resource group that
+	// allocates the Dedicated Hosts of the host tenancy.
+	HostResourceGroupARN string `json:"hostResourceGroupArn"`
+	// Hibernation lets the instances be hibernated, saving their RAM to the
+	// encrypted root volume, and resumed where they left off.
+	Hibernation bool `json:"hibernation"`
+	// Existing points the autoscaling groups at a launch template managed
+	// outside the stack instead of creating one, the other settings of the
+	// launch template are then ignored.
+	Existing *ExistingLaunchTemplateConfig `json:"existing"`
+}
+
+// ExistingLaunchTemplateConfig refers to an existing launch template by ID
+// or by name. Version is a version number, $Latest or $Default, the default.
+type ExistingLaunchTemplateConfig struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// MetadataConfig controls the instance metadata service. Tokens (IMDSv2) are
+// required by default so instances can't be exploited via IMDSv1 SSRF.
+type MetadataConfig struct {
+	RequireTokens bool  `json:"requireTokens"`
+	HopLimit      int32 `json:"hopLimit"`
+	// InstanceTags exposes the tags of the instance in the metadata under
+	// tags/instance, so the instance can read its stack and environment.
+	InstanceTags bool `json:"instanceTags"`
+}
+
+// HTTPTokens returns the HttpTokens value matching RequireTokens.
+func (c MetadataConfig) HTTPTokens() string {
+	if c.RequireTokens {
+		return "required"
+	}
+	return "optional"
+}
+
+// InstanceMetadataTags returns the InstanceMetadataTags value matching
+// InstanceTags.
+func (c MetadataConfig) InstanceMetadataTags() string {
+	if c.InstanceTags {
+		return "enabled"
+	}
+	return "disabled"
+}
+
+type RootVolumeConfig struct {
+	DeviceName string `json:"deviceName"`
+	VolumeType string `json:"volumeType"`
+	SizeGiB    int32  `json:"sizeGiB"`
+	IOPS       int32  `json:"iops"`
+	Throughput int32  `json:"throughput"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+// Device returns the configured device name, defaulting to the root device
+// of the Amazon Linux AMIs.
+func (c *RootVolumeConfig) Device() string {
+	if c.DeviceName == "" {
+		return DefaultRootDeviceName
+	}
+	return c.DeviceName
+}
+
+type TargetGroupConfig struct {
+	Name string `json:"name"`
+	Port int32  `json:"port"`
+	// TargetType is instance, for the instances of the autoscaling group, or
+	// ip, for IPTargets and addresses registered with the targets command.
+	TargetType string           `json:"targetType"`
+	IPTargets  []IPTargetConfig `json:"ipTargets"`
+	// ProtocolVersion is HTTP1, HTTP2 or GRPC. HTTP2 and GRPC need an HTTPS
+	// listener.
+	ProtocolVersion string `json:"protocolVersion"`
+	// SlowStartSeconds ramps the share of requests a newly registered
+	// target gets up over this many seconds, 0 sends it its full share
+	// right away.
+	SlowStartSeconds int32 `json:"slowStartSeconds"`
+	// DeregistrationDelaySeconds is how long in-flight requests of a target
+	// being removed may take before the load balancer closes them.
+	DeregistrationDelaySeconds int32             `json:"deregistrationDelaySeconds"`
+	HealthCheck                HealthCheckConfig `json:"healthCheck"`
+}
+
+// HealthCheckConfig sets up the health check of the target group. Path and
+// Matcher default to / and 200, or to the default gRPC health check method
+// and code 12 with protocol version GRPC.
+type HealthCheckConfig struct {
+	Path               string `json:"path"`
+	IntervalSeconds    int32  `json:"intervalSeconds"`
+	TimeoutSeconds     int32  `json:"timeoutSeconds"`
+	HealthyThreshold   int32  `json:"healthyThreshold"`
+	UnhealthyThreshold int32  `json:"unhealthyThreshold"`
+	Matcher            string `json:"matcher"`
+}
+
+type AutoScalingConfig struct {
+	Name           string  `json:"name"`
+	PolicyName     string  `json:"policyName"`
+	MinSize        int32   `json:"minSize"`
+	MaxSize        int32   `json:"maxSize"`
+	CPUTargetValue float64 `json:"cpuTargetValue"`
+	// SQSBacklog switches the scaling policy from CPU to the queue backlog
+	// per instance.
+	SQSBacklog *SQSBacklogConfig `json:"sqsBacklog"`
+	// CustomMetric tracks any CloudWatch metric instead, it can't be
+	// combined with SQSBacklog.
+	CustomMetric *CustomMetricConfig `json:"customMetric"`
+	// DesiredCapacity is only used when the group is created, defaulting to
+	// minSize; afterwards the scaling policy and the scale command own it.
+	DesiredCapacity *int32 `json:"desiredCapacity"`
+	DefaultCooldown int32  `json:"defaultCooldown"`
+	// HealthCheckType is ELB to replace instances the target group reports
+	// as unhealthy, or EC2 to only replace instances failing status checks.
+	HealthCheckType        string `json:"healthCheckType"`
+	HealthCheckGracePeriod int32  `json:"healthCheckGracePeriod"`
+	// TerminationPolicies choose the instances removed on scale-in, in
+	// order, e.g. OldestLaunchTemplate then ClosestToNextInstanceHour.
+	TerminationPolicies []string `json:"terminationPolicies"`
+	// MaxInstanceLifetimeDays replaces instances once they're that old, so
+	// the fleet picks up new AMIs; 0 keeps instances indefinitely.
+	MaxInstanceLifetimeDays int32 `json:"maxInstanceLifetimeDays"`
+	// NewInstancesProtectedFromScaleIn protects every launched instance from
+	// scale-in, protection is then lifted per instance with unprotect.
+	NewInstancesProtectedFromScaleIn bool `json:"newInstancesProtectedFromScaleIn"`
+	// GroupMetrics lists the autoscaling group metrics published to
+	// CloudWatch, an empty list disables metrics collection.
+	GroupMetrics []string `json:"groupMetrics"`
+	// InstanceRequirements select the instance types by vCPUs and memory
+	// instead of launching launchTemplate.instanceType only.
+	InstanceRequirements *InstanceRequirementsConfig `json:"instanceRequirements"`
+}
+
+// MaxInstanceLifetime is the lifetime in seconds, as the autoscaling API
+// expects it.
+func (a AutoScalingConfig) MaxInstanceLifetime() int32 {
+	return a.MaxInstanceLifetimeDays * 24 * 60 * 60
+}
+
+type LoadBalancerConfig struct {
+	Name string `json:"name"`
+	// Scheme is internet-facing, or internal for a load balancer in the
+	// private subnets that is only reachable from within the VPC.
+	Scheme string `json:"scheme"`
+}
+
+// Internal reports whether the load balancer is placed in the private
+// subnets without public exposure.
+func (l LoadBalancerConfig) Internal() bool {
+	return l.Scheme == LoadBalancerSchemeInternal
+}
+
+type ListenerConfig struct {
+	Port int32 `json:"port"`
+	// CertificateARN is the ACM certificate the listener serves HTTPS with;
+	// without it the listener serves plain HTTP.
+	CertificateARN string `json:"certificateArn"`
+	// AdditionalCertificateARNs are served by SNI next to CertificateARN, so
+	// one load balancer serves several hostnames.
+	AdditionalCertificateARNs []string `json:"additionalCertificateArns"`
+	// SSLPolicy sets the TLS versions and ciphers the HTTPS listener
+	// negotiates.
+	SSLPolicy      string                `json:"sslPolicy"`
+	MutualTLS      *MutualTLSConfig      `json:"mutualTls"`
+	Authentication *AuthenticationConfig `json:"authentication"`
+	// Maintenance is the page served while maintenance mode is on.
+	Maintenance MaintenanceConfig `json:"maintenance"`
+	// Redirect is only used by additional listeners.
+	Redirect *RedirectConfig `json:"redirect"`
+}
+
+// LockConfig sets up the stack lock. Table names a DynamoDB table with a
+// string partition key LockID; without it only a local lock file is used.
+type LockConfig struct {
+	Table string `json:"table"`
+}
+
+// StateConfig moves the state to S3. With Bucket set the state is stored
+// under Key, named after the stack by default, instead of the local state
+// file; KMSKeyID encrypts it with SSE-KMS.
+type StateConfig struct {
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
+	KMSKeyID string `json:"kmsKeyId"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Region: AWSRegion,
+		Naming: NamingConfig{
+			Stack:    DefaultStackName,
+			Template: DefaultNamingTemplate,
+		},
+		VPC: VPCConfig{
+			CIDRBlock:          AWSVPCCIDRBlock,
+			PublicSubnets:      2,
+			SubnetPrefixLength: 24,
+			NetworkACL: NetworkACLConfig{
+				Subnets: NetworkACLSubnetsAll,
+			},
+			FlowLogs: FlowLogsConfig{
+				Destination:   FlowLogsDestinationCloudWatchLogs,
+				RetentionDays: 30,
+				TrafficType:   "ALL",
+			},
+		},
+		SecurityGroup: SecurityGroupConfig{
+			Description:  AWSSecurityGroupDescription,
+			IngressPorts: []int32{8080, 80, 443},
+		},
+		LaunchTemplate: LaunchTemplateConfig{
+			AMIParameter: DefaultAMIParameter,
+			InstanceType: AWSInstanceType,
+			UserDataFile: UserDataScript,
+			Tenancy:      TenancyDefault,
+			Metadata: MetadataConfig{
+				RequireTokens: true,
+				HopLimit:      1,
+				InstanceTags:  true,
+			},
+		},
+		TargetGroup: TargetGroupConfig{
+			Port:                       AWSTargetGroupPort,
+			TargetType:                 TargetTypeInstance,
+			ProtocolVersion:            ProtocolVersionHTTP1,
+			DeregistrationDelaySeconds: DefaultDeregistrationDelaySeconds,
+			HealthCheck: HealthCheckConfig{
+				IntervalSeconds:    30,
+				TimeoutSeconds:     5,
+				HealthyThreshold:   5,
+				UnhealthyThreshold: 2,
+			},
+		},
+		AutoScaling: AutoScalingConfig{
+			MinSize:                AWSMinEC2Count,
+			MaxSize:                AWSMaxEC2Count,
+			CPUTargetValue:         AWSAutoScalingCPUThreshold,
+			DefaultCooldown:        AWSDefaultCooldown,
+			HealthCheckType:        AWSHealthCheckType,
+			HealthCheckGracePeriod: AWSHealthCheckGracePeriod,
+			TerminationPolicies:    []string{"Default"},
+			GroupMetrics: []string{
+				"GroupMinSize",
+				"GroupMaxSize",
+				"GroupDesiredCapacity",
+				"GroupInServiceInstances",
+				"GroupPendingInstances",
+				"GroupTerminatingInstances",
+				"GroupTotalInstances",
+			},
+		},
+		LoadBalancer: LoadBalancerConfig{
+			Scheme: LoadBalancerSchemeInternetFacing,
+		},
+		Listener: ListenerConfig{
+			Port:      AWSListenerPort,
+			SSLPolicy: DefaultSSLPolicy,
+			Maintenance: MaintenanceConfig{
+				StatusCode:  503,
+				ContentType: "text/html",
+				Body:        DefaultMaintenanceBody,
+			},
+		},
+		SmokeTest: SmokeTestConfig{
+			Enabled:        true,
+			Path:           "/",
+			ExpectedStatus: 200,
+			TimeoutSeconds: DefaultSmokeTestTimeout,
+		},
+		Retries: RetriesConfig{
+			Networking:    DefaultRetryConfig(),
+			LoadBalancing: DefaultRetryConfig(),
+			AutoScaling:   DefaultRetryConfig(),
+			Default:       DefaultRetryConfig(),
+		},
+	}
+}
+
+// LoadConfig reads the config file at path on top of the defaults and, when
+// env is set, applies the overrides of that environment on top. A non-empty
+// stack replaces naming.stack, so the same config can be deployed as several
+// independent stacks. A missing file is not an error, the defaults are used
+// as-is.
+func LoadConfig(path, env, stack string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && env == "" {
+		if stack != "" {
+			cfg.Naming.Stack = stack
+		}
+		cfg.ResolveNames()
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	if env != "" {
+		overrides, ok := cfg.Environments[env]
+		if !ok {
+			return nil, fmt.Errorf("environment %q is not defined in %s", env, path)
+		}
+		if err := json.Unmarshal(overrides, cfg); err != nil {
+			return nil, fmt.Errorf("error parsing environment %q in %s: %w", env, path, err)
+		}
+		cfg.Environment = env
+	}
+	if stack != "" {
+		cfg.Naming.Stack = stack
+	}
+
+	cfg.ResolveNames()
+	return cfg, nil
+}
+
+// ResourceName renders the naming template for resource. Separators left
+// around empty placeholders, e.g. {env} without --env, are dropped.
+func (c *Config) ResourceName(resource string) string {
+	name := strings.NewReplacer(
+		"{stack}", c.Naming.Stack,
+		"{env}", c.Environment,
+		"{resource}", resource,
+	).Replace(c.Naming.Template)
+
+	for strings.Contains(name, "--") {
+		name = strings.ReplaceAll(name, "--", "-")
+	}
+	return strings.Trim(name, "-")
+}
+
+// ResolveNames fills the names that aren't set explicitly from the naming
+// template.
+func (c *Config) ResolveNames() {
+	for _, field := range []struct {
+		name     *string
+		resource string
+	}{
+		{&c.SecurityGroup.Name, ResourceSecurityGroup},
+		{&c.LaunchTemplate.Name, ResourceLaunchTemplate},
+		{&c.AutoScaling.Name, ResourceAutoScalingGroup},
+		{&c.AutoScaling.PolicyName, ResourceScalingPolicy},
+		{&c.TargetGroup.Name, ResourceTargetGroup},
+		{&c.LoadBalancer.Name, ResourceLoadBalancer},
+		{&c.VPC.FlowLogs.LogGroupName, ResourceFlowLogs},
+		{&c.VPC.FlowLogs.RoleName, ResourceFlowLogsRole},
+	} {
+		if *field.name == "" {
+			*field.name = c.ResourceName(field.resource)
+		}
+	}
+	if healthCheck := &c.TargetGroup.HealthCheck; c.TargetGroup.UsesGRPC() {
+		if healthCheck.Path == "" {
+			healthCheck.Path = DefaultGRPCHealthCheckPath
+		}
+		if healthCheck.Matcher == "" {
+			healthCheck.Matcher = DefaultGRPCMatcher
+		}
+	} else {
+		if healthCheck.Path == "" {
+			healthCheck.Path = DefaultHealthCheckPath
+		}
+		if healthCheck.Matcher == "" {
+			healthCheck.Matcher = DefaultHTTPMatcher
+		}
+	}
+	if c.Canary != nil {
+		if c.Canary.TargetGroupName == "" {
+			c.Canary.TargetGroupName = c.ResourceName(ResourceCanaryTargetGroup)
+		}
+		if c.Canary.AutoScalingGroupName == "" {
+			c.Canary.AutoScalingGroupName = c.ResourceName(ResourceCanaryGroup)
+		}
+		if c.Canary.Size == 0 {
+			c.Canary.Size = 1
+		}
+	}
+	for i := range c.HostRules {
+		if c.HostRules[i].Priority == 0 {
+			c.HostRules[i].Priority = DefaultHostRulePriority + int32(i)*10
+		}
+	}
+	for i := range c.LambdaTargets {
+		lambdaTarget := &c.LambdaTargets[i]
+		if lambdaTarget.Name == "" {
+			lambdaTarget.Name = c.ResourceName(fmt.Sprintf("%s-%d", ResourceLambda, i))
+		}
+		if lambdaTarget.Priority == 0 {
+			lambdaTarget.Priority = DefaultLambdaRulePriority + int32(i)*10
+		}
+	}
+	if c.ECS != nil {
+		if c.ECS.ClusterName == "" {
+			c.ECS.ClusterName = c.ResourceName(ResourceECSCluster)
+		}
+		if c.ECS.CapacityProviderName == "" {
+			c.ECS.CapacityProviderName = c.ResourceName(ResourceCapacityProvider)
+		}
+		if c.ECS.TargetCapacity == 0 {
+			c.ECS.TargetCapacity = DefaultECSTargetCapacity
+		}
+		if c.LaunchTemplate.AMIParameter == DefaultAMIParameter {
+			c.LaunchTemplate.AMIParameter = ecsAMIParameter(c.LaunchTemplate.InstanceType)
+		}
+		c.LaunchTemplate.ECSCluster = c.ECS.ClusterName
+	}
+	if windows := c.Windows; windows != nil {
+		if windows.Version == "" {
+			windows.Version = DefaultWindowsVersion
+		}
+		if c.LaunchTemplate.AMIParameter == DefaultAMIParameter {
+			c.LaunchTemplate.AMIParameter = windows.AMIParameter()
+		}
+		if c.LaunchTemplate.UserDataFile == UserDataScript {
+			c.LaunchTemplate.UserDataFile = WindowsUserDataScript
+		}
+		if c.TargetGroup.Port == AWSTargetGroupPort {
+			c.TargetGroup.Port = DefaultWindowsTargetGroupPort
+		}
+		if c.AutoScaling.HealthCheckGracePeriod == AWSHealthCheckGracePeriod {
+			c.AutoScaling.HealthCheckGracePeriod = DefaultWindowsHealthCheckGracePeriod
+		}
+		if c.SmokeTest.TimeoutSeconds == DefaultSmokeTestTimeout {
+			c.SmokeTest.TimeoutSeconds = DefaultWindowsSmokeTestTimeout
+		}
+		if windows.RDPCIDR != "" {
+			c.SecurityGroup.AddedIngress = []SecurityGroupRule{windows.RDPRule()}
+		}
+		c.LaunchTemplate.Windows = true
+	}
+	if placementGroup := c.PlacementGroup; placementGroup != nil {
+		if placementGroup.Name == "" {
+			placementGroup.Name = c.ResourceName(ResourcePlacementGroup)
+		}
+		if placementGroup.Strategy == PlacementStrategyPartition && placementGroup.PartitionCount == 0 {
+			placementGroup.PartitionCount = 2
+		}
+		c.LaunchTemplate.PlacementGroup = placementGroup.Name
+	}
+	for i := range c.LaunchTemplate.DataVolumes {
+		if volume := &c.LaunchTemplate.DataVolumes[i]; volume.FileSystem == "" {
+			volume.FileSystem = DefaultDataVolumeFileSystem
+		}
+	}
+	for i := range c.ElasticIPs {
+		c.ElasticIPs[i].TagName = c.ResourceName(ResourceElasticIP + "-" + c.ElasticIPs[i].Name)
+	}
+	if events := c.LifecycleEvents; events != nil {
+		if events.RuleName == "" {
+			events.RuleName = c.ResourceName(ResourceLifecycleEvents)
+		}
+		if events.CreatesTopic() && events.TopicName == "" {
+			events.TopicName = c.ResourceName(ResourceLifecycleEvents)
+		}
+	}
+	if endpoint := c.InstanceConnectEndpoint; endpoint != nil && endpoint.SecurityGroupName == "" {
+		endpoint.SecurityGroupName = c.ResourceName(ResourceInstanceConnect)
+	}
+	if bastion := c.Bastion; bastion != nil {
+		if bastion.InstanceType == "" {
+			bastion.InstanceType = DefaultBastionInstanceType
+		}
+		if bastion.AMIParameter == "" {
+			bastion.AMIParameter = DefaultAMIParameter
+		}
+		if bastion.Name == "" {
+			bastion.Name = c.ResourceName(ResourceBastion)
+		}
+		if bastion.SecurityGroupName == "" {
+			bastion.SecurityGroupName = c.ResourceName(ResourceBastionSecurityGroup)
+		}
+	}
+	if encryption := c.VolumeEncryption; encryption != nil {
+		if encryption.CreatesKey() && encryption.Alias == "" {
+			encryption.Alias = "alias/" + c.ResourceName(ResourceVolumeKey)
+		}
+		if encryption.CreatesKey() && encryption.DeletionWindowDays == 0 {
+			encryption.DeletionWindowDays = DefaultKeyDeletionWindowDays
+		}
+		// The root volume is listed in the launch template even without
+		// overrides, so it is encrypted with the key too.
+		if c.LaunchTemplate.RootVolume == nil {
+			c.LaunchTemplate.RootVolume = &RootVolumeConfig{}
+			if c.LaunchTemplate.Windows {
+				c.LaunchTemplate.RootVolume.DeviceName = WindowsRootDeviceName
+			}
+		}
+		c.LaunchTemplate.RootVolume.Encrypted = true
+		for i := range c.LaunchTemplate.DataVolumes {
+			c.LaunchTemplate.DataVolumes[i].Encrypted = true
+		}
+	}
+	if requirements := c.AutoScaling.InstanceRequirements; requirements != nil && len(requirements.CPUManufacturers) == 0 {
+		requirements.CPUManufacturers = architectureCPUManufacturers(InstanceArchitecture(c.LaunchTemplate.InstanceType))
+	}
+	for i := range c.Secrets {
+		secret := &c.Secrets[i]
+		if secret.Store == "" {
+			secret.Store = SecretStoreSSM
+		}
+		if secret.Path == "" {
+			secret.Path = "/" + c.ResourceName(ResourceSecrets) + "/" + secret.Name
+		}
+		if secret.FromEnv == "" {
+			secret.FromEnv = secret.Name
+		}
+		secret.Region = c.Region
+	}
+	c.LaunchTemplate.Secrets = c.Secrets
+	if logs := c.Logs; logs != nil {
+		if logs.RetentionDays == nil {
+			retentionDays := int32(DefaultLogRetentionDays)
+			logs.RetentionDays = &retentionDays
+		}
+		appLogFiles := DefaultAppLogFiles
+		if c.App != nil {
+			appLogFiles = DefaultContainerLogFiles
+		}
+		for _, group := range []struct {
+			config   *LogGroupConfig
+			resource string
+			files    []string
+		}{
+			{&logs.App, ResourceAppLogs, appLogFiles},
+			{&logs.Access, ResourceAccessLogs, DefaultAccessLogFiles},
+			{&logs.System, ResourceSystemLogs, DefaultSystemLogFiles},
+		} {
+			if group.config.Name == "" {
+				group.config.Name = c.ResourceName(group.resource)
+			}
+			if len(group.config.Files) == 0 {
+				group.config.Files = group.files
+			}
+			if group.config.RetentionDays == nil {
+				group.config.RetentionDays = logs.RetentionDays
+			}
+		}
+		c.LaunchTemplate.LogGroups = logs.Groups()
+	}
+	if c.NeedsInstanceRole() {
+		if c.LaunchTemplate.InstanceRoleName == "" {
+			c.LaunchTemplate.InstanceRoleName = c.ResourceName(ResourceInstanceRole)
+		}
+		if c.LaunchTemplate.IAMInstanceProfile == "" {
+			c.LaunchTemplate.IAMInstanceProfile = c.LaunchTemplate.InstanceRoleName
+		}
+	}
+	if codeDeploy := c.CodeDeploy; codeDeploy != nil {
+		if codeDeploy.ApplicationName == "" {
+			codeDeploy.ApplicationName = c.ResourceName(ResourceCodeDeploy)
+		}
+		if codeDeploy.DeploymentGroupName == "" {
+			codeDeploy.DeploymentGroupName = c.AutoScaling.Name
+		}
+		if codeDeploy.ServiceRoleName == "" {
+			codeDeploy.ServiceRoleName = c.ResourceName(ResourceCodeDeployRole)
+		}
+		if codeDeploy.DeploymentType == "" {
+			codeDeploy.DeploymentType = DeploymentTypeBlueGreen
+		}
+		if codeDeploy.DeploymentConfigName == "" {
+			codeDeploy.DeploymentConfigName = DefaultDeploymentConfigName
+		}
+		if codeDeploy.TerminationWaitMinutes == 0 {
+			codeDeploy.TerminationWaitMinutes = DefaultTerminationWaitMinutes
+		}
+	}
+	if app := c.App; app != nil {
+		if app.RestartPolicy == "" {
+			app.RestartPolicy = DefaultAppRestartPolicy
+		}
+		if app.ContainerName == "" {
+			app.ContainerName = DefaultAppContainerName
+		}
+		if len(app.Ports) == 0 {
+			app.Ports = []AppPort{{ContainerPort: c.TargetGroup.Port}}
+		}
+		for i := range app.Ports {
+			if app.Ports[i].HostPort == 0 {
+				app.Ports[i].HostPort = app.Ports[i].ContainerPort
+			}
+		}
+		app.SecretNames = SecretNames(c.Secrets)
+		c.LaunchTemplate.App = app
+	}
+	if c.Listener.MutualTLS != nil && c.Listener.MutualTLS.TrustStoreName == "" {
+		c.Listener.MutualTLS.TrustStoreName = c.ResourceName(ResourceTrustStore)
+	}
+	for i := range c.AdditionalListeners {
+		listener := &c.AdditionalListeners[i]
+		if listener.CertificateARN != "" && listener.SSLPolicy == "" {
+			listener.SSLPolicy = c.Listener.SSLPolicy
+		}
+		if redirect := listener.Redirect; redirect != nil {
+			if redirect.Protocol == "" {
+				redirect.Protocol = c.Listener.Protocol()
+			}
+			if redirect.Port == 0 {
+				redirect.Port = c.Listener.Port
+			}
+			if redirect.StatusCode == 0 {
+				redirect.StatusCode = RedirectStatusCodes[0]
+			}
+		}
+	}
+	if auth := c.Listener.Authentication; auth != nil && auth.OnUnauthenticatedRequest == "" {
+		auth.OnUnauthenticatedRequest = "authenticate"
+	}
+	if c.State.Key == "" {
+		c.State.Key = c.ResourceName(ResourceState) + ".json"
+	}
+}
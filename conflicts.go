@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// NameConflict is a resource the stack would create under a name that is
+// already taken in the account and region.
+type NameConflict struct {
+	Kind string
+	Name string
+	// ID identifies the existing resource.
+	ID string
+}
+
+func (c NameConflict) String() string {
+	return fmt.Sprintf("%s %s already exists (%s)", c.Kind, c.Name, c.ID)
+}
+
+// FindNameConflicts looks up the region-wide names the first apply of cfg
+// would create: the load balancer, the target groups, the launch template
+// and the autoscaling groups. In an existing VPC it also looks up the names
+// of the security groups, which are scoped to the VPC; in a new VPC they
+// can't be taken yet. The names of the other resources are only created when
+// missing.
+func FindNameConflicts(ctx context.Context, clients *Clients, cfg *Config) ([]NameConflict, error) {
+	var conflicts []NameConflict
+
+	loadBalancers, err := clients.ELB.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		Names: []string{cfg.LoadBalancer.Name},
+	})
+	switch {
+	case err != nil && !hasErrorCode(err, "LoadBalancerNotFound"):
+		return nil, fmt.Errorf("error describing load balancer %s: %w", cfg.LoadBalancer.Name, err)
+	case err == nil && len(loadBalancers.LoadBalancers) > 0:
+		conflicts = append(conflicts, NameConflict{Kind: "load balancer", Name: cfg.LoadBalancer.Name, ID: aws.StringValue(loadBalancers.LoadBalancers[0].LoadBalancerArn)})
+	}
+
+	// A single unknown name fails the whole call, so every target group is
+	// looked up on its own.
+	targetGroupNames := []string{cfg.TargetGroup.Name}
+	if cfg.Canary != nil {
+		targetGroupNames = append(targetGroupNames, cfg.Canary.TargetGroupName)
+	}
+	for _, name := range targetGroupNames {
+		targetGroups, err := clients.ELB.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+			Names: []string{name},
+		})
+		switch {
+		case err != nil && !hasErrorCode(err, "TargetGroupNotFound"):
+			return nil, fmt.Errorf("error describing target group %s: %w", name, err)
+		case err == nil && len(targetGroups.TargetGroups) > 0:
+			conflicts = append(conflicts, NameConflict{Kind: "target group", Name: name, ID: aws.StringValue(targetGroups.TargetGroups[0].TargetGroupArn)})
+		}
+	}
+
+	// An existing launch template is meant to be there.
+	if cfg.LaunchTemplate.Existing == nil {
+		launchTemplates, err := clients.EC2.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+			Filters: []types.Filter{{Name: aws.String("launch-template-name"), Values: []string{cfg.LaunchTemplate.Name}}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing launch template %s: %w", cfg.LaunchTemplate.Name, err)
+		}
+		for _, launchTemplate := range launchTemplates.LaunchTemplates {
+			conflicts = append(conflicts, NameConflict{Kind: "launch template", Name: cfg.LaunchTemplate.Name, ID: aws.StringValue(launchTemplate.LaunchTemplateId)})
+		}
+	}
+
+	groupNames := []string{cfg.AutoScaling.Name}
+	if cfg.Canary != nil {
+		groupNames = append(groupNames, cfg.Canary.AutoScalingGroupName)
+	}
+	groups, err := clients.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: groupNames,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing autoscaling groups: %w", err)
+	}
+	for _, group := range groups.AutoScalingGroups {
+		conflicts = append(conflicts, NameConflict{Kind: "autoscaling group", Name: aws.StringValue(group.AutoScalingGroupName), ID: aws.StringValue(group.AutoScalingGroupARN)})
+	}
+
+	if cfg.VPC.Existing() {
+		securityGroupConflicts, err := findSecurityGroupConflicts(ctx, clients, cfg)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, securityGroupConflicts...)
+	}
+
+	return conflicts, nil
+}
+
+// findSecurityGroupConflicts looks up the names of the security groups the
+// stack would create in the existing VPC of cfg.
+func findSecurityGroupConflicts(ctx context.Context, clients *Clients, cfg *Config) ([]NameConflict, error) {
+	var vpcID string
+	if cfg.VPC.Resolved != nil {
+		vpcID = cfg.VPC.Resolved.ID
+	} else {
+		vpc, err := findExistingVPC(ctx, clients, cfg.VPC)
+		if err != nil {
+			return nil, err
+		}
+		vpcID = aws.StringValue(vpc.VpcId)
+	}
+
+	var names []string
+	if cfg.SecurityGroup.Existing == nil {
+		names = append(names, cfg.SecurityGroup.Name)
+	}
+	if len(cfg.VPC.InterfaceEndpoints()) > 0 {
+		names = append(names, cfg.ResourceName(ResourceEndpoints))
+	}
+	if cfg.Bastion != nil {
+		names = append(names, cfg.Bastion.SecurityGroupName)
+	}
+	if cfg.InstanceConnectEndpoint != nil {
+		names = append(names, cfg.InstanceConnectEndpoint.SecurityGroupName)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	securityGroups, err := clients.EC2.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+			{Name: aws.String("group-name"), Values: names},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing security groups: %w", err)
+	}
+	var conflicts []NameConflict
+	for _, securityGroup := range securityGroups.SecurityGroups {
+		conflicts = append(conflicts, NameConflict{Kind: "security group", Name: aws.StringValue(securityGroup.GroupName), ID: aws.StringValue(securityGroup.GroupId)})
+	}
+	return conflicts, nil
+}
+
+// CheckNameConflicts fails before anything is created when a name of the
+// stack is taken, e.g. by another deployment of the same config under the
+// same stack name. A lookup that fails is only logged, like the quotas.
+func CheckNameConflicts(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config) error {
+	conflicts, err := FindNameConflicts(ctx, clients, cfg)
+	if err != nil {
+		logger.Printf("Skipping name conflict check: %v", err)
+		return nil
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	descriptions := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		descriptions = append(descriptions, conflict.String())
+	}
+	return fmt.Errorf("names of stack %s are already taken (deploy it under another name with --stack or naming.stack):\n  - %s", cfg.Naming.Stack, strings.Join(descriptions, "\n  - "))
+}
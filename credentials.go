@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	EnvProfile             = "AWS_PROFILE"
+	DefaultRoleSessionName = "aws-autoscaling-pzc"
+)
+
+// AssumeRoleCredentials returns cached credentials of opts.RoleARN, assumed
+// with the credentials already present in cfg (default chain or --profile).
+func AssumeRoleCredentials(cfg awsv2.Config, opts *GlobalOptions) awsv2.CredentialsProvider {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), opts.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = opts.RoleSessionName
+		if opts.ExternalID != "" {
+			o.ExternalID = aws.String(opts.ExternalID)
+		}
+		if opts.MFASerial != "" {
+			o.SerialNumber = aws.String(opts.MFASerial)
+			o.TokenProvider = MFATokenProvider(opts.MFAToken)
+		}
+	})
+
+	return awsv2.NewCredentialsCache(provider)
+}
+
+// MFATokenProvider returns the token passed with --mfa-token, or asks for
+// one on stdin when none was given. It is used both for --role-arn with
+// --mfa-serial and for profiles that set mfa_serial.
+func MFATokenProvider(token string) func() (string, error) {
+	return func() (string, error) {
+		if token != "" {
+			return token, nil
+		}
+
+		fmt.Fprint(os.Stderr, "MFA token code: ")
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("error reading MFA token code: %w", err)
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return "", fmt.Errorf("the role requires MFA, pass the token code with --mfa-token")
+		}
+
+		return answer, nil
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	DefaultDataVolumeFileSystem = "xfs"
+	MaxDataVolumeSizeGiB        = 16384
+)
+
+// DataVolumeFileSystems are the file systems a data volume is formatted
+// with.
+var DataVolumeFileSystems = []string{"xfs", "ext4"}
+
+// VolumeTypes are the EBS volume types.
+var VolumeTypes = []string{"gp2", "gp3", "io1", "io2", "st1", "sc1", "standard"}
+
+// dataVolumeDevicePattern matches the device names recommended for EBS
+// volumes next to the root volume.
+var dataVolumeDevicePattern = regexp.MustCompile(`^/dev/(sd[f-p]|xvd[b-z])$`)
+
+// DataVolumeConfig is an EBS volume attached to every instance next to the
+// root volume. With a MountPoint the user data formats it on first use and
+// mounts it.
+type DataVolumeConfig struct {
+	// DeviceName is the block device, e.g. /dev/sdf. Amazon Linux links it
+	// to the NVMe device on Nitro instances.
+	DeviceName string `json:"deviceName"`
+	VolumeType string `json:"volumeType"`
+	SizeGiB    int32  `json:"sizeGiB"`
+	IOPS       int32  `json:"iops"`
+	Throughput int32  `json:"throughput"`
+	Encrypted  bool   `json:"encrypted"`
+	// DeleteOnTermination deletes the volume with the instance, true by
+	// default.
+	DeleteOnTermination *bool  `json:"deleteOnTermination"`
+	MountPoint          string `json:"mountPoint"`
+	// FileSystem is xfs or ext4, xfs by default.
+	FileSystem string `json:"fileSystem"`
+}
+
+// DeletesOnTermination reports whether the volume is deleted with the
+// instance.
+func (v DataVolumeConfig) DeletesOnTermination() bool {
+	return v.DeleteOnTermination == nil || *v.DeleteOnTermination
+}
+
+func (v DataVolumeConfig) String() string {
+	description := fmt.Sprintf("%s %d GiB", v.DeviceName, v.SizeGiB)
+	if v.VolumeType != "" {
+		description += " " + v.VolumeType
+	}
+	if v.MountPoint != "" {
+		description += " on " + v.MountPoint
+	}
+	return description
+}
+
+func (v DataVolumeConfig) blockDeviceMapping() types.LaunchTemplateBlockDeviceMappingRequest {
+	ebs := &types.LaunchTemplateEbsBlockDeviceRequest{
+		DeleteOnTermination: aws.Bool(v.DeletesOnTermination()),
+		Encrypted:           aws.Bool(v.Encrypted),
+		VolumeSize:          aws.Int32(v.SizeGiB),
+	}
+	if v.VolumeType != "" {
+		ebs.VolumeType = types.VolumeType(v.VolumeType)
+	}
+	if v.IOPS > 0 {
+		ebs.Iops = aws.Int32(v.IOPS)
+	}
+	if v.Throughput > 0 {
+		ebs.Throughput = aws.Int32(v.Throughput)
+	}
+	return types.LaunchTemplateBlockDeviceMappingRequest{
+		DeviceName: aws.String(v.DeviceName),
+		Ebs:        ebs,
+	}
+}
+
+// dataVolumesScript formats the data volumes that have no file system yet
+// and mounts them, waiting for their devices to show up first. The mounts
+// are added to /etc/fstab with nofail, so they come back after a reboot.
+const dataVolumesScript = `mount_data_volume() {
+  for _ in $(seq 60); do
+    [ -b "$1" ] && break
+    sleep 1
+  done
+  device="$(readlink -f "$1")"
+  if ! blkid "$device" >/dev/null; then
+    mkfs -t "$3" "$device" || exit 1
+  fi
+  mkdir -p "$2"
+  uuid="$(blkid -s UUID -o value "$device")"
+  grep -q "UUID=$uuid " /etc/fstab || echo "UUID=$uuid $2 $3 defaults,nofail 0 2" >> /etc/fstab
+  mount "$2" || exit 1
+}
+`
+
+// DataVolumesUserData prepends mounting the data volumes with a mount point
+// to the script.
+func DataVolumesUserData(volumes []DataVolumeConfig, script []byte) []byte {
+	var userData strings.Builder
+	userData.WriteString("#!/bin/bash\n")
+	userData.WriteString(dataVolumesScript)
+	for _, volume := range volumes {
+		if volume.MountPoint != "" {
+			fmt.Fprintf(&userData, "mount_data_volume %s %s %s\n", shellQuote(volume.DeviceName), shellQuote(volume.MountPoint), volume.FileSystem)
+		}
+	}
+	userData.Write(stripShebang(script))
+	return []byte(userData.String())
+}
+
+// mountsDataVolumes reports whether the user data mounts any of the volumes.
+func mountsDataVolumes(volumes []DataVolumeConfig) bool {
+	for _, volume := range volumes {
+		if volume.MountPoint != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -1,5 +1,5 @@
 #!/bin/bash
 
-go build main.go
+go build -o main .
 ./main
-rm -rf main
\ No newline at end of file
+rm -rf main
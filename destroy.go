@@ -0,0 +1,690 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	DestroyTimeout      = 20 * time.Minute
+	DestroyPollInterval = 10 * time.Second
+)
+
+// Destroy deletes every resource recorded in state, in the reverse order of
+// creation, and clears each one from the state as soon as it is gone. It
+// works on partially created stacks too, and resources already deleted
+// outside of the tool are skipped. Custom steps are destroyed first, then
+// the built-in steps in DestroyOrder.
+func Destroy(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	if err := DestroyCustomSteps(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	order, err := DestroyOrder()
+	if err != nil {
+		return err
+	}
+	for _, step := range order {
+		if destroy, ok := destroySteps[step]; ok {
+			if err := destroy(ctx, logger, clients, cfg, state); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := state.Record(func(s *State) { s.CompletedSteps = nil }); err != nil {
+		return err
+	}
+
+	logger.Println("All AWS resources deleted successfully")
+	return nil
+}
+
+// deletedAfter are steps whose resource AWS only deletes once the resource
+// of another step is gone, although they are created after it: a network ACL
+// stays associated with the subnets until they are deleted.
+var deletedAfter = map[string]string{
+	"Network ACL": "Subnets",
+}
+
+// DestroyOrder returns the built-in steps in the order Destroy deletes their
+// resources, the reverse of stepDependencies: a step comes before the steps
+// it depends on, and of the steps free to go, the one ApplySteps lists last
+// goes first. A step of deletedAfter follows its step right away instead.
+func DestroyOrder() ([]string, error) {
+	dependents := map[string]int{}
+	for _, step := range ApplySteps {
+		for _, dependency := range stepDependencies[step] {
+			if dependency != deletedAfter[step] {
+				dependents[dependency]++
+			}
+		}
+	}
+
+	var order []string
+	take := func(step string) {
+		order = append(order, step)
+		for _, dependency := range stepDependencies[step] {
+			if dependency != deletedAfter[step] {
+				dependents[dependency]--
+			}
+		}
+	}
+	remaining := slices.DeleteFunc(slices.Clone(ApplySteps), func(step string) bool { return deletedAfter[step] != "" })
+	for len(remaining) > 0 {
+		i := len(remaining) - 1
+		for i >= 0 && dependents[remaining[i]] > 0 {
+			i--
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("steps %s depend on each other in a cycle", strings.Join(remaining, ", "))
+		}
+		step := remaining[i]
+		remaining = slices.Delete(remaining, i, i+1)
+		take(step)
+		for _, after := range ApplySteps {
+			if deletedAfter[after] == step {
+				take(after)
+			}
+		}
+	}
+	return order, nil
+}
+
+// destroySteps delete the resources the built-in steps recorded in state
+// and clear them from it. Steps that record nothing of their own, like the
+// smoke test, have none.
+var destroySteps = map[string]func(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error{
+	"CodeDeploy": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return deleteCodeDeploy(ctx, logger, clients, state)
+	},
+	"Host rules": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteHostRules(ctx, logger, clients.ELB, state)
+	},
+	"Lambda targets": destroyLambdaTargets,
+	"Additional listeners": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteAdditionalListeners(ctx, logger, clients.ELB, state)
+	},
+	"Listener":            destroyListener,
+	"Trust store":         destroyTrustStore,
+	"Load balancer":       destroyLoadBalancer,
+	"Canary group":        destroyCanaryGroup,
+	"Capacity provider":   destroyCapacityProvider,
+	"Autoscaling group":   destroyAutoscalingGroup,
+	"Target group":        destroyTargetGroup,
+	"Canary target group": destroyCanaryTargetGroup,
+	"Launch template":     destroyLaunchTemplate,
+	"Placement group":     destroyPlacementGroup,
+	"Volume key":          destroyVolumeKey,
+	"Elastic IPs": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return ReleaseElasticIPs(ctx, logger, clients.EC2, state, nil)
+	},
+	"Bastion": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteBastion(ctx, logger, clients.EC2, state)
+	},
+	"Instance Connect": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteInstanceConnect(ctx, logger, clients.EC2, state)
+	},
+	"Lifecycle events": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteLifecycleEvents(ctx, logger, clients, state)
+	},
+	"Instance role": destroyInstanceRole,
+	"Secrets": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		_, err := DeleteSecrets(ctx, logger, clients, state, nil)
+		return err
+	},
+	"Log groups": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return DeleteLogGroups(ctx, logger, clients.Logs, state, nil)
+	},
+	"ECS cluster":      destroyECSCluster,
+	"VPC endpoints":    destroyVPCEndpoints,
+	"Security group":   destroySecurityGroup,
+	"Subnets":          destroySubnets,
+	"Network ACL":      destroyNetworkACL,
+	"VPC":              destroyVPC,
+	"Route table":      destroyRouteTable,
+	"Internet gateway": destroyInternetGateway,
+	"Flow logs": func(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+		return deleteFlowLogs(ctx, logger, clients, state)
+	},
+}
+
+func destroyLambdaTargets(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	for len(state.LambdaTargets) > 0 {
+		if err := DeleteLambdaTarget(ctx, logger, clients, state.LambdaTargets[0]); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.LambdaTargets = s.LambdaTargets[1:] }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyListener(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.ListenerARN != "" {
+		if _, err := clients.ELB.DeleteListener(ctx, &elasticloadbalancingv2.DeleteListenerInput{
+			ListenerArn: aws.String(state.ListenerARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting listener: %w", err)
+		}
+		logger.Printf("Listener %s deleted", state.ListenerARN)
+		if err := state.Record(func(s *State) { s.ListenerARN = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyTrustStore(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.TrustStoreARN != "" {
+		if _, err := clients.ELB.DeleteTrustStore(ctx, &elasticloadbalancingv2.DeleteTrustStoreInput{
+			TrustStoreArn: aws.String(state.TrustStoreARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting trust store: %w", err)
+		}
+		logger.Printf("Trust store %s deleted", state.TrustStoreARN)
+		if err := state.Record(func(s *State) { s.TrustStoreARN = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyLoadBalancer(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.LoadBalancerARN != "" {
+		if _, err := clients.ELB.DeleteLoadBalancer(ctx, &elasticloadbalancingv2.DeleteLoadBalancerInput{
+			LoadBalancerArn: aws.String(state.LoadBalancerARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting load balancer: %w", err)
+		}
+		waiter := elasticloadbalancingv2.NewLoadBalancersDeletedWaiter(clients.ELB)
+		if err := waiter.Wait(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			LoadBalancerArns: []string{state.LoadBalancerARN},
+		}, DestroyTimeout); err != nil {
+			return fmt.Errorf("error waiting for load balancer deletion: %w", err)
+		}
+		logger.Printf("Load balancer %s deleted", state.LoadBalancerARN)
+		if err := state.Record(func(s *State) {
+			s.LoadBalancerARN = ""
+			s.LoadBalancerDNSName = ""
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyCanaryGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.CanaryAutoScalingGroupName != "" {
+		if err := deleteAutoscalingGroup(ctx, logger, clients.AutoScaling, state.CanaryAutoScalingGroupName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.CanaryAutoScalingGroupName = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyCapacityProvider(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.CapacityProviderName != "" {
+		if err := deleteCapacityProvider(ctx, logger, clients.ECS, state.ECSClusterARN, state.CapacityProviderName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.CapacityProviderName = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyAutoscalingGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.AutoScalingGroupName != "" {
+		if err := deleteAutoscalingGroup(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) {
+			s.AutoScalingGroupName = ""
+			s.ScalingPolicyName = ""
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyTargetGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.TargetGroupARN != "" {
+		if _, err := clients.ELB.DeleteTargetGroup(ctx, &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: aws.String(state.TargetGroupARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting target group: %w", err)
+		}
+		logger.Printf("Target group %s deleted", state.TargetGroupARN)
+		if err := state.Record(func(s *State) { s.TargetGroupARN = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyCanaryTargetGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.CanaryTargetGroupARN != "" {
+		if _, err := clients.ELB.DeleteTargetGroup(ctx, &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: aws.String(state.CanaryTargetGroupARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting canary target group: %w", err)
+		}
+		logger.Printf("Canary target group %s deleted", state.CanaryTargetGroupARN)
+		if err := state.Record(func(s *State) {
+			s.CanaryTargetGroupARN = ""
+			s.CanaryWeight = 0
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyLaunchTemplate(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.ExistingLaunchTemplate {
+		logger.Printf("Launch template %s existed before the stack, leaving it in place", state.LaunchTemplateID)
+		if err := state.Record(func(s *State) {
+			s.LaunchTemplateID = ""
+			s.LaunchTemplateVersion = ""
+			s.ExistingLaunchTemplate = false
+		}); err != nil {
+			return err
+		}
+	}
+
+	if state.LaunchTemplateID != "" {
+		if _, err := clients.EC2.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(state.LaunchTemplateID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting launch template: %w", err)
+		}
+		logger.Printf("Launch template %s deleted", state.LaunchTemplateID)
+		if err := state.Record(func(s *State) {
+			s.LaunchTemplateID = ""
+			s.LaunchTemplateVersion = ""
+			s.LaunchTemplateDataHash = ""
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyPlacementGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.PlacementGroupName != "" {
+		if err := deletePlacementGroup(ctx, logger, clients.EC2, state.PlacementGroupName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.PlacementGroupName = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyVolumeKey(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	if state.VolumeKeyARN != "" {
+		deletionWindowDays := int32(DefaultKeyDeletionWindowDays)
+		if cfg.VolumeEncryption != nil && cfg.VolumeEncryption.CreatesKey() {
+			deletionWindowDays = cfg.VolumeEncryption.DeletionWindowDays
+		}
+		if err := DeleteVolumeKey(ctx, logger, clients.KMS, state, deletionWindowDays); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) {
+			s.VolumeKeyARN = ""
+			s.VolumeKeyAlias = ""
+			s.VolumeKeyGrantID = ""
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyInstanceRole(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.InstanceRoleName != "" {
+		if err := DeleteInstanceRole(ctx, logger, clients.IAM, state.InstanceRoleName); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.InstanceRoleName = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyECSCluster(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.ECSClusterARN != "" {
+		if err := deleteECSCluster(ctx, logger, clients.ECS, state.ECSClusterARN); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { s.ECSClusterARN = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyVPCEndpoints(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	// Network interfaces of the load balancer and the instances can linger
+	// for a while after they are gone, so the network resources are retried
+	// until their dependencies are released.
+	if len(state.VPCEndpointIDs) > 0 {
+		output, err := clients.EC2.DeleteVpcEndpoints(ctx, &ec2.DeleteVpcEndpointsInput{
+			VpcEndpointIds: state.VPCEndpointIDs,
+		})
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting VPC endpoints: %w", err)
+		}
+		if output != nil {
+			for _, item := range output.Unsuccessful {
+				if item.Error != nil && !strings.Contains(aws.StringValue(item.Error.Code), "NotFound") {
+					return fmt.Errorf("error deleting VPC endpoint %s: %s", aws.StringValue(item.ResourceId), aws.StringValue(item.Error.Message))
+				}
+			}
+		}
+		logger.Printf("VPC endpoints %s deleted", strings.Join(state.VPCEndpointIDs, ", "))
+		if err := state.Record(func(s *State) { s.VPCEndpointIDs = nil }); err != nil {
+			return err
+		}
+	}
+
+	if state.EndpointSecurityGroupID != "" {
+		if err := retryDependencyViolation(ctx, func() error {
+			_, err := clients.EC2.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+				GroupId: aws.String(state.EndpointSecurityGroupID),
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("error deleting endpoint security group: %w", err)
+		}
+		logger.Printf("Endpoint security group %s deleted", state.EndpointSecurityGroupID)
+		if err := state.Record(func(s *State) { s.EndpointSecurityGroupID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroySecurityGroup(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.ExistingSecurityGroup {
+		logger.Printf("Security group %s existed before the stack, leaving it in place", state.SecurityGroupID)
+		if err := state.Record(func(s *State) {
+			s.SecurityGroupID = ""
+			s.ExistingSecurityGroup = false
+		}); err != nil {
+			return err
+		}
+	}
+
+	if state.SecurityGroupID != "" {
+		if err := retryDependencyViolation(ctx, func() error {
+			_, err := clients.EC2.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+				GroupId: aws.String(state.SecurityGroupID),
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("error deleting security group: %w", err)
+		}
+		logger.Printf("Security group %s deleted", state.SecurityGroupID)
+		if err := state.Record(func(s *State) { s.SecurityGroupID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroySubnets(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.ExistingSubnets {
+		logger.Printf("Subnets %s existed before the stack, leaving them in place", strings.Join(state.SubnetIDs, ", "))
+		if err := state.Record(func(s *State) {
+			s.SubnetIDs = nil
+			s.ExistingSubnets = false
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, subnetIDs := range []*[]string{&state.SubnetIDs, &state.PrivateSubnetIDs} {
+		for len(*subnetIDs) > 0 {
+			subnetID := (*subnetIDs)[0]
+			if err := retryDependencyViolation(ctx, func() error {
+				_, err := clients.EC2.DeleteSubnet(ctx, &ec2.DeleteSubnetInput{
+					SubnetId: aws.String(subnetID),
+				})
+				return err
+			}); err != nil {
+				return fmt.Errorf("error deleting subnet %s: %w", subnetID, err)
+			}
+			logger.Printf("Subnet %s deleted", subnetID)
+			if err := state.Record(func(s *State) { *subnetIDs = (*subnetIDs)[1:] }); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func destroyNetworkACL(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	if state.NetworkACLID != "" {
+		if _, err := clients.EC2.DeleteNetworkAcl(ctx, &ec2.DeleteNetworkAclInput{
+			NetworkAclId: aws.String(state.NetworkACLID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting network ACL: %w", err)
+		}
+		logger.Printf("Network ACL %s deleted", state.NetworkACLID)
+		if err := state.Record(func(s *State) { s.NetworkACLID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyVPC(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	// An existing VPC the stack was deployed into stays, along with its
+	// internet gateway and route table.
+	if state.ExistingVPC {
+		logger.Printf("VPC %s, internet gateway %s and route table %s existed before the stack, leaving them in place", state.VPCID, state.InternetGatewayID, state.RouteTableID)
+		if err := state.Record(func(s *State) {
+			s.VPCID, s.InternetGatewayID, s.RouteTableID = "", "", ""
+			s.ExistingVPC = false
+		}); err != nil {
+			return err
+		}
+	}
+
+	if state.VPCID != "" {
+		if err := retryDependencyViolation(ctx, func() error {
+			_, err := clients.EC2.DeleteVpc(ctx, &ec2.DeleteVpcInput{
+				VpcId: aws.String(state.VPCID),
+			})
+			return err
+		}); err != nil {
+			return fmt.Errorf("error deleting VPC: %w", err)
+		}
+		logger.Printf("VPC %s deleted", state.VPCID)
+		if err := state.Record(func(s *State) { s.VPCID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyRouteTable(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	// The route table of an existing VPC is left to the VPC step.
+	if state.ExistingVPC {
+		return nil
+	}
+	if state.RouteTableID != "" {
+		if _, err := clients.EC2.DeleteRouteTable(ctx, &ec2.DeleteRouteTableInput{
+			RouteTableId: aws.String(state.RouteTableID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting route table: %w", err)
+		}
+		logger.Printf("Route table %s deleted", state.RouteTableID)
+		if err := state.Record(func(s *State) { s.RouteTableID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func destroyInternetGateway(ctx context.Context, logger *log.Logger, clients *Clients, _ *Config, state *State) error {
+	// The internet gateway of an existing VPC is left to the VPC step.
+	if state.ExistingVPC {
+		return nil
+	}
+	if state.InternetGatewayID != "" {
+		if _, err := clients.EC2.DetachInternetGateway(ctx, &ec2.DetachInternetGatewayInput{
+			InternetGatewayId: aws.String(state.InternetGatewayID),
+			VpcId:             aws.String(state.VPCID),
+		}); err != nil && !isNotFound(err) && !hasErrorCode(err, "Gateway.NotAttached") {
+			return fmt.Errorf("error detaching internet gateway: %w", err)
+		}
+		if _, err := clients.EC2.DeleteInternetGateway(ctx, &ec2.DeleteInternetGatewayInput{
+			InternetGatewayId: aws.String(state.InternetGatewayID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting internet gateway: %w", err)
+		}
+		logger.Printf("Internet gateway %s deleted", state.InternetGatewayID)
+		if err := state.Record(func(s *State) { s.InternetGatewayID = "" }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAutoscalingGroup force deletes the group, which also terminates its
+// instances, and waits until the group is gone.
+func deleteAutoscalingGroup(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string) error {
+	if _, err := autoscalingClient.DeleteAutoScalingGroup(ctx, &autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		ForceDelete:          aws.Bool(true),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error deleting autoscaling group: %w", err)
+	}
+	logger.Printf("Deleting autoscaling group %s and terminating its instances", autoscalingGroupName)
+
+	ticker := time.NewTicker(DestroyPollInterval)
+	defer ticker.Stop()
+	for {
+		output, err := autoscalingClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{autoscalingGroupName},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing autoscaling group: %w", err)
+		}
+		if len(output.AutoScalingGroups) == 0 {
+			logger.Printf("Autoscaling group %s deleted", autoscalingGroupName)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for autoscaling group deletion: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// retryDependencyViolation calls fn until it stops failing with
+// DependencyViolation. Not found errors count as success.
+func retryDependencyViolation(ctx context.Context, fn func() error) error {
+	ticker := time.NewTicker(DestroyPollInterval)
+	defer ticker.Stop()
+	for {
+		err := fn()
+		if err == nil || isNotFound(err) {
+			return nil
+		}
+		if !hasErrorCode(err, "DependencyViolation") {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func hasErrorCode(err error, code string) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == code
+}
+
+// isNotFound reports whether err says the resource doesn't exist anymore.
+// The services don't agree on a single error code for it.
+func isNotFound(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	message := apiErr.ErrorMessage()
+	return strings.Contains(apiErr.ErrorCode(), "NotFound") || strings.Contains(message, "not found") || strings.Contains(message, "does not exist")
+}
+
+func runDestroy(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("destroy", flag.ExitOnError)
+	opts.Register(fs)
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "destroy")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	if !*yes {
+		ok, err := Confirm(fmt.Sprintf("Delete every resource recorded in %s (VPC %s)?", state.Location(), state.VPCID))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Aborted")
+			return nil
+		}
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, DestroyTimeout)
+	defer cancelFunc()
+
+	notifier := NewNotifier(logger, cfg, "destroy")
+	notifier.Started()
+	if err := Destroy(ctx, logger, clients, cfg, state); err != nil {
+		notifier.Failed(err)
+		return err
+	}
+	notifier.Succeeded(state)
+	return nil
+}
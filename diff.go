@@ -0,0 +1,567 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+type ChangeKind int
+
+const (
+	ChangeModified ChangeKind = iota
+	ChangeAdded
+	ChangeRemoved
+)
+
+// AttributeChange is one attribute of a resource that differs between the
+// live stack and the config. Added and removed changes concern one element
+// of a set attribute, e.g. an ingress port.
+type AttributeChange struct {
+	Kind      ChangeKind
+	Attribute string
+	Live      string
+	Desired   string
+}
+
+// ResourceDiff lists the changes of one resource. Missing means the
+// resource is in the config but not in the state.
+type ResourceDiff struct {
+	Resource string
+	Missing  bool
+	Changes  []AttributeChange
+}
+
+type stackDiff struct {
+	resources []ResourceDiff
+}
+
+func (d *stackDiff) resource(name string) *ResourceDiff {
+	for i := range d.resources {
+		if d.resources[i].Resource == name {
+			return &d.resources[i]
+		}
+	}
+	d.resources = append(d.resources, ResourceDiff{Resource: name})
+	return &d.resources[len(d.resources)-1]
+}
+
+func (d *stackDiff) missing(resource string) {
+	d.resource(resource).Missing = true
+}
+
+func (d *stackDiff) compare(resource, attribute, live, desired string) {
+	if live != desired {
+		r := d.resource(resource)
+		r.Changes = append(r.Changes, AttributeChange{Kind: ChangeModified, Attribute: attribute, Live: live, Desired: desired})
+	}
+}
+
+func (d *stackDiff) compareSet(resource, attribute string, live, desired []string) {
+	for _, value := range desired {
+		if !slices.Contains(live, value) {
+			r := d.resource(resource)
+			r.Changes = append(r.Changes, AttributeChange{Kind: ChangeAdded, Attribute: attribute, Desired: value})
+		}
+	}
+	for _, value := range live {
+		if !slices.Contains(desired, value) {
+			r := d.resource(resource)
+			r.Changes = append(r.Changes, AttributeChange{Kind: ChangeRemoved, Attribute: attribute, Live: value})
+		}
+	}
+}
+
+// DiffStack compares the live resources recorded in state with cfg and
+// returns the resources that differ, in creation order. Attributes apply
+// can't change in place are compared too, they show what only recreating
+// the stack would change.
+func DiffStack(ctx context.Context, clients *Clients, cfg *Config, state *State) ([]ResourceDiff, error) {
+	var diff stackDiff
+
+	steps := []func(context.Context, *Clients, *Config, *State, *stackDiff) error{
+		diffVPC,
+		diffSecurityGroup,
+		diffLaunchTemplate,
+		diffTargetGroup,
+		diffAutoscalingGroup,
+		diffScalingPolicy,
+		diffLoadBalancer,
+		diffListener,
+		diffAdditionalListeners,
+	}
+	for _, step := range steps {
+		if err := step(ctx, clients, cfg, state, &diff); err != nil {
+			return nil, err
+		}
+	}
+
+	return diff.resources, nil
+}
+
+func diffVPC(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "vpc " + state.VPCID
+	output, err := clients.EC2.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{state.VPCID}})
+	if err != nil {
+		return fmt.Errorf("error describing VPC: %w", err)
+	}
+	diff.compare(resource, "cidrBlock", aws.StringValue(output.Vpcs[0].CidrBlock), cfg.VPC.CIDRBlock)
+
+	if subnetIDs := cfg.VPC.SubnetIDs; subnetIDs != nil {
+		diff.compare(resource, "subnetIds.autoScalingGroup", strings.Join(state.SubnetIDs, ","), strings.Join(subnetIDs.AutoScalingGroup, ","))
+		if state.LoadBalancerARN == "" {
+			return nil
+		}
+		// The load balancer lists its subnets by availability zone, so both
+		// sides are sorted.
+		loadBalancers, err := clients.ELB.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			LoadBalancerArns: []string{state.LoadBalancerARN},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing load balancer: %w", err)
+		}
+		var live []string
+		for _, zone := range loadBalancers.LoadBalancers[0].AvailabilityZones {
+			live = append(live, aws.StringValue(zone.SubnetId))
+		}
+		desired := slices.Clone(subnetIDs.LoadBalancer)
+		sort.Strings(live)
+		sort.Strings(desired)
+		diff.compare(resource, "subnetIds.loadBalancer", strings.Join(live, ","), strings.Join(desired, ","))
+		return nil
+	}
+	subnetCount := len(state.SubnetIDs) + len(state.PrivateSubnetIDs)
+	layout, err := SubnetLayout(cfg.VPC, nil)
+	if err != nil {
+		return err
+	}
+	diff.compare(resource, "subnets", strconv.Itoa(subnetCount), strconv.Itoa(len(layout)))
+
+	return nil
+}
+
+func diffSecurityGroup(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "security group " + cfg.SecurityGroup.Name
+	if cfg.SecurityGroup.Existing != nil {
+		resource = "existing security group " + state.SecurityGroupID
+	}
+	if state.SecurityGroupID == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.EC2.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{state.SecurityGroupID}})
+	if err != nil {
+		return fmt.Errorf("error describing security group: %w", err)
+	}
+	group := output.SecurityGroups[0]
+	if cfg.SecurityGroup.Existing == nil {
+		diff.compare(resource, "name", aws.StringValue(group.GroupName), cfg.SecurityGroup.Name)
+	}
+
+	var liveIngress, liveEgress []string
+	for _, permission := range group.IpPermissions {
+		liveIngress = append(liveIngress, permissionStrings(permission)...)
+	}
+	for _, permission := range group.IpPermissionsEgress {
+		liveEgress = append(liveEgress, permissionStrings(permission)...)
+	}
+	ingressRules, err := ResolvePrefixLists(ctx, clients.EC2, cfg.SecurityGroup.IngressRules())
+	if err != nil {
+		return err
+	}
+	egressRules, err := ResolvePrefixLists(ctx, clients.EC2, cfg.SecurityGroup.EgressRules())
+	if err != nil {
+		return err
+	}
+	diff.compareSet(resource, "ingress", liveIngress, DescribeRules(ingressRules))
+	diff.compareSet(resource, "egress", liveEgress, DescribeRules(egressRules))
+
+	return nil
+}
+
+func diffLaunchTemplate(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "launch template " + cfg.LaunchTemplate.Name
+	if state.LaunchTemplateID == "" {
+		diff.missing(resource)
+		return nil
+	}
+	// The data of an existing launch template isn't built from the config,
+	// the version the group uses is compared with the group.
+	if cfg.LaunchTemplate.Existing != nil {
+		return nil
+	}
+
+	output, err := clients.EC2.DescribeLaunchTemplateVersions(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId: aws.String(state.LaunchTemplateID),
+		Versions:         []string{state.CurrentLaunchTemplateVersion()},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing launch template version: %w", err)
+	}
+	if len(output.LaunchTemplateVersions) == 0 {
+		return fmt.Errorf("launch template %s version %s not found", state.LaunchTemplateID, state.CurrentLaunchTemplateVersion())
+	}
+	live := output.LaunchTemplateVersions[0].LaunchTemplateData
+
+	desired, err := LaunchTemplateData(cfg.LaunchTemplate, state.SecurityGroupID, state.VolumeKeyARN)
+	if err != nil {
+		return err
+	}
+
+	diff.compare(resource, "instanceType", string(live.InstanceType), string(desired.InstanceType))
+	diff.compare(resource, "amiId", aws.StringValue(live.ImageId), aws.StringValue(desired.ImageId))
+	if aws.StringValue(live.UserData) != aws.StringValue(desired.UserData) {
+		liveSize, desiredSize := decodedSize(live.UserData), decodedSize(desired.UserData)
+		source := cfg.LaunchTemplate.UserDataFile
+		if len(cfg.LaunchTemplate.UserDataParts) > 0 {
+			source = "userDataParts"
+		} else if cfg.App != nil {
+			source = "app"
+		}
+		diff.compare(resource, "userData", fmt.Sprintf("%d bytes", liveSize), fmt.Sprintf("%d bytes, %s changed", desiredSize, source))
+	}
+	var liveProfile string
+	if live.IamInstanceProfile != nil {
+		liveProfile = aws.StringValue(live.IamInstanceProfile.Name)
+	}
+	diff.compare(resource, "iamInstanceProfile", liveProfile, cfg.LaunchTemplate.IAMInstanceProfile)
+	if live.Monitoring != nil {
+		diff.compare(resource, "detailedMonitoring", strconv.FormatBool(aws.BoolValue(live.Monitoring.Enabled)), strconv.FormatBool(cfg.LaunchTemplate.DetailedMonitoring))
+	}
+	var liveHibernation bool
+	if live.HibernationOptions != nil {
+		liveHibernation = aws.BoolValue(live.HibernationOptions.Configured)
+	}
+	diff.compare(resource, "hibernation", strconv.FormatBool(liveHibernation), strconv.FormatBool(cfg.LaunchTemplate.Hibernation))
+	if live.MetadataOptions != nil {
+		diff.compare(resource, "metadata.httpTokens", string(live.MetadataOptions.HttpTokens), cfg.LaunchTemplate.Metadata.HTTPTokens())
+		diff.compare(resource, "metadata.hopLimit", strconv.Itoa(int(aws.Int32Value(live.MetadataOptions.HttpPutResponseHopLimit))), strconv.Itoa(int(cfg.LaunchTemplate.Metadata.HopLimit)))
+		diff.compare(resource, "metadata.instanceTags", string(live.MetadataOptions.InstanceMetadataTags), cfg.LaunchTemplate.Metadata.InstanceMetadataTags())
+	}
+
+	return nil
+}
+
+func decodedSize(encoded *string) int {
+	data, err := base64.StdEncoding.DecodeString(aws.StringValue(encoded))
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+func diffTargetGroup(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "target group " + cfg.TargetGroup.Name
+	if state.TargetGroupARN == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.ELB.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{state.TargetGroupARN},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing target group: %w", err)
+	}
+	targetGroup := output.TargetGroups[0]
+	healthCheck := cfg.TargetGroup.HealthCheck
+
+	diff.compare(resource, "name", aws.StringValue(targetGroup.TargetGroupName), cfg.TargetGroup.Name)
+	diff.compare(resource, "port", strconv.Itoa(int(aws.Int32Value(targetGroup.Port))), strconv.Itoa(int(cfg.TargetGroup.Port)))
+	diff.compare(resource, "targetType", string(targetGroup.TargetType), cfg.TargetGroup.TargetType)
+	diff.compare(resource, "protocolVersion", aws.StringValue(targetGroup.ProtocolVersion), cfg.TargetGroup.ProtocolVersion)
+	diff.compare(resource, "healthCheck.path", aws.StringValue(targetGroup.HealthCheckPath), healthCheck.Path)
+	diff.compare(resource, "healthCheck.intervalSeconds", strconv.Itoa(int(aws.Int32Value(targetGroup.HealthCheckIntervalSeconds))), strconv.Itoa(int(healthCheck.IntervalSeconds)))
+	diff.compare(resource, "healthCheck.timeoutSeconds", strconv.Itoa(int(aws.Int32Value(targetGroup.HealthCheckTimeoutSeconds))), strconv.Itoa(int(healthCheck.TimeoutSeconds)))
+	diff.compare(resource, "healthCheck.healthyThreshold", strconv.Itoa(int(aws.Int32Value(targetGroup.HealthyThresholdCount))), strconv.Itoa(int(healthCheck.HealthyThreshold)))
+	diff.compare(resource, "healthCheck.unhealthyThreshold", strconv.Itoa(int(aws.Int32Value(targetGroup.UnhealthyThresholdCount))), strconv.Itoa(int(healthCheck.UnhealthyThreshold)))
+	diff.compare(resource, "healthCheck.matcher", matcherCodes(targetGroup.Matcher), healthCheck.Matcher)
+
+	attributes, err := DescribeTargetGroupAttributes(ctx, clients.ELB, state.TargetGroupARN)
+	if err != nil {
+		return err
+	}
+	for _, attribute := range cfg.TargetGroup.Attributes() {
+		diff.compare(resource, attribute.Setting, attributes[attribute.Key], attribute.Value)
+	}
+
+	return nil
+}
+
+func diffAutoscalingGroup(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "autoscaling group " + cfg.AutoScaling.Name
+	if state.AutoScalingGroupName == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{state.AutoScalingGroupName},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return fmt.Errorf("autoscaling group %s not found", state.AutoScalingGroupName)
+	}
+	group := output.AutoScalingGroups[0]
+
+	diff.compare(resource, "name", state.AutoScalingGroupName, cfg.AutoScaling.Name)
+	diff.compare(resource, "minSize", strconv.Itoa(int(aws.Int32Value(group.MinSize))), strconv.Itoa(int(cfg.AutoScaling.MinSize)))
+	diff.compare(resource, "maxSize", strconv.Itoa(int(aws.Int32Value(group.MaxSize))), strconv.Itoa(int(cfg.AutoScaling.MaxSize)))
+	diff.compare(resource, "healthCheckType", aws.StringValue(group.HealthCheckType), cfg.AutoScaling.HealthCheckType)
+	diff.compare(resource, "healthCheckGracePeriod", strconv.Itoa(int(aws.Int32Value(group.HealthCheckGracePeriod))), strconv.Itoa(int(cfg.AutoScaling.HealthCheckGracePeriod)))
+	diff.compare(resource, "defaultCooldown", strconv.Itoa(int(aws.Int32Value(group.DefaultCooldown))), strconv.Itoa(int(cfg.AutoScaling.DefaultCooldown)))
+	diff.compare(resource, "maxInstanceLifetime", strconv.Itoa(int(aws.Int32Value(group.MaxInstanceLifetime))), strconv.Itoa(int(cfg.AutoScaling.MaxInstanceLifetime())))
+	diff.compare(resource, "newInstancesProtectedFromScaleIn", strconv.FormatBool(aws.BoolValue(group.NewInstancesProtectedFromScaleIn)), strconv.FormatBool(cfg.AutoScaling.NewInstancesProtectedFromScaleIn))
+	diff.compare(resource, "terminationPolicies", strings.Join(group.TerminationPolicies, ","), strings.Join(cfg.AutoScaling.TerminationPolicies, ","))
+	requirements := cfg.AutoScaling.InstanceRequirements
+	diff.compare(resource, "instanceRequirements",
+		describeInstanceRequirements(instanceRequirementsConfig(groupInstanceRequirements(group), requirements == nil || requirements.BurstablePerformance != "")),
+		describeInstanceRequirements(requirements))
+	if group.LaunchTemplate != nil {
+		diff.compare(resource, "launchTemplateVersion", aws.StringValue(group.LaunchTemplate.Version), state.CurrentLaunchTemplateVersion())
+	}
+
+	var liveMetrics []string
+	for _, metric := range group.EnabledMetrics {
+		liveMetrics = append(liveMetrics, aws.StringValue(metric.Metric))
+	}
+	sort.Strings(liveMetrics)
+	diff.compareSet(resource, "groupMetrics", liveMetrics, cfg.AutoScaling.GroupMetrics)
+
+	return nil
+}
+
+func diffScalingPolicy(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	// ECS scales the group of a capacity provider with its own policy.
+	if cfg.ECS != nil {
+		return nil
+	}
+	resource := "scaling policy " + cfg.AutoScaling.PolicyName
+	if state.ScalingPolicyName == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.AutoScaling.DescribePolicies(ctx, &autoscaling.DescribePoliciesInput{
+		AutoScalingGroupName: aws.String(state.AutoScalingGroupName),
+		PolicyNames:          []string{state.ScalingPolicyName},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing autoscaling policy: %w", err)
+	}
+	if len(output.ScalingPolicies) == 0 || output.ScalingPolicies[0].TargetTrackingConfiguration == nil {
+		diff.missing(resource)
+		return nil
+	}
+
+	live := output.ScalingPolicies[0].TargetTrackingConfiguration
+	desired := TargetTrackingConfiguration(cfg.AutoScaling, state.AutoScalingGroupName)
+	diff.compare(resource, "metric", DescribeTrackedMetric(live), DescribeTrackedMetric(desired))
+	diff.compare(resource, "targetValue", strconv.FormatFloat(aws.Float64Value(live.TargetValue), 'f', -1, 64), strconv.FormatFloat(cfg.AutoScaling.TargetValue(), 'f', -1, 64))
+
+	return nil
+}
+
+func diffLoadBalancer(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "load balancer " + cfg.LoadBalancer.Name
+	if state.LoadBalancerARN == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.ELB.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+		LoadBalancerArns: []string{state.LoadBalancerARN},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing load balancer: %w", err)
+	}
+	diff.compare(resource, "name", aws.StringValue(output.LoadBalancers[0].LoadBalancerName), cfg.LoadBalancer.Name)
+	diff.compare(resource, "scheme", string(output.LoadBalancers[0].Scheme), cfg.LoadBalancer.Scheme)
+
+	return nil
+}
+
+func diffListener(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	resource := "listener"
+	if state.ListenerARN == "" {
+		diff.missing(resource)
+		return nil
+	}
+
+	output, err := clients.ELB.DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{
+		ListenerArns: []string{state.ListenerARN},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing listener: %w", err)
+	}
+	listener := output.Listeners[0]
+	diff.compare(resource, "port", strconv.Itoa(int(aws.Int32Value(listener.Port))), strconv.Itoa(int(cfg.Listener.Port)))
+	diff.compare(resource, "protocol", string(listener.Protocol), cfg.Listener.Protocol())
+	if cfg.Listener.CertificateARN != "" {
+		diff.compare(resource, "sslPolicy", aws.StringValue(listener.SslPolicy), cfg.Listener.SSLPolicy)
+
+		sniCertificates, err := listenerSNICertificates(ctx, clients.ELB, state.ListenerARN)
+		if err != nil {
+			return err
+		}
+		diff.compareSet(resource, "certificates", sniCertificates, cfg.Listener.AdditionalCertificateARNs)
+	}
+
+	liveMode, desiredMode := "off", "off"
+	if listener.MutualAuthentication != nil && aws.StringValue(listener.MutualAuthentication.Mode) != "" {
+		liveMode = aws.StringValue(listener.MutualAuthentication.Mode)
+	}
+	if cfg.Listener.MutualTLS != nil {
+		desiredMode = MutualTLSModeVerify
+	}
+	diff.compare(resource, "mutualTls", liveMode, desiredMode)
+
+	liveAuth, desiredAuth := "off", "off"
+	for _, action := range listener.DefaultActions {
+		switch action.Type {
+		case elbTypes.ActionTypeEnumAuthenticateOidc:
+			liveAuth = AuthenticationOIDC
+		case elbTypes.ActionTypeEnumAuthenticateCognito:
+			liveAuth = AuthenticationCognito
+		}
+	}
+	if cfg.Listener.Authentication != nil {
+		desiredAuth = cfg.Listener.Authentication.Type
+	}
+	if cfg.Canary != nil && !state.Maintenance {
+		liveWeight := "0"
+		for _, action := range listener.DefaultActions {
+			if action.ForwardConfig == nil {
+				continue
+			}
+			for _, targetGroup := range action.ForwardConfig.TargetGroups {
+				if aws.StringValue(targetGroup.TargetGroupArn) == state.CanaryTargetGroupARN {
+					liveWeight = strconv.Itoa(int(aws.Int32Value(targetGroup.Weight)))
+				}
+			}
+		}
+		diff.compare(resource, "canaryWeight", liveWeight, strconv.Itoa(int(state.CanaryWeight)))
+	}
+
+	// The maintenance page replaces the authentication action until
+	// maintenance mode is turned off.
+	if !state.Maintenance {
+		diff.compare(resource, "authentication", liveAuth, desiredAuth)
+	}
+
+	return nil
+}
+
+func diffAdditionalListeners(ctx context.Context, clients *Clients, cfg *Config, state *State, diff *stackDiff) error {
+	for _, listenerConfig := range cfg.AdditionalListeners {
+		resource := fmt.Sprintf("listener on port %d", listenerConfig.Port)
+		listenerARN := state.AdditionalListenerARNs[listenerConfig.Port]
+		if listenerARN == "" {
+			diff.missing(resource)
+			continue
+		}
+
+		output, err := clients.ELB.DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{
+			ListenerArns: []string{listenerARN},
+		})
+		if err != nil {
+			return fmt.Errorf("error describing listener: %w", err)
+		}
+		listener := output.Listeners[0]
+		diff.compare(resource, "protocol", string(listener.Protocol), listenerConfig.Protocol())
+		if listenerConfig.CertificateARN != "" {
+			diff.compare(resource, "sslPolicy", aws.StringValue(listener.SslPolicy), listenerConfig.SSLPolicy)
+		}
+
+		liveAction, desiredAction := "", "forward"
+		if len(listener.DefaultActions) > 0 {
+			liveAction = string(listener.DefaultActions[len(listener.DefaultActions)-1].Type)
+		}
+		if listenerConfig.Redirect != nil {
+			desiredAction = "redirect"
+		}
+		// Forwarding listeners serve the maintenance page too.
+		if !state.Maintenance || listenerConfig.Redirect != nil {
+			diff.compare(resource, "defaultAction", liveAction, desiredAction)
+		}
+	}
+
+	return nil
+}
+
+// PrintDiff writes the differences in a terraform plan like layout: ~ for
+// changed attributes, + for what the config adds and - for what it removes.
+func PrintDiff(w io.Writer, resources []ResourceDiff) {
+	for _, resource := range resources {
+		if resource.Missing {
+			fmt.Fprintf(w, "+ %s (not in state)\n", resource.Resource)
+			continue
+		}
+		fmt.Fprintf(w, "~ %s\n", resource.Resource)
+		for _, change := range resource.Changes {
+			switch change.Kind {
+			case ChangeAdded:
+				fmt.Fprintf(w, "    + %s: %s\n", change.Attribute, change.Desired)
+			case ChangeRemoved:
+				fmt.Fprintf(w, "    - %s: %s\n", change.Attribute, change.Live)
+			default:
+				fmt.Fprintf(w, "    ~ %s: %s -> %s\n", change.Attribute, change.Live, change.Desired)
+			}
+		}
+	}
+}
+
+func runDiff(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	opts.Register(fs)
+	exitCode := fs.Bool("exit-code", false, "fail when the live stack differs from the config, e.g. to detect drift in CI")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	if err := ResolveAMI(ctx, logger, clients, cfg); err != nil {
+		return err
+	}
+	if err := ResolveVPC(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	resources, err := DiffStack(ctx, clients, cfg, state)
+	if err != nil {
+		return err
+	}
+	if len(resources) == 0 {
+		logger.Println("No differences, the stack matches the config")
+		return nil
+	}
+
+	PrintDiff(os.Stdout, resources)
+	if *exitCode {
+		return fmt.Errorf("%d resource(s) differ from the config", len(resources))
+	}
+
+	return nil
+}
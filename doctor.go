@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// RequiredActions lists every IAM action the tool calls, checked by doctor
+// with a policy simulation.
+var RequiredActions = []string{
+	"ec2:CreateVpc",
+	"ec2:ModifyVpcAttribute",
+	"ec2:AssociateVpcCidrBlock",
+	"ec2:DescribeVpcs",
+	"ec2:CreateFlowLogs",
+	"ec2:CreateInternetGateway",
+	"ec2:AttachInternetGateway",
+	"ec2:CreateRouteTable",
+	"ec2:CreateRoute",
+	"ec2:DescribeAvailabilityZones",
+	"ec2:CreateSubnet",
+	"ec2:CreateNetworkAcl",
+	"ec2:CreateNetworkAclEntry",
+	"ec2:DescribeNetworkAcls",
+	"ec2:ReplaceNetworkAclAssociation",
+	"ec2:CreateVpcEndpoint",
+	"ec2:DescribeRouteTables",
+	"ec2:ModifySubnetAttribute",
+	"ec2:AssociateRouteTable",
+	"ec2:CreateSecurityGroup",
+	"ec2:AuthorizeSecurityGroupIngress",
+	"ec2:AuthorizeSecurityGroupEgress",
+	"ec2:RevokeSecurityGroupEgress",
+	"ec2:DescribeManagedPrefixLists",
+	"ec2:CreatePlacementGroup",
+	"ec2:AllocateAddress",
+	"ec2:AssociateAddress",
+	"ec2:DisassociateAddress",
+	"ec2:ReleaseAddress",
+	"ec2:CreateLaunchTemplate",
+	"ec2:CreateLaunchTemplateVersion",
+	"ec2:DescribeLaunchTemplates",
+	"ec2:DescribeImages",
+	"ec2:DescribeInstanceTypes",
+	"ec2:RunInstances",
+	"ec2:DescribeInstances",
+	"ec2:TerminateInstances",
+	"ec2:RevokeSecurityGroupIngress",
+	"ec2:CreateInstanceConnectEndpoint",
+	"ec2:DescribeInstanceConnectEndpoints",
+	"ec2:DeleteInstanceConnectEndpoint",
+	"ec2:CreateTags",
+	"ec2:DeleteVpc",
+	"ec2:DeleteFlowLogs",
+	"ec2:DetachInternetGateway",
+	"ec2:DeleteInternetGateway",
+	"ec2:DeleteRouteTable",
+	"ec2:DeleteSubnet",
+	"ec2:DeleteNetworkAcl",
+	"ec2:DeleteVpcEndpoints",
+	"ec2:DeleteSecurityGroup",
+	"ec2:DeleteLaunchTemplate",
+	"ec2:DeletePlacementGroup",
+	"elasticloadbalancing:CreateTargetGroup",
+	"elasticloadbalancing:ModifyTargetGroup",
+	"elasticloadbalancing:CreateLoadBalancer",
+	"elasticloadbalancing:CreateListener",
+	"elasticloadbalancing:AddListenerCertificates",
+	"elasticloadbalancing:DescribeListenerCertificates",
+	"elasticloadbalancing:CreateTrustStore",
+	"elasticloadbalancing:DescribeTrustStores",
+	"elasticloadbalancing:DescribeLoadBalancers",
+	"elasticloadbalancing:DescribeTargetGroups",
+	"elasticloadbalancing:DescribeTargetHealth",
+	"elasticloadbalancing:AddTags",
+	"elasticloadbalancing:DeleteListener",
+	"elasticloadbalancing:DeleteTrustStore",
+	"elasticloadbalancing:ModifyListener",
+	"elasticloadbalancing:CreateRule",
+	"elasticloadbalancing:DeleteRule",
+	"elasticloadbalancing:RegisterTargets",
+	"elasticloadbalancing:DeregisterTargets",
+	"elasticloadbalancing:ModifyTargetGroupAttributes",
+	"elasticloadbalancing:DescribeTargetGroupAttributes",
+	"elasticloadbalancing:DeleteLoadBalancer",
+	"elasticloadbalancing:DeleteTargetGroup",
+	"autoscaling:CreateAutoScalingGroup",
+	"autoscaling:UpdateAutoScalingGroup",
+	"autoscaling:CreateOrUpdateTags",
+	"autoscaling:DescribeAutoScalingGroups",
+	"autoscaling:DeleteAutoScalingGroup",
+	"autoscaling:TerminateInstanceInAutoScalingGroup",
+	"autoscaling:SetDesiredCapacity",
+	"autoscaling:PutScalingPolicy",
+	"autoscaling:DescribePolicies",
+	"autoscaling:DescribeScalingActivities",
+	"autoscaling:EnableMetricsCollection",
+	"autoscaling:DisableMetricsCollection",
+	"autoscaling:StartInstanceRefresh",
+	"autoscaling:DescribeInstanceRefreshes",
+	"autoscaling:CancelInstanceRefresh",
+	"autoscaling:RollbackInstanceRefresh",
+	"cloudwatch:DescribeAlarms",
+	"pricing:GetProducts",
+	"iam:CreateServiceLinkedRole",
+	"iam:CreateRole",
+	"iam:PutRolePolicy",
+	"iam:PassRole",
+	"iam:DeleteRolePolicy",
+	"iam:DeleteRole",
+	"iam:AttachRolePolicy",
+	"iam:DetachRolePolicy",
+	"iam:ListAttachedRolePolicies",
+	"iam:ListRolePolicies",
+	"iam:CreateInstanceProfile",
+	"iam:AddRoleToInstanceProfile",
+	"iam:RemoveRoleFromInstanceProfile",
+	"iam:DeleteInstanceProfile",
+	"kms:CreateKey",
+	"kms:CreateAlias",
+	"kms:CreateGrant",
+	"kms:TagResource",
+	"kms:RevokeGrant",
+	"kms:DeleteAlias",
+	"kms:ScheduleKeyDeletion",
+	"logs:CreateLogGroup",
+	"logs:PutRetentionPolicy",
+	"logs:DeleteRetentionPolicy",
+	"logs:DeleteLogGroup",
+	"ssm:GetParameter",
+	"ssm:StartSession",
+	"ssm:PutParameter",
+	"ssm:DeleteParameter",
+	"secretsmanager:GetSecretValue",
+	"secretsmanager:CreateSecret",
+	"secretsmanager:PutSecretValue",
+	"secretsmanager:DeleteSecret",
+	"lambda:AddPermission",
+	"lambda:RemovePermission",
+	"events:PutRule",
+	"events:PutTargets",
+	"events:RemoveTargets",
+	"events:DeleteRule",
+	"events:TagResource",
+	"sns:CreateTopic",
+	"sns:SetTopicAttributes",
+	"sns:DeleteTopic",
+	"sns:TagResource",
+	"sns:Subscribe",
+	"sns:Unsubscribe",
+	"sns:GetSubscriptionAttributes",
+	"ecs:CreateCluster",
+	"ecs:DeleteCluster",
+	"ecs:CreateCapacityProvider",
+	"ecs:DeleteCapacityProvider",
+	"ecs:PutClusterCapacityProviders",
+	"ecs:TagResource",
+	"codedeploy:CreateApplication",
+	"codedeploy:CreateDeploymentGroup",
+	"codedeploy:GetDeploymentGroup",
+	"codedeploy:DeleteDeploymentGroup",
+	"codedeploy:DeleteApplication",
+}
+
+type CheckResult int
+
+const (
+	CheckOK CheckResult = iota
+	CheckWarning
+	CheckFailed
+)
+
+func (r CheckResult) String() string {
+	switch r {
+	case CheckOK:
+		return "ok"
+	case CheckWarning:
+		return "warn"
+	default:
+		return "FAIL"
+	}
+}
+
+type DoctorCheck struct {
+	Name   string
+	Result CheckResult
+	Detail string
+}
+
+// RunDoctor runs every preflight check. Checks don't stop at the first
+// failure, so a single run shows everything that needs fixing.
+func RunDoctor(ctx context.Context, clients *Clients, cfg *Config) []DoctorCheck {
+	var checks []DoctorCheck
+
+	identity, err := clients.STS.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return append(checks, DoctorCheck{Name: "Credentials", Result: CheckFailed, Detail: err.Error()})
+	}
+	callerARN := aws.StringValue(identity.Arn)
+	checks = append(checks, DoctorCheck{
+		Name:   "Credentials",
+		Result: CheckOK,
+		Detail: fmt.Sprintf("%s (account %s)", callerARN, aws.StringValue(identity.Account)),
+	})
+
+	checks = append(checks, checkPermissions(ctx, clients.IAM, callerARN))
+	checks = append(checks, checkAMI(ctx, clients, cfg))
+	if cfg.LaunchTemplate.Hibernation {
+		checks = append(checks, checkHibernation(ctx, clients, cfg))
+	}
+	if cfg.SessionManagerOnly {
+		checks = append(checks, checkSessionManagerPlugin())
+	}
+	checks = append(checks, checkNameConflicts(ctx, clients, cfg))
+	checks = append(checks, checkVPCQuota(ctx, clients))
+	checks = append(checks, checkElasticIPQuota(ctx, clients, cfg))
+
+	vcpuUsage, err := VCPUQuotaUsage(ctx, clients, cfg)
+	checks = append(checks, quotaUsageCheck("vCPU quota", vcpuUsage, err))
+	lbUsage, err := LoadBalancerQuotaUsage(ctx, clients)
+	checks = append(checks, quotaUsageCheck("Load balancer quota", lbUsage, err))
+
+	return checks
+}
+
+func checkPermissions(ctx context.Context, iamClient *iam.Client, callerARN string) DoctorCheck {
+	check := DoctorCheck{Name: "IAM permissions"}
+
+	principalARN, ok := simulationPrincipal(callerARN)
+	if !ok {
+		check.Result = CheckWarning
+		check.Detail = fmt.Sprintf("cannot simulate policies of %s", callerARN)
+		return check
+	}
+
+	var denied []string
+	paginator := iam.NewSimulatePrincipalPolicyPaginator(iamClient, &iam.SimulatePrincipalPolicyInput{
+		PolicySourceArn: aws.String(principalARN),
+		ActionNames:     RequiredActions,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			check.Result = CheckWarning
+			check.Detail = fmt.Sprintf("policy simulation not possible: %v", err)
+			return check
+		}
+		for _, result := range page.EvaluationResults {
+			if result.EvalDecision != iamTypes.PolicyEvaluationDecisionTypeAllowed {
+				denied = append(denied, aws.StringValue(result.EvalActionName))
+			}
+		}
+	}
+
+	if len(denied) > 0 {
+		check.Result = CheckFailed
+		check.Detail = "denied: " + strings.Join(denied, ", ")
+		return check
+	}
+	check.Detail = fmt.Sprintf("all %d actions allowed", len(RequiredActions))
+	return check
+}
+
+// simulationPrincipal maps the caller identity to the IAM principal whose
+// policies can be simulated. Assumed role sessions map to their role, which
+// only works for roles without a path. The root user can't be simulated.
+func simulationPrincipal(callerARN string) (string, bool) {
+	parts := strings.SplitN(callerARN, ":", 6)
+	if len(parts) != 6 {
+		return "", false
+	}
+	partition, service, account, resource := parts[1], parts[2], parts[4], parts[5]
+
+	switch {
+	case service == "iam" && strings.HasPrefix(resource, "user/"):
+		return callerARN, true
+	case service == "sts" && strings.HasPrefix(resource, "assumed-role/"):
+		role := strings.SplitN(strings.TrimPrefix(resource, "assumed-role/"), "/", 2)[0]
+		return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, role), true
+	default:
+		return "", false
+	}
+}
+
+func checkAMI(ctx context.Context, clients *Clients, cfg *Config) DoctorCheck {
+	check := DoctorCheck{Name: "AMI"}
+
+	if err := ResolveAMI(ctx, log.New(io.Discard, "", 0), clients, cfg); err != nil {
+		check.Result = CheckFailed
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.Detail = fmt.Sprintf("%s for %s in %s", cfg.LaunchTemplate.AMIID, cfg.LaunchTemplate.InstanceType, cfg.Region)
+	return check
+}
+
+func checkHibernation(ctx context.Context, clients *Clients, cfg *Config) DoctorCheck {
+	check := DoctorCheck{Name: "Hibernation"}
+
+	detail, err := CheckHibernation(ctx, clients.EC2, cfg.LaunchTemplate)
+	if err != nil {
+		check.Result = CheckFailed
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.Detail = detail
+	return check
+}
+
+// checkSessionManagerPlugin warns when connect can't attach the terminal to
+// a session, the only way into the instances.
+func checkSessionManagerPlugin() DoctorCheck {
+	check := DoctorCheck{Name: "Session Manager plugin"}
+
+	path, err := exec.LookPath(SessionManagerPlugin)
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = SessionManagerPlugin + " is not installed, connect needs it"
+		return check
+	}
+
+	check.Detail = path
+	return check
+}
+
+// checkNameConflicts only warns, the names are taken by the stack itself
+// once it is deployed.
+func checkNameConflicts(ctx context.Context, clients *Clients, cfg *Config) DoctorCheck {
+	check := DoctorCheck{Name: "Resource names"}
+
+	conflicts, err := FindNameConflicts(ctx, clients, cfg)
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = err.Error()
+		return check
+	}
+	if len(conflicts) == 0 {
+		check.Result = CheckOK
+		check.Detail = fmt.Sprintf("names of stack %s are free", cfg.Naming.Stack)
+		return check
+	}
+
+	descriptions := make([]string, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		descriptions = append(descriptions, conflict.String())
+	}
+	check.Result = CheckWarning
+	check.Detail = strings.Join(descriptions, "; ") + ", apply fails unless the stack is already deployed"
+	return check
+}
+
+func checkVPCQuota(ctx context.Context, clients *Clients) DoctorCheck {
+	check := DoctorCheck{Name: "VPC quota"}
+
+	limit, err := QuotaValue(ctx, clients.Quotas, QuotaVPCsPerRegion)
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = err.Error()
+		return check
+	}
+
+	var used int
+	paginator := ec2.NewDescribeVpcsPaginator(clients.EC2, &ec2.DescribeVpcsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			check.Result = CheckWarning
+			check.Detail = fmt.Sprintf("error describing VPCs: %v", err)
+			return check
+		}
+		used += len(page.Vpcs)
+	}
+
+	check.Detail = fmt.Sprintf("%d of %.0f used", used, limit)
+	if float64(used) >= limit {
+		check.Result = CheckFailed
+		check.Detail += ", apply needs 1 more"
+	}
+	return check
+}
+
+func checkElasticIPQuota(ctx context.Context, clients *Clients, cfg *Config) DoctorCheck {
+	check := DoctorCheck{Name: "Elastic IP quota"}
+
+	limit, err := QuotaValue(ctx, clients.Quotas, QuotaElasticIPs)
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = err.Error()
+		return check
+	}
+
+	output, err := clients.EC2.DescribeAddresses(ctx, &ec2.DescribeAddressesInput{})
+	if err != nil {
+		check.Result = CheckWarning
+		check.Detail = fmt.Sprintf("error describing addresses: %v", err)
+		return check
+	}
+
+	used := len(output.Addresses)
+	check.Detail = fmt.Sprintf("%d of %.0f used", used, limit)
+	switch needed := len(cfg.ElasticIPs); {
+	case needed > 0 && float64(used+needed) > limit:
+		check.Result = CheckFailed
+		check.Detail += fmt.Sprintf(", apply needs %d more", needed)
+	case float64(used) >= limit:
+		check.Result = CheckWarning
+		check.Detail += ", none left"
+	}
+	return check
+}
+
+func quotaUsageCheck(name string, usage QuotaUsage, err error) DoctorCheck {
+	check := DoctorCheck{Name: name}
+	switch {
+	case err != nil:
+		check.Result = CheckWarning
+		check.Detail = err.Error()
+	case usage.Exceeded():
+		check.Result = CheckFailed
+		check.Detail = usage.String()
+	default:
+		check.Detail = usage.String()
+	}
+	return check
+}
+
+func PrintDoctor(w io.Writer, checks []DoctorCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Result, check.Name, check.Detail)
+	}
+	return tw.Flush()
+}
+
+func runDoctor(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	clients, err := NewClients(ctx, logger, cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	checks := RunDoctor(ctx, clients, cfg)
+	if err := PrintDoctor(os.Stdout, checks); err != nil {
+		return err
+	}
+
+	var failed int
+	for _, check := range checks {
+		if check.Result == CheckFailed {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d preflight check(s) failed", failed)
+	}
+
+	return nil
+}
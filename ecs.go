@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecsTypes "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// ECSAMIParameter and ECSARMAMIParameter are the public SSM parameters of
+	// the latest ECS-optimized Amazon Linux 2023 AMIs, which come with the
+	// container agent.
+	ECSAMIParameter    = "/aws/service/ecs/optimized-ami/amazon-linux-2023/recommended/image_id"
+	ECSARMAMIParameter = "/aws/service/ecs/optimized-ami/amazon-linux-2023/arm64/recommended/image_id"
+	// ECSInstanceRolePolicyARN lets the container agent register the
+	// instances with the cluster.
+	ECSInstanceRolePolicyARN = "arn:aws:iam::aws:policy/service-role/AmazonEC2ContainerServiceforEC2Role"
+	DefaultECSTargetCapacity = 100
+	// ECSConfigFile is where the container agent reads its cluster from.
+	ECSConfigFile = "/etc/ecs/ecs.config"
+)
+
+// ECSConfig turns the autoscaling group into the capacity provider of an ECS
+// cluster. The instances join the cluster and ECS scales the group for the
+// tasks placed on it; the target group is left for an ECS service to
+// register its tasks with.
+type ECSConfig struct {
+	ClusterName          string `json:"clusterName"`
+	CapacityProviderName string `json:"capacityProviderName"`
+	// TargetCapacity is the percentage of the instances ECS keeps in use by
+	// tasks, below 100 leaves spare instances for new tasks.
+	TargetCapacity int32 `json:"targetCapacity"`
+	// ManagedTerminationProtection keeps ECS from scaling in instances that
+	// still run tasks. It needs
+	// autoScaling.newInstancesProtectedFromScaleIn.
+	ManagedTerminationProtection bool `json:"managedTerminationProtection"`
+}
+
+// ManagedTerminationProtectionStatus is ENABLED or DISABLED, as the capacity
+// provider expects it.
+func (e ECSConfig) ManagedTerminationProtectionStatus() string {
+	if e.ManagedTerminationProtection {
+		return string(ecsTypes.ManagedTerminationProtectionEnabled)
+	}
+	return string(ecsTypes.ManagedTerminationProtectionDisabled)
+}
+
+// ecsAMIParameter returns the ECS-optimized AMI parameter for the
+// architecture of the instance type.
+func ecsAMIParameter(instanceType string) string {
+	if InstanceArchitecture(instanceType) == ArchitectureARM {
+		return ECSARMAMIParameter
+	}
+	return ECSAMIParameter
+}
+
+// ECSUserData prepends joining the cluster to the user data script, so the
+// container agent registers the instance with clusterName.
+func ECSUserData(clusterName string, script []byte) []byte {
+	var userData bytes.Buffer
+	userData.WriteString("#!/bin/bash\n")
+	fmt.Fprintf(&userData, "echo ECS_CLUSTER=%s >> %s\n", clusterName, ECSConfigFile)
+	userData.Write(stripShebang(script))
+	return userData.Bytes()
+}
+
+func ecsTags(tags map[string]string) []ecsTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]ecsTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, ecsTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// CreateECSCluster creates the cluster the instances join and returns its
+// ARN.
+func CreateECSCluster(ctx context.Context, logger *log.Logger, ecsClient *ecs.Client, clusterName string, tags map[string]string) (string, error) {
+	output, err := ecsClient.CreateCluster(ctx, &ecs.CreateClusterInput{
+		ClusterName: aws.String(clusterName),
+		Tags:        ecsTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating ECS cluster: %w", err)
+	}
+
+	clusterARN := aws.StringValue(output.Cluster.ClusterArn)
+	logger.Printf("ECS cluster created with ARN: %s", clusterARN)
+	return clusterARN, nil
+}
+
+// CreateCapacityProvider makes the autoscaling group the capacity provider of
+// the cluster, scaled by ECS, and its default capacity provider strategy.
+func CreateCapacityProvider(ctx context.Context, logger *log.Logger, clients *Clients, ecsConfig ECSConfig, autoscalingGroupName string, tags map[string]string) (string, error) {
+	groups, err := clients.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoscalingGroupName},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(groups.AutoScalingGroups) == 0 {
+		return "", fmt.Errorf("autoscaling group %s not found", autoscalingGroupName)
+	}
+
+	if _, err := clients.ECS.CreateCapacityProvider(ctx, &ecs.CreateCapacityProviderInput{
+		Name: aws.String(ecsConfig.CapacityProviderName),
+		AutoScalingGroupProvider: &ecsTypes.AutoScalingGroupProvider{
+			AutoScalingGroupArn: groups.AutoScalingGroups[0].AutoScalingGroupARN,
+			ManagedScaling: &ecsTypes.ManagedScaling{
+				Status:         ecsTypes.ManagedScalingStatusEnabled,
+				TargetCapacity: aws.Int32(ecsConfig.TargetCapacity),
+			},
+			ManagedTerminationProtection: ecsTypes.ManagedTerminationProtection(ecsConfig.ManagedTerminationProtectionStatus()),
+		},
+		Tags: ecsTags(tags),
+	}); err != nil {
+		return "", fmt.Errorf("error creating capacity provider: %w", err)
+	}
+	logger.Printf("Capacity provider %s created", ecsConfig.CapacityProviderName)
+
+	if _, err := clients.ECS.PutClusterCapacityProviders(ctx, &ecs.PutClusterCapacityProvidersInput{
+		Cluster:           aws.String(ecsConfig.ClusterName),
+		CapacityProviders: []string{ecsConfig.CapacityProviderName},
+		DefaultCapacityProviderStrategy: []ecsTypes.CapacityProviderStrategyItem{
+			{CapacityProvider: aws.String(ecsConfig.CapacityProviderName), Weight: 1},
+		},
+	}); err != nil {
+		return ecsConfig.CapacityProviderName, fmt.Errorf("error adding capacity provider to ECS cluster: %w", err)
+	}
+	logger.Printf("Capacity provider %s is the default of ECS cluster %s", ecsConfig.CapacityProviderName, ecsConfig.ClusterName)
+
+	return ecsConfig.CapacityProviderName, nil
+}
+
+// deleteCapacityProvider removes the capacity provider from the cluster and
+// deletes it, so the autoscaling group can go.
+func deleteCapacityProvider(ctx context.Context, logger *log.Logger, ecsClient *ecs.Client, clusterARN, capacityProviderName string) error {
+	if clusterARN != "" {
+		if _, err := ecsClient.PutClusterCapacityProviders(ctx, &ecs.PutClusterCapacityProvidersInput{
+			Cluster:                         aws.String(clusterARN),
+			CapacityProviders:               []string{},
+			DefaultCapacityProviderStrategy: []ecsTypes.CapacityProviderStrategyItem{},
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error removing capacity provider from ECS cluster: %w", err)
+		}
+	}
+	if _, err := ecsClient.DeleteCapacityProvider(ctx, &ecs.DeleteCapacityProviderInput{
+		CapacityProvider: aws.String(capacityProviderName),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error deleting capacity provider: %w", err)
+	}
+	logger.Printf("Capacity provider %s deleted", capacityProviderName)
+	return nil
+}
+
+// deleteECSCluster deletes the cluster, retrying while the container
+// instances of the terminated autoscaling group are still registered.
+func deleteECSCluster(ctx context.Context, logger *log.Logger, ecsClient *ecs.Client, clusterARN string) error {
+	ctx, cancel := context.WithTimeout(ctx, DestroyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(DestroyPollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := ecsClient.DeleteCluster(ctx, &ecs.DeleteClusterInput{
+			Cluster: aws.String(clusterARN),
+		})
+		if err == nil || isNotFound(err) {
+			logger.Printf("ECS cluster %s deleted", clusterARN)
+			return nil
+		}
+		if !hasErrorCode(err, "ClusterContainsContainerInstancesException") {
+			return fmt.Errorf("error deleting ECS cluster: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error deleting ECS cluster: %w", errors.Join(err, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+var (
+	// elasticIPNamePattern matches the names that can key an address in the
+	// state and in the exported templates.
+	elasticIPNamePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+	// elasticIPTargetPattern matches the IDs of the instances and network
+	// interfaces an address can be associated with.
+	elasticIPTargetPattern = regexp.MustCompile(`^(i|eni)-[0-9a-f]{8,17}$`)
+)
+
+// ElasticIPConfig is a static public IPv4 address allocated by apply and
+// kept until destroy, e.g. to be allowlisted by a partner.
+type ElasticIPConfig struct {
+	// Name keys the address in the state, it is tagged <resource name> with
+	// it.
+	Name string `json:"name"`
+	// AssociateWith is the instance or network interface the address is
+	// associated with, or bastion for the bastion, left unassociated when
+	// empty.
+	AssociateWith string `json:"associateWith"`
+	// TagName is the Name tag of the address.
+	TagName string `json:"-"`
+}
+
+func (e ElasticIPConfig) String() string {
+	if e.AssociateWith != "" {
+		return e.Name + " on " + e.AssociateWith
+	}
+	return e.Name
+}
+
+// AssociatesNetworkInterface reports whether the address is associated with
+// a network interface rather than an instance.
+func (e ElasticIPConfig) AssociatesNetworkInterface() bool {
+	return strings.HasPrefix(e.AssociateWith, "eni-")
+}
+
+// AssociatesBastion reports whether the address is associated with the
+// bastion.
+func (e ElasticIPConfig) AssociatesBastion() bool {
+	return e.AssociateWith == ElasticIPTargetBastion
+}
+
+// target returns the instance or network interface the address is
+// associated with, resolving the bastion to its instance. It is empty while
+// the bastion doesn't run.
+func (e ElasticIPConfig) target(state *State) string {
+	if e.AssociatesBastion() {
+		return state.BastionInstanceID
+	}
+	return e.AssociateWith
+}
+
+// ElasticIPState is an allocated address and its association, if any.
+type ElasticIPState struct {
+	AllocationID  string `json:"allocationId"`
+	PublicIP      string `json:"publicIp"`
+	AssociationID string `json:"associationId,omitempty"`
+	// AssociatedWith is the instance or network interface the address is
+	// associated with.
+	AssociatedWith string `json:"associatedWith,omitempty"`
+}
+
+// AllocateElasticIP allocates an address in the VPC scope and tags it.
+func AllocateElasticIP(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, tagName string, tags map[string]string) (ElasticIPState, error) {
+	addressTags := map[string]string{"Name": tagName}
+	for key, value := range tags {
+		addressTags[key] = value
+	}
+	output, err := ec2Client.AllocateAddress(ctx, &ec2.AllocateAddressInput{
+		Domain:            types.DomainTypeVpc,
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeElasticIp, addressTags),
+	})
+	if err != nil {
+		return ElasticIPState{}, fmt.Errorf("error allocating elastic IP %s: %w", tagName, err)
+	}
+	address := ElasticIPState{
+		AllocationID: aws.StringValue(output.AllocationId),
+		PublicIP:     aws.StringValue(output.PublicIp),
+	}
+	logger.Printf("Elastic IP %s allocated with ID: %s", address.PublicIP, address.AllocationID)
+	return address, nil
+}
+
+// AssociateElasticIP associates the address with an instance or a network
+// interface, taking it over from whatever it was associated with before.
+func AssociateElasticIP(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, address ElasticIPState, target string) (string, error) {
+	input := &ec2.AssociateAddressInput{
+		AllocationId:       aws.String(address.AllocationID),
+		AllowReassociation: aws.Bool(true),
+	}
+	if strings.HasPrefix(target, "eni-") {
+		input.NetworkInterfaceId = aws.String(target)
+	} else {
+		input.InstanceId = aws.String(target)
+	}
+	output, err := ec2Client.AssociateAddress(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error associating elastic IP %s with %s: %w", address.PublicIP, target, err)
+	}
+	logger.Printf("Elastic IP %s associated with %s", address.PublicIP, target)
+	return aws.StringValue(output.AssociationId), nil
+}
+
+func disassociateElasticIP(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, address ElasticIPState) error {
+	if _, err := ec2Client.DisassociateAddress(ctx, &ec2.DisassociateAddressInput{
+		AssociationId: aws.String(address.AssociationID),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error disassociating elastic IP %s: %w", address.PublicIP, err)
+	}
+	logger.Printf("Elastic IP %s disassociated from %s", address.PublicIP, address.AssociatedWith)
+	return nil
+}
+
+// ReleaseElasticIP disassociates the address and releases it.
+func ReleaseElasticIP(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, address ElasticIPState) error {
+	if address.AssociationID != "" {
+		if err := disassociateElasticIP(ctx, logger, ec2Client, address); err != nil {
+			return err
+		}
+	}
+	if _, err := ec2Client.ReleaseAddress(ctx, &ec2.ReleaseAddressInput{
+		AllocationId: aws.String(address.AllocationID),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error releasing elastic IP %s: %w", address.PublicIP, err)
+	}
+	logger.Printf("Elastic IP %s released", address.PublicIP)
+	return nil
+}
+
+// recordedElasticIP returns the address saved under name. Other steps of
+// apply may be recording into the state meanwhile.
+func recordedElasticIP(state *State, name string) (ElasticIPState, bool) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	address, ok := state.ElasticIPs[name]
+	return address, ok
+}
+
+// recordElasticIP saves the address under its name.
+func recordElasticIP(state *State, name string, address ElasticIPState) error {
+	return state.Record(func(s *State) {
+		if s.ElasticIPs == nil {
+			s.ElasticIPs = make(map[string]ElasticIPState)
+		}
+		s.ElasticIPs[name] = address
+	})
+}
+
+// AllocateElasticIPs allocates the addresses missing from the state and
+// moves each association to the configured target, recording every address
+// as soon as it exists. Addresses meant for a bastion that doesn't run yet
+// are associated once it does.
+func AllocateElasticIPs(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, addresses []ElasticIPConfig, state *State, tags map[string]string) error {
+	for _, config := range addresses {
+		address, allocated := recordedElasticIP(state, config.Name)
+		if !allocated {
+			var err error
+			address, err = AllocateElasticIP(ctx, logger, ec2Client, config.TagName, tags)
+			if err != nil {
+				return err
+			}
+			if err := recordElasticIP(state, config.Name, address); err != nil {
+				return err
+			}
+		}
+		target := config.target(state)
+		if target == address.AssociatedWith || target == "" && config.AssociatesBastion() {
+			continue
+		}
+
+		if target == "" {
+			if err := disassociateElasticIP(ctx, logger, ec2Client, address); err != nil {
+				return err
+			}
+			address.AssociationID, address.AssociatedWith = "", ""
+		} else {
+			associationID, err := AssociateElasticIP(ctx, logger, ec2Client, address, target)
+			if err != nil {
+				return err
+			}
+			address.AssociationID, address.AssociatedWith = associationID, target
+		}
+		if err := recordElasticIP(state, config.Name, address); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReleaseElasticIPs releases the recorded addresses that are not kept.
+func ReleaseElasticIPs(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, state *State, keep []ElasticIPConfig) error {
+	kept := make(map[string]bool, len(keep))
+	for _, address := range keep {
+		kept[address.Name] = true
+	}
+	names := make([]string, 0, len(state.ElasticIPs))
+	for name := range state.ElasticIPs {
+		if !kept[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ReleaseElasticIP(ctx, logger, ec2Client, state.ElasticIPs[name]); err != nil {
+			return err
+		}
+		if err := state.Record(func(s *State) { delete(s.ElasticIPs, name) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ElasticIPAddresses returns the public IPs of the recorded addresses by
+// name.
+func ElasticIPAddresses(state *State) map[string]string {
+	if len(state.ElasticIPs) == 0 {
+		return nil
+	}
+	addresses := make(map[string]string, len(state.ElasticIPs))
+	for name, address := range state.ElasticIPs {
+		addresses[name] = address.PublicIP
+	}
+	return addresses
+}
+
+// UpdateElasticIPs allocates the addresses added to the config, moves the
+// changed associations and releases the addresses removed from the config.
+func UpdateElasticIPs(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State) error {
+	if err := AllocateElasticIPs(ctx, logger, ec2Client, cfg.ElasticIPs, state, cfg.StackTags()); err != nil {
+		return err
+	}
+	return ReleaseElasticIPs(ctx, logger, ec2Client, state, cfg.ElasticIPs)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	EndpointsSecurityGroupDescription = "Allows HTTPS from the VPC to the interface endpoints"
+)
+
+// GatewayEndpointServices are the services reached through gateway
+// endpoints, routes in the route tables. Every other service gets an
+// interface endpoint, network interfaces in the subnets.
+var GatewayEndpointServices = []string{"s3", "dynamodb"}
+
+// GatewayEndpoints returns the configured endpoint services that get
+// gateway endpoints.
+func (v VPCConfig) GatewayEndpoints() []string {
+	return slices.DeleteFunc(slices.Clone(v.Endpoints), func(service string) bool {
+		return !slices.Contains(GatewayEndpointServices, service)
+	})
+}
+
+// InterfaceEndpoints returns the configured endpoint services that get
+// interface endpoints.
+func (v VPCConfig) InterfaceEndpoints() []string {
+	return slices.DeleteFunc(slices.Clone(v.Endpoints), func(service string) bool {
+		return slices.Contains(GatewayEndpointServices, service)
+	})
+}
+
+// EndpointServiceName returns the full name of an AWS service in region.
+func EndpointServiceName(region, service string) string {
+	return "com.amazonaws." + region + "." + service
+}
+
+// EndpointSubnets picks the subnets the interface endpoints are placed in,
+// one per availability zone, preferring the private subnets. It returns their
+// positions among the private or public subnets.
+func EndpointSubnets(publicSubnets, privateSubnets []SubnetConfig) (indexes []int, private bool) {
+	subnets, private := publicSubnets, false
+	if len(privateSubnets) > 0 {
+		subnets, private = privateSubnets, true
+	}
+
+	var zones []string
+	for i, subnet := range subnets {
+		zone := subnet.AvailabilityZone
+		if zone == "" {
+			zone = fmt.Sprint(subnet.ZoneIndex)
+		}
+		if slices.Contains(zones, zone) {
+			continue
+		}
+		zones = append(zones, zone)
+		indexes = append(indexes, i)
+	}
+	return indexes, private
+}
+
+// endpointSubnetRefs names the subnets EndpointSubnets picks, for the
+// exports. The formats get the position of the subnet.
+func endpointSubnetRefs(publicSubnets, privateSubnets []SubnetConfig, publicFormat, privateFormat string) []string {
+	indexes, private := EndpointSubnets(publicSubnets, privateSubnets)
+	format := publicFormat
+	if private {
+		format = privateFormat
+	}
+	refs := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		refs = append(refs, fmt.Sprintf(format, i))
+	}
+	return refs
+}
+
+// CreateEndpointSecurityGroup creates the security group of the interface
+// endpoints, which accepts HTTPS from the whole VPC.
+func CreateEndpointSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, name, vpcID string, vpcCIDRBlocks []string, tags map[string]string) (string, error) {
+	output, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:         aws.String(name),
+		Description:       aws.String(EndpointsSecurityGroupDescription),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeSecurityGroup, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating endpoint security group: %w", err)
+	}
+	securityGroupID := aws.StringValue(output.GroupId)
+	logger.Printf("Endpoint security group created with ID: %s", securityGroupID)
+
+	rule := SecurityGroupRule{Protocol: "tcp", FromPort: 443, CIDRs: vpcCIDRBlocks, Description: "HTTPS from the VPC"}
+	if _, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(securityGroupID),
+		IpPermissions: []types.IpPermission{rule.IPPermission()},
+	}); err != nil {
+		return securityGroupID, fmt.Errorf("error adding inbound (ingress) rule to endpoint security group: %w", err)
+	}
+
+	return securityGroupID, nil
+}
+
+// CreateVPCEndpoints creates the gateway endpoints in the route tables and
+// the interface endpoints, with private DNS names, in the subnets. It returns
+// the IDs of the endpoints created so far also when one fails.
+func CreateVPCEndpoints(
+	ctx context.Context,
+	logger *log.Logger,
+	ec2Client *ec2.Client,
+	vpcConfig VPCConfig,
+	region string,
+	vpcID string,
+	routeTableIDs []string,
+	subnetIDs []string,
+	securityGroupID string,
+	tags map[string]string,
+) ([]string, error) {
+	var endpointIDs []string
+	create := func(service string, input *ec2.CreateVpcEndpointInput) error {
+		input.VpcId = aws.String(vpcID)
+		input.ServiceName = aws.String(EndpointServiceName(region, service))
+		input.TagSpecifications = ec2TagSpecifications(types.ResourceTypeVpcEndpoint, tags)
+		output, err := ec2Client.CreateVpcEndpoint(ctx, input)
+		if err != nil {
+			return fmt.Errorf("error creating %s endpoint: %w", service, err)
+		}
+		endpointID := aws.StringValue(output.VpcEndpoint.VpcEndpointId)
+		endpointIDs = append(endpointIDs, endpointID)
+		logger.Printf("VPC endpoint for %s created with ID: %s", service, endpointID)
+		return nil
+	}
+
+	for _, service := range vpcConfig.GatewayEndpoints() {
+		if err := create(service, &ec2.CreateVpcEndpointInput{
+			VpcEndpointType: types.VpcEndpointTypeGateway,
+			RouteTableIds:   routeTableIDs,
+		}); err != nil {
+			return endpointIDs, err
+		}
+	}
+	for _, service := range vpcConfig.InterfaceEndpoints() {
+		if err := create(service, &ec2.CreateVpcEndpointInput{
+			VpcEndpointType:   types.VpcEndpointTypeInterface,
+			SubnetIds:         subnetIDs,
+			SecurityGroupIds:  []string{securityGroupID},
+			PrivateDnsEnabled: aws.Bool(true),
+		}); err != nil {
+			return endpointIDs, err
+		}
+	}
+
+	return endpointIDs, nil
+}
+
+// MainRouteTable returns the ID of the main route table of the VPC, which
+// the private subnets use.
+func MainRouteTable(ctx context.Context, ec2Client *ec2.Client, vpcID string) (string, error) {
+	output, err := ec2Client.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{vpcID}},
+			{Name: aws.String("association.main"), Values: []string{"true"}},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing route tables: %w", err)
+	}
+	if len(output.RouteTables) == 0 {
+		return "", fmt.Errorf("main route table of VPC %s not found", vpcID)
+	}
+	return aws.StringValue(output.RouteTables[0].RouteTableId), nil
+}
+
+// DescribeEndpoints summarizes the configured endpoints for the plan.
+func DescribeEndpoints(vpcConfig VPCConfig) string {
+	var kinds []string
+	if gateway := vpcConfig.GatewayEndpoints(); len(gateway) > 0 {
+		kinds = append(kinds, "gateway "+strings.Join(gateway, ", "))
+	}
+	if interfaces := vpcConfig.InterfaceEndpoints(); len(interfaces) > 0 {
+		kinds = append(kinds, "interface "+strings.Join(interfaces, ", "))
+	}
+	return strings.Join(kinds, "; ")
+}
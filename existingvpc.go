@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ExistingVPC is the VPC ResolveVPC found for vpc.id or vpc.selector, with
+// the internet gateway and the route table the public subnets use.
+type ExistingVPC struct {
+	ID                string
+	InternetGatewayID string
+	RouteTableID      string
+	// SubnetCIDRBlocks are the ranges taken by subnets that aren't part of
+	// the stack, which the subnets of the stack are carved around.
+	SubnetCIDRBlocks []string
+}
+
+// Existing reports whether the stack goes into an existing VPC instead of
+// creating one.
+func (v VPCConfig) Existing() bool {
+	return v.ID != "" || len(v.Selector) > 0
+}
+
+// ResolveVPC looks up the existing VPC of the config, checks it can hold the
+// stack and takes its CIDR blocks over into the config, so the subnets are
+// carved from them. It does nothing when the stack creates its VPC.
+func ResolveVPC(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	vpcConfig := &cfg.VPC
+	if !vpcConfig.Existing() {
+		return nil
+	}
+
+	vpc, err := findExistingVPC(ctx, clients, *vpcConfig)
+	if err != nil {
+		return err
+	}
+	existing := &ExistingVPC{ID: aws.StringValue(vpc.VpcId)}
+
+	// The instances and the interface endpoints resolve names through the
+	// VPC's DNS, and the instances need public DNS names.
+	for _, attribute := range []types.VpcAttributeName{types.VpcAttributeNameEnableDnsSupport, types.VpcAttributeNameEnableDnsHostnames} {
+		output, err := clients.EC2.DescribeVpcAttribute(ctx, &ec2.DescribeVpcAttributeInput{
+			VpcId:     aws.String(existing.ID),
+			Attribute: attribute,
+		})
+		if err != nil {
+			return fmt.Errorf("error describing %s of VPC %s: %w", attribute, existing.ID, err)
+		}
+		enabled := output.EnableDnsSupport
+		if attribute == types.VpcAttributeNameEnableDnsHostnames {
+			enabled = output.EnableDnsHostnames
+		}
+		if enabled == nil || !aws.BoolValue(enabled.Value) {
+			return fmt.Errorf("VPC %s doesn't have %s turned on, the stack needs it", existing.ID, attribute)
+		}
+	}
+
+	gateways, err := clients.EC2.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{
+		Filters: []types.Filter{{Name: aws.String("attachment.vpc-id"), Values: []string{existing.ID}}},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing internet gateways: %w", err)
+	}
+	if len(gateways.InternetGateways) == 0 {
+		return fmt.Errorf("VPC %s has no internet gateway, the public subnets need one", existing.ID)
+	}
+	existing.InternetGatewayID = aws.StringValue(gateways.InternetGateways[0].InternetGatewayId)
+
+	routeTables, err := clients.EC2.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("vpc-id"), Values: []string{existing.ID}},
+			{Name: aws.String("route.destination-cidr-block"), Values: []string{"0.0.0.0/0"}},
+			{Name: aws.String("route.gateway-id"), Values: []string{existing.InternetGatewayID}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing route tables: %w", err)
+	}
+	if len(routeTables.RouteTables) == 0 {
+		return fmt.Errorf("no route table of VPC %s routes 0.0.0.0/0 to internet gateway %s, the public subnets need one", existing.ID, existing.InternetGatewayID)
+	}
+	existing.RouteTableID = aws.StringValue(routeTables.RouteTables[0].RouteTableId)
+
+	// The subnets of the stack itself don't count, they are carved the
+	// same way on every run.
+	ownSubnetIDs := append(slices.Clone(state.SubnetIDs), state.PrivateSubnetIDs...)
+	paginator := ec2.NewDescribeSubnetsPaginator(clients.EC2, &ec2.DescribeSubnetsInput{
+		Filters: []types.Filter{{Name: aws.String("vpc-id"), Values: []string{existing.ID}}},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("error describing subnets of VPC %s: %w", existing.ID, err)
+		}
+		for _, subnet := range page.Subnets {
+			if !slices.Contains(ownSubnetIDs, aws.StringValue(subnet.SubnetId)) {
+				existing.SubnetCIDRBlocks = append(existing.SubnetCIDRBlocks, aws.StringValue(subnet.CidrBlock))
+			}
+		}
+	}
+
+	vpcConfig.CIDRBlock = aws.StringValue(vpc.CidrBlock)
+	vpcConfig.SecondaryCIDRBlocks = nil
+	for _, association := range vpc.CidrBlockAssociationSet {
+		cidrBlock := aws.StringValue(association.CidrBlock)
+		if cidrBlock != vpcConfig.CIDRBlock && association.CidrBlockState != nil && association.CidrBlockState.State == types.VpcCidrBlockStateCodeAssociated {
+			vpcConfig.SecondaryCIDRBlocks = append(vpcConfig.SecondaryCIDRBlocks, cidrBlock)
+		}
+	}
+	vpcConfig.Resolved = existing
+
+	for i, subnet := range vpcConfig.Subnets {
+		prefix, err := netip.ParsePrefix(subnet.CIDRBlock)
+		if err != nil || !slices.ContainsFunc(vpcConfig.CIDRBlocks(), func(cidrBlock string) bool {
+			vpcPrefix, err := netip.ParsePrefix(cidrBlock)
+			return err == nil && vpcPrefix.Contains(prefix.Addr()) && prefix.Bits() >= vpcPrefix.Bits()
+		}) {
+			return fmt.Errorf("vpc.subnets[%d].cidrBlock %s is outside of the CIDR blocks %s of VPC %s", i, subnet.CIDRBlock, strings.Join(vpcConfig.CIDRBlocks(), ", "), existing.ID)
+		}
+		if taken := takenBy(subnet.CIDRBlock, existing.SubnetCIDRBlocks); taken != "" {
+			return fmt.Errorf("vpc.subnets[%d].cidrBlock %s overlaps subnet %s of VPC %s", i, subnet.CIDRBlock, taken, existing.ID)
+		}
+	}
+
+	if vpcConfig.SubnetIDs != nil {
+		if err := checkSubnetIDs(ctx, clients, *vpcConfig.SubnetIDs, existing.ID); err != nil {
+			return err
+		}
+	}
+
+	logger.Printf("Using existing VPC %s (%s) with internet gateway %s and route table %s", existing.ID, strings.Join(vpcConfig.CIDRBlocks(), ", "), existing.InternetGatewayID, existing.RouteTableID)
+	return nil
+}
+
+// findExistingVPC looks up the VPC of vpc.id or vpc.selector, which must
+// match exactly one.
+func findExistingVPC(ctx context.Context, clients *Clients, vpcConfig VPCConfig) (types.Vpc, error) {
+	input := &ec2.DescribeVpcsInput{}
+	if vpcConfig.ID != "" {
+		input.VpcIds = []string{vpcConfig.ID}
+	}
+	for _, key := range sortedTagKeys(vpcConfig.Selector) {
+		input.Filters = append(input.Filters, types.Filter{Name: aws.String("tag:" + key), Values: []string{vpcConfig.Selector[key]}})
+	}
+	// A missing vpc.id is an error of its own rather than an empty result.
+	output, err := clients.EC2.DescribeVpcs(ctx, input)
+	if err != nil && !hasErrorCode(err, "InvalidVpcID.NotFound") {
+		return types.Vpc{}, fmt.Errorf("error describing VPC: %w", err)
+	}
+	if err != nil || len(output.Vpcs) == 0 && vpcConfig.ID != "" {
+		return types.Vpc{}, fmt.Errorf("vpc.id %s not found", vpcConfig.ID)
+	}
+	switch len(output.Vpcs) {
+	case 0:
+		return types.Vpc{}, fmt.Errorf("no VPC matches vpc.selector %v", vpcConfig.Selector)
+	case 1:
+	default:
+		var ids []string
+		for _, vpc := range output.Vpcs {
+			ids = append(ids, aws.StringValue(vpc.VpcId))
+		}
+		return types.Vpc{}, fmt.Errorf("vpc.selector %v matches %d VPCs (%s), it must match exactly one", vpcConfig.Selector, len(ids), strings.Join(ids, ", "))
+	}
+	return output.Vpcs[0], nil
+}
+
+// checkSubnetIDs checks that the subnets of each list belong to the VPC and
+// span at least two availability zones.
+func checkSubnetIDs(ctx context.Context, clients *Clients, subnetIDs SubnetIDsConfig, vpcID string) error {
+	// The load balancer and the autoscaling groups may share subnets.
+	ids := append(slices.Clone(subnetIDs.LoadBalancer), subnetIDs.AutoScalingGroup...)
+	slices.Sort(ids)
+	output, err := clients.EC2.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: slices.Compact(ids)})
+	if err != nil {
+		return fmt.Errorf("error describing vpc.subnetIds: %w", err)
+	}
+	subnets := map[string]types.Subnet{}
+	for _, subnet := range output.Subnets {
+		subnets[aws.StringValue(subnet.SubnetId)] = subnet
+	}
+
+	for _, list := range []struct {
+		name      string
+		subnetIDs []string
+	}{
+		{"loadBalancer", subnetIDs.LoadBalancer},
+		{"autoScalingGroup", subnetIDs.AutoScalingGroup},
+	} {
+		zones := map[string]bool{}
+		for i, subnetID := range list.subnetIDs {
+			subnet, ok := subnets[subnetID]
+			if !ok {
+				return fmt.Errorf("vpc.subnetIds.%s[%d] %s doesn't exist", list.name, i, subnetID)
+			}
+			if subnetVPCID := aws.StringValue(subnet.VpcId); subnetVPCID != vpcID {
+				return fmt.Errorf("vpc.subnetIds.%s[%d] %s belongs to VPC %s, not to VPC %s", list.name, i, subnetID, subnetVPCID, vpcID)
+			}
+			zones[aws.StringValue(subnet.AvailabilityZone)] = true
+		}
+		if len(zones) < 2 {
+			return fmt.Errorf("vpc.subnetIds.%s are all in one availability zone, they must span at least two", list.name)
+		}
+	}
+	return nil
+}
+
+// takenBy returns the first of cidrBlocks that overlaps cidrBlock.
+func takenBy(cidrBlock string, cidrBlocks []string) string {
+	prefix, err := netip.ParsePrefix(cidrBlock)
+	if err != nil {
+		return ""
+	}
+	for _, other := range cidrBlocks {
+		if otherPrefix, err := netip.ParsePrefix(other); err == nil && otherPrefix.Overlaps(prefix) {
+			return other
+		}
+	}
+	return ""
+}
+
+func validateExistingVPC(vpcConfig VPCConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if vpcConfig.ID != "" && len(vpcConfig.Selector) > 0 {
+		report("vpc.id and vpc.selector can't both be set")
+	}
+	if vpcConfig.ID != "" && !strings.HasPrefix(vpcConfig.ID, "vpc-") {
+		report("vpc.id %q is not a VPC ID", vpcConfig.ID)
+	}
+	if len(vpcConfig.SecondaryCIDRBlocks) > 0 {
+		report("vpc.secondaryCidrBlocks are only associated with a VPC apply creates, associate them with the existing VPC yourself")
+	}
+	return problems
+}
+
+// validateSubnetIDs checks vpc.subnetIds. The availability zones of the
+// subnets and their VPC are checked by ResolveVPC.
+func validateSubnetIDs(vpcConfig VPCConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !vpcConfig.Existing() {
+		report("vpc.subnetIds need vpc.id or vpc.selector, the subnets must belong to an existing VPC")
+	}
+	if len(vpcConfig.Subnets) > 0 {
+		report("vpc.subnets and vpc.subnetIds can't both be set")
+	}
+	if vpcConfig.NetworkACL.Enabled {
+		report("vpc.networkAcl can't be used with vpc.subnetIds, it would replace the network ACL of subnets the stack doesn't own")
+	}
+	if len(vpcConfig.Endpoints) > 0 {
+		report("vpc.endpoints can't be used with vpc.subnetIds, the endpoints of an existing VPC are managed with it")
+	}
+	for _, list := range []struct {
+		name      string
+		subnetIDs []string
+	}{
+		{"loadBalancer", vpcConfig.SubnetIDs.LoadBalancer},
+		{"autoScalingGroup", vpcConfig.SubnetIDs.AutoScalingGroup},
+	} {
+		if len(list.subnetIDs) < 2 {
+			report("vpc.subnetIds.%s must list subnets in at least two availability zones", list.name)
+		}
+		for i, subnetID := range list.subnetIDs {
+			if !strings.HasPrefix(subnetID, "subnet-") {
+				report("vpc.subnetIds.%s[%d] %q is not a subnet ID", list.name, i, subnetID)
+			}
+			if slices.Contains(list.subnetIDs[:i], subnetID) {
+				report("vpc.subnetIds.%s[%d] %s is listed twice", list.name, i, subnetID)
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// FleetAction is one of the manual operations on instances of the
+// autoscaling group.
+type FleetAction struct {
+	// DecrementsDesired is set for actions that take instances out of the
+	// group and can lower the desired capacity instead of launching
+	// replacements.
+	DecrementsDesired bool
+	Run               func(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, decrementDesired bool) error
+	Done              string
+}
+
+var fleetActions = map[string]FleetAction{
+	"standby": {
+		DecrementsDesired: true,
+		Run: func(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, decrementDesired bool) error {
+			_, err := autoscalingClient.EnterStandby(ctx, &autoscaling.EnterStandbyInput{
+				AutoScalingGroupName:           aws.String(autoscalingGroupName),
+				InstanceIds:                    instanceIDs,
+				ShouldDecrementDesiredCapacity: aws.Bool(decrementDesired),
+			})
+			return err
+		},
+		Done: "moved to Standby",
+	},
+	"resume": {
+		Run: func(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, _ bool) error {
+			_, err := autoscalingClient.ExitStandby(ctx, &autoscaling.ExitStandbyInput{
+				AutoScalingGroupName: aws.String(autoscalingGroupName),
+				InstanceIds:          instanceIDs,
+			})
+			return err
+		},
+		Done: "returned to service",
+	},
+	"detach": {
+		DecrementsDesired: true,
+		Run: func(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, decrementDesired bool) error {
+			_, err := autoscalingClient.DetachInstances(ctx, &autoscaling.DetachInstancesInput{
+				AutoScalingGroupName:           aws.String(autoscalingGroupName),
+				InstanceIds:                    instanceIDs,
+				ShouldDecrementDesiredCapacity: aws.Bool(decrementDesired),
+			})
+			return err
+		},
+		Done: "detached",
+	},
+	"attach": {
+		Run: func(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, _ bool) error {
+			_, err := autoscalingClient.AttachInstances(ctx, &autoscaling.AttachInstancesInput{
+				AutoScalingGroupName: aws.String(autoscalingGroupName),
+				InstanceIds:          instanceIDs,
+			})
+			return err
+		},
+		Done: "attached",
+	},
+}
+
+// splitInstanceIDs returns the instance IDs given before the first flag and
+// the remaining arguments.
+func splitInstanceIDs(args []string) ([]string, []string) {
+	var instanceIDs []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		instanceIDs = append(instanceIDs, args[0])
+		args = args[1:]
+	}
+	return instanceIDs, args
+}
+
+// RunFleetAction applies the named action to the instances of the group.
+func RunFleetAction(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, name, autoscalingGroupName string, instanceIDs []string, decrementDesired bool) error {
+	action := fleetActions[name]
+	if err := action.Run(ctx, autoscalingClient, autoscalingGroupName, instanceIDs, decrementDesired); err != nil {
+		return fmt.Errorf("error running %s on instances %s: %w", name, strings.Join(instanceIDs, ", "), err)
+	}
+	logger.Printf("Instances %s: %s", action.Done, strings.Join(instanceIDs, ", "))
+	return nil
+}
+
+func runFleetCommand(name string) func(ctx context.Context, logger *log.Logger, args []string) error {
+	return func(ctx context.Context, logger *log.Logger, args []string) error {
+		instanceIDs, args := splitInstanceIDs(args)
+
+		var opts GlobalOptions
+		fs := flag.NewFlagSet(name, flag.ExitOnError)
+		opts.Register(fs)
+		var decrementDesired bool
+		if fleetActions[name].DecrementsDesired {
+			fs.BoolVar(&decrementDesired, "decrement-desired", false, "lower the desired capacity instead of launching replacements")
+		}
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if len(instanceIDs) == 0 {
+			return errors.New(name + " requires at least one instance ID")
+		}
+
+		_, state, clients, err := LoadStack(ctx, logger, &opts)
+		if err != nil {
+			return err
+		}
+
+		return RunFleetAction(ctx, logger, clients.AutoScaling, name, state.AutoScalingGroupName, instanceIDs, decrementDesired)
+	}
+}
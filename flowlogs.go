@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	FlowLogsDestinationCloudWatchLogs = "cloud-watch-logs"
+	FlowLogsDestinationS3             = "s3"
+	FlowLogsRolePolicyName            = "flow-logs-delivery"
+	// A new IAM role takes a while until the flow logs service can assume it.
+	FlowLogsRoleTimeout      = 2 * time.Minute
+	FlowLogsRolePollInterval = 5 * time.Second
+)
+
+// FlowLogsTrafficTypes are the kinds of traffic flow logs can capture.
+var FlowLogsTrafficTypes = []string{"ALL", "ACCEPT", "REJECT"}
+
+// FlowLogsRetentionDays are the retention periods CloudWatch Logs accepts;
+// 0 keeps the logs forever.
+var FlowLogsRetentionDays = []int32{0, 1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+// FlowLogsConfig captures the IP traffic of the VPC. Delivered to CloudWatch
+// Logs, the tool also creates the log group and the IAM role the flow logs
+// service writes to it with; an S3 bucket must exist and allow the delivery
+// in its bucket policy.
+type FlowLogsConfig struct {
+	Enabled bool `json:"enabled"`
+	// Destination is cloud-watch-logs or s3.
+	Destination   string `json:"destination"`
+	LogGroupName  string `json:"logGroupName"`
+	RetentionDays int32  `json:"retentionDays"`
+	RoleName      string `json:"roleName"`
+	// BucketARN is the S3 bucket, optionally with a prefix, e.g.
+	// arn:aws:s3:::my-bucket/flow-logs.
+	BucketARN string `json:"bucketArn"`
+	// TrafficType is ALL, ACCEPT or REJECT.
+	TrafficType string `json:"trafficType"`
+}
+
+// ToCloudWatchLogs reports whether the flow logs are delivered to a log group.
+func (f FlowLogsConfig) ToCloudWatchLogs() bool {
+	return f.Destination == FlowLogsDestinationCloudWatchLogs
+}
+
+// TrustPolicy lets the flow logs service assume the delivery role.
+func (f FlowLogsConfig) TrustPolicy() string {
+	return policyJSON(map[string]any{
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": "vpc-flow-logs.amazonaws.com"},
+		"Action":    "sts:AssumeRole",
+	})
+}
+
+// DeliveryPolicy allows the delivery role to write to the log group.
+func (f FlowLogsConfig) DeliveryPolicy() string {
+	return policyJSON(map[string]any{
+		"Effect": "Allow",
+		"Action": []string{
+			"logs:CreateLogStream",
+			"logs:PutLogEvents",
+			"logs:DescribeLogGroups",
+			"logs:DescribeLogStreams",
+		},
+		"Resource": fmt.Sprintf("arn:aws:logs:*:*:log-group:%s:*", f.LogGroupName),
+	})
+}
+
+func policyJSON(statements ...map[string]any) string {
+	data, _ := json.Marshal(map[string]any{
+		"Version":   "2012-10-17",
+		"Statement": statements,
+	})
+	return string(data)
+}
+
+// CreateFlowLogGroup creates the log group the flow logs are delivered to.
+func CreateFlowLogGroup(ctx context.Context, logger *log.Logger, logsClient *cloudwatchlogs.Client, flowLogsConfig FlowLogsConfig, tags map[string]string) error {
+	if _, err := logsClient.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(flowLogsConfig.LogGroupName),
+		Tags:         tags,
+	}); err != nil {
+		return fmt.Errorf("error creating log group: %w", err)
+	}
+	logger.Printf("Log group %s created", flowLogsConfig.LogGroupName)
+
+	if flowLogsConfig.RetentionDays == 0 {
+		return nil
+	}
+	if _, err := logsClient.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+		LogGroupName:    aws.String(flowLogsConfig.LogGroupName),
+		RetentionInDays: aws.Int32(flowLogsConfig.RetentionDays),
+	}); err != nil {
+		return fmt.Errorf("error setting retention of log group: %w", err)
+	}
+	logger.Printf("Log group %s keeps logs for %d days", flowLogsConfig.LogGroupName, flowLogsConfig.RetentionDays)
+
+	return nil
+}
+
+// CreateFlowLogsRole creates the IAM role the flow logs service delivers to
+// the log group with and returns its ARN.
+func CreateFlowLogsRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client, flowLogsConfig FlowLogsConfig, tags map[string]string) (string, error) {
+	output, err := iamClient.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 aws.String(flowLogsConfig.RoleName),
+		AssumeRolePolicyDocument: aws.String(flowLogsConfig.TrustPolicy()),
+		Description:              aws.String("Delivers VPC flow logs to CloudWatch Logs"),
+		Tags:                     iamTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating flow logs role: %w", err)
+	}
+	roleARN := aws.StringValue(output.Role.Arn)
+	logger.Printf("IAM role %s created", flowLogsConfig.RoleName)
+
+	if _, err := iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(flowLogsConfig.RoleName),
+		PolicyName:     aws.String(FlowLogsRolePolicyName),
+		PolicyDocument: aws.String(flowLogsConfig.DeliveryPolicy()),
+	}); err != nil {
+		return roleARN, fmt.Errorf("error adding policy to flow logs role: %w", err)
+	}
+
+	return roleARN, nil
+}
+
+// CreateFlowLog starts capturing the traffic of the VPC. roleARN is only
+// used for CloudWatch Logs delivery. It retries while the flow logs service
+// can't assume the freshly created role yet.
+func CreateFlowLog(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, flowLogsConfig FlowLogsConfig, vpcID, roleARN string, tags map[string]string) (string, error) {
+	input := &ec2.CreateFlowLogsInput{
+		ResourceIds:        []string{vpcID},
+		ResourceType:       types.FlowLogsResourceTypeVpc,
+		TrafficType:        types.TrafficType(flowLogsConfig.TrafficType),
+		LogDestinationType: types.LogDestinationType(flowLogsConfig.Destination),
+		TagSpecifications:  ec2TagSpecifications(types.ResourceTypeVpcFlowLog, tags),
+	}
+	if flowLogsConfig.ToCloudWatchLogs() {
+		input.LogGroupName = aws.String(flowLogsConfig.LogGroupName)
+		input.DeliverLogsPermissionArn = aws.String(roleARN)
+	} else {
+		input.LogDestination = aws.String(flowLogsConfig.BucketARN)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, FlowLogsRoleTimeout)
+	defer cancel()
+	ticker := time.NewTicker(FlowLogsRolePollInterval)
+	defer ticker.Stop()
+	for {
+		flowLogID, err := createFlowLog(ctx, ec2Client, input)
+		if err == nil {
+			logger.Printf("Flow log created with ID: %s", flowLogID)
+			return flowLogID, nil
+		}
+		if !strings.Contains(err.Error(), "assume") {
+			return "", err
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-ticker.C:
+		}
+	}
+}
+
+func createFlowLog(ctx context.Context, ec2Client *ec2.Client, input *ec2.CreateFlowLogsInput) (string, error) {
+	output, err := ec2Client.CreateFlowLogs(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error creating flow log: %w", err)
+	}
+	for _, item := range output.Unsuccessful {
+		if item.Error != nil {
+			return "", fmt.Errorf("error creating flow log: %s", aws.StringValue(item.Error.Message))
+		}
+	}
+	if len(output.FlowLogIds) == 0 {
+		return "", fmt.Errorf("error creating flow log: no flow log ID returned")
+	}
+	return output.FlowLogIds[0], nil
+}
+
+// deleteFlowLogs deletes the flow log and, for CloudWatch Logs delivery, its
+// IAM role and log group, clearing each from the state.
+func deleteFlowLogs(ctx context.Context, logger *log.Logger, clients *Clients, state *State) error {
+	if state.FlowLogID != "" {
+		output, err := clients.EC2.DeleteFlowLogs(ctx, &ec2.DeleteFlowLogsInput{
+			FlowLogIds: []string{state.FlowLogID},
+		})
+		if err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting flow log: %w", err)
+		}
+		if output != nil {
+			for _, item := range output.Unsuccessful {
+				if item.Error != nil && !strings.Contains(aws.StringValue(item.Error.Code), "NotFound") {
+					return fmt.Errorf("error deleting flow log: %s", aws.StringValue(item.Error.Message))
+				}
+			}
+		}
+		logger.Printf("Flow log %s deleted", state.FlowLogID)
+		if err := state.Record(func(s *State) { s.FlowLogID = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.FlowLogsRoleName != "" {
+		if _, err := clients.IAM.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(state.FlowLogsRoleName),
+			PolicyName: aws.String(FlowLogsRolePolicyName),
+		}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+			return fmt.Errorf("error deleting policy of flow logs role: %w", err)
+		}
+		if _, err := clients.IAM.DeleteRole(ctx, &iam.DeleteRoleInput{
+			RoleName: aws.String(state.FlowLogsRoleName),
+		}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+			return fmt.Errorf("error deleting flow logs role: %w", err)
+		}
+		logger.Printf("IAM role %s deleted", state.FlowLogsRoleName)
+		if err := state.Record(func(s *State) { s.FlowLogsRoleName = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.FlowLogGroupName != "" {
+		if _, err := clients.Logs.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{
+			LogGroupName: aws.String(state.FlowLogGroupName),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting log group: %w", err)
+		}
+		logger.Printf("Log group %s deleted", state.FlowLogGroupName)
+		if err := state.Record(func(s *State) { s.FlowLogGroupName = "" }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// StepNode is a step of the graph Apply runs. Run creates the resource of
+// the step and returns its identifier, like the fn of Progress.Track.
+type StepNode struct {
+	Name      string
+	DependsOn []string
+	Run       func() (string, error)
+}
+
+// StepGraph runs the steps of an apply as soon as the steps they depend on
+// are done, as many at a time as the dependencies allow.
+type StepGraph struct {
+	nodes   []*StepNode
+	retries RetriesConfig
+}
+
+// NewStepGraph creates an empty graph running its steps again according to
+// the policy of their class in retries.
+func NewStepGraph(retries RetriesConfig) *StepGraph {
+	return &StepGraph{retries: retries}
+}
+
+// Add adds a step running after the steps of dependsOn. Dependencies on
+// steps that aren't added, e.g. optional steps disabled by the config, are
+// ignored.
+func (g *StepGraph) Add(name string, dependsOn []string, run func() (string, error)) {
+	g.nodes = append(g.nodes, &StepNode{Name: name, DependsOn: dependsOn, Run: run})
+}
+
+// Names returns the names of the steps added to the graph.
+func (g *StepGraph) Names() []string {
+	names := make([]string, 0, len(g.nodes))
+	for _, node := range g.nodes {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+// Skip removes the steps of names from the graph, the steps depending on
+// them no longer wait for them.
+func (g *StepGraph) Skip(names []string) {
+	g.nodes = slices.DeleteFunc(g.nodes, func(node *StepNode) bool {
+		return slices.Contains(names, node.Name)
+	})
+}
+
+// Target keeps only the step target and the steps it depends on, directly
+// or through other steps. Steps it depends on that aren't part of the graph,
+// e.g. skipped ones, stay out.
+func (g *StepGraph) Target(target string) error {
+	byName := make(map[string]*StepNode, len(g.nodes))
+	for _, node := range g.nodes {
+		byName[node.Name] = node
+	}
+	if _, ok := byName[target]; !ok {
+		return fmt.Errorf("step %q is not part of the apply, steps: %s", target, strings.Join(g.Names(), ", "))
+	}
+
+	keep := map[string]bool{}
+	var visit func(name string)
+	visit = func(name string) {
+		node, ok := byName[name]
+		if !ok || keep[name] {
+			return
+		}
+		keep[name] = true
+		for _, dependency := range node.DependsOn {
+			visit(dependency)
+		}
+	}
+	visit(target)
+	g.nodes = slices.DeleteFunc(g.nodes, func(node *StepNode) bool {
+		return !keep[node.Name]
+	})
+	return nil
+}
+
+// dependencies returns the dependencies of every node that are part of the
+// graph, or an error for an unknown dependency or a cycle.
+func (g *StepGraph) dependencies() (map[string][]string, error) {
+	known := StepNames()
+	dependencies := make(map[string][]string, len(g.nodes))
+	for _, node := range g.nodes {
+		if _, ok := dependencies[node.Name]; ok {
+			return nil, fmt.Errorf("step %q is added twice", node.Name)
+		}
+		dependencies[node.Name] = []string{}
+	}
+	for _, node := range g.nodes {
+		for _, dependency := range node.DependsOn {
+			if !slices.Contains(known, dependency) {
+				return nil, fmt.Errorf("step %q depends on unknown step %q", node.Name, dependency)
+			}
+			if _, ok := dependencies[dependency]; ok {
+				dependencies[node.Name] = append(dependencies[node.Name], dependency)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int, len(g.nodes))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("steps depend on each other in a cycle through %q", name)
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dependency := range dependencies[name] {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	for _, node := range g.nodes {
+		if err := visit(node.Name); err != nil {
+			return nil, err
+		}
+	}
+	return dependencies, nil
+}
+
+// Run runs the steps and records each one that is done in state. Once a
+// step failed no more steps are started, the ones already running are left
+// to finish so every resource they create ends up in state.
+func (g *StepGraph) Run(ctx context.Context, logger *log.Logger, progress Progress, state *State) error {
+	dependencies, err := g.dependencies()
+	if err != nil {
+		return err
+	}
+
+	waiting := make(map[string]int, len(g.nodes))
+	dependents := map[string][]*StepNode{}
+	var ready []*StepNode
+	for _, node := range g.nodes {
+		waiting[node.Name] = len(dependencies[node.Name])
+		for _, dependency := range dependencies[node.Name] {
+			dependents[dependency] = append(dependents[dependency], node)
+		}
+		if waiting[node.Name] == 0 {
+			ready = append(ready, node)
+		}
+	}
+
+	type result struct {
+		node *StepNode
+		err  error
+	}
+	results := make(chan result)
+	running := 0
+	start := func(node *StepNode) {
+		running++
+		go func() {
+			results <- result{node, progress.Track(node.Name, func() (string, error) {
+				return runStep(ctx, logger, node, g.retries.ForStep(node.Name))
+			})}
+		}()
+	}
+	for _, node := range ready {
+		start(node)
+	}
+
+	var (
+		errs   []error
+		failed []string
+		done   = map[string]bool{}
+	)
+	for running > 0 {
+		result := <-results
+		running--
+		if result.err != nil {
+			errs = append(errs, result.err)
+			failed = append(failed, result.node.Name)
+			continue
+		}
+		done[result.node.Name] = true
+		if err := state.Record(func(s *State) { s.CompletedSteps = append(s.CompletedSteps, result.node.Name) }); err != nil {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			continue
+		}
+		for _, dependent := range dependents[result.node.Name] {
+			if waiting[dependent.Name]--; waiting[dependent.Name] == 0 {
+				start(dependent)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	var notStarted []string
+	for _, node := range g.nodes {
+		if !done[node.Name] && !slices.Contains(failed, node.Name) {
+			notStarted = append(notStarted, node.Name)
+		}
+	}
+	logger.Printf("%d of %d steps done, failed: %s, not started: %s", len(done), len(g.nodes), strings.Join(failed, ", "), orDash(strings.Join(notStarted, ", ")))
+	return errors.Join(errs...)
+}
+
+// runStep runs the step again while it is throttled, as often as policy
+// allows. A step is only run again when it returned no resource, as it would
+// create a second one otherwise.
+func runStep(ctx context.Context, logger *log.Logger, node *StepNode, policy RetryConfig) (string, error) {
+	for run := 1; ; run++ {
+		resource, err := node.Run()
+		if err == nil || resource != "" || run >= policy.StepAttempts || !isThrottled(err) {
+			return resource, err
+		}
+
+		backoff := policy.StepBackoff(run)
+		logger.Printf("Step %s was throttled, retrying in %s: %v", node.Name, backoff, err)
+		select {
+		case <-ctx.Done():
+			return resource, err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// isThrottled tells whether AWS rejected a request of err for exceeding a
+// rate limit, so nothing was done.
+func isThrottled(err error) bool {
+	return retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool()
+}
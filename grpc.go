@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/korzepadawid/aws-autoscaling-pzc/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// StacksService serves the gRPC API of api/stacks.proto on top of the jobs
+// of the HTTP API.
+type StacksService struct {
+	api.UnimplementedStacksServer
+	server *Server
+	// done ends the streams of WatchStack when serve shuts down.
+	done <-chan struct{}
+}
+
+// NewGRPCServer creates the gRPC server of the API, requiring the bearer
+// token of server, when it has one, in the authorization metadata.
+func NewGRPCServer(server *Server, done <-chan struct{}) *grpc.Server {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := server.authenticateGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := server.authenticateGRPC(stream.Context()); err != nil {
+				return err
+			}
+			return handler(srv, stream)
+		}),
+	)
+	api.RegisterStacksServer(grpcServer, &StacksService{server: server, done: done})
+	return grpcServer
+}
+
+func (s *Server) authenticateGRPC(ctx context.Context) error {
+	if s.token == "" {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+func (s *StacksService) CreateStack(ctx context.Context, req *api.CreateStackRequest) (*api.Job, error) {
+	job, err := s.server.StartApply(req.GetName(), req.GetEnvironment())
+	if err != nil {
+		return nil, startErrorStatus(err)
+	}
+	return jobMessage(job), nil
+}
+
+func (s *StacksService) DestroyStack(ctx context.Context, req *api.DestroyStackRequest) (*api.Job, error) {
+	job, err := s.server.StartDestroy(req.GetName(), req.GetEnvironment())
+	if err != nil {
+		return nil, startErrorStatus(err)
+	}
+	return jobMessage(job), nil
+}
+
+// WatchStack streams the events of the jobs of the stack as they happen and
+// polls its instances every interval, like watch does.
+func (s *StacksService) WatchStack(req *api.WatchStackRequest, stream api.Stacks_WatchStackServer) error {
+	opts, err := s.server.stackOptions(req.GetName(), req.GetEnvironment())
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	cfg, err := loadServedConfig(opts)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	interval := DefaultWatchInterval
+	if req.GetInterval() != nil {
+		interval = req.GetInterval().AsDuration()
+	}
+	if interval <= 0 {
+		return status.Error(codes.InvalidArgument, "interval must be positive")
+	}
+
+	ctx := stream.Context()
+	events, cancel := s.server.Subscribe(opts.StateFile())
+	defer cancel()
+
+	clients, err := NewClients(ctx, s.server.logger, cfg, opts)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var previous *StackStatus
+	poll := func() error {
+		// The state is read again on every poll, a job may be recording
+		// into it.
+		state, err := OpenState(ctx, s.server.logger, cfg, opts, clients)
+		if err != nil || state.IsEmpty() {
+			return err
+		}
+		current, err := CollectStatus(ctx, clients, state)
+		if err != nil {
+			s.server.logger.Printf("Error polling stack %s: %v", opts.Stack, err)
+			return nil
+		}
+		for _, event := range statusEvents(previous, current) {
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+		previous = current
+		return nil
+	}
+
+	if err := poll(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case event := <-events:
+			if err := stream.Send(jobEventMessage(event)); err != nil {
+				return err
+			}
+		case <-ticker.C:
+			if err := poll(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusEvents reports every instance on the first poll, then the instances
+// that launched, changed or left, along with the changes watch logs.
+func statusEvents(previous, current *StackStatus) []*api.StackEvent {
+	now := timestamppb.Now()
+	var events []*api.StackEvent
+	before := map[string]InstanceStatus{}
+	if previous != nil {
+		for _, instance := range previous.Instances {
+			before[instance.InstanceID] = instance
+		}
+	}
+	for _, instance := range current.Instances {
+		old, known := before[instance.InstanceID]
+		delete(before, instance.InstanceID)
+		if known && old == instance {
+			continue
+		}
+		events = append(events, &api.StackEvent{Time: now, Event: &api.StackEvent_Instance{Instance: instanceMessage(instance, false)}})
+	}
+	if previous == nil {
+		return events
+	}
+	for _, instance := range previous.Instances {
+		if _, gone := before[instance.InstanceID]; gone {
+			events = append(events, &api.StackEvent{Time: now, Event: &api.StackEvent_Instance{Instance: instanceMessage(instance, true)}})
+		}
+	}
+	for _, change := range StatusChanges(previous, current) {
+		events = append(events, &api.StackEvent{Time: now, Event: &api.StackEvent_Change{Change: &api.ChangeEvent{Message: change}}})
+	}
+	return events
+}
+
+func instanceMessage(instance InstanceStatus, gone bool) *api.InstanceEvent {
+	return &api.InstanceEvent{
+		InstanceId:       instance.InstanceID,
+		AvailabilityZone: instance.AvailabilityZone,
+		LifecycleState:   instance.LifecycleState,
+		HealthStatus:     instance.HealthStatus,
+		TargetHealth:     instance.TargetHealth,
+		TargetReason:     instance.TargetReason,
+		Gone:             gone,
+	}
+}
+
+func jobEventMessage(event JobEvent) *api.StackEvent {
+	message := &api.StackEvent{Time: timestamppb.New(event.Time)}
+	switch {
+	case event.Step != nil:
+		message.Event = &api.StackEvent_Step{Step: &api.StepEvent{
+			JobId:    event.JobID,
+			Step:     event.Step.Step,
+			State:    event.Step.State,
+			Resource: event.Step.Resource,
+			Error:    event.Step.Error,
+		}}
+	case event.Finished != nil:
+		message.Event = &api.StackEvent_Job{Job: jobMessage(event.Finished)}
+	default:
+		message.Event = &api.StackEvent_Log{Log: &api.LogEvent{JobId: event.JobID, Line: event.Log}}
+	}
+	return message
+}
+
+func jobMessage(job *Job) *api.Job {
+	message := &api.Job{
+		Id:          job.ID,
+		Command:     job.Command,
+		Stack:       job.Stack,
+		Environment: job.Environment,
+		Status:      job.Status,
+		Error:       job.Error,
+		StartedAt:   timestamppb.New(job.StartedAt),
+	}
+	if job.FinishedAt != nil {
+		message.FinishedAt = timestamppb.New(*job.FinishedAt)
+	}
+	if job.Outputs != nil {
+		message.Outputs = &api.StackOutputs{
+			Region:               job.Outputs.Region,
+			VpcId:                job.Outputs.VPCID,
+			AutoScalingGroupName: job.Outputs.AutoScalingGroupName,
+			LoadBalancerDnsName:  job.Outputs.LoadBalancerDNSName,
+			Url:                  job.Outputs.URL,
+		}
+	}
+	return message
+}
+
+// startErrorStatus is the status of a job that couldn't be started, like
+// startErrorCode.
+func startErrorStatus(err error) error {
+	if errors.Is(err, ErrJobRunning) {
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return status.Error(codes.InvalidArgument, err.Error())
+}
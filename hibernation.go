@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// MaxHibernationMemoryGiB is the most RAM an instance can hibernate with,
+	// MaxWindowsHibernationMemoryGiB on Windows.
+	MaxHibernationMemoryGiB        = 150
+	MaxWindowsHibernationMemoryGiB = 16
+	// HibernationRootHeadroomGiB is the room left on the root volume for the
+	// OS and the workload next to the saved RAM.
+	HibernationRootHeadroomGiB = 8
+)
+
+// hibernationFamilies are the instance families that can hibernate. The list
+// lets validate catch the common mistakes offline, doctor asks EC2 about the
+// exact instance type.
+var hibernationFamilies = []string{
+	"c3", "c4", "c5", "c5d", "c6a", "c6g", "c6gd", "c6gn", "c6i", "c6id", "c6in", "c7a", "c7g", "c7gd", "c7i", "c7i-flex",
+	"i3", "i3en", "i4g", "i4i",
+	"m3", "m4", "m5", "m5a", "m5ad", "m5d", "m5dn", "m5n", "m5zn", "m6a", "m6g", "m6gd", "m6i", "m6id", "m6idn", "m6in", "m7a", "m7g", "m7gd", "m7i", "m7i-flex",
+	"r3", "r4", "r5", "r5a", "r5ad", "r5b", "r5d", "r5dn", "r5n", "r6a", "r6g", "r6gd", "r6i", "r6id", "r6idn", "r6in", "r7a", "r7g", "r7gd", "r7i", "r7iz",
+	"t2", "t3", "t3a", "t4g",
+	"x2gd", "x2idn", "x2iedn", "x2iezn", "z1d",
+}
+
+// SupportsHibernation reports whether instances of the type can hibernate,
+// judging by its family. Bare metal instances can't.
+func SupportsHibernation(instanceType string) bool {
+	family, size, _ := strings.Cut(instanceType, ".")
+	return slices.Contains(hibernationFamilies, family) && !strings.HasPrefix(size, "metal")
+}
+
+// CheckHibernation asks EC2 whether the instance type can hibernate and
+// whether the root volume holds its RAM.
+func CheckHibernation(ctx context.Context, ec2Client *ec2.Client, ltConfig LaunchTemplateConfig) (string, error) {
+	output, err := ec2Client.DescribeInstanceTypes(ctx, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: []types.InstanceType{types.InstanceType(ltConfig.InstanceType)},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing instance type %s: %w", ltConfig.InstanceType, err)
+	}
+	if len(output.InstanceTypes) == 0 || output.InstanceTypes[0].MemoryInfo == nil {
+		return "", fmt.Errorf("instance type %s is not offered", ltConfig.InstanceType)
+	}
+	instanceType := output.InstanceTypes[0]
+	if !aws.BoolValue(instanceType.HibernationSupported) {
+		return "", fmt.Errorf("instance type %s can't hibernate", ltConfig.InstanceType)
+	}
+
+	memoryGiB := int32((aws.Int64Value(instanceType.MemoryInfo.SizeInMiB) + 1023) / 1024)
+	maxMemoryGiB := int32(MaxHibernationMemoryGiB)
+	if ltConfig.Windows {
+		maxMemoryGiB = MaxWindowsHibernationMemoryGiB
+	}
+	if memoryGiB > maxMemoryGiB {
+		return "", fmt.Errorf("instance type %s has %d GiB of RAM, instances with more than %d GiB can't hibernate", ltConfig.InstanceType, memoryGiB, maxMemoryGiB)
+	}
+	if ltConfig.RootVolume != nil && ltConfig.RootVolume.SizeGiB < memoryGiB+HibernationRootHeadroomGiB {
+		return "", fmt.Errorf("launchTemplate.rootVolume.sizeGiB %d can't hold the %d GiB of RAM of %s, make it at least %d", ltConfig.RootVolume.SizeGiB, memoryGiB, ltConfig.InstanceType, memoryGiB+HibernationRootHeadroomGiB)
+	}
+	return fmt.Sprintf("%s can hibernate its %d GiB of RAM", ltConfig.InstanceType, memoryGiB), nil
+}
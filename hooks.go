@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+
+	DefaultHookTimeoutSeconds = 60
+)
+
+// HookConfig runs a shell command or calls a webhook before or after a step
+// of apply. Step is one of ApplySteps.
+type HookConfig struct {
+	Step string `json:"step"`
+	When string `json:"when"`
+	// Command is run with sh -c, the step context is passed as environment
+	// variables.
+	Command string `json:"command"`
+	// URL receives the step context as a JSON POST.
+	URL            string `json:"url"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+func (h HookConfig) Timeout() time.Duration {
+	if h.TimeoutSeconds <= 0 {
+		return DefaultHookTimeoutSeconds * time.Second
+	}
+	return time.Duration(h.TimeoutSeconds) * time.Second
+}
+
+// HookContext is what a hook learns about the step it runs for.
+type HookContext struct {
+	Step     string       `json:"step"`
+	When     string       `json:"when"`
+	Resource string       `json:"resource,omitempty"`
+	Stack    string       `json:"stack"`
+	Outputs  StackOutputs `json:"outputs"`
+}
+
+// Env returns the context as environment variables for hook commands.
+func (c HookContext) Env() []string {
+	return []string{
+		"HOOK_STEP=" + c.Step,
+		"HOOK_WHEN=" + c.When,
+		"HOOK_RESOURCE=" + c.Resource,
+		"STACK_NAME=" + c.Stack,
+		"STACK_ENV=" + c.Outputs.Environment,
+		"STACK_REGION=" + c.Outputs.Region,
+		"VPC_ID=" + c.Outputs.VPCID,
+		"SUBNET_IDS=" + strings.Join(c.Outputs.SubnetIDs, ","),
+		"SECURITY_GROUP_ID=" + c.Outputs.SecurityGroupID,
+		"LAUNCH_TEMPLATE_ID=" + c.Outputs.LaunchTemplateID,
+		"TARGET_GROUP_ARN=" + c.Outputs.TargetGroupARN,
+		"AUTOSCALING_GROUP_NAME=" + c.Outputs.AutoScalingGroupName,
+		"LOAD_BALANCER_ARN=" + c.Outputs.LoadBalancerARN,
+		"LOAD_BALANCER_DNS_NAME=" + c.Outputs.LoadBalancerDNSName,
+		"LISTENER_ARN=" + c.Outputs.ListenerARN,
+	}
+}
+
+// HookProgress runs the configured hooks around the steps it tracks. A
+// failing hook fails its step, so a before hook can veto a step.
+type HookProgress struct {
+	Progress
+	logger *log.Logger
+	cfg    *Config
+	state  *State
+}
+
+func NewHookProgress(logger *log.Logger, cfg *Config, state *State, progress Progress) HookProgress {
+	return HookProgress{Progress: progress, logger: logger, cfg: cfg, state: state}
+}
+
+func (p HookProgress) Track(step string, fn func() (string, error)) error {
+	if err := p.run(step, HookBefore, ""); err != nil {
+		return err
+	}
+
+	var resource string
+	if err := p.Progress.Track(step, func() (string, error) {
+		var err error
+		resource, err = fn()
+		return resource, err
+	}); err != nil {
+		return err
+	}
+
+	return p.run(step, HookAfter, resource)
+}
+
+func (p HookProgress) run(step, when, resource string) error {
+	for _, hook := range p.cfg.Hooks {
+		if hook.Step != step || hook.When != when {
+			continue
+		}
+
+		p.state.mu.Lock()
+		hookContext := HookContext{
+			Step:     step,
+			When:     when,
+			Resource: resource,
+			Stack:    p.cfg.Naming.Stack,
+			Outputs:  NewStackOutputs(p.cfg, p.state),
+		}
+		p.state.mu.Unlock()
+
+		if err := RunHook(hook, hookContext); err != nil {
+			return err
+		}
+		p.logger.Printf("Hook %s %s ran successfully", when, step)
+	}
+	return nil
+}
+
+// RunHook runs the command of hook and then calls its webhook, if set.
+func RunHook(hook HookConfig, hookContext HookContext) error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), hook.Timeout())
+	defer cancelFunc()
+
+	if hook.Command != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+		cmd.Env = append(os.Environ(), hookContext.Env()...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error running %s hook of step %s: %w", hook.When, hook.Step, err)
+		}
+	}
+
+	if hook.URL != "" {
+		body, err := json.Marshal(hookContext)
+		if err != nil {
+			return fmt.Errorf("error encoding hook context: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error creating %s hook request of step %s: %w", hook.When, hook.Step, err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("error calling %s hook of step %s: %w", hook.When, hook.Step, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("%s hook of step %s returned %s", hook.When, hook.Step, resp.Status)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// DefaultHostRulePriority is the priority of the first host rule without
+	// one, the next ones follow in steps of 10.
+	DefaultHostRulePriority = 1000
+)
+
+// HostRuleConfig routes the requests for HostHeaders, optionally only those
+// matching PathPatterns too, to an existing target group, e.g. the one of an
+// ECS service. Host headers may use * and ? wildcards.
+type HostRuleConfig struct {
+	HostHeaders    []string `json:"hostHeaders"`
+	PathPatterns   []string `json:"pathPatterns"`
+	TargetGroupARN string   `json:"targetGroupArn"`
+	// Priority orders the listener rules, lower values are evaluated first.
+	Priority int32 `json:"priority"`
+}
+
+// Conditions returns the conditions of the listener rule.
+func (h HostRuleConfig) Conditions() []elbTypes.RuleCondition {
+	conditions := []elbTypes.RuleCondition{
+		{
+			Field:            aws.String("host-header"),
+			HostHeaderConfig: &elbTypes.HostHeaderConditionConfig{Values: h.HostHeaders},
+		},
+	}
+	if len(h.PathPatterns) > 0 {
+		conditions = append(conditions, elbTypes.RuleCondition{
+			Field:             aws.String("path-pattern"),
+			PathPatternConfig: &elbTypes.PathPatternConditionConfig{Values: h.PathPatterns},
+		})
+	}
+	return conditions
+}
+
+// CreateHostRule adds the listener rule of a host rule. actions are the
+// actions of the rule.
+func CreateHostRule(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, ruleConfig HostRuleConfig, listenerARN string, actions []elbTypes.Action, tags map[string]string) (string, error) {
+	output, err := elbClient.CreateRule(ctx, &elasticloadbalancingv2.CreateRuleInput{
+		ListenerArn: aws.String(listenerARN),
+		Priority:    aws.Int32(ruleConfig.Priority),
+		Conditions:  ruleConfig.Conditions(),
+		Actions:     actions,
+		Tags:        elbTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating listener rule for %v: %w", ruleConfig.HostHeaders, err)
+	}
+
+	ruleARN := aws.StringValue(output.Rules[0].RuleArn)
+	logger.Printf("Listener rule for %v created with ARN: %s", ruleConfig.HostHeaders, ruleARN)
+	return ruleARN, nil
+}
+
+// DeleteHostRules removes the recorded host rules, forgetting each of them
+// once it is gone.
+func DeleteHostRules(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, state *State) error {
+	for len(state.HostRuleARNs) > 0 {
+		ruleARN := state.HostRuleARNs[0]
+		if _, err := elbClient.DeleteRule(ctx, &elasticloadbalancingv2.DeleteRuleInput{
+			RuleArn: aws.String(ruleARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting listener rule: %w", err)
+		}
+		logger.Printf("Listener rule %s deleted", ruleARN)
+		if err := state.Record(func(s *State) { s.HostRuleARNs = s.HostRuleARNs[1:] }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// resourceImporter looks an existing resource up and returns the change
+// recording it in state.
+type resourceImporter func(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error)
+
+var importers = map[string]resourceImporter{
+	"vpc":               importVPC,
+	"internet-gateway":  importInternetGateway,
+	"route-table":       importRouteTable,
+	"subnet":            importSubnet(false),
+	"private-subnet":    importSubnet(true),
+	"security-group":    importSecurityGroup,
+	"launch-template":   importLaunchTemplate,
+	"target-group":      importTargetGroup,
+	"autoscaling-group": importAutoscalingGroup,
+	"scaling-policy":    importScalingPolicy,
+	"load-balancer":     importLoadBalancer,
+	"listener":          importListener,
+}
+
+func importTypes() []string {
+	types := make([]string, 0, len(importers))
+	for resourceType := range importers {
+		types = append(types, resourceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ImportResource adopts an existing resource into state, so later applies,
+// updates and destroys manage it as if the tool had created it. Resources
+// living in a VPC must be in the VPC recorded in state.
+func ImportResource(ctx context.Context, clients *Clients, state *State, resourceType, id string) error {
+	importer, ok := importers[resourceType]
+	if !ok {
+		return fmt.Errorf("unknown resource type %q, use one of: %s", resourceType, strings.Join(importTypes(), ", "))
+	}
+
+	record, err := importer(ctx, clients, state, id)
+	if err != nil {
+		return err
+	}
+	return state.Record(record)
+}
+
+// checkVPC fails when the resource lives outside the VPC recorded in state.
+func checkVPC(state *State, resource, vpcID string) error {
+	if state.VPCID != "" && vpcID != state.VPCID {
+		return fmt.Errorf("%s is in VPC %s, not in the VPC %s recorded in state", resource, vpcID, state.VPCID)
+	}
+	return nil
+}
+
+// checkUnset fails when state already records another resource of the kind.
+func checkUnset(kind, current, id string) error {
+	if current != "" && current != id {
+		return fmt.Errorf("state already records %s %s, destroy or remove it from the state first", kind, current)
+	}
+	return nil
+}
+
+func importVPC(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("VPC", state.VPCID, id); err != nil {
+		return nil, err
+	}
+	if _, err := clients.EC2.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{id}}); err != nil {
+		return nil, fmt.Errorf("error describing VPC %s: %w", id, err)
+	}
+	return func(s *State) { s.VPCID = id }, nil
+}
+
+func importInternetGateway(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("internet gateway", state.InternetGatewayID, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.EC2.DescribeInternetGateways(ctx, &ec2.DescribeInternetGatewaysInput{InternetGatewayIds: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing internet gateway %s: %w", id, err)
+	}
+	for _, attachment := range output.InternetGateways[0].Attachments {
+		if err := checkVPC(state, "internet gateway "+id, aws.StringValue(attachment.VpcId)); err != nil {
+			return nil, err
+		}
+	}
+	return func(s *State) { s.InternetGatewayID = id }, nil
+}
+
+func importRouteTable(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("route table", state.RouteTableID, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.EC2.DescribeRouteTables(ctx, &ec2.DescribeRouteTablesInput{RouteTableIds: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing route table %s: %w", id, err)
+	}
+	if err := checkVPC(state, "route table "+id, aws.StringValue(output.RouteTables[0].VpcId)); err != nil {
+		return nil, err
+	}
+	return func(s *State) { s.RouteTableID = id }, nil
+}
+
+func importSubnet(private bool) resourceImporter {
+	return func(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+		if slices.Contains(state.SubnetIDs, id) || slices.Contains(state.PrivateSubnetIDs, id) {
+			return nil, fmt.Errorf("subnet %s is already recorded in state", id)
+		}
+		output, err := clients.EC2.DescribeSubnets(ctx, &ec2.DescribeSubnetsInput{SubnetIds: []string{id}})
+		if err != nil {
+			return nil, fmt.Errorf("error describing subnet %s: %w", id, err)
+		}
+		if err := checkVPC(state, "subnet "+id, aws.StringValue(output.Subnets[0].VpcId)); err != nil {
+			return nil, err
+		}
+		if private {
+			return func(s *State) { s.PrivateSubnetIDs = append(s.PrivateSubnetIDs, id) }, nil
+		}
+		return func(s *State) { s.SubnetIDs = append(s.SubnetIDs, id) }, nil
+	}
+}
+
+func importSecurityGroup(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("security group", state.SecurityGroupID, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.EC2.DescribeSecurityGroups(ctx, &ec2.DescribeSecurityGroupsInput{GroupIds: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing security group %s: %w", id, err)
+	}
+	if err := checkVPC(state, "security group "+id, aws.StringValue(output.SecurityGroups[0].VpcId)); err != nil {
+		return nil, err
+	}
+	return func(s *State) { s.SecurityGroupID = id }, nil
+}
+
+// importLaunchTemplate records the latest version of the template. No data
+// hash is recorded, so the next apply writes the configured launch template
+// data as a new version.
+func importLaunchTemplate(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("launch template", state.LaunchTemplateID, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.EC2.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{LaunchTemplateIds: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing launch template %s: %w", id, err)
+	}
+	latestVersion := aws.Int64Value(output.LaunchTemplates[0].LatestVersionNumber)
+	return func(s *State) {
+		s.LaunchTemplateID = id
+		s.LaunchTemplateDataHash = ""
+		if s.LaunchTemplateVersion == "" {
+			s.LaunchTemplateVersion = strconv.FormatInt(latestVersion, 10)
+		}
+	}, nil
+}
+
+func importTargetGroup(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("target group", state.TargetGroupARN, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.ELB.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{TargetGroupArns: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing target group %s: %w", id, err)
+	}
+	if err := checkVPC(state, "target group "+id, aws.StringValue(output.TargetGroups[0].VpcId)); err != nil {
+		return nil, err
+	}
+	return func(s *State) { s.TargetGroupARN = id }, nil
+}
+
+// importAutoscalingGroup takes the launch template version over from the
+// group, so the next apply doesn't switch it.
+func importAutoscalingGroup(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("autoscaling group", state.AutoScalingGroupName, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{AutoScalingGroupNames: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing autoscaling group %s: %w", id, err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return nil, fmt.Errorf("autoscaling group %s not found", id)
+	}
+	var version string
+	if launchTemplate := output.AutoScalingGroups[0].LaunchTemplate; launchTemplate != nil {
+		version = aws.StringValue(launchTemplate.Version)
+	}
+	return func(s *State) {
+		s.AutoScalingGroupName = id
+		if version != "" {
+			s.LaunchTemplateVersion = version
+		}
+	}, nil
+}
+
+func importScalingPolicy(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if state.AutoScalingGroupName == "" {
+		return nil, fmt.Errorf("import the autoscaling group before its scaling policy")
+	}
+	if err := checkUnset("scaling policy", state.ScalingPolicyName, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.AutoScaling.DescribePolicies(ctx, &autoscaling.DescribePoliciesInput{
+		AutoScalingGroupName: aws.String(state.AutoScalingGroupName),
+		PolicyNames:          []string{id},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing scaling policy %s: %w", id, err)
+	}
+	if len(output.ScalingPolicies) == 0 {
+		return nil, fmt.Errorf("scaling policy %s not found on autoscaling group %s", id, state.AutoScalingGroupName)
+	}
+	return func(s *State) { s.ScalingPolicyName = id }, nil
+}
+
+func importLoadBalancer(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("load balancer", state.LoadBalancerARN, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.ELB.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{LoadBalancerArns: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing load balancer %s: %w", id, err)
+	}
+	loadBalancer := output.LoadBalancers[0]
+	if err := checkVPC(state, "load balancer "+id, aws.StringValue(loadBalancer.VpcId)); err != nil {
+		return nil, err
+	}
+	dnsName := aws.StringValue(loadBalancer.DNSName)
+	return func(s *State) {
+		s.LoadBalancerARN = id
+		s.LoadBalancerDNSName = dnsName
+	}, nil
+}
+
+func importListener(ctx context.Context, clients *Clients, state *State, id string) (func(*State), error) {
+	if err := checkUnset("listener", state.ListenerARN, id); err != nil {
+		return nil, err
+	}
+	output, err := clients.ELB.DescribeListeners(ctx, &elasticloadbalancingv2.DescribeListenersInput{ListenerArns: []string{id}})
+	if err != nil {
+		return nil, fmt.Errorf("error describing listener %s: %w", id, err)
+	}
+	if loadBalancerARN := aws.StringValue(output.Listeners[0].LoadBalancerArn); state.LoadBalancerARN != "" && loadBalancerARN != state.LoadBalancerARN {
+		return nil, fmt.Errorf("listener %s belongs to load balancer %s, not to %s recorded in state", id, loadBalancerARN, state.LoadBalancerARN)
+	}
+	return func(s *State) { s.ListenerARN = id }, nil
+}
+
+func runImport(ctx context.Context, logger *log.Logger, args []string) error {
+	if len(args) < 2 || strings.HasPrefix(args[0], "-") || strings.HasPrefix(args[1], "-") {
+		return fmt.Errorf("import requires a resource type and an ID, e.g. import vpc vpc-0abc; types: %s", strings.Join(importTypes(), ", "))
+	}
+	resourceType, id, args := args[0], args[1], args[2:]
+	if _, ok := importers[resourceType]; !ok {
+		return fmt.Errorf("unknown resource type %q, use one of: %s", resourceType, strings.Join(importTypes(), ", "))
+	}
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("import "+resourceType, flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	clients, err := NewClients(ctx, logger, cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "import")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	state, err := OpenState(ctx, logger, cfg, &opts, clients)
+	if err != nil {
+		return err
+	}
+
+	if err := ImportResource(ctx, clients, state, resourceType, id); err != nil {
+		return err
+	}
+	logger.Printf("Imported %s %s into %s", resourceType, id, state.Location())
+
+	return nil
+}
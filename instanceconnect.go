@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ResourceInstanceConnect = "instance-connect"
+	// An endpoint takes a few minutes to be created and deleted.
+	InstanceConnectEndpointPollInterval = 10 * time.Second
+)
+
+// InstanceConnectEndpointConfig creates an EC2 Instance Connect Endpoint in
+// the VPC, which tunnels SSH to the private IPs of the instances without a
+// bastion.
+type InstanceConnectEndpointConfig struct {
+	// SecurityGroupName names the security group of the endpoint, which can
+	// only SSH to the instances.
+	SecurityGroupName string `json:"securityGroupName"`
+}
+
+// InstanceConnectSubnet returns the subnet the endpoint is placed in: the
+// first private subnet, or the first public one when there are none.
+func InstanceConnectSubnet[S any](subnets, privateSubnets []S) S {
+	if len(privateSubnets) > 0 {
+		return privateSubnets[0]
+	}
+	return subnets[0]
+}
+
+// CreateInstanceConnectSecurityGroup creates the security group of the
+// endpoint, which can only SSH to the instances, and lets it SSH to them.
+func CreateInstanceConnectSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, name, vpcID, securityGroupID string, tags map[string]string) (string, error) {
+	output, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
+		GroupName:         aws.String(name),
+		Description:       aws.String("EC2 Instance Connect Endpoint"),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeSecurityGroup, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating instance connect security group: %w", err)
+	}
+	endpointSecurityGroupID := aws.StringValue(output.GroupId)
+	logger.Printf("Instance connect security group created with ID: %s", endpointSecurityGroupID)
+
+	if _, err := ec2Client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+		GroupId:       aws.String(endpointSecurityGroupID),
+		IpPermissions: []types.IpPermission{sshFrom(securityGroupID, "SSH to the instances")},
+	}); err != nil {
+		return endpointSecurityGroupID, fmt.Errorf("error allowing SSH from the endpoint to the instances: %w", err)
+	}
+	if _, err := ec2Client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+		GroupId:       aws.String(endpointSecurityGroupID),
+		IpPermissions: []types.IpPermission{allowAllRule.IPPermission()},
+	}); err != nil {
+		return endpointSecurityGroupID, fmt.Errorf("error revoking default egress of instance connect security group: %w", err)
+	}
+
+	if _, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+		GroupId:       aws.String(securityGroupID),
+		IpPermissions: []types.IpPermission{sshFromInstanceConnect(endpointSecurityGroupID)},
+	}); err != nil && !hasErrorCode(err, "InvalidPermission.Duplicate") {
+		return endpointSecurityGroupID, fmt.Errorf("error allowing SSH from the endpoint to the instances: %w", err)
+	}
+	logger.Printf("Instance connect endpoint allowed to SSH to the instances of security group %s", securityGroupID)
+	return endpointSecurityGroupID, nil
+}
+
+// sshFromInstanceConnect is the rule of the instance security group letting
+// the endpoint SSH to the instances.
+func sshFromInstanceConnect(endpointSecurityGroupID string) types.IpPermission {
+	return sshFrom(endpointSecurityGroupID, "SSH from the instance connect endpoint")
+}
+
+// describeInstanceConnectEndpoint returns the endpoint, nil when it is gone.
+func describeInstanceConnectEndpoint(ctx context.Context, ec2Client *ec2.Client, endpointID string) (*types.Ec2InstanceConnectEndpoint, error) {
+	output, err := ec2Client.DescribeInstanceConnectEndpoints(ctx, &ec2.DescribeInstanceConnectEndpointsInput{
+		InstanceConnectEndpointIds: []string{endpointID},
+	})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error describing instance connect endpoint %s: %w", endpointID, err)
+	}
+	if len(output.InstanceConnectEndpoints) == 0 {
+		return nil, nil
+	}
+	return &output.InstanceConnectEndpoints[0], nil
+}
+
+// CreateInstanceConnectEndpoint creates the endpoint in the subnet and waits
+// until it is ready.
+func CreateInstanceConnectEndpoint(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, subnetID, endpointSecurityGroupID string, tags map[string]string) (string, error) {
+	output, err := ec2Client.CreateInstanceConnectEndpoint(ctx, &ec2.CreateInstanceConnectEndpointInput{
+		SubnetId:          aws.String(subnetID),
+		SecurityGroupIds:  []string{endpointSecurityGroupID},
+		PreserveClientIp:  aws.Bool(false),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeInstanceConnectEndpoint, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating instance connect endpoint: %w", err)
+	}
+	endpointID := aws.StringValue(output.InstanceConnectEndpoint.InstanceConnectEndpointId)
+	logger.Printf("Instance connect endpoint created with ID: %s", endpointID)
+
+	ticker := time.NewTicker(InstanceConnectEndpointPollInterval)
+	defer ticker.Stop()
+	for {
+		endpoint, err := describeInstanceConnectEndpoint(ctx, ec2Client, endpointID)
+		if err != nil {
+			return endpointID, err
+		}
+		if endpoint != nil {
+			switch endpoint.State {
+			case types.Ec2InstanceConnectEndpointStateCreateComplete:
+				logger.Printf("Instance connect endpoint %s is ready", endpointID)
+				return endpointID, nil
+			case types.Ec2InstanceConnectEndpointStateCreateFailed:
+				return endpointID, fmt.Errorf("instance connect endpoint %s failed: %s", endpointID, aws.StringValue(endpoint.StateMessage))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return endpointID, fmt.Errorf("error waiting for instance connect endpoint: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetupInstanceConnect creates the security group and the endpoint,
+// recording both.
+func SetupInstanceConnect(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State, vpcID, subnetID, securityGroupID string) (string, error) {
+	tags := cfg.StackTags()
+	if state.InstanceConnectSecurityGroupID == "" {
+		endpointSecurityGroupID, err := CreateInstanceConnectSecurityGroup(ctx, logger, ec2Client, cfg.InstanceConnectEndpoint.SecurityGroupName, vpcID, securityGroupID, tags)
+		if endpointSecurityGroupID != "" {
+			if saveErr := state.Record(func(s *State) { s.InstanceConnectSecurityGroupID = endpointSecurityGroupID }); saveErr != nil {
+				return "", saveErr
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	endpointID, err := CreateInstanceConnectEndpoint(ctx, logger, ec2Client, subnetID, state.InstanceConnectSecurityGroupID, tags)
+	if endpointID != "" {
+		if saveErr := state.Record(func(s *State) { s.InstanceConnectEndpointID = endpointID }); saveErr != nil {
+			return endpointID, saveErr
+		}
+	}
+	return endpointID, err
+}
+
+// DeleteInstanceConnect deletes the endpoint, waiting for it to be gone, and
+// its security group after revoking the rule that refers to it.
+func DeleteInstanceConnect(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, state *State) error {
+	if endpointID := state.InstanceConnectEndpointID; endpointID != "" {
+		if _, err := ec2Client.DeleteInstanceConnectEndpoint(ctx, &ec2.DeleteInstanceConnectEndpointInput{
+			InstanceConnectEndpointId: aws.String(endpointID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting instance connect endpoint: %w", err)
+		}
+
+		ticker := time.NewTicker(InstanceConnectEndpointPollInterval)
+		defer ticker.Stop()
+		for {
+			endpoint, err := describeInstanceConnectEndpoint(ctx, ec2Client, endpointID)
+			if err != nil {
+				return err
+			}
+			if endpoint == nil || endpoint.State == types.Ec2InstanceConnectEndpointStateDeleteComplete {
+				break
+			}
+			if endpoint.State == types.Ec2InstanceConnectEndpointStateDeleteFailed {
+				return fmt.Errorf("deleting instance connect endpoint %s failed: %s", endpointID, aws.StringValue(endpoint.StateMessage))
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("error waiting for instance connect endpoint deletion: %w", ctx.Err())
+			case <-ticker.C:
+			}
+		}
+		logger.Printf("Instance connect endpoint %s deleted", endpointID)
+		if err := state.Record(func(s *State) { s.InstanceConnectEndpointID = "" }); err != nil {
+			return err
+		}
+	}
+
+	if state.InstanceConnectSecurityGroupID == "" {
+		return nil
+	}
+	if state.SecurityGroupID != "" {
+		if _, err := ec2Client.RevokeSecurityGroupIngress(ctx, &ec2.RevokeSecurityGroupIngressInput{
+			GroupId:       aws.String(state.SecurityGroupID),
+			IpPermissions: []types.IpPermission{sshFromInstanceConnect(state.InstanceConnectSecurityGroupID)},
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error revoking SSH from the instance connect endpoint: %w", err)
+		}
+	}
+	if err := retryDependencyViolation(ctx, func() error {
+		_, err := ec2Client.DeleteSecurityGroup(ctx, &ec2.DeleteSecurityGroupInput{
+			GroupId: aws.String(state.InstanceConnectSecurityGroupID),
+		})
+		return err
+	}); err != nil {
+		return fmt.Errorf("error deleting instance connect security group: %w", err)
+	}
+	logger.Printf("Instance connect security group %s deleted", state.InstanceConnectSecurityGroupID)
+	return state.Record(func(s *State) { s.InstanceConnectSecurityGroupID = "" })
+}
+
+// UpdateInstanceConnect creates the endpoint when it was added to the config
+// after the stack was applied, and deletes it when it was removed.
+func UpdateInstanceConnect(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State) error {
+	if cfg.InstanceConnectEndpoint == nil {
+		return DeleteInstanceConnect(ctx, logger, ec2Client, state)
+	}
+	if state.InstanceConnectEndpointID != "" {
+		return nil
+	}
+	if len(state.SubnetIDs) == 0 {
+		return fmt.Errorf("the stack has no subnet to create the instance connect endpoint in")
+	}
+	_, err := SetupInstanceConnect(ctx, logger, ec2Client, cfg, state, state.VPCID, InstanceConnectSubnet(state.SubnetIDs, state.PrivateSubnetIDs), state.SecurityGroupID)
+	return err
+}
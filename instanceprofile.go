@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamTypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// A new IAM role or instance profile takes a while until the other
+	// services accept it.
+	IAMPropagationTimeout      = 2 * time.Minute
+	IAMPropagationPollInterval = 5 * time.Second
+)
+
+func iamTags(tags map[string]string) []iamTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]iamTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, iamTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// CreatesInstanceRole reports whether apply creates the instance role, which
+// the instances need for ECS, to read the secrets, to serve Session Manager
+// sessions or to ship their logs, rather than using an existing instance profile.
+// An existing launch template brings its own instance profile.
+func (c *Config) CreatesInstanceRole() bool {
+	return c.NeedsInstanceRole() && c.LaunchTemplate.Existing == nil && c.LaunchTemplate.IAMInstanceProfile == c.LaunchTemplate.InstanceRoleName
+}
+
+// NeedsInstanceRole reports whether the instances need an instance profile.
+func (c *Config) NeedsInstanceRole() bool {
+	return c.ECS != nil || len(c.Secrets) > 0 || c.SessionManagerOnly || c.Logs != nil
+}
+
+// InstanceRolePolicyARNs returns the managed policies attached to the
+// instance role.
+func (c *Config) InstanceRolePolicyARNs() []string {
+	var policyARNs []string
+	if c.ECS != nil {
+		policyARNs = append(policyARNs, ECSInstanceRolePolicyARN)
+	}
+	if c.SessionManagerOnly {
+		policyARNs = append(policyARNs, SSMManagedInstanceCorePolicyARN)
+	}
+	return policyARNs
+}
+
+// InstanceTrustPolicy lets EC2 instances assume the instance role.
+func InstanceTrustPolicy() string {
+	return policyJSON(map[string]any{
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": "ec2.amazonaws.com"},
+		"Action":    "sts:AssumeRole",
+	})
+}
+
+// CreateInstanceRole creates an IAM role for the instances with the given
+// managed policies attached, and the instance profile of the same name the
+// launch template refers to.
+func CreateInstanceRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client, roleName, description string, policyARNs []string, tags map[string]string) error {
+	if _, err := iamClient.CreateRole(ctx, &iam.CreateRoleInput{
+		RoleName:                 aws.String(roleName),
+		AssumeRolePolicyDocument: aws.String(InstanceTrustPolicy()),
+		Description:              aws.String(description),
+		Tags:                     iamTags(tags),
+	}); err != nil {
+		return fmt.Errorf("error creating instance role: %w", err)
+	}
+	logger.Printf("IAM role %s created", roleName)
+
+	for _, policyARN := range policyARNs {
+		if _, err := iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+			RoleName:  aws.String(roleName),
+			PolicyArn: aws.String(policyARN),
+		}); err != nil {
+			return fmt.Errorf("error attaching %s to instance role: %w", policyARN, err)
+		}
+	}
+
+	if _, err := iamClient.CreateInstanceProfile(ctx, &iam.CreateInstanceProfileInput{
+		InstanceProfileName: aws.String(roleName),
+		Tags:                iamTags(tags),
+	}); err != nil {
+		return fmt.Errorf("error creating instance profile: %w", err)
+	}
+	if _, err := iamClient.AddRoleToInstanceProfile(ctx, &iam.AddRoleToInstanceProfileInput{
+		InstanceProfileName: aws.String(roleName),
+		RoleName:            aws.String(roleName),
+	}); err != nil {
+		return fmt.Errorf("error adding role to instance profile: %w", err)
+	}
+	logger.Printf("Instance profile %s created", roleName)
+
+	return nil
+}
+
+// DeleteInstanceRole deletes the instance profile and the role created by
+// CreateInstanceRole. Parts that are already gone are skipped.
+func DeleteInstanceRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client, roleName string) error {
+	if _, err := iamClient.RemoveRoleFromInstanceProfile(ctx, &iam.RemoveRoleFromInstanceProfileInput{
+		InstanceProfileName: aws.String(roleName),
+		RoleName:            aws.String(roleName),
+	}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+		return fmt.Errorf("error removing role from instance profile: %w", err)
+	}
+	if _, err := iamClient.DeleteInstanceProfile(ctx, &iam.DeleteInstanceProfileInput{
+		InstanceProfileName: aws.String(roleName),
+	}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+		return fmt.Errorf("error deleting instance profile: %w", err)
+	}
+	logger.Printf("Instance profile %s deleted", roleName)
+
+	return DeleteRole(ctx, logger, iamClient, roleName)
+}
+
+// DeleteRole deletes an IAM role along with its inline policies, detaching
+// the managed ones first. A role that is already gone is skipped.
+func DeleteRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client, roleName string) error {
+	inline := iam.NewListRolePoliciesPaginator(iamClient, &iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if hasErrorCode(err, "NoSuchEntity") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error listing inline policies of role %s: %w", roleName, err)
+		}
+		for _, policyName := range page.PolicyNames {
+			if _, err := iamClient.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+				RoleName:   aws.String(roleName),
+				PolicyName: aws.String(policyName),
+			}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+				return fmt.Errorf("error deleting policy %s of role %s: %w", policyName, roleName, err)
+			}
+		}
+	}
+
+	attached := iam.NewListAttachedRolePoliciesPaginator(iamClient, &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if hasErrorCode(err, "NoSuchEntity") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error listing policies of role %s: %w", roleName, err)
+		}
+		for _, policy := range page.AttachedPolicies {
+			if _, err := iamClient.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+				RoleName:  aws.String(roleName),
+				PolicyArn: policy.PolicyArn,
+			}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+				return fmt.Errorf("error detaching %s from role %s: %w", aws.StringValue(policy.PolicyArn), roleName, err)
+			}
+		}
+	}
+
+	if _, err := iamClient.DeleteRole(ctx, &iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+		return fmt.Errorf("error deleting role %s: %w", roleName, err)
+	}
+	logger.Printf("IAM role %s deleted", roleName)
+
+	return nil
+}
+
+// retryIAMPropagation calls fn until its error stops mentioning message, the
+// way services reject a freshly created role or instance profile.
+func retryIAMPropagation(ctx context.Context, message string, fn func() error) error {
+	ctx, cancel := context.WithTimeout(ctx, IAMPropagationTimeout)
+	defer cancel()
+	ticker := time.NewTicker(IAMPropagationPollInterval)
+	defer ticker.Stop()
+	for {
+		err := fn()
+		if err == nil || !strings.Contains(strings.ToLower(err.Error()), message) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	CPUManufacturerIntel = "intel"
+	CPUManufacturerAMD   = "amd"
+	CPUManufacturerAWS   = "amazon-web-services"
+)
+
+// CPUManufacturers are the manufacturers the instance types can be selected
+// by. Graviton is the only arm64 one.
+var CPUManufacturers = []string{CPUManufacturerIntel, CPUManufacturerAMD, CPUManufacturerAWS}
+
+// BurstablePerformanceOptions say whether burstable (T) instance types are
+// selected too, left out or the only ones selected.
+var BurstablePerformanceOptions = []string{"included", "excluded", "required"}
+
+// RangeConfig is an inclusive range, a Max of 0 leaves it open.
+type RangeConfig struct {
+	Min int32 `json:"min"`
+	Max int32 `json:"max"`
+}
+
+func (r RangeConfig) String() string {
+	if r.Max == 0 {
+		return fmt.Sprintf("%d+", r.Min)
+	}
+	if r.Min == r.Max {
+		return fmt.Sprint(r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r RangeConfig) max() *int32 {
+	if r.Max == 0 {
+		return nil
+	}
+	return aws.Int32(r.Max)
+}
+
+// InstanceRequirementsConfig lets the autoscaling group launch any instance
+// type with the given attributes instead of launchTemplate.instanceType,
+// which then only decides the architecture of the AMI.
+type InstanceRequirementsConfig struct {
+	VCPUs     RangeConfig `json:"vcpus"`
+	MemoryMiB RangeConfig `json:"memoryMiB"`
+	// ExcludedInstanceTypes leaves out instance types or whole families with
+	// * wildcards, e.g. t2.* or r6*.
+	ExcludedInstanceTypes []string `json:"excludedInstanceTypes"`
+	// CPUManufacturers default to those of the architecture of
+	// launchTemplate.instanceType, so every selected type can boot the AMI.
+	CPUManufacturers []string `json:"cpuManufacturers"`
+	// BurstablePerformance is included, excluded or required. AWS leaves
+	// burstable types out by default.
+	BurstablePerformance string `json:"burstablePerformance"`
+}
+
+// architectureCPUManufacturers returns the manufacturers of the instance
+// types with the given architecture.
+func architectureCPUManufacturers(architecture string) []string {
+	if architecture == ArchitectureARM {
+		return []string{CPUManufacturerAWS}
+	}
+	return []string{CPUManufacturerIntel, CPUManufacturerAMD}
+}
+
+func (r InstanceRequirementsConfig) String() string {
+	description := fmt.Sprintf("%s vCPUs, %s MiB, %s", r.VCPUs, r.MemoryMiB, strings.Join(r.CPUManufacturers, "/"))
+	if len(r.ExcludedInstanceTypes) > 0 {
+		description += ", excluding " + strings.Join(r.ExcludedInstanceTypes, ", ")
+	}
+	if r.BurstablePerformance != "" {
+		description += ", burstable " + r.BurstablePerformance
+	}
+	return description
+}
+
+func (r InstanceRequirementsConfig) autoscalingRequirements() *autoscalingTypes.InstanceRequirements {
+	requirements := &autoscalingTypes.InstanceRequirements{
+		VCpuCount: &autoscalingTypes.VCpuCountRequest{Min: aws.Int32(r.VCPUs.Min), Max: r.VCPUs.max()},
+		MemoryMiB: &autoscalingTypes.MemoryMiBRequest{Min: aws.Int32(r.MemoryMiB.Min), Max: r.MemoryMiB.max()},
+	}
+	requirements.ExcludedInstanceTypes = r.ExcludedInstanceTypes
+	for _, manufacturer := range r.CPUManufacturers {
+		requirements.CpuManufacturers = append(requirements.CpuManufacturers, autoscalingTypes.CpuManufacturer(manufacturer))
+	}
+	if r.BurstablePerformance != "" {
+		requirements.BurstablePerformance = autoscalingTypes.BurstablePerformance(r.BurstablePerformance)
+	}
+	return requirements
+}
+
+// groupInstanceRequirements returns the instance requirements of an existing
+// group, nil when it launches a fixed instance type.
+func groupInstanceRequirements(group autoscalingTypes.AutoScalingGroup) *autoscalingTypes.InstanceRequirements {
+	policy := group.MixedInstancesPolicy
+	if policy == nil || policy.LaunchTemplate == nil || len(policy.LaunchTemplate.Overrides) == 0 {
+		return nil
+	}
+	return policy.LaunchTemplate.Overrides[0].InstanceRequirements
+}
+
+// instanceRequirementsConfig converts the requirements of an existing group
+// back to the config, leaving out burstablePerformance when the config does
+// too, as AWS then reports its default.
+func instanceRequirementsConfig(live *autoscalingTypes.InstanceRequirements, withBurstable bool) *InstanceRequirementsConfig {
+	if live == nil || live.VCpuCount == nil || live.MemoryMiB == nil {
+		return nil
+	}
+	requirements := &InstanceRequirementsConfig{
+		VCPUs:                 RangeConfig{Min: aws.Int32Value(live.VCpuCount.Min), Max: aws.Int32Value(live.VCpuCount.Max)},
+		MemoryMiB:             RangeConfig{Min: aws.Int32Value(live.MemoryMiB.Min), Max: aws.Int32Value(live.MemoryMiB.Max)},
+		ExcludedInstanceTypes: live.ExcludedInstanceTypes,
+	}
+	for _, manufacturer := range live.CpuManufacturers {
+		requirements.CPUManufacturers = append(requirements.CPUManufacturers, string(manufacturer))
+	}
+	if withBurstable {
+		requirements.BurstablePerformance = string(live.BurstablePerformance)
+	}
+	return requirements
+}
+
+// describeInstanceRequirements returns the requirements as they are compared
+// against the live group, empty for a fixed instance type.
+func describeInstanceRequirements(requirements *InstanceRequirementsConfig) string {
+	if requirements == nil {
+		return ""
+	}
+	return requirements.String()
+}
+
+// Matches reports whether the requirements of an existing group are the
+// configured ones.
+func (r InstanceRequirementsConfig) Matches(live *autoscalingTypes.InstanceRequirements) bool {
+	current := instanceRequirementsConfig(live, r.BurstablePerformance != "")
+	return current != nil && current.VCPUs == r.VCPUs && current.MemoryMiB == r.MemoryMiB &&
+		slices.Equal(current.ExcludedInstanceTypes, r.ExcludedInstanceTypes) &&
+		slices.Equal(current.CPUManufacturers, r.CPUManufacturers) &&
+		current.BurstablePerformance == r.BurstablePerformance
+}
+
+// MixedInstancesPolicy selects the instance types of the group by their
+// attributes, launching them from the given launch template version.
+func (r InstanceRequirementsConfig) MixedInstancesPolicy(launchTemplateID, version string) *autoscalingTypes.MixedInstancesPolicy {
+	return &autoscalingTypes.MixedInstancesPolicy{
+		LaunchTemplate: &autoscalingTypes.LaunchTemplate{
+			LaunchTemplateSpecification: &autoscalingTypes.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String(launchTemplateID),
+				Version:          aws.String(version),
+			},
+			Overrides: []autoscalingTypes.LaunchTemplateOverrides{
+				{InstanceRequirements: r.autoscalingRequirements()},
+			},
+		},
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+const (
+	OnInterruptAsk      = "ask"
+	OnInterruptRollback = "rollback"
+	OnInterruptKeep     = "keep"
+)
+
+// ErrInterrupted is returned by Apply when it was stopped by a signal.
+var ErrInterrupted = errors.New("interrupted")
+
+// InterruptibleProgress stops an apply before its next step once ctx is
+// done. Steps already running are left to finish, so every resource they
+// create is recorded in state.
+type InterruptibleProgress struct {
+	Progress
+	ctx context.Context
+}
+
+func NewInterruptibleProgress(ctx context.Context, progress Progress) InterruptibleProgress {
+	return InterruptibleProgress{Progress: progress, ctx: ctx}
+}
+
+func (p InterruptibleProgress) Track(step string, fn func() (string, error)) error {
+	if p.ctx.Err() != nil {
+		return fmt.Errorf("stopped before step %s: %w", step, ErrInterrupted)
+	}
+	return p.Progress.Track(step, fn)
+}
+
+func ValidateOnInterrupt(onInterrupt string) error {
+	switch onInterrupt {
+	case OnInterruptAsk, OnInterruptRollback, OnInterruptKeep:
+		return nil
+	default:
+		return fmt.Errorf("unknown --on-interrupt %q, use %s, %s or %s", onInterrupt, OnInterruptAsk, OnInterruptRollback, OnInterruptKeep)
+	}
+}
+
+// HandleInterrupt deals with the resources an interrupted apply left behind:
+// it deletes them or keeps them for a later apply or destroy, as chosen by
+// onInterrupt.
+func HandleInterrupt(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, onInterrupt string) error {
+	if state.IsEmpty() {
+		logger.Println("Apply interrupted before anything was created")
+		return ErrInterrupted
+	}
+	logger.Printf("Apply interrupted, the resources created so far are recorded in %s", state.Location())
+
+	rollback := onInterrupt == OnInterruptRollback
+	if onInterrupt == OnInterruptAsk {
+		var err error
+		rollback, err = Confirm("Delete the resources created so far?")
+		if err != nil {
+			return err
+		}
+	}
+
+	if !rollback {
+		logger.Printf("Resources kept in %s, run destroy to delete them", state.Location())
+		return ErrInterrupted
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, DestroyTimeout)
+	defer cancelFunc()
+
+	if err := Destroy(ctx, logger, clients, cfg, state); err != nil {
+		return fmt.Errorf("error rolling back interrupted apply: %w", err)
+	}
+	return ErrInterrupted
+}
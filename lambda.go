@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ELBServicePrincipal = "elasticloadbalancing.amazonaws.com"
+	// DefaultLambdaRulePriority is the priority of the rule of the first
+	// Lambda target without one, the next ones follow in steps of 10.
+	DefaultLambdaRulePriority = 100
+	MaxRulePriority           = 50000
+	// MaxRuleConditionValues is the number of values a rule condition may
+	// have.
+	MaxRuleConditionValues = 5
+)
+
+// LambdaTargetConfig serves the requests matching PathPatterns from a Lambda
+// function, through a listener rule forwarding to a target group of type
+// lambda. The rest of the traffic still goes to the instances.
+type LambdaTargetConfig struct {
+	// Name is the name of the target group.
+	Name         string   `json:"name"`
+	FunctionARN  string   `json:"functionArn"`
+	PathPatterns []string `json:"pathPatterns"`
+	// Priority orders the listener rules, lower values are evaluated first.
+	Priority int32 `json:"priority"`
+	// MultiValueHeaders passes repeated headers and query parameters to the
+	// function as lists.
+	MultiValueHeaders bool `json:"multiValueHeaders"`
+}
+
+// StatementID identifies the permission that allows the load balancer to
+// invoke the function.
+func (l LambdaTargetConfig) StatementID() string {
+	return "elb-" + l.Name
+}
+
+// LambdaTarget records the resources created for a Lambda target.
+type LambdaTarget struct {
+	FunctionARN    string `json:"functionArn"`
+	StatementID    string `json:"statementId,omitempty"`
+	TargetGroupARN string `json:"targetGroupArn,omitempty"`
+	RuleARN        string `json:"ruleArn,omitempty"`
+}
+
+// CreateLambdaTarget creates the target group of the function, allows the
+// load balancer to invoke it and adds the listener rule. actions are the
+// actions of the rule. The returned target records what was created, also
+// when it fails halfway.
+func CreateLambdaTarget(ctx context.Context, logger *log.Logger, clients *Clients, lambdaConfig LambdaTargetConfig, listenerARN string, actions func(targetGroupARN string) []elbTypes.Action, tags map[string]string) (LambdaTarget, error) {
+	target := LambdaTarget{FunctionARN: lambdaConfig.FunctionARN}
+
+	output, err := clients.ELB.CreateTargetGroup(ctx, &elasticloadbalancingv2.CreateTargetGroupInput{
+		Name:       aws.String(lambdaConfig.Name),
+		TargetType: elbTypes.TargetTypeEnumLambda,
+		Tags:       elbTags(tags),
+	})
+	if err != nil {
+		return target, fmt.Errorf("error creating target group %s: %w", lambdaConfig.Name, err)
+	}
+	target.TargetGroupARN = aws.StringValue(output.TargetGroups[0].TargetGroupArn)
+	logger.Printf("Lambda target group created with ARN: %s", target.TargetGroupARN)
+
+	if lambdaConfig.MultiValueHeaders {
+		if _, err := clients.ELB.ModifyTargetGroupAttributes(ctx, &elasticloadbalancingv2.ModifyTargetGroupAttributesInput{
+			TargetGroupArn: aws.String(target.TargetGroupARN),
+			Attributes: []elbTypes.TargetGroupAttribute{
+				{Key: aws.String("lambda.multi_value_headers.enabled"), Value: aws.String("true")},
+			},
+		}); err != nil {
+			return target, fmt.Errorf("error enabling multi value headers: %w", err)
+		}
+	}
+
+	if _, err := clients.Lambda.AddPermission(ctx, &lambda.AddPermissionInput{
+		FunctionName: aws.String(lambdaConfig.FunctionARN),
+		StatementId:  aws.String(lambdaConfig.StatementID()),
+		Action:       aws.String("lambda:InvokeFunction"),
+		Principal:    aws.String(ELBServicePrincipal),
+		SourceArn:    aws.String(target.TargetGroupARN),
+	}); err != nil {
+		return target, fmt.Errorf("error allowing the load balancer to invoke %s: %w", lambdaConfig.FunctionARN, err)
+	}
+	target.StatementID = lambdaConfig.StatementID()
+
+	if _, err := clients.ELB.RegisterTargets(ctx, &elasticloadbalancingv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(target.TargetGroupARN),
+		Targets:        []elbTypes.TargetDescription{{Id: aws.String(lambdaConfig.FunctionARN)}},
+	}); err != nil {
+		return target, fmt.Errorf("error registering %s: %w", lambdaConfig.FunctionARN, err)
+	}
+
+	ruleOutput, err := clients.ELB.CreateRule(ctx, &elasticloadbalancingv2.CreateRuleInput{
+		ListenerArn: aws.String(listenerARN),
+		Priority:    aws.Int32(lambdaConfig.Priority),
+		Conditions: []elbTypes.RuleCondition{
+			{
+				Field:             aws.String("path-pattern"),
+				PathPatternConfig: &elbTypes.PathPatternConditionConfig{Values: lambdaConfig.PathPatterns},
+			},
+		},
+		Actions: actions(target.TargetGroupARN),
+		Tags:    elbTags(tags),
+	})
+	if err != nil {
+		return target, fmt.Errorf("error creating listener rule for %s: %w", lambdaConfig.Name, err)
+	}
+	target.RuleARN = aws.StringValue(ruleOutput.Rules[0].RuleArn)
+	logger.Printf("Listener rule created with ARN: %s", target.RuleARN)
+
+	return target, nil
+}
+
+// DeleteLambdaTarget removes the listener rule, the target group and the
+// permission of a Lambda target. Resources already gone are skipped.
+func DeleteLambdaTarget(ctx context.Context, logger *log.Logger, clients *Clients, target LambdaTarget) error {
+	if target.RuleARN != "" {
+		if _, err := clients.ELB.DeleteRule(ctx, &elasticloadbalancingv2.DeleteRuleInput{
+			RuleArn: aws.String(target.RuleARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting listener rule: %w", err)
+		}
+		logger.Printf("Listener rule %s deleted", target.RuleARN)
+	}
+
+	if target.TargetGroupARN != "" {
+		if _, err := clients.ELB.DeleteTargetGroup(ctx, &elasticloadbalancingv2.DeleteTargetGroupInput{
+			TargetGroupArn: aws.String(target.TargetGroupARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting target group: %w", err)
+		}
+		logger.Printf("Lambda target group %s deleted", target.TargetGroupARN)
+	}
+
+	if target.StatementID != "" {
+		if _, err := clients.Lambda.RemovePermission(ctx, &lambda.RemovePermissionInput{
+			FunctionName: aws.String(target.FunctionARN),
+			StatementId:  aws.String(target.StatementID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error removing permission from %s: %w", target.FunctionARN, err)
+		}
+		logger.Printf("Permission %s removed from %s", target.StatementID, target.FunctionARN)
+	}
+
+	return nil
+}
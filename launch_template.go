@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	DefaultRootDeviceName = "/dev/xvda" // root device of the Amazon Linux AMIs
+	CPUCreditsStandard    = "standard"
+	CPUCreditsUnlimited   = "unlimited"
+)
+
+// CPUCreditOptions say whether burstable instances are throttled to their
+// baseline once their CPU credits run out, or keep bursting at an extra
+// charge.
+var CPUCreditOptions = []string{CPUCreditsStandard, CPUCreditsUnlimited}
+
+// AWSDefaultLaunchTemplateVersion is the version of a launch template marked
+// as its default, which an existing launch template is used at unless
+// launchTemplate.existing.version is set.
+const AWSDefaultLaunchTemplateVersion = "$Default"
+
+// LaunchTemplateVersion returns the version of the existing launch template
+// the autoscaling groups launch instances from.
+func (e ExistingLaunchTemplateConfig) LaunchTemplateVersion() string {
+	if e.Version == "" {
+		return AWSDefaultLaunchTemplateVersion
+	}
+	return e.Version
+}
+
+// UseExistingLaunchTemplate looks up the launch template of
+// launchTemplate.existing and checks it has the configured version. It
+// returns the ID of the launch template.
+func UseExistingLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, existing ExistingLaunchTemplateConfig) (string, error) {
+	input := &ec2.DescribeLaunchTemplatesInput{}
+	if existing.ID != "" {
+		input.LaunchTemplateIds = []string{existing.ID}
+	} else {
+		input.LaunchTemplateNames = []string{existing.Name}
+	}
+	output, err := ec2Client.DescribeLaunchTemplates(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error describing launch template %s: %w", existing.ID+existing.Name, err)
+	}
+	if len(output.LaunchTemplates) == 0 {
+		return "", fmt.Errorf("launch template %s not found", existing.ID+existing.Name)
+	}
+	launchTemplate := output.LaunchTemplates[0]
+	launchTemplateID := aws.StringValue(launchTemplate.LaunchTemplateId)
+
+	version := existing.LaunchTemplateVersion()
+	if number, err := strconv.ParseInt(version, 10, 64); err == nil && number > aws.Int64Value(launchTemplate.LatestVersionNumber) {
+		return "", fmt.Errorf("launch template %s has no version %d, its latest version is %d", launchTemplateID, number, aws.Int64Value(launchTemplate.LatestVersionNumber))
+	}
+	logger.Printf("Using existing launch template %s (%s) at version %s", launchTemplateID, aws.StringValue(launchTemplate.LaunchTemplateName), version)
+	return launchTemplateID, nil
+}
+
+// CreateLaunchTemplate creates the launch template and returns its ID along
+// with the hash of the launch template data, see LaunchTemplateDataHash.
+func CreateLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, ltConfig LaunchTemplateConfig, securityGroupID, volumeKeyARN string, tags map[string]string) (string, string, error) {
+	data, err := LaunchTemplateData(ltConfig, securityGroupID, volumeKeyARN)
+	if err != nil {
+		return "", "", err
+	}
+	if ltConfig.App == nil {
+		logger.Printf("%s file read successfully", ltConfig.UserDataFile)
+	}
+
+	dataHash, err := LaunchTemplateDataHash(data)
+	if err != nil {
+		return "", "", err
+	}
+
+	ec2LaunchTemplate, err := ec2Client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateData: data,
+		LaunchTemplateName: aws.String(ltConfig.Name),
+		TagSpecifications:  ec2TagSpecifications(types.ResourceTypeLaunchTemplate, tags),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error creating launch template: %w", err)
+	}
+	logger.Printf("Launch template created with ID: %s", *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId)
+
+	return *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId, dataHash, nil
+}
+
+// LaunchTemplateData builds the launch template data from the config. It is
+// used for the initial template as well as for every later version. With a
+// volume key, all volumes are encrypted with it.
+func LaunchTemplateData(ltConfig LaunchTemplateConfig, securityGroupID, volumeKeyARN string) (*types.RequestLaunchTemplateData, error) {
+	base64UserData, err := ReadUserData(ltConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &types.RequestLaunchTemplateData{
+		UserData:     aws.String(base64UserData),
+		ImageId:      aws.String(ltConfig.AMIID),
+		InstanceType: types.InstanceType(ltConfig.InstanceType),
+		SecurityGroupIds: []string{
+			securityGroupID,
+		},
+		BlockDeviceMappings: blockDeviceMappings(ltConfig, volumeKeyARN),
+		MetadataOptions: &types.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            types.LaunchTemplateInstanceMetadataEndpointStateEnabled,
+			HttpTokens:              types.LaunchTemplateHttpTokensState(ltConfig.Metadata.HTTPTokens()),
+			HttpPutResponseHopLimit: aws.Int32(ltConfig.Metadata.HopLimit),
+			InstanceMetadataTags:    types.LaunchTemplateInstanceMetadataTagsState(ltConfig.Metadata.InstanceMetadataTags()),
+		},
+		Monitoring: &types.LaunchTemplatesMonitoringRequest{
+			Enabled: aws.Bool(ltConfig.DetailedMonitoring),
+		},
+	}
+	data.Placement = launchTemplatePlacement(ltConfig)
+	if ltConfig.Hibernation {
+		data.HibernationOptions = &types.LaunchTemplateHibernationOptionsRequest{
+			Configured: aws.Bool(true),
+		}
+	}
+	if ltConfig.CPUCredits != "" {
+		data.CreditSpecification = &types.CreditSpecificationRequest{
+			CpuCredits: aws.String(ltConfig.CPUCredits),
+		}
+	}
+	if ltConfig.IAMInstanceProfile != "" {
+		data.IamInstanceProfile = &types.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Name: aws.String(ltConfig.IAMInstanceProfile),
+		}
+	}
+	return data, nil
+}
+
+// LaunchTemplateDataHash fingerprints launch template data, so apply can
+// tell whether the config changed since the last version was created.
+func LaunchTemplateDataHash(data *types.RequestLaunchTemplateData) (string, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return "", fmt.Errorf("error encoding launch template data: %w", err)
+	}
+
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// blockDeviceMappings returns the root volume, when it overrides the AMI
+// defaults, and the data volumes, encrypted with the volume key if any.
+func blockDeviceMappings(ltConfig LaunchTemplateConfig, volumeKeyARN string) []types.LaunchTemplateBlockDeviceMappingRequest {
+	var mappings []types.LaunchTemplateBlockDeviceMappingRequest
+	if rootVolume := ltConfig.RootVolume; rootVolume != nil {
+		ebs := &types.LaunchTemplateEbsBlockDeviceRequest{
+			DeleteOnTermination: aws.Bool(true),
+			Encrypted:           aws.Bool(rootVolume.Encrypted),
+		}
+		if rootVolume.VolumeType != "" {
+			ebs.VolumeType = types.VolumeType(rootVolume.VolumeType)
+		}
+		if rootVolume.SizeGiB > 0 {
+			ebs.VolumeSize = aws.Int32(rootVolume.SizeGiB)
+		}
+		if rootVolume.IOPS > 0 {
+			ebs.Iops = aws.Int32(rootVolume.IOPS)
+		}
+		if rootVolume.Throughput > 0 {
+			ebs.Throughput = aws.Int32(rootVolume.Throughput)
+		}
+		mappings = append(mappings, types.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName: aws.String(rootVolume.Device()),
+			Ebs:        ebs,
+		})
+	}
+	for _, volume := range ltConfig.DataVolumes {
+		mappings = append(mappings, volume.blockDeviceMapping())
+	}
+	if volumeKeyARN != "" {
+		for _, mapping := range mappings {
+			mapping.Ebs.Encrypted = aws.Bool(true)
+			mapping.Ebs.KmsKeyId = aws.String(volumeKeyARN)
+		}
+	}
+	return mappings
+}
+
+// UserData returns the user data script of the launch template, generated
+// for the app or read from the user data file, joining the ECS cluster,
+// mounting the data volumes, starting the CloudWatch agent and reading the
+// secrets first. With user data parts, it is the multipart document of the
+// parts instead, and on Windows the PowerShell script.
+func UserData(ltConfig LaunchTemplateConfig) ([]byte, error) {
+	if len(ltConfig.UserDataParts) > 0 {
+		return MultipartUserData(ltConfig)
+	}
+
+	var userDataBytes []byte
+	if ltConfig.Windows {
+		userDataBytes, err := os.ReadFile(ltConfig.UserDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s file: %w", ltConfig.UserDataFile, err)
+		}
+		return WindowsUserData(ltConfig.Secrets, userDataBytes), nil
+	}
+	if ltConfig.App != nil {
+		userDataBytes = ltConfig.App.UserData()
+	} else {
+		var err error
+		userDataBytes, err = os.ReadFile(ltConfig.UserDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s file: %w", ltConfig.UserDataFile, err)
+		}
+	}
+	if len(ltConfig.Secrets) > 0 {
+		userDataBytes = SecretsUserData(ltConfig.Secrets, userDataBytes)
+	}
+	if len(ltConfig.LogGroups) > 0 {
+		userDataBytes = LogsUserData(ltConfig.LogGroups, userDataBytes)
+	}
+	if mountsDataVolumes(ltConfig.DataVolumes) {
+		userDataBytes = DataVolumesUserData(ltConfig.DataVolumes, userDataBytes)
+	}
+	if ltConfig.ECSCluster != "" {
+		userDataBytes = ECSUserData(ltConfig.ECSCluster, userDataBytes)
+	}
+	return userDataBytes, nil
+}
+
+// stripShebang drops the interpreter line of a script, so lines can be
+// prepended to it under a new one.
+func stripShebang(script []byte) []byte {
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		return script
+	}
+	if i := bytes.IndexByte(script, '\n'); i >= 0 {
+		return script[i+1:]
+	}
+	return nil
+}
+
+// ReadUserData returns the base64 encoded user data script of the launch
+// template.
+func ReadUserData(ltConfig LaunchTemplateConfig) (string, error) {
+	userDataBytes, err := UserData(ltConfig)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(userDataBytes), nil
+}
+
+// LaunchTemplateVersionFor returns the launch template version the
+// autoscaling group should track: the given version number when pinned,
+// $Latest otherwise.
+func LaunchTemplateVersionFor(ltConfig LaunchTemplateConfig, versionNumber int64) string {
+	if ltConfig.PinVersion {
+		return strconv.FormatInt(versionNumber, 10)
+	}
+	return AWSLaunchTemplateVersion
+}
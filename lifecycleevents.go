@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	eventsTypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snsTypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ResourceLifecycleEvents = "lifecycle-events"
+	EventsServicePrincipal  = "events.amazonaws.com"
+	// LifecycleEventsTargetID identifies the only target of the rule.
+	LifecycleEventsTargetID = "lifecycle-events"
+)
+
+var (
+	// ruleNamePattern matches the names EventBridge accepts for a rule.
+	ruleNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+	// topicNamePattern matches the names SNS accepts for a standard topic.
+	topicNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+)
+
+// LifecycleEventTypes are the detail types of the events Amazon EC2 Auto
+// Scaling sends when it launches or terminates an instance.
+var LifecycleEventTypes = []string{
+	"EC2 Instance Launch Successful",
+	"EC2 Instance Launch Unsuccessful",
+	"EC2 Instance Terminate Successful",
+	"EC2 Instance Terminate Unsuccessful",
+}
+
+// LifecycleEventsConfig sends the launch and terminate events of the
+// autoscaling groups of the stack to an SNS topic or a Lambda function
+// through an EventBridge rule. Without TopicARN and FunctionARN a topic is
+// created for them.
+type LifecycleEventsConfig struct {
+	RuleName string `json:"ruleName"`
+	// TopicARN is an existing topic, its policy must allow EventBridge to
+	// publish to it.
+	TopicARN string `json:"topicArn"`
+	// FunctionARN is a Lambda function invoked with every event instead.
+	FunctionARN string `json:"functionArn"`
+	// TopicName names the topic created when neither is set.
+	TopicName string `json:"topicName"`
+	// Emails are subscribed to the topic. SNS sends each address a
+	// confirmation email, no events reach it before it is confirmed.
+	Emails []string `json:"emails"`
+}
+
+// CreatesTopic reports whether apply creates the topic the events go to.
+func (l LifecycleEventsConfig) CreatesTopic() bool {
+	return l.TopicARN == "" && l.FunctionARN == ""
+}
+
+// StatementID identifies the permission that allows EventBridge to invoke
+// the function.
+func (l LifecycleEventsConfig) StatementID() string {
+	return "events-" + l.RuleName
+}
+
+func (l LifecycleEventsConfig) String() string {
+	switch {
+	case l.FunctionARN != "":
+		return l.RuleName + " to function " + l.FunctionARN
+	case l.TopicARN != "":
+		return l.RuleName + " to topic " + l.TopicARN
+	default:
+		return l.RuleName + " to new topic " + l.TopicName
+	}
+}
+
+// LifecycleEventsState records the resources created for the lifecycle
+// events.
+type LifecycleEventsState struct {
+	RuleName string `json:"ruleName"`
+	RuleARN  string `json:"ruleArn,omitempty"`
+	// TopicARN is only set for a created topic.
+	TopicARN    string `json:"topicArn,omitempty"`
+	FunctionARN string `json:"functionArn,omitempty"`
+	StatementID string `json:"statementId,omitempty"`
+	// Subscriptions are the ARNs of the email subscriptions by address.
+	Subscriptions map[string]string `json:"subscriptions,omitempty"`
+}
+
+// LifecycleEventGroups returns the autoscaling groups whose events the rule
+// matches: the main group and the canary group, if any.
+func LifecycleEventGroups(cfg *Config) []string {
+	groups := []string{cfg.AutoScaling.Name}
+	if cfg.Canary != nil {
+		groups = append(groups, cfg.Canary.AutoScalingGroupName)
+	}
+	return groups
+}
+
+// LifecycleEventPattern matches the launch and terminate events of the
+// groups.
+func LifecycleEventPattern(groups []string) string {
+	data, _ := json.Marshal(map[string]any{
+		"source":      []string{"aws.autoscaling"},
+		"detail-type": LifecycleEventTypes,
+		"detail":      map[string][]string{"AutoScalingGroupName": groups},
+	})
+	return string(data)
+}
+
+// LifecycleTopicPolicy lets the rule, and only the rule, publish to the
+// topic.
+func LifecycleTopicPolicy(topicARN, ruleARN string) string {
+	return policyJSON(map[string]any{
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": EventsServicePrincipal},
+		"Action":    "sns:Publish",
+		"Resource":  topicARN,
+		"Condition": map[string]any{"ArnEquals": map[string]string{"aws:SourceArn": ruleARN}},
+	})
+}
+
+func eventsTags(tags map[string]string) []eventsTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]eventsTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, eventsTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+func snsTags(tags map[string]string) []snsTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]snsTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, snsTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// LifecycleTopicARN returns the topic created for the lifecycle events,
+// empty when there is none.
+func (s *State) LifecycleTopicARN() string {
+	if s.LifecycleEvents == nil {
+		return ""
+	}
+	return s.LifecycleEvents.TopicARN
+}
+
+func recordLifecycleEvents(state *State, events LifecycleEventsState) error {
+	return state.Record(func(s *State) { s.LifecycleEvents = &events })
+}
+
+// SetupLifecycleEvents puts the rule, creates the topic or allows
+// EventBridge to invoke the function, and points the rule at it, recording
+// each resource as soon as it exists. Putting the rule and the target again
+// updates them, so it also applies config changes.
+func SetupLifecycleEvents(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) (string, error) {
+	eventsConfig := *cfg.LifecycleEvents
+	tags := cfg.StackTags()
+	events := LifecycleEventsState{RuleName: eventsConfig.RuleName}
+	if state.LifecycleEvents != nil {
+		events = *state.LifecycleEvents
+	}
+
+	ruleOutput, err := clients.Events.PutRule(ctx, &eventbridge.PutRuleInput{
+		Name:         aws.String(eventsConfig.RuleName),
+		Description:  aws.String("Launch and terminate events of " + cfg.AutoScaling.Name),
+		EventPattern: aws.String(LifecycleEventPattern(LifecycleEventGroups(cfg))),
+		State:        eventsTypes.RuleStateEnabled,
+		Tags:         eventsTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error putting rule %s: %w", eventsConfig.RuleName, err)
+	}
+	events.RuleARN = aws.StringValue(ruleOutput.RuleArn)
+	logger.Printf("Rule %s matches the lifecycle events", eventsConfig.RuleName)
+	if err := recordLifecycleEvents(state, events); err != nil {
+		return "", err
+	}
+
+	targetARN := eventsConfig.FunctionARN
+	if eventsConfig.TopicARN != "" {
+		targetARN = eventsConfig.TopicARN
+	}
+	if eventsConfig.CreatesTopic() {
+		if events.TopicARN == "" {
+			topicOutput, err := clients.SNS.CreateTopic(ctx, &sns.CreateTopicInput{
+				Name: aws.String(eventsConfig.TopicName),
+				Tags: snsTags(tags),
+			})
+			if err != nil {
+				return "", fmt.Errorf("error creating topic %s: %w", eventsConfig.TopicName, err)
+			}
+			events.TopicARN = aws.StringValue(topicOutput.TopicArn)
+			logger.Printf("Topic created with ARN: %s", events.TopicARN)
+			if err := recordLifecycleEvents(state, events); err != nil {
+				return "", err
+			}
+		}
+		if _, err := clients.SNS.SetTopicAttributes(ctx, &sns.SetTopicAttributesInput{
+			TopicArn:       aws.String(events.TopicARN),
+			AttributeName:  aws.String("Policy"),
+			AttributeValue: aws.String(LifecycleTopicPolicy(events.TopicARN, events.RuleARN)),
+		}); err != nil {
+			return "", fmt.Errorf("error allowing the rule to publish to %s: %w", events.TopicARN, err)
+		}
+		targetARN = events.TopicARN
+	}
+	if eventsConfig.FunctionARN != "" && events.StatementID == "" {
+		if _, err := clients.Lambda.AddPermission(ctx, &lambda.AddPermissionInput{
+			FunctionName: aws.String(eventsConfig.FunctionARN),
+			StatementId:  aws.String(eventsConfig.StatementID()),
+			Action:       aws.String("lambda:InvokeFunction"),
+			Principal:    aws.String(EventsServicePrincipal),
+			SourceArn:    aws.String(events.RuleARN),
+		}); err != nil && !hasErrorCode(err, "ResourceConflictException") {
+			return "", fmt.Errorf("error allowing the rule to invoke %s: %w", eventsConfig.FunctionARN, err)
+		}
+		events.FunctionARN, events.StatementID = eventsConfig.FunctionARN, eventsConfig.StatementID()
+		if err := recordLifecycleEvents(state, events); err != nil {
+			return "", err
+		}
+	}
+
+	targetsOutput, err := clients.Events.PutTargets(ctx, &eventbridge.PutTargetsInput{
+		Rule: aws.String(eventsConfig.RuleName),
+		Targets: []eventsTypes.Target{{
+			Id:  aws.String(LifecycleEventsTargetID),
+			Arn: aws.String(targetARN),
+		}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error pointing rule %s at %s: %w", eventsConfig.RuleName, targetARN, err)
+	}
+	if len(targetsOutput.FailedEntries) > 0 {
+		return "", fmt.Errorf("error pointing rule %s at %s: %s", eventsConfig.RuleName, targetARN, aws.StringValue(targetsOutput.FailedEntries[0].ErrorMessage))
+	}
+	logger.Printf("Rule %s sends the lifecycle events to %s", eventsConfig.RuleName, targetARN)
+
+	if eventsConfig.FunctionARN == "" {
+		if err := SubscribeEmails(ctx, logger, clients.SNS, targetARN, eventsConfig.Emails, state); err != nil {
+			return targetARN, err
+		}
+	}
+	return targetARN, nil
+}
+
+// DeleteLifecycleEvents deletes the rule and its target, the email
+// subscriptions, the created topic and the permission of the function.
+// Resources already gone are skipped.
+func DeleteLifecycleEvents(ctx context.Context, logger *log.Logger, clients *Clients, state *State) error {
+	if state.LifecycleEvents == nil {
+		return nil
+	}
+	events := *state.LifecycleEvents
+
+	if events.RuleARN != "" {
+		if _, err := clients.Events.RemoveTargets(ctx, &eventbridge.RemoveTargetsInput{
+			Rule: aws.String(events.RuleName),
+			Ids:  []string{LifecycleEventsTargetID},
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error removing target of rule %s: %w", events.RuleName, err)
+		}
+		if _, err := clients.Events.DeleteRule(ctx, &eventbridge.DeleteRuleInput{
+			Name: aws.String(events.RuleName),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting rule %s: %w", events.RuleName, err)
+		}
+		logger.Printf("Rule %s deleted", events.RuleName)
+	}
+
+	if err := UnsubscribeEmails(ctx, logger, clients.SNS, state, "", nil); err != nil {
+		return err
+	}
+	if err := deleteLifecycleTopic(ctx, logger, clients.SNS, events.TopicARN); err != nil {
+		return err
+	}
+	if err := removeLifecyclePermission(ctx, logger, clients.Lambda, events); err != nil {
+		return err
+	}
+	return state.Record(func(s *State) { s.LifecycleEvents = nil })
+}
+
+func deleteLifecycleTopic(ctx context.Context, logger *log.Logger, snsClient *sns.Client, topicARN string) error {
+	if topicARN == "" {
+		return nil
+	}
+	if _, err := snsClient.DeleteTopic(ctx, &sns.DeleteTopicInput{
+		TopicArn: aws.String(topicARN),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error deleting topic %s: %w", topicARN, err)
+	}
+	logger.Printf("Topic %s deleted", topicARN)
+	return nil
+}
+
+func removeLifecyclePermission(ctx context.Context, logger *log.Logger, lambdaClient *lambda.Client, events LifecycleEventsState) error {
+	if events.StatementID == "" {
+		return nil
+	}
+	if _, err := lambdaClient.RemovePermission(ctx, &lambda.RemovePermissionInput{
+		FunctionName: aws.String(events.FunctionARN),
+		StatementId:  aws.String(events.StatementID),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error removing permission from %s: %w", events.FunctionARN, err)
+	}
+	logger.Printf("Permission %s removed from %s", events.StatementID, events.FunctionARN)
+	return nil
+}
+
+// UpdateLifecycleEvents puts the rule and its target again, after deleting
+// what the previous target needed: the email subscriptions removed from the
+// config or left on another topic, the created topic when an existing topic
+// or a function took its place, the permission of a function that is no
+// longer the target. The rule is deleted when it was removed from the
+// config, or renamed.
+func UpdateLifecycleEvents(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	eventsConfig := cfg.LifecycleEvents
+	if eventsConfig == nil || state.LifecycleEvents != nil && state.LifecycleEvents.RuleName != eventsConfig.RuleName {
+		if err := DeleteLifecycleEvents(ctx, logger, clients, state); err != nil {
+			return err
+		}
+	}
+	if eventsConfig == nil {
+		return nil
+	}
+
+	if recorded := state.LifecycleEvents; recorded != nil {
+		topicARN := eventsConfig.TopicARN
+		if eventsConfig.CreatesTopic() {
+			topicARN = recorded.TopicARN
+		}
+		if err := UnsubscribeEmails(ctx, logger, clients.SNS, state, topicARN, eventsConfig.Emails); err != nil {
+			return err
+		}
+
+		events := *state.LifecycleEvents
+		if events.TopicARN != "" && !eventsConfig.CreatesTopic() {
+			if err := deleteLifecycleTopic(ctx, logger, clients.SNS, events.TopicARN); err != nil {
+				return err
+			}
+			events.TopicARN = ""
+		}
+		if events.StatementID != "" && events.FunctionARN != eventsConfig.FunctionARN {
+			if err := removeLifecyclePermission(ctx, logger, clients.Lambda, events); err != nil {
+				return err
+			}
+			events.FunctionARN, events.StatementID = "", ""
+		}
+		if err := recordLifecycleEvents(state, events); err != nil {
+			return err
+		}
+	}
+	_, err := SetupLifecycleEvents(ctx, logger, clients, cfg, state)
+	return err
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// RedirectStatusCodes are the status codes a redirect action can answer
+// with, permanent or temporary.
+var RedirectStatusCodes = []int{301, 302}
+
+// RedirectConfig makes a listener redirect every request instead of
+// forwarding it, e.g. from HTTP on port 80 to HTTPS on port 443. Protocol and
+// Port default to the ones of the main listener.
+type RedirectConfig struct {
+	Protocol   string `json:"protocol"`
+	Port       int32  `json:"port"`
+	StatusCode int    `json:"statusCode"`
+}
+
+// redirectAction keeps the host, path and query of the request.
+func redirectAction(redirectConfig RedirectConfig) elbTypes.Action {
+	return elbTypes.Action{
+		Type: elbTypes.ActionTypeEnumRedirect,
+		RedirectConfig: &elbTypes.RedirectActionConfig{
+			Protocol:   aws.String(redirectConfig.Protocol),
+			Port:       aws.String(strconv.Itoa(int(redirectConfig.Port))),
+			StatusCode: elbTypes.RedirectActionStatusCodeEnum(redirectConfig.StatusCodeName()),
+		},
+	}
+}
+
+// StatusCodeName is the status code as the redirect action names it, like
+// HTTP_301.
+func (r RedirectConfig) StatusCodeName() string {
+	return fmt.Sprintf("HTTP_%d", r.StatusCode)
+}
+
+// describeListener summarizes a listener for the plan.
+func describeListener(listenerConfig ListenerConfig) string {
+	listener := fmt.Sprintf("%s:%d", listenerConfig.Protocol(), listenerConfig.Port)
+	if listenerConfig.CertificateARN != "" {
+		listener += ", " + listenerConfig.SSLPolicy
+		if n := len(listenerConfig.AdditionalCertificateARNs); n > 0 {
+			listener += fmt.Sprintf(", %d SNI certificates", n)
+		}
+	}
+	if listenerConfig.MutualTLS != nil {
+		listener += ", mutual TLS"
+	}
+	if auth := listenerConfig.Authentication; auth != nil {
+		listener += ", " + auth.Type + " authentication"
+	}
+	if redirect := listenerConfig.Redirect; redirect != nil {
+		listener += fmt.Sprintf(", redirects to %s:%d", redirect.Protocol, redirect.Port)
+	}
+	return listener
+}
+
+// AdditionalListenerActions returns the default actions of an additional
+// listener: its redirect, or forwarding to the same target groups as the
+// main listener.
+func AdditionalListenerActions(listenerConfig ListenerConfig, targetGroups []elbTypes.TargetGroupTuple) []elbTypes.Action {
+	if listenerConfig.Redirect != nil {
+		return []elbTypes.Action{redirectAction(*listenerConfig.Redirect)}
+	}
+	return ListenerDefaultActions(listenerConfig, targetGroups, OIDCClient{})
+}
+
+// CreateAdditionalListeners creates the listeners next to the main one and
+// records each of them by port as soon as it exists.
+func CreateAdditionalListeners(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, listenerConfigs []ListenerConfig, loadBalancerARN string, targetGroups []elbTypes.TargetGroupTuple, state *State) error {
+	for _, listenerConfig := range listenerConfigs {
+		input := &elasticloadbalancingv2.CreateListenerInput{
+			LoadBalancerArn: aws.String(loadBalancerARN),
+			Protocol:        elbTypes.ProtocolEnum(listenerConfig.Protocol()),
+			Port:            aws.Int32(listenerConfig.Port),
+			DefaultActions:  AdditionalListenerActions(listenerConfig, targetGroups),
+		}
+		if listenerConfig.CertificateARN != "" {
+			input.Certificates = []elbTypes.Certificate{{CertificateArn: aws.String(listenerConfig.CertificateARN)}}
+			input.SslPolicy = aws.String(listenerConfig.SSLPolicy)
+		}
+
+		output, err := elbClient.CreateListener(ctx, input)
+		if err != nil {
+			return fmt.Errorf("error creating listener on port %d: %w", listenerConfig.Port, err)
+		}
+		listenerARN := aws.StringValue(output.Listeners[0].ListenerArn)
+		logger.Printf("Listener on port %d created with ARN: %s", listenerConfig.Port, listenerARN)
+
+		if err := state.Record(func(s *State) {
+			if s.AdditionalListenerARNs == nil {
+				s.AdditionalListenerARNs = make(map[int32]string)
+			}
+			s.AdditionalListenerARNs[listenerConfig.Port] = listenerARN
+		}); err != nil {
+			return err
+		}
+		if err := AddListenerCertificates(ctx, logger, elbClient, listenerARN, listenerConfig.AdditionalCertificateARNs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteAdditionalListeners deletes the listeners recorded next to the main
+// one, forgetting each of them once it is gone.
+func DeleteAdditionalListeners(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, state *State) error {
+	ports := make([]int32, 0, len(state.AdditionalListenerARNs))
+	for port := range state.AdditionalListenerARNs {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool { return ports[i] < ports[j] })
+
+	for _, port := range ports {
+		listenerARN := state.AdditionalListenerARNs[port]
+		if _, err := elbClient.DeleteListener(ctx, &elasticloadbalancingv2.DeleteListenerInput{
+			ListenerArn: aws.String(listenerARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting listener on port %d: %w", port, err)
+		}
+		logger.Printf("Listener %s deleted", listenerARN)
+		if err := state.Record(func(s *State) { delete(s.AdditionalListenerARNs, port) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForwardingListenerARNs returns the additional listeners that forward to
+// the target groups, which follow the main listener into maintenance mode and
+// through canary weight changes.
+func ForwardingListenerARNs(cfg *Config, state *State) []string {
+	var listenerARNs []string
+	for _, listenerConfig := range cfg.AdditionalListeners {
+		if listenerARN := state.AdditionalListenerARNs[listenerConfig.Port]; listenerARN != "" && listenerConfig.Redirect == nil {
+			listenerARNs = append(listenerARNs, listenerARN)
+		}
+	}
+	return listenerARNs
+}
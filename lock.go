@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	LockFileSuffix = ".lock"
+	// LockTableKey is the string partition key of the DynamoDB lock table.
+	LockTableKey = "LockID"
+)
+
+// LockInfo tells who holds a stack lock.
+type LockInfo struct {
+	ID        string    `json:"id"`
+	Who       string    `json:"who"`
+	Operation string    `json:"operation"`
+	Created   time.Time `json:"created"`
+}
+
+func (i LockInfo) String() string {
+	return fmt.Sprintf("%s (%s since %s)", i.Who, i.Operation, i.Created.Format(time.RFC3339))
+}
+
+// StackLock keeps other runs from changing the stack at the same time. It is
+// always a lock file next to the state file and, when lock.table is set, an
+// item in that DynamoDB table, which also covers runs on other machines.
+type StackLock struct {
+	info   LockInfo
+	path   string
+	table  string
+	client *dynamodb.Client
+}
+
+// LockID identifies the stack in the lock table.
+func LockID(cfg *Config) string {
+	return cfg.ResourceName(ResourceState)
+}
+
+// AcquireLock takes the stack lock for operation, or fails right away with
+// the current holder when someone else has it.
+func AcquireLock(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, stateFile, operation string) (*StackLock, error) {
+	lock := &StackLock{
+		info: LockInfo{
+			ID:        LockID(cfg),
+			Who:       lockOwner(),
+			Operation: operation,
+			Created:   time.Now().UTC(),
+		},
+		path:   stateFile + LockFileSuffix,
+		table:  cfg.Lock.Table,
+		client: clients.DynamoDB,
+	}
+
+	if err := lock.acquireFile(); err != nil {
+		return nil, err
+	}
+	if lock.table != "" {
+		if err := lock.acquireTable(ctx); err != nil {
+			if removeErr := os.Remove(lock.path); removeErr != nil {
+				logger.Printf("Error removing lock file %s: %v", lock.path, removeErr)
+			}
+			return nil, err
+		}
+	}
+	logger.Printf("Lock %s acquired", lock.info.ID)
+
+	return lock, nil
+}
+
+func (l *StackLock) acquireFile() error {
+	data, err := json.MarshalIndent(l.info, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding lock: %w", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if errors.Is(err, os.ErrExist) {
+		holder := "unknown holder"
+		if data, err := os.ReadFile(l.path); err == nil {
+			var info LockInfo
+			if json.Unmarshal(data, &info) == nil {
+				holder = info.String()
+			}
+		}
+		return fmt.Errorf("stack is locked by %s, lock file %s; run unlock if that run is gone", holder, l.path)
+	}
+	if err != nil {
+		return fmt.Errorf("error creating lock file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return fmt.Errorf("error writing lock file %s: %w", l.path, err)
+	}
+	return nil
+}
+
+func (l *StackLock) acquireTable(ctx context.Context) error {
+	_, err := l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]dynamodbTypes.AttributeValue{
+			LockTableKey: &dynamodbTypes.AttributeValueMemberS{Value: l.info.ID},
+			"Who":        &dynamodbTypes.AttributeValueMemberS{Value: l.info.Who},
+			"Operation":  &dynamodbTypes.AttributeValueMemberS{Value: l.info.Operation},
+			"Created":    &dynamodbTypes.AttributeValueMemberS{Value: l.info.Created.Format(time.RFC3339)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{
+			"#id": LockTableKey,
+		},
+	})
+	var conditionErr *dynamodbTypes.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		holder := "unknown holder"
+		if info, err := readTableLock(ctx, l.client, l.table, l.info.ID); err == nil {
+			holder = info.String()
+		}
+		return fmt.Errorf("stack is locked by %s, lock %s in table %s; run unlock if that run is gone", holder, l.info.ID, l.table)
+	}
+	if err != nil {
+		return fmt.Errorf("error acquiring lock in table %s: %w", l.table, err)
+	}
+	return nil
+}
+
+// Release gives the lock up. Both parts are released even when one fails.
+func (l *StackLock) Release(ctx context.Context) error {
+	var errs []error
+	if l.table != "" {
+		if err := deleteTableLock(ctx, l.client, l.table, l.info.ID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("error removing lock file: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// releaseLock releases lock at the end of a command. Failures are only
+// logged, the command itself has already finished.
+func releaseLock(ctx context.Context, logger *log.Logger, lock *StackLock) {
+	if err := lock.Release(context.WithoutCancel(ctx)); err != nil {
+		logger.Printf("Error releasing lock %s, run unlock: %v", lock.info.ID, err)
+	}
+}
+
+func readTableLock(ctx context.Context, client *dynamodb.Client, table, id string) (LockInfo, error) {
+	output, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]dynamodbTypes.AttributeValue{
+			LockTableKey: &dynamodbTypes.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return LockInfo{}, fmt.Errorf("error reading lock from table %s: %w", table, err)
+	}
+	if output.Item == nil {
+		return LockInfo{}, fmt.Errorf("lock %s not found in table %s", id, table)
+	}
+
+	info := LockInfo{ID: id}
+	if v, ok := output.Item["Who"].(*dynamodbTypes.AttributeValueMemberS); ok {
+		info.Who = v.Value
+	}
+	if v, ok := output.Item["Operation"].(*dynamodbTypes.AttributeValueMemberS); ok {
+		info.Operation = v.Value
+	}
+	if v, ok := output.Item["Created"].(*dynamodbTypes.AttributeValueMemberS); ok {
+		info.Created, _ = time.Parse(time.RFC3339, v.Value)
+	}
+	return info, nil
+}
+
+func deleteTableLock(ctx context.Context, client *dynamodb.Client, table, id string) error {
+	if _, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key: map[string]dynamodbTypes.AttributeValue{
+			LockTableKey: &dynamodbTypes.AttributeValueMemberS{Value: id},
+		},
+	}); err != nil {
+		return fmt.Errorf("error releasing lock in table %s: %w", table, err)
+	}
+	return nil
+}
+
+// lockOwner describes the current process for other runs hitting the lock.
+func lockOwner() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s pid %d", name, host, os.Getpid())
+}
+
+func runUnlock(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	opts.Register(fs)
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	if !*yes {
+		ok, err := Confirm(fmt.Sprintf("Remove the lock of %s? Only do this when the run holding it is gone", LockID(cfg)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Aborted")
+			return nil
+		}
+	}
+
+	path := opts.StateFile() + LockFileSuffix
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing lock file: %w", err)
+	}
+	logger.Printf("Lock file %s removed", path)
+
+	if cfg.Lock.Table != "" {
+		clients, err := NewClients(ctx, logger, cfg, &opts)
+		if err != nil {
+			return err
+		}
+		if err := deleteTableLock(ctx, clients.DynamoDB, cfg.Lock.Table, LockID(cfg)); err != nil {
+			return err
+		}
+		logger.Printf("Lock %s removed from table %s", LockID(cfg), cfg.Lock.Table)
+	}
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ResourceAppLogs    = "app-logs"
+	ResourceAccessLogs = "access-logs"
+	ResourceSystemLogs = "system-logs"
+	// LogsPolicyName is the inline policy of the instance role that lets the
+	// CloudWatch agent write to the log groups.
+	LogsPolicyName          = "write-logs"
+	DefaultLogRetentionDays = 30
+	// CloudWatchAgentConfigFile is where the user data writes the config of
+	// the agent on the instances.
+	CloudWatchAgentConfigFile = "/opt/aws/amazon-cloudwatch-agent/etc/logs.json"
+	CloudWatchAgentCtl        = "/opt/aws/amazon-cloudwatch-agent/bin/amazon-cloudwatch-agent-ctl"
+)
+
+// logGroupNamePattern matches the names CloudWatch Logs accepts.
+var logGroupNamePattern = regexp.MustCompile(`^[A-Za-z0-9._/#-]{1,512}$`)
+
+// Files tailed into the log groups unless the config lists others. The
+// container logs of the app are the app logs when the app config runs one.
+var (
+	DefaultAppLogFiles       = []string{"/var/log/app/*.log"}
+	DefaultContainerLogFiles = []string{"/var/lib/docker/containers/*/*-json.log"}
+	DefaultAccessLogFiles    = []string{"/var/log/nginx/access.log", "/var/log/httpd/access_log"}
+	DefaultSystemLogFiles    = []string{"/var/log/messages", "/var/log/cloud-init-output.log"}
+)
+
+// LogsConfig ships the logs of the instances to CloudWatch Logs. Apply
+// creates the app, access and system log groups, the user data installs the
+// CloudWatch agent tailing the files of each group, and the instance role
+// may write to the groups and nothing else.
+type LogsConfig struct {
+	App    LogGroupConfig `json:"app"`
+	Access LogGroupConfig `json:"access"`
+	System LogGroupConfig `json:"system"`
+	// RetentionDays is the retention of the groups that don't set their own,
+	// 30 days by default; 0 keeps the logs forever.
+	RetentionDays *int32 `json:"retentionDays"`
+}
+
+// LogGroupConfig is a log group and the files on the instances the agent
+// tails into it, one log stream per instance.
+type LogGroupConfig struct {
+	Name string `json:"name"`
+	// Files are paths or glob patterns.
+	Files         []string `json:"files"`
+	RetentionDays *int32   `json:"retentionDays"`
+}
+
+func (g LogGroupConfig) String() string {
+	retention := "kept forever"
+	if days := g.Retention(); days > 0 {
+		retention = fmt.Sprintf("kept %d days", days)
+	}
+	return fmt.Sprintf("%s (%s, %s)", g.Name, strings.Join(g.Files, ", "), retention)
+}
+
+// Retention returns the days the group keeps its logs, 0 for forever.
+func (g LogGroupConfig) Retention() int32 {
+	return aws.Int32Value(g.RetentionDays)
+}
+
+// Groups returns the app, access and system log groups.
+func (l *LogsConfig) Groups() []LogGroupConfig {
+	return []LogGroupConfig{l.App, l.Access, l.System}
+}
+
+// GroupNames returns the names of the log groups.
+func (l *LogsConfig) GroupNames() []string {
+	var names []string
+	for _, group := range l.Groups() {
+		names = append(names, group.Name)
+	}
+	return names
+}
+
+// Policy is the inline policy of the instance role letting the instances
+// write to the log groups.
+func (l *LogsConfig) Policy() string {
+	return LogsPolicy(l.GroupNames())
+}
+
+// CloudWatchAgentConfig renders the config of the CloudWatch agent, tailing
+// the files of each group into a stream named after the instance.
+func CloudWatchAgentConfig(groups []LogGroupConfig) string {
+	var collect []map[string]string
+	for _, group := range groups {
+		for _, file := range group.Files {
+			collect = append(collect, map[string]string{
+				"file_path":       file,
+				"log_group_name":  group.Name,
+				"log_stream_name": "{instance_id}",
+			})
+		}
+	}
+	data, _ := json.MarshalIndent(map[string]any{
+		"agent": map[string]string{"run_as_user": "root"},
+		"logs": map[string]any{
+			"logs_collected": map[string]any{
+				"files": map[string]any{"collect_list": collect},
+			},
+		},
+	}, "", "  ")
+	return string(data)
+}
+
+// LogsUserData prepends installing and starting the CloudWatch agent to the
+// user data script. rsyslog is installed too, as Amazon Linux 2023 only
+// logs to the journal, which the agent can't read.
+func LogsUserData(groups []LogGroupConfig, script []byte) []byte {
+	var userData strings.Builder
+	userData.WriteString("#!/bin/bash\n")
+	userData.WriteString("dnf install -y amazon-cloudwatch-agent rsyslog\n")
+	userData.WriteString("systemctl enable --now rsyslog\n")
+	fmt.Fprintf(&userData, "cat > %s <<'EOF'\n%s\nEOF\n", CloudWatchAgentConfigFile, CloudWatchAgentConfig(groups))
+	fmt.Fprintf(&userData, "%s -a fetch-config -m ec2 -s -c file:%s\n", CloudWatchAgentCtl, CloudWatchAgentConfigFile)
+	userData.Write(stripShebang(script))
+	return []byte(userData.String())
+}
+
+// LogGroupARN is the ARN pattern of the log group and its streams in any
+// region and account, the way the delivery policy of the flow logs scopes
+// its group.
+func LogGroupARN(name string) string {
+	return fmt.Sprintf("arn:aws:logs:*:*:log-group:%s:*", name)
+}
+
+// LogsPolicy lets the instances write to the log groups and nothing else.
+func LogsPolicy(groupNames []string) string {
+	resources := make([]string, 0, len(groupNames))
+	for _, name := range groupNames {
+		resources = append(resources, LogGroupARN(name))
+	}
+	return policyJSON(map[string]any{
+		"Effect": "Allow",
+		"Action": []string{
+			"logs:CreateLogStream",
+			"logs:PutLogEvents",
+			"logs:DescribeLogStreams",
+		},
+		"Resource": resources,
+	})
+}
+
+// CreateLogGroup creates a log group and sets its retention. A group that
+// already exists only gets its retention set, so apply can be repeated after
+// a failure.
+func CreateLogGroup(ctx context.Context, logger *log.Logger, logsClient *cloudwatchlogs.Client, group LogGroupConfig, tags map[string]string) error {
+	_, err := logsClient.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(group.Name),
+		Tags:         tags,
+	})
+	switch {
+	case err == nil:
+		logger.Printf("Log group %s created", group.Name)
+	case !hasErrorCode(err, "ResourceAlreadyExistsException"):
+		return fmt.Errorf("error creating log group %s: %w", group.Name, err)
+	}
+	return PutLogGroupRetention(ctx, logger, logsClient, group)
+}
+
+// PutLogGroupRetention sets how long the group keeps its logs, removing the
+// retention policy when they are kept forever.
+func PutLogGroupRetention(ctx context.Context, logger *log.Logger, logsClient *cloudwatchlogs.Client, group LogGroupConfig) error {
+	days := group.Retention()
+	if days == 0 {
+		if _, err := logsClient.DeleteRetentionPolicy(ctx, &cloudwatchlogs.DeleteRetentionPolicyInput{
+			LogGroupName: aws.String(group.Name),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error removing retention of log group %s: %w", group.Name, err)
+		}
+		return nil
+	}
+	if _, err := logsClient.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{
+		LogGroupName:    aws.String(group.Name),
+		RetentionInDays: aws.Int32(days),
+	}); err != nil {
+		return fmt.Errorf("error setting retention of log group %s: %w", group.Name, err)
+	}
+	logger.Printf("Log group %s keeps logs for %d days", group.Name, days)
+	return nil
+}
+
+// CreateLogGroups creates the log groups, recording each of them as soon as
+// it exists.
+func CreateLogGroups(ctx context.Context, logger *log.Logger, logsClient *cloudwatchlogs.Client, groups []LogGroupConfig, state *State, tags map[string]string) error {
+	for _, group := range groups {
+		if err := CreateLogGroup(ctx, logger, logsClient, group, tags); err != nil {
+			return err
+		}
+		if state.HasLogGroup(group.Name) {
+			continue
+		}
+		if err := state.Record(func(s *State) { s.LogGroupNames = append(s.LogGroupNames, group.Name) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteLogGroups deletes the recorded log groups that are not in keep,
+// forgetting each of them once it is gone.
+func DeleteLogGroups(ctx context.Context, logger *log.Logger, logsClient *cloudwatchlogs.Client, state *State, keep []string) error {
+	for _, name := range slices.Clone(state.LogGroupNames) {
+		if slices.Contains(keep, name) {
+			continue
+		}
+		if _, err := logsClient.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{
+			LogGroupName: aws.String(name),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting log group %s: %w", name, err)
+		}
+		logger.Printf("Log group %s deleted", name)
+		if err := state.Record(func(s *State) {
+			s.LogGroupNames = slices.DeleteFunc(s.LogGroupNames, func(n string) bool { return n == name })
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PutLogsPolicy scopes the inline policy of the instance role to the log
+// groups, removing it when there are none.
+func PutLogsPolicy(ctx context.Context, logger *log.Logger, iamClient *iam.Client, roleName string, groupNames []string) error {
+	if len(groupNames) == 0 {
+		if _, err := iamClient.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(roleName),
+			PolicyName: aws.String(LogsPolicyName),
+		}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+			return fmt.Errorf("error deleting policy %s of role %s: %w", LogsPolicyName, roleName, err)
+		}
+		return nil
+	}
+
+	if _, err := iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(LogsPolicyName),
+		PolicyDocument: aws.String(LogsPolicy(groupNames)),
+	}); err != nil {
+		return fmt.Errorf("error putting policy %s of role %s: %w", LogsPolicyName, roleName, err)
+	}
+	logger.Printf("Role %s can write to %d log groups", roleName, len(groupNames))
+	return nil
+}
+
+// UpdateLogs creates the log groups added to the config, sets the retention
+// of every group, deletes the groups removed from the config and keeps the
+// instance role able to write to exactly the configured ones.
+func UpdateLogs(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	var names []string
+	if cfg.Logs != nil {
+		if err := CreateLogGroups(ctx, logger, clients.Logs, cfg.Logs.Groups(), state, cfg.StackTags()); err != nil {
+			return err
+		}
+		names = cfg.Logs.GroupNames()
+	}
+	if err := DeleteLogGroups(ctx, logger, clients.Logs, state, names); err != nil {
+		return err
+	}
+	if state.InstanceRoleName == "" {
+		return nil
+	}
+	return PutLogsPolicy(ctx, logger, clients.IAM, state.InstanceRoleName, names)
+}
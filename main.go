@@ -2,14 +2,18 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
@@ -17,106 +21,589 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
 	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 )
 
 const (
 	EnvFilePath    = ".env"
+	EnvEndpointURL = "AWS_ENDPOINT_URL"
 	UserDataScript = "user_data.sh"
+	ApplyTimeout   = 15 * time.Minute
 
 	AWSRegion                   = "us-east-1"
-	AWSAmiID                    = "ami-01816d07b1128cd2d" // Amazon Linux 2023 AMI
-	AWSLaunchTemplatePrefix     = "webservice-launch-template-"
+	AWSVPCCIDRBlock             = "10.0.0.0/16"
+	AWSInstanceType             = "t2.micro"
 	AWSLaunchTemplateVersion    = "$Latest"
-	AWSSecurityGroupPrefix      = "webservice-sg-"
-	AWSAutoscalingGroupPrefix   = "webservice-sg-"
-	AWSAutoscalingPolicyPrefix  = "webservice-sg-"
 	AWSSecurityGroupDescription = "Security group for port 8080 access"
 	AWSAutoscalingPolicyType    = "TargetTrackingScaling"
+	AWSTargetGroupPort          = 8080
+	AWSListenerPort             = 80
 	AWSMinEC2Count              = 2
 	AWSMaxEC2Count              = 5
+	AWSHealthCheckType          = "ELB"
+	AWSHealthCheckGracePeriod   = 300
+	AWSDefaultCooldown          = 300
 
 	AWSAutoScalingCPUThreshold = 30.0
-)
 
-var (
-	AWSSubnetAvailabilityZones = map[string]string{
-		"10.0.1.0/24": "us-east-1a",
-		"10.0.2.0/24": "us-east-1b",
-	}
+	LoadBalancerSchemeInternetFacing = "internet-facing"
+	LoadBalancerSchemeInternal       = "internal"
 )
 
 func main() {
 	logger := log.Default()
 	if err := godotenv.Load(EnvFilePath); err != nil {
-		logger.Fatalf("Error loading .env file: %v", err)
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Fatalf("Error loading .env file: %v", err)
+		}
+		logger.Println("No .env file found, using the process environment")
+	} else {
+		logger.Println("Environment variables loaded successfully")
 	}
-	logger.Println("Environment variables loaded successfully")
 
-	ctx, cancelFunc := context.WithTimeout(context.Background(), 6*time.Minute)
-	defer cancelFunc()
+	if err := Run(context.Background(), logger, os.Args[1:]); err != nil {
+		logger.Fatal(err)
+	}
+}
 
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithDefaultRegion(AWSRegion))
-	if err != nil {
-		log.Fatal(err)
+// Apply provisions the whole stack described by cfg. The identifier of every
+// created resource is recorded in state, which is saved after each step so a
+// failed run still leaves a trace of what exists.
+func Apply(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, progress Progress) error {
+	if !state.IsEmpty() {
+		return fmt.Errorf("state already contains a stack (VPC %s), refusing to create another one", state.VPCID)
 	}
-	logger.Println("AWS configuration loaded successfully")
-	ec2Client := ec2.NewFromConfig(cfg)
-	elbClient := elasticloadbalancingv2.NewFromConfig(cfg)
-	autoscalingClient := autoscaling.NewFromConfig(cfg)
 
-	vpcID, err := CreateVPC(ctx, logger, ec2Client)
+	graph, err := ApplyGraph(ctx, logger, clients, cfg, state)
 	if err != nil {
-		logger.Fatal(err)
+		return err
 	}
+	return runApplyGraph(ctx, logger, graph, state, progress)
+}
 
-	internetGatewayID, err := CreateInternetGateway(ctx, logger, ec2Client, vpcID)
-	if err != nil {
-		logger.Fatal(err)
+// runApplyGraph runs the steps of a first apply. completedSteps only track
+// an apply that hasn't finished yet, so they are cleared once it has.
+func runApplyGraph(ctx context.Context, logger *log.Logger, graph *StepGraph, state *State, progress Progress) error {
+	if err := graph.Run(ctx, logger, progress, state); err != nil {
+		return err
+	}
+	if err := state.Record(func(s *State) { s.CompletedSteps = nil }); err != nil {
+		return err
 	}
 
-	subnetIDs, err := CreateSubnets(ctx, logger, ec2Client, vpcID, internetGatewayID)
+	logger.Println("All AWS resources created successfully")
+
+	return nil
+}
+
+// ApplyGraph builds the steps of Apply with their stepDependencies. Every step
+// reads the resources it depends on from state, where the steps it depends
+// on recorded them. The VPC comes first, so a config AWS rejects fails
+// before anything else is created, and the smoke test comes last.
+func ApplyGraph(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) (*StepGraph, error) {
+	subnetLayout, err := ResolveSubnets(ctx, clients.EC2, cfg.VPC)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
+	publicSubnets, privateSubnets := SplitSubnets(subnetLayout)
+	tags := cfg.StackTags()
 
-	securityGroupID, err := CreateSecurityGroup(ctx, logger, ec2Client, vpcID)
+	oidcClient, err := ListenerOIDCClient(ctx, clients, cfg.Listener)
 	if err != nil {
-		logger.Fatal(err)
+		return nil, err
 	}
 
-	launchTemplateID, err := CreateLaunchTemplate(ctx, logger, ec2Client, securityGroupID)
-	if err != nil {
-		logger.Fatal(err)
+	graph := NewStepGraph(cfg.Retries)
+	add := func(name string, run func() (string, error)) {
+		graph.Add(name, stepDependencies[name], run)
+	}
+	optional := func(name string, run func() (string, error)) {
+		if enabled, ok := optionalSteps[name]; !ok || enabled(cfg) {
+			add(name, run)
+		}
 	}
 
-	targetGroupARN, err := CreateTargetGroup(ctx, logger, elbClient, vpcID)
-	if err != nil {
-		logger.Fatal(err)
+	add("VPC", func() (string, error) {
+		if existing := cfg.VPC.Resolved; existing != nil {
+			return existing.ID, state.Record(func(s *State) {
+				s.VPCID, s.InternetGatewayID, s.RouteTableID = existing.ID, existing.InternetGatewayID, existing.RouteTableID
+				s.ExistingVPC = true
+			})
+		}
+		vpcID, err := CreateVPC(ctx, logger, clients.EC2, cfg.VPC, tags)
+		if saveErr := state.Record(func(s *State) { s.VPCID = vpcID }); saveErr != nil {
+			return vpcID, saveErr
+		}
+		return vpcID, err
+	})
+
+	optional("Internet gateway", func() (string, error) {
+		internetGatewayID, err := CreateInternetGateway(ctx, logger, clients.EC2, state.VPCID, tags)
+		if err != nil {
+			return "", err
+		}
+		return internetGatewayID, state.Record(func(s *State) { s.InternetGatewayID = internetGatewayID })
+	})
+
+	optional("Route table", func() (string, error) {
+		routeTableID, err := CreateRouteTable(ctx, logger, clients.EC2, state.VPCID, state.InternetGatewayID, tags)
+		if saveErr := state.Record(func(s *State) { s.RouteTableID = routeTableID }); saveErr != nil {
+			return routeTableID, saveErr
+		}
+		return routeTableID, err
+	})
+
+	add("Subnets", func() (string, error) {
+		// Existing subnets stand in for the public ones, which the
+		// instances are launched into.
+		if subnetIDs := cfg.VPC.SubnetIDs; subnetIDs != nil {
+			return strings.Join(subnetIDs.AutoScalingGroup, ", "), state.Record(func(s *State) {
+				s.SubnetIDs = slices.Clone(subnetIDs.AutoScalingGroup)
+				s.ExistingSubnets = true
+			})
+		}
+		subnetIDs, err := CreateSubnets(ctx, logger, clients.EC2, publicSubnets, state.VPCID, state.RouteTableID, tags)
+		if saveErr := state.Record(func(s *State) { s.SubnetIDs = subnetIDs }); saveErr != nil {
+			return strings.Join(subnetIDs, ", "), saveErr
+		}
+		if err != nil || len(privateSubnets) == 0 {
+			return strings.Join(subnetIDs, ", "), err
+		}
+
+		privateSubnetIDs, err := CreateSubnets(ctx, logger, clients.EC2, privateSubnets, state.VPCID, state.RouteTableID, tags)
+		if saveErr := state.Record(func(s *State) { s.PrivateSubnetIDs = privateSubnetIDs }); saveErr != nil {
+			return strings.Join(subnetIDs, ", "), saveErr
+		}
+		return strings.Join(append(subnetIDs, privateSubnetIDs...), ", "), err
+	})
+
+	optional("Network ACL", func() (string, error) {
+		networkACLID, err := CreateNetworkACL(ctx, logger, clients.EC2, cfg.VPC.NetworkACL, state.VPCID, tags)
+		if saveErr := state.Record(func(s *State) { s.NetworkACLID = networkACLID }); saveErr != nil {
+			return networkACLID, saveErr
+		}
+		if err != nil {
+			return networkACLID, err
+		}
+		return networkACLID, AssociateNetworkACL(ctx, logger, clients.EC2, networkACLID, cfg.VPC.NetworkACL.AssociatedSubnetIDs(state.SubnetIDs, state.PrivateSubnetIDs))
+	})
+
+	optional("VPC endpoints", func() (string, error) {
+		routeTableIDs := []string{state.RouteTableID}
+		if len(state.PrivateSubnetIDs) > 0 {
+			mainRouteTableID, err := MainRouteTable(ctx, clients.EC2, state.VPCID)
+			if err != nil {
+				return "", err
+			}
+			routeTableIDs = append(routeTableIDs, mainRouteTableID)
+		}
+
+		var endpointSubnetIDs []string
+		indexes, private := EndpointSubnets(publicSubnets, privateSubnets)
+		for _, i := range indexes {
+			if private {
+				endpointSubnetIDs = append(endpointSubnetIDs, state.PrivateSubnetIDs[i])
+			} else {
+				endpointSubnetIDs = append(endpointSubnetIDs, state.SubnetIDs[i])
+			}
+		}
+
+		var endpointSecurityGroupID string
+		if len(cfg.VPC.InterfaceEndpoints()) > 0 {
+			var err error
+			endpointSecurityGroupID, err = CreateEndpointSecurityGroup(ctx, logger, clients.EC2, cfg.ResourceName(ResourceEndpoints), state.VPCID, cfg.VPC.CIDRBlocks(), tags)
+			if saveErr := state.Record(func(s *State) { s.EndpointSecurityGroupID = endpointSecurityGroupID }); saveErr != nil {
+				return "", saveErr
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		endpointIDs, err := CreateVPCEndpoints(ctx, logger, clients.EC2, cfg.VPC, cfg.Region, state.VPCID, routeTableIDs, endpointSubnetIDs, endpointSecurityGroupID, tags)
+		if saveErr := state.Record(func(s *State) { s.VPCEndpointIDs = endpointIDs }); saveErr != nil {
+			return strings.Join(endpointIDs, ", "), saveErr
+		}
+		return strings.Join(endpointIDs, ", "), err
+	})
+
+	optional("Elastic IPs", func() (string, error) {
+		err := AllocateElasticIPs(ctx, logger, clients.EC2, cfg.ElasticIPs, state, tags)
+		var addresses []string
+		for _, address := range cfg.ElasticIPs {
+			if recorded, ok := recordedElasticIP(state, address.Name); ok {
+				addresses = append(addresses, recorded.PublicIP)
+			}
+		}
+		return strings.Join(addresses, ", "), err
+	})
+
+	optional("Flow logs", func() (string, error) {
+		flowLogs := cfg.VPC.FlowLogs
+		var roleARN string
+		if flowLogs.ToCloudWatchLogs() {
+			err := CreateFlowLogGroup(ctx, logger, clients.Logs, flowLogs, tags)
+			if err != nil {
+				return "", err
+			}
+			if err := state.Record(func(s *State) { s.FlowLogGroupName = flowLogs.LogGroupName }); err != nil {
+				return "", err
+			}
+
+			roleARN, err = CreateFlowLogsRole(ctx, logger, clients.IAM, flowLogs, tags)
+			if roleARN != "" {
+				if saveErr := state.Record(func(s *State) { s.FlowLogsRoleName = flowLogs.RoleName }); saveErr != nil {
+					return "", saveErr
+				}
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+
+		flowLogID, err := CreateFlowLog(ctx, logger, clients.EC2, flowLogs, state.VPCID, roleARN, tags)
+		if err != nil {
+			return "", err
+		}
+		return flowLogID, state.Record(func(s *State) { s.FlowLogID = flowLogID })
+	})
+
+	optional("Log groups", func() (string, error) {
+		err := CreateLogGroups(ctx, logger, clients.Logs, cfg.Logs.Groups(), state, tags)
+		return strings.Join(state.LogGroupNames, ", "), err
+	})
+
+	add("Security group", func() (string, error) {
+		if cfg.SecurityGroup.Existing != nil {
+			securityGroupID, err := UseExistingSecurityGroup(ctx, logger, clients.EC2, cfg.SecurityGroup, state.VPCID)
+			if err != nil {
+				return "", err
+			}
+			return securityGroupID, state.Record(func(s *State) {
+				s.SecurityGroupID = securityGroupID
+				s.ExistingSecurityGroup = true
+			})
+		}
+		securityGroupID, err := CreateSecurityGroup(ctx, logger, clients.EC2, cfg.SecurityGroup, state.VPCID, tags)
+		if err != nil {
+			return "", err
+		}
+		return securityGroupID, state.Record(func(s *State) { s.SecurityGroupID = securityGroupID })
+	})
+
+	optional("ECS cluster", func() (string, error) {
+		clusterARN, err := CreateECSCluster(ctx, logger, clients.ECS, cfg.ECS.ClusterName, tags)
+		if err != nil {
+			return "", err
+		}
+		return clusterARN, state.Record(func(s *State) { s.ECSClusterARN = clusterARN })
+	})
+
+	optional("Secrets", func() (string, error) {
+		_, err := PublishSecrets(ctx, logger, clients, cfg.Secrets, state, tags)
+		return strings.Join(SecretNames(cfg.Secrets), ", "), err
+	})
+
+	optional("Instance role", func() (string, error) {
+		roleName := cfg.LaunchTemplate.InstanceRoleName
+		err := CreateInstanceRole(ctx, logger, clients.IAM, roleName, "Role of the instances of the autoscaling group", cfg.InstanceRolePolicyARNs(), tags)
+		if saveErr := state.Record(func(s *State) { s.InstanceRoleName = roleName }); saveErr != nil {
+			return roleName, saveErr
+		}
+		if err != nil {
+			return roleName, err
+		}
+		if err := PutSecretsPolicy(ctx, logger, clients.IAM, roleName, RecordedSecretARNs(state)); err != nil {
+			return roleName, err
+		}
+		if cfg.Logs == nil {
+			return roleName, nil
+		}
+		return roleName, PutLogsPolicy(ctx, logger, clients.IAM, roleName, cfg.Logs.GroupNames())
+	})
+
+	optional("Placement group", func() (string, error) {
+		groupName, err := CreatePlacementGroup(ctx, logger, clients.EC2, *cfg.PlacementGroup, tags)
+		if err != nil {
+			return "", err
+		}
+		return groupName, state.Record(func(s *State) { s.PlacementGroupName = groupName })
+	})
+
+	optional("Volume key", func() (string, error) {
+		keyARN, aliasOrGrant, err := CreateVolumeKey(ctx, logger, clients, *cfg.VolumeEncryption, tags)
+		if keyARN == "" {
+			return "", err
+		}
+		if saveErr := RecordVolumeKey(state, *cfg.VolumeEncryption, keyARN, aliasOrGrant); saveErr != nil {
+			return keyARN, saveErr
+		}
+		return keyARN, err
+	})
+
+	add("Launch template", func() (string, error) {
+		if existing := cfg.LaunchTemplate.Existing; existing != nil {
+			launchTemplateID, err := UseExistingLaunchTemplate(ctx, logger, clients.EC2, *existing)
+			if err != nil {
+				return "", err
+			}
+			return launchTemplateID, state.Record(func(s *State) {
+				s.LaunchTemplateID = launchTemplateID
+				s.LaunchTemplateVersion = existing.LaunchTemplateVersion()
+				s.ExistingLaunchTemplate = true
+			})
+		}
+		launchTemplateID, launchTemplateDataHash, err := CreateLaunchTemplate(ctx, logger, clients.EC2, cfg.LaunchTemplate, state.SecurityGroupID, state.VolumeKeyARN, tags)
+		if err != nil {
+			return "", err
+		}
+		return launchTemplateID, state.Record(func(s *State) {
+			s.LaunchTemplateID = launchTemplateID
+			s.LaunchTemplateDataHash = launchTemplateDataHash
+			s.LaunchTemplateVersion = LaunchTemplateVersionFor(cfg.LaunchTemplate, 1)
+		})
+	})
+
+	// A resumed apply only registers the targets of a recorded target group.
+	add("Target group", func() (string, error) {
+		targetGroupARN := state.TargetGroupARN
+		if targetGroupARN == "" {
+			var err error
+			targetGroupARN, err = CreateTargetGroup(ctx, logger, clients.ELB, cfg.TargetGroup, state.VPCID, tags)
+			if err != nil {
+				return "", err
+			}
+			if err := state.Record(func(s *State) { s.TargetGroupARN = targetGroupARN }); err != nil {
+				return targetGroupARN, err
+			}
+		}
+		return targetGroupARN, RegisterIPTargets(ctx, logger, clients.ELB, targetGroupARN, cfg.TargetGroup.IPTargets, cfg.VPC.CIDRBlocks())
+	})
+
+	optional("Canary target group", func() (string, error) {
+		canaryConfig := cfg.TargetGroup
+		canaryConfig.Name = cfg.Canary.TargetGroupName
+		canaryTargetGroupARN, err := CreateTargetGroup(ctx, logger, clients.ELB, canaryConfig, state.VPCID, tags)
+		if err != nil {
+			return "", err
+		}
+		return canaryTargetGroupARN, state.Record(func(s *State) { s.CanaryTargetGroupARN = canaryTargetGroupARN })
+	})
+
+	add("Autoscaling group", func() (string, error) {
+		asgTargetGroupARN := state.TargetGroupARN
+		if !cfg.AttachesTargetGroup() {
+			asgTargetGroupARN = ""
+		}
+		// A freshly created instance profile isn't accepted right away.
+		var autoscalingGroupName string
+		err := retryIAMPropagation(ctx, "instance profile", func() error {
+			var err error
+			autoscalingGroupName, err = CreateAutoscalingGroup(ctx, logger, clients.AutoScaling, cfg.AutoScaling, state.LaunchTemplateID, state.LaunchTemplateVersion, asgTargetGroupARN, GroupSubnets(cfg, state.SubnetIDs), tags)
+			return err
+		})
+		if err != nil {
+			return "", err
+		}
+		return autoscalingGroupName, state.Record(func(s *State) { s.AutoScalingGroupName = autoscalingGroupName })
+	})
+
+	// ECS scales the group of a capacity provider itself.
+	optional("Capacity provider", func() (string, error) {
+		capacityProviderName, err := CreateCapacityProvider(ctx, logger, clients, *cfg.ECS, state.AutoScalingGroupName, tags)
+		if capacityProviderName != "" {
+			if saveErr := state.Record(func(s *State) { s.CapacityProviderName = capacityProviderName }); saveErr != nil {
+				return capacityProviderName, saveErr
+			}
+		}
+		return capacityProviderName, err
+	})
+
+	optional("Scaling policy", func() (string, error) {
+		policyName, err := CreateScalingPolicy(ctx, logger, clients.AutoScaling, cfg.AutoScaling, state.AutoScalingGroupName)
+		if err != nil {
+			return "", err
+		}
+		return policyName, state.Record(func(s *State) { s.ScalingPolicyName = policyName })
+	})
+
+	optional("Canary group", func() (string, error) {
+		// The canary tries out the latest version of a created launch
+		// template, an existing one is used at its configured version.
+		canaryVersion := AWSLaunchTemplateVersion
+		if existing := cfg.LaunchTemplate.Existing; existing != nil {
+			canaryVersion = existing.LaunchTemplateVersion()
+		}
+		canaryGroupName, err := CreateAutoscalingGroup(ctx, logger, clients.AutoScaling, cfg.Canary.AutoScalingConfig(cfg.AutoScaling), state.LaunchTemplateID, canaryVersion, state.CanaryTargetGroupARN, GroupSubnets(cfg, state.SubnetIDs), tags)
+		if err != nil {
+			return "", err
+		}
+		return canaryGroupName, state.Record(func(s *State) { s.CanaryAutoScalingGroupName = canaryGroupName })
+	})
+
+	optional("CodeDeploy", func() (string, error) {
+		cdConfig := cfg.CodeDeploy
+		roleARN, err := CreateCodeDeployRole(ctx, logger, clients.IAM, *cdConfig, tags)
+		if roleARN != "" {
+			if saveErr := state.Record(func(s *State) { s.CodeDeployRoleName = cdConfig.ServiceRoleName }); saveErr != nil {
+				return "", saveErr
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := CreateCodeDeployApplication(ctx, logger, clients.CodeDeploy, cdConfig.ApplicationName, tags); err != nil {
+			return "", err
+		}
+		if err := state.Record(func(s *State) { s.CodeDeployApplicationName = cdConfig.ApplicationName }); err != nil {
+			return "", err
+		}
+		if err := CreateDeploymentGroup(ctx, logger, clients.CodeDeploy, *cdConfig, roleARN, state.AutoScalingGroupName, cfg.TargetGroup.Name, tags); err != nil {
+			return "", err
+		}
+		return cdConfig.DeploymentGroupName, state.Record(func(s *State) { s.CodeDeployDeploymentGroupName = cdConfig.DeploymentGroupName })
+	})
+
+	add("Load balancer", func() (string, error) {
+		loadBalancerSubnetIDs := state.SubnetIDs
+		if cfg.VPC.SubnetIDs != nil {
+			loadBalancerSubnetIDs = cfg.VPC.SubnetIDs.LoadBalancer
+		} else if cfg.LoadBalancer.Internal() {
+			loadBalancerSubnetIDs = state.PrivateSubnetIDs
+		}
+		loadBalancerARN, dnsName, err := CreateLoadBalancer(ctx, logger, clients.ELB, cfg.LoadBalancer, loadBalancerSubnetIDs, state.SecurityGroupID, tags)
+		if err != nil {
+			return "", err
+		}
+		return dnsName, state.Record(func(s *State) {
+			s.LoadBalancerARN = loadBalancerARN
+			s.LoadBalancerDNSName = dnsName
+		})
+	})
+
+	optional("Trust store", func() (string, error) {
+		trustStoreARN, err := CreateTrustStore(ctx, logger, clients.ELB, *cfg.Listener.MutualTLS, tags)
+		if saveErr := state.Record(func(s *State) { s.TrustStoreARN = trustStoreARN }); saveErr != nil {
+			return trustStoreARN, saveErr
+		}
+		return trustStoreARN, err
+	})
+
+	// Like the target group, a recorded listener only gets its certificates.
+	add("Listener", func() (string, error) {
+		listenerARN := state.ListenerARN
+		if listenerARN == "" {
+			targetGroups := ForwardTargetGroups(state.TargetGroupARN, state.CanaryTargetGroupARN, 0)
+			var err error
+			listenerARN, err = CreateListener(ctx, logger, clients.ELB, cfg.Listener, state.LoadBalancerARN, targetGroups, state.TrustStoreARN, oidcClient)
+			if err != nil {
+				return "", err
+			}
+			if err := state.Record(func(s *State) { s.ListenerARN = listenerARN }); err != nil {
+				return listenerARN, err
+			}
+		}
+		return listenerARN, AddListenerCertificates(ctx, logger, clients.ELB, listenerARN, cfg.Listener.AdditionalCertificateARNs)
+	})
+
+	optional("Additional listeners", func() (string, error) {
+		targetGroups := ForwardTargetGroups(state.TargetGroupARN, state.CanaryTargetGroupARN, 0)
+		if err := CreateAdditionalListeners(ctx, logger, clients.ELB, cfg.AdditionalListeners, state.LoadBalancerARN, targetGroups, state); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d listeners", len(cfg.AdditionalListeners)), nil
+	})
+
+	optional("Host rules", func() (string, error) {
+		var ruleARNs []string
+		for _, ruleConfig := range cfg.HostRules {
+			targetGroups := ForwardTargetGroups(ruleConfig.TargetGroupARN, "", 0)
+			ruleARN, err := CreateHostRule(ctx, logger, clients.ELB, ruleConfig, state.ListenerARN, ListenerDefaultActions(cfg.Listener, targetGroups, oidcClient), tags)
+			if err != nil {
+				return strings.Join(ruleARNs, ", "), err
+			}
+			ruleARNs = append(ruleARNs, ruleARN)
+			if err := state.Record(func(s *State) { s.HostRuleARNs = append(s.HostRuleARNs, ruleARN) }); err != nil {
+				return strings.Join(ruleARNs, ", "), err
+			}
+		}
+		return strings.Join(ruleARNs, ", "), nil
+	})
+
+	optional("Lambda targets", func() (string, error) {
+		// Rules authenticate users like the default action does.
+		actions := func(lambdaTargetGroupARN string) []elbTypes.Action {
+			return ListenerDefaultActions(cfg.Listener, ForwardTargetGroups(lambdaTargetGroupARN, "", 0), oidcClient)
+		}
+		var ruleARNs []string
+		for _, lambdaConfig := range cfg.LambdaTargets {
+			target, err := CreateLambdaTarget(ctx, logger, clients, lambdaConfig, state.ListenerARN, actions, tags)
+			if saveErr := state.Record(func(s *State) { s.LambdaTargets = append(s.LambdaTargets, target) }); saveErr != nil {
+				return strings.Join(ruleARNs, ", "), saveErr
+			}
+			if err != nil {
+				return strings.Join(ruleARNs, ", "), err
+			}
+			ruleARNs = append(ruleARNs, target.RuleARN)
+		}
+		return strings.Join(ruleARNs, ", "), nil
+	})
+
+	optional("Lifecycle events", func() (string, error) {
+		return SetupLifecycleEvents(ctx, logger, clients, cfg, state)
+	})
+
+	optional("Instance Connect", func() (string, error) {
+		return SetupInstanceConnect(ctx, logger, clients.EC2, cfg, state, state.VPCID, InstanceConnectSubnet(state.SubnetIDs, state.PrivateSubnetIDs), state.SecurityGroupID)
+	})
+
+	optional("Bastion", func() (string, error) {
+		return CreateBastion(ctx, logger, clients.EC2, cfg, state, state.VPCID, state.SubnetIDs[0], state.SecurityGroupID)
+	})
+
+	if cfg.RunsSmokeTest() {
+		graph.Add("Smoke test", graph.Names(), func() (string, error) {
+			url := SmokeTestURL(cfg.SmokeTest, state.LoadBalancerDNSName, cfg.Listener)
+			return url, SmokeTest(ctx, logger, cfg.SmokeTest, url)
+		})
 	}
 
-	if err := CreateAutoscalingGroup(ctx, logger, autoscalingClient, launchTemplateID, targetGroupARN, subnetIDs); err != nil {
-		logger.Fatal(err)
+	if err := AddCustomSteps(ctx, logger, clients, cfg, state, graph); err != nil {
+		return nil, err
 	}
+	return graph, nil
+}
 
-	loadBalancerARN, dnsName, err := CreateLoadBalancer(ctx, logger, elbClient, subnetIDs, securityGroupID)
-	if err != nil {
-		logger.Fatal(err)
+func LoadAWSConfig(ctx context.Context, region string, opts *GlobalOptions) (awsv2.Config, error) {
+	optFns := []func(*config.LoadOptions) error{
+		config.WithDefaultRegion(region),
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = MFATokenProvider(opts.MFAToken)
+		}),
+	}
+	if opts.EndpointURL != "" {
+		optFns = append(optFns, config.WithBaseEndpoint(opts.EndpointURL))
+	}
+	if opts.Profile != "" {
+		optFns = append(optFns, config.WithSharedConfigProfile(opts.Profile))
 	}
 
-	if err = CreateListener(ctx, logger, elbClient, loadBalancerARN, targetGroupARN); err != nil {
-		logger.Fatal(err)
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return awsv2.Config{}, fmt.Errorf("error loading AWS config: %w", err)
 	}
 
-	logger.Println("All AWS resources created successfully")
+	if opts.RoleARN != "" {
+		cfg.Credentials = AssumeRoleCredentials(cfg, opts)
+	}
 
-	logger.Printf("http://%s", dnsName)
+	return cfg, nil
 }
 
-func CreateVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client) (string, error) {
+func CreateVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcConfig VPCConfig, tags map[string]string) (string, error) {
 	result, err := ec2Client.CreateVpc(ctx, &ec2.CreateVpcInput{
-		CidrBlock: aws.String("10.0.0.0/16"),
+		CidrBlock:         aws.String(vpcConfig.CIDRBlock),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeVpc, tags),
 	})
 	if err != nil {
 		return "", fmt.Errorf("error creating VPC: %w", err)
@@ -134,23 +621,20 @@ func CreateVPC(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client) (
 	}
 	logger.Printf("DNS hostnames enabled for VPC with ID: %s", *result.Vpc.VpcId)
 
+	if err := AssociateSecondaryCIDRBlocks(ctx, logger, ec2Client, *result.Vpc.VpcId, vpcConfig.SecondaryCIDRBlocks); err != nil {
+		return *result.Vpc.VpcId, err
+	}
+
 	return *result.Vpc.VpcId, nil
 }
 
-func CreateSubnets(
-	ctx context.Context,
-	logger *log.Logger,
-	ec2Client *ec2.Client,
-	vpcID string,
-	internetGatewayID string,
-) ([]string, error) {
-	subnets := make([]string, 0, len(AWSSubnetAvailabilityZones))
-
+func CreateRouteTable(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, internetGatewayID string, tags map[string]string) (string, error) {
 	routeTableResult, err := ec2Client.CreateRouteTable(ctx, &ec2.CreateRouteTableInput{
-		VpcId: aws.String(vpcID),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeRouteTable, tags),
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error creating route table: %w", err)
+		return "", fmt.Errorf("error creating route table: %w", err)
 	}
 	routeTableID := *routeTableResult.RouteTable.RouteTableId
 	logger.Printf("Route table created with ID: %s", routeTableID)
@@ -160,27 +644,50 @@ func CreateSubnets(
 		DestinationCidrBlock: aws.String("0.0.0.0/0"),
 		GatewayId:            aws.String(internetGatewayID),
 	}); err != nil {
-		return nil, fmt.Errorf("error creating route to internet gateway: %w", err)
+		return routeTableID, fmt.Errorf("error creating route to internet gateway: %w", err)
 	}
 	logger.Printf("Created route to Internet Gateway %s in route table %s", internetGatewayID, routeTableID)
 
-	for cidrBlock, availabilityZone := range AWSSubnetAvailabilityZones {
+	return routeTableID, nil
+}
+
+// CreateSubnets creates the given subnets. Public subnets get public IPs
+// and are associated with the route table, private ones keep the main route
+// table of the VPC. On failure the subnets created so far are still returned.
+func CreateSubnets(
+	ctx context.Context,
+	logger *log.Logger,
+	ec2Client *ec2.Client,
+	subnetConfigs []SubnetConfig,
+	vpcID string,
+	routeTableID string,
+	tags map[string]string,
+) ([]string, error) {
+	subnets := make([]string, 0, len(subnetConfigs))
+
+	for _, subnet := range subnetConfigs {
 		subnetResult, err := ec2Client.CreateSubnet(ctx, &ec2.CreateSubnetInput{
-			VpcId:            aws.String(vpcID),
-			CidrBlock:        aws.String(cidrBlock),
-			AvailabilityZone: aws.String(availabilityZone),
+			VpcId:             aws.String(vpcID),
+			CidrBlock:         aws.String(subnet.CIDRBlock),
+			AvailabilityZone:  aws.String(subnet.AvailabilityZone),
+			TagSpecifications: ec2TagSpecifications(types.ResourceTypeSubnet, tags),
 		})
 		if err != nil {
-			return nil, fmt.Errorf("error creating subnet: %w", err)
+			return subnets, fmt.Errorf("error creating subnet: %w", err)
 		}
 		subnetID := *subnetResult.Subnet.SubnetId
+		subnets = append(subnets, subnetID)
 		logger.Printf("Subnet created with ID: %s", subnetID)
 
+		if subnet.Private {
+			continue
+		}
+
 		if _, err := ec2Client.ModifySubnetAttribute(ctx, &ec2.ModifySubnetAttributeInput{
 			SubnetId:            aws.String(subnetID),
 			MapPublicIpOnLaunch: &types.AttributeBooleanValue{Value: aws.Bool(true)},
 		}); err != nil {
-			return nil, fmt.Errorf("error enabling auto-assign public IPv4: %w", err)
+			return subnets, fmt.Errorf("error enabling auto-assign public IPv4: %w", err)
 		}
 		logger.Printf("Enabled auto-assign public IPv4 for subnet: %s", subnetID)
 
@@ -188,101 +695,51 @@ func CreateSubnets(
 			RouteTableId: aws.String(routeTableID),
 			SubnetId:     aws.String(subnetID),
 		}); err != nil {
-			return nil, fmt.Errorf("error associating route table: %w", err)
+			return subnets, fmt.Errorf("error associating route table: %w", err)
 		}
 		logger.Printf("Associated route table %s with subnet %s", routeTableID, subnetID)
-
-		subnets = append(subnets, subnetID)
 	}
 
 	return subnets, nil
 }
 
-func CreateSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string) (string, error) {
-	sgName := AWSSecurityGroupPrefix + uuid.NewString()
+func CreateSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, sgConfig SecurityGroupConfig, vpcID string, tags map[string]string) (string, error) {
 	createOutput, err := ec2Client.CreateSecurityGroup(ctx, &ec2.CreateSecurityGroupInput{
-		GroupName:   aws.String(sgName),
-		Description: aws.String(AWSSecurityGroupDescription),
-		VpcId:       aws.String(vpcID),
+		GroupName:         aws.String(sgConfig.Name),
+		Description:       aws.String(sgConfig.Description),
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeSecurityGroup, tags),
 	})
 	if err != nil {
 		return "", fmt.Errorf("error creating security group: %w", err)
 	}
 	logger.Printf("Created security group with ID: %s", *createOutput.GroupId)
 
-	ec2IngressInput := &ec2.AuthorizeSecurityGroupIngressInput{
-		GroupId: createOutput.GroupId,
-		IpPermissions: []types.IpPermission{
-			{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(8080),
-				ToPort:     aws.Int32(8080),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(80),
-				ToPort:     aws.Int32(80),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-			{
-				IpProtocol: aws.String("tcp"),
-				FromPort:   aws.Int32(443),
-				ToPort:     aws.Int32(443),
-				IpRanges: []types.IpRange{
-					{
-						CidrIp: aws.String("0.0.0.0/0"),
-					},
-				},
-			},
-		},
+	ingressRules, err := ResolvePrefixLists(ctx, ec2Client, sgConfig.IngressRules())
+	if err != nil {
+		return *createOutput.GroupId, err
+	}
+	if len(ingressRules) > 0 {
+		if _, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       createOutput.GroupId,
+			IpPermissions: ipPermissions(ingressRules),
+		}); err != nil {
+			return "", fmt.Errorf("error adding inbound (ingress) rules %s: %w", strings.Join(DescribeRules(ingressRules), ", "), err)
+		}
+		logger.Printf("Added inbound (ingress) rules %s to security group with ID: %s", strings.Join(DescribeRules(ingressRules), ", "), *createOutput.GroupId)
 	}
 
-	if _, err = ec2Client.AuthorizeSecurityGroupIngress(ctx, ec2IngressInput); err != nil {
-		return "", fmt.Errorf("error adding inbound (ingress) rule for port 8080: %w", err)
+	if err := ConfigureEgress(ctx, logger, ec2Client, sgConfig, *createOutput.GroupId); err != nil {
+		return *createOutput.GroupId, err
 	}
-	logger.Printf("Added inbound (ingress) rule for port 8080 to security group with ID: %s", *createOutput.GroupId)
 
 	return *createOutput.GroupId, nil
 }
 
-func CreateLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, securityGroupID string) (string, error) {
-	userDataBytes, err := os.ReadFile(UserDataScript)
-	if err != nil {
-		return "", fmt.Errorf("error reading user_data.sh file: %w", err)
-	}
-	logger.Println("user_data.sh file read successfully")
-
-	base64UserData := base64.StdEncoding.EncodeToString(userDataBytes)
-	ec2LaunchTemplate, err := ec2Client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
-		LaunchTemplateData: &types.RequestLaunchTemplateData{
-			UserData:     aws.String(base64UserData),
-			ImageId:      aws.String(AWSAmiID),
-			InstanceType: types.InstanceTypeT2Micro,
-			SecurityGroupIds: []string{
-				securityGroupID,
-			},
-		},
-		LaunchTemplateName: aws.String(AWSLaunchTemplatePrefix + uuid.NewString()),
+func CreateInternetGateway(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, tags map[string]string) (string, error) {
+	result, err := ec2Client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeInternetGateway, tags),
 	})
-	if err != nil {
-		return "", fmt.Errorf("error creating launch template: %w", err)
-	}
-	logger.Printf("Launch template created with ID: %s", *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId)
-
-	return *ec2LaunchTemplate.LaunchTemplate.LaunchTemplateId, nil
-}
-
-func CreateInternetGateway(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string) (string, error) {
-	result, err := ec2Client.CreateInternetGateway(ctx, &ec2.CreateInternetGatewayInput{})
 	if err != nil {
 		return "", fmt.Errorf("error creating internet gateway: %w", err)
 	}
@@ -300,14 +757,15 @@ func CreateInternetGateway(ctx context.Context, logger *log.Logger, ec2Client *e
 	return *result.InternetGateway.InternetGatewayId, nil
 }
 
-func CreateLoadBalancer(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, subnetIDs []string, securityGroupID string) (string, string, error) {
+func CreateLoadBalancer(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, lbConfig LoadBalancerConfig, subnetIDs []string, securityGroupID string, tags map[string]string) (string, string, error) {
 	input := &elasticloadbalancingv2.CreateLoadBalancerInput{
-		Name:           aws.String("webservice-load-balancer"),
-		Scheme:         elbTypes.LoadBalancerSchemeEnumInternetFacing,
+		Name:           aws.String(lbConfig.Name),
+		Scheme:         elbTypes.LoadBalancerSchemeEnum(lbConfig.Scheme),
 		Subnets:        subnetIDs,
 		SecurityGroups: []string{securityGroupID},
 		IpAddressType:  elbTypes.IpAddressTypeIpv4,
 		Type:           elbTypes.LoadBalancerTypeEnumApplication,
+		Tags:           elbTags(tags),
 	}
 
 	output, err := elbClient.CreateLoadBalancer(ctx, input)
@@ -323,13 +781,21 @@ func CreateLoadBalancer(ctx context.Context, logger *log.Logger, elbClient *elas
 	return lbARN, dnsName, nil
 }
 
-func CreateTargetGroup(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, vpcID string) (string, error) {
+func CreateTargetGroup(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, tgConfig TargetGroupConfig, vpcID string, tags map[string]string) (string, error) {
 	input := &elasticloadbalancingv2.CreateTargetGroupInput{
-		Name:       aws.String("webservice-target-group"),
-		Protocol:   elbTypes.ProtocolEnumHttp,
-		Port:       aws.Int32(8080),
-		VpcId:      aws.String(vpcID),
-		TargetType: elbTypes.TargetTypeEnumInstance,
+		Name:                       aws.String(tgConfig.Name),
+		Protocol:                   elbTypes.ProtocolEnumHttp,
+		Port:                       aws.Int32(tgConfig.Port),
+		VpcId:                      aws.String(vpcID),
+		TargetType:                 elbTypes.TargetTypeEnum(tgConfig.TargetType),
+		ProtocolVersion:            aws.String(tgConfig.ProtocolVersion),
+		HealthCheckPath:            aws.String(tgConfig.HealthCheck.Path),
+		HealthCheckIntervalSeconds: aws.Int32(tgConfig.HealthCheck.IntervalSeconds),
+		HealthCheckTimeoutSeconds:  aws.Int32(tgConfig.HealthCheck.TimeoutSeconds),
+		HealthyThresholdCount:      aws.Int32(tgConfig.HealthCheck.HealthyThreshold),
+		UnhealthyThresholdCount:    aws.Int32(tgConfig.HealthCheck.UnhealthyThreshold),
+		Matcher:                    tgConfig.HealthCheckMatcher(),
+		Tags:                       elbTags(tags),
 	}
 
 	output, err := elbClient.CreateTargetGroup(ctx, input)
@@ -340,70 +806,126 @@ func CreateTargetGroup(ctx context.Context, logger *log.Logger, elbClient *elast
 	tgARN := *output.TargetGroups[0].TargetGroupArn
 	logger.Printf("Target group created with ARN: %s", tgARN)
 
+	if err := SetTargetGroupAttributes(ctx, elbClient, tgARN, tgConfig.Attributes()); err != nil {
+		return tgARN, err
+	}
+
 	return tgARN, nil
 }
 
-func CreateListener(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, loadBalancerARN, targetGroupARN string) error {
+// CreateListener creates the listener forwarding to the target group. With a
+// certificate it serves HTTPS, and with trustStoreARN set it requires client
+// certificates.
+func CreateListener(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, listenerConfig ListenerConfig, loadBalancerARN string, targetGroups []elbTypes.TargetGroupTuple, trustStoreARN string, oidcClient OIDCClient) (string, error) {
 	input := &elasticloadbalancingv2.CreateListenerInput{
 		LoadBalancerArn: aws.String(loadBalancerARN),
-		Protocol:        elbTypes.ProtocolEnumHttp,
-		Port:            aws.Int32(80),
-		DefaultActions: []elbTypes.Action{
-			{
-				Type: elbTypes.ActionTypeEnumForward,
-				ForwardConfig: &elbTypes.ForwardActionConfig{
-					TargetGroups: []elbTypes.TargetGroupTuple{
-						{
-							TargetGroupArn: aws.String(targetGroupARN),
-						},
-					},
-				},
-			},
-		},
+		Protocol:        elbTypes.ProtocolEnum(listenerConfig.Protocol()),
+		Port:            aws.Int32(listenerConfig.Port),
+		DefaultActions:  ListenerDefaultActions(listenerConfig, targetGroups, oidcClient),
 	}
 
-	if _, err := elbClient.CreateListener(ctx, input); err != nil {
-		return fmt.Errorf("error creating listener: %w", err)
+	if listenerConfig.CertificateARN != "" {
+		input.Certificates = []elbTypes.Certificate{{CertificateArn: aws.String(listenerConfig.CertificateARN)}}
+		input.SslPolicy = aws.String(listenerConfig.SSLPolicy)
+	}
+	if listenerConfig.MutualTLS != nil {
+		input.MutualAuthentication = mutualAuthentication(*listenerConfig.MutualTLS, trustStoreARN)
 	}
 
-	logger.Println("Listener created successfully")
-	return nil
+	output, err := elbClient.CreateListener(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error creating listener: %w", err)
+	}
+
+	listenerARN := *output.Listeners[0].ListenerArn
+	logger.Printf("Listener created with ARN: %s", listenerARN)
+	return listenerARN, nil
 }
 
-func CreateAutoscalingGroup(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, launchTemplateID string, targetGroupARN string, subnetIDs []string) error {
-	autoscalingGroupName := AWSAutoscalingGroupPrefix + uuid.NewString()
-	if _, err := autoscalingClient.CreateAutoScalingGroup(ctx, &autoscaling.CreateAutoScalingGroupInput{
-		AutoScalingGroupName: aws.String(autoscalingGroupName),
-		LaunchTemplate: &autoscalingTypes.LaunchTemplateSpecification{
+func CreateAutoscalingGroup(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, asgConfig AutoScalingConfig, launchTemplateID, launchTemplateVersion string, targetGroupARN string, subnetIDs []string, tags map[string]string) (string, error) {
+	autoscalingGroupName := asgConfig.Name
+	// Without a target group, e.g. when it routes to IP targets, the group
+	// only keeps the instances running.
+	var targetGroupARNs []string
+	if targetGroupARN != "" {
+		targetGroupARNs = []string{targetGroupARN}
+	}
+	input := &autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName:             aws.String(autoscalingGroupName),
+		MinSize:                          aws.Int32(asgConfig.MinSize),
+		MaxSize:                          aws.Int32(asgConfig.MaxSize),
+		DesiredCapacity:                  asgConfig.DesiredCapacity,
+		DefaultCooldown:                  aws.Int32(asgConfig.DefaultCooldown),
+		HealthCheckType:                  aws.String(asgConfig.HealthCheckType),
+		HealthCheckGracePeriod:           aws.Int32(asgConfig.HealthCheckGracePeriod),
+		TerminationPolicies:              asgConfig.TerminationPolicies,
+		MaxInstanceLifetime:              aws.Int32(asgConfig.MaxInstanceLifetime()),
+		NewInstancesProtectedFromScaleIn: aws.Bool(asgConfig.NewInstancesProtectedFromScaleIn),
+		TargetGroupARNs:                  targetGroupARNs,
+		VPCZoneIdentifier:                aws.String(strings.Join(subnetIDs, ",")),
+		Tags:                             autoscalingTags(tags),
+	}
+	// With instance requirements the group picks any matching instance type,
+	// overriding the one of the launch template.
+	if asgConfig.InstanceRequirements != nil {
+		input.MixedInstancesPolicy = asgConfig.InstanceRequirements.MixedInstancesPolicy(launchTemplateID, launchTemplateVersion)
+	} else {
+		input.LaunchTemplate = &autoscalingTypes.LaunchTemplateSpecification{
 			LaunchTemplateId: aws.String(launchTemplateID),
-			Version:          aws.String(AWSLaunchTemplateVersion),
-		},
-		MinSize: aws.Int32(AWSMinEC2Count),
-		MaxSize: aws.Int32(AWSMaxEC2Count),
-		TargetGroupARNs: []string{
-			targetGroupARN,
-		},
-		VPCZoneIdentifier: aws.String(strings.Join(subnetIDs, ",")),
-	}); err != nil {
-		return fmt.Errorf("error creating autoscaling group: %w", err)
+			Version:          aws.String(launchTemplateVersion),
+		}
+	}
+	if _, err := autoscalingClient.CreateAutoScalingGroup(ctx, input); err != nil {
+		return "", fmt.Errorf("error creating autoscaling group: %w", err)
 	}
 	logger.Printf("Autoscaling group created with name: %s", autoscalingGroupName)
 
-	policyInput := &autoscaling.PutScalingPolicyInput{
+	if len(asgConfig.GroupMetrics) > 0 {
+		if err := EnableGroupMetrics(ctx, logger, autoscalingClient, autoscalingGroupName, asgConfig.GroupMetrics); err != nil {
+			return autoscalingGroupName, err
+		}
+	}
+
+	return autoscalingGroupName, nil
+}
+
+// EnableGroupMetrics starts publishing the given group metrics to CloudWatch
+// at 1-minute granularity.
+func EnableGroupMetrics(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, metrics []string) error {
+	if _, err := autoscalingClient.EnableMetricsCollection(ctx, &autoscaling.EnableMetricsCollectionInput{
 		AutoScalingGroupName: aws.String(autoscalingGroupName),
-		PolicyName:           aws.String(AWSAutoscalingPolicyPrefix + uuid.NewString()),
-		PolicyType:           aws.String(AWSAutoscalingPolicyType),
-		TargetTrackingConfiguration: &autoscalingTypes.TargetTrackingConfiguration{
-			TargetValue: aws.Float64(AWSAutoScalingCPUThreshold),
-			PredefinedMetricSpecification: &autoscalingTypes.PredefinedMetricSpecification{
-				PredefinedMetricType: autoscalingTypes.MetricTypeASGAverageCPUUtilization,
-			},
-		},
+		Granularity:          aws.String("1Minute"),
+		Metrics:              metrics,
+	}); err != nil {
+		return fmt.Errorf("error enabling autoscaling group metrics: %w", err)
+	}
+	logger.Printf("Metrics collection enabled for autoscaling group %s: %s", autoscalingGroupName, strings.Join(metrics, ", "))
+
+	return nil
+}
+
+func CreateScalingPolicy(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, asgConfig AutoScalingConfig, autoscalingGroupName string) (string, error) {
+	policyName := asgConfig.PolicyName
+	if err := PutScalingPolicy(ctx, autoscalingClient, asgConfig, autoscalingGroupName, policyName); err != nil {
+		return "", err
+	}
+	logger.Printf("Autoscaling policy created with name: %s", policyName)
+
+	return policyName, nil
+}
+
+// PutScalingPolicy creates the target tracking policy, or replaces the
+// configuration of an existing policy with the same name.
+func PutScalingPolicy(ctx context.Context, autoscalingClient *autoscaling.Client, asgConfig AutoScalingConfig, autoscalingGroupName, policyName string) error {
+	policyInput := &autoscaling.PutScalingPolicyInput{
+		AutoScalingGroupName:        aws.String(autoscalingGroupName),
+		PolicyName:                  aws.String(policyName),
+		PolicyType:                  aws.String(AWSAutoscalingPolicyType),
+		TargetTrackingConfiguration: TargetTrackingConfiguration(asgConfig, autoscalingGroupName),
 	}
 	if _, err := autoscalingClient.PutScalingPolicy(ctx, policyInput); err != nil {
-		return fmt.Errorf("error creating autoscaling policy: %w", err)
+		return fmt.Errorf("error putting autoscaling policy: %w", err)
 	}
-	logger.Println("Autoscaling policy created successfully")
 
 	return nil
 }
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// MaxFixedResponseBodyLength is the longest body a fixed-response action
+	// can return.
+	MaxFixedResponseBodyLength = 1024
+	DefaultMaintenanceBody     = "<html><body><h1>Down for maintenance</h1><p>We'll be back shortly.</p></body></html>"
+)
+
+// FixedResponseContentTypes are the content types a fixed-response action can
+// answer with.
+var FixedResponseContentTypes = []string{"text/plain", "text/css", "text/html", "application/javascript", "application/json"}
+
+// MaintenanceConfig is the page the listener answers every request with
+// while the stack is in maintenance mode.
+type MaintenanceConfig struct {
+	StatusCode  int    `json:"statusCode"`
+	ContentType string `json:"contentType"`
+	Body        string `json:"body"`
+}
+
+// maintenanceAction answers with the maintenance page instead of forwarding.
+func maintenanceAction(maintenanceConfig MaintenanceConfig) elbTypes.Action {
+	return elbTypes.Action{
+		Type: elbTypes.ActionTypeEnumFixedResponse,
+		FixedResponseConfig: &elbTypes.FixedResponseActionConfig{
+			StatusCode:  aws.String(fmt.Sprint(maintenanceConfig.StatusCode)),
+			ContentType: aws.String(maintenanceConfig.ContentType),
+			MessageBody: aws.String(maintenanceConfig.Body),
+		},
+	}
+}
+
+// SetListenerDefaultActions replaces the default actions of the listener.
+func SetListenerDefaultActions(ctx context.Context, elbClient *elasticloadbalancingv2.Client, listenerARN string, actions []elbTypes.Action) error {
+	if _, err := elbClient.ModifyListener(ctx, &elasticloadbalancingv2.ModifyListenerInput{
+		ListenerArn:    aws.String(listenerARN),
+		DefaultActions: actions,
+	}); err != nil {
+		return fmt.Errorf("error modifying listener: %w", err)
+	}
+	return nil
+}
+
+// SetMaintenance switches the listener to the maintenance page, or back to
+// its regular actions. The target group and the autoscaling group are left
+// untouched, so the instances keep running and pass health checks.
+func SetMaintenance(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, enabled bool) error {
+	actions := []elbTypes.Action{maintenanceAction(cfg.Listener.Maintenance)}
+	forwardActions := actions
+	if !enabled {
+		oidcClient, err := ListenerOIDCClient(ctx, clients, cfg.Listener)
+		if err != nil {
+			return err
+		}
+		targetGroups := ForwardTargetGroups(state.TargetGroupARN, state.CanaryTargetGroupARN, state.CanaryWeight)
+		actions = ListenerDefaultActions(cfg.Listener, targetGroups, oidcClient)
+		forwardActions = ListenerDefaultActions(ListenerConfig{}, targetGroups, OIDCClient{})
+	}
+
+	if err := SetListenerDefaultActions(ctx, clients.ELB, state.ListenerARN, actions); err != nil {
+		return err
+	}
+	for _, listenerARN := range ForwardingListenerARNs(cfg, state) {
+		if err := SetListenerDefaultActions(ctx, clients.ELB, listenerARN, forwardActions); err != nil {
+			return err
+		}
+	}
+	if enabled {
+		logger.Printf("Maintenance mode on, listener %s answers %d", state.ListenerARN, cfg.Listener.Maintenance.StatusCode)
+	} else {
+		logger.Printf("Maintenance mode off, listener %s forwards to %s", state.ListenerARN, state.TargetGroupARN)
+	}
+
+	return state.Record(func(s *State) { s.Maintenance = enabled })
+}
+
+func runMaintenance(ctx context.Context, logger *log.Logger, args []string) error {
+	if len(args) == 0 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("maintenance requires a mode: on or off")
+	}
+	mode, args := args[0], args[1:]
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("maintenance "+mode, flag.ExitOnError)
+	opts.Register(fs)
+	yes := fs.Bool("yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+	if state.ListenerARN == "" {
+		return fmt.Errorf("no listener recorded in %s", state.Location())
+	}
+
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "maintenance")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	enabled := mode == "on"
+	if enabled && !*yes {
+		ok, err := Confirm(fmt.Sprintf("Answer every request to %s with %d?", state.LoadBalancerDNSName, cfg.Listener.Maintenance.StatusCode))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Maintenance mode cancelled")
+			return nil
+		}
+	}
+
+	return SetMaintenance(ctx, logger, clients, cfg, state, enabled)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MetricsPath = "/metrics"
+	// MetricsContentType is the Prometheus text exposition format.
+	MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// StackMetrics are the gauges watch exposes to Prometheus, updated by every
+// poll of the stack. Nothing is exposed before the first poll succeeded.
+type StackMetrics struct {
+	labels string
+
+	mu             sync.Mutex
+	polled         bool
+	inService      int
+	healthyTargets int
+	desired        int32
+	lastActivity   time.Time
+}
+
+// NewStackMetrics labels the gauges with the stack and environment of cfg.
+func NewStackMetrics(cfg *Config) *StackMetrics {
+	return &StackMetrics{
+		labels: fmt.Sprintf("stack=%q,environment=%q,autoscaling_group=%q", cfg.Naming.Stack, cfg.Environment, cfg.AutoScaling.Name),
+	}
+}
+
+// Update sets the gauges from the status of the stack.
+func (m *StackMetrics) Update(status *StackStatus) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.polled = true
+	m.inService, m.healthyTargets = status.InServiceInstances(), status.HealthyTargets()
+	m.desired = status.Capacity.Desired
+	m.lastActivity = time.Time{}
+	if len(status.Activities) > 0 {
+		m.lastActivity = status.Activities[0].StartTime
+	}
+}
+
+// WriteTo writes the gauges in the Prometheus text format. The age of the
+// last scaling activity is computed at the time of the scrape.
+func (m *StackMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var out strings.Builder
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} %g\n", name, help, name, name, m.labels, value)
+	}
+	if m.polled {
+		gauge("stack_in_service_instances", "Instances of the autoscaling group in service.", float64(m.inService))
+		gauge("stack_healthy_targets", "Healthy targets of the target group.", float64(m.healthyTargets))
+		gauge("stack_desired_capacity", "Desired capacity of the autoscaling group.", float64(m.desired))
+		if !m.lastActivity.IsZero() {
+			gauge("stack_last_scaling_activity_age_seconds", "Seconds since the last scaling activity started.", time.Since(m.lastActivity).Seconds())
+		}
+	}
+	n, err := io.WriteString(w, out.String())
+	return int64(n), err
+}
+
+func (m *StackMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", MetricsContentType)
+	m.WriteTo(w)
+}
+
+// ServeMetrics serves the metrics at /metrics on addr in the background
+// until ctx is done. It only fails when addr can't be listened on.
+func ServeMetrics(ctx context.Context, logger *log.Logger, addr string, metrics *StackMetrics) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, metrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Printf("Error serving metrics: %v", err)
+		}
+	}()
+	logger.Printf("Serving Prometheus metrics on http://%s%s", listener.Addr(), MetricsPath)
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	NetworkACLSubnetsAll     = "all"
+	NetworkACLSubnetsPublic  = "public"
+	NetworkACLSubnetsPrivate = "private"
+)
+
+// networkACLProtocols maps the protocol names of the config to the protocol
+// numbers network ACL entries take.
+var networkACLProtocols = map[string]string{
+	"tcp":       "6",
+	"udp":       "17",
+	"icmp":      "1",
+	ProtocolAll: ProtocolAll,
+}
+
+// NetworkACLConfig replaces the default network ACL of the subnets with one
+// holding only the configured entries. Unlike security groups network ACLs
+// are stateless, so responses need their own entries, usually for the
+// ephemeral ports 1024-65535.
+type NetworkACLConfig struct {
+	Enabled  bool              `json:"enabled"`
+	Inbound  []NetworkACLEntry `json:"inbound"`
+	Outbound []NetworkACLEntry `json:"outbound"`
+	// Subnets selects the associated subnets: all, public or private.
+	Subnets string `json:"subnets"`
+}
+
+// NetworkACLEntry allows or denies traffic of a protocol on a port range to
+// or from a CIDR block. Entries are evaluated by ascending RuleNumber.
+type NetworkACLEntry struct {
+	RuleNumber int32  `json:"ruleNumber"`
+	Protocol   string `json:"protocol"`
+	FromPort   int32  `json:"fromPort"`
+	ToPort     int32  `json:"toPort"`
+	CIDR       string `json:"cidr"`
+	// Action is allow or deny.
+	Action string `json:"action"`
+}
+
+// normalized fills in the default protocol and ToPort.
+func (e NetworkACLEntry) normalized() NetworkACLEntry {
+	if e.Protocol == "" {
+		e.Protocol = "tcp"
+	}
+	if e.ToPort == 0 {
+		e.ToPort = e.FromPort
+	}
+	return e
+}
+
+// ProtocolNumber is the protocol of the entry as network ACLs expect it.
+func (e NetworkACLEntry) ProtocolNumber() string {
+	return networkACLProtocols[e.normalized().Protocol]
+}
+
+// HasPorts reports whether the entry applies to a port range.
+func (e NetworkACLEntry) HasPorts() bool {
+	protocol := e.normalized().Protocol
+	return protocol == "tcp" || protocol == "udp"
+}
+
+// InboundEntries returns the normalized inbound entries.
+func (n NetworkACLConfig) InboundEntries() []NetworkACLEntry {
+	return normalizedEntries(n.Inbound)
+}
+
+// OutboundEntries returns the normalized outbound entries.
+func (n NetworkACLConfig) OutboundEntries() []NetworkACLEntry {
+	return normalizedEntries(n.Outbound)
+}
+
+func normalizedEntries(entries []NetworkACLEntry) []NetworkACLEntry {
+	normalized := make([]NetworkACLEntry, 0, len(entries))
+	for _, entry := range entries {
+		normalized = append(normalized, entry.normalized())
+	}
+	return normalized
+}
+
+// AssociatedSubnetIDs picks the subnets the network ACL is associated with.
+func (n NetworkACLConfig) AssociatedSubnetIDs(publicSubnetIDs, privateSubnetIDs []string) []string {
+	switch n.Subnets {
+	case NetworkACLSubnetsPublic:
+		return publicSubnetIDs
+	case NetworkACLSubnetsPrivate:
+		return privateSubnetIDs
+	default:
+		return append(slices.Clone(publicSubnetIDs), privateSubnetIDs...)
+	}
+}
+
+// CreateNetworkACL creates the network ACL with its entries. It returns the
+// ID of the network ACL also when adding an entry fails, so it can be
+// recorded and cleaned up.
+func CreateNetworkACL(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, naclConfig NetworkACLConfig, vpcID string, tags map[string]string) (string, error) {
+	output, err := ec2Client.CreateNetworkAcl(ctx, &ec2.CreateNetworkAclInput{
+		VpcId:             aws.String(vpcID),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypeNetworkAcl, tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating network ACL: %w", err)
+	}
+	networkACLID := aws.StringValue(output.NetworkAcl.NetworkAclId)
+	logger.Printf("Network ACL created with ID: %s", networkACLID)
+
+	inbound, outbound := naclConfig.InboundEntries(), naclConfig.OutboundEntries()
+	for _, entries := range []struct {
+		egress  bool
+		entries []NetworkACLEntry
+	}{{false, inbound}, {true, outbound}} {
+		for _, entry := range entries.entries {
+			input := &ec2.CreateNetworkAclEntryInput{
+				NetworkAclId: aws.String(networkACLID),
+				RuleNumber:   aws.Int32(entry.RuleNumber),
+				Protocol:     aws.String(entry.ProtocolNumber()),
+				CidrBlock:    aws.String(entry.CIDR),
+				RuleAction:   types.RuleAction(entry.Action),
+				Egress:       aws.Bool(entries.egress),
+			}
+			if entry.HasPorts() {
+				input.PortRange = &types.PortRange{From: aws.Int32(entry.FromPort), To: aws.Int32(entry.ToPort)}
+			}
+			if entry.Protocol == "icmp" {
+				input.IcmpTypeCode = &types.IcmpTypeCode{Type: aws.Int32(-1), Code: aws.Int32(-1)}
+			}
+			if _, err := ec2Client.CreateNetworkAclEntry(ctx, input); err != nil {
+				return networkACLID, fmt.Errorf("error adding network ACL entry %d: %w", entry.RuleNumber, err)
+			}
+		}
+	}
+	logger.Printf("Added %d inbound and %d outbound entries to network ACL %s", len(inbound), len(outbound), networkACLID)
+
+	return networkACLID, nil
+}
+
+// AssociateNetworkACL moves the subnets from their current network ACL,
+// usually the default one of the VPC, to the given one.
+func AssociateNetworkACL(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, networkACLID string, subnetIDs []string) error {
+	if len(subnetIDs) == 0 {
+		return nil
+	}
+
+	output, err := ec2Client.DescribeNetworkAcls(ctx, &ec2.DescribeNetworkAclsInput{
+		Filters: []types.Filter{{Name: aws.String("association.subnet-id"), Values: subnetIDs}},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing network ACLs: %w", err)
+	}
+
+	for _, acl := range output.NetworkAcls {
+		for _, association := range acl.Associations {
+			if !slices.Contains(subnetIDs, aws.StringValue(association.SubnetId)) {
+				continue
+			}
+			if _, err := ec2Client.ReplaceNetworkAclAssociation(ctx, &ec2.ReplaceNetworkAclAssociationInput{
+				AssociationId: association.NetworkAclAssociationId,
+				NetworkAclId:  aws.String(networkACLID),
+			}); err != nil {
+				return fmt.Errorf("error associating subnet %s with network ACL: %w", aws.StringValue(association.SubnetId), err)
+			}
+			logger.Printf("Subnet %s associated with network ACL %s", aws.StringValue(association.SubnetId), networkACLID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NotifierSlack   = "slack"
+	NotifierWebhook = "webhook"
+
+	DefaultNotificationTimeoutSeconds = 10
+
+	NotificationStarted   = "started"
+	NotificationStep      = "step"
+	NotificationFailed    = "failed"
+	NotificationSucceeded = "succeeded"
+	NotificationChange    = "change"
+)
+
+// NotificationConfig posts the progress of apply and destroy to a Slack
+// incoming webhook or to any HTTP endpoint.
+type NotificationConfig struct {
+	// Type is slack, which posts messages, or webhook, which posts the
+	// notifications as JSON.
+	Type string `json:"type"`
+	URL  string `json:"url"`
+	// URLFromEnv is the environment variable the URL is read from instead,
+	// so the secret URL of a Slack webhook stays out of the config.
+	URLFromEnv     string `json:"urlFromEnv"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+func (n NotificationConfig) Timeout() time.Duration {
+	if n.TimeoutSeconds <= 0 {
+		return DefaultNotificationTimeoutSeconds * time.Second
+	}
+	return time.Duration(n.TimeoutSeconds) * time.Second
+}
+
+// Endpoint returns the URL notifications are posted to.
+func (n NotificationConfig) Endpoint() (string, error) {
+	if n.URLFromEnv == "" {
+		return n.URL, nil
+	}
+	endpoint, ok := os.LookupEnv(n.URLFromEnv)
+	if !ok || endpoint == "" {
+		return "", fmt.Errorf("environment variable %s is not set", n.URLFromEnv)
+	}
+	return endpoint, nil
+}
+
+// Notification is what the notifier posts to webhooks. Message describes a
+// change noticed by watch; outputs are only set when an apply succeeded.
+type Notification struct {
+	Command     string        `json:"command"`
+	Event       string        `json:"event"`
+	Stack       string        `json:"stack"`
+	Environment string        `json:"environment,omitempty"`
+	Step        string        `json:"step,omitempty"`
+	Resource    string        `json:"resource,omitempty"`
+	Error       string        `json:"error,omitempty"`
+	Message     string        `json:"message,omitempty"`
+	Outputs     *StackOutputs `json:"outputs,omitempty"`
+}
+
+// Text renders the notification as a chat message.
+func (n Notification) Text() string {
+	stack := n.Stack
+	if n.Environment != "" {
+		stack += " (" + n.Environment + ")"
+	}
+	switch n.Event {
+	case NotificationStarted:
+		return fmt.Sprintf("%s of %s started", n.Command, stack)
+	case NotificationStep:
+		if n.Resource == "" {
+			return fmt.Sprintf("%s of %s: %s done", n.Command, stack, n.Step)
+		}
+		return fmt.Sprintf("%s of %s: %s done (%s)", n.Command, stack, n.Step, n.Resource)
+	case NotificationChange:
+		return fmt.Sprintf("%s: %s", stack, n.Message)
+	case NotificationFailed:
+		if n.Step != "" {
+			return fmt.Sprintf("%s of %s failed at %s: %s", n.Command, stack, n.Step, n.Error)
+		}
+		return fmt.Sprintf("%s of %s failed: %s", n.Command, stack, n.Error)
+	default:
+		if n.Outputs != nil && n.Outputs.URL != "" {
+			return fmt.Sprintf("%s of %s finished, the service is at %s", n.Command, stack, n.Outputs.URL)
+		}
+		return fmt.Sprintf("%s of %s finished", n.Command, stack)
+	}
+}
+
+// Notifier posts the start, the steps, the failure or the outputs of a
+// command to the configured notifications. Posting is best effort: an
+// endpoint that fails is logged and never fails the command.
+type Notifier struct {
+	logger  *log.Logger
+	cfg     *Config
+	command string
+
+	mu         sync.Mutex
+	failedStep string
+}
+
+func NewNotifier(logger *log.Logger, cfg *Config, command string) *Notifier {
+	return &Notifier{logger: logger, cfg: cfg, command: command}
+}
+
+// Started posts that the command started.
+func (n *Notifier) Started() {
+	n.send(Notification{Event: NotificationStarted})
+}
+
+// StepDone posts that a step completed with the resource it created.
+func (n *Notifier) StepDone(step, resource string) {
+	n.send(Notification{Event: NotificationStep, Step: step, Resource: resource})
+}
+
+// StepFailed remembers the step that failed, for the failure posted once
+// the command gives up.
+func (n *Notifier) StepFailed(step string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failedStep == "" {
+		n.failedStep = step
+	}
+}
+
+// Failed posts the error the command failed with, naming the step that
+// failed first.
+func (n *Notifier) Failed(err error) {
+	n.mu.Lock()
+	step := n.failedStep
+	n.mu.Unlock()
+	n.send(Notification{Event: NotificationFailed, Step: step, Error: err.Error()})
+}
+
+// Changed posts a change of the stack noticed by watch.
+func (n *Notifier) Changed(message string) {
+	n.send(Notification{Event: NotificationChange, Message: message})
+}
+
+// Succeeded posts that the command finished, with the outputs of the stack
+// after an apply.
+func (n *Notifier) Succeeded(state *State) {
+	notification := Notification{Event: NotificationSucceeded}
+	if n.command == "apply" {
+		outputs := NewStackOutputs(n.cfg, state)
+		notification.Outputs = &outputs
+	}
+	n.send(notification)
+}
+
+func (n *Notifier) send(notification Notification) {
+	notification.Command = n.command
+	notification.Stack = n.cfg.Naming.Stack
+	notification.Environment = n.cfg.Environment
+	for i, target := range n.cfg.Notifications {
+		if err := PostNotification(target, notification); err != nil {
+			n.logger.Printf("Error posting notification to notifications[%d]: %v", i, err)
+		}
+	}
+}
+
+// PostNotification posts the notification to the endpoint of target, as a
+// Slack message or as JSON.
+func PostNotification(target NotificationConfig, notification Notification) error {
+	endpoint, err := target.Endpoint()
+	if err != nil {
+		return err
+	}
+	var payload any = notification
+	if target.Type == NotifierSlack {
+		payload = map[string]string{"text": notification.Text()}
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding notification: %w", err)
+	}
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), target.Timeout())
+	defer cancelFunc()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating notification request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The error names the URL, which is a secret for Slack webhooks.
+		return fmt.Errorf("error posting %s notification: %s", target.Type, strings.ReplaceAll(err.Error(), endpoint, "<url>"))
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s notification endpoint returned %s", target.Type, resp.Status)
+	}
+	return nil
+}
+
+// NotifyProgress posts the completion of the steps it tracks and remembers
+// the step that failed.
+type NotifyProgress struct {
+	Progress
+	notifier *Notifier
+}
+
+func NewNotifyProgress(notifier *Notifier, progress Progress) NotifyProgress {
+	return NotifyProgress{Progress: progress, notifier: notifier}
+}
+
+func (p NotifyProgress) Track(step string, fn func() (string, error)) error {
+	var resource string
+	if err := p.Progress.Track(step, func() (string, error) {
+		var err error
+		resource, err = fn()
+		return resource, err
+	}); err != nil {
+		p.notifier.StepFailed(step)
+		return err
+	}
+	p.notifier.StepDone(step, resource)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// StackOutputs is the machine-readable summary of a deployed stack printed
+// by --output json. Its fields are meant to be consumed by other tooling, so
+// they should only ever be added to.
+type StackOutputs struct {
+	Environment           string   `json:"environment,omitempty"`
+	Region                string   `json:"region"`
+	VPCID                 string   `json:"vpcId"`
+	InternetGatewayID     string   `json:"internetGatewayId"`
+	RouteTableID          string   `json:"routeTableId"`
+	SubnetIDs             []string `json:"subnetIds"`
+	SecurityGroupID       string   `json:"securityGroupId"`
+	LaunchTemplateID      string   `json:"launchTemplateId"`
+	LaunchTemplateVersion string   `json:"launchTemplateVersion"`
+	TargetGroupARN        string   `json:"targetGroupArn"`
+	AutoScalingGroupName  string   `json:"autoScalingGroupName"`
+	ScalingPolicyName     string   `json:"scalingPolicyName"`
+	LoadBalancerARN       string   `json:"loadBalancerArn"`
+	LoadBalancerDNSName   string   `json:"loadBalancerDnsName"`
+	ListenerARN           string   `json:"listenerArn"`
+	URL                   string   `json:"url"`
+	// ECSClusterARN and CapacityProviderName are only set in ECS mode.
+	ECSClusterARN        string `json:"ecsClusterArn,omitempty"`
+	CapacityProviderName string `json:"capacityProviderName,omitempty"`
+	// ElasticIPs are the public IPs of the allocated addresses by name.
+	ElasticIPs map[string]string `json:"elasticIps,omitempty"`
+	// BastionInstanceID is only set with a bastion.
+	BastionInstanceID string `json:"bastionInstanceId,omitempty"`
+	// ConnectEndpointID is only set with an instance connect endpoint.
+	ConnectEndpointID string `json:"instanceConnectEndpointId,omitempty"`
+	// LifecycleTopicARN is only set when a topic was created for the
+	// lifecycle events.
+	LifecycleTopicARN string `json:"lifecycleTopicArn,omitempty"`
+}
+
+func NewStackOutputs(cfg *Config, state *State) StackOutputs {
+	return StackOutputs{
+		Environment:           cfg.Environment,
+		Region:                cfg.Region,
+		VPCID:                 state.VPCID,
+		InternetGatewayID:     state.InternetGatewayID,
+		RouteTableID:          state.RouteTableID,
+		SubnetIDs:             state.SubnetIDs,
+		SecurityGroupID:       state.SecurityGroupID,
+		LaunchTemplateID:      state.LaunchTemplateID,
+		LaunchTemplateVersion: state.CurrentLaunchTemplateVersion(),
+		TargetGroupARN:        state.TargetGroupARN,
+		AutoScalingGroupName:  state.AutoScalingGroupName,
+		ScalingPolicyName:     state.ScalingPolicyName,
+		LoadBalancerARN:       state.LoadBalancerARN,
+		LoadBalancerDNSName:   state.LoadBalancerDNSName,
+		ListenerARN:           state.ListenerARN,
+		URL:                   ListenerURL(state.LoadBalancerDNSName, cfg.Listener),
+		ECSClusterARN:         state.ECSClusterARN,
+		CapacityProviderName:  state.CapacityProviderName,
+		ElasticIPs:            ElasticIPAddresses(state),
+		BastionInstanceID:     state.BastionInstanceID,
+		ConnectEndpointID:     state.InstanceConnectEndpointID,
+		LifecycleTopicARN:     state.LifecycleTopicARN(),
+	}
+}
+
+// ValidateOutputFormat checks the value of an --output flag.
+func ValidateOutputFormat(format string) error {
+	switch format {
+	case OutputText, OutputJSON:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, expected %s or %s", format, OutputText, OutputJSON)
+	}
+}
+
+func WriteJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("error encoding output: %w", err)
+	}
+	return nil
+}
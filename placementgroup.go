@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	PlacementStrategyCluster   = "cluster"
+	PlacementStrategySpread    = "spread"
+	PlacementStrategyPartition = "partition"
+	// MaxPlacementPartitions is the most partitions a partition placement
+	// group has per availability zone.
+	MaxPlacementPartitions = 7
+	// MaxSpreadInstancesPerZone is the most running instances a spread
+	// placement group holds per availability zone.
+	MaxSpreadInstancesPerZone = 7
+)
+
+// PlacementStrategies are the strategies a placement group places the
+// instances by.
+var PlacementStrategies = []string{PlacementStrategyCluster, PlacementStrategySpread, PlacementStrategyPartition}
+
+// PlacementGroupConfig launches the instances into a placement group:
+// cluster packs them close together for low network latency, spread puts
+// each on distinct hardware and partition splits them into groups that
+// share no racks.
+type PlacementGroupConfig struct {
+	Name     string `json:"name"`
+	Strategy string `json:"strategy"`
+	// PartitionCount is the number of partitions of the partition strategy,
+	// 2 by default.
+	PartitionCount int32 `json:"partitionCount"`
+}
+
+func (p PlacementGroupConfig) String() string {
+	if p.Strategy == PlacementStrategyPartition {
+		return fmt.Sprintf("%s, %s with %d partitions", p.Name, p.Strategy, p.PartitionCount)
+	}
+	return fmt.Sprintf("%s, %s", p.Name, p.Strategy)
+}
+
+// GroupSubnets returns the subnets the autoscaling groups launch instances
+// into: all of them, or only the first with a cluster placement group, which
+// can't span availability zones.
+func GroupSubnets[S any](cfg *Config, subnets []S) []S {
+	if cfg.PlacementGroup != nil && cfg.PlacementGroup.Strategy == PlacementStrategyCluster && len(subnets) > 1 {
+		return subnets[:1]
+	}
+	return subnets
+}
+
+// CreatePlacementGroup creates the placement group and returns its name.
+func CreatePlacementGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, pgConfig PlacementGroupConfig, tags map[string]string) (string, error) {
+	input := &ec2.CreatePlacementGroupInput{
+		GroupName:         aws.String(pgConfig.Name),
+		Strategy:          types.PlacementStrategy(pgConfig.Strategy),
+		TagSpecifications: ec2TagSpecifications(types.ResourceTypePlacementGroup, tags),
+	}
+	if pgConfig.Strategy == PlacementStrategyPartition {
+		input.PartitionCount = aws.Int32(pgConfig.PartitionCount)
+	}
+	if _, err := ec2Client.CreatePlacementGroup(ctx, input); err != nil {
+		return "", fmt.Errorf("error creating placement group: %w", err)
+	}
+	logger.Printf("Placement group %s created with the %s strategy", pgConfig.Name, pgConfig.Strategy)
+
+	return pgConfig.Name, nil
+}
+
+// deletePlacementGroup deletes the placement group, retrying while the
+// instances of the deleted autoscaling group are still terminating.
+func deletePlacementGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, groupName string) error {
+	ctx, cancel := context.WithTimeout(ctx, DestroyTimeout)
+	defer cancel()
+	ticker := time.NewTicker(DestroyPollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := ec2Client.DeletePlacementGroup(ctx, &ec2.DeletePlacementGroupInput{
+			GroupName: aws.String(groupName),
+		})
+		if err == nil || hasErrorCode(err, "InvalidPlacementGroup.Unknown") {
+			logger.Printf("Placement group %s deleted", groupName)
+			return nil
+		}
+		if !hasErrorCode(err, "InvalidPlacementGroup.InUse") {
+			return fmt.Errorf("error deleting placement group: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error deleting placement group: %w", errors.Join(err, ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+// UpdatePlacementGroup creates the placement group when it was added to the
+// config after the stack was applied, before the launch template refers to
+// it.
+func UpdatePlacementGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, cfg *Config, state *State) error {
+	pgConfig := cfg.PlacementGroup
+	if pgConfig == nil || state.PlacementGroupName == pgConfig.Name {
+		return nil
+	}
+	if state.PlacementGroupName != "" {
+		return fmt.Errorf("placement group %s can't be renamed to %s in place, recreate the stack", state.PlacementGroupName, pgConfig.Name)
+	}
+
+	groupName, err := CreatePlacementGroup(ctx, logger, ec2Client, *pgConfig, cfg.StackTags())
+	if err != nil {
+		return err
+	}
+	return state.Record(func(s *State) { s.PlacementGroupName = groupName })
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// PlannedResource is a single resource apply would create.
+type PlannedResource struct {
+	Type    string
+	Details string
+}
+
+// PlanResources lists the resources apply creates for cfg, in creation order.
+// Subnets without a configured zone are shown with the zone they will be
+// spread to.
+func PlanResources(cfg *Config) ([]PlannedResource, error) {
+	subnets, err := SubnetLayout(cfg.VPC, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := []PlannedResource{
+		{Type: "VPC", Details: strings.Join(cfg.VPC.CIDRBlocks(), ", ")},
+		{Type: "Internet gateway", Details: "attached to the VPC"},
+		{Type: "Route table", Details: "default route to the internet gateway"},
+	}
+	if cfg.VPC.Existing() {
+		vpc := cfg.VPC.ID
+		if vpc == "" {
+			vpc = fmt.Sprintf("selected by tags %v", cfg.VPC.Selector)
+		}
+		resources = []PlannedResource{{Type: "Existing VPC", Details: vpc + ", with its internet gateway and route table"}}
+	}
+	if subnetIDs := cfg.VPC.SubnetIDs; subnetIDs != nil {
+		resources = append(resources,
+			PlannedResource{Type: "Existing subnets", Details: "load balancer in " + strings.Join(subnetIDs.LoadBalancer, ", ")},
+			PlannedResource{Type: "Existing subnets", Details: "autoscaling groups in " + strings.Join(subnetIDs.AutoScalingGroup, ", ")},
+		)
+	}
+	for _, subnet := range subnets {
+		zone := subnet.AvailabilityZone
+		if zone == "" {
+			zone = fmt.Sprintf("availability zone #%d", subnet.ZoneIndex+1)
+		}
+		kind := "Public subnet"
+		if subnet.Private {
+			kind = "Private subnet"
+		}
+		resources = append(resources, PlannedResource{
+			Type:    kind,
+			Details: fmt.Sprintf("%s in %s", subnet.CIDRBlock, zone),
+		})
+	}
+	if len(cfg.VPC.Endpoints) > 0 {
+		resources = append(resources, PlannedResource{Type: "VPC endpoints", Details: DescribeEndpoints(cfg.VPC)})
+	}
+	if flowLogs := cfg.VPC.FlowLogs; flowLogs.Enabled {
+		destination := flowLogs.BucketARN
+		if flowLogs.ToCloudWatchLogs() {
+			destination = fmt.Sprintf("log group %s with role %s", flowLogs.LogGroupName, flowLogs.RoleName)
+		}
+		resources = append(resources, PlannedResource{
+			Type:    "Flow logs",
+			Details: fmt.Sprintf("%s traffic to %s", flowLogs.TrafficType, destination),
+		})
+	}
+	if logs := cfg.Logs; logs != nil {
+		for _, group := range logs.Groups() {
+			resources = append(resources, PlannedResource{Type: "Log group", Details: group.String()})
+		}
+	}
+	if nacl := cfg.VPC.NetworkACL; nacl.Enabled {
+		resources = append(resources, PlannedResource{
+			Type:    "Network ACL",
+			Details: fmt.Sprintf("%d inbound and %d outbound entries on %s subnets", len(nacl.Inbound), len(nacl.Outbound), nacl.Subnets),
+		})
+	}
+
+	if existing := cfg.SecurityGroup.Existing; existing != nil {
+		details := orDash(existing.ID + existing.Name)
+		if existing.Reconcile {
+			details += ", adding missing ingress " + strings.Join(DescribeRules(cfg.SecurityGroup.IngressRules()), ", ")
+		}
+		resources = append(resources, PlannedResource{Type: "Existing security group", Details: details})
+	} else {
+		resources = append(resources, PlannedResource{Type: "Security group", Details: "ingress " + strings.Join(DescribeRules(cfg.SecurityGroup.IngressRules()), ", ")})
+	}
+	if ecsConfig := cfg.ECS; ecsConfig != nil {
+		resources = append(resources, PlannedResource{Type: "ECS cluster", Details: ecsConfig.ClusterName})
+	}
+	if len(cfg.Secrets) > 0 {
+		secrets := make([]string, 0, len(cfg.Secrets))
+		for _, secret := range cfg.Secrets {
+			secrets = append(secrets, secret.String())
+		}
+		resources = append(resources, PlannedResource{Type: "Secrets", Details: strings.Join(secrets, ", ")})
+	}
+	if cfg.CreatesInstanceRole() {
+		policies := cfg.InstanceRolePolicyARNs()
+		if len(cfg.Secrets) > 0 {
+			policies = append(policies, SecretsPolicyName)
+		}
+		if cfg.Logs != nil {
+			policies = append(policies, LogsPolicyName)
+		}
+		resources = append(resources, PlannedResource{Type: "Instance role", Details: cfg.LaunchTemplate.InstanceRoleName + " with " + strings.Join(policies, ", ")})
+	}
+	if placementGroup := cfg.PlacementGroup; placementGroup != nil {
+		resources = append(resources, PlannedResource{Type: "Placement group", Details: placementGroup.String()})
+	}
+	if len(cfg.ElasticIPs) > 0 {
+		addresses := make([]string, 0, len(cfg.ElasticIPs))
+		for _, address := range cfg.ElasticIPs {
+			addresses = append(addresses, address.String())
+		}
+		resources = append(resources, PlannedResource{Type: "Elastic IPs", Details: strings.Join(addresses, ", ")})
+	}
+	if encryption := cfg.VolumeEncryption; encryption != nil {
+		resources = append(resources, PlannedResource{Type: "Volume key", Details: encryption.String()})
+	}
+	resources = append(resources,
+		PlannedResource{Type: "Launch template", Details: launchTemplateDetails(cfg.LaunchTemplate)},
+		PlannedResource{Type: "Target group", Details: fmt.Sprintf("%s, HTTP:%d, %s, %s targets", cfg.TargetGroup.Name, cfg.TargetGroup.Port, cfg.TargetGroup.ProtocolVersion, cfg.TargetGroup.TargetType)},
+		PlannedResource{Type: "Autoscaling group", Details: autoscalingGroupDetails(cfg.AutoScaling)},
+	)
+	if ecsConfig := cfg.ECS; ecsConfig != nil {
+		resources = append(resources, PlannedResource{
+			Type:    "Capacity provider",
+			Details: fmt.Sprintf("%s, target capacity %d%%", ecsConfig.CapacityProviderName, ecsConfig.TargetCapacity),
+		})
+	} else {
+		resources = append(resources, PlannedResource{Type: "Scaling policy", Details: "target " + cfg.AutoScaling.ScalingMetric()})
+	}
+	if events := cfg.LifecycleEvents; events != nil {
+		if events.CreatesTopic() {
+			resources = append(resources, PlannedResource{Type: "Lifecycle topic", Details: events.TopicName})
+		}
+		resources = append(resources, PlannedResource{Type: "Lifecycle events rule", Details: events.String()})
+		for _, email := range events.Emails {
+			resources = append(resources, PlannedResource{Type: "Email subscription", Details: email + " (to confirm from the email)"})
+		}
+	}
+	if bastion := cfg.Bastion; bastion != nil {
+		resources = append(resources,
+			PlannedResource{Type: "Bastion security group", Details: fmt.Sprintf("%s, SSH from %s, SSH to the instances", bastion.SecurityGroupName, bastion.SSHCIDR)},
+			PlannedResource{Type: "Bastion", Details: fmt.Sprintf("%s, %s (%s) in the first public subnet", bastion.Name, bastion.InstanceType, InstanceArchitecture(bastion.InstanceType))},
+		)
+	}
+	if endpoint := cfg.InstanceConnectEndpoint; endpoint != nil {
+		subnet := InstanceConnectSubnet(SplitSubnets(subnets))
+		resources = append(resources,
+			PlannedResource{Type: "Instance Connect security group", Details: endpoint.SecurityGroupName + ", SSH to the instances only"},
+			PlannedResource{Type: "Instance Connect endpoint", Details: "in subnet " + subnet.CIDRBlock},
+		)
+	}
+	resources = append(resources, PlannedResource{Type: "Load balancer", Details: fmt.Sprintf("%s, %s", cfg.LoadBalancer.Name, cfg.LoadBalancer.Scheme)})
+	if canary := cfg.Canary; canary != nil {
+		resources = append(resources,
+			PlannedResource{Type: "Canary target group", Details: fmt.Sprintf("%s, HTTP:%d, no traffic", canary.TargetGroupName, cfg.TargetGroup.Port)},
+			PlannedResource{Type: "Canary group", Details: fmt.Sprintf("%s, %d instances on the latest launch template", canary.AutoScalingGroupName, canary.Size)},
+		)
+	}
+	if cdConfig := cfg.CodeDeploy; cdConfig != nil {
+		resources = append(resources, PlannedResource{
+			Type:    "CodeDeploy",
+			Details: fmt.Sprintf("application %s, %s deployment group %s with role %s", cdConfig.ApplicationName, cdConfig.DeploymentType, cdConfig.DeploymentGroupName, cdConfig.ServiceRoleName),
+		})
+	}
+	if mtls := cfg.Listener.MutualTLS; mtls != nil {
+		resources = append(resources, PlannedResource{
+			Type:    "Trust store",
+			Details: fmt.Sprintf("%s from s3://%s/%s", mtls.TrustStoreName, mtls.CABundleBucket, mtls.CABundleKey),
+		})
+	}
+	resources = append(resources, PlannedResource{Type: "Listener", Details: describeListener(cfg.Listener)})
+	for _, listenerConfig := range cfg.AdditionalListeners {
+		resources = append(resources, PlannedResource{Type: "Listener", Details: describeListener(listenerConfig)})
+	}
+	for _, hostRule := range cfg.HostRules {
+		hosts := strings.Join(hostRule.HostHeaders, ", ")
+		if len(hostRule.PathPatterns) > 0 {
+			hosts += " " + strings.Join(hostRule.PathPatterns, ", ")
+		}
+		resources = append(resources, PlannedResource{
+			Type:    "Host rule",
+			Details: fmt.Sprintf("%s to %s, priority %d", hosts, hostRule.TargetGroupARN, hostRule.Priority),
+		})
+	}
+	for _, lambdaTarget := range cfg.LambdaTargets {
+		resources = append(resources, PlannedResource{
+			Type:    "Lambda target",
+			Details: fmt.Sprintf("%s for %s, priority %d", lambdaTarget.Name, strings.Join(lambdaTarget.PathPatterns, ", "), lambdaTarget.Priority),
+		})
+	}
+	return resources, nil
+}
+
+// launchTemplateDetails describes the instance type and the AMI, the data
+// volumes, the CPU credits when set and the tenancy when the hardware isn't
+// shared.
+func launchTemplateDetails(ltConfig LaunchTemplateConfig) string {
+	if existing := ltConfig.Existing; existing != nil {
+		return fmt.Sprintf("existing %s at version %s", existing.ID+existing.Name, existing.LaunchTemplateVersion())
+	}
+	details := fmt.Sprintf("%s (%s) on %s", ltConfig.InstanceType, InstanceArchitecture(ltConfig.InstanceType), ltConfig.ImageSource())
+	for _, volume := range ltConfig.DataVolumes {
+		details += ", " + volume.String()
+	}
+	if ltConfig.CPUCredits != "" {
+		details += ", " + ltConfig.CPUCredits + " CPU credits"
+	}
+	if ltConfig.Tenancy != TenancyDefault {
+		details += ", " + ltConfig.Tenancy + " tenancy"
+	}
+	if ltConfig.Hibernation {
+		details += ", hibernation"
+	}
+	return details
+}
+
+// autoscalingGroupDetails describes the size of the group and, with instance
+// requirements, the instance types it picks from.
+func autoscalingGroupDetails(asgConfig AutoScalingConfig) string {
+	details := fmt.Sprintf("min %d, max %d", asgConfig.MinSize, asgConfig.MaxSize)
+	if asgConfig.InstanceRequirements != nil {
+		details += ", any instance type with " + asgConfig.InstanceRequirements.String()
+	}
+	return details
+}
+
+func PrintPlan(w io.Writer, resources []PlannedResource, estimate *CostEstimate) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "Planned resources:")
+	for _, resource := range resources {
+		fmt.Fprintf(tw, "  +\t%s\t%s\n", resource.Type, resource.Details)
+	}
+
+	if estimate != nil {
+		fmt.Fprintf(tw, "\nEstimated monthly cost (USD, on-demand, %d hours):\n", HoursPerMonth)
+		fmt.Fprintln(tw, "  ITEM\tQTY\tHOURLY\tMONTHLY")
+		lines := append([]CostLine{estimate.MinCapacity, estimate.MaxCapacity}, estimate.Shared...)
+		for _, line := range lines {
+			fmt.Fprintf(tw, "  %s\t%g\t%.4f\t%.2f\n", line.Item, line.Quantity, line.HourlyPrice, line.Monthly())
+		}
+		fmt.Fprintf(tw, "\n  Total at min capacity:\t\t\t%.2f\n", estimate.MonthlyAtMin())
+		fmt.Fprintf(tw, "  Total at max capacity:\t\t\t%.2f\n", estimate.MonthlyAtMax())
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/pricing"
+	pricingTypes "github.com/aws/aws-sdk-go-v2/service/pricing/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// The Pricing API is only served from a handful of regions, prices for
+	// every other region are looked up through us-east-1.
+	PricingRegion = "us-east-1"
+	HoursPerMonth = 730
+
+	// ALBBaselineLCUs is the number of load balancer capacity units assumed
+	// for an idle-to-light traffic profile.
+	ALBBaselineLCUs = 1
+)
+
+// CostLine is a single priced item of the estimate.
+type CostLine struct {
+	Item        string
+	Quantity    float64
+	HourlyPrice float64
+}
+
+func (l CostLine) Monthly() float64 {
+	return l.Quantity * l.HourlyPrice * HoursPerMonth
+}
+
+// CostEstimate is the on-demand monthly cost of the planned stack. Instance
+// cost is reported at both ends of the autoscaling range, everything else is
+// shared between the two totals.
+type CostEstimate struct {
+	MinCapacity CostLine
+	MaxCapacity CostLine
+	Shared      []CostLine
+}
+
+func (e *CostEstimate) MonthlyAtMin() float64 {
+	return e.MinCapacity.Monthly() + e.sharedMonthly()
+}
+
+func (e *CostEstimate) MonthlyAtMax() float64 {
+	return e.MaxCapacity.Monthly() + e.sharedMonthly()
+}
+
+func (e *CostEstimate) sharedMonthly() float64 {
+	var total float64
+	for _, line := range e.Shared {
+		total += line.Monthly()
+	}
+	return total
+}
+
+// EstimateCost looks up on-demand prices for the resources in cfg that are
+// billed by the hour.
+func EstimateCost(ctx context.Context, pricingClient *pricing.Client, cfg *Config) (*CostEstimate, error) {
+	// Windows instances are billed with the license included.
+	operatingSystem, licenseModel, instanceItem := "Linux", "No License required", "EC2 "+cfg.LaunchTemplate.InstanceType
+	if cfg.Windows != nil {
+		operatingSystem, licenseModel, instanceItem = "Windows", "License Included", instanceItem+" Windows"
+	}
+	instancePrice, err := lookupHourlyPrice(ctx, pricingClient, "AmazonEC2", map[string]string{
+		"regionCode":      cfg.Region,
+		"instanceType":    cfg.LaunchTemplate.InstanceType,
+		"operatingSystem": operatingSystem,
+		"tenancy":         pricingTenancies[cfg.LaunchTemplate.Tenancy],
+		"preInstalledSw":  "NA",
+		"capacitystatus":  "Used",
+		"licenseModel":    licenseModel,
+	}, "")
+	if err != nil {
+		return nil, fmt.Errorf("error looking up %s price: %w", cfg.LaunchTemplate.InstanceType, err)
+	}
+
+	albFilters := map[string]string{
+		"regionCode":    cfg.Region,
+		"productFamily": "Load Balancer-Application",
+	}
+	albPrice, err := lookupHourlyPrice(ctx, pricingClient, "AWSELB", albFilters, "LoadBalancerUsage")
+	if err != nil {
+		return nil, fmt.Errorf("error looking up load balancer price: %w", err)
+	}
+	lcuPrice, err := lookupHourlyPrice(ctx, pricingClient, "AWSELB", albFilters, "LCUUsage")
+	if err != nil {
+		return nil, fmt.Errorf("error looking up load balancer capacity unit price: %w", err)
+	}
+
+	estimate := &CostEstimate{
+		MinCapacity: CostLine{
+			Item:        instanceItem + " (min capacity)",
+			Quantity:    float64(cfg.AutoScaling.MinSize),
+			HourlyPrice: instancePrice,
+		},
+		MaxCapacity: CostLine{
+			Item:        instanceItem + " (max capacity)",
+			Quantity:    float64(cfg.AutoScaling.MaxSize),
+			HourlyPrice: instancePrice,
+		},
+		Shared: []CostLine{
+			{Item: "Application Load Balancer", Quantity: 1, HourlyPrice: albPrice},
+			{Item: "ALB capacity units (baseline)", Quantity: ALBBaselineLCUs, HourlyPrice: lcuPrice},
+		},
+	}
+
+	if bastion := cfg.Bastion; bastion != nil {
+		bastionPrice, err := lookupHourlyPrice(ctx, pricingClient, "AmazonEC2", map[string]string{
+			"regionCode":      cfg.Region,
+			"instanceType":    bastion.InstanceType,
+			"operatingSystem": "Linux",
+			"tenancy":         pricingTenancies[TenancyDefault],
+			"preInstalledSw":  "NA",
+			"capacitystatus":  "Used",
+			"licenseModel":    "No License required",
+		}, "")
+		if err != nil {
+			return nil, fmt.Errorf("error looking up %s price: %w", bastion.InstanceType, err)
+		}
+		estimate.Shared = append(estimate.Shared, CostLine{Item: fmt.Sprintf("EC2 %s (bastion)", bastion.InstanceType), Quantity: 1, HourlyPrice: bastionPrice})
+	}
+
+	return estimate, nil
+}
+
+type priceListItem struct {
+	Product struct {
+		Attributes map[string]string `json:"attributes"`
+	} `json:"product"`
+	Terms struct {
+		OnDemand map[string]struct {
+			PriceDimensions map[string]struct {
+				Unit         string            `json:"unit"`
+				PricePerUnit map[string]string `json:"pricePerUnit"`
+			} `json:"priceDimensions"`
+		} `json:"OnDemand"`
+	} `json:"terms"`
+}
+
+// lookupHourlyPrice returns the USD on-demand price of the first product
+// matching filters. When usageTypeSuffix is set only products whose usagetype
+// ends with it are considered.
+func lookupHourlyPrice(ctx context.Context, pricingClient *pricing.Client, serviceCode string, filters map[string]string, usageTypeSuffix string) (float64, error) {
+	input := &pricing.GetProductsInput{
+		ServiceCode: aws.String(serviceCode),
+	}
+	for field, value := range filters {
+		input.Filters = append(input.Filters, pricingTypes.Filter{
+			Field: aws.String(field),
+			Type:  pricingTypes.FilterTypeTermMatch,
+			Value: aws.String(value),
+		})
+	}
+
+	paginator := pricing.NewGetProductsPaginator(pricingClient, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, rawItem := range output.PriceList {
+			var item priceListItem
+			if err := json.Unmarshal([]byte(rawItem), &item); err != nil {
+				return 0, fmt.Errorf("error parsing price list: %w", err)
+			}
+			if usageTypeSuffix != "" && !strings.HasSuffix(item.Product.Attributes["usagetype"], usageTypeSuffix) {
+				continue
+			}
+
+			for _, term := range item.Terms.OnDemand {
+				for _, dimension := range term.PriceDimensions {
+					price, err := strconv.ParseFloat(dimension.PricePerUnit["USD"], 64)
+					if err != nil {
+						return 0, fmt.Errorf("error parsing price %q: %w", dimension.PricePerUnit["USD"], err)
+					}
+					if price > 0 {
+						return price, nil
+					}
+				}
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("no %s price found for %v", serviceCode, filters)
+}
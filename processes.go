@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// ScalingProcesses are the autoscaling group processes that can be
+// suspended.
+var ScalingProcesses = []string{
+	"Launch",
+	"Terminate",
+	"AddToLoadBalancer",
+	"AlarmNotification",
+	"AZRebalance",
+	"HealthCheck",
+	"InstanceRefresh",
+	"ReplaceUnhealthy",
+	"ScheduledActions",
+}
+
+// SetProcessesSuspended suspends or resumes the given processes of the
+// group, all of them when processes is empty. Suspended processes stay
+// suspended until resumed, scaling policies are kept.
+func SetProcessesSuspended(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, processes []string, suspended bool) error {
+	for _, process := range processes {
+		if !slices.Contains(ScalingProcesses, process) {
+			return fmt.Errorf("unknown process %q, must be one of %s", process, strings.Join(ScalingProcesses, ", "))
+		}
+	}
+
+	described := "all processes"
+	if len(processes) > 0 {
+		described = strings.Join(processes, ", ")
+	}
+
+	if suspended {
+		if _, err := autoscalingClient.SuspendProcesses(ctx, &autoscaling.SuspendProcessesInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			ScalingProcesses:     processes,
+		}); err != nil {
+			return fmt.Errorf("error suspending processes: %w", err)
+		}
+		logger.Printf("Suspended %s of autoscaling group %s", described, autoscalingGroupName)
+		return nil
+	}
+
+	if _, err := autoscalingClient.ResumeProcesses(ctx, &autoscaling.ResumeProcessesInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		ScalingProcesses:     processes,
+	}); err != nil {
+		return fmt.Errorf("error resuming processes: %w", err)
+	}
+	logger.Printf("Resumed %s of autoscaling group %s", described, autoscalingGroupName)
+	return nil
+}
+
+func runSuspendProcesses(ctx context.Context, logger *log.Logger, args []string) error {
+	return runProcesses(ctx, logger, "suspend-processes", args, true)
+}
+
+func runResumeProcesses(ctx context.Context, logger *log.Logger, args []string) error {
+	return runProcesses(ctx, logger, "resume-processes", args, false)
+}
+
+func runProcesses(ctx context.Context, logger *log.Logger, name string, args []string, suspended bool) error {
+	var processes []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		processes = append(processes, args[0])
+		args = args[1:]
+	}
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	return SetProcessesSuspended(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, processes, suspended)
+}
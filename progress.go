@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	ProgressRefreshInterval = 100 * time.Millisecond
+)
+
+// ApplySteps lists the steps of Apply in the order they are shown by the
+// progress view.
+var ApplySteps = []string{
+	"VPC",
+	"Flow logs",
+	"Log groups",
+	"Internet gateway",
+	"Route table",
+	"Subnets",
+	"Network ACL",
+	"VPC endpoints",
+	"Elastic IPs",
+	"Security group",
+	"ECS cluster",
+	"Secrets",
+	"Instance role",
+	"Placement group",
+	"Volume key",
+	"Launch template",
+	"Target group",
+	"Canary target group",
+	"Autoscaling group",
+	"Scaling policy",
+	"Capacity provider",
+	"Canary group",
+	"CodeDeploy",
+	"Load balancer",
+	"Trust store",
+	"Listener",
+	"Additional listeners",
+	"Host rules",
+	"Lambda targets",
+	"Lifecycle events",
+	"Bastion",
+	"Instance Connect",
+	"Smoke test",
+}
+
+// optionalSteps are the steps of Apply that only run when their check
+// passes for the config.
+var optionalSteps = map[string]func(cfg *Config) bool{
+	"Internet gateway":     func(cfg *Config) bool { return !cfg.VPC.Existing() },
+	"Route table":          func(cfg *Config) bool { return !cfg.VPC.Existing() },
+	"Flow logs":            func(cfg *Config) bool { return cfg.VPC.FlowLogs.Enabled },
+	"Log groups":           func(cfg *Config) bool { return cfg.Logs != nil },
+	"Network ACL":          func(cfg *Config) bool { return cfg.VPC.NetworkACL.Enabled },
+	"VPC endpoints":        func(cfg *Config) bool { return len(cfg.VPC.Endpoints) > 0 },
+	"Elastic IPs":          func(cfg *Config) bool { return len(cfg.ElasticIPs) > 0 },
+	"ECS cluster":          func(cfg *Config) bool { return cfg.ECS != nil },
+	"Secrets":              func(cfg *Config) bool { return len(cfg.Secrets) > 0 },
+	"Instance role":        (*Config).CreatesInstanceRole,
+	"Placement group":      func(cfg *Config) bool { return cfg.PlacementGroup != nil },
+	"Volume key":           func(cfg *Config) bool { return cfg.VolumeEncryption != nil },
+	"Canary target group":  func(cfg *Config) bool { return cfg.Canary != nil },
+	"Scaling policy":       func(cfg *Config) bool { return cfg.ECS == nil },
+	"Capacity provider":    func(cfg *Config) bool { return cfg.ECS != nil },
+	"CodeDeploy":           func(cfg *Config) bool { return cfg.CodeDeploy != nil },
+	"Canary group":         func(cfg *Config) bool { return cfg.Canary != nil },
+	"Trust store":          func(cfg *Config) bool { return cfg.Listener.MutualTLS != nil },
+	"Additional listeners": func(cfg *Config) bool { return len(cfg.AdditionalListeners) > 0 },
+	"Host rules":           func(cfg *Config) bool { return len(cfg.HostRules) > 0 },
+	"Lambda targets":       func(cfg *Config) bool { return len(cfg.LambdaTargets) > 0 },
+	"Lifecycle events":     func(cfg *Config) bool { return cfg.LifecycleEvents != nil },
+	"Bastion":              func(cfg *Config) bool { return cfg.Bastion != nil },
+	"Instance Connect":     func(cfg *Config) bool { return cfg.InstanceConnectEndpoint != nil },
+	"Smoke test":           (*Config).RunsSmokeTest,
+}
+
+// stepDependencies are the steps each built-in step of Apply runs after.
+// Destroy deletes the resources of the steps in the reverse order. The smoke
+// test runs after all the other steps.
+var stepDependencies = map[string][]string{
+	"VPC":              nil,
+	"Flow logs":        []string{"VPC"},
+	"Log groups":       []string{"VPC"},
+	"Internet gateway": []string{"VPC"},
+	"Route table":      []string{"Internet gateway"},
+	"Subnets":          []string{"VPC", "Route table"},
+	"Network ACL":      []string{"Subnets"},
+	"VPC endpoints":    []string{"Subnets"},
+	"Elastic IPs":      []string{"VPC"},
+	"Security group":   []string{"VPC"},
+	"ECS cluster":      []string{"VPC"},
+	"Secrets":          []string{"VPC"},
+	// The role may read the secrets and write to the log groups.
+	"Instance role":       []string{"Secrets", "Log groups"},
+	"Placement group":     []string{"VPC"},
+	"Volume key":          []string{"VPC"},
+	"Launch template":     []string{"Security group", "ECS cluster", "Secrets", "Instance role", "Placement group", "Volume key"},
+	"Target group":        []string{"VPC"},
+	"Canary target group": []string{"VPC"},
+	// Instances only launch once the network they boot into is complete:
+	// the endpoints and ACL of their subnets, their log groups and the
+	// addresses they claim.
+	"Autoscaling group":    []string{"Subnets", "Network ACL", "VPC endpoints", "Elastic IPs", "Log groups", "Launch template", "Target group"},
+	"Scaling policy":       []string{"Autoscaling group"},
+	"Capacity provider":    []string{"Autoscaling group", "ECS cluster"},
+	"Canary group":         []string{"Autoscaling group", "Canary target group"},
+	"CodeDeploy":           []string{"Autoscaling group", "Target group"},
+	"Load balancer":        []string{"Subnets", "Security group"},
+	"Trust store":          []string{"VPC"},
+	"Listener":             []string{"Load balancer", "Trust store", "Target group", "Canary target group"},
+	"Additional listeners": []string{"Load balancer", "Target group", "Canary target group"},
+	"Host rules":           []string{"Listener"},
+	"Lambda targets":       []string{"Listener"},
+	"Lifecycle events":     []string{"VPC"},
+	// The bastion associates the Elastic IPs meant for it, which the other
+	// step allocates.
+	"Bastion":          []string{"Subnets", "Security group", "Elastic IPs"},
+	"Instance Connect": []string{"Subnets", "Security group"},
+}
+
+// ConfiguredSteps returns the steps an apply of cfg runs, built-in and
+// custom.
+func ConfiguredSteps(cfg *Config) []string {
+	return slices.DeleteFunc(StepNames(), func(step string) bool {
+		enabled, optional := optionalSteps[step]
+		return optional && !enabled(cfg)
+	})
+}
+
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Progress reports the provisioning steps. Track runs fn as the given step;
+// fn returns the identifier of the resource it created.
+type Progress interface {
+	Track(step string, fn func() (string, error)) error
+}
+
+// PlainProgress only runs the steps, the steps themselves log what they
+// create.
+type PlainProgress struct{}
+
+func (PlainProgress) Track(step string, fn func() (string, error)) error {
+	_, err := fn()
+	return err
+}
+
+type stepStatus int
+
+const (
+	stepPending stepStatus = iota
+	stepRunning
+	stepDone
+	stepFailed
+)
+
+type progressStep struct {
+	name     string
+	status   stepStatus
+	resource string
+	started  time.Time
+	finished time.Time
+}
+
+// TerminalProgress redraws one line per step in place, with a spinner for
+// running steps, the created resource identifiers and the time each step
+// took. It must only be used when w is a terminal.
+type TerminalProgress struct {
+	w     io.Writer
+	steps []*progressStep
+	frame int
+	lines int
+
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewTerminalProgress(w io.Writer, steps []string) *TerminalProgress {
+	p := &TerminalProgress{w: w}
+	for _, name := range steps {
+		p.steps = append(p.steps, &progressStep{name: name})
+	}
+	return p
+}
+
+// Start draws the steps and keeps redrawing them until Stop is called.
+func (p *TerminalProgress) Start() {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+
+		ticker := time.NewTicker(ProgressRefreshInterval)
+		defer ticker.Stop()
+		for {
+			p.render()
+			select {
+			case <-ticker.C:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the final state of the steps and stops redrawing.
+func (p *TerminalProgress) Stop() {
+	close(p.stop)
+	<-p.done
+	p.render()
+}
+
+func (p *TerminalProgress) Track(step string, fn func() (string, error)) error {
+	s := p.step(step)
+	p.mu.Lock()
+	s.status = stepRunning
+	s.started = time.Now()
+	p.mu.Unlock()
+
+	resource, err := fn()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s.finished = time.Now()
+	s.resource = resource
+	if err != nil {
+		s.status = stepFailed
+		return err
+	}
+	s.status = stepDone
+	return nil
+}
+
+func (p *TerminalProgress) step(name string) *progressStep {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.steps {
+		if s.name == name {
+			return s
+		}
+	}
+	s := &progressStep{name: name}
+	p.steps = append(p.steps, s)
+	return s
+}
+
+func (p *TerminalProgress) render() {
+	lines, _, _ := p.stepLines(true)
+	if p.lines > 0 {
+		fmt.Fprintf(p.w, "\x1b[%dA", p.lines)
+	}
+	for _, line := range lines {
+		fmt.Fprintf(p.w, "\x1b[2K%s\n", line)
+	}
+	p.lines = len(lines)
+}
+
+// stepLines advances the spinner and renders one line per step, leaving out
+// the pending steps unless pending is set. It also counts the steps done
+// and all of them.
+func (p *TerminalProgress) stepLines(pending bool) ([]string, int, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.frame = (p.frame + 1) % len(spinnerFrames)
+
+	var lines []string
+	done := 0
+	now := time.Now()
+	for _, s := range p.steps {
+		var icon string
+		var elapsed time.Duration
+		switch s.status {
+		case stepPending:
+			if !pending {
+				continue
+			}
+			icon = "·"
+		case stepRunning:
+			icon = spinnerFrames[p.frame]
+			elapsed = now.Sub(s.started)
+		case stepDone:
+			icon = "✓"
+			elapsed = s.finished.Sub(s.started)
+			done++
+		case stepFailed:
+			icon = "✗"
+			elapsed = s.finished.Sub(s.started)
+		}
+
+		line := fmt.Sprintf("%s %-18s", icon, s.name)
+		if s.status != stepPending {
+			line += fmt.Sprintf(" %6s", elapsed.Truncate(100*time.Millisecond))
+		}
+		if s.resource != "" {
+			line += "  " + s.resource
+		}
+		lines = append(lines, line)
+	}
+	return lines, done, len(p.steps)
+}
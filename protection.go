@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// SetInstanceProtection protects the instances of the group from being
+// terminated on scale-in, or lifts the protection. Protected instances are
+// still replaced when they fail health checks.
+func SetInstanceProtection(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, instanceIDs []string, protected bool) error {
+	if _, err := autoscalingClient.SetInstanceProtection(ctx, &autoscaling.SetInstanceProtectionInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		InstanceIds:          instanceIDs,
+		ProtectedFromScaleIn: aws.Bool(protected),
+	}); err != nil {
+		return fmt.Errorf("error setting instance protection: %w", err)
+	}
+
+	if protected {
+		logger.Printf("Instances protected from scale-in: %s", strings.Join(instanceIDs, ", "))
+	} else {
+		logger.Printf("Scale-in protection removed from instances: %s", strings.Join(instanceIDs, ", "))
+	}
+	return nil
+}
+
+func runProtect(ctx context.Context, logger *log.Logger, args []string) error {
+	return runInstanceProtection(ctx, logger, "protect", args, true)
+}
+
+func runUnprotect(ctx context.Context, logger *log.Logger, args []string) error {
+	return runInstanceProtection(ctx, logger, "unprotect", args, false)
+}
+
+func runInstanceProtection(ctx context.Context, logger *log.Logger, name string, args []string, protected bool) error {
+	instanceIDs, args := splitInstanceIDs(args)
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(instanceIDs) == 0 {
+		return errors.New(name + " requires at least one instance ID")
+	}
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	return SetInstanceProtection(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, instanceIDs, protected)
+}
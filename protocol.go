@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	ProtocolVersionHTTP1 = "HTTP1"
+	ProtocolVersionHTTP2 = "HTTP2"
+	ProtocolVersionGRPC  = "GRPC"
+
+	DefaultHealthCheckPath = "/"
+	DefaultHTTPMatcher     = "200"
+	// DefaultGRPCHealthCheckPath is the health check method the load
+	// balancer calls on gRPC targets when none is configured.
+	DefaultGRPCHealthCheckPath = "/AWS.ALB/healthcheck"
+	// DefaultGRPCMatcher expects UNIMPLEMENTED, which a gRPC server answers
+	// for the default health check method it doesn't serve.
+	DefaultGRPCMatcher = "12"
+	MaxGRPCCode        = 99
+)
+
+// ProtocolVersions are the protocol versions the load balancer can send
+// requests to the targets with.
+var ProtocolVersions = []string{ProtocolVersionHTTP1, ProtocolVersionHTTP2, ProtocolVersionGRPC}
+
+// UsesGRPC reports whether the targets serve gRPC, in which case the health
+// check path is a gRPC method and the matcher lists gRPC status codes.
+func (t TargetGroupConfig) UsesGRPC() bool {
+	return t.ProtocolVersion == ProtocolVersionGRPC
+}
+
+// HealthCheckMatcher returns the codes a healthy target answers the health
+// check with, HTTP codes or gRPC codes depending on the protocol version.
+func (t TargetGroupConfig) HealthCheckMatcher() *elbTypes.Matcher {
+	if t.UsesGRPC() {
+		return &elbTypes.Matcher{GrpcCode: aws.String(t.HealthCheck.Matcher)}
+	}
+	return &elbTypes.Matcher{HttpCode: aws.String(t.HealthCheck.Matcher)}
+}
+
+// matcherCodes returns the codes of an existing target group's matcher.
+func matcherCodes(matcher *elbTypes.Matcher) string {
+	if matcher == nil {
+		return ""
+	}
+	if matcher.GrpcCode != nil {
+		return aws.StringValue(matcher.GrpcCode)
+	}
+	return aws.StringValue(matcher.HttpCode)
+}
+
+// validGRPCMatcher reports whether matcher lists gRPC codes, like 12, 0,12 or
+// 0-99.
+func validGRPCMatcher(matcher string) bool {
+	for _, codes := range strings.Split(matcher, ",") {
+		for _, code := range strings.SplitN(codes, "-", 2) {
+			n, err := strconv.Atoi(code)
+			if err != nil || n < 0 || n > MaxGRPCCode {
+				return false
+			}
+		}
+	}
+	return true
+}
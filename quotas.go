@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2Types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/servicequotas"
+	servicequotasTypes "github.com/aws/aws-sdk-go-v2/service/servicequotas/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Quota identifies a Service Quotas limit.
+type Quota struct {
+	ServiceCode string
+	QuotaCode   string
+	Name        string
+}
+
+var (
+	QuotaVPCsPerRegion = Quota{ServiceCode: "vpc", QuotaCode: "L-F678F1CE", Name: "VPCs per Region"}
+	QuotaElasticIPs    = Quota{ServiceCode: "ec2", QuotaCode: "L-0263D0A3", Name: "EC2-VPC Elastic IPs"}
+
+	QuotaStandardVCPUs            = Quota{ServiceCode: "ec2", QuotaCode: "L-1216C47A", Name: "Running On-Demand Standard instances (vCPUs)"}
+	QuotaApplicationLoadBalancers = Quota{ServiceCode: "elasticloadbalancing", QuotaCode: "L-53DA6B97", Name: "Application Load Balancers per Region"}
+)
+
+// QuotaValue returns the quota applied to the account, or the AWS default
+// when the account never had it adjusted.
+func QuotaValue(ctx context.Context, quotasClient *servicequotas.Client, quota Quota) (float64, error) {
+	output, err := quotasClient.GetServiceQuota(ctx, &servicequotas.GetServiceQuotaInput{
+		ServiceCode: aws.String(quota.ServiceCode),
+		QuotaCode:   aws.String(quota.QuotaCode),
+	})
+	var notFound *servicequotasTypes.NoSuchResourceException
+	if errors.As(err, &notFound) {
+		defaultOutput, err := quotasClient.GetAWSDefaultServiceQuota(ctx, &servicequotas.GetAWSDefaultServiceQuotaInput{
+			ServiceCode: aws.String(quota.ServiceCode),
+			QuotaCode:   aws.String(quota.QuotaCode),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error getting default quota %q: %w", quota.Name, err)
+		}
+		return aws.Float64Value(defaultOutput.Quota.Value), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error getting quota %q: %w", quota.Name, err)
+	}
+
+	return aws.Float64Value(output.Quota.Value), nil
+}
+
+// QuotaUsage compares a quota with what is in use and what apply would add.
+type QuotaUsage struct {
+	Quota  Quota
+	Limit  float64
+	Used   float64
+	Needed float64
+}
+
+func (u QuotaUsage) Exceeded() bool {
+	return u.Used+u.Needed > u.Limit
+}
+
+func (u QuotaUsage) String() string {
+	return fmt.Sprintf("%s: %.0f of %.0f used, the stack needs up to %.0f more", u.Quota.Name, u.Used, u.Limit, u.Needed)
+}
+
+// VCPUQuotaUsage checks the on-demand standard instance vCPU quota against
+// the running instances plus the autoscaling group at max capacity.
+func VCPUQuotaUsage(ctx context.Context, clients *Clients, cfg *Config) (QuotaUsage, error) {
+	usage := QuotaUsage{Quota: QuotaStandardVCPUs}
+
+	limit, err := QuotaValue(ctx, clients.Quotas, usage.Quota)
+	if err != nil {
+		return usage, err
+	}
+	usage.Limit = limit
+
+	instanceCounts := map[string]int32{}
+	paginator := ec2.NewDescribeInstancesPaginator(clients.EC2, &ec2.DescribeInstancesInput{
+		Filters: []ec2Types.Filter{
+			{Name: aws.String("instance-state-name"), Values: []string{"pending", "running"}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return usage, fmt.Errorf("error describing instances: %w", err)
+		}
+		for _, reservation := range page.Reservations {
+			for _, instance := range reservation.Instances {
+				// Spot instances have their own quota.
+				if instance.InstanceLifecycle == ec2Types.InstanceLifecycleTypeSpot {
+					continue
+				}
+				if isStandardInstanceType(string(instance.InstanceType)) {
+					instanceCounts[string(instance.InstanceType)]++
+				}
+			}
+		}
+	}
+
+	instanceTypes := []ec2Types.InstanceType{ec2Types.InstanceType(cfg.LaunchTemplate.InstanceType)}
+	for instanceType := range instanceCounts {
+		if instanceType != cfg.LaunchTemplate.InstanceType {
+			instanceTypes = append(instanceTypes, ec2Types.InstanceType(instanceType))
+		}
+	}
+	vcpus := map[string]int32{}
+	typesPaginator := ec2.NewDescribeInstanceTypesPaginator(clients.EC2, &ec2.DescribeInstanceTypesInput{
+		InstanceTypes: instanceTypes,
+	})
+	for typesPaginator.HasMorePages() {
+		page, err := typesPaginator.NextPage(ctx)
+		if err != nil {
+			return usage, fmt.Errorf("error describing instance types: %w", err)
+		}
+		for _, info := range page.InstanceTypes {
+			if info.VCpuInfo != nil {
+				vcpus[string(info.InstanceType)] = aws.Int32Value(info.VCpuInfo.DefaultVCpus)
+			}
+		}
+	}
+
+	for instanceType, count := range instanceCounts {
+		usage.Used += float64(count * vcpus[instanceType])
+	}
+	usage.Needed = float64(cfg.AutoScaling.MaxSize * vcpus[cfg.LaunchTemplate.InstanceType])
+
+	return usage, nil
+}
+
+// isStandardInstanceType reports whether instances of the type count
+// against the standard (A, C, D, H, I, M, R, T, Z) on-demand quota.
+func isStandardInstanceType(instanceType string) bool {
+	family := strings.SplitN(instanceType, ".", 2)[0]
+	for _, special := range []string{"inf", "dl", "trn"} {
+		if strings.HasPrefix(family, special) {
+			return false
+		}
+	}
+	return family != "" && strings.ContainsRune("acdhimrtz", rune(family[0]))
+}
+
+// LoadBalancerQuotaUsage checks the application load balancers per region
+// quota.
+func LoadBalancerQuotaUsage(ctx context.Context, clients *Clients) (QuotaUsage, error) {
+	usage := QuotaUsage{Quota: QuotaApplicationLoadBalancers, Needed: 1}
+
+	limit, err := QuotaValue(ctx, clients.Quotas, usage.Quota)
+	if err != nil {
+		return usage, err
+	}
+	usage.Limit = limit
+
+	paginator := elasticloadbalancingv2.NewDescribeLoadBalancersPaginator(clients.ELB, &elasticloadbalancingv2.DescribeLoadBalancersInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return usage, fmt.Errorf("error describing load balancers: %w", err)
+		}
+		for _, lb := range page.LoadBalancers {
+			if lb.Type == elbTypes.LoadBalancerTypeEnumApplication {
+				usage.Used++
+			}
+		}
+	}
+
+	return usage, nil
+}
+
+// CheckCapacityQuotas fails when the stack at max capacity would exceed the
+// vCPU or load balancer quotas. Quotas that can't be looked up (e.g. missing
+// permissions or LocalStack) are only logged.
+func CheckCapacityQuotas(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config) error {
+	vcpuUsage, vcpuErr := VCPUQuotaUsage(ctx, clients, cfg)
+	lbUsage, lbErr := LoadBalancerQuotaUsage(ctx, clients)
+
+	var exceeded []string
+	for _, check := range []struct {
+		usage QuotaUsage
+		err   error
+	}{{vcpuUsage, vcpuErr}, {lbUsage, lbErr}} {
+		switch {
+		case check.err != nil:
+			logger.Printf("Skipping %s quota check: %v", check.usage.Quota.Name, check.err)
+		case check.usage.Exceeded():
+			exceeded = append(exceeded, check.usage.String())
+		}
+	}
+
+	if len(exceeded) > 0 {
+		return fmt.Errorf("service quotas would be exceeded (request an increase or lower autoScaling.maxSize):\n  - %s", strings.Join(exceeded, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	RefreshPollInterval = 15 * time.Second
+	RefreshTimeout      = 60 * time.Minute
+)
+
+type RefreshOptions struct {
+	MinHealthyPercentage int32
+	InstanceWarmup       time.Duration
+	// MaxUnhealthyPolls is the number of consecutive polls with unhealthy
+	// targets after which the refresh is considered failed.
+	MaxUnhealthyPolls int
+	// RollbackOnFailure rolls the group back to its previous launch template
+	// version instead of only cancelling the refresh. AWS only supports this
+	// when the group is pinned to a launch template version.
+	RollbackOnFailure bool
+}
+
+// StartInstanceRefresh starts a rolling replacement of the instances of the
+// group and returns the refresh ID.
+func StartInstanceRefresh(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, opts RefreshOptions) (string, error) {
+	output, err := autoscalingClient.StartInstanceRefresh(ctx, &autoscaling.StartInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		Strategy:             autoscalingTypes.RefreshStrategyRolling,
+		Preferences: &autoscalingTypes.RefreshPreferences{
+			MinHealthyPercentage: aws.Int32(opts.MinHealthyPercentage),
+			InstanceWarmup:       aws.Int32(int32(opts.InstanceWarmup.Seconds())),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error starting instance refresh: %w", err)
+	}
+	refreshID := aws.StringValue(output.InstanceRefreshId)
+	logger.Printf("Instance refresh %s started for autoscaling group %s", refreshID, autoscalingGroupName)
+
+	return refreshID, nil
+}
+
+// WaitForInstanceRefresh polls the refresh until it finishes. While it runs
+// the target group health is watched, and when targets stay unhealthy for
+// too long the refresh is cancelled or rolled back.
+func WaitForInstanceRefresh(ctx context.Context, logger *log.Logger, clients *Clients, state *State, refreshID string, opts RefreshOptions) error {
+	ticker := time.NewTicker(RefreshPollInterval)
+	defer ticker.Stop()
+
+	unhealthyPolls := 0
+	for {
+		refresh, err := describeInstanceRefresh(ctx, clients.AutoScaling, state.AutoScalingGroupName, refreshID)
+		if err != nil {
+			return err
+		}
+		logger.Printf("Instance refresh %s: %s, %d%% complete, %d instances to update",
+			refreshID, refresh.Status, aws.Int32Value(refresh.PercentageComplete), aws.Int32Value(refresh.InstancesToUpdate))
+
+		switch refresh.Status {
+		case autoscalingTypes.InstanceRefreshStatusSuccessful:
+			logger.Printf("Instance refresh %s completed successfully", refreshID)
+			return nil
+		case autoscalingTypes.InstanceRefreshStatusFailed,
+			autoscalingTypes.InstanceRefreshStatusCancelled,
+			autoscalingTypes.InstanceRefreshStatusRollbackFailed,
+			autoscalingTypes.InstanceRefreshStatusRollbackSuccessful:
+			return fmt.Errorf("instance refresh %s ended with status %s: %s", refreshID, refresh.Status, aws.StringValue(refresh.StatusReason))
+		}
+
+		if refresh.Status == autoscalingTypes.InstanceRefreshStatusInProgress {
+			unhealthy, err := countUnhealthyTargets(ctx, clients.ELB, state.TargetGroupARN)
+			if err != nil {
+				return err
+			}
+			if unhealthy > 0 {
+				unhealthyPolls++
+				logger.Printf("%d unhealthy targets (%d/%d polls)", unhealthy, unhealthyPolls, opts.MaxUnhealthyPolls)
+			} else {
+				unhealthyPolls = 0
+			}
+
+			if opts.MaxUnhealthyPolls > 0 && unhealthyPolls >= opts.MaxUnhealthyPolls {
+				if err := AbortInstanceRefresh(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, opts.RollbackOnFailure); err != nil {
+					return err
+				}
+				return fmt.Errorf("instance refresh %s aborted, targets stayed unhealthy", refreshID)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// AbortInstanceRefresh cancels the running refresh, or rolls it back to the
+// previous configuration when rollback is set.
+func AbortInstanceRefresh(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, rollback bool) error {
+	if rollback {
+		output, err := autoscalingClient.RollbackInstanceRefresh(ctx, &autoscaling.RollbackInstanceRefreshInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+		})
+		if err != nil {
+			return fmt.Errorf("error rolling back instance refresh: %w", err)
+		}
+		logger.Printf("Instance refresh %s is rolling back", aws.StringValue(output.InstanceRefreshId))
+		return nil
+	}
+
+	output, err := autoscalingClient.CancelInstanceRefresh(ctx, &autoscaling.CancelInstanceRefreshInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+	})
+	if err != nil {
+		return fmt.Errorf("error cancelling instance refresh: %w", err)
+	}
+	logger.Printf("Instance refresh %s cancelled", aws.StringValue(output.InstanceRefreshId))
+	return nil
+}
+
+func describeInstanceRefresh(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName, refreshID string) (*autoscalingTypes.InstanceRefresh, error) {
+	output, err := autoscalingClient.DescribeInstanceRefreshes(ctx, &autoscaling.DescribeInstanceRefreshesInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		InstanceRefreshIds:   []string{refreshID},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing instance refresh: %w", err)
+	}
+	if len(output.InstanceRefreshes) == 0 {
+		return nil, fmt.Errorf("instance refresh %s not found", refreshID)
+	}
+
+	return &output.InstanceRefreshes[0], nil
+}
+
+func countUnhealthyTargets(ctx context.Context, elbClient *elasticloadbalancingv2.Client, targetGroupARN string) (int, error) {
+	output, err := elbClient.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error describing target health: %w", err)
+	}
+
+	unhealthy := 0
+	for _, description := range output.TargetHealthDescriptions {
+		if description.TargetHealth != nil && description.TargetHealth.State == elbTypes.TargetHealthStateEnumUnhealthy {
+			unhealthy++
+		}
+	}
+	return unhealthy, nil
+}
+
+func runRefresh(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	var refreshOpts RefreshOptions
+	var minHealthy int
+	var cancel, rollback, noWait bool
+	fs := flag.NewFlagSet("refresh", flag.ExitOnError)
+	opts.Register(fs)
+	fs.IntVar(&minHealthy, "min-healthy", 90, "percentage of capacity that must stay healthy during the refresh")
+	fs.DurationVar(&refreshOpts.InstanceWarmup, "warmup", 5*time.Minute, "time a new instance needs before it counts as healthy")
+	fs.IntVar(&refreshOpts.MaxUnhealthyPolls, "max-unhealthy-polls", 8, "abort after this many consecutive polls with unhealthy targets (0 disables)")
+	fs.BoolVar(&refreshOpts.RollbackOnFailure, "rollback-on-failure", false, "roll back instead of cancelling when targets stay unhealthy")
+	fs.BoolVar(&cancel, "cancel", false, "cancel the running instance refresh")
+	fs.BoolVar(&rollback, "rollback", false, "roll back the running instance refresh")
+	fs.BoolVar(&noWait, "no-wait", false, "start the refresh without tracking its progress")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if minHealthy < 0 || minHealthy > 100 {
+		return fmt.Errorf("--min-healthy must be between 0 and 100, got %d", minHealthy)
+	}
+	if cancel && rollback {
+		return errors.New("--cancel and --rollback are mutually exclusive")
+	}
+	refreshOpts.MinHealthyPercentage = int32(minHealthy)
+
+	ctx, cancelFunc := context.WithTimeout(ctx, RefreshTimeout)
+	defer cancelFunc()
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	if cancel || rollback {
+		return AbortInstanceRefresh(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, rollback)
+	}
+
+	refreshID, err := StartInstanceRefresh(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, refreshOpts)
+	if err != nil {
+		return err
+	}
+	if noWait {
+		return nil
+	}
+
+	return WaitForInstanceRefresh(ctx, logger, clients, state, refreshID, refreshOpts)
+}
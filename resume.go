@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// partialSteps tell whether a step recorded resources in state before it
+// failed. Running such a step again would create them a second time, so a
+// resumed apply refuses to. Steps left out either record their resource
+// only once they're done or reuse what is recorded.
+var partialSteps = map[string]func(s *State) bool{
+	"VPC":                  func(s *State) bool { return s.VPCID != "" },
+	"Route table":          func(s *State) bool { return s.RouteTableID != "" },
+	"Subnets":              func(s *State) bool { return len(s.SubnetIDs) > 0 || len(s.PrivateSubnetIDs) > 0 },
+	"Network ACL":          func(s *State) bool { return s.NetworkACLID != "" },
+	"VPC endpoints":        func(s *State) bool { return s.EndpointSecurityGroupID != "" || len(s.VPCEndpointIDs) > 0 },
+	"Flow logs":            func(s *State) bool { return s.FlowLogGroupName != "" || s.FlowLogsRoleName != "" },
+	"Instance role":        func(s *State) bool { return s.InstanceRoleName != "" },
+	"Volume key":           func(s *State) bool { return s.VolumeKeyARN != "" },
+	"Capacity provider":    func(s *State) bool { return s.CapacityProviderName != "" },
+	"CodeDeploy":           func(s *State) bool { return s.CodeDeployRoleName != "" || s.CodeDeployApplicationName != "" },
+	"Trust store":          func(s *State) bool { return s.TrustStoreARN != "" },
+	"Additional listeners": func(s *State) bool { return len(s.AdditionalListenerARNs) > 0 },
+	"Host rules":           func(s *State) bool { return len(s.HostRuleARNs) > 0 },
+	"Lambda targets":       func(s *State) bool { return len(s.LambdaTargets) > 0 },
+	"Instance Connect":     func(s *State) bool { return s.InstanceConnectEndpointID != "" },
+	"Bastion":              func(s *State) bool { return s.BastionInstanceID != "" },
+}
+
+// checkPartialSteps fails when a step of graph left resources in state
+// before it failed.
+func checkPartialSteps(graph *StepGraph, state *State) error {
+	var partial []string
+	for _, step := range graph.Names() {
+		if recorded, ok := partialSteps[step]; ok && recorded(state) {
+			partial = append(partial, step)
+		}
+	}
+	if len(partial) > 0 {
+		return fmt.Errorf("steps %s failed after creating resources recorded in %s, run destroy and apply again", strings.Join(partial, ", "), state.Location())
+	}
+	return nil
+}
+
+// PendingSteps returns the steps an apply of cfg runs that the unfinished
+// first apply recorded in state hasn't completed yet.
+func PendingSteps(cfg *Config, state *State) []string {
+	return slices.DeleteFunc(ConfiguredSteps(cfg), func(step string) bool {
+		return slices.Contains(state.CompletedSteps, step)
+	})
+}
+
+// Resume finishes a first apply that failed or was interrupted. The steps
+// recorded as completed are skipped, the others run as they would have,
+// e.g. only the listener after ELB rejected it.
+func Resume(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, progress Progress) error {
+	if state.IsEmpty() {
+		return fmt.Errorf("no stack recorded in %s, run apply first", state.Location())
+	}
+	if len(state.CompletedSteps) == 0 {
+		return fmt.Errorf("no unfinished apply recorded in %s, nothing to resume", state.Location())
+	}
+
+	graph, err := ApplyGraph(ctx, logger, clients, cfg, state)
+	if err != nil {
+		return err
+	}
+	graph.Skip(state.CompletedSteps)
+	if err := checkPartialSteps(graph, state); err != nil {
+		return err
+	}
+
+	logger.Printf("Resuming apply after %d completed steps, %d steps left: %s", len(state.CompletedSteps), len(graph.Names()), strings.Join(graph.Names(), ", "))
+	return runApplyGraph(ctx, logger, graph, state, progress)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+const (
+	// The defaults of every class of steps, those of the standard retryer
+	// of the AWS SDK for the calls.
+	DefaultRetryMaxAttempts       = retry.DefaultMaxAttempts
+	DefaultRetryMaxBackoffSeconds = 20
+	DefaultStepAttempts           = 3
+	DefaultStepBackoffSeconds     = 5
+)
+
+// The step classes of RetriesConfig. Networking covers the steps calling the
+// EC2 API, load balancing those calling ELB and autoscaling those calling
+// Auto Scaling. Every other step, custom ones included, uses Default.
+var (
+	networkingSteps = []string{
+		"VPC", "Internet gateway", "Route table", "Subnets", "Network ACL", "VPC endpoints",
+		"Elastic IPs", "Flow logs", "Security group", "Placement group", "Launch template",
+		"Instance Connect", "Bastion",
+	}
+	loadBalancingSteps = []string{
+		"Target group", "Canary target group", "Load balancer", "Trust store", "Listener",
+		"Additional listeners", "Host rules", "Lambda targets",
+	}
+	autoScalingSteps = []string{
+		"Autoscaling group", "Scaling policy", "Canary group", "Capacity provider",
+	}
+)
+
+// RetriesConfig sets how hard the calls and the steps of each class are
+// retried, as the APIs behind them throttle differently: ELB allows far
+// fewer calls per second than EC2.
+type RetriesConfig struct {
+	Networking    RetryConfig `json:"networking"`
+	LoadBalancing RetryConfig `json:"loadBalancing"`
+	AutoScaling   RetryConfig `json:"autoScaling"`
+	Default       RetryConfig `json:"default"`
+}
+
+// RetryConfig is the retry policy of a class of steps.
+type RetryConfig struct {
+	// MaxAttempts is how many times the AWS SDK sends a call failing with a
+	// retryable error, e.g. throttling, before giving up.
+	MaxAttempts int `json:"maxAttempts"`
+	// MaxBackoffSeconds caps the jittered exponential backoff of the SDK
+	// between two attempts.
+	MaxBackoffSeconds int `json:"maxBackoffSeconds"`
+	// StepAttempts is how many times apply runs a step that is still
+	// throttled once the SDK gave up.
+	StepAttempts int `json:"stepAttempts"`
+	// StepBackoffSeconds is the wait before the second run of a step,
+	// doubled for every further one.
+	StepBackoffSeconds int `json:"stepBackoffSeconds"`
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts:        DefaultRetryMaxAttempts,
+		MaxBackoffSeconds:  DefaultRetryMaxBackoffSeconds,
+		StepAttempts:       DefaultStepAttempts,
+		StepBackoffSeconds: DefaultStepBackoffSeconds,
+	}
+}
+
+// ForStep returns the policy of the class of step.
+func (c RetriesConfig) ForStep(step string) RetryConfig {
+	switch {
+	case slices.Contains(networkingSteps, step):
+		return c.Networking
+	case slices.Contains(loadBalancingSteps, step):
+		return c.LoadBalancing
+	case slices.Contains(autoScalingSteps, step):
+		return c.AutoScaling
+	default:
+		return c.Default
+	}
+}
+
+// Retryer returns the retryer of the AWS SDK clients of the class.
+func (c RetryConfig) Retryer() awsv2.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = c.MaxAttempts
+		o.MaxBackoff = time.Duration(c.MaxBackoffSeconds) * time.Second
+	})
+}
+
+// StepBackoff is the wait after the given failed run of a step, counting
+// from 1.
+func (c RetryConfig) StepBackoff(run int) time.Duration {
+	if run < 1 {
+		return 0
+	}
+	return time.Duration(c.StepBackoffSeconds) * time.Second << (run - 1)
+}
+
+func validateRetries(retries RetriesConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, class := range []struct {
+		name   string
+		policy RetryConfig
+	}{
+		{"networking", retries.Networking},
+		{"loadBalancing", retries.LoadBalancing},
+		{"autoScaling", retries.AutoScaling},
+		{"default", retries.Default},
+	} {
+		if class.policy.MaxAttempts < 1 {
+			report("retries.%s.maxAttempts %d must be at least 1", class.name, class.policy.MaxAttempts)
+		}
+		if class.policy.MaxBackoffSeconds < 1 {
+			report("retries.%s.maxBackoffSeconds %d must be at least 1", class.name, class.policy.MaxBackoffSeconds)
+		}
+		if class.policy.StepAttempts < 1 {
+			report("retries.%s.stepAttempts %d must be at least 1", class.name, class.policy.StepAttempts)
+		}
+		if class.policy.StepBackoffSeconds < 0 {
+			report("retries.%s.stepBackoffSeconds %d can't be negative", class.name, class.policy.StepBackoffSeconds)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// StateRequestTimeout bounds every state read and write in S3. Saves
+	// happen from the middle of steps, which don't pass a context along.
+	StateRequestTimeout = 30 * time.Second
+)
+
+// S3Backend keeps the state as an object in S3, so everyone working on the
+// stack shares it. With a KMS key the object is encrypted with SSE-KMS.
+type S3Backend struct {
+	client   *s3.Client
+	bucket   string
+	key      string
+	kmsKeyID string
+}
+
+func NewS3Backend(client *s3.Client, stateConfig StateConfig) *S3Backend {
+	return &S3Backend{
+		client:   client,
+		bucket:   stateConfig.Bucket,
+		key:      stateConfig.Key,
+		kmsKeyID: stateConfig.KMSKeyID,
+	}
+}
+
+func (b *S3Backend) Read() ([]byte, error) {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), StateRequestTimeout)
+	defer cancelFunc()
+
+	output, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key),
+	})
+	var noSuchKey *s3Types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state from %s: %w", b, err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading state from %s: %w", b, err)
+	}
+	return data, nil
+}
+
+func (b *S3Backend) Write(data []byte) error {
+	ctx, cancelFunc := context.WithTimeout(context.Background(), StateRequestTimeout)
+	defer cancelFunc()
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(b.bucket),
+		Key:         aws.String(b.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	}
+	if b.kmsKeyID != "" {
+		input.ServerSideEncryption = s3Types.ServerSideEncryptionAwsKms
+		input.SSEKMSKeyId = aws.String(b.kmsKeyID)
+	}
+
+	if _, err := b.client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("error writing state to %s: %w", b, err)
+	}
+	return nil
+}
+
+func (b *S3Backend) String() string {
+	return fmt.Sprintf("s3://%s/%s", b.bucket, b.key)
+}
+
+// CheckVersioning warns when the bucket doesn't keep old versions of the
+// state, as a bad write then can't be undone.
+func (b *S3Backend) CheckVersioning(ctx context.Context, logger *log.Logger) {
+	output, err := b.client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(b.bucket),
+	})
+	if err != nil {
+		logger.Printf("Cannot check versioning of state bucket %s: %v", b.bucket, err)
+		return
+	}
+	if output.Status != s3Types.BucketVersioningStatusEnabled {
+		logger.Printf("Versioning is not enabled on state bucket %s, previous states can't be recovered", b.bucket)
+	}
+}
+
+// OpenState loads the state from S3 when state.bucket is configured, from
+// the local state file otherwise.
+func OpenState(ctx context.Context, logger *log.Logger, cfg *Config, opts *GlobalOptions, clients *Clients) (*State, error) {
+	if cfg.State.Bucket == "" {
+		return LoadState(FileBackend{Path: opts.StateFile()})
+	}
+
+	backend := NewS3Backend(clients.S3, cfg.State)
+	backend.CheckVersioning(ctx, logger)
+	return LoadState(backend)
+}
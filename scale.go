@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Capacity is the size of an autoscaling group.
+type Capacity struct {
+	Min     int32 `json:"min"`
+	Max     int32 `json:"max"`
+	Desired int32 `json:"desired"`
+}
+
+func (c Capacity) String() string {
+	return fmt.Sprintf("min %d, max %d, desired %d", c.Min, c.Max, c.Desired)
+}
+
+func (c Capacity) Validate() error {
+	if c.Min < 0 {
+		return fmt.Errorf("min capacity must not be negative, got %d", c.Min)
+	}
+	if c.Min > c.Max {
+		return fmt.Errorf("min capacity %d is greater than max capacity %d", c.Min, c.Max)
+	}
+	if c.Desired < c.Min || c.Desired > c.Max {
+		return fmt.Errorf("desired capacity %d is outside of the min %d - max %d range", c.Desired, c.Min, c.Max)
+	}
+	return nil
+}
+
+func DescribeCapacity(ctx context.Context, autoscalingClient *autoscaling.Client, autoscalingGroupName string) (Capacity, error) {
+	output, err := autoscalingClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoscalingGroupName},
+	})
+	if err != nil {
+		return Capacity{}, fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return Capacity{}, fmt.Errorf("autoscaling group %s not found", autoscalingGroupName)
+	}
+
+	group := output.AutoScalingGroups[0]
+	return Capacity{
+		Min:     aws.Int32Value(group.MinSize),
+		Max:     aws.Int32Value(group.MaxSize),
+		Desired: aws.Int32Value(group.DesiredCapacity),
+	}, nil
+}
+
+// ScaleAutoscalingGroup applies the new min/max bounds with
+// UpdateAutoScalingGroup and then the desired capacity with
+// SetDesiredCapacity, so a new desired value always lands inside the range.
+func ScaleAutoscalingGroup(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, current, target Capacity) error {
+	if current.Min != target.Min || current.Max != target.Max {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			MinSize:              aws.Int32(target.Min),
+			MaxSize:              aws.Int32(target.Max),
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group: %w", err)
+		}
+		logger.Printf("Autoscaling group %s bounds set to min %d, max %d", autoscalingGroupName, target.Min, target.Max)
+	}
+
+	if current.Desired != target.Desired {
+		if _, err := autoscalingClient.SetDesiredCapacity(ctx, &autoscaling.SetDesiredCapacityInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			DesiredCapacity:      aws.Int32(target.Desired),
+		}); err != nil {
+			return fmt.Errorf("error setting desired capacity: %w", err)
+		}
+		logger.Printf("Autoscaling group %s desired capacity set to %d", autoscalingGroupName, target.Desired)
+	}
+
+	return nil
+}
+
+func runScale(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	var minSize, maxSize, desired int
+	var yes bool
+	fs := flag.NewFlagSet("scale", flag.ExitOnError)
+	opts.Register(fs)
+	fs.IntVar(&minSize, "min", -1, "new min capacity (unchanged when omitted)")
+	fs.IntVar(&maxSize, "max", -1, "new max capacity (unchanged when omitted)")
+	fs.IntVar(&desired, "desired", -1, "new desired capacity (unchanged when omitted)")
+	fs.BoolVar(&yes, "yes", false, "skip the confirmation prompt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if minSize < 0 && maxSize < 0 && desired < 0 {
+		return errors.New("scale requires at least one of --min, --max or --desired")
+	}
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	current, err := DescribeCapacity(ctx, clients.AutoScaling, state.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	target := current
+	if minSize >= 0 {
+		target.Min = int32(minSize)
+	}
+	if maxSize >= 0 {
+		target.Max = int32(maxSize)
+	}
+	if desired >= 0 {
+		target.Desired = int32(desired)
+	}
+	if err := target.Validate(); err != nil {
+		return err
+	}
+	if target == current {
+		logger.Printf("Autoscaling group %s already at %s", state.AutoScalingGroupName, current)
+		return nil
+	}
+
+	if !yes {
+		ok, err := Confirm(fmt.Sprintf("Scale %s from %s to %s?", state.AutoScalingGroupName, current, target))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logger.Println("Scaling cancelled")
+			return nil
+		}
+	}
+
+	return ScaleAutoscalingGroup(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, current, target)
+}
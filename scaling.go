@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// SQSBacklogConfig scales worker services on the backlog of a queue: the
+// visible messages divided by the InService instances is kept at
+// TargetBacklogPerInstance. The division needs the GroupInServiceInstances
+// group metric.
+type SQSBacklogConfig struct {
+	QueueName                string  `json:"queueName"`
+	TargetBacklogPerInstance float64 `json:"targetBacklogPerInstance"`
+}
+
+// CustomMetricConfig makes the scaling policy track any CloudWatch metric,
+// e.g. one the application publishes, at TargetValue.
+type CustomMetricConfig struct {
+	Namespace  string            `json:"namespace"`
+	MetricName string            `json:"metricName"`
+	Dimensions map[string]string `json:"dimensions"`
+	// Statistic is one of Average, Minimum, Maximum, SampleCount or Sum.
+	Statistic   string  `json:"statistic"`
+	Unit        string  `json:"unit"`
+	TargetValue float64 `json:"targetValue"`
+}
+
+// MetricStatistics are the statistics a custom metric can be tracked by.
+var MetricStatistics = []string{"Average", "Minimum", "Maximum", "SampleCount", "Sum"}
+
+// MetricQuery is one query of a metric math target tracking policy, either
+// a CloudWatch metric or an expression over the other queries.
+type MetricQuery struct {
+	ID         string
+	Expression string
+	Namespace  string
+	MetricName string
+	Dimensions map[string]string
+	Stat       string
+	ReturnData bool
+}
+
+// ScalingMetric describes what the scaling policy tracks, for logs and the
+// plan.
+func (a AutoScalingConfig) ScalingMetric() string {
+	if a.CustomMetric != nil {
+		return fmt.Sprintf("%g %s of %s/%s", a.CustomMetric.TargetValue, a.CustomMetric.Statistic, a.CustomMetric.Namespace, a.CustomMetric.MetricName)
+	}
+	if a.SQSBacklog != nil {
+		return fmt.Sprintf("%g messages per instance in %s", a.SQSBacklog.TargetBacklogPerInstance, a.SQSBacklog.QueueName)
+	}
+	return fmt.Sprintf("%.0f%% average CPU", a.CPUTargetValue)
+}
+
+// TargetValue is the value the scaling policy keeps its metric at.
+func (a AutoScalingConfig) TargetValue() float64 {
+	if a.CustomMetric != nil {
+		return a.CustomMetric.TargetValue
+	}
+	if a.SQSBacklog != nil {
+		return a.SQSBacklog.TargetBacklogPerInstance
+	}
+	return a.CPUTargetValue
+}
+
+// MetricQueries returns the metric math the scaling policy tracks, or nil
+// when it tracks a single metric.
+func (a AutoScalingConfig) MetricQueries(autoscalingGroupName string) []MetricQuery {
+	if a.SQSBacklog == nil {
+		return nil
+	}
+	return []MetricQuery{
+		{
+			ID:         "visible",
+			Namespace:  "AWS/SQS",
+			MetricName: "ApproximateNumberOfMessagesVisible",
+			Dimensions: map[string]string{"QueueName": a.SQSBacklog.QueueName},
+			Stat:       "Sum",
+		},
+		{
+			ID:         "instances",
+			Namespace:  "AWS/AutoScaling",
+			MetricName: "GroupInServiceInstances",
+			Dimensions: map[string]string{"AutoScalingGroupName": autoscalingGroupName},
+			Stat:       "Average",
+		},
+		{
+			ID:         "backlog",
+			Expression: "visible / instances",
+			ReturnData: true,
+		},
+	}
+}
+
+// TargetTrackingConfiguration builds the target tracking configuration of
+// the scaling policy of the group.
+func TargetTrackingConfiguration(asgConfig AutoScalingConfig, autoscalingGroupName string) *autoscalingTypes.TargetTrackingConfiguration {
+	trackingConfig := &autoscalingTypes.TargetTrackingConfiguration{
+		TargetValue: aws.Float64(asgConfig.TargetValue()),
+	}
+
+	if custom := asgConfig.CustomMetric; custom != nil {
+		trackingConfig.CustomizedMetricSpecification = &autoscalingTypes.CustomizedMetricSpecification{
+			Namespace:  aws.String(custom.Namespace),
+			MetricName: aws.String(custom.MetricName),
+			Dimensions: metricDimensions(custom.Dimensions),
+			Statistic:  autoscalingTypes.MetricStatistic(custom.Statistic),
+		}
+		if custom.Unit != "" {
+			trackingConfig.CustomizedMetricSpecification.Unit = aws.String(custom.Unit)
+		}
+		return trackingConfig
+	}
+
+	queries := asgConfig.MetricQueries(autoscalingGroupName)
+	if queries == nil {
+		trackingConfig.PredefinedMetricSpecification = &autoscalingTypes.PredefinedMetricSpecification{
+			PredefinedMetricType: autoscalingTypes.MetricTypeASGAverageCPUUtilization,
+		}
+		return trackingConfig
+	}
+
+	metrics := make([]autoscalingTypes.TargetTrackingMetricDataQuery, 0, len(queries))
+	for _, query := range queries {
+		metric := autoscalingTypes.TargetTrackingMetricDataQuery{
+			Id:         aws.String(query.ID),
+			ReturnData: aws.Bool(query.ReturnData),
+		}
+		if query.Expression != "" {
+			metric.Expression = aws.String(query.Expression)
+		} else {
+			metric.MetricStat = &autoscalingTypes.TargetTrackingMetricStat{
+				Metric: &autoscalingTypes.Metric{
+					Namespace:  aws.String(query.Namespace),
+					MetricName: aws.String(query.MetricName),
+					Dimensions: metricDimensions(query.Dimensions),
+				},
+				Stat: aws.String(query.Stat),
+			}
+		}
+		metrics = append(metrics, metric)
+	}
+	trackingConfig.CustomizedMetricSpecification = &autoscalingTypes.CustomizedMetricSpecification{
+		Metrics: metrics,
+	}
+	return trackingConfig
+}
+
+func metricDimensions(dimensions map[string]string) []autoscalingTypes.MetricDimension {
+	names := make([]string, 0, len(dimensions))
+	for name := range dimensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metricDimensions := make([]autoscalingTypes.MetricDimension, 0, len(names))
+	for _, name := range names {
+		metricDimensions = append(metricDimensions, autoscalingTypes.MetricDimension{
+			Name:  aws.String(name),
+			Value: aws.String(dimensions[name]),
+		})
+	}
+	return metricDimensions
+}
+
+// DescribeTrackedMetric summarizes the metric of a target tracking
+// configuration, so the live and the configured policy can be compared.
+func DescribeTrackedMetric(trackingConfig *autoscalingTypes.TargetTrackingConfiguration) string {
+	if trackingConfig == nil {
+		return ""
+	}
+	if trackingConfig.PredefinedMetricSpecification != nil {
+		return string(trackingConfig.PredefinedMetricSpecification.PredefinedMetricType)
+	}
+
+	spec := trackingConfig.CustomizedMetricSpecification
+	if spec == nil {
+		return ""
+	}
+	if len(spec.Metrics) == 0 {
+		return describeMetric(aws.StringValue(spec.Namespace), aws.StringValue(spec.MetricName), spec.Dimensions, string(spec.Statistic))
+	}
+
+	queries := make([]string, 0, len(spec.Metrics))
+	for _, query := range spec.Metrics {
+		switch {
+		case query.Expression != nil:
+			queries = append(queries, fmt.Sprintf("%s=%s", aws.StringValue(query.Id), aws.StringValue(query.Expression)))
+		case query.MetricStat != nil && query.MetricStat.Metric != nil:
+			metric := query.MetricStat.Metric
+			queries = append(queries, fmt.Sprintf("%s=%s", aws.StringValue(query.Id),
+				describeMetric(aws.StringValue(metric.Namespace), aws.StringValue(metric.MetricName), metric.Dimensions, aws.StringValue(query.MetricStat.Stat))))
+		}
+	}
+	return strings.Join(queries, "; ")
+}
+
+func describeMetric(namespace, metricName string, dimensions []autoscalingTypes.MetricDimension, stat string) string {
+	pairs := make([]string, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		pairs = append(pairs, aws.StringValue(dimension.Name)+"="+aws.StringValue(dimension.Value))
+	}
+	sort.Strings(pairs)
+	return fmt.Sprintf("%s/%s{%s} %s", namespace, metricName, strings.Join(pairs, ","), stat)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	secretsTypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmTypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	SecretStoreSSM            = "ssm"
+	SecretStoreSecretsManager = "secretsmanager"
+	// SecretsPolicyName is the inline policy of the instance role that lets
+	// the instances read the secrets.
+	SecretsPolicyName = "read-secrets"
+)
+
+// SecretStores are the services a secret can be published to.
+var SecretStores = []string{SecretStoreSSM, SecretStoreSecretsManager}
+
+// secretNamePattern matches the names a shell variable can have.
+var secretNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SecretConfig is a value apply publishes to SSM Parameter Store, as a
+// SecureString, or to Secrets Manager. The user data reads it at boot into
+// the environment variable Name, so the value itself never ends up in the
+// launch template.
+type SecretConfig struct {
+	Name string `json:"name"`
+	// Store is ssm or secretsmanager.
+	Store string `json:"store"`
+	// Path is the parameter or secret name, by default under the name of the
+	// stack. Parameter paths start with a slash.
+	Path string `json:"path"`
+	// FromEnv is the environment variable apply reads the value from, Name by
+	// default. FromFile reads it from a file instead.
+	FromEnv  string `json:"fromEnv"`
+	FromFile string `json:"fromFile"`
+	// Region is where the instances read the secret from, set from the
+	// config.
+	Region string `json:"-"`
+}
+
+// Value reads the value to publish from the environment or the file.
+func (s SecretConfig) Value() (string, error) {
+	if s.FromFile != "" {
+		data, err := os.ReadFile(s.FromFile)
+		if err != nil {
+			return "", fmt.Errorf("error reading secret %s: %w", s.Name, err)
+		}
+		return strings.TrimRight(string(data), "\n"), nil
+	}
+	value, ok := os.LookupEnv(s.FromEnv)
+	if !ok || value == "" {
+		return "", fmt.Errorf("secret %s: environment variable %s is not set", s.Name, s.FromEnv)
+	}
+	return value, nil
+}
+
+// FetchCommand is the AWS CLI command printing the secret on the instance.
+func (s SecretConfig) FetchCommand() string {
+	if s.Store == SecretStoreSecretsManager {
+		return fmt.Sprintf("aws secretsmanager get-secret-value --region %s --secret-id %s --query SecretString --output text", s.Region, shellQuote(s.Path))
+	}
+	return fmt.Sprintf("aws ssm get-parameter --region %s --name %s --with-decryption --query Parameter.Value --output text", s.Region, shellQuote(s.Path))
+}
+
+// PowerShellFetchCommand is the AWS Tools for PowerShell command returning
+// the secret on a Windows instance.
+func (s SecretConfig) PowerShellFetchCommand() string {
+	if s.Store == SecretStoreSecretsManager {
+		return fmt.Sprintf("(Get-SECSecretValue -Region %s -SecretId %s).SecretString", s.Region, powerShellQuote(s.Path))
+	}
+	return fmt.Sprintf("(Get-SSMParameter -Region %s -Name %s -WithDecryption $true).Value", s.Region, powerShellQuote(s.Path))
+}
+
+func (s SecretConfig) String() string {
+	return fmt.Sprintf("%s (%s %s)", s.Name, s.Store, s.Path)
+}
+
+// SecretNames returns the environment variables the secrets are read into.
+func SecretNames(secrets []SecretConfig) []string {
+	names := make([]string, 0, len(secrets))
+	for _, secret := range secrets {
+		names = append(names, secret.Name)
+	}
+	return names
+}
+
+// SecretsUserData prepends reading the secrets to the user data script, so
+// the script can refer to them as environment variables. The script stops
+// when a secret can't be read.
+func SecretsUserData(secrets []SecretConfig, script []byte) []byte {
+	var userData strings.Builder
+	userData.WriteString("#!/bin/bash\n")
+	for _, secret := range secrets {
+		fmt.Fprintf(&userData, "%s=\"$(%s)\" || exit 1\n", secret.Name, secret.FetchCommand())
+		fmt.Fprintf(&userData, "export %s\n", secret.Name)
+	}
+	userData.Write(stripShebang(script))
+	return []byte(userData.String())
+}
+
+// SecretsPolicy lets the instances read the secrets with the given ARNs and
+// nothing else.
+func SecretsPolicy(secretARNs []string) string {
+	var parameters, secrets []string
+	for _, secretARN := range secretARNs {
+		if strings.Contains(secretARN, ":secretsmanager:") {
+			secrets = append(secrets, secretARN)
+		} else {
+			parameters = append(parameters, secretARN)
+		}
+	}
+
+	var statements []map[string]any
+	if len(parameters) > 0 {
+		statements = append(statements, map[string]any{
+			"Effect":   "Allow",
+			"Action":   "ssm:GetParameter",
+			"Resource": parameters,
+		})
+	}
+	if len(secrets) > 0 {
+		statements = append(statements, map[string]any{
+			"Effect":   "Allow",
+			"Action":   "secretsmanager:GetSecretValue",
+			"Resource": secrets,
+		})
+	}
+	return policyJSON(statements...)
+}
+
+func secretsTags(tags map[string]string) []secretsTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]secretsTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, secretsTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+func ssmTags(tags map[string]string) []ssmTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]ssmTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, ssmTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// PublishSecret creates the parameter or secret with its current value, or
+// puts the value into it when it was published before, and returns its ARN.
+func PublishSecret(ctx context.Context, clients *Clients, secret SecretConfig, published bool, tags map[string]string) (string, error) {
+	value, err := secret.Value()
+	if err != nil {
+		return "", err
+	}
+
+	if secret.Store == SecretStoreSecretsManager {
+		if published {
+			output, err := clients.Secrets.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+				SecretId:     aws.String(secret.Path),
+				SecretString: aws.String(value),
+			})
+			if err != nil {
+				return "", fmt.Errorf("error putting value of secret %s: %w", secret.Path, err)
+			}
+			return aws.StringValue(output.ARN), nil
+		}
+		output, err := clients.Secrets.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+			Name:         aws.String(secret.Path),
+			SecretString: aws.String(value),
+			Tags:         secretsTags(tags),
+		})
+		if err != nil {
+			return "", fmt.Errorf("error creating secret %s: %w", secret.Path, err)
+		}
+		return aws.StringValue(output.ARN), nil
+	}
+
+	// Tags can only be set on a parameter that doesn't exist yet.
+	input := &ssm.PutParameterInput{
+		Name:      aws.String(secret.Path),
+		Value:     aws.String(value),
+		Type:      ssmTypes.ParameterTypeSecureString,
+		Overwrite: aws.Bool(published),
+	}
+	if !published {
+		input.Tags = ssmTags(tags)
+	}
+	if _, err := clients.SSM.PutParameter(ctx, input); err != nil {
+		return "", fmt.Errorf("error putting parameter %s: %w", secret.Path, err)
+	}
+	output, err := clients.SSM.GetParameter(ctx, &ssm.GetParameterInput{
+		Name: aws.String(secret.Path),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error describing parameter %s: %w", secret.Path, err)
+	}
+	return aws.StringValue(output.Parameter.ARN), nil
+}
+
+// PublishSecrets publishes the current values of the secrets and records
+// each of them as soon as it exists. It reports whether a secret was
+// created.
+func PublishSecrets(ctx context.Context, logger *log.Logger, clients *Clients, secrets []SecretConfig, state *State, tags map[string]string) (bool, error) {
+	created := false
+	for _, secret := range secrets {
+		recordedARN, published := state.SecretARNs[secret.Path]
+		if published && !strings.Contains(recordedARN, ":"+secret.Store+":") {
+			// The secret moved to the other store.
+			if err := deleteSecret(ctx, clients, recordedARN); err != nil {
+				return created, err
+			}
+			published = false
+		}
+		secretARN, err := PublishSecret(ctx, clients, secret, published, tags)
+		if err != nil {
+			return created, err
+		}
+		if published {
+			logger.Printf("Secret %s updated", secret)
+			continue
+		}
+		logger.Printf("Secret %s published with ARN: %s", secret, secretARN)
+		created = true
+		if err := state.Record(func(s *State) {
+			if s.SecretARNs == nil {
+				s.SecretARNs = make(map[string]string)
+			}
+			s.SecretARNs[secret.Path] = secretARN
+		}); err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// RecordedSecretARNs returns the ARNs of the published secrets in a stable
+// order.
+func RecordedSecretARNs(state *State) []string {
+	secretARNs := make([]string, 0, len(state.SecretARNs))
+	for _, secretARN := range state.SecretARNs {
+		secretARNs = append(secretARNs, secretARN)
+	}
+	sort.Strings(secretARNs)
+	return secretARNs
+}
+
+// PutSecretsPolicy scopes the inline policy of the instance role to the
+// published secrets, removing it when there are none.
+func PutSecretsPolicy(ctx context.Context, logger *log.Logger, iamClient *iam.Client, roleName string, secretARNs []string) error {
+	if len(secretARNs) == 0 {
+		if _, err := iamClient.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(roleName),
+			PolicyName: aws.String(SecretsPolicyName),
+		}); err != nil && !hasErrorCode(err, "NoSuchEntity") {
+			return fmt.Errorf("error deleting policy %s of role %s: %w", SecretsPolicyName, roleName, err)
+		}
+		return nil
+	}
+
+	if _, err := iamClient.PutRolePolicy(ctx, &iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(SecretsPolicyName),
+		PolicyDocument: aws.String(SecretsPolicy(secretARNs)),
+	}); err != nil {
+		return fmt.Errorf("error putting policy %s of role %s: %w", SecretsPolicyName, roleName, err)
+	}
+	logger.Printf("Role %s can read %d secrets", roleName, len(secretARNs))
+	return nil
+}
+
+// deleteSecret deletes a parameter or secret by ARN. Secrets are deleted
+// without a recovery window, so the stack can be created again right away.
+func deleteSecret(ctx context.Context, clients *Clients, secretARN string) error {
+	if strings.Contains(secretARN, ":secretsmanager:") {
+		if _, err := clients.Secrets.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+			SecretId:                   aws.String(secretARN),
+			ForceDeleteWithoutRecovery: aws.Bool(true),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting secret %s: %w", secretARN, err)
+		}
+		return nil
+	}
+
+	// Parameter paths start with a slash, which their ARN drops.
+	name := "/" + secretARN[strings.Index(secretARN, ":parameter/")+len(":parameter/"):]
+	if _, err := clients.SSM.DeleteParameter(ctx, &ssm.DeleteParameterInput{
+		Name: aws.String(name),
+	}); err != nil && !isNotFound(err) {
+		return fmt.Errorf("error deleting parameter %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteSecrets deletes the published secrets that are not in keep,
+// forgetting each of them once it is gone. It reports whether one was
+// deleted.
+func DeleteSecrets(ctx context.Context, logger *log.Logger, clients *Clients, state *State, keep []SecretConfig) (bool, error) {
+	kept := make(map[string]bool, len(keep))
+	for _, secret := range keep {
+		kept[secret.Path] = true
+	}
+	paths := make([]string, 0, len(state.SecretARNs))
+	for path := range state.SecretARNs {
+		if !kept[path] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		secretARN := state.SecretARNs[path]
+		if err := deleteSecret(ctx, clients, secretARN); err != nil {
+			return false, err
+		}
+		logger.Printf("Secret %s deleted", secretARN)
+		if err := state.Record(func(s *State) { delete(s.SecretARNs, path) }); err != nil {
+			return false, err
+		}
+	}
+	return len(paths) > 0, nil
+}
+
+// UpdateSecrets publishes the current values of the secrets, deletes the
+// ones removed from the config and keeps the instance role able to read
+// exactly the published ones. The instance role is created when the secrets
+// are added to a stack that had none.
+func UpdateSecrets(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	created, err := PublishSecrets(ctx, logger, clients, cfg.Secrets, state, cfg.StackTags())
+	if err != nil {
+		return err
+	}
+	deleted, err := DeleteSecrets(ctx, logger, clients, state, cfg.Secrets)
+	if err != nil {
+		return err
+	}
+
+	if cfg.CreatesInstanceRole() && state.InstanceRoleName == "" {
+		roleName := cfg.LaunchTemplate.InstanceRoleName
+		err := CreateInstanceRole(ctx, logger, clients.IAM, roleName, "Role of the instances of the autoscaling group", cfg.InstanceRolePolicyARNs(), cfg.StackTags())
+		if saveErr := state.Record(func(s *State) { s.InstanceRoleName = roleName }); saveErr != nil {
+			return saveErr
+		}
+		if err != nil {
+			return err
+		}
+		created = true
+	}
+
+	if state.InstanceRoleName == "" || !created && !deleted {
+		return nil
+	}
+	return PutSecretsPolicy(ctx, logger, clients.IAM, state.InstanceRoleName, RecordedSecretARNs(state))
+}
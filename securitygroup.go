@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// ProtocolAll matches every protocol and port.
+	ProtocolAll = "-1"
+	AnyIPv4     = "0.0.0.0/0"
+)
+
+// SecurityGroupRule allows traffic of a protocol on a port range to or from
+// the given CIDR blocks and prefix lists. ToPort defaults to FromPort; ports
+// are ignored for protocol -1. PrefixListNames refer to managed prefix lists
+// by name, e.g. com.amazonaws.global.cloudfront.origin-facing, and are
+// looked up when the rule is created.
+type SecurityGroupRule struct {
+	Protocol        string   `json:"protocol"`
+	FromPort        int32    `json:"fromPort"`
+	ToPort          int32    `json:"toPort"`
+	CIDRs           []string `json:"cidrs"`
+	PrefixListIDs   []string `json:"prefixListIds"`
+	PrefixListNames []string `json:"prefixListNames"`
+	Description     string   `json:"description"`
+}
+
+// allowAllRule is the egress rule every new security group starts with.
+var allowAllRule = SecurityGroupRule{Protocol: ProtocolAll, CIDRs: []string{AnyIPv4}}
+
+var securityGroupRuleDescriptionPattern = regexp.MustCompile(`^[a-zA-Z0-9 ._\-:/()#,@\[\]+=&;{}!$*]*$`)
+
+func (r SecurityGroupRule) protocol() string {
+	if r.Protocol == "" {
+		return "tcp"
+	}
+	return r.Protocol
+}
+
+func (r SecurityGroupRule) toPort() int32 {
+	if r.ToPort == 0 {
+		return r.FromPort
+	}
+	return r.ToPort
+}
+
+// normalized fills in the default protocol and ToPort.
+func (r SecurityGroupRule) normalized() SecurityGroupRule {
+	r.Protocol = r.protocol()
+	r.ToPort = r.toPort()
+	return r
+}
+
+func (r SecurityGroupRule) IPPermission() types.IpPermission {
+	permission := types.IpPermission{IpProtocol: aws.String(r.protocol())}
+	if r.protocol() != ProtocolAll {
+		permission.FromPort = aws.Int32(r.FromPort)
+		permission.ToPort = aws.Int32(r.toPort())
+	}
+	var description *string
+	if r.Description != "" {
+		description = aws.String(r.Description)
+	}
+	for _, cidr := range r.CIDRs {
+		permission.IpRanges = append(permission.IpRanges, types.IpRange{CidrIp: aws.String(cidr), Description: description})
+	}
+	for _, prefixListID := range r.PrefixListIDs {
+		permission.PrefixListIds = append(permission.PrefixListIds, types.PrefixListId{PrefixListId: aws.String(prefixListID), Description: description})
+	}
+	return permission
+}
+
+// Strings describes the rule once per CIDR block and prefix list, in the form diff compares
+// live and configured rules in.
+func (r SecurityGroupRule) Strings() []string {
+	return permissionStrings(r.IPPermission())
+}
+
+func permissionStrings(permission types.IpPermission) []string {
+	ports := permissionPorts(permission)
+	var peers []string
+	for _, ipRange := range permission.IpRanges {
+		peers = append(peers, aws.StringValue(ipRange.CidrIp))
+	}
+	for _, prefixList := range permission.PrefixListIds {
+		peers = append(peers, aws.StringValue(prefixList.PrefixListId))
+	}
+
+	rules := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		rules = append(rules, fmt.Sprintf("%s%s from/to %s", aws.StringValue(permission.IpProtocol), ports, peer))
+	}
+	return rules
+}
+
+// permissionPorts is the port range of a live permission as DescribeRules
+// writes it, empty for all protocols.
+func permissionPorts(permission types.IpPermission) string {
+	if aws.StringValue(permission.IpProtocol) == ProtocolAll {
+		return ""
+	}
+	return " " + portRange(aws.Int32Value(permission.FromPort), aws.Int32Value(permission.ToPort))
+}
+
+func portRange(from, to int32) string {
+	if from == to {
+		return fmt.Sprint(from)
+	}
+	return fmt.Sprintf("%d-%d", from, to)
+}
+
+// IngressRules returns the ingress rules of the security group: the
+// configured ingress rules or, without them, a TCP rule open to the internet
+// for each of IngressPorts, followed by the added ones.
+func (s SecurityGroupConfig) IngressRules() []SecurityGroupRule {
+	var rules []SecurityGroupRule
+	for _, rule := range s.Ingress {
+		rules = append(rules, rule.normalized())
+	}
+	if len(rules) == 0 {
+		for _, port := range s.IngressPorts {
+			rules = append(rules, SecurityGroupRule{
+				Protocol:    "tcp",
+				FromPort:    port,
+				ToPort:      port,
+				CIDRs:       []string{AnyIPv4},
+				Description: fmt.Sprintf("Port %d from anywhere", port),
+			})
+		}
+	}
+	for _, rule := range s.AddedIngress {
+		rules = append(rules, rule.normalized())
+	}
+	return rules
+}
+
+// DescribeRules lists the rules in the form diff and the logs use.
+func DescribeRules(rules []SecurityGroupRule) []string {
+	var described []string
+	for _, rule := range rules {
+		described = append(described, rule.Strings()...)
+	}
+	return described
+}
+
+// EgressRules returns the egress rules the security group ends up with: the
+// configured ones plus, unless revoked, the default allow-all rule.
+func (s SecurityGroupConfig) EgressRules() []SecurityGroupRule {
+	var rules []SecurityGroupRule
+	if !s.RevokeDefaultEgress {
+		rules = append(rules, allowAllRule)
+	}
+	for _, rule := range s.Egress {
+		rules = append(rules, rule.normalized())
+	}
+	return rules
+}
+
+// ResolvePrefixLists looks up the IDs of the managed prefix lists the rules
+// refer to by name and returns the rules with the IDs added.
+func ResolvePrefixLists(ctx context.Context, ec2Client *ec2.Client, rules []SecurityGroupRule) ([]SecurityGroupRule, error) {
+	var names []string
+	for _, rule := range rules {
+		names = append(names, rule.PrefixListNames...)
+	}
+	if len(names) == 0 {
+		return rules, nil
+	}
+
+	output, err := ec2Client.DescribeManagedPrefixLists(ctx, &ec2.DescribeManagedPrefixListsInput{
+		Filters: []types.Filter{{Name: aws.String("prefix-list-name"), Values: names}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing managed prefix lists: %w", err)
+	}
+	ids := make(map[string]string, len(output.PrefixLists))
+	for _, prefixList := range output.PrefixLists {
+		ids[aws.StringValue(prefixList.PrefixListName)] = aws.StringValue(prefixList.PrefixListId)
+	}
+
+	resolved := make([]SecurityGroupRule, 0, len(rules))
+	for _, rule := range rules {
+		rule.PrefixListIDs = slices.Clone(rule.PrefixListIDs)
+		for _, name := range rule.PrefixListNames {
+			id, ok := ids[name]
+			if !ok {
+				return nil, fmt.Errorf("managed prefix list %s not found", name)
+			}
+			rule.PrefixListIDs = append(rule.PrefixListIDs, id)
+		}
+		rule.PrefixListNames = nil
+		resolved = append(resolved, rule)
+	}
+	return resolved, nil
+}
+
+// ConfigureEgress revokes the default allow-all egress rule of a new
+// security group when configured, and adds the configured egress rules.
+func ConfigureEgress(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, sgConfig SecurityGroupConfig, securityGroupID string) error {
+	if sgConfig.RevokeDefaultEgress {
+		if _, err := ec2Client.RevokeSecurityGroupEgress(ctx, &ec2.RevokeSecurityGroupEgressInput{
+			GroupId:       aws.String(securityGroupID),
+			IpPermissions: []types.IpPermission{allowAllRule.IPPermission()},
+		}); err != nil {
+			return fmt.Errorf("error revoking default outbound (egress) rule: %w", err)
+		}
+		logger.Printf("Revoked default outbound (egress) rule of security group with ID: %s", securityGroupID)
+	}
+
+	if len(sgConfig.Egress) == 0 {
+		return nil
+	}
+
+	egressRules, err := ResolvePrefixLists(ctx, ec2Client, sgConfig.Egress)
+	if err != nil {
+		return err
+	}
+	if _, err := ec2Client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+		GroupId:       aws.String(securityGroupID),
+		IpPermissions: ipPermissions(egressRules),
+	}); err != nil {
+		return fmt.Errorf("error adding outbound (egress) rules: %w", err)
+	}
+	logger.Printf("Added outbound (egress) rules %s to security group with ID: %s", strings.Join(DescribeRules(egressRules), ", "), securityGroupID)
+
+	return nil
+}
+
+func ipPermissions(rules []SecurityGroupRule) []types.IpPermission {
+	permissions := make([]types.IpPermission, 0, len(rules))
+	for _, rule := range rules {
+		permissions = append(permissions, rule.IPPermission())
+	}
+	return permissions
+}
+
+// PrefixListNames returns the managed prefix list names the ingress and
+// egress rules refer to, each once.
+func (s SecurityGroupConfig) PrefixListNames() []string {
+	var names []string
+	for _, rule := range append(s.IngressRules(), s.EgressRules()...) {
+		for _, name := range rule.PrefixListNames {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// UseExistingSecurityGroup looks up the securityGroup.existing group in the
+// VPC and, when configured, reconciles its rules. It returns the ID of the
+// group.
+func UseExistingSecurityGroup(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, sgConfig SecurityGroupConfig, vpcID string) (string, error) {
+	existing := sgConfig.Existing
+	input := &ec2.DescribeSecurityGroupsInput{
+		Filters: []types.Filter{{Name: aws.String("vpc-id"), Values: []string{vpcID}}},
+	}
+	if existing.ID != "" {
+		input.GroupIds = []string{existing.ID}
+	} else {
+		input.Filters = append(input.Filters, types.Filter{Name: aws.String("group-name"), Values: []string{existing.Name}})
+	}
+	output, err := ec2Client.DescribeSecurityGroups(ctx, input)
+	if err != nil {
+		return "", fmt.Errorf("error describing security group: %w", err)
+	}
+	if len(output.SecurityGroups) == 0 {
+		return "", fmt.Errorf("security group %s not found in VPC %s", orDash(existing.ID+existing.Name), vpcID)
+	}
+	group := output.SecurityGroups[0]
+	securityGroupID := aws.StringValue(group.GroupId)
+	logger.Printf("Using existing security group %s (%s)", securityGroupID, aws.StringValue(group.GroupName))
+
+	if !existing.Reconcile {
+		return securityGroupID, nil
+	}
+
+	ingressRules, err := ResolvePrefixLists(ctx, ec2Client, sgConfig.IngressRules())
+	if err != nil {
+		return "", err
+	}
+	egressRules, err := ResolvePrefixLists(ctx, ec2Client, sgConfig.EgressRules())
+	if err != nil {
+		return "", err
+	}
+
+	missingIngress, extraIngress := ruleChanges(group.IpPermissions, ingressRules)
+	if len(missingIngress) > 0 {
+		if _, err := ec2Client.AuthorizeSecurityGroupIngress(ctx, &ec2.AuthorizeSecurityGroupIngressInput{
+			GroupId:       aws.String(securityGroupID),
+			IpPermissions: ipPermissions(missingIngress),
+		}); err != nil {
+			return "", fmt.Errorf("error adding inbound (ingress) rules %s: %w", strings.Join(DescribeRules(missingIngress), ", "), err)
+		}
+		logger.Printf("Added missing inbound (ingress) rules %s to security group with ID: %s", strings.Join(DescribeRules(missingIngress), ", "), securityGroupID)
+	}
+	missingEgress, extraEgress := ruleChanges(group.IpPermissionsEgress, egressRules)
+	if len(missingEgress) > 0 {
+		if _, err := ec2Client.AuthorizeSecurityGroupEgress(ctx, &ec2.AuthorizeSecurityGroupEgressInput{
+			GroupId:       aws.String(securityGroupID),
+			IpPermissions: ipPermissions(missingEgress),
+		}); err != nil {
+			return "", fmt.Errorf("error adding outbound (egress) rules %s: %w", strings.Join(DescribeRules(missingEgress), ", "), err)
+		}
+		logger.Printf("Added missing outbound (egress) rules %s to security group with ID: %s", strings.Join(DescribeRules(missingEgress), ", "), securityGroupID)
+	}
+
+	// Other workloads may rely on the rules the config doesn't list, so
+	// they are only reported.
+	if len(extraIngress) > 0 {
+		logger.Printf("Security group %s has inbound (ingress) rules the config doesn't list, leaving them: %s", securityGroupID, strings.Join(extraIngress, ", "))
+	}
+	if len(extraEgress) > 0 {
+		logger.Printf("Security group %s has outbound (egress) rules the config doesn't list, leaving them: %s", securityGroupID, strings.Join(extraEgress, ", "))
+	}
+	return securityGroupID, nil
+}
+
+// ruleChanges compares the live permissions of a security group with the
+// configured rules. It returns the configured rules missing from the group,
+// one per CIDR block or prefix list, and the live rules that aren't
+// configured, in the form DescribeRules uses. Rules can't name a security
+// group, so the live rules that do are always extra.
+func ruleChanges(live []types.IpPermission, rules []SecurityGroupRule) ([]SecurityGroupRule, []string) {
+	var liveRules, groupRules []string
+	for _, permission := range live {
+		liveRules = append(liveRules, permissionStrings(permission)...)
+		groupRules = append(groupRules, groupPairStrings(permission)...)
+	}
+
+	var missing []SecurityGroupRule
+	for _, rule := range rules {
+		var peers []SecurityGroupRule
+		for _, cidr := range rule.CIDRs {
+			peer := rule
+			peer.CIDRs, peer.PrefixListIDs = []string{cidr}, nil
+			peers = append(peers, peer)
+		}
+		for _, prefixListID := range rule.PrefixListIDs {
+			peer := rule
+			peer.CIDRs, peer.PrefixListIDs = nil, []string{prefixListID}
+			peers = append(peers, peer)
+		}
+		for _, peer := range peers {
+			if !slices.Contains(liveRules, peer.Strings()[0]) {
+				missing = append(missing, peer)
+			}
+		}
+	}
+
+	desired := DescribeRules(rules)
+	extra := slices.DeleteFunc(liveRules, func(rule string) bool { return slices.Contains(desired, rule) })
+	return missing, append(extra, groupRules...)
+}
+
+// groupPairStrings describes the rules of a live permission naming security
+// groups, like permissionStrings does for CIDR blocks and prefix lists.
+func groupPairStrings(permission types.IpPermission) []string {
+	ports := permissionPorts(permission)
+	rules := make([]string, 0, len(permission.UserIdGroupPairs))
+	for _, pair := range permission.UserIdGroupPairs {
+		rules = append(rules, fmt.Sprintf("%s%s from/to %s", aws.StringValue(permission.IpProtocol), ports, aws.StringValue(pair.GroupId)))
+	}
+	return rules
+}
@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	DefaultServeAddr = ":8080"
+	// EnvAPIToken is the bearer token every request must carry, when set.
+	EnvAPIToken = "PZC_API_TOKEN"
+	// JobLogLines is how much of the log of a job is kept.
+	JobLogLines     = 200
+	ShutdownTimeout = 10 * time.Second
+
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+
+	StepStarted = "started"
+	StepDone    = "done"
+	StepFailed  = "failed"
+
+	// JobEventBuffer is how many events a slow watcher may lag behind before
+	// it misses events.
+	JobEventBuffer = 256
+)
+
+// ErrJobRunning is returned when a job is started for a stack whose last
+// job is still running.
+var ErrJobRunning = errors.New("a job of the stack is still running")
+
+// stackNamePattern keeps stack names usable in resource and state file
+// names.
+var stackNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,30}[a-zA-Z0-9])?$`)
+
+// Job is an apply or destroy of a stack run in the background by serve.
+type Job struct {
+	ID          string        `json:"id"`
+	Command     string        `json:"command"`
+	Stack       string        `json:"stack"`
+	Environment string        `json:"environment,omitempty"`
+	Status      string        `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	StartedAt   time.Time     `json:"startedAt"`
+	FinishedAt  *time.Time    `json:"finishedAt,omitempty"`
+	Outputs     *StackOutputs `json:"outputs,omitempty"`
+	Log         []string      `json:"log"`
+}
+
+// JobEvent is something that happened in a job, sent to the watchers of its
+// stack: a step that started or ended, a log line, or the job that finished.
+type JobEvent struct {
+	JobID    string
+	Time     time.Time
+	Step     *StepEvent
+	Log      string
+	Finished *Job
+}
+
+type StepEvent struct {
+	Step     string
+	State    string
+	Resource string
+	Error    string
+}
+
+// StackRequest is the body of POST /stacks.
+type StackRequest struct {
+	Name        string `json:"name"`
+	Environment string `json:"environment"`
+}
+
+// StackStatusResponse is the body of GET /stacks/{name}/status. Status is
+// missing while the first apply hasn't recorded anything yet.
+type StackStatusResponse struct {
+	Stack       string       `json:"stack"`
+	Environment string       `json:"environment,omitempty"`
+	Job         *Job         `json:"job,omitempty"`
+	Status      *StackStatus `json:"status,omitempty"`
+}
+
+// Server exposes apply, destroy and status of the stacks of one config
+// file over HTTP. Every stack name in a request is applied like --stack, so
+// each stack gets its own resources and state file. Jobs are only kept in
+// memory.
+type Server struct {
+	logger *log.Logger
+	opts   GlobalOptions
+	token  string
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	latest   map[string]*Job
+	watchers map[string]map[chan JobEvent]struct{}
+	wg       sync.WaitGroup
+}
+
+func NewServer(logger *log.Logger, opts GlobalOptions, token string) *Server {
+	return &Server{
+		logger:   logger,
+		opts:     opts,
+		token:    token,
+		jobs:     map[string]*Job{},
+		latest:   map[string]*Job{},
+		watchers: map[string]map[chan JobEvent]struct{}{},
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /stacks", s.handleApply)
+	mux.HandleFunc("DELETE /stacks/{name}", s.handleDestroy)
+	mux.HandleFunc("GET /stacks/{name}/status", s.handleStatus)
+	mux.HandleFunc("GET /jobs", s.handleJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	return s.authenticate(mux)
+}
+
+// authenticate requires the bearer token on every request when one is set.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.token != "" && (!ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1) {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// stackOptions returns the options of the stack, or an error for a name
+// that can't be used.
+func (s *Server) stackOptions(name, env string) (*GlobalOptions, error) {
+	if !stackNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("stack name %q must be 1 to 32 letters, digits or hyphens", name)
+	}
+	opts := s.opts
+	opts.Stack, opts.Env = name, env
+	return &opts, nil
+}
+
+// loadServedConfig loads the config of a stack and checks that its
+// resources and state can't collide with those of the other stacks.
+func loadServedConfig(opts *GlobalOptions) (*Config, error) {
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(cfg.Naming.Template, "{stack}") {
+		return nil, fmt.Errorf("naming.template %q must contain {stack} to serve several stacks", cfg.Naming.Template)
+	}
+	if cfg.State.Bucket != "" && cfg.State.Key != cfg.ResourceName(ResourceState)+".json" {
+		return nil, errors.New("state.key must be left to the naming template to serve several stacks")
+	}
+	return cfg, nil
+}
+
+// StartApply starts an apply of the stack in the background. A config that
+// can't be applied fails right away rather than the job.
+func (s *Server) StartApply(name, env string) (*Job, error) {
+	opts, err := s.stackOptions(name, env)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadServedConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	return s.start("apply", opts, func(ctx context.Context, logger *log.Logger, job *Job, progress Progress) error {
+		outputs, err := serveApply(ctx, logger, opts, progress)
+		s.mu.Lock()
+		job.Outputs = outputs
+		s.mu.Unlock()
+		return err
+	})
+}
+
+// StartDestroy starts a destroy of the stack in the background.
+func (s *Server) StartDestroy(name, env string) (*Job, error) {
+	opts, err := s.stackOptions(name, env)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := loadServedConfig(opts); err != nil {
+		return nil, err
+	}
+
+	return s.start("destroy", opts, func(ctx context.Context, logger *log.Logger, job *Job, progress Progress) error {
+		return serveDestroy(ctx, logger, opts)
+	})
+}
+
+func (s *Server) handleApply(w http.ResponseWriter, r *http.Request) {
+	var request StackRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error parsing request: %w", err))
+		return
+	}
+	job, err := s.StartApply(request.Name, request.Environment)
+	if err != nil {
+		writeError(w, startErrorCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleDestroy(w http.ResponseWriter, r *http.Request) {
+	job, err := s.StartDestroy(r.PathValue("name"), r.URL.Query().Get("environment"))
+	if err != nil {
+		writeError(w, startErrorCode(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+// startErrorCode is the status of a job that couldn't be started: a
+// conflict with the running job, or a bad request.
+func startErrorCode(err error) int {
+	if errors.Is(err, ErrJobRunning) {
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	opts, err := s.stackOptions(r.PathValue("name"), r.URL.Query().Get("environment"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	cfg, err := loadServedConfig(opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := StackStatusResponse{Stack: opts.Stack, Environment: opts.Env}
+	s.mu.Lock()
+	if job := s.latest[opts.StateFile()]; job != nil {
+		response.Job = job.snapshot()
+	}
+	s.mu.Unlock()
+
+	clients, err := NewClients(r.Context(), s.logger, cfg, opts)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	state, err := OpenState(r.Context(), s.logger, cfg, opts, clients)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if state.IsEmpty() {
+		if response.Job == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no stack %s recorded in %s", opts.Stack, state.Location()))
+			return
+		}
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+	if response.Status, err = CollectStatus(r.Context(), clients, state); err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.snapshot())
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.Before(jobs[j].StartedAt)
+	})
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	job, ok := s.jobs[r.PathValue("id")]
+	if ok {
+		job = job.snapshot()
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// start runs the job in the background, unless a job of the same stack is
+// still running. The job runs to the end even when the server shuts down.
+func (s *Server) start(command string, opts *GlobalOptions, run func(ctx context.Context, logger *log.Logger, job *Job, progress Progress) error) (*Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	key := opts.StateFile()
+	if running := s.latest[key]; running != nil && running.Status == JobRunning {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%s of stack %s is still running as job %s: %w", running.Command, opts.Stack, running.ID, ErrJobRunning)
+	}
+	job := &Job{
+		ID:          id,
+		Command:     command,
+		Stack:       opts.Stack,
+		Environment: opts.Env,
+		Status:      JobRunning,
+		StartedAt:   time.Now(),
+		Log:         []string{},
+	}
+	s.jobs[id], s.latest[key] = job, job
+	snapshot := job.snapshot()
+	s.mu.Unlock()
+
+	logger := log.New(io.MultiWriter(s.logger.Writer(), &jobLog{server: s, key: key, job: job}), "job "+id+": ", log.LstdFlags)
+	progress := jobProgress{Progress: PlainProgress{}, server: s, key: key, jobID: id}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := run(context.Background(), logger, job, progress)
+		if err != nil {
+			logger.Printf("%s of stack %s failed: %v", command, opts.Stack, err)
+		} else {
+			logger.Printf("%s of stack %s finished", command, opts.Stack)
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		finishedAt := time.Now()
+		job.FinishedAt = &finishedAt
+		job.Status = JobSucceeded
+		if err != nil {
+			job.Status, job.Error = JobFailed, err.Error()
+		}
+		s.publish(key, JobEvent{JobID: id, Finished: job.snapshot()})
+	}()
+	logger.Printf("Started %s of stack %s", command, opts.Stack)
+	return snapshot, nil
+}
+
+// Wait blocks until every running job finished.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// Subscribe sends the events of the jobs of the stack recorded in the state
+// file key until cancel is called. Events are dropped rather than holding up
+// a job when the watcher doesn't keep up.
+func (s *Server) Subscribe(key string) (events <-chan JobEvent, cancel func()) {
+	ch := make(chan JobEvent, JobEventBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.watchers[key] == nil {
+		s.watchers[key] = map[chan JobEvent]struct{}{}
+	}
+	s.watchers[key][ch] = struct{}{}
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.watchers[key], ch)
+	}
+}
+
+// publish sends event to the watchers of the stack. It must be called with
+// the mutex held.
+func (s *Server) publish(key string, event JobEvent) {
+	event.Time = time.Now()
+	for ch := range s.watchers[key] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// snapshot copies the job, which is only safe to read under the mutex of
+// the server while it runs.
+func (j *Job) snapshot() *Job {
+	snapshot := *j
+	snapshot.Log = slices.Clone(j.Log)
+	return &snapshot
+}
+
+// jobLog keeps the last log lines of a job and sends them to the watchers
+// of its stack.
+type jobLog struct {
+	server *Server
+	key    string
+	job    *Job
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.server.mu.Lock()
+	defer l.server.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		l.job.Log = append(l.job.Log, line)
+		l.server.publish(l.key, JobEvent{JobID: l.job.ID, Log: line})
+	}
+	if len(l.job.Log) > JobLogLines {
+		l.job.Log = l.job.Log[len(l.job.Log)-JobLogLines:]
+	}
+	return len(p), nil
+}
+
+// jobProgress sends the start and the end of every step to the watchers of
+// the stack.
+type jobProgress struct {
+	Progress
+	server *Server
+	key    string
+	jobID  string
+}
+
+func (p jobProgress) Track(step string, fn func() (string, error)) error {
+	p.send(StepEvent{Step: step, State: StepStarted})
+	var resource string
+	err := p.Progress.Track(step, func() (string, error) {
+		var err error
+		resource, err = fn()
+		return resource, err
+	})
+	if err != nil {
+		p.send(StepEvent{Step: step, State: StepFailed, Error: err.Error()})
+		return err
+	}
+	p.send(StepEvent{Step: step, State: StepDone, Resource: resource})
+	return nil
+}
+
+func (p jobProgress) send(event StepEvent) {
+	p.server.mu.Lock()
+	defer p.server.mu.Unlock()
+	p.server.publish(p.key, JobEvent{JobID: p.jobID, Step: &event})
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("error generating job ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// serveApply creates or updates a stack like apply does without a terminal,
+// always checking the quotas before creating it. Progress tracks the steps
+// of a new stack.
+func serveApply(ctx context.Context, logger *log.Logger, opts *GlobalOptions, progress Progress) (*StackOutputs, error) {
+	cfg, err := loadServedConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	ctx, cancelFunc := context.WithTimeout(ctx, ApplyTimeout)
+	defer cancelFunc()
+
+	clients, err := NewClients(ctx, logger, cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "apply")
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	state, err := OpenState(ctx, logger, cfg, opts, clients)
+	if err != nil {
+		return nil, err
+	}
+	if err := ResolveAMI(ctx, logger, clients, cfg); err != nil {
+		return nil, err
+	}
+	if err := ResolveVPC(ctx, logger, clients, cfg, state); err != nil {
+		return nil, err
+	}
+	if state.IsEmpty() {
+		if err := CheckCapacityQuotas(ctx, logger, clients, cfg); err != nil {
+			return nil, err
+		}
+		if err := CheckNameConflicts(ctx, logger, clients, cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	notifier := NewNotifier(logger, cfg, "apply")
+	notifier.Started()
+	if state.IsEmpty() {
+		err = Apply(ctx, logger, clients, cfg, state, NewNotifyProgress(notifier, NewHookProgress(logger, cfg, state, progress)))
+	} else {
+		logger.Printf("Updating existing stack recorded in %s", state.Location())
+		err = Update(ctx, logger, clients, cfg, state)
+	}
+	if err != nil {
+		notifier.Failed(err)
+		return nil, err
+	}
+	notifier.Succeeded(state)
+
+	outputs := NewStackOutputs(cfg, state)
+	logger.Print(outputs.URL)
+	return &outputs, nil
+}
+
+// serveDestroy deletes a stack like destroy --yes does.
+func serveDestroy(ctx context.Context, logger *log.Logger, opts *GlobalOptions) error {
+	cfg, state, clients, err := LoadStack(ctx, logger, opts)
+	if err != nil {
+		return err
+	}
+	lock, err := AcquireLock(ctx, logger, clients, cfg, opts.StateFile(), "destroy")
+	if err != nil {
+		return err
+	}
+	defer releaseLock(ctx, logger, lock)
+
+	ctx, cancelFunc := context.WithTimeout(ctx, DestroyTimeout)
+	defer cancelFunc()
+
+	notifier := NewNotifier(logger, cfg, "destroy")
+	notifier.Started()
+	if err := Destroy(ctx, logger, clients, cfg, state); err != nil {
+		notifier.Failed(err)
+		return err
+	}
+	notifier.Succeeded(state)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	WriteJSON(w, v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+func runServe(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	opts.Register(fs)
+	addr := fs.String("addr", DefaultServeAddr, "address to serve the HTTP API on, empty to only serve gRPC")
+	grpcAddr := fs.String("grpc-addr", "", "address to serve the gRPC API of api/stacks.proto on, e.g. :9090")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if opts.StatePath != "" || opts.Stack != "" || opts.Env != "" {
+		return errors.New("--state, --stack and --env can't be used with serve, every request names its stack and environment")
+	}
+	if *addr == "" && *grpcAddr == "" {
+		return errors.New("--addr or --grpc-addr must be set")
+	}
+
+	token := os.Getenv(EnvAPIToken)
+	if token == "" {
+		logger.Printf("%s is not set, the API accepts requests without a token", EnvAPIToken)
+	}
+	server := NewServer(logger, opts, token)
+
+	// SIGINT and SIGTERM stop accepting requests, running jobs are left to
+	// finish. A second signal kills the server.
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	go func() {
+		<-ctx.Done()
+		stopSignals()
+	}()
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	if *addr != "" {
+		listener, err := net.Listen("tcp", *addr)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %w", *addr, err)
+		}
+		httpServer := &http.Server{Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		group.Go(func() error {
+			logger.Printf("Serving the HTTP API on http://%s", listener.Addr())
+			if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return fmt.Errorf("error serving the HTTP API: %w", err)
+			}
+			return nil
+		})
+		group.Go(func() error {
+			<-groupCtx.Done()
+			shutdownCtx, cancelFunc := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancelFunc()
+			return httpServer.Shutdown(shutdownCtx)
+		})
+	}
+	if *grpcAddr != "" {
+		listener, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			return fmt.Errorf("error listening on %s: %w", *grpcAddr, err)
+		}
+		grpcServer := NewGRPCServer(server, groupCtx.Done())
+		group.Go(func() error {
+			logger.Printf("Serving the gRPC API on %s", listener.Addr())
+			if err := grpcServer.Serve(listener); err != nil {
+				return fmt.Errorf("error serving the gRPC API: %w", err)
+			}
+			return nil
+		})
+		group.Go(func() error {
+			<-groupCtx.Done()
+			grpcServer.GracefulStop()
+			return nil
+		})
+	}
+
+	err := group.Wait()
+	logger.Println("Waiting for running jobs to finish")
+	server.Wait()
+	return err
+}
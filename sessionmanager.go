@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// SSMManagedInstanceCorePolicyARN lets the SSM agent on the instances
+	// register with Systems Manager and serve sessions.
+	SSMManagedInstanceCorePolicyARN = "arn:aws:iam::aws:policy/AmazonSSMManagedInstanceCore"
+	// SessionManagerPlugin is the executable the AWS CLI runs to attach the
+	// terminal to a session, installed next to the CLI.
+	SessionManagerPlugin = "session-manager-plugin"
+)
+
+// AllowsPort reports whether the rule lets traffic in on the TCP port.
+func (r SecurityGroupRule) AllowsPort(port int32) bool {
+	switch r.protocol() {
+	case ProtocolAll:
+		return true
+	case "tcp":
+		return r.FromPort <= port && port <= r.toPort()
+	default:
+		return false
+	}
+}
+
+// AttachSessionManagerPolicy lets the instances of a stack that already has
+// an instance role serve sessions, after the mode was turned on in the
+// config. Attaching the policy again is a no-op.
+func AttachSessionManagerPolicy(ctx context.Context, logger *log.Logger, iamClient *iam.Client, cfg *Config, state *State) error {
+	if !cfg.SessionManagerOnly || state.InstanceRoleName == "" {
+		return nil
+	}
+	if _, err := iamClient.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+		RoleName:  aws.String(state.InstanceRoleName),
+		PolicyArn: aws.String(SSMManagedInstanceCorePolicyARN),
+	}); err != nil {
+		return fmt.Errorf("error attaching %s to instance role: %w", SSMManagedInstanceCorePolicyARN, err)
+	}
+	logger.Printf("Instance role %s can serve Session Manager sessions", state.InstanceRoleName)
+	return nil
+}
+
+// StartSession opens a Session Manager session to the instance and hands it
+// to the session manager plugin, which attaches the terminal until the
+// session ends. Interrupts go to the remote shell rather than to the tool.
+func StartSession(ctx context.Context, logger *log.Logger, ssmClient *ssm.Client, region, profile, instanceID string) error {
+	plugin, err := exec.LookPath(SessionManagerPlugin)
+	if err != nil {
+		return fmt.Errorf("%s is not installed, see https://docs.aws.amazon.com/systems-manager/latest/userguide/session-manager-working-with-install-plugin.html: %w", SessionManagerPlugin, err)
+	}
+
+	input := &ssm.StartSessionInput{Target: aws.String(instanceID)}
+	output, err := ssmClient.StartSession(ctx, input)
+	if err != nil {
+		return fmt.Errorf("error starting session to %s: %w", instanceID, err)
+	}
+	logger.Printf("Session %s started to %s", aws.StringValue(output.SessionId), instanceID)
+
+	session, err := json.Marshal(map[string]string{
+		"SessionId":  aws.StringValue(output.SessionId),
+		"TokenValue": aws.StringValue(output.TokenValue),
+		"StreamUrl":  aws.StringValue(output.StreamUrl),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding session: %w", err)
+	}
+	parameters, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("error encoding session parameters: %w", err)
+	}
+
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	cmd := exec.Command(plugin, string(session), region, "StartSession", profile, string(parameters), fmt.Sprintf("https://ssm.%s.amazonaws.com", region))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running %s: %w", SessionManagerPlugin, err)
+	}
+	return nil
+}
+
+func runConnect(ctx context.Context, logger *log.Logger, args []string) error {
+	instanceIDs, args := splitInstanceIDs(args)
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("connect", flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(instanceIDs) > 1 {
+		return errors.New("connect takes at most one instance ID")
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	var instanceID string
+	if len(instanceIDs) == 1 {
+		instanceID = instanceIDs[0]
+	} else if instanceID, err = PickInServiceInstance(ctx, clients.AutoScaling, state.AutoScalingGroupName); err != nil {
+		return err
+	}
+	return StartSession(ctx, logger, clients.SSM, cfg.Region, opts.Profile, instanceID)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	SmokeTestPollInterval   = 10 * time.Second
+	SmokeTestRequestTimeout = 5 * time.Second
+	DefaultSmokeTestTimeout = 300
+)
+
+// SmokeTestConfig makes apply wait until the load balancer serves the
+// application before reporting success.
+type SmokeTestConfig struct {
+	Enabled        bool   `json:"enabled"`
+	Path           string `json:"path"`
+	ExpectedStatus int    `json:"expectedStatus"`
+	TimeoutSeconds int    `json:"timeoutSeconds"`
+}
+
+// RunsSmokeTest reports whether apply runs the smoke test. It is skipped for
+// an internal load balancer, which isn't reachable from outside of the VPC,
+// for a listener requiring client certificates or a login, and for gRPC
+// targets, which don't answer plain HTTP requests, and in ECS mode, where
+// the targets only come with an ECS service deployed later.
+func (c *Config) RunsSmokeTest() bool {
+	return c.SmokeTest.Enabled && !c.LoadBalancer.Internal() && c.Listener.MutualTLS == nil && c.Listener.Authentication == nil && !c.TargetGroup.UsesGRPC() && c.ECS == nil
+}
+
+// SmokeTestURL is the URL polled by the smoke test.
+func SmokeTestURL(smokeConfig SmokeTestConfig, dnsName string, listenerConfig ListenerConfig) string {
+	return ListenerURL(dnsName, listenerConfig) + smokeConfig.Path
+}
+
+// ListenerURL is the base URL the listener serves, without the port when it
+// is the default one of the scheme.
+func ListenerURL(dnsName string, listenerConfig ListenerConfig) string {
+	scheme := listenerConfig.URLScheme()
+	if (scheme == "http" && listenerConfig.Port == 80) || (scheme == "https" && listenerConfig.Port == 443) {
+		return fmt.Sprintf("%s://%s", scheme, dnsName)
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, dnsName, listenerConfig.Port)
+}
+
+// SmokeTest polls url until it answers with the expected status. It fails
+// when that doesn't happen within the configured timeout; instances need a
+// few minutes to boot and pass the health checks, so errors and other
+// statuses are retried until then.
+func SmokeTest(ctx context.Context, logger *log.Logger, smokeConfig SmokeTestConfig, url string) error {
+	ctx, cancelFunc := context.WithTimeout(ctx, time.Duration(smokeConfig.TimeoutSeconds)*time.Second)
+	defer cancelFunc()
+
+	client := &http.Client{Timeout: SmokeTestRequestTimeout}
+	ticker := time.NewTicker(SmokeTestPollInterval)
+	defer ticker.Stop()
+
+	var lastResult string
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("error creating smoke test request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == smokeConfig.ExpectedStatus {
+				logger.Printf("Smoke test passed, %s answered %s", url, resp.Status)
+				return nil
+			}
+			lastResult = resp.Status
+		} else {
+			lastResult = err.Error()
+		}
+		logger.Printf("Waiting for %s to answer %d, got %s", url, smokeConfig.ExpectedStatus, lastResult)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("smoke test failed, %s did not answer %d within %ds, last result: %s", url, smokeConfig.ExpectedStatus, smokeConfig.TimeoutSeconds, lastResult)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// StackSummary describes a deployed stack by its VPC.
+type StackSummary struct {
+	Stack       string
+	Environment string
+	VPCID       string
+	CIDRBlock   string
+}
+
+// ListStacks finds the stacks of the region through the Stack tag of their
+// VPCs, sorted by stack and environment.
+func ListStacks(ctx context.Context, ec2Client *ec2.Client) ([]StackSummary, error) {
+	var stacks []StackSummary
+	paginator := ec2.NewDescribeVpcsPaginator(ec2Client, &ec2.DescribeVpcsInput{
+		Filters: []types.Filter{
+			{Name: aws.String("tag-key"), Values: []string{TagStack}},
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing VPCs: %w", err)
+		}
+		for _, vpc := range page.Vpcs {
+			summary := StackSummary{
+				VPCID:     aws.StringValue(vpc.VpcId),
+				CIDRBlock: aws.StringValue(vpc.CidrBlock),
+			}
+			for _, tag := range vpc.Tags {
+				switch aws.StringValue(tag.Key) {
+				case TagStack:
+					summary.Stack = aws.StringValue(tag.Value)
+				case TagEnvironment:
+					summary.Environment = aws.StringValue(tag.Value)
+				}
+			}
+			stacks = append(stacks, summary)
+		}
+	}
+
+	sort.Slice(stacks, func(i, j int) bool {
+		if stacks[i].Stack != stacks[j].Stack {
+			return stacks[i].Stack < stacks[j].Stack
+		}
+		return stacks[i].Environment < stacks[j].Environment
+	})
+	return stacks, nil
+}
+
+func PrintStacks(w io.Writer, stacks []StackSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STACK\tENV\tVPC\tCIDR")
+	for _, stack := range stacks {
+		env := stack.Environment
+		if env == "" {
+			env = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", stack.Stack, env, stack.VPCID, stack.CIDRBlock)
+	}
+	return tw.Flush()
+}
+
+func runList(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	clients, err := NewClients(ctx, logger, cfg, &opts)
+	if err != nil {
+		return err
+	}
+
+	stacks, err := ListStacks(ctx, clients.EC2)
+	if err != nil {
+		return err
+	}
+	if len(stacks) == 0 {
+		logger.Printf("No stacks found in %s", cfg.Region)
+		return nil
+	}
+
+	return PrintStacks(os.Stdout, stacks)
+}
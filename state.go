@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	DefaultStatePath = "state.json"
+)
+
+// State records the identifiers of every resource created for the stack, so
+// later commands can find, update and export them.
+type State struct {
+	VPCID string `json:"vpcId,omitempty"`
+	// ExistingVPC is set when VPCID, InternetGatewayID and RouteTableID
+	// weren't created by the stack, so destroy leaves them in place.
+	ExistingVPC       bool     `json:"existingVpc,omitempty"`
+	FlowLogID         string   `json:"flowLogId,omitempty"`
+	FlowLogsRoleName  string   `json:"flowLogsRoleName,omitempty"`
+	FlowLogGroupName  string   `json:"flowLogGroupName,omitempty"`
+	InternetGatewayID string   `json:"internetGatewayId,omitempty"`
+	RouteTableID      string   `json:"routeTableId,omitempty"`
+	SubnetIDs         []string `json:"subnetIds,omitempty"`
+	PrivateSubnetIDs  []string `json:"privateSubnetIds,omitempty"`
+	// ExistingSubnets is set when SubnetIDs are the vpc.subnetIds of the
+	// autoscaling groups, which destroy leaves in place.
+	ExistingSubnets         bool     `json:"existingSubnets,omitempty"`
+	NetworkACLID            string   `json:"networkAclId,omitempty"`
+	EndpointSecurityGroupID string   `json:"endpointSecurityGroupId,omitempty"`
+	VPCEndpointIDs          []string `json:"vpcEndpointIds,omitempty"`
+	SecurityGroupID         string   `json:"securityGroupId,omitempty"`
+	// ExistingSecurityGroup is set when SecurityGroupID is the
+	// securityGroup.existing group, which destroy leaves in place.
+	ExistingSecurityGroup         bool   `json:"existingSecurityGroup,omitempty"`
+	LaunchTemplateID              string `json:"launchTemplateId,omitempty"`
+	LaunchTemplateVersion         string `json:"launchTemplateVersion,omitempty"`
+	LaunchTemplateDataHash        string `json:"launchTemplateDataHash,omitempty"`
+	ExistingLaunchTemplate        bool   `json:"existingLaunchTemplate,omitempty"`
+	TargetGroupARN                string `json:"targetGroupArn,omitempty"`
+	CanaryTargetGroupARN          string `json:"canaryTargetGroupArn,omitempty"`
+	AutoScalingGroupName          string `json:"autoScalingGroupName,omitempty"`
+	ScalingPolicyName             string `json:"scalingPolicyName,omitempty"`
+	CanaryAutoScalingGroupName    string `json:"canaryAutoScalingGroupName,omitempty"`
+	ECSClusterARN                 string `json:"ecsClusterArn,omitempty"`
+	InstanceRoleName              string `json:"instanceRoleName,omitempty"`
+	PlacementGroupName            string `json:"placementGroupName,omitempty"`
+	VolumeKeyARN                  string `json:"volumeKeyArn,omitempty"`
+	VolumeKeyAlias                string `json:"volumeKeyAlias,omitempty"`
+	VolumeKeyGrantID              string `json:"volumeKeyGrantId,omitempty"`
+	BastionSecurityGroupID        string `json:"bastionSecurityGroupId,omitempty"`
+	BastionInstanceID             string `json:"bastionInstanceId,omitempty"`
+	CapacityProviderName          string `json:"capacityProviderName,omitempty"`
+	CodeDeployApplicationName     string `json:"codeDeployApplicationName,omitempty"`
+	CodeDeployDeploymentGroupName string `json:"codeDeployDeploymentGroupName,omitempty"`
+	CodeDeployRoleName            string `json:"codeDeployRoleName,omitempty"`
+	// ElasticIPs are the allocated addresses by name.
+	ElasticIPs map[string]ElasticIPState `json:"elasticIps,omitempty"`
+	// InstanceConnectEndpointID and its security group are only set with an
+	// EC2 Instance Connect Endpoint.
+	InstanceConnectEndpointID      string `json:"instanceConnectEndpointId,omitempty"`
+	InstanceConnectSecurityGroupID string `json:"instanceConnectSecurityGroupId,omitempty"`
+	// LifecycleEvents is only set with lifecycle events.
+	LifecycleEvents *LifecycleEventsState `json:"lifecycleEvents,omitempty"`
+	// LogGroupNames are the log groups the CloudWatch agent ships the logs
+	// of the instances to.
+	LogGroupNames []string `json:"logGroupNames,omitempty"`
+	// SecretARNs are the published parameters and secrets by path.
+	SecretARNs map[string]string `json:"secretArns,omitempty"`
+	// CanaryWeight is the percentage of the traffic the canary target group
+	// gets.
+	CanaryWeight        int32          `json:"canaryWeight,omitempty"`
+	LoadBalancerARN     string         `json:"loadBalancerArn,omitempty"`
+	LoadBalancerDNSName string         `json:"loadBalancerDnsName,omitempty"`
+	ListenerARN         string         `json:"listenerArn,omitempty"`
+	TrustStoreARN       string         `json:"trustStoreArn,omitempty"`
+	LambdaTargets       []LambdaTarget `json:"lambdaTargets,omitempty"`
+	HostRuleARNs        []string       `json:"hostRuleArns,omitempty"`
+	// AdditionalListenerARNs are the listeners next to ListenerARN by port.
+	AdditionalListenerARNs map[int32]string `json:"additionalListenerArns,omitempty"`
+	// Maintenance is set while the listener serves the maintenance page.
+	Maintenance bool `json:"maintenance,omitempty"`
+	// CustomResources maps registered custom steps to what they created.
+	CustomResources map[string]string `json:"customResources,omitempty"`
+	// CompletedSteps are the steps of a first apply that hasn't finished yet
+	// that are done, in the order they finished.
+	CompletedSteps []string `json:"completedSteps,omitempty"`
+
+	backend StateBackend
+	mu      sync.Mutex
+}
+
+// StateBackend stores the encoded state.
+type StateBackend interface {
+	// Read returns the stored state, or nil when none was stored yet.
+	Read() ([]byte, error)
+	Write(data []byte) error
+	// String describes where the state is stored, for messages.
+	String() string
+}
+
+// FileBackend keeps the state in a local file.
+type FileBackend struct {
+	Path string
+}
+
+func (b FileBackend) Read() ([]byte, error) {
+	data, err := os.ReadFile(b.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	return data, nil
+}
+
+func (b FileBackend) Write(data []byte) error {
+	if err := os.WriteFile(b.Path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing state file %s: %w", b.Path, err)
+	}
+	return nil
+}
+
+func (b FileBackend) String() string {
+	return b.Path
+}
+
+// LoadState reads the state from backend. When nothing is stored yet, it
+// yields an empty state that will be written to backend on the first Save.
+func LoadState(backend StateBackend) (*State, error) {
+	state := &State{backend: backend}
+
+	data, err := backend.Read()
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("error parsing state %s: %w", backend, err)
+	}
+
+	return state, nil
+}
+
+// Save writes the state back to the backend it was loaded from.
+func (s *State) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %w", err)
+	}
+
+	return s.backend.Write(data)
+}
+
+// Location describes where the state is stored.
+func (s *State) Location() string {
+	return s.backend.String()
+}
+
+// Record applies fn to the state and saves it. Steps running concurrently
+// record their resources through it so updates and writes don't interleave.
+func (s *State) Record(fn func(*State)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s)
+	return s.Save()
+}
+
+// IsEmpty reports whether no resource has been recorded yet.
+func (s *State) IsEmpty() bool {
+	return s.VPCID == ""
+}
+
+// HasLogGroup reports whether the log group was created for the stack.
+func (s *State) HasLogGroup(name string) bool {
+	return slices.Contains(s.LogGroupNames, name)
+}
+
+// CurrentLaunchTemplateVersion returns the launch template version the
+// autoscaling group tracks. States written before versions were recorded
+// always tracked $Latest.
+func (s *State) CurrentLaunchTemplateVersion() string {
+	if s.LaunchTemplateVersion == "" {
+		return AWSLaunchTemplateVersion
+	}
+	return s.LaunchTemplateVersion
+}
+
+// VolumeKeyID returns the key ID part of the volume key ARN.
+func (s *State) VolumeKeyID() string {
+	return s.VolumeKeyARN[strings.LastIndex(s.VolumeKeyARN, "/")+1:]
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	StatusActivityCount = 10
+)
+
+// StackStatus is a point-in-time view of the live health of a stack.
+type StackStatus struct {
+	LoadBalancerState   string   `json:"loadBalancerState"`
+	LoadBalancerDNSName string   `json:"loadBalancerDnsName"`
+	Capacity            Capacity `json:"capacity"`
+	Maintenance         bool     `json:"maintenance"`
+	// CanaryWeight is the share of the traffic of the canary target group,
+	// -1 without one.
+	CanaryWeight       int32                `json:"canaryWeight"`
+	SuspendedProcesses []string             `json:"suspendedProcesses"`
+	Instances          []InstanceStatus     `json:"instances"`
+	Activities         []ScalingActivity    `json:"activities"`
+	Alarms             []AlarmStatus        `json:"alarms"`
+	Subscriptions      []SubscriptionStatus `json:"subscriptions,omitempty"`
+}
+
+// InServiceInstances counts the instances of the autoscaling group in
+// service.
+func (s *StackStatus) InServiceInstances() int {
+	count := 0
+	for _, instance := range s.Instances {
+		if instance.LifecycleState == string(autoscalingTypes.LifecycleStateInService) {
+			count++
+		}
+	}
+	return count
+}
+
+// HealthyTargets counts the instances that are healthy targets of the
+// target group.
+func (s *StackStatus) HealthyTargets() int {
+	count := 0
+	for _, instance := range s.Instances {
+		if instance.TargetHealth == string(elbTypes.TargetHealthStateEnumHealthy) {
+			count++
+		}
+	}
+	return count
+}
+
+type InstanceStatus struct {
+	InstanceID       string `json:"instanceId"`
+	AvailabilityZone string `json:"availabilityZone"`
+	LifecycleState   string `json:"lifecycleState"`
+	HealthStatus     string `json:"healthStatus"`
+	TargetHealth     string `json:"targetHealth,omitempty"`
+	TargetReason     string `json:"targetReason,omitempty"`
+}
+
+type ScalingActivity struct {
+	StartTime   time.Time `json:"startTime"`
+	StatusCode  string    `json:"statusCode"`
+	Description string    `json:"description"`
+}
+
+type AlarmStatus struct {
+	Name   string `json:"name"`
+	State  string `json:"state"`
+	Reason string `json:"reason"`
+}
+
+// CollectStatus queries the autoscaling group, target group, load balancer,
+// the alarms of the scaling policy and the email subscriptions recorded in
+// state.
+func CollectStatus(ctx context.Context, clients *Clients, state *State) (*StackStatus, error) {
+	status := &StackStatus{Maintenance: state.Maintenance, CanaryWeight: -1}
+	if state.CanaryTargetGroupARN != "" {
+		status.CanaryWeight = state.CanaryWeight
+	}
+
+	if state.LoadBalancerARN != "" {
+		lbOutput, err := clients.ELB.DescribeLoadBalancers(ctx, &elasticloadbalancingv2.DescribeLoadBalancersInput{
+			LoadBalancerArns: []string{state.LoadBalancerARN},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing load balancer: %w", err)
+		}
+		if len(lbOutput.LoadBalancers) > 0 {
+			lb := lbOutput.LoadBalancers[0]
+			status.LoadBalancerDNSName = aws.StringValue(lb.DNSName)
+			if lb.State != nil {
+				status.LoadBalancerState = string(lb.State.Code)
+			}
+		}
+	}
+
+	targetHealth := map[string][2]string{}
+	if state.TargetGroupARN != "" {
+		healthOutput, err := clients.ELB.DescribeTargetHealth(ctx, &elasticloadbalancingv2.DescribeTargetHealthInput{
+			TargetGroupArn: aws.String(state.TargetGroupARN),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing target health: %w", err)
+		}
+		for _, description := range healthOutput.TargetHealthDescriptions {
+			if description.Target == nil || description.TargetHealth == nil {
+				continue
+			}
+			targetHealth[aws.StringValue(description.Target.Id)] = [2]string{
+				string(description.TargetHealth.State),
+				string(description.TargetHealth.Reason),
+			}
+		}
+	}
+
+	if state.AutoScalingGroupName != "" {
+		asgOutput, err := clients.AutoScaling.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []string{state.AutoScalingGroupName},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing autoscaling group: %w", err)
+		}
+		if len(asgOutput.AutoScalingGroups) > 0 {
+			group := asgOutput.AutoScalingGroups[0]
+			status.Capacity = Capacity{
+				Min:     aws.Int32Value(group.MinSize),
+				Max:     aws.Int32Value(group.MaxSize),
+				Desired: aws.Int32Value(group.DesiredCapacity),
+			}
+			for _, process := range group.SuspendedProcesses {
+				status.SuspendedProcesses = append(status.SuspendedProcesses, aws.StringValue(process.ProcessName))
+			}
+			for _, instance := range group.Instances {
+				instanceID := aws.StringValue(instance.InstanceId)
+				health := targetHealth[instanceID]
+				status.Instances = append(status.Instances, InstanceStatus{
+					InstanceID:       instanceID,
+					AvailabilityZone: aws.StringValue(instance.AvailabilityZone),
+					LifecycleState:   string(instance.LifecycleState),
+					HealthStatus:     aws.StringValue(instance.HealthStatus),
+					TargetHealth:     health[0],
+					TargetReason:     health[1],
+				})
+			}
+		}
+
+		activityOutput, err := clients.AutoScaling.DescribeScalingActivities(ctx, &autoscaling.DescribeScalingActivitiesInput{
+			AutoScalingGroupName: aws.String(state.AutoScalingGroupName),
+			MaxRecords:           aws.Int32(StatusActivityCount),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error describing scaling activities: %w", err)
+		}
+		for _, activity := range activityOutput.Activities {
+			status.Activities = append(status.Activities, ScalingActivity{
+				StartTime:   aws.TimeValue(activity.StartTime),
+				StatusCode:  string(activity.StatusCode),
+				Description: aws.StringValue(activity.Description),
+			})
+		}
+
+		alarms, err := collectAlarms(ctx, clients, state)
+		if err != nil {
+			return nil, err
+		}
+		status.Alarms = alarms
+	}
+
+	subscriptions, err := CollectSubscriptions(ctx, clients.SNS, state)
+	if err != nil {
+		return nil, err
+	}
+	status.Subscriptions = subscriptions
+
+	return status, nil
+}
+
+func collectAlarms(ctx context.Context, clients *Clients, state *State) ([]AlarmStatus, error) {
+	policyOutput, err := clients.AutoScaling.DescribePolicies(ctx, &autoscaling.DescribePoliciesInput{
+		AutoScalingGroupName: aws.String(state.AutoScalingGroupName),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing autoscaling policies: %w", err)
+	}
+
+	var alarmNames []string
+	for _, policy := range policyOutput.ScalingPolicies {
+		for _, alarm := range policy.Alarms {
+			alarmNames = append(alarmNames, aws.StringValue(alarm.AlarmName))
+		}
+	}
+	if len(alarmNames) == 0 {
+		return nil, nil
+	}
+
+	alarmOutput, err := clients.CloudWatch.DescribeAlarms(ctx, &cloudwatch.DescribeAlarmsInput{
+		AlarmNames: alarmNames,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing alarms: %w", err)
+	}
+
+	alarms := make([]AlarmStatus, 0, len(alarmOutput.MetricAlarms))
+	for _, alarm := range alarmOutput.MetricAlarms {
+		alarms = append(alarms, AlarmStatus{
+			Name:   aws.StringValue(alarm.AlarmName),
+			State:  string(alarm.StateValue),
+			Reason: aws.StringValue(alarm.StateReason),
+		})
+	}
+
+	return alarms, nil
+}
+
+func PrintStatus(w io.Writer, status *StackStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Load balancer:\t%s\t%s\n", status.LoadBalancerState, status.LoadBalancerDNSName)
+	fmt.Fprintf(tw, "Capacity:\t%s\n", status.Capacity)
+	if status.CanaryWeight >= 0 {
+		fmt.Fprintf(tw, "Canary:\t%d%% of the traffic\n", status.CanaryWeight)
+	}
+	if status.Maintenance {
+		fmt.Fprintln(tw, "Maintenance:\ton")
+	}
+	if len(status.SuspendedProcesses) > 0 {
+		fmt.Fprintf(tw, "Suspended:\t%s\n", strings.Join(status.SuspendedProcesses, ", "))
+	}
+
+	fmt.Fprintln(tw, "\nINSTANCE\tZONE\tLIFECYCLE\tHEALTH\tTARGET\tREASON")
+	for _, instance := range status.Instances {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			instance.InstanceID, instance.AvailabilityZone, instance.LifecycleState,
+			instance.HealthStatus, orDash(instance.TargetHealth), orDash(instance.TargetReason))
+	}
+
+	fmt.Fprintln(tw, "\nALARM\tSTATE\tREASON")
+	for _, alarm := range status.Alarms {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", alarm.Name, alarm.State, alarm.Reason)
+	}
+
+	if len(status.Subscriptions) > 0 {
+		fmt.Fprintln(tw, "\nSUBSCRIPTION\tSTATE")
+		for _, subscription := range status.Subscriptions {
+			fmt.Fprintf(tw, "%s\t%s\n", subscription.Email, subscription.State)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nSTARTED\tSTATUS\tACTIVITY")
+	for _, activity := range status.Activities {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", activity.StartTime.Local().Format(time.DateTime), activity.StatusCode, activity.Description)
+	}
+
+	return tw.Flush()
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func runStatus(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	_, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	status, err := CollectStatus(ctx, clients, state)
+	if err != nil {
+		return err
+	}
+
+	return PrintStatus(os.Stdout, status)
+}
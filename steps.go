@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+)
+
+// StepContext is what a custom step gets to work with.
+type StepContext struct {
+	Logger  *log.Logger
+	Clients *Clients
+	Config  *Config
+	State   *State
+}
+
+// Step is a custom provisioning step, e.g. an SQS queue the application
+// needs. Apply creates the resource and returns its identifier, which is
+// recorded in state under the step name and handed back to Destroy.
+// DependsOn names the steps, built-in (see ApplySteps) or custom, that must
+// be done before Apply runs; the step may run alongside any other step.
+//
+// Steps are registered from an init function in a file added to this
+// package:
+//
+//	func init() {
+//		RegisterStep(queueStep{})
+//	}
+type Step interface {
+	Name() string
+	DependsOn() []string
+	Apply(ctx context.Context, stepContext StepContext) (string, error)
+	Destroy(ctx context.Context, stepContext StepContext, id string) error
+}
+
+var customSteps []Step
+
+// RegisterStep adds a custom step to every apply and destroy. It panics on
+// a duplicate name, as registration happens at init time.
+func RegisterStep(step Step) {
+	if slices.Contains(StepNames(), step.Name()) {
+		panic(fmt.Sprintf("step %q is already registered", step.Name()))
+	}
+	customSteps = append(customSteps, step)
+}
+
+// StepNames returns the names of the built-in and the custom steps.
+func StepNames() []string {
+	names := slices.Clone(ApplySteps)
+	for _, step := range customSteps {
+		names = append(names, step.Name())
+	}
+	return names
+}
+
+// OrderedSteps returns the custom steps so that every step comes after the
+// custom steps it depends on. Apply runs a custom step as soon as the steps
+// it depends on are done, update runs them after the built-in ones.
+func OrderedSteps() ([]Step, error) {
+	byName := make(map[string]Step, len(customSteps))
+	for _, step := range customSteps {
+		byName[step.Name()] = step
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int, len(byName))
+	ordered := make([]Step, 0, len(byName))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("custom steps depend on each other in a cycle through %q", name)
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dependency := range byName[name].DependsOn() {
+			if _, ok := byName[dependency]; ok {
+				if err := visit(dependency); err != nil {
+					return err
+				}
+				continue
+			}
+			if !slices.Contains(ApplySteps, dependency) {
+				return fmt.Errorf("step %q depends on unknown step %q", name, dependency)
+			}
+		}
+		marks[name] = visited
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// ApplyCustomSteps runs the custom steps not recorded in state yet, in
+// dependency order.
+func ApplyCustomSteps(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, progress Progress) error {
+	steps, err := OrderedSteps()
+	if err != nil {
+		return err
+	}
+
+	stepContext := StepContext{Logger: logger, Clients: clients, Config: cfg, State: state}
+	for _, step := range steps {
+		if _, ok := state.CustomResources[step.Name()]; ok {
+			continue
+		}
+		if err := progress.Track(step.Name(), func() (string, error) {
+			return applyCustomStep(ctx, stepContext, step)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddCustomSteps adds the custom steps not recorded in state yet to the
+// graph of Apply. A step without dependencies still waits for the VPC, like
+// every built-in step does.
+func AddCustomSteps(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, graph *StepGraph) error {
+	steps, err := OrderedSteps()
+	if err != nil {
+		return err
+	}
+
+	stepContext := StepContext{Logger: logger, Clients: clients, Config: cfg, State: state}
+	for _, step := range steps {
+		if _, ok := state.CustomResources[step.Name()]; ok {
+			continue
+		}
+		graph.Add(step.Name(), customStepDependencies(step), func() (string, error) {
+			return applyCustomStep(ctx, stepContext, step)
+		})
+	}
+
+	return nil
+}
+
+// customStepDependencies returns the steps step runs after in Apply.
+func customStepDependencies(step Step) []string {
+	if dependsOn := step.DependsOn(); len(dependsOn) > 0 {
+		return dependsOn
+	}
+	return []string{"VPC"}
+}
+
+// applyCustomStep applies step and records its resource in state.
+func applyCustomStep(ctx context.Context, stepContext StepContext, step Step) (string, error) {
+	id, err := step.Apply(ctx, stepContext)
+	if err != nil {
+		return "", fmt.Errorf("error applying step %s: %w", step.Name(), err)
+	}
+	return id, stepContext.State.Record(func(s *State) {
+		if s.CustomResources == nil {
+			s.CustomResources = map[string]string{}
+		}
+		s.CustomResources[step.Name()] = id
+	})
+}
+
+// DestroyCustomSteps destroys the resources of the custom steps recorded in
+// state, in reverse dependency order. Recorded steps that are no longer
+// registered are left alone and reported.
+func DestroyCustomSteps(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	steps, err := OrderedSteps()
+	if err != nil {
+		return err
+	}
+
+	stepContext := StepContext{Logger: logger, Clients: clients, Config: cfg, State: state}
+	for i := len(steps) - 1; i >= 0; i-- {
+		step := steps[i]
+		id, ok := state.CustomResources[step.Name()]
+		if !ok {
+			continue
+		}
+		if err := step.Destroy(ctx, stepContext, id); err != nil {
+			return fmt.Errorf("error destroying step %s: %w", step.Name(), err)
+		}
+		logger.Printf("Step %s destroyed %s", step.Name(), id)
+		if err := state.Record(func(s *State) { delete(s.CustomResources, step.Name()) }); err != nil {
+			return err
+		}
+	}
+
+	for name, id := range state.CustomResources {
+		logger.Printf("Step %s is not registered anymore, %s was not destroyed", name, id)
+	}
+	return nil
+}
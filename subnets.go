@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	CIDRBlockAssociationPollInterval = 2 * time.Second
+)
+
+// CIDRBlocks returns the primary and the secondary CIDR blocks of the VPC.
+func (v VPCConfig) CIDRBlocks() []string {
+	return append([]string{v.CIDRBlock}, v.SecondaryCIDRBlocks...)
+}
+
+// SecondaryBlockIndex returns the position of the secondary CIDR block a
+// subnet is carved from, or -1 when it belongs to the primary block.
+func (v VPCConfig) SecondaryBlockIndex(subnetCIDRBlock string) int {
+	subnet, err := netip.ParsePrefix(subnetCIDRBlock)
+	if err != nil {
+		return -1
+	}
+	for i, cidrBlock := range v.SecondaryCIDRBlocks {
+		block, err := netip.ParsePrefix(cidrBlock)
+		if err == nil && block.Contains(subnet.Addr()) && subnet.Bits() >= block.Bits() {
+			return i
+		}
+	}
+	return -1
+}
+
+// AssociateSecondaryCIDRBlocks associates the secondary CIDR blocks with the
+// VPC and waits until they are usable for subnets.
+func AssociateSecondaryCIDRBlocks(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, vpcID string, cidrBlocks []string) error {
+	if len(cidrBlocks) == 0 {
+		return nil
+	}
+
+	for _, cidrBlock := range cidrBlocks {
+		if _, err := ec2Client.AssociateVpcCidrBlock(ctx, &ec2.AssociateVpcCidrBlockInput{
+			VpcId:     aws.String(vpcID),
+			CidrBlock: aws.String(cidrBlock),
+		}); err != nil {
+			return fmt.Errorf("error associating CIDR block %s with VPC: %w", cidrBlock, err)
+		}
+	}
+
+	ticker := time.NewTicker(CIDRBlockAssociationPollInterval)
+	defer ticker.Stop()
+	for {
+		output, err := ec2Client.DescribeVpcs(ctx, &ec2.DescribeVpcsInput{VpcIds: []string{vpcID}})
+		if err != nil {
+			return fmt.Errorf("error describing VPC: %w", err)
+		}
+		if len(output.Vpcs) == 0 {
+			return fmt.Errorf("VPC %s not found", vpcID)
+		}
+
+		associated := 0
+		for _, association := range output.Vpcs[0].CidrBlockAssociationSet {
+			if association.CidrBlockState == nil || !slices.Contains(cidrBlocks, aws.StringValue(association.CidrBlock)) {
+				continue
+			}
+			switch association.CidrBlockState.State {
+			case types.VpcCidrBlockStateCodeAssociated:
+				associated++
+			case types.VpcCidrBlockStateCodeFailed:
+				return fmt.Errorf("error associating CIDR block %s with VPC: %s", aws.StringValue(association.CidrBlock), aws.StringValue(association.CidrBlockState.StatusMessage))
+			}
+		}
+		if associated == len(cidrBlocks) {
+			logger.Printf("Associated CIDR blocks %s with VPC %s", strings.Join(cidrBlocks, ", "), vpcID)
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for CIDR block association: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// SubnetLayout returns the subnets of the VPC: the explicitly configured
+// ones, or PublicSubnets and PrivateSubnets subnets carved from the VPC CIDR
+// block and spread round-robin over zones. Public subnets are numbered from
+// the start of the block (10.0.1.0/24, 10.0.2.0/24, ...) and private ones
+// from its second half (10.0.128.0/24, ...). With no zones, e.g. when
+// exporting, the availability zones are left empty and only ZoneIndex is set.
+// There are none to create with vpc.subnetIds.
+func SubnetLayout(vpcConfig VPCConfig, zones []string) ([]SubnetConfig, error) {
+	if vpcConfig.SubnetIDs != nil {
+		return nil, nil
+	}
+	if len(vpcConfig.Subnets) > 0 {
+		return vpcConfig.Subnets, nil
+	}
+
+	vpcPrefix, err := netip.ParsePrefix(vpcConfig.CIDRBlock)
+	if err != nil || !vpcPrefix.Addr().Is4() {
+		return nil, fmt.Errorf("vpc.cidrBlock %q is not a valid IPv4 CIDR block", vpcConfig.CIDRBlock)
+	}
+	vpcPrefix = vpcPrefix.Masked()
+
+	prefixLength := vpcConfig.SubnetPrefixLength
+	if prefixLength < vpcPrefix.Bits() || prefixLength > 28 {
+		return nil, fmt.Errorf("vpc.subnetPrefixLength /%d must be between /%d and /28", prefixLength, vpcPrefix.Bits())
+	}
+	available := 1 << (prefixLength - vpcPrefix.Bits())
+	privateOffset := available / 2
+	if vpcConfig.PublicSubnets+1 > privateOffset || vpcConfig.PrivateSubnets > available-privateOffset {
+		return nil, fmt.Errorf("vpc.cidrBlock %s has no room for %d public and %d private /%d subnets",
+			vpcPrefix, vpcConfig.PublicSubnets, vpcConfig.PrivateSubnets, prefixLength)
+	}
+
+	base := vpcPrefix.Addr().As4()
+	carve := func(offset int) string {
+		var addr [4]byte
+		binary.BigEndian.PutUint32(addr[:], binary.BigEndian.Uint32(base[:])+uint32(offset)<<(32-prefixLength))
+		return netip.PrefixFrom(netip.AddrFrom4(addr), prefixLength).String()
+	}
+	zone := func(index int) string {
+		if len(zones) == 0 {
+			return ""
+		}
+		return zones[index%len(zones)]
+	}
+
+	// In an existing VPC the ranges of its other subnets are skipped.
+	var taken []string
+	if vpcConfig.Resolved != nil {
+		taken = vpcConfig.Resolved.SubnetCIDRBlocks
+	}
+	free := func(offset *int, limit int) (string, error) {
+		for ; *offset < limit; *offset++ {
+			if cidrBlock := carve(*offset); takenBy(cidrBlock, taken) == "" {
+				*offset++
+				return cidrBlock, nil
+			}
+		}
+		return "", fmt.Errorf("vpc.cidrBlock %s has no room for %d public and %d private /%d subnets beside the subnets already in VPC %s",
+			vpcPrefix, vpcConfig.PublicSubnets, vpcConfig.PrivateSubnets, prefixLength, vpcConfig.Resolved.ID)
+	}
+
+	subnets := make([]SubnetConfig, 0, vpcConfig.PublicSubnets+vpcConfig.PrivateSubnets)
+	publicOffset := 1
+	for i := 0; i < vpcConfig.PublicSubnets; i++ {
+		cidrBlock, err := free(&publicOffset, privateOffset)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, SubnetConfig{CIDRBlock: cidrBlock, AvailabilityZone: zone(i), ZoneIndex: i})
+	}
+	for i := 0; i < vpcConfig.PrivateSubnets; i++ {
+		cidrBlock, err := free(&privateOffset, available)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, SubnetConfig{CIDRBlock: cidrBlock, AvailabilityZone: zone(i), ZoneIndex: i, Private: true})
+	}
+
+	return subnets, nil
+}
+
+// ResolveSubnets returns the subnet layout for the region, looking up its
+// availability zones unless the subnets are configured explicitly.
+func ResolveSubnets(ctx context.Context, ec2Client *ec2.Client, vpcConfig VPCConfig) ([]SubnetConfig, error) {
+	if vpcConfig.SubnetIDs != nil {
+		return nil, nil
+	}
+	if len(vpcConfig.Subnets) > 0 {
+		return vpcConfig.Subnets, nil
+	}
+
+	zones, err := AvailabilityZones(ctx, ec2Client)
+	if err != nil {
+		return nil, err
+	}
+	if len(zones) == 0 {
+		return nil, fmt.Errorf("no availability zones available in the region")
+	}
+
+	return SubnetLayout(vpcConfig, zones)
+}
+
+// AvailabilityZones returns the names of the available, opted-in
+// availability zones of the region (no local or wavelength zones), sorted.
+func AvailabilityZones(ctx context.Context, ec2Client *ec2.Client) ([]string, error) {
+	output, err := ec2Client.DescribeAvailabilityZones(ctx, &ec2.DescribeAvailabilityZonesInput{
+		Filters: []types.Filter{
+			{Name: aws.String("state"), Values: []string{"available"}},
+			{Name: aws.String("zone-type"), Values: []string{"availability-zone"}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error describing availability zones: %w", err)
+	}
+
+	zones := make([]string, 0, len(output.AvailabilityZones))
+	for _, zone := range output.AvailabilityZones {
+		zones = append(zones, aws.StringValue(zone.ZoneName))
+	}
+	sort.Strings(zones)
+
+	return zones, nil
+}
+
+// SplitSubnets separates the public subnets from the private ones, keeping
+// their order.
+func SplitSubnets(subnets []SubnetConfig) (public, private []SubnetConfig) {
+	for _, subnet := range subnets {
+		if subnet.Private {
+			private = append(private, subnet)
+		} else {
+			public = append(public, subnet)
+		}
+	}
+	return public, private
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// emailPattern loosely matches an email address, SNS rejects the rest.
+var emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+const (
+	SubscriptionConfirmed = "confirmed"
+	SubscriptionPending   = "pending confirmation"
+	SubscriptionGone      = "deleted"
+)
+
+// SubscriptionStatus is the state of an email subscription to the topic of
+// the lifecycle events. It stays pending until the address confirms it from
+// the email SNS sent.
+type SubscriptionStatus struct {
+	Email string `json:"email"`
+	State string `json:"state"`
+}
+
+// SubscribeEmails subscribes the addresses that aren't subscribed yet to the
+// topic, recording each subscription as soon as it exists. SNS sends every
+// address an email to confirm the subscription with.
+func SubscribeEmails(ctx context.Context, logger *log.Logger, snsClient *sns.Client, topicARN string, emails []string, state *State) error {
+	for _, email := range emails {
+		if _, ok := state.LifecycleEvents.Subscriptions[email]; ok {
+			continue
+		}
+		output, err := snsClient.Subscribe(ctx, &sns.SubscribeInput{
+			TopicArn:              aws.String(topicARN),
+			Protocol:              aws.String("email"),
+			Endpoint:              aws.String(email),
+			ReturnSubscriptionArn: true,
+		})
+		if err != nil {
+			return fmt.Errorf("error subscribing %s to %s: %w", email, topicARN, err)
+		}
+		subscriptionARN := aws.StringValue(output.SubscriptionArn)
+		logger.Printf("Subscribed %s to %s, waiting for the address to confirm", email, topicARN)
+		if err := state.Record(func(s *State) {
+			if s.LifecycleEvents.Subscriptions == nil {
+				s.LifecycleEvents.Subscriptions = map[string]string{}
+			}
+			s.LifecycleEvents.Subscriptions[email] = subscriptionARN
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UnsubscribeEmails deletes the recorded subscriptions that are not to the
+// topic or whose address is not in keep, forgetting each of them once it is
+// gone.
+func UnsubscribeEmails(ctx context.Context, logger *log.Logger, snsClient *sns.Client, state *State, topicARN string, keep []string) error {
+	if state.LifecycleEvents == nil {
+		return nil
+	}
+	for _, email := range sortedTagKeys(state.LifecycleEvents.Subscriptions) {
+		subscriptionARN := state.LifecycleEvents.Subscriptions[email]
+		if topicARN != "" && strings.HasPrefix(subscriptionARN, topicARN+":") && slices.Contains(keep, email) {
+			continue
+		}
+		if _, err := snsClient.Unsubscribe(ctx, &sns.UnsubscribeInput{
+			SubscriptionArn: aws.String(subscriptionARN),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error unsubscribing %s: %w", email, err)
+		}
+		logger.Printf("Unsubscribed %s", email)
+		if err := state.Record(func(s *State) { delete(s.LifecycleEvents.Subscriptions, email) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectSubscriptions looks up whether the recorded email subscriptions
+// were confirmed.
+func CollectSubscriptions(ctx context.Context, snsClient *sns.Client, state *State) ([]SubscriptionStatus, error) {
+	if state.LifecycleEvents == nil {
+		return nil, nil
+	}
+	var subscriptions []SubscriptionStatus
+	for _, email := range sortedTagKeys(state.LifecycleEvents.Subscriptions) {
+		output, err := snsClient.GetSubscriptionAttributes(ctx, &sns.GetSubscriptionAttributesInput{
+			SubscriptionArn: aws.String(state.LifecycleEvents.Subscriptions[email]),
+		})
+		subscription := SubscriptionStatus{Email: email, State: SubscriptionConfirmed}
+		switch {
+		case isNotFound(err):
+			subscription.State = SubscriptionGone
+		case err != nil:
+			return nil, fmt.Errorf("error describing subscription of %s: %w", email, err)
+		case output.Attributes["PendingConfirmation"] == "true":
+			subscription.State = SubscriptionPending
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
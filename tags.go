@@ -0,0 +1,73 @@
+package main
+
+import (
+	"sort"
+
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	TagStack       = "Stack"
+	TagEnvironment = "Environment"
+)
+
+// StackTags returns the tags put on every created resource. They tell the
+// stacks sharing an account apart and let list find them.
+func (c *Config) StackTags() map[string]string {
+	tags := map[string]string{TagStack: c.Naming.Stack}
+	if c.Environment != "" {
+		tags[TagEnvironment] = c.Environment
+	}
+	return tags
+}
+
+func sortedTagKeys(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func ec2TagSpecifications(resourceType types.ResourceType, tags map[string]string) []types.TagSpecification {
+	if len(tags) == 0 {
+		return nil
+	}
+	ec2Tags := make([]types.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		ec2Tags = append(ec2Tags, types.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return []types.TagSpecification{{ResourceType: resourceType, Tags: ec2Tags}}
+}
+
+func elbTags(tags map[string]string) []elbTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]elbTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, elbTypes.Tag{Key: aws.String(key), Value: aws.String(tags[key])})
+	}
+	return result
+}
+
+// autoscalingTags also propagates the tags to the instances the group
+// launches.
+func autoscalingTags(tags map[string]string) []autoscalingTypes.Tag {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]autoscalingTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, autoscalingTypes.Tag{
+			Key:               aws.String(key),
+			Value:             aws.String(tags[key]),
+			PropagateAtLaunch: aws.Bool(true),
+		})
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ApplyTarget runs the step target of a first apply together with the steps
+// it depends on, e.g. only the VPC and the security group for "Security
+// group". Steps an unfinished apply recorded as completed are
+// skipped. Until every step ran, the apply stays unfinished, so apply
+// --resume or another --target runs the rest.
+func ApplyTarget(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, progress Progress, target string) error {
+	if !state.IsEmpty() && len(state.CompletedSteps) == 0 {
+		return fmt.Errorf("stack recorded in %s is fully applied, --target only runs steps of a first apply, apply without it to update the stack", state.Location())
+	}
+
+	graph, err := ApplyGraph(ctx, logger, clients, cfg, state)
+	if err != nil {
+		return err
+	}
+	graph.Skip(state.CompletedSteps)
+	pending := len(graph.Names())
+	if err := graph.Target(target); err != nil {
+		return err
+	}
+	if err := checkPartialSteps(graph, state); err != nil {
+		return err
+	}
+
+	logger.Printf("Applying %s with the steps it depends on: %s", target, strings.Join(graph.Names(), ", "))
+	if len(graph.Names()) == pending {
+		return runApplyGraph(ctx, logger, graph, state, progress)
+	}
+	if err := graph.Run(ctx, logger, progress, state); err != nil {
+		return err
+	}
+	logger.Printf("%d steps left, run apply --resume to finish the stack", pending-len(graph.Names()))
+	return nil
+}
+
+// ResolveStepName returns the step named name, ignoring case, so --target
+// accepts "security group" as well.
+func ResolveStepName(name string) (string, error) {
+	for _, step := range StepNames() {
+		if strings.EqualFold(step, name) {
+			return step, nil
+		}
+	}
+	return "", fmt.Errorf("unknown step %q, steps: %s", name, strings.Join(StepNames(), ", "))
+}
+
+// TargetSteps returns the steps ApplyTarget runs for target, in the order
+// the progress view shows them.
+func TargetSteps(cfg *Config, state *State, target string) []string {
+	pending := PendingSteps(cfg, state)
+	graph := NewStepGraph(cfg.Retries)
+	for _, step := range pending {
+		// The smoke test runs after every built-in step.
+		dependsOn := stepDependencies[step]
+		if step == "Smoke test" {
+			dependsOn = ApplySteps
+		}
+		for _, custom := range customSteps {
+			if custom.Name() == step {
+				dependsOn = customStepDependencies(custom)
+			}
+		}
+		graph.Add(step, dependsOn, nil)
+	}
+	if err := graph.Target(target); err != nil {
+		return pending
+	}
+	return graph.Names()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	TargetTypeInstance = "instance"
+	TargetTypeIP       = "ip"
+	// AllAvailabilityZones registers an IP target outside of the VPC, e.g.
+	// on-premises over Direct Connect or VPN.
+	AllAvailabilityZones = "all"
+)
+
+// IPTargetConfig is an IP address registered with a target group of type
+// ip. Port defaults to the port of the target group.
+type IPTargetConfig struct {
+	IP   string `json:"ip"`
+	Port int32  `json:"port"`
+}
+
+func (t IPTargetConfig) String() string {
+	if t.Port == 0 {
+		return t.IP
+	}
+	return fmt.Sprintf("%s:%d", t.IP, t.Port)
+}
+
+// UsesIPTargets reports whether the target group routes to IP addresses
+// instead of the instances of the autoscaling group.
+func (t TargetGroupConfig) UsesIPTargets() bool {
+	return t.TargetType == TargetTypeIP
+}
+
+// AttachesTargetGroup reports whether the instances of the autoscaling group
+// are registered with the target group. IP targets and the tasks of an ECS
+// service are registered instead.
+func (c *Config) AttachesTargetGroup() bool {
+	return !c.TargetGroup.UsesIPTargets() && c.ECS == nil
+}
+
+// ParseIPTarget parses IP or IP:PORT.
+func ParseIPTarget(target string) (IPTargetConfig, error) {
+	if addrPort, err := netip.ParseAddrPort(target); err == nil {
+		return IPTargetConfig{IP: addrPort.Addr().String(), Port: int32(addrPort.Port())}, nil
+	}
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return IPTargetConfig{}, fmt.Errorf("%q is not an IP address or IP:PORT", target)
+	}
+	return IPTargetConfig{IP: addr.String()}, nil
+}
+
+// OutsideVPC reports whether the address is outside of the VPC CIDR blocks.
+// Such targets are registered in all availability zones.
+func (t IPTargetConfig) OutsideVPC(vpcCIDRBlocks []string) bool {
+	addr, err := netip.ParseAddr(t.IP)
+	if err != nil {
+		return false
+	}
+	for _, cidrBlock := range vpcCIDRBlocks {
+		if prefix, err := netip.ParsePrefix(cidrBlock); err == nil && prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func ipTargetDescriptions(targets []IPTargetConfig, vpcCIDRBlocks []string) []elbTypes.TargetDescription {
+	descriptions := make([]elbTypes.TargetDescription, 0, len(targets))
+	for _, target := range targets {
+		description := elbTypes.TargetDescription{Id: aws.String(target.IP)}
+		if target.Port != 0 {
+			description.Port = aws.Int32(target.Port)
+		}
+		if target.OutsideVPC(vpcCIDRBlocks) {
+			description.AvailabilityZone = aws.String(AllAvailabilityZones)
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions
+}
+
+// RegisterIPTargets adds IP targets to the target group.
+func RegisterIPTargets(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, targetGroupARN string, targets []IPTargetConfig, vpcCIDRBlocks []string) error {
+	if len(targets) == 0 {
+		return nil
+	}
+
+	if _, err := elbClient.RegisterTargets(ctx, &elasticloadbalancingv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        ipTargetDescriptions(targets, vpcCIDRBlocks),
+	}); err != nil {
+		return fmt.Errorf("error registering targets: %w", err)
+	}
+	logger.Printf("Registered targets %s with %s", joinIPTargets(targets), targetGroupARN)
+	return nil
+}
+
+// DeregisterIPTargets removes IP targets from the target group. The load
+// balancer stops sending them new requests right away and lets in-flight
+// ones finish.
+func DeregisterIPTargets(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, targetGroupARN string, targets []IPTargetConfig, vpcCIDRBlocks []string) error {
+	if _, err := elbClient.DeregisterTargets(ctx, &elasticloadbalancingv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(targetGroupARN),
+		Targets:        ipTargetDescriptions(targets, vpcCIDRBlocks),
+	}); err != nil {
+		return fmt.Errorf("error deregistering targets: %w", err)
+	}
+	logger.Printf("Deregistered targets %s from %s", joinIPTargets(targets), targetGroupARN)
+	return nil
+}
+
+func joinIPTargets(targets []IPTargetConfig) string {
+	parts := make([]string, 0, len(targets))
+	for _, target := range targets {
+		parts = append(parts, target.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+func runTargets(ctx context.Context, logger *log.Logger, args []string) error {
+	if len(args) == 0 || (args[0] != "register" && args[0] != "deregister") {
+		return errors.New("targets requires an action: register or deregister")
+	}
+	action, args := args[0], args[1:]
+
+	var rawTargets []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		rawTargets, args = append(rawTargets, args[0]), args[1:]
+	}
+
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("targets "+action, flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(rawTargets) == 0 {
+		return fmt.Errorf("targets %s requires at least one IP or IP:PORT", action)
+	}
+
+	targets := make([]IPTargetConfig, 0, len(rawTargets))
+	for _, rawTarget := range rawTargets {
+		target, err := ParseIPTarget(rawTarget)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target)
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+	if err := ResolveVPC(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+	if !cfg.TargetGroup.UsesIPTargets() {
+		return fmt.Errorf("target group %s has target type %s, set targetGroup.targetType to %s to register IP targets", cfg.TargetGroup.Name, cfg.TargetGroup.TargetType, TargetTypeIP)
+	}
+
+	if action == "register" {
+		return RegisterIPTargets(ctx, logger, clients.ELB, state.TargetGroupARN, targets, cfg.VPC.CIDRBlocks())
+	}
+	return DeregisterIPTargets(ctx, logger, clients.ELB, state.TargetGroupARN, targets, cfg.VPC.CIDRBlocks())
+}
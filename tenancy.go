@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	TenancyDefault   = "default"
+	TenancyDedicated = "dedicated"
+	TenancyHost      = "host"
+)
+
+// Tenancies are the hardware the instances run on: shared, dedicated to the
+// account, or Dedicated Hosts.
+var Tenancies = []string{TenancyDefault, TenancyDedicated, TenancyHost}
+
+// pricingTenancies map the tenancies to the tenancy attribute of the EC2
+// prices. Instances on Dedicated Hosts are free, the hosts are billed.
+var pricingTenancies = map[string]string{
+	TenancyDefault:   "Shared",
+	TenancyDedicated: "Dedicated",
+	TenancyHost:      "Host",
+}
+
+// launchTemplatePlacement returns the placement of the instances, nil when
+// they are placed by default.
+func launchTemplatePlacement(ltConfig LaunchTemplateConfig) *types.LaunchTemplatePlacementRequest {
+	if ltConfig.PlacementGroup == "" && ltConfig.Tenancy == TenancyDefault {
+		return nil
+	}
+
+	placement := &types.LaunchTemplatePlacementRequest{}
+	if ltConfig.PlacementGroup != "" {
+		placement.GroupName = aws.String(ltConfig.PlacementGroup)
+	}
+	if ltConfig.Tenancy != TenancyDefault {
+		placement.Tenancy = types.Tenancy(ltConfig.Tenancy)
+	}
+	if ltConfig.HostResourceGroupARN != "" {
+		placement.HostResourceGroupArn = aws.String(ltConfig.HostResourceGroupARN)
+	}
+	return placement
+}
+
+// isHostResourceGroupARN reports whether arn looks like the ARN of a License
+// Manager host resource group.
+func isHostResourceGroupARN(arn string) bool {
+	return strings.HasPrefix(arn, "arn:") && strings.Contains(arn, ":resource-groups:") && strings.Contains(arn, ":group/")
+}
@@ -0,0 +1,1489 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const (
+	DefaultTerraformDir  = "terraform"
+	TerraformMainFile    = "main.tf"
+	TerraformImportFile  = "import.sh"
+	TerraformUserDataDir = "files"
+)
+
+var terraformTemplate = template.Must(template.New("terraform").Funcs(template.FuncMap{
+	"quote":  strconv.Quote,
+	"tfName": terraformName,
+}).Parse(`provider "aws" {
+  region = {{ quote .Config.Region }}
+}
+
+resource "aws_vpc" "main" {
+  cidr_block           = {{ quote .Config.VPC.CIDRBlock }}
+  enable_dns_hostnames = true
+}
+{{- range $i, $cidrBlock := .Config.VPC.SecondaryCIDRBlocks }}
+
+resource "aws_vpc_ipv4_cidr_block_association" "secondary_{{ $i }}" {
+  vpc_id     = aws_vpc.main.id
+  cidr_block = {{ quote $cidrBlock }}
+}
+{{- end }}
+{{- with .Config.VPC.FlowLogs }}{{ if .Enabled }}
+{{- if .ToCloudWatchLogs }}
+
+resource "aws_cloudwatch_log_group" "flow_logs" {
+  name              = {{ quote .LogGroupName }}
+  retention_in_days = {{ .RetentionDays }}
+}
+
+resource "aws_iam_role" "flow_logs" {
+  name               = {{ quote .RoleName }}
+  assume_role_policy = {{ quote .TrustPolicy }}
+}
+
+resource "aws_iam_role_policy" "flow_logs" {
+  name   = "flow-logs-delivery"
+  role   = aws_iam_role.flow_logs.id
+  policy = {{ quote .DeliveryPolicy }}
+}
+{{- end }}
+
+resource "aws_flow_log" "main" {
+  vpc_id               = aws_vpc.main.id
+  traffic_type         = {{ quote .TrafficType }}
+  log_destination_type = {{ quote .Destination }}
+{{- if .ToCloudWatchLogs }}
+  log_destination      = aws_cloudwatch_log_group.flow_logs.arn
+  iam_role_arn         = aws_iam_role.flow_logs.arn
+{{- else }}
+  log_destination      = {{ quote .BucketARN }}
+{{- end }}
+}
+{{- end }}{{ end }}
+{{- with .Config.Logs }}
+
+resource "aws_cloudwatch_log_group" "app_logs" {
+  name              = {{ quote .App.Name }}
+  retention_in_days = {{ .App.Retention }}
+}
+
+resource "aws_cloudwatch_log_group" "access_logs" {
+  name              = {{ quote .Access.Name }}
+  retention_in_days = {{ .Access.Retention }}
+}
+
+resource "aws_cloudwatch_log_group" "system_logs" {
+  name              = {{ quote .System.Name }}
+  retention_in_days = {{ .System.Retention }}
+}
+{{- end }}
+
+resource "aws_internet_gateway" "main" {
+  vpc_id = aws_vpc.main.id
+}
+
+resource "aws_route_table" "main" {
+  vpc_id = aws_vpc.main.id
+}
+
+resource "aws_route" "internet" {
+  route_table_id         = aws_route_table.main.id
+  destination_cidr_block = "0.0.0.0/0"
+  gateway_id             = aws_internet_gateway.main.id
+}
+
+data "aws_availability_zones" "available" {
+  state = "available"
+}
+{{ range $i, $subnet := .PublicSubnets }}
+{{- $block := $.Config.VPC.SecondaryBlockIndex $subnet.CIDRBlock }}
+resource "aws_subnet" "subnet_{{ $i }}" {
+  vpc_id                  = {{ if ge $block 0 }}aws_vpc_ipv4_cidr_block_association.secondary_{{ $block }}.vpc_id{{ else }}aws_vpc.main.id{{ end }}
+  cidr_block              = {{ quote $subnet.CIDRBlock }}
+  availability_zone       = {{ template "zone" $subnet }}
+  map_public_ip_on_launch = true
+}
+
+resource "aws_route_table_association" "subnet_{{ $i }}" {
+  subnet_id      = aws_subnet.subnet_{{ $i }}.id
+  route_table_id = aws_route_table.main.id
+}
+{{ end }}
+{{- range $i, $subnet := .PrivateSubnets }}
+{{- $block := $.Config.VPC.SecondaryBlockIndex $subnet.CIDRBlock }}
+resource "aws_subnet" "private_subnet_{{ $i }}" {
+  vpc_id            = {{ if ge $block 0 }}aws_vpc_ipv4_cidr_block_association.secondary_{{ $block }}.vpc_id{{ else }}aws_vpc.main.id{{ end }}
+  cidr_block        = {{ quote $subnet.CIDRBlock }}
+  availability_zone = {{ template "zone" $subnet }}
+}
+{{ end }}
+{{- if .Config.VPC.NetworkACL.Enabled }}
+{{- $subnets := .Config.VPC.NetworkACL.Subnets }}
+resource "aws_network_acl" "main" {
+  vpc_id     = aws_vpc.main.id
+  subnet_ids = [
+    {{- $first := true }}
+    {{- if ne $subnets "private" }}{{ range $i, $subnet := .PublicSubnets }}{{ if not $first }}, {{ end }}{{ $first = false }}aws_subnet.subnet_{{ $i }}.id{{ end }}{{ end }}
+    {{- if ne $subnets "public" }}{{ range $i, $subnet := .PrivateSubnets }}{{ if not $first }}, {{ end }}{{ $first = false }}aws_subnet.private_subnet_{{ $i }}.id{{ end }}{{ end -}}
+  ]
+{{- range .Config.VPC.NetworkACL.InboundEntries }}
+
+  ingress {
+{{- template "naclEntry" . }}
+  }
+{{- end }}
+{{- range .Config.VPC.NetworkACL.OutboundEntries }}
+
+  egress {
+{{- template "naclEntry" . }}
+  }
+{{- end }}
+}
+{{ end }}
+{{- range .Config.VPC.GatewayEndpoints }}
+resource "aws_vpc_endpoint" {{ quote (tfName .) }} {
+  vpc_id            = aws_vpc.main.id
+  vpc_endpoint_type = "Gateway"
+  service_name      = {{ quote (printf "com.amazonaws.%s.%s" $.Config.Region .) }}
+  route_table_ids   = [aws_route_table.main.id{{ if $.PrivateSubnets }}, aws_vpc.main.main_route_table_id{{ end }}]
+}
+{{ end }}
+{{- if .Config.VPC.InterfaceEndpoints }}
+resource "aws_security_group" "endpoints" {
+  description = {{ quote .EndpointsSecurityGroupDescription }}
+  vpc_id      = aws_vpc.main.id
+
+  ingress {
+    protocol    = "tcp"
+    from_port   = 443
+    to_port     = 443
+    cidr_blocks = [{{ range $i, $cidrBlock := .Config.VPC.CIDRBlocks }}{{ if $i }}, {{ end }}{{ quote $cidrBlock }}{{ end }}]
+    description = "HTTPS from the VPC"
+  }
+}
+{{ range .Config.VPC.InterfaceEndpoints }}
+resource "aws_vpc_endpoint" {{ quote (tfName .) }} {
+  vpc_id              = aws_vpc.main.id
+  vpc_endpoint_type   = "Interface"
+  service_name        = {{ quote (printf "com.amazonaws.%s.%s" $.Config.Region .) }}
+  private_dns_enabled = true
+  security_group_ids  = [aws_security_group.endpoints.id]
+  subnet_ids          = [{{ range $i, $ref := $.EndpointSubnets }}{{ if $i }}, {{ end }}{{ $ref }}{{ end }}]
+}
+{{ end }}
+{{- end }}
+{{- range $address := .Config.ElasticIPs }}
+resource "aws_eip" {{ quote (tfName .Name) }} {
+  domain = "vpc"
+
+  tags = {
+    Name = {{ quote .TagName }}
+  }
+}
+{{ with .AssociateWith }}
+resource "aws_eip_association" {{ quote (tfName $address.Name) }} {
+  allocation_id        = aws_eip.{{ tfName $address.Name }}.id
+{{- if $address.AssociatesBastion }}
+  instance_id          = aws_instance.bastion.id
+{{- else if $address.AssociatesNetworkInterface }}
+  network_interface_id = {{ quote . }}
+{{- else }}
+  instance_id          = {{ quote . }}
+{{- end }}
+}
+{{ end }}
+{{- end }}
+{{- range .Config.SecurityGroup.PrefixListNames }}
+data "aws_ec2_managed_prefix_list" {{ quote (tfName .) }} {
+  name = {{ quote . }}
+}
+{{ end }}
+resource "aws_security_group" "main" {
+  description = {{ quote .Config.SecurityGroup.Description }}
+  vpc_id      = aws_vpc.main.id
+{{ range .Config.SecurityGroup.IngressRules }}
+  ingress {
+{{- template "rule" . }}
+  }
+{{ end -}}
+{{- range .Config.SecurityGroup.EgressRules }}
+  egress {
+{{- template "rule" . }}
+  }
+{{ end -}}
+}
+
+{{ if not .Config.LaunchTemplate.AMIID -}}
+data "aws_ssm_parameter" "ami" {
+  name = {{ quote .Config.LaunchTemplate.AMIParameterPath }}
+}
+
+{{ end -}}
+{{ with .Config.Bastion -}}
+resource "aws_security_group" "bastion" {
+  name        = {{ quote .SecurityGroupName }}
+  description = "SSH to the bastion"
+  vpc_id      = aws_vpc.main.id
+
+  ingress {
+    protocol    = "tcp"
+    from_port   = {{ $.SSHPort }}
+    to_port     = {{ $.SSHPort }}
+    cidr_blocks = [{{ quote .SSHCIDR }}]
+    description = "SSH from the operators"
+  }
+}
+
+resource "aws_security_group_rule" "ssh_from_bastion" {
+  type                     = "ingress"
+  security_group_id        = aws_security_group.main.id
+  protocol                 = "tcp"
+  from_port                = {{ $.SSHPort }}
+  to_port                  = {{ $.SSHPort }}
+  source_security_group_id = aws_security_group.bastion.id
+  description              = "SSH from the bastion"
+}
+
+data "aws_ssm_parameter" "bastion_ami" {
+  name = {{ quote .AMIParameterPath }}
+}
+
+resource "aws_instance" "bastion" {
+  ami                         = data.aws_ssm_parameter.bastion_ami.value
+  instance_type               = {{ quote .InstanceType }}
+{{- with .KeyName }}
+  key_name                    = {{ quote . }}
+{{- end }}
+  subnet_id                   = aws_subnet.subnet_0.id
+  vpc_security_group_ids      = [aws_security_group.bastion.id]
+  associate_public_ip_address = true
+
+  metadata_options {
+    http_endpoint = "enabled"
+    http_tokens   = "required"
+  }
+
+  tags = {
+    Name = {{ quote .Name }}
+  }
+}
+
+{{ end -}}
+{{ with .Config.InstanceConnectEndpoint -}}
+resource "aws_security_group" "instance_connect" {
+  name        = {{ quote .SecurityGroupName }}
+  description = "EC2 Instance Connect Endpoint"
+  vpc_id      = aws_vpc.main.id
+
+  egress {
+    protocol        = "tcp"
+    from_port       = {{ $.SSHPort }}
+    to_port         = {{ $.SSHPort }}
+    security_groups = [aws_security_group.main.id]
+    description     = "SSH to the instances"
+  }
+}
+
+resource "aws_security_group_rule" "ssh_from_instance_connect" {
+  type                     = "ingress"
+  security_group_id        = aws_security_group.main.id
+  protocol                 = "tcp"
+  from_port                = {{ $.SSHPort }}
+  to_port                  = {{ $.SSHPort }}
+  source_security_group_id = aws_security_group.instance_connect.id
+  description              = "SSH from the instance connect endpoint"
+}
+
+resource "aws_ec2_instance_connect_endpoint" "main" {
+  subnet_id          = aws_subnet.{{ if $.PrivateSubnets }}private_subnet_0{{ else }}subnet_0{{ end }}.id
+  security_group_ids = [aws_security_group.instance_connect.id]
+  preserve_client_ip = false
+}
+
+{{ end -}}
+{{ with .Config.LifecycleEvents -}}
+{{ if .CreatesTopic -}}
+resource "aws_sns_topic" "lifecycle" {
+  name = {{ quote .TopicName }}
+}
+
+resource "aws_sns_topic_policy" "lifecycle" {
+  arn = aws_sns_topic.lifecycle.arn
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect    = "Allow"
+        Principal = { Service = {{ quote $.EventsServicePrincipal }} }
+        Action    = "sns:Publish"
+        Resource  = aws_sns_topic.lifecycle.arn
+        Condition = { ArnEquals = { "aws:SourceArn" = aws_cloudwatch_event_rule.lifecycle.arn } }
+      },
+    ]
+  })
+}
+
+{{ end -}}
+resource "aws_cloudwatch_event_rule" "lifecycle" {
+  name          = {{ quote .RuleName }}
+  event_pattern = {{ quote $.LifecycleEventPattern }}
+}
+
+resource "aws_cloudwatch_event_target" "lifecycle" {
+  rule      = aws_cloudwatch_event_rule.lifecycle.name
+  target_id = {{ quote $.LifecycleEventsTargetID }}
+  arn       = {{ if .CreatesTopic }}aws_sns_topic.lifecycle.arn{{ else if .TopicARN }}{{ quote .TopicARN }}{{ else }}{{ quote .FunctionARN }}{{ end }}
+}
+
+{{ range $i, $email := .Emails -}}
+resource "aws_sns_topic_subscription" "lifecycle_email_{{ $i }}" {
+  topic_arn = {{ if $.Config.LifecycleEvents.CreatesTopic }}aws_sns_topic.lifecycle.arn{{ else }}{{ quote $.Config.LifecycleEvents.TopicARN }}{{ end }}
+  protocol  = "email"
+  endpoint  = {{ quote $email }}
+}
+
+{{ end -}}
+{{ if .FunctionARN -}}
+resource "aws_lambda_permission" "lifecycle_events" {
+  statement_id  = {{ quote .StatementID }}
+  function_name = {{ quote .FunctionARN }}
+  action        = "lambda:InvokeFunction"
+  principal     = {{ quote $.EventsServicePrincipal }}
+  source_arn    = aws_cloudwatch_event_rule.lifecycle.arn
+}
+
+{{ end -}}
+{{ end -}}
+{{ with .Config.ECS -}}
+resource "aws_ecs_cluster" "main" {
+  name = {{ quote .ClusterName }}
+}
+
+{{ end -}}
+{{ range $i, $secret := .Config.Secrets -}}
+variable "secret_{{ $i }}" {
+  description = {{ quote (printf "Value of the %s secret" $secret.Name) }}
+  type        = string
+  sensitive   = true
+}
+
+{{ if eq $secret.Store $.SecretStoreSecretsManager -}}
+resource "aws_secretsmanager_secret" "secret_{{ $i }}" {
+  name = {{ quote $secret.Path }}
+}
+
+resource "aws_secretsmanager_secret_version" "secret_{{ $i }}" {
+  secret_id     = aws_secretsmanager_secret.secret_{{ $i }}.id
+  secret_string = var.secret_{{ $i }}
+}
+{{- else -}}
+resource "aws_ssm_parameter" "secret_{{ $i }}" {
+  name  = {{ quote $secret.Path }}
+  type  = "SecureString"
+  value = var.secret_{{ $i }}
+}
+{{- end }}
+
+{{ end -}}
+{{ if .Config.CreatesInstanceRole -}}
+resource "aws_iam_role" "instance" {
+  name               = {{ quote .Config.LaunchTemplate.InstanceRoleName }}
+  assume_role_policy = {{ quote .InstanceTrustPolicy }}
+}
+
+{{ if .Config.ECS -}}
+resource "aws_iam_role_policy_attachment" "instance_ecs" {
+  role       = aws_iam_role.instance.name
+  policy_arn = {{ quote .ECSInstanceRolePolicyARN }}
+}
+
+{{ end -}}
+{{ if .Config.SessionManagerOnly -}}
+resource "aws_iam_role_policy_attachment" "instance_ssm" {
+  role       = aws_iam_role.instance.name
+  policy_arn = {{ quote .SSMManagedInstanceCorePolicyARN }}
+}
+
+{{ end -}}
+{{ if .Config.Secrets -}}
+resource "aws_iam_role_policy" "instance_secrets" {
+  name   = {{ quote .SecretsPolicyName }}
+  role   = aws_iam_role.instance.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+{{- range $i, $secret := .Config.Secrets }}
+      {
+        Effect   = "Allow"
+{{- if eq $secret.Store $.SecretStoreSecretsManager }}
+        Action   = "secretsmanager:GetSecretValue"
+        Resource = aws_secretsmanager_secret.secret_{{ $i }}.arn
+{{- else }}
+        Action   = "ssm:GetParameter"
+        Resource = aws_ssm_parameter.secret_{{ $i }}.arn
+{{- end }}
+      },
+{{- end }}
+    ]
+  })
+}
+
+{{ end -}}
+{{ if .Config.Logs -}}
+resource "aws_iam_role_policy" "instance_logs" {
+  name   = {{ quote .LogsPolicyName }}
+  role   = aws_iam_role.instance.id
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect   = "Allow"
+        Action   = ["logs:CreateLogStream", "logs:PutLogEvents", "logs:DescribeLogStreams"]
+        Resource = [
+          "${aws_cloudwatch_log_group.app_logs.arn}:*",
+          "${aws_cloudwatch_log_group.access_logs.arn}:*",
+          "${aws_cloudwatch_log_group.system_logs.arn}:*",
+        ]
+      },
+    ]
+  })
+}
+
+{{ end -}}
+resource "aws_iam_instance_profile" "instance" {
+  name = {{ quote .Config.LaunchTemplate.InstanceRoleName }}
+  role = aws_iam_role.instance.name
+}
+
+{{ end -}}
+{{ with .Config.PlacementGroup -}}
+resource "aws_placement_group" "main" {
+  name     = {{ quote .Name }}
+  strategy = {{ quote .Strategy }}
+{{- if eq .Strategy "partition" }}
+  partition_count = {{ .PartitionCount }}
+{{- end }}
+}
+
+{{ end -}}
+{{ with .Config.VolumeEncryption -}}
+data "aws_caller_identity" "current" {}
+
+{{ if .CreatesKey -}}
+# The key policy names the autoscaling service-linked role, create it with
+# aws iam create-service-linked-role --aws-service-name autoscaling.amazonaws.com
+# if the account has no autoscaling group yet.
+resource "aws_kms_key" "ebs" {
+  description             = "Encryption of the EBS volumes of the autoscaling group"
+  deletion_window_in_days = {{ .DeletionWindowDays }}
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Sid       = "AccountAdministration"
+        Effect    = "Allow"
+        Principal = { AWS = "arn:aws:iam::${data.aws_caller_identity.current.account_id}:root" }
+        Action    = "kms:*"
+        Resource  = "*"
+      },
+      {
+        Sid       = "AutoScalingVolumes"
+        Effect    = "Allow"
+        Principal = { AWS = "arn:aws:iam::${data.aws_caller_identity.current.account_id}:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling" }
+        Action    = ["kms:Encrypt", "kms:Decrypt", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:DescribeKey"]
+        Resource  = "*"
+      },
+      {
+        Sid       = "AutoScalingGrants"
+        Effect    = "Allow"
+        Principal = { AWS = "arn:aws:iam::${data.aws_caller_identity.current.account_id}:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling" }
+        Action    = "kms:CreateGrant"
+        Resource  = "*"
+        Condition = { Bool = { "kms:GrantIsForAWSResource" = true } }
+      },
+    ]
+  })
+}
+
+resource "aws_kms_alias" "ebs" {
+  name          = {{ quote .Alias }}
+  target_key_id = aws_kms_key.ebs.key_id
+}
+
+{{ else -}}
+resource "aws_kms_grant" "ebs" {
+  key_id            = {{ quote .KeyARN }}
+  grantee_principal = "arn:aws:iam::${data.aws_caller_identity.current.account_id}:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+  operations        = ["Encrypt", "Decrypt", "ReEncryptFrom", "ReEncryptTo", "GenerateDataKey", "GenerateDataKeyWithoutPlaintext", "DescribeKey", "CreateGrant"]
+}
+
+{{ end -}}
+{{ end -}}
+resource "aws_launch_template" "main" {
+  image_id               = {{ with .Config.LaunchTemplate.AMIID }}{{ quote . }}{{ else }}data.aws_ssm_parameter.ami.value{{ end }}
+  instance_type          = {{ quote .Config.LaunchTemplate.InstanceType }}
+  vpc_security_group_ids = [aws_security_group.main.id]
+  user_data              = filebase64("${path.module}/{{ .UserDataFile }}")
+
+  monitoring {
+    enabled = {{ .Config.LaunchTemplate.DetailedMonitoring }}
+  }
+{{- with .Config.LaunchTemplate.CPUCredits }}
+
+  credit_specification {
+    cpu_credits = {{ quote . }}
+  }
+{{- end }}
+{{- if .Config.LaunchTemplate.Hibernation }}
+
+  hibernation_options {
+    configured = true
+  }
+{{- end }}
+{{- if or .Config.PlacementGroup (ne .Config.LaunchTemplate.Tenancy "default") }}
+
+  placement {
+{{- if .Config.PlacementGroup }}
+    group_name              = aws_placement_group.main.name
+{{- end }}
+{{- if ne .Config.LaunchTemplate.Tenancy "default" }}
+    tenancy                 = {{ quote .Config.LaunchTemplate.Tenancy }}
+{{- end }}
+{{- with .Config.LaunchTemplate.HostResourceGroupARN }}
+    host_resource_group_arn = {{ quote . }}
+{{- end }}
+  }
+{{- end }}
+{{- if .Config.CreatesInstanceRole }}
+
+  iam_instance_profile {
+    name = aws_iam_instance_profile.instance.name
+  }
+{{- else if .Config.LaunchTemplate.IAMInstanceProfile }}
+
+  iam_instance_profile {
+    name = {{ quote .Config.LaunchTemplate.IAMInstanceProfile }}
+  }
+{{- end }}
+
+  metadata_options {
+    http_endpoint               = "enabled"
+    http_tokens                 = {{ quote .Config.LaunchTemplate.Metadata.HTTPTokens }}
+    http_put_response_hop_limit = {{ .Config.LaunchTemplate.Metadata.HopLimit }}
+    instance_metadata_tags      = {{ quote .Config.LaunchTemplate.Metadata.InstanceMetadataTags }}
+  }
+{{- with .Config.LaunchTemplate.RootVolume }}
+
+  block_device_mappings {
+    device_name = {{ quote .Device }}
+
+    ebs {
+      delete_on_termination = true
+      encrypted             = {{ .Encrypted }}
+{{- template "volumeKey" $.Config.VolumeEncryption }}
+{{- if .VolumeType }}
+      volume_type           = {{ quote .VolumeType }}
+{{- end }}
+{{- if .SizeGiB }}
+      volume_size           = {{ .SizeGiB }}
+{{- end }}
+{{- if .IOPS }}
+      iops                  = {{ .IOPS }}
+{{- end }}
+{{- if .Throughput }}
+      throughput            = {{ .Throughput }}
+{{- end }}
+    }
+  }
+{{- end }}
+{{- range .Config.LaunchTemplate.DataVolumes }}
+
+  block_device_mappings {
+    device_name = {{ quote .DeviceName }}
+
+    ebs {
+      delete_on_termination = {{ .DeletesOnTermination }}
+      encrypted             = {{ .Encrypted }}
+{{- template "volumeKey" $.Config.VolumeEncryption }}
+      volume_size           = {{ .SizeGiB }}
+{{- if .VolumeType }}
+      volume_type           = {{ quote .VolumeType }}
+{{- end }}
+{{- if .IOPS }}
+      iops                  = {{ .IOPS }}
+{{- end }}
+{{- if .Throughput }}
+      throughput            = {{ .Throughput }}
+{{- end }}
+    }
+  }
+{{- end }}
+}
+
+resource "aws_lb_target_group" "main" {
+  name             = {{ quote .Config.TargetGroup.Name }}
+  protocol         = "HTTP"
+  port             = {{ .Config.TargetGroup.Port }}
+  vpc_id           = aws_vpc.main.id
+  target_type      = {{ quote .Config.TargetGroup.TargetType }}
+  protocol_version = {{ quote .Config.TargetGroup.ProtocolVersion }}
+  slow_start       = {{ .Config.TargetGroup.SlowStartSeconds }}
+
+  deregistration_delay = {{ .Config.TargetGroup.DeregistrationDelaySeconds }}
+
+  health_check {
+    path                = {{ quote .Config.TargetGroup.HealthCheck.Path }}
+    interval            = {{ .Config.TargetGroup.HealthCheck.IntervalSeconds }}
+    timeout             = {{ .Config.TargetGroup.HealthCheck.TimeoutSeconds }}
+    healthy_threshold   = {{ .Config.TargetGroup.HealthCheck.HealthyThreshold }}
+    unhealthy_threshold = {{ .Config.TargetGroup.HealthCheck.UnhealthyThreshold }}
+    matcher             = {{ quote .Config.TargetGroup.HealthCheck.Matcher }}
+  }
+}
+
+{{ range $i, $target := .Config.TargetGroup.IPTargets -}}
+resource "aws_lb_target_group_attachment" "ip_{{ $i }}" {
+  target_group_arn  = aws_lb_target_group.main.arn
+  target_id         = {{ quote $target.IP }}
+{{- with $target.Port }}
+  port              = {{ . }}
+{{- end }}
+{{- if $target.OutsideVPC $.Config.VPC.CIDRBlocks }}
+  availability_zone = "all"
+{{- end }}
+}
+
+{{ end -}}
+resource "aws_autoscaling_group" "main" {
+  name                      = {{ quote .AutoScalingGroupName }}
+  min_size                  = {{ .Config.AutoScaling.MinSize }}
+  max_size                  = {{ .Config.AutoScaling.MaxSize }}
+{{- with .Config.AutoScaling.DesiredCapacity }}
+  desired_capacity          = {{ . }}
+{{- end }}
+  default_cooldown          = {{ .Config.AutoScaling.DefaultCooldown }}
+  health_check_type         = {{ quote .Config.AutoScaling.HealthCheckType }}
+  health_check_grace_period = {{ .Config.AutoScaling.HealthCheckGracePeriod }}
+{{- with .Config.AutoScaling.MaxInstanceLifetime }}
+  max_instance_lifetime     = {{ . }}
+{{- end }}
+{{- if .Config.AutoScaling.NewInstancesProtectedFromScaleIn }}
+  protect_from_scale_in     = true
+{{- end }}
+  termination_policies      = [{{ range $i, $policy := .Config.AutoScaling.TerminationPolicies }}{{ if $i }}, {{ end }}{{ quote $policy }}{{ end }}]
+{{- if .Config.AttachesTargetGroup }}
+  target_group_arns         = [aws_lb_target_group.main.arn]
+{{- end }}
+  vpc_zone_identifier       = [{{ range $i, $subnet := .GroupSubnets }}{{ if $i }}, {{ end }}aws_subnet.subnet_{{ $i }}.id{{ end }}]
+{{- with .Config.AutoScaling.GroupMetrics }}
+  metrics_granularity       = "1Minute"
+  enabled_metrics           = [{{ range $i, $metric := . }}{{ if $i }}, {{ end }}{{ quote $metric }}{{ end }}]
+{{- end }}
+{{- with $.Config.AutoScaling.InstanceRequirements }}
+
+  mixed_instances_policy {
+    launch_template {
+      launch_template_specification {
+        launch_template_id = aws_launch_template.main.id
+        version            = {{ quote $.LaunchTemplateVersion }}
+      }
+{{ template "instanceRequirements" . }}
+    }
+  }
+{{- else }}
+
+  launch_template {
+    id      = aws_launch_template.main.id
+    version = {{ quote $.LaunchTemplateVersion }}
+  }
+{{- end }}
+{{- template "groupTags" $.Config.StackTags }}
+}
+
+{{ with .Config.Canary -}}
+resource "aws_lb_target_group" "canary" {
+  name             = {{ quote .TargetGroupName }}
+  protocol         = "HTTP"
+  port             = {{ $.Config.TargetGroup.Port }}
+  vpc_id           = aws_vpc.main.id
+  target_type      = "instance"
+  protocol_version = {{ quote $.Config.TargetGroup.ProtocolVersion }}
+  slow_start       = {{ $.Config.TargetGroup.SlowStartSeconds }}
+
+  deregistration_delay = {{ $.Config.TargetGroup.DeregistrationDelaySeconds }}
+
+  health_check {
+    path                = {{ quote $.Config.TargetGroup.HealthCheck.Path }}
+    interval            = {{ $.Config.TargetGroup.HealthCheck.IntervalSeconds }}
+    timeout             = {{ $.Config.TargetGroup.HealthCheck.TimeoutSeconds }}
+    healthy_threshold   = {{ $.Config.TargetGroup.HealthCheck.HealthyThreshold }}
+    unhealthy_threshold = {{ $.Config.TargetGroup.HealthCheck.UnhealthyThreshold }}
+    matcher             = {{ quote $.Config.TargetGroup.HealthCheck.Matcher }}
+  }
+}
+
+resource "aws_autoscaling_group" "canary" {
+  name                      = {{ quote .AutoScalingGroupName }}
+  min_size                  = {{ .Size }}
+  max_size                  = {{ .Size }}
+  desired_capacity          = {{ .Size }}
+  health_check_type         = {{ quote $.Config.AutoScaling.HealthCheckType }}
+  health_check_grace_period = {{ $.Config.AutoScaling.HealthCheckGracePeriod }}
+  target_group_arns         = [aws_lb_target_group.canary.arn]
+  vpc_zone_identifier       = [{{ range $i, $subnet := $.GroupSubnets }}{{ if $i }}, {{ end }}aws_subnet.subnet_{{ $i }}.id{{ end }}]
+{{- with $.Config.AutoScaling.InstanceRequirements }}
+
+  mixed_instances_policy {
+    launch_template {
+      launch_template_specification {
+        launch_template_id = aws_launch_template.main.id
+        version            = "$Latest"
+      }
+{{ template "instanceRequirements" . }}
+    }
+  }
+{{- else }}
+
+  launch_template {
+    id      = aws_launch_template.main.id
+    version = "$Latest"
+  }
+{{- end }}
+{{- template "groupTags" $.Config.StackTags }}
+}
+
+{{ end -}}
+{{ with .Config.ECS -}}
+resource "aws_ecs_capacity_provider" "main" {
+  name = {{ quote .CapacityProviderName }}
+
+  auto_scaling_group_provider {
+    auto_scaling_group_arn         = aws_autoscaling_group.main.arn
+    managed_termination_protection = {{ quote .ManagedTerminationProtectionStatus }}
+
+    managed_scaling {
+      status          = "ENABLED"
+      target_capacity = {{ .TargetCapacity }}
+    }
+  }
+}
+
+resource "aws_ecs_cluster_capacity_providers" "main" {
+  cluster_name       = aws_ecs_cluster.main.name
+  capacity_providers = [aws_ecs_capacity_provider.main.name]
+
+  default_capacity_provider_strategy {
+    capacity_provider = aws_ecs_capacity_provider.main.name
+    weight            = 1
+  }
+}
+
+{{ else -}}
+resource "aws_autoscaling_policy" "cpu" {
+  name                   = {{ quote .PolicyName }}
+  autoscaling_group_name = aws_autoscaling_group.main.name
+  policy_type            = {{ quote .PolicyType }}
+
+  target_tracking_configuration {
+{{- with .Config.AutoScaling.CustomMetric }}
+    customized_metric_specification {
+      namespace   = {{ quote .Namespace }}
+      metric_name = {{ quote .MetricName }}
+      statistic   = {{ quote .Statistic }}
+{{- with .Unit }}
+      unit        = {{ quote . }}
+{{- end }}
+{{- range $name, $value := .Dimensions }}
+
+      metric_dimension {
+        name  = {{ quote $name }}
+        value = {{ quote $value }}
+      }
+{{- end }}
+    }
+{{- else }}
+{{- with .MetricQueries }}
+    customized_metric_specification {
+{{- range . }}
+      metrics {
+        id          = {{ quote .ID }}
+        return_data = {{ .ReturnData }}
+{{- if .Expression }}
+        expression  = {{ quote .Expression }}
+{{- else }}
+
+        metric_stat {
+          stat = {{ quote .Stat }}
+
+          metric {
+            namespace   = {{ quote .Namespace }}
+            metric_name = {{ quote .MetricName }}
+{{- range $name, $value := .Dimensions }}
+
+            dimensions {
+              name  = {{ quote $name }}
+              value = {{ quote $value }}
+            }
+{{- end }}
+          }
+        }
+{{- end }}
+      }
+{{- end }}
+    }
+{{- else }}
+    predefined_metric_specification {
+      predefined_metric_type = "ASGAverageCPUUtilization"
+    }
+{{- end }}
+{{- end }}
+    target_value = {{ .Config.AutoScaling.TargetValue }}
+  }
+}
+
+{{ end -}}
+{{ with .Config.CodeDeploy -}}
+resource "aws_iam_role" "codedeploy" {
+  name               = {{ quote .ServiceRoleName }}
+  assume_role_policy = {{ quote .TrustPolicy }}
+}
+
+resource "aws_iam_role_policy_attachment" "codedeploy" {
+  role       = aws_iam_role.codedeploy.name
+  policy_arn = {{ quote $.CodeDeployRolePolicyARN }}
+}
+
+resource "aws_iam_role_policy" "codedeploy_launch_template" {
+  name   = {{ quote $.CodeDeployLaunchTemplatePolicyName }}
+  role   = aws_iam_role.codedeploy.id
+  policy = {{ quote .LaunchTemplatePolicy }}
+}
+
+resource "aws_codedeploy_app" "main" {
+  name             = {{ quote .ApplicationName }}
+  compute_platform = "Server"
+}
+
+resource "aws_codedeploy_deployment_group" "main" {
+  app_name               = aws_codedeploy_app.main.name
+  deployment_group_name  = {{ quote .DeploymentGroupName }}
+  service_role_arn       = aws_iam_role.codedeploy.arn
+  deployment_config_name = {{ quote .DeploymentConfigName }}
+  autoscaling_groups     = [aws_autoscaling_group.main.name]
+
+  deployment_style {
+    deployment_type   = {{ quote .DeploymentType }}
+    deployment_option = "WITH_TRAFFIC_CONTROL"
+  }
+
+  load_balancer_info {
+    target_group_info {
+      name = aws_lb_target_group.main.name
+    }
+  }
+{{- if .BlueGreen }}
+
+  blue_green_deployment_config {
+    deployment_ready_option {
+      action_on_timeout = "CONTINUE_DEPLOYMENT"
+    }
+
+    green_fleet_provisioning_option {
+      action = "COPY_AUTO_SCALING_GROUP"
+    }
+
+    terminate_blue_instances_on_deployment_success {
+      action                           = "TERMINATE"
+      termination_wait_time_in_minutes = {{ .TerminationWaitMinutes }}
+    }
+  }
+{{- end }}
+}
+
+{{ end -}}
+resource "aws_lb" "main" {
+  name               = {{ quote .Config.LoadBalancer.Name }}
+  internal           = {{ .Config.LoadBalancer.Internal }}
+  load_balancer_type = "application"
+  ip_address_type    = "ipv4"
+  security_groups    = [aws_security_group.main.id]
+{{- if .Config.LoadBalancer.Internal }}
+  subnets            = [{{ range $i, $subnet := .PrivateSubnets }}{{ if $i }}, {{ end }}aws_subnet.private_subnet_{{ $i }}.id{{ end }}]
+{{- else }}
+  subnets            = [{{ range $i, $subnet := .PublicSubnets }}{{ if $i }}, {{ end }}aws_subnet.subnet_{{ $i }}.id{{ end }}]
+{{- end }}
+}
+
+{{ with .Config.Listener.MutualTLS -}}
+resource "aws_lb_trust_store" "main" {
+  name                             = {{ quote .TrustStoreName }}
+  ca_certificates_bundle_s3_bucket = {{ quote .CABundleBucket }}
+  ca_certificates_bundle_s3_key    = {{ quote .CABundleKey }}
+}
+
+{{ end -}}
+{{ with .Config.Listener.Authentication }}{{ if eq .Type "oidc" -}}
+data "aws_secretsmanager_secret_version" "oidc_client" {
+  secret_id = {{ quote .ClientSecretID }}
+}
+
+locals {
+  oidc_client = jsondecode(data.aws_secretsmanager_secret_version.oidc_client.secret_string)
+}
+
+{{ end }}{{ end -}}
+resource "aws_lb_listener" "http" {
+  load_balancer_arn = aws_lb.main.arn
+  protocol          = {{ quote .Config.Listener.Protocol }}
+  port              = {{ .Config.Listener.Port }}
+{{- with .Config.Listener.CertificateARN }}
+  certificate_arn   = {{ quote . }}
+  ssl_policy        = {{ quote $.Config.Listener.SSLPolicy }}
+{{- end }}
+{{- with .Config.Listener.MutualTLS }}
+
+  mutual_authentication {
+    mode                             = "verify"
+    trust_store_arn                  = aws_lb_trust_store.main.arn
+    ignore_client_certificate_expiry = {{ .IgnoreClientCertificateExpiry }}
+  }
+{{- end }}
+{{- with .Config.Listener.Authentication }}
+
+  default_action {
+{{- template "authenticateAction" . }}
+  }
+{{- end }}
+
+  default_action {
+    type             = "forward"
+{{- if .Config.Listener.Authentication }}
+    order            = 2
+{{- end }}
+{{- template "forwardTargetGroups" . }}
+  }
+}
+
+{{ range $i, $arn := .Config.Listener.AdditionalCertificateARNs -}}
+resource "aws_lb_listener_certificate" "sni_{{ $i }}" {
+  listener_arn    = aws_lb_listener.http.arn
+  certificate_arn = {{ quote $arn }}
+}
+
+{{ end -}}
+{{ range $i, $listener := .Config.AdditionalListeners -}}
+resource "aws_lb_listener" "additional_{{ $i }}" {
+  load_balancer_arn = aws_lb.main.arn
+  protocol          = {{ quote .Protocol }}
+  port              = {{ .Port }}
+{{- with .CertificateARN }}
+  certificate_arn   = {{ quote . }}
+  ssl_policy        = {{ quote $listener.SSLPolicy }}
+{{- end }}
+
+  default_action {
+{{- with .Redirect }}
+    type = "redirect"
+
+    redirect {
+      protocol    = {{ quote .Protocol }}
+      port        = {{ quote (print .Port) }}
+      status_code = {{ quote .StatusCodeName }}
+    }
+{{- else }}
+    type             = "forward"
+{{- template "forwardTargetGroups" $ }}
+{{- end }}
+  }
+}
+
+{{ range $j, $arn := .AdditionalCertificateARNs -}}
+resource "aws_lb_listener_certificate" "additional_{{ $i }}_sni_{{ $j }}" {
+  listener_arn    = aws_lb_listener.additional_{{ $i }}.arn
+  certificate_arn = {{ quote $arn }}
+}
+
+{{ end -}}
+{{ end -}}
+{{ range $i, $lambda := .Config.LambdaTargets -}}
+resource "aws_lambda_permission" "lambda_{{ $i }}" {
+  statement_id  = {{ quote $lambda.StatementID }}
+  function_name = {{ quote $lambda.FunctionARN }}
+  action        = "lambda:InvokeFunction"
+  principal     = "elasticloadbalancing.amazonaws.com"
+  source_arn    = aws_lb_target_group.lambda_{{ $i }}.arn
+}
+
+resource "aws_lb_target_group" "lambda_{{ $i }}" {
+  name                               = {{ quote $lambda.Name }}
+  target_type                        = "lambda"
+  lambda_multi_value_headers_enabled = {{ $lambda.MultiValueHeaders }}
+}
+
+resource "aws_lb_target_group_attachment" "lambda_{{ $i }}" {
+  target_group_arn = aws_lb_target_group.lambda_{{ $i }}.arn
+  target_id        = {{ quote $lambda.FunctionARN }}
+  depends_on       = [aws_lambda_permission.lambda_{{ $i }}]
+}
+
+resource "aws_lb_listener_rule" "lambda_{{ $i }}" {
+  listener_arn = aws_lb_listener.http.arn
+  priority     = {{ $lambda.Priority }}
+
+  condition {
+    path_pattern {
+      values = [{{ range $j, $pattern := $lambda.PathPatterns }}{{ if $j }}, {{ end }}{{ quote $pattern }}{{ end }}]
+    }
+  }
+{{- with $.Config.Listener.Authentication }}
+
+  action {
+{{- template "authenticateAction" . }}
+  }
+{{- end }}
+
+  action {
+    type             = "forward"
+{{- if $.Config.Listener.Authentication }}
+    order            = 2
+{{- end }}
+    target_group_arn = aws_lb_target_group.lambda_{{ $i }}.arn
+  }
+}
+
+{{ end -}}
+{{ range $i, $rule := .Config.HostRules -}}
+resource "aws_lb_listener_rule" "host_{{ $i }}" {
+  listener_arn = aws_lb_listener.http.arn
+  priority     = {{ $rule.Priority }}
+
+  condition {
+    host_header {
+      values = [{{ range $j, $host := $rule.HostHeaders }}{{ if $j }}, {{ end }}{{ quote $host }}{{ end }}]
+    }
+  }
+{{- with $rule.PathPatterns }}
+
+  condition {
+    path_pattern {
+      values = [{{ range $j, $pattern := . }}{{ if $j }}, {{ end }}{{ quote $pattern }}{{ end }}]
+    }
+  }
+{{- end }}
+{{- with $.Config.Listener.Authentication }}
+
+  action {
+{{- template "authenticateAction" . }}
+  }
+{{- end }}
+
+  action {
+    type             = "forward"
+{{- if $.Config.Listener.Authentication }}
+    order            = 2
+{{- end }}
+    target_group_arn = {{ quote $rule.TargetGroupARN }}
+  }
+}
+
+{{ end -}}
+output "load_balancer_dns_name" {
+  value = aws_lb.main.dns_name
+}
+{{- range .Config.ElasticIPs }}
+
+output "elastic_ip_{{ tfName .Name }}" {
+  value = aws_eip.{{ tfName .Name }}.public_ip
+}
+{{- end }}
+{{- if .Config.Bastion }}
+
+output "bastion_public_ip" {
+  value = aws_instance.bastion.public_ip
+}
+{{- end }}
+{{- define "naclEntry" }}
+    rule_no    = {{ .RuleNumber }}
+    protocol   = {{ quote .ProtocolNumber }}
+    action     = {{ quote .Action }}
+    cidr_block = {{ quote .CIDR }}
+    from_port  = {{ if .HasPorts }}{{ .FromPort }}{{ else }}0{{ end }}
+    to_port    = {{ if .HasPorts }}{{ .ToPort }}{{ else }}0{{ end }}
+{{- if eq .Protocol "icmp" }}
+    icmp_type  = -1
+    icmp_code  = -1
+{{- end }}
+{{- end }}
+{{- define "rule" }}
+{{- $pad := "" }}{{ if or .PrefixListIDs .PrefixListNames }}{{ $pad = "    " }}{{ end }}
+    protocol    {{ $pad }}= {{ quote .Protocol }}
+    from_port   {{ $pad }}= {{ if eq .Protocol "-1" }}0{{ else }}{{ .FromPort }}{{ end }}
+    to_port     {{ $pad }}= {{ if eq .Protocol "-1" }}0{{ else }}{{ .ToPort }}{{ end }}
+{{- with .CIDRs }}
+    cidr_blocks {{ $pad }}= [{{ range $i, $cidr := . }}{{ if $i }}, {{ end }}{{ quote $cidr }}{{ end }}]
+{{- end }}
+{{- if or .PrefixListIDs .PrefixListNames }}
+    prefix_list_ids = [
+      {{- range $i, $id := .PrefixListIDs }}{{ if $i }}, {{ end }}{{ quote $id }}{{ end }}
+      {{- range $i, $name := .PrefixListNames }}{{ if or $i $.PrefixListIDs }}, {{ end }}data.aws_ec2_managed_prefix_list.{{ tfName $name }}.id{{ end -}}
+    ]
+{{- end }}
+{{- with .Description }}
+    description {{ $pad }}= {{ quote . }}
+{{- end }}
+{{- end }}
+{{- define "authenticateAction" }}
+    type  = "authenticate-{{ .Type }}"
+    order = 1
+{{- if eq .Type "cognito" }}
+
+    authenticate_cognito {
+      user_pool_arn              = {{ quote .UserPoolARN }}
+      user_pool_client_id        = {{ quote .UserPoolClientID }}
+      user_pool_domain           = {{ quote .UserPoolDomain }}
+{{- else }}
+
+    authenticate_oidc {
+      issuer                     = {{ quote .Issuer }}
+      authorization_endpoint     = {{ quote .AuthorizationEndpoint }}
+      token_endpoint             = {{ quote .TokenEndpoint }}
+      user_info_endpoint         = {{ quote .UserInfoEndpoint }}
+      client_id                  = local.oidc_client.clientId
+      client_secret              = local.oidc_client.clientSecret
+{{- end }}
+{{- with .Scope }}
+      scope                      = {{ quote . }}
+{{- end }}
+{{- with .SessionTimeoutSeconds }}
+      session_timeout            = {{ . }}
+{{- end }}
+      on_unauthenticated_request = {{ quote .OnUnauthenticatedRequest }}
+    }
+{{- end }}
+{{- define "forwardTargetGroups" }}
+{{- if .Config.Canary }}
+
+    forward {
+      target_group {
+        arn    = aws_lb_target_group.main.arn
+        weight = {{ .MainWeight }}
+      }
+
+      target_group {
+        arn    = aws_lb_target_group.canary.arn
+        weight = {{ .State.CanaryWeight }}
+      }
+    }
+{{- else }}
+    target_group_arn = aws_lb_target_group.main.arn
+{{- end }}
+{{- end }}
+{{- define "instanceRequirements" }}      override {
+        instance_requirements {
+          vcpu_count {
+            min = {{ .VCPUs.Min }}
+{{- with .VCPUs.Max }}
+            max = {{ . }}
+{{- end }}
+          }
+
+          memory_mib {
+            min = {{ .MemoryMiB.Min }}
+{{- with .MemoryMiB.Max }}
+            max = {{ . }}
+{{- end }}
+          }
+
+          cpu_manufacturers       = [{{ range $i, $manufacturer := .CPUManufacturers }}{{ if $i }}, {{ end }}{{ quote $manufacturer }}{{ end }}]
+{{- with .ExcludedInstanceTypes }}
+          excluded_instance_types = [{{ range $i, $instanceType := . }}{{ if $i }}, {{ end }}{{ quote $instanceType }}{{ end }}]
+{{- end }}
+{{- with .BurstablePerformance }}
+          burstable_performance   = {{ quote . }}
+{{- end }}
+        }
+      }
+{{- end }}
+{{- define "groupTags" }}
+{{- range $key, $value := . }}
+
+  tag {
+    key                 = {{ quote $key }}
+    value               = {{ quote $value }}
+    propagate_at_launch = true
+  }
+{{- end }}
+{{- end }}
+{{- define "volumeKey" }}
+{{- with . }}
+      kms_key_id            = {{ if .CreatesKey }}aws_kms_key.ebs.arn{{ else }}{{ quote .KeyARN }}{{ end }}
+{{- end }}
+{{- end }}
+{{- define "zone" }}{{ if .AvailabilityZone }}{{ quote .AvailabilityZone }}{{ else }}data.aws_availability_zones.available.names[{{ .ZoneIndex }}]{{ end }}{{ end }}
+`))
+
+// terraformImportTemplate maps the identifiers recorded in the state file to
+// the addresses used in main.tf. Terraform may still plan changes for
+// attributes the tool does not record, such as the security group name.
+var terraformImportTemplate = template.Must(template.New("import").Funcs(template.FuncMap{
+	"tfName": terraformName,
+}).Parse(`#!/bin/bash
+set -euo pipefail
+
+{{ if .State.IsEmpty -}}
+# The state file does not contain any resources yet, run apply first.
+{{- else -}}
+{{ with .State -}}
+terraform import aws_vpc.main {{ .VPCID }}
+{{- if .FlowLogGroupName }}
+terraform import aws_cloudwatch_log_group.flow_logs {{ .FlowLogGroupName }}
+{{- end }}
+{{- if .FlowLogsRoleName }}
+terraform import aws_iam_role.flow_logs {{ .FlowLogsRoleName }}
+terraform import aws_iam_role_policy.flow_logs {{ .FlowLogsRoleName }}:flow-logs-delivery
+{{- end }}
+{{- if .FlowLogID }}
+terraform import aws_flow_log.main {{ .FlowLogID }}
+{{- end }}
+{{- with $.Config.Logs }}
+{{- if $.State.HasLogGroup .App.Name }}
+terraform import aws_cloudwatch_log_group.app_logs {{ .App.Name }}
+{{- end }}
+{{- if $.State.HasLogGroup .Access.Name }}
+terraform import aws_cloudwatch_log_group.access_logs {{ .Access.Name }}
+{{- end }}
+{{- if $.State.HasLogGroup .System.Name }}
+terraform import aws_cloudwatch_log_group.system_logs {{ .System.Name }}
+{{- end }}
+{{- end }}
+terraform import aws_internet_gateway.main {{ .InternetGatewayID }}
+terraform import aws_route_table.main {{ .RouteTableID }}
+terraform import aws_route.internet {{ .RouteTableID }}_0.0.0.0/0
+{{- range $i, $subnetID := .SubnetIDs }}
+terraform import aws_subnet.subnet_{{ $i }} {{ $subnetID }}
+terraform import aws_route_table_association.subnet_{{ $i }} {{ $subnetID }}/{{ $.State.RouteTableID }}
+{{- end }}
+{{- range $i, $subnetID := .PrivateSubnetIDs }}
+terraform import aws_subnet.private_subnet_{{ $i }} {{ $subnetID }}
+{{- end }}
+{{ if .SecurityGroupID }}terraform import aws_security_group.main {{ .SecurityGroupID }}
+{{ end -}}
+{{ if .BastionSecurityGroupID }}terraform import aws_security_group.bastion {{ .BastionSecurityGroupID }}
+{{ if .SecurityGroupID }}terraform import aws_security_group_rule.ssh_from_bastion {{ .SecurityGroupID }}_ingress_tcp_{{ $.SSHPort }}_{{ $.SSHPort }}_{{ .BastionSecurityGroupID }}
+{{ end -}}
+{{ end -}}
+{{ if .BastionInstanceID }}terraform import aws_instance.bastion {{ .BastionInstanceID }}
+{{ end -}}
+{{ if .InstanceConnectSecurityGroupID }}terraform import aws_security_group.instance_connect {{ .InstanceConnectSecurityGroupID }}
+{{ if .SecurityGroupID }}terraform import aws_security_group_rule.ssh_from_instance_connect {{ .SecurityGroupID }}_ingress_tcp_{{ $.SSHPort }}_{{ $.SSHPort }}_{{ .InstanceConnectSecurityGroupID }}
+{{ end -}}
+{{ end -}}
+{{ if .InstanceConnectEndpointID }}terraform import aws_ec2_instance_connect_endpoint.main {{ .InstanceConnectEndpointID }}
+{{ end -}}
+{{ with .LifecycleEvents -}}
+{{ if .RuleName }}terraform import aws_cloudwatch_event_rule.lifecycle {{ .RuleName }}
+terraform import aws_cloudwatch_event_target.lifecycle {{ .RuleName }}/{{ $.LifecycleEventsTargetID }}
+{{ end -}}
+{{ if .TopicARN }}terraform import aws_sns_topic.lifecycle {{ .TopicARN }}
+terraform import aws_sns_topic_policy.lifecycle {{ .TopicARN }}
+{{ end -}}
+{{ if .StatementID }}terraform import aws_lambda_permission.lifecycle_events {{ .FunctionARN }}/{{ .StatementID }}
+{{ end -}}
+{{ $subscriptions := .Subscriptions -}}
+{{ with $.Config.LifecycleEvents }}{{ range $i, $email := .Emails }}{{ with index $subscriptions $email -}}
+terraform import aws_sns_topic_subscription.lifecycle_email_{{ $i }} {{ . }}
+{{ end }}{{ end }}{{ end -}}
+{{ end -}}
+{{ if .ECSClusterARN }}terraform import aws_ecs_cluster.main {{ $.Config.ECS.ClusterName }}
+{{ end -}}
+{{ range $i, $secret := $.Config.Secrets }}{{ with index $.State.SecretARNs $secret.Path -}}
+{{ if eq $secret.Store $.SecretStoreSecretsManager }}terraform import aws_secretsmanager_secret.secret_{{ $i }} {{ . }}
+{{ else }}terraform import aws_ssm_parameter.secret_{{ $i }} {{ $secret.Path }}
+{{ end -}}
+{{ end }}{{ end -}}
+{{ if .InstanceRoleName }}terraform import aws_iam_role.instance {{ .InstanceRoleName }}
+{{ if $.Config.ECS }}terraform import aws_iam_role_policy_attachment.instance_ecs {{ .InstanceRoleName }}/{{ $.ECSInstanceRolePolicyARN }}
+{{ end -}}
+{{ if $.Config.SessionManagerOnly }}terraform import aws_iam_role_policy_attachment.instance_ssm {{ .InstanceRoleName }}/{{ $.SSMManagedInstanceCorePolicyARN }}
+{{ end -}}
+{{ if $.Config.Secrets }}terraform import aws_iam_role_policy.instance_secrets {{ .InstanceRoleName }}:{{ $.SecretsPolicyName }}
+{{ end -}}
+{{ if $.Config.Logs }}terraform import aws_iam_role_policy.instance_logs {{ .InstanceRoleName }}:{{ $.LogsPolicyName }}
+{{ end -}}
+terraform import aws_iam_instance_profile.instance {{ .InstanceRoleName }}
+{{ end -}}
+{{ if .PlacementGroupName }}terraform import aws_placement_group.main {{ .PlacementGroupName }}
+{{ end -}}
+{{ range $address := $.Config.ElasticIPs }}{{ $recorded := index $.State.ElasticIPs $address.Name }}{{ with $recorded.AllocationID -}}
+terraform import aws_eip.{{ tfName $address.Name }} {{ . }}
+{{ with $recorded.AssociationID }}terraform import aws_eip_association.{{ tfName $address.Name }} {{ . }}
+{{ end -}}
+{{ end }}{{ end -}}
+{{ if .VolumeKeyAlias }}terraform import aws_kms_key.ebs {{ .VolumeKeyID }}
+terraform import aws_kms_alias.ebs {{ .VolumeKeyAlias }}
+{{ end -}}
+{{ if .VolumeKeyGrantID }}terraform import aws_kms_grant.ebs {{ .VolumeKeyID }}:{{ .VolumeKeyGrantID }}
+{{ end -}}
+{{ if .LaunchTemplateID }}terraform import aws_launch_template.main {{ .LaunchTemplateID }}
+{{ end -}}
+{{ if .TargetGroupARN }}terraform import aws_lb_target_group.main {{ .TargetGroupARN }}
+{{ end -}}
+{{ if .AutoScalingGroupName }}terraform import aws_autoscaling_group.main {{ .AutoScalingGroupName }}
+{{ end -}}
+{{ if .CanaryTargetGroupARN }}terraform import aws_lb_target_group.canary {{ .CanaryTargetGroupARN }}
+{{ end -}}
+{{ if .CanaryAutoScalingGroupName }}terraform import aws_autoscaling_group.canary {{ .CanaryAutoScalingGroupName }}
+{{ end -}}
+{{ if .ScalingPolicyName }}terraform import aws_autoscaling_policy.cpu {{ .AutoScalingGroupName }}/{{ .ScalingPolicyName }}
+{{ end -}}
+{{ if .CapacityProviderName }}terraform import aws_ecs_capacity_provider.main {{ .CapacityProviderName }}
+terraform import aws_ecs_cluster_capacity_providers.main {{ $.Config.ECS.ClusterName }}
+{{ end -}}
+{{ if .CodeDeployRoleName }}terraform import aws_iam_role.codedeploy {{ .CodeDeployRoleName }}
+terraform import aws_iam_role_policy_attachment.codedeploy {{ .CodeDeployRoleName }}/{{ $.CodeDeployRolePolicyARN }}
+terraform import aws_iam_role_policy.codedeploy_launch_template {{ .CodeDeployRoleName }}:{{ $.CodeDeployLaunchTemplatePolicyName }}
+{{ end -}}
+{{ if .CodeDeployApplicationName }}terraform import aws_codedeploy_app.main {{ .CodeDeployApplicationName }}
+{{ end -}}
+{{ if .CodeDeployDeploymentGroupName }}terraform import aws_codedeploy_deployment_group.main {{ .CodeDeployApplicationName }}:{{ .CodeDeployDeploymentGroupName }}
+{{ end -}}
+{{ if .LoadBalancerARN }}terraform import aws_lb.main {{ .LoadBalancerARN }}
+{{ end -}}
+{{ if .TrustStoreARN }}terraform import aws_lb_trust_store.main {{ .TrustStoreARN }}
+{{ end -}}
+{{ if .ListenerARN }}terraform import aws_lb_listener.http {{ .ListenerARN }}
+{{ end -}}
+{{- range $i, $listener := $.Config.AdditionalListeners }}
+{{- with index $.State.AdditionalListenerARNs $listener.Port }}
+terraform import aws_lb_listener.additional_{{ $i }} {{ . }}
+{{- end }}
+{{- end }}
+{{- range $i, $ruleARN := .HostRuleARNs }}
+terraform import aws_lb_listener_rule.host_{{ $i }} {{ $ruleARN }}
+{{- end }}
+{{- range $i, $lambda := .LambdaTargets }}
+{{- if $lambda.TargetGroupARN }}
+terraform import aws_lb_target_group.lambda_{{ $i }} {{ $lambda.TargetGroupARN }}
+{{- end }}
+{{- if $lambda.StatementID }}
+terraform import aws_lambda_permission.lambda_{{ $i }} {{ $lambda.FunctionARN }}/{{ $lambda.StatementID }}
+{{- end }}
+{{- if $lambda.RuleARN }}
+terraform import aws_lb_listener_rule.lambda_{{ $i }} {{ $lambda.RuleARN }}
+{{- end }}
+{{- end }}
+{{ end -}}
+{{ end }}
+`))
+
+// ExportTerraform writes main.tf, a copy of the user data script and an
+// import.sh script adopting the resources recorded in state into dir.
+func ExportTerraform(cfg *Config, state *State, dir string) error {
+	userData, err := UserData(cfg.LaunchTemplate)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, TerraformUserDataDir), 0o755); err != nil {
+		return fmt.Errorf("error creating terraform directory: %w", err)
+	}
+
+	userDataName := filepath.Base(cfg.LaunchTemplate.UserDataFile)
+	if len(cfg.LaunchTemplate.UserDataParts) > 0 {
+		userDataName = MultipartUserDataFile
+	}
+	userDataFile := filepath.ToSlash(filepath.Join(TerraformUserDataDir, userDataName))
+	if err := os.WriteFile(filepath.Join(dir, userDataFile), userData, 0o644); err != nil {
+		return fmt.Errorf("error writing user data: %w", err)
+	}
+
+	autoscalingGroupName := state.AutoScalingGroupName
+	if autoscalingGroupName == "" {
+		autoscalingGroupName = cfg.AutoScaling.Name
+	}
+	policyName := state.ScalingPolicyName
+	if policyName == "" {
+		policyName = cfg.AutoScaling.PolicyName
+	}
+
+	subnets, err := SubnetLayout(cfg.VPC, nil)
+	if err != nil {
+		return err
+	}
+	publicSubnets, privateSubnets := SplitSubnets(subnets)
+
+	var mainTF bytes.Buffer
+	if err := terraformTemplate.Execute(&mainTF, map[string]any{
+		"Config":                             cfg,
+		"PublicSubnets":                      publicSubnets,
+		"PrivateSubnets":                     privateSubnets,
+		"GroupSubnets":                       GroupSubnets(cfg, publicSubnets),
+		"State":                              state,
+		"AutoScalingGroupName":               autoscalingGroupName,
+		"PolicyName":                         policyName,
+		"UserDataFile":                       userDataFile,
+		"PolicyType":                         AWSAutoscalingPolicyType,
+		"MetricQueries":                      cfg.AutoScaling.MetricQueries(autoscalingGroupName),
+		"EndpointSubnets":                    endpointSubnetRefs(publicSubnets, privateSubnets, "aws_subnet.subnet_%d.id", "aws_subnet.private_subnet_%d.id"),
+		"EndpointsSecurityGroupDescription":  EndpointsSecurityGroupDescription,
+		"LaunchTemplateVersion":              AWSLaunchTemplateVersion,
+		"MainWeight":                         MaxCanaryWeight - state.CanaryWeight,
+		"InstanceTrustPolicy":                InstanceTrustPolicy(),
+		"ECSInstanceRolePolicyARN":           ECSInstanceRolePolicyARN,
+		"SSMManagedInstanceCorePolicyARN":    SSMManagedInstanceCorePolicyARN,
+		"CodeDeployRolePolicyARN":            CodeDeployRolePolicyARN,
+		"CodeDeployLaunchTemplatePolicyName": CodeDeployLaunchTemplatePolicyName,
+		"SecretsPolicyName":                  SecretsPolicyName,
+		"LogsPolicyName":                     LogsPolicyName,
+		"SecretStoreSecretsManager":          SecretStoreSecretsManager,
+		"SSHPort":                            SSHPort,
+		"LifecycleEventPattern":              LifecycleEventPattern(LifecycleEventGroups(cfg)),
+		"LifecycleEventsTargetID":            LifecycleEventsTargetID,
+		"EventsServicePrincipal":             EventsServicePrincipal,
+	}); err != nil {
+		return fmt.Errorf("error rendering terraform configuration: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TerraformMainFile), mainTF.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing %s: %w", TerraformMainFile, err)
+	}
+
+	var importScript bytes.Buffer
+	if err := terraformImportTemplate.Execute(&importScript, map[string]any{
+		"Config":                             cfg,
+		"State":                              state,
+		"ECSInstanceRolePolicyARN":           ECSInstanceRolePolicyARN,
+		"SSMManagedInstanceCorePolicyARN":    SSMManagedInstanceCorePolicyARN,
+		"CodeDeployRolePolicyARN":            CodeDeployRolePolicyARN,
+		"CodeDeployLaunchTemplatePolicyName": CodeDeployLaunchTemplatePolicyName,
+		"SecretsPolicyName":                  SecretsPolicyName,
+		"LogsPolicyName":                     LogsPolicyName,
+		"SecretStoreSecretsManager":          SecretStoreSecretsManager,
+		"SSHPort":                            SSHPort,
+		"LifecycleEventsTargetID":            LifecycleEventsTargetID,
+	}); err != nil {
+		return fmt.Errorf("error rendering terraform import script: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, TerraformImportFile), importScript.Bytes(), 0o755); err != nil {
+		return fmt.Errorf("error writing %s: %w", TerraformImportFile, err)
+	}
+
+	return nil
+}
+
+// terraformName turns name into a Terraform identifier.
+func terraformName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
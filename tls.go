@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbTypes "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	MutualTLSModeVerify    = "verify"
+	TrustStorePollInterval = 5 * time.Second
+	ListenerProtocolHTTP   = "HTTP"
+	ListenerProtocolHTTPS  = "HTTPS"
+	// DefaultSSLPolicy allows TLS 1.3 and 1.2 with forward secrecy only.
+	DefaultSSLPolicy = "ELBSecurityPolicy-TLS13-1-2-2021-06"
+)
+
+// MutualTLSConfig makes the HTTPS listener require client certificates
+// issued by the CAs of a trust store, created from the PEM bundle of CA
+// certificates at CABundleBucket/CABundleKey in S3.
+type MutualTLSConfig struct {
+	TrustStoreName                string `json:"trustStoreName"`
+	CABundleBucket                string `json:"caBundleBucket"`
+	CABundleKey                   string `json:"caBundleKey"`
+	IgnoreClientCertificateExpiry bool   `json:"ignoreClientCertificateExpiry"`
+}
+
+// Protocol is HTTPS when the listener has a certificate and HTTP otherwise.
+func (l ListenerConfig) Protocol() string {
+	if l.CertificateARN != "" {
+		return ListenerProtocolHTTPS
+	}
+	return ListenerProtocolHTTP
+}
+
+// URLScheme is the scheme of URLs served by the listener.
+func (l ListenerConfig) URLScheme() string {
+	if l.CertificateARN != "" {
+		return "https"
+	}
+	return "http"
+}
+
+// CreateTrustStore creates the trust store of the listener from the CA
+// bundle and waits until it can be used.
+func CreateTrustStore(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, mtlsConfig MutualTLSConfig, tags map[string]string) (string, error) {
+	output, err := elbClient.CreateTrustStore(ctx, &elasticloadbalancingv2.CreateTrustStoreInput{
+		Name:                         aws.String(mtlsConfig.TrustStoreName),
+		CaCertificatesBundleS3Bucket: aws.String(mtlsConfig.CABundleBucket),
+		CaCertificatesBundleS3Key:    aws.String(mtlsConfig.CABundleKey),
+		Tags:                         elbTags(tags),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error creating trust store: %w", err)
+	}
+	trustStoreARN := aws.StringValue(output.TrustStores[0].TrustStoreArn)
+	logger.Printf("Trust store created with ARN: %s", trustStoreARN)
+
+	ticker := time.NewTicker(TrustStorePollInterval)
+	defer ticker.Stop()
+	for {
+		output, err := elbClient.DescribeTrustStores(ctx, &elasticloadbalancingv2.DescribeTrustStoresInput{
+			TrustStoreArns: []string{trustStoreARN},
+		})
+		if err != nil {
+			return trustStoreARN, fmt.Errorf("error describing trust store: %w", err)
+		}
+		if len(output.TrustStores) > 0 && output.TrustStores[0].Status == elbTypes.TrustStoreStatusActive {
+			logger.Printf("Trust store %s is active with %d CA certificates", mtlsConfig.TrustStoreName, aws.Int32Value(output.TrustStores[0].NumberOfCaCertificates))
+			return trustStoreARN, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return trustStoreARN, fmt.Errorf("error waiting for trust store: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// mutualAuthentication enables mTLS in verify mode with the trust store.
+func mutualAuthentication(mtlsConfig MutualTLSConfig, trustStoreARN string) *elbTypes.MutualAuthenticationAttributes {
+	return &elbTypes.MutualAuthenticationAttributes{
+		Mode:                          aws.String(MutualTLSModeVerify),
+		TrustStoreArn:                 aws.String(trustStoreARN),
+		IgnoreClientCertificateExpiry: aws.Bool(mtlsConfig.IgnoreClientCertificateExpiry),
+	}
+}
+
+// AddListenerCertificates adds the additional certificates to the HTTPS
+// listener, the load balancer picks the one matching the SNI hostname.
+func AddListenerCertificates(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, listenerARN string, certificateARNs []string) error {
+	if len(certificateARNs) == 0 {
+		return nil
+	}
+
+	certificates := make([]elbTypes.Certificate, 0, len(certificateARNs))
+	for _, certificateARN := range certificateARNs {
+		certificates = append(certificates, elbTypes.Certificate{CertificateArn: aws.String(certificateARN)})
+	}
+	if _, err := elbClient.AddListenerCertificates(ctx, &elasticloadbalancingv2.AddListenerCertificatesInput{
+		ListenerArn:  aws.String(listenerARN),
+		Certificates: certificates,
+	}); err != nil {
+		return fmt.Errorf("error adding certificates to listener: %w", err)
+	}
+	logger.Printf("Added %d certificates to listener %s", len(certificates), listenerARN)
+
+	return nil
+}
+
+// listenerSNICertificates returns the ARNs of the certificates of the
+// listener other than its default one.
+func listenerSNICertificates(ctx context.Context, elbClient *elasticloadbalancingv2.Client, listenerARN string) ([]string, error) {
+	var certificateARNs []string
+	paginator := elasticloadbalancingv2.NewDescribeListenerCertificatesPaginator(elbClient, &elasticloadbalancingv2.DescribeListenerCertificatesInput{
+		ListenerArn: aws.String(listenerARN),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error describing listener certificates: %w", err)
+		}
+		for _, certificate := range page.Certificates {
+			if !aws.BoolValue(certificate.IsDefault) {
+				certificateARNs = append(certificateARNs, aws.StringValue(certificate.CertificateArn))
+			}
+		}
+	}
+	return certificateARNs, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	// DashboardPollInterval is how often the dashboard of apply polls the
+	// instances once the autoscaling group exists.
+	DashboardPollInterval = 10 * time.Second
+	DashboardActivities   = 5
+	// DashboardLogLines is how much of the log is kept, and printed again
+	// once the dashboard is closed.
+	DashboardLogLines = 100
+	// The size of the screen when it can't be read.
+	DefaultDashboardWidth  = 120
+	DefaultDashboardHeight = 40
+)
+
+// Dashboard is the full-screen view of --tui: the steps of apply, the
+// instances with their target health, the recent scaling activities and the
+// log, redrawn in place on the alternate screen. Log lines are written to
+// the dashboard itself. It must only be used when f is a terminal.
+type Dashboard struct {
+	f        *os.File
+	title    string
+	progress *TerminalProgress
+
+	mu      sync.Mutex
+	status  *StackStatus
+	updated time.Time
+	log     []string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewDashboard creates the dashboard of an apply running steps, or of watch
+// without any.
+func NewDashboard(f *os.File, title string, steps []string) *Dashboard {
+	d := &Dashboard{f: f, title: title}
+	if len(steps) > 0 {
+		d.progress = NewTerminalProgress(f, steps)
+	}
+	return d
+}
+
+func (d *Dashboard) Track(step string, fn func() (string, error)) error {
+	return d.progress.Track(step, fn)
+}
+
+// Write adds the lines of p to the log pane, so the dashboard can be the
+// output of a logger.
+func (d *Dashboard) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		d.log = append(d.log, line)
+	}
+	if len(d.log) > DashboardLogLines {
+		d.log = d.log[len(d.log)-DashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+// Logger returns a logger writing to the log pane.
+func (d *Dashboard) Logger() *log.Logger {
+	return log.New(d, "", log.Ltime)
+}
+
+// SetStatus shows the status of the stack in the instance and activity
+// panes.
+func (d *Dashboard) SetStatus(status *StackStatus) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.status = status
+	d.updated = time.Now()
+}
+
+// Poll keeps the status of the stack up to date while apply runs, from the
+// moment the autoscaling group is recorded in state until ctx is done.
+func (d *Dashboard) Poll(ctx context.Context, clients *Clients, state *State) {
+	go func() {
+		ticker := time.NewTicker(DashboardPollInterval)
+		defer ticker.Stop()
+		for {
+			if snapshot := statusSnapshot(state); snapshot.AutoScalingGroupName != "" {
+				if status, err := CollectStatus(ctx, clients, snapshot); err == nil {
+					d.SetStatus(status)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// statusSnapshot copies what CollectStatus reads from a state that apply
+// is still recording into.
+func statusSnapshot(state *State) *State {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return &State{
+		LoadBalancerARN:      state.LoadBalancerARN,
+		TargetGroupARN:       state.TargetGroupARN,
+		AutoScalingGroupName: state.AutoScalingGroupName,
+		CanaryTargetGroupARN: state.CanaryTargetGroupARN,
+		CanaryWeight:         state.CanaryWeight,
+		Maintenance:          state.Maintenance,
+	}
+}
+
+// Start switches to the alternate screen and keeps redrawing the dashboard
+// until Stop is called.
+func (d *Dashboard) Start() {
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{})
+	fmt.Fprint(d.f, "\x1b[?1049h\x1b[?25l")
+
+	go func() {
+		defer close(d.done)
+
+		ticker := time.NewTicker(ProgressRefreshInterval)
+		defer ticker.Stop()
+		for {
+			d.render()
+			select {
+			case <-ticker.C:
+			case <-d.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop leaves the alternate screen, then prints the log and the final state
+// of the steps, which would be lost with the screen.
+func (d *Dashboard) Stop() {
+	close(d.stop)
+	<-d.done
+	fmt.Fprint(d.f, "\x1b[?25h\x1b[?1049l")
+
+	d.mu.Lock()
+	for _, line := range d.log {
+		fmt.Fprintln(d.f, line)
+	}
+	d.mu.Unlock()
+	if d.progress != nil {
+		d.progress.render()
+	}
+}
+
+func (d *Dashboard) render() {
+	width, height, err := term.GetSize(int(d.f.Fd()))
+	if err != nil {
+		width, height = DefaultDashboardWidth, DefaultDashboardHeight
+	}
+
+	var lines []string
+	pane := func(title string, content []string, limit int) {
+		lines = append(lines, "", "\x1b[1m"+title+"\x1b[0m")
+		if len(content) > limit {
+			content = content[len(content)-limit:]
+		}
+		lines = append(lines, content...)
+	}
+
+	d.mu.Lock()
+	status, updated := d.status, d.updated
+	logLines := d.log
+	d.mu.Unlock()
+
+	header := d.title
+	if !updated.IsZero() {
+		header += "  (polled " + updated.Format(time.TimeOnly) + ")"
+	}
+	lines = append(lines, "\x1b[1m"+header+"\x1b[0m")
+
+	if d.progress != nil {
+		steps, done, total := d.progress.stepLines(false)
+		pane(fmt.Sprintf("STEPS %d/%d", done, total), steps, max(3, height/3))
+	}
+
+	var instances, activities []string
+	if status == nil {
+		instances = []string{"waiting for the autoscaling group"}
+	} else {
+		instances = append(instances, fmt.Sprintf("Capacity: %s, %d in service, %d healthy targets", status.Capacity, status.InServiceInstances(), status.HealthyTargets()))
+		instances = append(instances, tableLines(func(tw *tabwriter.Writer) {
+			fmt.Fprintln(tw, "INSTANCE\tZONE\tLIFECYCLE\tHEALTH\tTARGET\tREASON")
+			for _, instance := range status.Instances {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					instance.InstanceID, instance.AvailabilityZone, instance.LifecycleState,
+					instance.HealthStatus, orDash(instance.TargetHealth), orDash(instance.TargetReason))
+			}
+		})...)
+		for i := min(len(status.Activities), DashboardActivities) - 1; i >= 0; i-- {
+			activity := status.Activities[i]
+			activities = append(activities, fmt.Sprintf("%s  %-12s %s", activity.StartTime.Local().Format(time.DateTime), activity.StatusCode, activity.Description))
+		}
+	}
+	pane("INSTANCES", instances, max(3, height/4))
+	pane("SCALING ACTIVITIES", activities, DashboardActivities)
+	pane("LOG", logLines, max(3, height-len(lines)-3))
+
+	// The last line is left empty, so the screen doesn't scroll.
+	if len(lines) >= height {
+		lines = lines[:height-1]
+	}
+	var screen strings.Builder
+	screen.WriteString("\x1b[H")
+	for _, line := range lines {
+		screen.WriteString("\x1b[2K" + truncateLine(line, width) + "\n")
+	}
+	screen.WriteString("\x1b[J")
+	fmt.Fprint(d.f, screen.String())
+}
+
+// tableLines renders a table with tabwriter and returns its lines.
+func tableLines(write func(tw *tabwriter.Writer)) []string {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	write(tw)
+	tw.Flush()
+	return strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// truncateLine cuts line to width characters, not counting the escape
+// sequences of the pane titles.
+func truncateLine(line string, width int) string {
+	if strings.HasPrefix(line, "\x1b[") {
+		return line
+	}
+	runes := []rune(line)
+	if len(runes) <= width {
+		return line
+	}
+	return string(runes[:width])
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	autoscalingTypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// Update brings an existing stack in line with cfg. Only the settings that
+// can be changed in place are compared against the live resources; anything
+// else still requires recreating the stack.
+func Update(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	if len(state.CompletedSteps) > 0 {
+		return fmt.Errorf("the first apply of the stack stopped after %d steps, run apply --resume to finish it or destroy to start over", len(state.CompletedSteps))
+	}
+	if state.AutoScalingGroupName == "" || state.LaunchTemplateID == "" || state.TargetGroupARN == "" {
+		return fmt.Errorf("state does not describe a complete stack, cannot update it")
+	}
+	if state.ExistingLaunchTemplate != (cfg.LaunchTemplate.Existing != nil) {
+		return fmt.Errorf("launchTemplate.existing was added or removed since the stack was created, destroy and apply it again to switch launch templates")
+	}
+
+	if err := UpdateAutoscalingGroup(ctx, logger, clients.AutoScaling, cfg.AutoScaling, state.AutoScalingGroupName); err != nil {
+		return err
+	}
+
+	if state.ScalingPolicyName != "" {
+		if err := UpdateScalingPolicy(ctx, logger, clients.AutoScaling, cfg.AutoScaling, state.AutoScalingGroupName, state.ScalingPolicyName); err != nil {
+			return err
+		}
+	}
+
+	// The instances of the new launch template version read the secrets at
+	// boot, so they are published first.
+	if err := UpdateSecrets(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	if err := AttachSessionManagerPolicy(ctx, logger, clients.IAM, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateLogs(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdatePlacementGroup(ctx, logger, clients.EC2, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateVolumeKey(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateElasticIPs(ctx, logger, clients.EC2, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateBastion(ctx, logger, clients.EC2, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateInstanceConnect(ctx, logger, clients.EC2, cfg, state); err != nil {
+		return err
+	}
+
+	if err := UpdateLifecycleEvents(ctx, logger, clients, cfg, state); err != nil {
+		return err
+	}
+
+	// An existing launch template is managed elsewhere, only the version the
+	// groups use follows the config.
+	var version string
+	if existing := cfg.LaunchTemplate.Existing; existing != nil {
+		version = existing.LaunchTemplateVersion()
+	} else {
+		latestVersion, err := UpdateLaunchTemplate(ctx, logger, clients.EC2, cfg.LaunchTemplate, state)
+		if err != nil {
+			return err
+		}
+		version = LaunchTemplateVersionFor(cfg.LaunchTemplate, latestVersion)
+	}
+	if version != state.CurrentLaunchTemplateVersion() {
+		if err := SetAutoscalingGroupLaunchTemplateVersion(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, state.LaunchTemplateID, version, cfg.AutoScaling.InstanceRequirements); err != nil {
+			return err
+		}
+		state.LaunchTemplateVersion = version
+		if err := state.Save(); err != nil {
+			return err
+		}
+	} else if err := UpdateInstanceRequirements(ctx, logger, clients.AutoScaling, cfg.AutoScaling.InstanceRequirements, state.AutoScalingGroupName, state.LaunchTemplateID, version); err != nil {
+		return err
+	}
+
+	if err := UpdateTargetGroup(ctx, logger, clients.ELB, cfg.TargetGroup, state.TargetGroupARN); err != nil {
+		return err
+	}
+	if err := UpdateTargetGroupAttributes(ctx, logger, clients.ELB, cfg.TargetGroup, state.TargetGroupARN); err != nil {
+		return err
+	}
+
+	if err := ApplyCustomSteps(ctx, logger, clients, cfg, state, PlainProgress{}); err != nil {
+		return err
+	}
+
+	logger.Println("Stack is up to date")
+	return nil
+}
+
+func UpdateAutoscalingGroup(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, asgConfig AutoScalingConfig, autoscalingGroupName string) error {
+	output, err := autoscalingClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoscalingGroupName},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return fmt.Errorf("autoscaling group %s not found", autoscalingGroupName)
+	}
+
+	group := output.AutoScalingGroups[0]
+	if aws.Int32Value(group.MinSize) != asgConfig.MinSize || aws.Int32Value(group.MaxSize) != asgConfig.MaxSize {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			MinSize:              aws.Int32(asgConfig.MinSize),
+			MaxSize:              aws.Int32(asgConfig.MaxSize),
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group: %w", err)
+		}
+		logger.Printf("Autoscaling group %s resized from min %d/max %d to min %d/max %d",
+			autoscalingGroupName, aws.Int32Value(group.MinSize), aws.Int32Value(group.MaxSize), asgConfig.MinSize, asgConfig.MaxSize)
+	}
+
+	if aws.StringValue(group.HealthCheckType) != asgConfig.HealthCheckType ||
+		aws.Int32Value(group.HealthCheckGracePeriod) != asgConfig.HealthCheckGracePeriod ||
+		aws.Int32Value(group.DefaultCooldown) != asgConfig.DefaultCooldown {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName:   aws.String(autoscalingGroupName),
+			HealthCheckType:        aws.String(asgConfig.HealthCheckType),
+			HealthCheckGracePeriod: aws.Int32(asgConfig.HealthCheckGracePeriod),
+			DefaultCooldown:        aws.Int32(asgConfig.DefaultCooldown),
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group health check: %w", err)
+		}
+		logger.Printf("Autoscaling group %s health check set to %s with a %ds grace period, cooldown %ds",
+			autoscalingGroupName, asgConfig.HealthCheckType, asgConfig.HealthCheckGracePeriod, asgConfig.DefaultCooldown)
+	}
+
+	if !slices.Equal(group.TerminationPolicies, asgConfig.TerminationPolicies) {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			TerminationPolicies:  asgConfig.TerminationPolicies,
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group termination policies: %w", err)
+		}
+		logger.Printf("Autoscaling group %s termination policies set to %s", autoscalingGroupName, strings.Join(asgConfig.TerminationPolicies, ", "))
+	}
+
+	if aws.Int32Value(group.MaxInstanceLifetime) != asgConfig.MaxInstanceLifetime() {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			MaxInstanceLifetime:  aws.Int32(asgConfig.MaxInstanceLifetime()),
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group max instance lifetime: %w", err)
+		}
+		logger.Printf("Autoscaling group %s max instance lifetime set to %d days", autoscalingGroupName, asgConfig.MaxInstanceLifetimeDays)
+	}
+
+	if aws.BoolValue(group.NewInstancesProtectedFromScaleIn) != asgConfig.NewInstancesProtectedFromScaleIn {
+		if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, &autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName:             aws.String(autoscalingGroupName),
+			NewInstancesProtectedFromScaleIn: aws.Bool(asgConfig.NewInstancesProtectedFromScaleIn),
+		}); err != nil {
+			return fmt.Errorf("error updating autoscaling group scale-in protection: %w", err)
+		}
+		logger.Printf("Autoscaling group %s scale-in protection of new instances set to %t", autoscalingGroupName, asgConfig.NewInstancesProtectedFromScaleIn)
+	}
+
+	return updateGroupMetrics(ctx, logger, autoscalingClient, autoscalingGroupName, group.EnabledMetrics, asgConfig.GroupMetrics)
+}
+
+// updateGroupMetrics enables the configured group metrics that aren't
+// collected yet and disables the ones no longer configured.
+func updateGroupMetrics(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName string, enabled []autoscalingTypes.EnabledMetric, wanted []string) error {
+	enabledSet := make(map[string]bool, len(enabled))
+	for _, metric := range enabled {
+		enabledSet[aws.StringValue(metric.Metric)] = true
+	}
+	wantedSet := make(map[string]bool, len(wanted))
+	var toEnable []string
+	for _, metric := range wanted {
+		wantedSet[metric] = true
+		if !enabledSet[metric] {
+			toEnable = append(toEnable, metric)
+		}
+	}
+	var toDisable []string
+	for metric := range enabledSet {
+		if !wantedSet[metric] {
+			toDisable = append(toDisable, metric)
+		}
+	}
+
+	if len(toEnable) > 0 {
+		if err := EnableGroupMetrics(ctx, logger, autoscalingClient, autoscalingGroupName, toEnable); err != nil {
+			return err
+		}
+	}
+	if len(toDisable) > 0 {
+		sort.Strings(toDisable)
+		if _, err := autoscalingClient.DisableMetricsCollection(ctx, &autoscaling.DisableMetricsCollectionInput{
+			AutoScalingGroupName: aws.String(autoscalingGroupName),
+			Metrics:              toDisable,
+		}); err != nil {
+			return fmt.Errorf("error disabling autoscaling group metrics: %w", err)
+		}
+		logger.Printf("Metrics collection disabled for autoscaling group %s: %s", autoscalingGroupName, strings.Join(toDisable, ", "))
+	}
+
+	return nil
+}
+
+func UpdateScalingPolicy(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, asgConfig AutoScalingConfig, autoscalingGroupName, policyName string) error {
+	output, err := autoscalingClient.DescribePolicies(ctx, &autoscaling.DescribePoliciesInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+		PolicyNames:          []string{policyName},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing autoscaling policy: %w", err)
+	}
+	if len(output.ScalingPolicies) > 0 {
+		trackingConfig := output.ScalingPolicies[0].TargetTrackingConfiguration
+		if trackingConfig != nil && aws.Float64Value(trackingConfig.TargetValue) == asgConfig.TargetValue() &&
+			DescribeTrackedMetric(trackingConfig) == DescribeTrackedMetric(TargetTrackingConfiguration(asgConfig, autoscalingGroupName)) {
+			return nil
+		}
+	}
+
+	if err := PutScalingPolicy(ctx, autoscalingClient, asgConfig, autoscalingGroupName, policyName); err != nil {
+		return err
+	}
+	logger.Printf("Autoscaling policy %s updated to target %s", policyName, asgConfig.ScalingMetric())
+
+	return nil
+}
+
+// UpdateLaunchTemplate creates a new launch template version when the
+// launch template data built from the config differs from the one the last
+// version was created from, and returns the latest version number. Groups
+// tracking $Latest pick the new version up for new instances, running ones
+// keep the version they started with until refreshed.
+func UpdateLaunchTemplate(ctx context.Context, logger *log.Logger, ec2Client *ec2.Client, ltConfig LaunchTemplateConfig, state *State) (int64, error) {
+	data, err := LaunchTemplateData(ltConfig, state.SecurityGroupID, state.VolumeKeyARN)
+	if err != nil {
+		return 0, err
+	}
+	dataHash, err := LaunchTemplateDataHash(data)
+	if err != nil {
+		return 0, err
+	}
+
+	if dataHash == state.LaunchTemplateDataHash {
+		output, err := ec2Client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+			LaunchTemplateIds: []string{state.LaunchTemplateID},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("error describing launch template: %w", err)
+		}
+		if len(output.LaunchTemplates) == 0 {
+			return 0, fmt.Errorf("launch template %s not found", state.LaunchTemplateID)
+		}
+		return aws.Int64Value(output.LaunchTemplates[0].LatestVersionNumber), nil
+	}
+
+	versionOutput, err := ec2Client.CreateLaunchTemplateVersion(ctx, &ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateId:   aws.String(state.LaunchTemplateID),
+		LaunchTemplateData: data,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error creating launch template version: %w", err)
+	}
+	versionNumber := aws.Int64Value(versionOutput.LaunchTemplateVersion.VersionNumber)
+	logger.Printf("Launch template %s version %d created (%s on %s)", state.LaunchTemplateID, versionNumber, ltConfig.InstanceType, ltConfig.AMIID)
+
+	state.LaunchTemplateDataHash = dataHash
+	if err := state.Save(); err != nil {
+		return 0, err
+	}
+
+	return versionNumber, nil
+}
+
+// SetAutoscalingGroupLaunchTemplateVersion points the group at the given
+// launch template version ($Latest or a version number). With instance
+// requirements the version is set in the mixed instances policy instead.
+func SetAutoscalingGroupLaunchTemplateVersion(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, autoscalingGroupName, launchTemplateID, version string, requirements *InstanceRequirementsConfig) error {
+	input := &autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(autoscalingGroupName),
+	}
+	if requirements != nil {
+		input.MixedInstancesPolicy = requirements.MixedInstancesPolicy(launchTemplateID, version)
+	} else {
+		input.LaunchTemplate = &autoscalingTypes.LaunchTemplateSpecification{
+			LaunchTemplateId: aws.String(launchTemplateID),
+			Version:          aws.String(version),
+		}
+	}
+	if _, err := autoscalingClient.UpdateAutoScalingGroup(ctx, input); err != nil {
+		return fmt.Errorf("error updating autoscaling group launch template version: %w", err)
+	}
+	logger.Printf("Autoscaling group %s now uses launch template %s version %s", autoscalingGroupName, launchTemplateID, version)
+
+	return nil
+}
+
+// UpdateInstanceRequirements replaces the instance requirements of the group
+// when they changed, or switches it between a fixed instance type and
+// attribute-based selection.
+func UpdateInstanceRequirements(ctx context.Context, logger *log.Logger, autoscalingClient *autoscaling.Client, requirements *InstanceRequirementsConfig, autoscalingGroupName, launchTemplateID, version string) error {
+	output, err := autoscalingClient.DescribeAutoScalingGroups(ctx, &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{autoscalingGroupName},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing autoscaling group: %w", err)
+	}
+	if len(output.AutoScalingGroups) == 0 {
+		return fmt.Errorf("autoscaling group %s not found", autoscalingGroupName)
+	}
+
+	live := groupInstanceRequirements(output.AutoScalingGroups[0])
+	if requirements == nil && live == nil || requirements != nil && requirements.Matches(live) {
+		return nil
+	}
+
+	if err := SetAutoscalingGroupLaunchTemplateVersion(ctx, logger, autoscalingClient, autoscalingGroupName, launchTemplateID, version, requirements); err != nil {
+		return err
+	}
+	if requirements != nil {
+		logger.Printf("Autoscaling group %s instance requirements set to %s", autoscalingGroupName, requirements)
+	} else {
+		logger.Printf("Autoscaling group %s launches the instance type of the launch template again", autoscalingGroupName)
+	}
+	return nil
+}
+
+func UpdateTargetGroup(ctx context.Context, logger *log.Logger, elbClient *elasticloadbalancingv2.Client, tgConfig TargetGroupConfig, targetGroupARN string) error {
+	output, err := elbClient.DescribeTargetGroups(ctx, &elasticloadbalancingv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []string{targetGroupARN},
+	})
+	if err != nil {
+		return fmt.Errorf("error describing target group: %w", err)
+	}
+	if len(output.TargetGroups) == 0 {
+		return fmt.Errorf("target group %s not found", targetGroupARN)
+	}
+
+	targetGroup := output.TargetGroups[0]
+	healthCheck := tgConfig.HealthCheck
+	if aws.StringValue(targetGroup.HealthCheckPath) == healthCheck.Path &&
+		aws.Int32Value(targetGroup.HealthCheckIntervalSeconds) == healthCheck.IntervalSeconds &&
+		aws.Int32Value(targetGroup.HealthCheckTimeoutSeconds) == healthCheck.TimeoutSeconds &&
+		aws.Int32Value(targetGroup.HealthyThresholdCount) == healthCheck.HealthyThreshold &&
+		aws.Int32Value(targetGroup.UnhealthyThresholdCount) == healthCheck.UnhealthyThreshold &&
+		matcherCodes(targetGroup.Matcher) == healthCheck.Matcher {
+		return nil
+	}
+
+	if _, err := elbClient.ModifyTargetGroup(ctx, &elasticloadbalancingv2.ModifyTargetGroupInput{
+		TargetGroupArn:             aws.String(targetGroupARN),
+		HealthCheckPath:            aws.String(healthCheck.Path),
+		HealthCheckIntervalSeconds: aws.Int32(healthCheck.IntervalSeconds),
+		HealthCheckTimeoutSeconds:  aws.Int32(healthCheck.TimeoutSeconds),
+		HealthyThresholdCount:      aws.Int32(healthCheck.HealthyThreshold),
+		UnhealthyThresholdCount:    aws.Int32(healthCheck.UnhealthyThreshold),
+		Matcher:                    tgConfig.HealthCheckMatcher(),
+	}); err != nil {
+		return fmt.Errorf("error modifying target group: %w", err)
+	}
+	logger.Printf("Health check settings updated for target group %s", targetGroupARN)
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	UserDataPartCloudConfig = "cloud-config"
+	UserDataPartShellScript = "shellscript"
+	UserDataPartIncludeURL  = "include-url"
+	UserDataPartBoothook    = "boothook"
+	// MultipartUserDataFile is the name of the assembled multipart user data
+	// in the Terraform export.
+	MultipartUserDataFile = "user_data.mime"
+)
+
+// UserDataPartTypes are the part types cloud-init handles, in the order
+// their first line is checked when a part doesn't set its type.
+var UserDataPartTypes = []string{UserDataPartCloudConfig, UserDataPartShellScript, UserDataPartIncludeURL, UserDataPartBoothook}
+
+// userDataPartFormats maps the part types to their MIME content type and the
+// first line cloud-init recognizes them by.
+var userDataPartFormats = map[string]struct{ contentType, marker string }{
+	UserDataPartCloudConfig: {"text/cloud-config", "#cloud-config"},
+	UserDataPartShellScript: {"text/x-shellscript", "#!"},
+	UserDataPartIncludeURL:  {"text/x-include-url", "#include"},
+	UserDataPartBoothook:    {"text/cloud-boothook", "#cloud-boothook"},
+}
+
+// UserDataPart is one part of multipart user data: a cloud-config document,
+// a shell script, a list of URLs cloud-init includes, or a boothook.
+type UserDataPart struct {
+	// Type is cloud-config, shellscript, include-url or boothook. By default
+	// it is detected from the first line of File.
+	Type string `json:"type"`
+	File string `json:"file"`
+	// URLs make an include-url part without a file.
+	URLs []string `json:"urls"`
+}
+
+// Read returns the type, the file name and the content of the part.
+func (p UserDataPart) Read() (string, string, []byte, error) {
+	if len(p.URLs) > 0 {
+		return UserDataPartIncludeURL, "include-urls.txt", []byte("#include\n" + strings.Join(p.URLs, "\n") + "\n"), nil
+	}
+
+	content, err := os.ReadFile(p.File)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("error reading %s file: %w", p.File, err)
+	}
+	partType := p.Type
+	if partType == "" {
+		partType = detectUserDataPartType(content)
+	}
+	if partType == "" {
+		return "", "", nil, fmt.Errorf("the type of %s can't be detected from its first line, set it to one of %s", p.File, strings.Join(UserDataPartTypes, ", "))
+	}
+	return partType, filepath.Base(p.File), content, nil
+}
+
+func detectUserDataPartType(content []byte) string {
+	for _, partType := range UserDataPartTypes {
+		if bytes.HasPrefix(content, []byte(userDataPartFormats[partType].marker)) {
+			return partType
+		}
+	}
+	return ""
+}
+
+type mimePart struct {
+	partType string
+	filename string
+	content  []byte
+}
+
+// MultipartUserData assembles the user data parts into a multipart MIME
+// document for cloud-init. Joining the ECS cluster, mounting the data
+// volumes and starting the CloudWatch agent come first and the app last,
+// and every shell script reads the secrets, as each part runs in its own
+// process.
+func MultipartUserData(ltConfig LaunchTemplateConfig) ([]byte, error) {
+	var parts []mimePart
+	if ltConfig.ECSCluster != "" {
+		parts = append(parts, mimePart{UserDataPartShellScript, "ecs-cluster.sh", ECSUserData(ltConfig.ECSCluster, nil)})
+	}
+	if mountsDataVolumes(ltConfig.DataVolumes) {
+		parts = append(parts, mimePart{UserDataPartShellScript, "data-volumes.sh", DataVolumesUserData(ltConfig.DataVolumes, nil)})
+	}
+	if len(ltConfig.LogGroups) > 0 {
+		parts = append(parts, mimePart{UserDataPartShellScript, "cloudwatch-agent.sh", LogsUserData(ltConfig.LogGroups, nil)})
+	}
+	withSecrets := func(partType string, content []byte) []byte {
+		if partType == UserDataPartShellScript && len(ltConfig.Secrets) > 0 {
+			return SecretsUserData(ltConfig.Secrets, content)
+		}
+		return content
+	}
+	for _, part := range ltConfig.UserDataParts {
+		partType, filename, content, err := part.Read()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, mimePart{partType, filename, withSecrets(partType, content)})
+	}
+	if ltConfig.App != nil {
+		parts = append(parts, mimePart{UserDataPartShellScript, "app.sh", withSecrets(UserDataPartShellScript, ltConfig.App.UserData())})
+	}
+	return assembleMultipart(parts)
+}
+
+// assembleMultipart writes the MIME document. The boundary is derived from
+// the content of the parts, so the same parts always give the same user data
+// and don't create a new launch template version.
+func assembleMultipart(parts []mimePart) ([]byte, error) {
+	hash := sha256.New()
+	for _, part := range parts {
+		hash.Write(part.content)
+	}
+	boundary := fmt.Sprintf("==%x==", hash.Sum(nil)[:12])
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	if err := writer.SetBoundary(boundary); err != nil {
+		return nil, fmt.Errorf("error assembling user data: %w", err)
+	}
+	for _, part := range parts {
+		charset, encoding := "us-ascii", "7bit"
+		if !isASCII(part.content) {
+			charset, encoding = "utf-8", "8bit"
+		}
+		header := textproto.MIMEHeader{
+			"Content-Type":              {fmt.Sprintf("%s; charset=%q", userDataPartFormats[part.partType].contentType, charset)},
+			"MIME-Version":              {"1.0"},
+			"Content-Transfer-Encoding": {encoding},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", part.filename)},
+		}
+		partWriter, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("error assembling user data: %w", err)
+		}
+		partWriter.Write(part.content)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error assembling user data: %w", err)
+	}
+
+	var document bytes.Buffer
+	fmt.Fprintf(&document, "Content-Type: multipart/mixed; boundary=%q\r\nMIME-Version: 1.0\r\n\r\n", boundary)
+	document.Write(body.Bytes())
+	return document.Bytes(), nil
+}
+
+func isASCII(content []byte) bool {
+	for _, b := range content {
+		if b > 127 {
+			return false
+		}
+	}
+	return true
+}
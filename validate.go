@@ -0,0 +1,1339 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxUserDataSize is the EC2 limit on user data before base64 encoding.
+	MaxUserDataSize = 16 * 1024
+	// MaxELBNameLength applies to both target group and load balancer names.
+	MaxELBNameLength = 32
+)
+
+var (
+	availabilityZonePattern = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-\d[a-z]$`)
+	elbNamePattern          = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?$`)
+	endpointServicePattern  = regexp.MustCompile(`^[a-z0-9]+([.-][a-z0-9]+)*$`)
+	kmsKeyARNPattern        = regexp.MustCompile(`^arn:aws[a-z-]*:kms:([a-z0-9-]+):\d{12}:key/(mrk-)?[0-9a-f-]+$`)
+	kmsAliasPattern         = regexp.MustCompile(`^alias/[a-zA-Z0-9/_-]+$`)
+	terminationPolicies     = []string{"Default", "AllocationStrategy", "OldestLaunchTemplate", "OldestLaunchConfiguration", "ClosestToNextInstanceHour", "NewestInstance", "OldestInstance"}
+)
+
+// ValidateConfig checks cfg without calling AWS and returns every problem it
+// finds, so they can all be fixed in one go.
+func ValidateConfig(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// The CIDR blocks of an existing VPC are only known once it is looked
+	// up, so are its free ranges.
+	var (
+		vpcPrefix   netip.Prefix
+		vpcPrefixes []netip.Prefix
+		err         error
+	)
+	if cfg.VPC.Existing() {
+		problems = append(problems, validateExistingVPC(cfg.VPC)...)
+	} else {
+		vpcPrefix, err = netip.ParsePrefix(cfg.VPC.CIDRBlock)
+		if err != nil {
+			report("vpc.cidrBlock %q is not a valid CIDR block", cfg.VPC.CIDRBlock)
+		}
+		if vpcPrefix.IsValid() {
+			vpcPrefixes = append(vpcPrefixes, vpcPrefix)
+		}
+		for i, cidrBlock := range cfg.VPC.SecondaryCIDRBlocks {
+			prefix, err := netip.ParsePrefix(cidrBlock)
+			if err != nil || !prefix.Addr().Is4() {
+				report("vpc.secondaryCidrBlocks[%d] %q is not a valid IPv4 CIDR block", i, cidrBlock)
+				continue
+			}
+			if prefix.Bits() < 16 || prefix.Bits() > 28 {
+				report("vpc.secondaryCidrBlocks[%d] %s must be between /16 and /28", i, prefix)
+			}
+			for _, other := range vpcPrefixes {
+				if other.Overlaps(prefix) {
+					report("vpc.secondaryCidrBlocks[%d] %s overlaps the VPC CIDR block %s", i, prefix, other)
+				}
+			}
+			vpcPrefixes = append(vpcPrefixes, prefix)
+		}
+	}
+
+	if cfg.VPC.SubnetIDs != nil {
+		problems = append(problems, validateSubnetIDs(cfg.VPC)...)
+	} else if len(cfg.VPC.Subnets) == 0 {
+		if cfg.VPC.PublicSubnets < 2 {
+			report("vpc.publicSubnets must be at least 2, the load balancer needs two availability zones")
+		}
+		if cfg.VPC.PrivateSubnets < 0 {
+			report("vpc.privateSubnets %d must not be negative", cfg.VPC.PrivateSubnets)
+		}
+		if vpcPrefix.IsValid() {
+			if _, err := SubnetLayout(cfg.VPC, nil); err != nil {
+				report("%v", err)
+			}
+		}
+	} else {
+		problems = append(problems, validateSubnets(cfg, vpcPrefixes)...)
+	}
+
+	for i, port := range cfg.SecurityGroup.IngressPorts {
+		if port < 1 || port > 65535 {
+			report("securityGroup.ingressPorts[%d] %d is not a valid port", i, port)
+		}
+	}
+	if nacl := cfg.VPC.NetworkACL; nacl.Enabled {
+		if !slices.Contains([]string{NetworkACLSubnetsAll, NetworkACLSubnetsPublic, NetworkACLSubnetsPrivate}, nacl.Subnets) {
+			report("vpc.networkAcl.subnets %q must be all, public or private", nacl.Subnets)
+		}
+		if len(nacl.Inbound) == 0 || len(nacl.Outbound) == 0 {
+			report("vpc.networkAcl needs inbound and outbound entries, a network ACL without them denies all traffic")
+		}
+		problems = append(problems, validateNetworkACLEntries("vpc.networkAcl.inbound", nacl.Inbound)...)
+		problems = append(problems, validateNetworkACLEntries("vpc.networkAcl.outbound", nacl.Outbound)...)
+	}
+	for i, service := range cfg.VPC.Endpoints {
+		if !endpointServicePattern.MatchString(service) {
+			report("vpc.endpoints[%d] %q is not an AWS service name such as s3 or ssm", i, service)
+		}
+		if slices.Index(cfg.VPC.Endpoints, service) != i {
+			report("vpc.endpoints[%d] %q is listed twice", i, service)
+		}
+	}
+	if flowLogs := cfg.VPC.FlowLogs; flowLogs.Enabled {
+		switch flowLogs.Destination {
+		case FlowLogsDestinationCloudWatchLogs:
+			if !slices.Contains(FlowLogsRetentionDays, flowLogs.RetentionDays) {
+				report("vpc.flowLogs.retentionDays %d is not a retention period CloudWatch Logs supports", flowLogs.RetentionDays)
+			}
+		case FlowLogsDestinationS3:
+			if !strings.HasPrefix(flowLogs.BucketARN, "arn:aws:s3:::") {
+				report("vpc.flowLogs.bucketArn %q must be an S3 bucket ARN", flowLogs.BucketARN)
+			}
+		default:
+			report("vpc.flowLogs.destination %q must be cloud-watch-logs or s3", flowLogs.Destination)
+		}
+		if !slices.Contains(FlowLogsTrafficTypes, flowLogs.TrafficType) {
+			report("vpc.flowLogs.trafficType %q must be one of %s", flowLogs.TrafficType, strings.Join(FlowLogsTrafficTypes, ", "))
+		}
+	}
+
+	for i, rule := range cfg.SecurityGroup.Ingress {
+		problems = append(problems, validateRule(fmt.Sprintf("securityGroup.ingress[%d]", i), rule)...)
+	}
+	for i, rule := range cfg.SecurityGroup.Egress {
+		problems = append(problems, validateRule(fmt.Sprintf("securityGroup.egress[%d]", i), rule)...)
+	}
+	if existing := cfg.SecurityGroup.Existing; existing != nil {
+		if (existing.ID == "") == (existing.Name == "") {
+			report("securityGroup.existing needs either an id or a name")
+		}
+		if existing.ID != "" && !strings.HasPrefix(existing.ID, "sg-") {
+			report("securityGroup.existing.id %q is not a security group ID", existing.ID)
+		}
+	}
+	if cfg.TargetGroup.Port < 1 || cfg.TargetGroup.Port > 65535 {
+		report("targetGroup.port %d is not a valid port", cfg.TargetGroup.Port)
+	}
+	problems = append(problems, validateListener("listener", cfg.Listener)...)
+	if cfg.Listener.Redirect != nil {
+		report("listener.redirect is only supported on additionalListeners")
+	}
+	ports := map[int32]bool{cfg.Listener.Port: true}
+	for i, listener := range cfg.AdditionalListeners {
+		path := fmt.Sprintf("additionalListeners[%d]", i)
+		problems = append(problems, validateListener(path, listener)...)
+		if ports[listener.Port] {
+			report("%s.port %d is already used by another listener", path, listener.Port)
+		}
+		ports[listener.Port] = true
+		if listener.MutualTLS != nil || listener.Authentication != nil {
+			report("%s supports no mutualTls or authentication, they are only available on listener", path)
+		}
+		if redirect := listener.Redirect; redirect != nil {
+			if redirect.Protocol != ListenerProtocolHTTP && redirect.Protocol != ListenerProtocolHTTPS {
+				report("%s.redirect.protocol %q must be %s or %s", path, redirect.Protocol, ListenerProtocolHTTP, ListenerProtocolHTTPS)
+			}
+			if redirect.Port < 1 || redirect.Port > 65535 {
+				report("%s.redirect.port %d is not a valid port", path, redirect.Port)
+			}
+			if !slices.Contains(RedirectStatusCodes, redirect.StatusCode) {
+				report("%s.redirect.statusCode %d must be 301 or 302", path, redirect.StatusCode)
+			}
+		} else if cfg.Listener.MutualTLS != nil || cfg.Listener.Authentication != nil {
+			report("%s must redirect, forwarding would bypass the mutualTls or authentication of listener", path)
+		}
+	}
+
+	maintenance := cfg.Listener.Maintenance
+	if maintenance.StatusCode < 200 || maintenance.StatusCode > 599 {
+		report("listener.maintenance.statusCode %d must be between 200 and 599", maintenance.StatusCode)
+	}
+	if !slices.Contains(FixedResponseContentTypes, maintenance.ContentType) {
+		report("listener.maintenance.contentType %q must be one of %s", maintenance.ContentType, strings.Join(FixedResponseContentTypes, ", "))
+	}
+	if len(maintenance.Body) > MaxFixedResponseBodyLength {
+		report("listener.maintenance.body is %d characters long, at most %d are allowed", len(maintenance.Body), MaxFixedResponseBodyLength)
+	}
+
+	switch cfg.TargetGroup.TargetType {
+	case TargetTypeInstance:
+		if len(cfg.TargetGroup.IPTargets) > 0 {
+			report("targetGroup.ipTargets needs targetGroup.targetType %s", TargetTypeIP)
+		}
+	case TargetTypeIP:
+		if cfg.Canary != nil {
+			report("canary needs targetGroup.targetType %s, autoscaling groups can't register with ip target groups", TargetTypeInstance)
+		}
+	default:
+		report("targetGroup.targetType %q must be %s or %s", cfg.TargetGroup.TargetType, TargetTypeInstance, TargetTypeIP)
+	}
+	if !slices.Contains(ProtocolVersions, cfg.TargetGroup.ProtocolVersion) {
+		report("targetGroup.protocolVersion %q must be one of %s", cfg.TargetGroup.ProtocolVersion, strings.Join(ProtocolVersions, ", "))
+	} else if cfg.TargetGroup.ProtocolVersion != ProtocolVersionHTTP1 && cfg.Listener.Protocol() != ListenerProtocolHTTPS {
+		report("targetGroup.protocolVersion %s needs an HTTPS listener, set listener.certificateArn", cfg.TargetGroup.ProtocolVersion)
+	}
+	if cfg.TargetGroup.UsesGRPC() && !validGRPCMatcher(cfg.TargetGroup.HealthCheck.Matcher) {
+		report("targetGroup.healthCheck.matcher %q must list gRPC codes between 0 and %d, like 12 or 0-99", cfg.TargetGroup.HealthCheck.Matcher, MaxGRPCCode)
+	}
+	if slowStart := cfg.TargetGroup.SlowStartSeconds; slowStart != 0 && (slowStart < MinSlowStartSeconds || slowStart > MaxSlowStartSeconds) {
+		report("targetGroup.slowStartSeconds %d must be 0 or between %d and %d", slowStart, MinSlowStartSeconds, MaxSlowStartSeconds)
+	}
+	if delay := cfg.TargetGroup.DeregistrationDelaySeconds; delay < 0 || delay > MaxDeregistrationDelaySeconds {
+		report("targetGroup.deregistrationDelaySeconds %d must be between 0 and %d", delay, MaxDeregistrationDelaySeconds)
+	}
+	for i, target := range cfg.TargetGroup.IPTargets {
+		if _, err := netip.ParseAddr(target.IP); err != nil {
+			report("targetGroup.ipTargets[%d].ip %q is not an IP address", i, target.IP)
+		}
+		if target.Port < 0 || target.Port > 65535 {
+			report("targetGroup.ipTargets[%d].port %d must be between 1 and 65535", i, target.Port)
+		}
+	}
+
+	healthCheck := cfg.TargetGroup.HealthCheck
+	if healthCheck.TimeoutSeconds >= healthCheck.IntervalSeconds {
+		report("targetGroup.healthCheck.timeoutSeconds %d must be lower than intervalSeconds %d", healthCheck.TimeoutSeconds, healthCheck.IntervalSeconds)
+	}
+	if !strings.HasPrefix(healthCheck.Path, "/") {
+		report("targetGroup.healthCheck.path %q must start with /", healthCheck.Path)
+	}
+
+	switch cfg.LoadBalancer.Scheme {
+	case LoadBalancerSchemeInternetFacing:
+	case LoadBalancerSchemeInternal:
+		if len(cfg.VPC.Subnets) == 0 && cfg.VPC.PrivateSubnets < 2 {
+			report("vpc.privateSubnets must be at least 2 for an internal load balancer, it needs two availability zones")
+		}
+	default:
+		report("loadBalancer.scheme %q must be internet-facing or internal", cfg.LoadBalancer.Scheme)
+	}
+
+	if cfg.SmokeTest.Enabled {
+		if !strings.HasPrefix(cfg.SmokeTest.Path, "/") {
+			report("smokeTest.path %q must start with /", cfg.SmokeTest.Path)
+		}
+		if cfg.SmokeTest.ExpectedStatus < 100 || cfg.SmokeTest.ExpectedStatus > 599 {
+			report("smokeTest.expectedStatus %d is not an HTTP status", cfg.SmokeTest.ExpectedStatus)
+		}
+		if cfg.SmokeTest.TimeoutSeconds <= 0 {
+			report("smokeTest.timeoutSeconds %d must be positive", cfg.SmokeTest.TimeoutSeconds)
+		}
+	}
+
+	asg := cfg.AutoScaling
+	if asg.MinSize < 0 {
+		report("autoScaling.minSize %d must not be negative", asg.MinSize)
+	}
+	if asg.MinSize > asg.MaxSize {
+		report("autoScaling.minSize %d is greater than autoScaling.maxSize %d", asg.MinSize, asg.MaxSize)
+	}
+	if asg.DesiredCapacity != nil && (*asg.DesiredCapacity < asg.MinSize || *asg.DesiredCapacity > asg.MaxSize) {
+		report("autoScaling.desiredCapacity %d must be between autoScaling.minSize %d and autoScaling.maxSize %d", *asg.DesiredCapacity, asg.MinSize, asg.MaxSize)
+	}
+	if asg.HealthCheckType != "ELB" && asg.HealthCheckType != "EC2" {
+		report("autoScaling.healthCheckType %q must be ELB or EC2", asg.HealthCheckType)
+	}
+	if asg.HealthCheckGracePeriod < 0 {
+		report("autoScaling.healthCheckGracePeriod %d must not be negative", asg.HealthCheckGracePeriod)
+	}
+	if asg.DefaultCooldown < 0 {
+		report("autoScaling.defaultCooldown %d must not be negative", asg.DefaultCooldown)
+	}
+	if asg.MaxInstanceLifetimeDays != 0 && (asg.MaxInstanceLifetimeDays < 1 || asg.MaxInstanceLifetimeDays > 365) {
+		report("autoScaling.maxInstanceLifetimeDays %d must be 0 or between 1 and 365", asg.MaxInstanceLifetimeDays)
+	}
+	if len(asg.TerminationPolicies) == 0 {
+		report("autoScaling.terminationPolicies must not be empty, use [\"Default\"]")
+	}
+	for _, policy := range asg.TerminationPolicies {
+		if !slices.Contains(terminationPolicies, policy) && !strings.HasPrefix(policy, "arn:") {
+			report("autoScaling.terminationPolicies %q must be one of %s or a Lambda function ARN", policy, strings.Join(terminationPolicies, ", "))
+		}
+	}
+	if asg.CPUTargetValue <= 0 || asg.CPUTargetValue > 100 {
+		report("autoScaling.cpuTargetValue %.1f must be between 0 and 100", asg.CPUTargetValue)
+	}
+	if backlog := asg.SQSBacklog; backlog != nil {
+		if backlog.QueueName == "" {
+			report("autoScaling.sqsBacklog.queueName must be set")
+		}
+		if backlog.TargetBacklogPerInstance <= 0 {
+			report("autoScaling.sqsBacklog.targetBacklogPerInstance %g must be positive", backlog.TargetBacklogPerInstance)
+		}
+		if !slices.Contains(asg.GroupMetrics, "GroupInServiceInstances") {
+			report("autoScaling.groupMetrics must include GroupInServiceInstances to scale on autoScaling.sqsBacklog")
+		}
+	}
+	if custom := asg.CustomMetric; custom != nil {
+		if asg.SQSBacklog != nil {
+			report("autoScaling.customMetric and autoScaling.sqsBacklog can't both be set")
+		}
+		if custom.Namespace == "" || custom.MetricName == "" {
+			report("autoScaling.customMetric needs a namespace and a metricName")
+		}
+		if !slices.Contains(MetricStatistics, custom.Statistic) {
+			report("autoScaling.customMetric.statistic %q must be one of %s", custom.Statistic, strings.Join(MetricStatistics, ", "))
+		}
+		if custom.TargetValue <= 0 {
+			report("autoScaling.customMetric.targetValue %g must be positive", custom.TargetValue)
+		}
+	}
+
+	names := []struct{ path, name string }{
+		{"targetGroup.name", cfg.TargetGroup.Name},
+		{"loadBalancer.name", cfg.LoadBalancer.Name},
+	}
+	if canary := cfg.Canary; canary != nil {
+		names = append(names, struct{ path, name string }{"canary.targetGroupName", canary.TargetGroupName})
+		if canary.Size < 1 {
+			report("canary.size %d must be at least 1", canary.Size)
+		}
+		if canary.TargetGroupName == cfg.TargetGroup.Name {
+			report("canary.targetGroupName %q must differ from targetGroup.name", canary.TargetGroupName)
+		}
+		if canary.AutoScalingGroupName == cfg.AutoScaling.Name {
+			report("canary.autoScalingGroupName %q must differ from autoScaling.name", canary.AutoScalingGroupName)
+		}
+	}
+	if ecsConfig := cfg.ECS; ecsConfig != nil {
+		if cfg.Canary != nil {
+			report("canary can't be combined with ecs, deploy canaries with the ECS service instead")
+		}
+		if cfg.AutoScaling.SQSBacklog != nil || cfg.AutoScaling.CustomMetric != nil {
+			report("autoScaling.sqsBacklog and autoScaling.customMetric can't be combined with ecs, the capacity provider scales the group")
+		}
+		if ecsConfig.TargetCapacity < 1 || ecsConfig.TargetCapacity > 100 {
+			report("ecs.targetCapacity %d must be between 1 and 100", ecsConfig.TargetCapacity)
+		}
+		if ecsConfig.ManagedTerminationProtection && !cfg.AutoScaling.NewInstancesProtectedFromScaleIn {
+			report("ecs.managedTerminationProtection needs autoScaling.newInstancesProtectedFromScaleIn")
+		}
+	}
+	if codeDeploy := cfg.CodeDeploy; codeDeploy != nil {
+		if !cfg.AttachesTargetGroup() {
+			report("codeDeploy needs the instances in the target group, it can't be combined with ecs or targetGroup.targetType %s", TargetTypeIP)
+		}
+		if !slices.Contains(DeploymentTypes, codeDeploy.DeploymentType) {
+			report("codeDeploy.deploymentType %q must be one of %s", codeDeploy.DeploymentType, strings.Join(DeploymentTypes, ", "))
+		}
+		if codeDeploy.TerminationWaitMinutes < 0 || codeDeploy.TerminationWaitMinutes > MaxTerminationWaitMinutes {
+			report("codeDeploy.terminationWaitMinutes %d must be between 0 and %d", codeDeploy.TerminationWaitMinutes, MaxTerminationWaitMinutes)
+		}
+	}
+	priorities := map[int32]bool{}
+	for i, lambdaTarget := range cfg.LambdaTargets {
+		names = append(names, struct{ path, name string }{fmt.Sprintf("lambdaTargets[%d].name", i), lambdaTarget.Name})
+		if !strings.HasPrefix(lambdaTarget.FunctionARN, "arn:") || !strings.Contains(lambdaTarget.FunctionARN, ":function:") {
+			report("lambdaTargets[%d].functionArn %q is not a Lambda function ARN", i, lambdaTarget.FunctionARN)
+		}
+		if len(lambdaTarget.PathPatterns) == 0 || len(lambdaTarget.PathPatterns) > MaxRuleConditionValues {
+			report("lambdaTargets[%d].pathPatterns needs 1-%d patterns", i, MaxRuleConditionValues)
+		}
+		if lambdaTarget.Priority < 1 || lambdaTarget.Priority > MaxRulePriority {
+			report("lambdaTargets[%d].priority %d must be between 1 and %d", i, lambdaTarget.Priority, MaxRulePriority)
+		}
+		if priorities[lambdaTarget.Priority] {
+			report("lambdaTargets[%d].priority %d is used by another rule", i, lambdaTarget.Priority)
+		}
+		priorities[lambdaTarget.Priority] = true
+	}
+	for i, hostRule := range cfg.HostRules {
+		if len(hostRule.HostHeaders) == 0 {
+			report("hostRules[%d].hostHeaders needs at least one host name", i)
+		}
+		if n := len(hostRule.HostHeaders) + len(hostRule.PathPatterns); n > MaxRuleConditionValues {
+			report("hostRules[%d] has %d hostHeaders and pathPatterns, at most %d are allowed", i, n, MaxRuleConditionValues)
+		}
+		if !strings.HasPrefix(hostRule.TargetGroupARN, "arn:") || !strings.Contains(hostRule.TargetGroupARN, ":targetgroup/") {
+			report("hostRules[%d].targetGroupArn %q is not a target group ARN", i, hostRule.TargetGroupARN)
+		}
+		if hostRule.Priority < 1 || hostRule.Priority > MaxRulePriority {
+			report("hostRules[%d].priority %d must be between 1 and %d", i, hostRule.Priority, MaxRulePriority)
+		}
+		if priorities[hostRule.Priority] {
+			report("hostRules[%d].priority %d is used by another rule", i, hostRule.Priority)
+		}
+		priorities[hostRule.Priority] = true
+	}
+	for _, field := range names {
+		if len(field.name) > MaxELBNameLength || !elbNamePattern.MatchString(field.name) {
+			report("%s %q must be 1-%d letters, digits or hyphens, not starting or ending with a hyphen", field.path, field.name, MaxELBNameLength)
+		}
+	}
+
+	if _, err := OrderedSteps(); err != nil {
+		report("%v", err)
+	}
+	for i, hook := range cfg.Hooks {
+		if !slices.Contains(StepNames(), hook.Step) {
+			report("hooks[%d].step %q is not a step, use one of: %s", i, hook.Step, strings.Join(StepNames(), ", "))
+		}
+		if hook.When != HookBefore && hook.When != HookAfter {
+			report("hooks[%d].when %q must be %s or %s", i, hook.When, HookBefore, HookAfter)
+		}
+		if hook.Command == "" && hook.URL == "" {
+			report("hooks[%d] needs a command or a url", i)
+		}
+	}
+	for i, notification := range cfg.Notifications {
+		if notification.Type != NotifierSlack && notification.Type != NotifierWebhook {
+			report("notifications[%d].type %q must be %s or %s", i, notification.Type, NotifierSlack, NotifierWebhook)
+		}
+		if (notification.URL == "") == (notification.URLFromEnv == "") {
+			report("notifications[%d] needs either a url or a urlFromEnv", i)
+		}
+		if notification.URL != "" && !strings.HasPrefix(notification.URL, "https://") && !strings.HasPrefix(notification.URL, "http://") {
+			report("notifications[%d].url %q must be an http or https URL", i, notification.URL)
+		}
+	}
+
+	problems = append(problems, validateTenancy(cfg)...)
+	problems = append(problems, validateCPUCredits(cfg)...)
+	problems = append(problems, validateHibernation(cfg)...)
+	problems = append(problems, validateDataVolumes(cfg)...)
+	problems = append(problems, validateSecrets(cfg)...)
+	problems = append(problems, validateElasticIPs(cfg)...)
+	problems = append(problems, validateRetries(cfg.Retries)...)
+	if requirements := cfg.AutoScaling.InstanceRequirements; requirements != nil {
+		problems = append(problems, validateInstanceRequirements(cfg, *requirements)...)
+	}
+	if placementGroup := cfg.PlacementGroup; placementGroup != nil {
+		problems = append(problems, validatePlacementGroup(cfg, *placementGroup)...)
+	}
+	if encryption := cfg.VolumeEncryption; encryption != nil {
+		problems = append(problems, validateVolumeEncryption(cfg, *encryption)...)
+	}
+	if windows := cfg.Windows; windows != nil {
+		problems = append(problems, validateWindows(cfg, *windows)...)
+	}
+	if bastion := cfg.Bastion; bastion != nil {
+		problems = append(problems, validateBastion(cfg, *bastion)...)
+	}
+	if cfg.InstanceConnectEndpoint != nil && cfg.Windows != nil {
+		problems = append(problems, "instanceConnectEndpoint can't be combined with windows, whose instances are reached over remote desktop")
+	}
+	if cfg.SessionManagerOnly {
+		problems = append(problems, validateSessionManagerOnly(cfg)...)
+	}
+	if logs := cfg.Logs; logs != nil {
+		problems = append(problems, validateLogs(cfg, *logs)...)
+	}
+	if events := cfg.LifecycleEvents; events != nil {
+		problems = append(problems, validateLifecycleEvents(*events)...)
+	}
+	if cfg.LaunchTemplate.AMIID == "" {
+		parameter := cfg.LaunchTemplate.AMIParameterPath()
+		instanceArchitecture := InstanceArchitecture(cfg.LaunchTemplate.InstanceType)
+		if architecture := amiParameterArchitecture(parameter); architecture != "" && architecture != instanceArchitecture {
+			report("launchTemplate.amiParameter %s holds %s AMIs, but instanceType %s is %s; use {arch} in the parameter to follow the instance type", parameter, architecture, cfg.LaunchTemplate.InstanceType, instanceArchitecture)
+		}
+	}
+
+	// The user data of an existing launch template is part of it.
+	if existing := cfg.LaunchTemplate.Existing; existing != nil {
+		return append(problems, validateExistingLaunchTemplate(cfg, *existing)...)
+	}
+	if len(cfg.LaunchTemplate.UserDataParts) > 0 {
+		problems = append(problems, validateUserDataParts(cfg.LaunchTemplate)...)
+	}
+	if app := cfg.App; app != nil {
+		problems = append(problems, validateApp(cfg, *app)...)
+	} else if len(cfg.LaunchTemplate.UserDataParts) == 0 {
+		info, err := os.Stat(cfg.LaunchTemplate.UserDataFile)
+		switch {
+		case err != nil:
+			report("launchTemplate.userDataFile %s cannot be read: %v", cfg.LaunchTemplate.UserDataFile, err)
+		case info.Size() > MaxUserDataSize:
+			report("launchTemplate.userDataFile %s is %d bytes, user data is limited to %d bytes", cfg.LaunchTemplate.UserDataFile, info.Size(), MaxUserDataSize)
+		}
+	}
+
+	return problems
+}
+
+// validateExistingLaunchTemplate checks launchTemplate.existing and that no
+// settings that only apply to a created launch template are set.
+func validateExistingLaunchTemplate(cfg *Config, existing ExistingLaunchTemplateConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if (existing.ID == "") == (existing.Name == "") {
+		report("launchTemplate.existing needs either an id or a name")
+	}
+	if existing.ID != "" && !strings.HasPrefix(existing.ID, "lt-") {
+		report("launchTemplate.existing.id %q is not a launch template ID", existing.ID)
+	}
+	if version := existing.Version; version != "" && version != AWSLaunchTemplateVersion && version != AWSDefaultLaunchTemplateVersion {
+		if number, err := strconv.ParseInt(version, 10, 64); err != nil || number < 1 {
+			report("launchTemplate.existing.version %q must be a version number, %s or %s", version, AWSLaunchTemplateVersion, AWSDefaultLaunchTemplateVersion)
+		}
+	}
+	if cfg.App != nil {
+		report("app can't be used with launchTemplate.existing, the user data is part of the existing launch template")
+	}
+	if len(cfg.LaunchTemplate.UserDataParts) > 0 {
+		report("launchTemplate.userDataParts can't be used with launchTemplate.existing, the user data is part of the existing launch template")
+	}
+	if cfg.LaunchTemplate.PinVersion {
+		report("launchTemplate.pinVersion doesn't apply to launchTemplate.existing, set launchTemplate.existing.version instead")
+	}
+
+	// The rest would be written into a created launch template only, and the
+	// placement group and the volume key would be created for nothing.
+	ltConfig := cfg.LaunchTemplate
+	settings := []struct {
+		name string
+		set  bool
+	}{
+		{"windows", cfg.Windows != nil},
+		{"placementGroup", cfg.PlacementGroup != nil},
+		{"volumeEncryption", cfg.VolumeEncryption != nil},
+		{"launchTemplate.amiId", ltConfig.AMIID != ""},
+		{"launchTemplate.userDataFile", ltConfig.UserDataFile != UserDataScript && ltConfig.UserDataFile != WindowsUserDataScript},
+		{"launchTemplate.iamInstanceProfile", ltConfig.IAMInstanceProfile != "" && ltConfig.IAMInstanceProfile != ltConfig.InstanceRoleName},
+		{"launchTemplate.instanceRoleName", ltConfig.InstanceRoleName != "" && ltConfig.InstanceRoleName != cfg.ResourceName(ResourceInstanceRole)},
+		{"launchTemplate.rootVolume", ltConfig.RootVolume != nil},
+		{"launchTemplate.dataVolumes", len(ltConfig.DataVolumes) > 0},
+		{"launchTemplate.detailedMonitoring", ltConfig.DetailedMonitoring},
+		{"launchTemplate.cpuCredits", ltConfig.CPUCredits != ""},
+		{"launchTemplate.tenancy", ltConfig.Tenancy != TenancyDefault},
+		{"launchTemplate.hostResourceGroupArn", ltConfig.HostResourceGroupARN != ""},
+		{"launchTemplate.hibernation", ltConfig.Hibernation},
+	}
+	for _, setting := range settings {
+		if setting.set {
+			report("%s can't be used with launchTemplate.existing, it is a setting of the launch template", setting.name)
+		}
+	}
+	return problems
+}
+
+// validateTenancy checks the tenancy and the host resource group the host
+// tenancy needs.
+func validateTenancy(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	ltConfig := cfg.LaunchTemplate
+	if !slices.Contains(Tenancies, ltConfig.Tenancy) {
+		report("launchTemplate.tenancy %q must be one of %s", ltConfig.Tenancy, strings.Join(Tenancies, ", "))
+	}
+	switch {
+	case ltConfig.Tenancy == TenancyHost && ltConfig.HostResourceGroupARN == "":
+		report("launchTemplate.tenancy host needs launchTemplate.hostResourceGroupArn, autoscaling groups launch onto Dedicated Hosts through a host resource group")
+	case ltConfig.Tenancy != TenancyHost && ltConfig.HostResourceGroupARN != "":
+		report("launchTemplate.hostResourceGroupArn only applies to the host tenancy")
+	case ltConfig.HostResourceGroupARN != "" && !isHostResourceGroupARN(ltConfig.HostResourceGroupARN):
+		report("launchTemplate.hostResourceGroupArn %q is not the ARN of a host resource group", ltConfig.HostResourceGroupARN)
+	}
+	if ltConfig.Tenancy == TenancyHost && cfg.PlacementGroup != nil {
+		report("placementGroup can't be combined with launchTemplate.tenancy host")
+	}
+	return problems
+}
+
+// validateCPUCredits checks the credit option and that it can apply to the
+// instance types of the group.
+func validateCPUCredits(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	ltConfig := cfg.LaunchTemplate
+	if ltConfig.CPUCredits == "" {
+		return nil
+	}
+	if !slices.Contains(CPUCreditOptions, ltConfig.CPUCredits) {
+		report("launchTemplate.cpuCredits %q must be one of %s", ltConfig.CPUCredits, strings.Join(CPUCreditOptions, ", "))
+	}
+	if requirements := cfg.AutoScaling.InstanceRequirements; requirements != nil {
+		if requirements.BurstablePerformance != "included" && requirements.BurstablePerformance != "required" {
+			report("launchTemplate.cpuCredits only applies to burstable instance types, set autoScaling.instanceRequirements.burstablePerformance to included or required")
+		}
+	} else if !IsBurstableInstanceType(ltConfig.InstanceType) {
+		report("launchTemplate.cpuCredits only applies to burstable instance types, %s is not one", ltConfig.InstanceType)
+	}
+	return problems
+}
+
+// validateHibernation checks that the root volume can hold the RAM of the
+// instances and that their type can hibernate.
+func validateHibernation(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	ltConfig := cfg.LaunchTemplate
+	if !ltConfig.Hibernation {
+		return nil
+	}
+	if rootVolume := ltConfig.RootVolume; rootVolume == nil || !rootVolume.Encrypted {
+		report("launchTemplate.hibernation saves the RAM to the root volume, which must be encrypted, set launchTemplate.rootVolume.encrypted or volumeEncryption")
+	} else if rootVolume.SizeGiB == 0 {
+		report("launchTemplate.hibernation needs launchTemplate.rootVolume.sizeGiB large enough to hold the RAM of the instances")
+	}
+	if cfg.AutoScaling.InstanceRequirements != nil {
+		report("launchTemplate.hibernation can't be combined with autoScaling.instanceRequirements, which may pick instance types that can't hibernate")
+	} else if !SupportsHibernation(ltConfig.InstanceType) {
+		report("launchTemplate.instanceType %s can't hibernate", ltConfig.InstanceType)
+	}
+	return problems
+}
+
+// validateDataVolumes checks the devices, sizes and mount points of the data
+// volumes.
+func validateDataVolumes(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	devices := map[string]bool{}
+	if rootVolume := cfg.LaunchTemplate.RootVolume; rootVolume != nil {
+		devices[rootVolume.Device()] = true
+	}
+	mountPoints := map[string]bool{}
+	for i, volume := range cfg.LaunchTemplate.DataVolumes {
+		if !dataVolumeDevicePattern.MatchString(volume.DeviceName) {
+			report("launchTemplate.dataVolumes[%d].deviceName %q must be /dev/sdf to /dev/sdp or /dev/xvdb to /dev/xvdz", i, volume.DeviceName)
+		} else if devices[volume.DeviceName] {
+			report("launchTemplate.dataVolumes[%d].deviceName %s is used twice", i, volume.DeviceName)
+		}
+		devices[volume.DeviceName] = true
+		if volume.SizeGiB < 1 || volume.SizeGiB > MaxDataVolumeSizeGiB {
+			report("launchTemplate.dataVolumes[%d].sizeGiB %d must be between 1 and %d", i, volume.SizeGiB, MaxDataVolumeSizeGiB)
+		}
+		if volume.VolumeType != "" && !slices.Contains(VolumeTypes, volume.VolumeType) {
+			report("launchTemplate.dataVolumes[%d].volumeType %q must be one of %s", i, volume.VolumeType, strings.Join(VolumeTypes, ", "))
+		}
+		if volume.IOPS > 0 && !slices.Contains([]string{"gp3", "io1", "io2"}, volume.VolumeType) {
+			report("launchTemplate.dataVolumes[%d].iops only applies to gp3, io1 and io2 volumes", i)
+		}
+		if volume.Throughput > 0 && volume.VolumeType != "gp3" {
+			report("launchTemplate.dataVolumes[%d].throughput only applies to gp3 volumes", i)
+		}
+		if !slices.Contains(DataVolumeFileSystems, volume.FileSystem) {
+			report("launchTemplate.dataVolumes[%d].fileSystem %q must be one of %s", i, volume.FileSystem, strings.Join(DataVolumeFileSystems, ", "))
+		}
+		if volume.MountPoint == "" {
+			continue
+		}
+		if !strings.HasPrefix(volume.MountPoint, "/") || volume.MountPoint == "/" || strings.ContainsAny(volume.MountPoint, " \t") {
+			report("launchTemplate.dataVolumes[%d].mountPoint %q must be an absolute directory other than / without spaces", i, volume.MountPoint)
+		} else if mountPoints[volume.MountPoint] {
+			report("launchTemplate.dataVolumes[%d].mountPoint %s is used twice", i, volume.MountPoint)
+		}
+		mountPoints[volume.MountPoint] = true
+		if cfg.Windows != nil {
+			report("launchTemplate.dataVolumes[%d].mountPoint is mounted by a shell script, initialize the disk in the PowerShell user data on Windows", i)
+		}
+	}
+	return problems
+}
+
+// validateApp checks the container the generated user data runs.
+func validateApp(cfg *Config, app AppConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if app.Image == "" {
+		report("app.image is required, e.g. ghcr.io/owner/service:1.2.3")
+	}
+	if cfg.ECS != nil {
+		report("app can't be combined with ecs, run the image as an ECS service instead")
+	}
+	if !slices.Contains(AppRestartPolicies, app.RestartPolicy) {
+		report("app.restartPolicy %q must be one of %s", app.RestartPolicy, strings.Join(AppRestartPolicies, ", "))
+	}
+	for key := range app.Env {
+		if key == "" || strings.ContainsAny(key, "= ") {
+			report("app.env name %q must not be empty or contain = or spaces", key)
+		}
+	}
+	hostPorts := map[int32]bool{}
+	for i, port := range app.Ports {
+		if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+			report("app.ports[%d].containerPort %d is not a valid port", i, port.ContainerPort)
+		}
+		if port.HostPort < 1 || port.HostPort > 65535 {
+			report("app.ports[%d].hostPort %d is not a valid port", i, port.HostPort)
+		}
+		if hostPorts[port.HostPort] {
+			report("app.ports[%d].hostPort %d is published twice", i, port.HostPort)
+		}
+		hostPorts[port.HostPort] = true
+	}
+	if !hostPorts[cfg.TargetGroup.Port] && cfg.AttachesTargetGroup() {
+		report("app.ports doesn't publish targetGroup.port %d, the load balancer can't reach the container", cfg.TargetGroup.Port)
+	}
+	if size := len(app.UserData()); size > MaxUserDataSize {
+		report("app generates %d bytes of user data, user data is limited to %d bytes", size, MaxUserDataSize)
+	}
+
+	return problems
+}
+
+// validateUserDataParts checks the type and source of the user data parts,
+// and the size of the document they are assembled into.
+func validateUserDataParts(ltConfig LaunchTemplateConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for i, part := range ltConfig.UserDataParts {
+		if part.Type != "" && !slices.Contains(UserDataPartTypes, part.Type) {
+			report("launchTemplate.userDataParts[%d].type %q must be one of %s", i, part.Type, strings.Join(UserDataPartTypes, ", "))
+		}
+		switch {
+		case part.File == "" && len(part.URLs) == 0:
+			report("launchTemplate.userDataParts[%d] needs a file or urls", i)
+		case part.File != "" && len(part.URLs) > 0:
+			report("launchTemplate.userDataParts[%d] can't set both file and urls", i)
+		case len(part.URLs) > 0 && part.Type != "" && part.Type != UserDataPartIncludeURL:
+			report("launchTemplate.userDataParts[%d].urls need the %s type", i, UserDataPartIncludeURL)
+		}
+		for _, url := range part.URLs {
+			if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+				report("launchTemplate.userDataParts[%d].urls %q must be an http or https URL", i, url)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+
+	userData, err := MultipartUserData(ltConfig)
+	switch {
+	case err != nil:
+		report("launchTemplate.userDataParts: %v", err)
+	case len(userData) > MaxUserDataSize:
+		report("launchTemplate.userDataParts are assembled into %d bytes of user data, user data is limited to %d bytes", len(userData), MaxUserDataSize)
+	}
+	return problems
+}
+
+// validateInstanceRequirements checks the ranges and that every selected
+// instance type can boot the AMI of launchTemplate.instanceType.
+func validateInstanceRequirements(cfg *Config, requirements InstanceRequirementsConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, field := range []struct {
+		path    string
+		value   RangeConfig
+		minimum int32
+	}{
+		{"autoScaling.instanceRequirements.vcpus", requirements.VCPUs, 1},
+		{"autoScaling.instanceRequirements.memoryMiB", requirements.MemoryMiB, 512},
+	} {
+		if field.value.Min < field.minimum {
+			report("%s.min must be at least %d", field.path, field.minimum)
+		}
+		if field.value.Max != 0 && field.value.Max < field.value.Min {
+			report("%s.max %d must not be below min %d, or 0 for no maximum", field.path, field.value.Max, field.value.Min)
+		}
+	}
+	for _, instanceType := range requirements.ExcludedInstanceTypes {
+		if instanceType == "" || strings.Count(instanceType, "*") > 1 {
+			report("autoScaling.instanceRequirements.excludedInstanceTypes %q must be an instance type or family with at most one * wildcard", instanceType)
+		}
+	}
+	architectures := map[string]bool{}
+	for _, manufacturer := range requirements.CPUManufacturers {
+		if !slices.Contains(CPUManufacturers, manufacturer) {
+			report("autoScaling.instanceRequirements.cpuManufacturers %q must be one of %s", manufacturer, strings.Join(CPUManufacturers, ", "))
+			continue
+		}
+		if manufacturer == CPUManufacturerAWS {
+			architectures[ArchitectureARM] = true
+		} else {
+			architectures[ArchitectureX86] = true
+		}
+	}
+	instanceArchitecture := InstanceArchitecture(cfg.LaunchTemplate.InstanceType)
+	for architecture := range architectures {
+		if architecture != instanceArchitecture {
+			report("autoScaling.instanceRequirements.cpuManufacturers select %s instance types, but the AMI follows instanceType %s, which is %s", architecture, cfg.LaunchTemplate.InstanceType, instanceArchitecture)
+		}
+	}
+	if requirements.BurstablePerformance != "" && !slices.Contains(BurstablePerformanceOptions, requirements.BurstablePerformance) {
+		report("autoScaling.instanceRequirements.burstablePerformance %q must be one of %s", requirements.BurstablePerformance, strings.Join(BurstablePerformanceOptions, ", "))
+	}
+	return problems
+}
+
+// validatePlacementGroup checks the strategy and the limits it puts on the
+// instances.
+func validatePlacementGroup(cfg *Config, placementGroup PlacementGroupConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if len(placementGroup.Name) > 255 {
+		report("placementGroup.name must be at most 255 characters")
+	}
+	switch placementGroup.Strategy {
+	case PlacementStrategyCluster:
+		// Cluster placement groups don't take burstable instances.
+		if IsBurstableInstanceType(cfg.LaunchTemplate.InstanceType) {
+			report("launchTemplate.instanceType %s is burstable, which a cluster placement group can't hold", cfg.LaunchTemplate.InstanceType)
+		}
+		if requirements := cfg.AutoScaling.InstanceRequirements; requirements != nil && requirements.BurstablePerformance != "" && requirements.BurstablePerformance != "excluded" {
+			report("autoScaling.instanceRequirements.burstablePerformance must be excluded with a cluster placement group")
+		}
+	case PlacementStrategySpread:
+		zones := cfg.VPC.PublicSubnets
+		if len(cfg.VPC.Subnets) > 0 {
+			publicZones := map[string]bool{}
+			for _, subnet := range cfg.VPC.Subnets {
+				if !subnet.Private {
+					publicZones[subnet.AvailabilityZone] = true
+				}
+			}
+			zones = len(publicZones)
+		}
+		if limit := int32(zones * MaxSpreadInstancesPerZone); cfg.AutoScaling.MaxSize > limit {
+			report("autoScaling.maxSize %d is more than the %d instances a spread placement group holds in %d availability zones", cfg.AutoScaling.MaxSize, limit, zones)
+		}
+	case PlacementStrategyPartition:
+		if placementGroup.PartitionCount < 1 || placementGroup.PartitionCount > MaxPlacementPartitions {
+			report("placementGroup.partitionCount %d must be between 1 and %d", placementGroup.PartitionCount, MaxPlacementPartitions)
+		}
+	default:
+		report("placementGroup.strategy %q must be one of %s", placementGroup.Strategy, strings.Join(PlacementStrategies, ", "))
+	}
+	if placementGroup.Strategy != PlacementStrategyPartition && placementGroup.PartitionCount != 0 {
+		report("placementGroup.partitionCount only applies to the %s strategy", PlacementStrategyPartition)
+	}
+	return problems
+}
+
+// validateVolumeEncryption checks the referenced key or the alias and
+// deletion window of the created one.
+func validateVolumeEncryption(cfg *Config, encryption VolumeEncryptionConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if encryption.CreatesKey() {
+		if !kmsAliasPattern.MatchString(encryption.Alias) || len(encryption.Alias) > 256 {
+			report("volumeEncryption.alias %q must start with alias/ and hold at most 256 letters, digits, /, _ and -", encryption.Alias)
+		} else if strings.HasPrefix(encryption.Alias, "alias/aws/") {
+			report("volumeEncryption.alias %q is reserved for AWS managed keys", encryption.Alias)
+		}
+		if encryption.DeletionWindowDays < MinKeyDeletionWindowDays || encryption.DeletionWindowDays > MaxKeyDeletionWindowDays {
+			report("volumeEncryption.deletionWindowDays %d must be between %d and %d", encryption.DeletionWindowDays, MinKeyDeletionWindowDays, MaxKeyDeletionWindowDays)
+		}
+		return problems
+	}
+
+	if match := kmsKeyARNPattern.FindStringSubmatch(encryption.KeyARN); match == nil {
+		report("volumeEncryption.keyArn %q must be the ARN of a KMS key, not its ID or alias", encryption.KeyARN)
+	} else if match[1] != cfg.Region {
+		report("volumeEncryption.keyArn is a key in %s, the volumes can only use keys in %s", match[1], cfg.Region)
+	}
+	if encryption.Alias != "" {
+		report("volumeEncryption.alias only applies to a created key, not to keyArn")
+	}
+	if encryption.DeletionWindowDays != 0 {
+		report("volumeEncryption.deletionWindowDays only applies to a created key, not to keyArn")
+	}
+	return problems
+}
+
+// validateBastion checks the network the bastion is reached from and the
+// settings it can't be combined with.
+func validateBastion(cfg *Config, bastion BastionConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if bastion.SSHCIDR == "" {
+		report("bastion.sshCidr is required, use the network you connect from")
+	} else if _, err := netip.ParsePrefix(bastion.SSHCIDR); err != nil {
+		report("bastion.sshCidr %q is not a valid CIDR block", bastion.SSHCIDR)
+	} else if bastion.SSHCIDR == AnyIPv4 {
+		report("bastion.sshCidr must not open SSH to the internet, use the network you connect from")
+	}
+	if cfg.Windows != nil {
+		report("bastion can't be combined with windows, whose instances are reached over remote desktop")
+	}
+	return problems
+}
+
+// validateSessionManagerOnly checks that nothing else opens a way into the
+// instances.
+func validateSessionManagerOnly(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, rule := range cfg.SecurityGroup.IngressRules() {
+		for _, port := range []int32{SSHPort, RDPPort} {
+			if rule.AllowsPort(port) {
+				report("sessionManagerOnly forbids ingress on port %d, but the security group has the rule %s", port, strings.Join(rule.Strings(), ", "))
+			}
+		}
+	}
+	if cfg.Bastion != nil {
+		report("sessionManagerOnly can't be combined with bastion, connect to the instances instead")
+	}
+	if cfg.InstanceConnectEndpoint != nil {
+		report("sessionManagerOnly can't be combined with instanceConnectEndpoint, which needs SSH to the instances")
+	}
+	return problems
+}
+
+// validateWindows checks the Windows Server version and the settings that
+// don't work on Windows.
+func validateWindows(cfg *Config, windows WindowsConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !slices.Contains(WindowsVersions, windows.Version) {
+		report("windows.version %q must be one of %s", windows.Version, strings.Join(WindowsVersions, ", "))
+	}
+	if windows.RDPCIDR != "" {
+		if _, err := netip.ParsePrefix(windows.RDPCIDR); err != nil {
+			report("windows.rdpCidr %q is not a valid CIDR block", windows.RDPCIDR)
+		} else if windows.RDPCIDR == AnyIPv4 {
+			report("windows.rdpCidr must not open remote desktop to the internet, use the network you connect from")
+		}
+	}
+	if InstanceArchitecture(cfg.LaunchTemplate.InstanceType) == ArchitectureARM {
+		report("launchTemplate.instanceType %s is a Graviton type, Windows Server needs x86_64", cfg.LaunchTemplate.InstanceType)
+	}
+	if cfg.ECS != nil {
+		report("windows can't be combined with ecs")
+	}
+	if cfg.App != nil {
+		report("windows can't be combined with app, which generates a Linux script")
+	}
+	if len(cfg.LaunchTemplate.UserDataParts) > 0 {
+		report("windows can't be combined with launchTemplate.userDataParts, which are read by cloud-init")
+	}
+	return problems
+}
+
+// validateElasticIPs checks the names of the addresses and what they are
+// associated with.
+func validateElasticIPs(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := map[string]bool{}
+	targets := map[string]bool{}
+	for i, address := range cfg.ElasticIPs {
+		if !elasticIPNamePattern.MatchString(address.Name) {
+			report("elasticIps[%d].name %q must start with a letter and hold only lowercase letters, digits and -", i, address.Name)
+		}
+		if names[address.Name] {
+			report("elasticIps[%d].name %q is used twice", i, address.Name)
+		}
+		names[address.Name] = true
+		if address.AssociateWith == "" {
+			continue
+		}
+		if address.AssociatesBastion() {
+			if cfg.Bastion == nil {
+				report("elasticIps[%d].associateWith %s needs a bastion", i, address.AssociateWith)
+			}
+		} else if !elasticIPTargetPattern.MatchString(address.AssociateWith) {
+			report("elasticIps[%d].associateWith %q must be an instance or network interface ID, or %s", i, address.AssociateWith, ElasticIPTargetBastion)
+		}
+		if targets[address.AssociateWith] {
+			report("elasticIps[%d].associateWith %s already gets another address", i, address.AssociateWith)
+		}
+		targets[address.AssociateWith] = true
+	}
+	return problems
+}
+
+// validateLogs checks the names, files and retention of the log groups and
+// the settings they can't be combined with.
+func validateLogs(cfg *Config, logs LogsConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := map[string]bool{}
+	for _, group := range []struct {
+		path   string
+		config LogGroupConfig
+	}{
+		{"logs.app", logs.App},
+		{"logs.access", logs.Access},
+		{"logs.system", logs.System},
+	} {
+		if !logGroupNamePattern.MatchString(group.config.Name) {
+			report("%s.name %q is not a valid log group name", group.path, group.config.Name)
+		}
+		if names[group.config.Name] || cfg.VPC.FlowLogs.Enabled && cfg.VPC.FlowLogs.ToCloudWatchLogs() && group.config.Name == cfg.VPC.FlowLogs.LogGroupName {
+			report("%s.name %q is used by another log group", group.path, group.config.Name)
+		}
+		names[group.config.Name] = true
+		for i, file := range group.config.Files {
+			if !strings.HasPrefix(file, "/") {
+				report("%s.files[%d] %q must be an absolute path", group.path, i, file)
+			}
+		}
+		if days := group.config.Retention(); !slices.Contains(FlowLogsRetentionDays, days) {
+			report("%s.retentionDays %d is not a retention period CloudWatch Logs supports", group.path, days)
+		}
+	}
+	if cfg.Windows != nil {
+		report("logs can't be combined with windows, the CloudWatch agent is installed by a shell script")
+	}
+	return problems
+}
+
+// validateLifecycleEvents checks the names of the rule and the topic, the
+// target the events go to and the email addresses subscribed to the topic.
+func validateLifecycleEvents(events LifecycleEventsConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !ruleNamePattern.MatchString(events.RuleName) {
+		report("lifecycleEvents.ruleName %q must be at most 64 letters, digits, dots, hyphens and underscores", events.RuleName)
+	}
+	if events.TopicARN != "" && events.FunctionARN != "" {
+		report("lifecycleEvents can't set both topicArn and functionArn")
+	}
+	if events.TopicARN != "" && !strings.HasPrefix(events.TopicARN, "arn:aws:sns:") {
+		report("lifecycleEvents.topicArn %q must be an SNS topic ARN", events.TopicARN)
+	}
+	if events.FunctionARN != "" && !strings.HasPrefix(events.FunctionARN, "arn:aws:lambda:") {
+		report("lifecycleEvents.functionArn %q must be a Lambda function ARN", events.FunctionARN)
+	}
+	if events.CreatesTopic() && !topicNamePattern.MatchString(events.TopicName) {
+		report("lifecycleEvents.topicName %q must be at most 256 letters, digits, hyphens and underscores", events.TopicName)
+	}
+	if events.FunctionARN != "" && len(events.Emails) > 0 {
+		report("lifecycleEvents.emails can only be subscribed to a topic, not with functionArn")
+	}
+	seen := map[string]bool{}
+	for i, email := range events.Emails {
+		if !emailPattern.MatchString(email) {
+			report("lifecycleEvents.emails[%d] %q is not an email address", i, email)
+		}
+		if seen[email] {
+			report("lifecycleEvents.emails[%d] %q is listed twice", i, email)
+		}
+		seen[email] = true
+	}
+	return problems
+}
+
+// validateSecrets checks the names, stores and paths of the secrets and
+// where their values are read from.
+func validateSecrets(cfg *Config) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for i, secret := range cfg.Secrets {
+		if !secretNamePattern.MatchString(secret.Name) {
+			report("secrets[%d].name %q must be a valid environment variable name", i, secret.Name)
+		}
+		if names[secret.Name] {
+			report("secrets[%d].name %q is used twice", i, secret.Name)
+		}
+		names[secret.Name] = true
+		if app := cfg.App; app != nil {
+			if _, ok := app.Env[secret.Name]; ok {
+				report("secrets[%d].name %q is also set in app.env", i, secret.Name)
+			}
+		}
+		if !slices.Contains(SecretStores, secret.Store) {
+			report("secrets[%d].store %q must be one of %s", i, secret.Store, strings.Join(SecretStores, ", "))
+		}
+		if secret.Store == SecretStoreSSM && !strings.HasPrefix(secret.Path, "/") {
+			report("secrets[%d].path %q must start with a slash", i, secret.Path)
+		}
+		if paths[secret.Path] {
+			report("secrets[%d].path %q is used twice", i, secret.Path)
+		}
+		paths[secret.Path] = true
+		if secret.FromFile != "" && secret.FromEnv != secret.Name {
+			report("secrets[%d] can't set both fromEnv and fromFile", i)
+		}
+	}
+	return problems
+}
+
+// validateListener checks the port, certificates, mutual TLS and
+// authentication settings of a listener.
+func validateListener(path string, listener ListenerConfig) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if listener.Port < 1 || listener.Port > 65535 {
+		report("%s.port %d is not a valid port", path, listener.Port)
+	}
+	if arn := listener.CertificateARN; arn != "" && !strings.HasPrefix(arn, "arn:") {
+		report("%s.certificateArn %q is not an ARN", path, arn)
+	}
+	for i, arn := range listener.AdditionalCertificateARNs {
+		if !strings.HasPrefix(arn, "arn:") {
+			report("%s.additionalCertificateArns[%d] %q is not an ARN", path, i, arn)
+		}
+	}
+	if len(listener.AdditionalCertificateARNs) > 0 && listener.CertificateARN == "" {
+		report("%s.additionalCertificateArns needs %s.certificateArn as the default certificate", path, path)
+	}
+	if listener.CertificateARN != "" && !strings.HasPrefix(listener.SSLPolicy, "ELBSecurityPolicy-") {
+		report("%s.sslPolicy %q is not an ELB security policy such as %s", path, listener.SSLPolicy, DefaultSSLPolicy)
+	}
+	if mtls := listener.MutualTLS; mtls != nil {
+		if listener.CertificateARN == "" {
+			report("%s.mutualTls needs %s.certificateArn, mutual TLS is only available on HTTPS listeners", path, path)
+		}
+		if mtls.CABundleBucket == "" || mtls.CABundleKey == "" {
+			report("%s.mutualTls needs the caBundleBucket and caBundleKey of the CA bundle", path)
+		}
+		if len(mtls.TrustStoreName) > MaxELBNameLength || !elbNamePattern.MatchString(mtls.TrustStoreName) {
+			report("%s.mutualTls.trustStoreName %q must be 1-%d alphanumeric characters or hyphens", path, mtls.TrustStoreName, MaxELBNameLength)
+		}
+	}
+
+	if auth := listener.Authentication; auth != nil {
+		if listener.CertificateARN == "" {
+			report("%s.authentication needs %s.certificateArn, authentication is only available on HTTPS listeners", path, path)
+		}
+		switch auth.Type {
+		case AuthenticationOIDC:
+			if auth.Issuer == "" || auth.AuthorizationEndpoint == "" || auth.TokenEndpoint == "" || auth.UserInfoEndpoint == "" {
+				report("%s.authentication needs the issuer, authorizationEndpoint, tokenEndpoint and userInfoEndpoint of the OIDC provider", path)
+			}
+			if auth.ClientSecretID == "" {
+				report("%s.authentication.clientSecretId must name the Secrets Manager secret with the clientId and clientSecret", path)
+			}
+		case AuthenticationCognito:
+			if auth.UserPoolARN == "" || auth.UserPoolClientID == "" || auth.UserPoolDomain == "" {
+				report("%s.authentication needs the userPoolArn, userPoolClientId and userPoolDomain of the Cognito user pool", path)
+			}
+		default:
+			report("%s.authentication.type %q must be %s or %s", path, auth.Type, AuthenticationOIDC, AuthenticationCognito)
+		}
+		if !slices.Contains(UnauthenticatedRequestActions, auth.OnUnauthenticatedRequest) {
+			report("%s.authentication.onUnauthenticatedRequest %q must be one of %s", path, auth.OnUnauthenticatedRequest, strings.Join(UnauthenticatedRequestActions, ", "))
+		}
+		if auth.SessionTimeoutSeconds < 0 || auth.SessionTimeoutSeconds > MaxSessionTimeoutSeconds {
+			report("%s.authentication.sessionTimeoutSeconds %d must be between 1 and %d", path, auth.SessionTimeoutSeconds, MaxSessionTimeoutSeconds)
+		}
+	}
+
+	return problems
+}
+
+// validateSubnets checks explicitly configured subnets.
+func validateSubnets(cfg *Config, vpcPrefixes []netip.Prefix) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	subnetPrefixes := make([]netip.Prefix, len(cfg.VPC.Subnets))
+	publicZones, privateZones := map[string]bool{}, map[string]bool{}
+	for i, subnet := range cfg.VPC.Subnets {
+		prefix, err := netip.ParsePrefix(subnet.CIDRBlock)
+		if err != nil {
+			report("vpc.subnets[%d].cidrBlock %q is not a valid CIDR block", i, subnet.CIDRBlock)
+			continue
+		}
+		subnetPrefixes[i] = prefix
+		if len(vpcPrefixes) > 0 && !slices.ContainsFunc(vpcPrefixes, func(vpcPrefix netip.Prefix) bool {
+			return vpcPrefix.Contains(prefix.Addr()) && prefix.Bits() >= vpcPrefix.Bits()
+		}) {
+			report("vpc.subnets[%d].cidrBlock %s is outside of the VPC CIDR blocks %s", i, prefix, strings.Join(cfg.VPC.CIDRBlocks(), ", "))
+		}
+		for j := 0; j < i; j++ {
+			if subnetPrefixes[j].IsValid() && subnetPrefixes[j].Overlaps(prefix) {
+				report("vpc.subnets[%d].cidrBlock %s overlaps vpc.subnets[%d].cidrBlock %s", i, prefix, j, subnetPrefixes[j])
+			}
+		}
+
+		if !availabilityZonePattern.MatchString(subnet.AvailabilityZone) || !strings.HasPrefix(subnet.AvailabilityZone, cfg.Region) {
+			report("vpc.subnets[%d].availabilityZone %q is not an availability zone of region %s", i, subnet.AvailabilityZone, cfg.Region)
+		}
+		if subnet.Private {
+			privateZones[subnet.AvailabilityZone] = true
+		} else {
+			publicZones[subnet.AvailabilityZone] = true
+		}
+	}
+	if len(publicZones) < 2 {
+		report("vpc.subnets must include public subnets in at least 2 availability zones, the load balancer needs them")
+	}
+	if cfg.LoadBalancer.Internal() && len(privateZones) < 2 {
+		report("vpc.subnets must include private subnets in at least 2 availability zones for an internal load balancer")
+	}
+
+	return problems
+}
+
+// CheckConfig returns an error listing every problem of cfg, or nil when it
+// is valid.
+func CheckConfig(cfg *Config) error {
+	problems := ValidateConfig(cfg)
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config, %d problem(s):\n  - %s", len(problems), strings.Join(problems, "\n  - "))
+}
+
+func runValidate(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	opts.Register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfig(opts.ConfigPath, opts.Env, opts.Stack)
+	if err != nil {
+		return err
+	}
+
+	if err := CheckConfig(cfg); err != nil {
+		return err
+	}
+	logger.Println("Config is valid")
+
+	return nil
+}
+
+// validateRule checks the protocol, ports and CIDR blocks of a security
+// group rule.
+func validateRule(path string, rule SecurityGroupRule) []string {
+	var problems []string
+	report := func(format string, args ...any) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if !slices.Contains([]string{"tcp", "udp", "icmp", ProtocolAll}, rule.protocol()) {
+		report("protocol %q must be tcp, udp, icmp or -1", rule.Protocol)
+	}
+	if rule.protocol() == "tcp" || rule.protocol() == "udp" {
+		if rule.FromPort < 1 || rule.FromPort > 65535 || rule.toPort() < rule.FromPort || rule.toPort() > 65535 {
+			report("ports %s are not a valid port range", portRange(rule.FromPort, rule.toPort()))
+		}
+	}
+	if len(rule.CIDRs) == 0 && len(rule.PrefixListIDs) == 0 && len(rule.PrefixListNames) == 0 {
+		report("cidrs, prefixListIds or prefixListNames must be set")
+	}
+	if len(rule.Description) > 255 || !securityGroupRuleDescriptionPattern.MatchString(rule.Description) {
+		report("description %q must be at most 255 letters, digits, spaces or ._-:/()#,@[]+=&;{}!$*", rule.Description)
+	}
+	for _, name := range rule.PrefixListNames {
+		if name == "" {
+			report("prefixListNames must not contain empty names")
+		}
+	}
+	for _, cidr := range rule.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			report("cidr %q is not a valid CIDR block", cidr)
+		}
+	}
+	for _, prefixListID := range rule.PrefixListIDs {
+		if !strings.HasPrefix(prefixListID, "pl-") {
+			report("prefix list ID %q must start with pl-", prefixListID)
+		}
+	}
+	return problems
+}
+
+// validateNetworkACLEntries checks the entries of one direction of a network
+// ACL.
+func validateNetworkACLEntries(path string, entries []NetworkACLEntry) []string {
+	var problems []string
+	ruleNumbers := map[int32]bool{}
+	for i, entry := range entries {
+		report := func(format string, args ...any) {
+			problems = append(problems, fmt.Sprintf("%s[%d]: ", path, i)+fmt.Sprintf(format, args...))
+		}
+		normalized := entry.normalized()
+
+		if entry.RuleNumber < 1 || entry.RuleNumber > 32766 {
+			report("ruleNumber %d must be between 1 and 32766", entry.RuleNumber)
+		}
+		if ruleNumbers[entry.RuleNumber] {
+			report("ruleNumber %d is used twice", entry.RuleNumber)
+		}
+		ruleNumbers[entry.RuleNumber] = true
+		if _, ok := networkACLProtocols[normalized.Protocol]; !ok {
+			report("protocol %q must be tcp, udp, icmp or -1", entry.Protocol)
+		}
+		if entry.HasPorts() && (normalized.FromPort < 1 || normalized.ToPort < normalized.FromPort || normalized.ToPort > 65535) {
+			report("ports %s are not a valid port range", portRange(normalized.FromPort, normalized.ToPort))
+		}
+		if _, err := netip.ParsePrefix(entry.CIDR); err != nil {
+			report("cidr %q is not a valid CIDR block", entry.CIDR)
+		}
+		if entry.Action != "allow" && entry.Action != "deny" {
+			report("action %q must be allow or deny", entry.Action)
+		}
+	}
+	return problems
+}
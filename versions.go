@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// RollbackLaunchTemplateVersion makes the autoscaling group launch instances
+// from targetVersion again. A pinned group is simply pointed at that version.
+// A group tracking $Latest cannot be pointed backwards, so the old version is
+// copied into a new latest version instead.
+func RollbackLaunchTemplateVersion(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State, targetVersion int64) error {
+	if state.CurrentLaunchTemplateVersion() != AWSLaunchTemplateVersion {
+		version := strconv.FormatInt(targetVersion, 10)
+		if err := SetAutoscalingGroupLaunchTemplateVersion(ctx, logger, clients.AutoScaling, state.AutoScalingGroupName, state.LaunchTemplateID, version, cfg.AutoScaling.InstanceRequirements); err != nil {
+			return err
+		}
+		state.LaunchTemplateVersion = version
+		return state.Save()
+	}
+
+	output, err := clients.EC2.CreateLaunchTemplateVersion(ctx, &ec2.CreateLaunchTemplateVersionInput{
+		LaunchTemplateId:   aws.String(state.LaunchTemplateID),
+		SourceVersion:      aws.String(strconv.FormatInt(targetVersion, 10)),
+		LaunchTemplateData: &types.RequestLaunchTemplateData{},
+		VersionDescription: aws.String(fmt.Sprintf("Rollback to version %d", targetVersion)),
+	})
+	if err != nil {
+		return fmt.Errorf("error creating rollback launch template version: %w", err)
+	}
+	logger.Printf("Launch template %s version %d created as a copy of version %d",
+		state.LaunchTemplateID, aws.Int64Value(output.LaunchTemplateVersion.VersionNumber), targetVersion)
+
+	// The latest version no longer matches the config, the next apply
+	// creates a fresh version from it again.
+	state.LaunchTemplateDataHash = ""
+	return state.Save()
+}
+
+// currentLaunchTemplateVersionNumber resolves the version the autoscaling
+// group launches instances from to a number.
+func currentLaunchTemplateVersionNumber(ctx context.Context, ec2Client *ec2.Client, state *State) (int64, error) {
+	if version := state.CurrentLaunchTemplateVersion(); version != AWSLaunchTemplateVersion {
+		number, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid launch template version %q in state: %w", version, err)
+		}
+		return number, nil
+	}
+
+	output, err := ec2Client.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateIds: []string{state.LaunchTemplateID},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error describing launch template: %w", err)
+	}
+	if len(output.LaunchTemplates) == 0 {
+		return 0, fmt.Errorf("launch template %s not found", state.LaunchTemplateID)
+	}
+
+	return aws.Int64Value(output.LaunchTemplates[0].LatestVersionNumber), nil
+}
+
+func runRollbackVersion(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	var targetVersion int64
+	fs := flag.NewFlagSet("rollback-version", flag.ExitOnError)
+	opts.Register(fs)
+	fs.Int64Var(&targetVersion, "version", 0, "launch template version to roll back to (defaults to the previous version)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+	if state.ExistingLaunchTemplate {
+		return fmt.Errorf("launch template %s is managed outside the stack, set launchTemplate.existing.version and apply instead", state.LaunchTemplateID)
+	}
+
+	currentVersion, err := currentLaunchTemplateVersionNumber(ctx, clients.EC2, state)
+	if err != nil {
+		return err
+	}
+	if targetVersion == 0 {
+		targetVersion = currentVersion - 1
+	}
+	if targetVersion < 1 || targetVersion == currentVersion {
+		return fmt.Errorf("cannot roll back from version %d to version %d", currentVersion, targetVersion)
+	}
+
+	if err := RollbackLaunchTemplateVersion(ctx, logger, clients, cfg, state, targetVersion); err != nil {
+		return err
+	}
+	logger.Println("Run the refresh command to replace the running instances")
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmsTypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+const (
+	// AutoScalingServiceLinkedRole is the role the autoscaling service
+	// launches instances with, it needs the key to create their volumes.
+	AutoScalingServiceLinkedRole = "arn:aws:iam::%s:role/aws-service-role/autoscaling.amazonaws.com/AWSServiceRoleForAutoScaling"
+	DefaultKeyDeletionWindowDays = 30
+	MinKeyDeletionWindowDays     = 7
+	MaxKeyDeletionWindowDays     = 30
+)
+
+// volumeKeyOperations are the operations the autoscaling service-linked role
+// needs to create encrypted volumes and hand the key to EC2.
+var volumeKeyOperations = []kmsTypes.GrantOperation{
+	kmsTypes.GrantOperationEncrypt,
+	kmsTypes.GrantOperationDecrypt,
+	kmsTypes.GrantOperationReEncryptFrom,
+	kmsTypes.GrantOperationReEncryptTo,
+	kmsTypes.GrantOperationGenerateDataKey,
+	kmsTypes.GrantOperationGenerateDataKeyWithoutPlaintext,
+	kmsTypes.GrantOperationDescribeKey,
+	kmsTypes.GrantOperationCreateGrant,
+}
+
+// VolumeEncryptionConfig encrypts every EBS volume of the instances, root
+// and data volumes, with a customer managed KMS key.
+type VolumeEncryptionConfig struct {
+	// KeyARN references an existing key, the autoscaling service-linked role
+	// is granted its use. When empty, a key is created.
+	KeyARN string `json:"keyArn"`
+	// Alias names the created key, alias/<resource name> by default.
+	Alias string `json:"alias"`
+	// DeletionWindowDays is how long the created key can still be recovered
+	// after destroy, 7 to 30 days.
+	DeletionWindowDays int32 `json:"deletionWindowDays"`
+}
+
+// CreatesKey reports whether the key is created rather than referenced.
+func (v VolumeEncryptionConfig) CreatesKey() bool {
+	return v.KeyARN == ""
+}
+
+func (v VolumeEncryptionConfig) String() string {
+	if v.CreatesKey() {
+		return fmt.Sprintf("%s, deleted %d days after destroy", v.Alias, v.DeletionWindowDays)
+	}
+	return v.KeyARN + ", granted to the autoscaling service-linked role"
+}
+
+// VolumeKeyPolicy leaves the key to the administrators of the account and
+// lets the autoscaling service-linked role use it for the volumes of the
+// instances it launches.
+func VolumeKeyPolicy(accountID string) string {
+	serviceLinkedRole := fmt.Sprintf(AutoScalingServiceLinkedRole, accountID)
+	return policyJSON(
+		map[string]any{
+			"Sid":       "AccountAdministration",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"AWS": fmt.Sprintf("arn:aws:iam::%s:root", accountID)},
+			"Action":    "kms:*",
+			"Resource":  "*",
+		},
+		map[string]any{
+			"Sid":       "AutoScalingVolumes",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"AWS": serviceLinkedRole},
+			"Action":    []string{"kms:Encrypt", "kms:Decrypt", "kms:ReEncrypt*", "kms:GenerateDataKey*", "kms:DescribeKey"},
+			"Resource":  "*",
+		},
+		map[string]any{
+			"Sid":       "AutoScalingGrants",
+			"Effect":    "Allow",
+			"Principal": map[string]string{"AWS": serviceLinkedRole},
+			"Action":    "kms:CreateGrant",
+			"Resource":  "*",
+			"Condition": map[string]any{"Bool": map[string]bool{"kms:GrantIsForAWSResource": true}},
+		},
+	)
+}
+
+func kmsTags(tags map[string]string) []kmsTypes.Tag {
+	result := make([]kmsTypes.Tag, 0, len(tags))
+	for _, key := range sortedTagKeys(tags) {
+		result = append(result, kmsTypes.Tag{TagKey: aws.String(key), TagValue: aws.String(tags[key])})
+	}
+	return result
+}
+
+// ensureAutoScalingServiceLinkedRole creates the autoscaling service-linked
+// role, which only exists once the account created its first autoscaling
+// group, as a key policy can't name a role that doesn't exist.
+func ensureAutoScalingServiceLinkedRole(ctx context.Context, logger *log.Logger, iamClient *iam.Client) error {
+	if _, err := iamClient.CreateServiceLinkedRole(ctx, &iam.CreateServiceLinkedRoleInput{
+		AWSServiceName: aws.String("autoscaling.amazonaws.com"),
+	}); err != nil {
+		if hasErrorCode(err, "InvalidInput") {
+			return nil
+		}
+		return fmt.Errorf("error creating autoscaling service-linked role: %w", err)
+	}
+	logger.Println("Autoscaling service-linked role created")
+	return nil
+}
+
+// CreateVolumeKey creates the key and its alias, or grants the autoscaling
+// service-linked role the use of the referenced key. It returns the key ARN,
+// and the alias or the grant ID to record.
+func CreateVolumeKey(ctx context.Context, logger *log.Logger, clients *Clients, encryption VolumeEncryptionConfig, tags map[string]string) (string, string, error) {
+	identity, err := clients.STS.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", "", fmt.Errorf("error getting caller identity: %w", err)
+	}
+	accountID := aws.StringValue(identity.Account)
+	if err := ensureAutoScalingServiceLinkedRole(ctx, logger, clients.IAM); err != nil {
+		return "", "", err
+	}
+
+	if !encryption.CreatesKey() {
+		output, err := clients.KMS.CreateGrant(ctx, &kms.CreateGrantInput{
+			KeyId:            aws.String(encryption.KeyARN),
+			GranteePrincipal: aws.String(fmt.Sprintf(AutoScalingServiceLinkedRole, accountID)),
+			Operations:       volumeKeyOperations,
+		})
+		if err != nil {
+			return "", "", fmt.Errorf("error granting the autoscaling service-linked role the use of key %s: %w", encryption.KeyARN, err)
+		}
+		grantID := aws.StringValue(output.GrantId)
+		logger.Printf("Autoscaling service-linked role granted the use of key %s with grant %s", encryption.KeyARN, grantID)
+		return encryption.KeyARN, grantID, nil
+	}
+
+	output, err := clients.KMS.CreateKey(ctx, &kms.CreateKeyInput{
+		Description: aws.String("Encryption of the EBS volumes of the autoscaling group"),
+		Policy:      aws.String(VolumeKeyPolicy(accountID)),
+		Tags:        kmsTags(tags),
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("error creating KMS key: %w", err)
+	}
+	keyARN := aws.StringValue(output.KeyMetadata.Arn)
+	logger.Printf("KMS key created with ARN: %s", keyARN)
+
+	if _, err := clients.KMS.CreateAlias(ctx, &kms.CreateAliasInput{
+		AliasName:   aws.String(encryption.Alias),
+		TargetKeyId: aws.String(keyARN),
+	}); err != nil {
+		return keyARN, "", fmt.Errorf("error creating KMS key alias: %w", err)
+	}
+	logger.Printf("KMS key alias %s created", encryption.Alias)
+	return keyARN, encryption.Alias, nil
+}
+
+// RecordVolumeKey saves the key and its alias or grant in the state.
+func RecordVolumeKey(state *State, encryption VolumeEncryptionConfig, keyARN, aliasOrGrant string) error {
+	return state.Record(func(s *State) {
+		s.VolumeKeyARN = keyARN
+		if encryption.CreatesKey() {
+			s.VolumeKeyAlias = aliasOrGrant
+		} else {
+			s.VolumeKeyGrantID = aliasOrGrant
+		}
+	})
+}
+
+// DeleteVolumeKey revokes the grant on a referenced key, or deletes the alias
+// of the created key and schedules its deletion after the deletion window.
+func DeleteVolumeKey(ctx context.Context, logger *log.Logger, kmsClient *kms.Client, state *State, deletionWindowDays int32) error {
+	if state.VolumeKeyGrantID != "" {
+		if _, err := kmsClient.RevokeGrant(ctx, &kms.RevokeGrantInput{
+			KeyId:   aws.String(state.VolumeKeyARN),
+			GrantId: aws.String(state.VolumeKeyGrantID),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error revoking grant on KMS key: %w", err)
+		}
+		logger.Printf("Grant %s on KMS key %s revoked", state.VolumeKeyGrantID, state.VolumeKeyARN)
+		return nil
+	}
+
+	if state.VolumeKeyAlias != "" {
+		if _, err := kmsClient.DeleteAlias(ctx, &kms.DeleteAliasInput{
+			AliasName: aws.String(state.VolumeKeyAlias),
+		}); err != nil && !isNotFound(err) {
+			return fmt.Errorf("error deleting KMS key alias: %w", err)
+		}
+		logger.Printf("KMS key alias %s deleted", state.VolumeKeyAlias)
+	}
+	if _, err := kmsClient.ScheduleKeyDeletion(ctx, &kms.ScheduleKeyDeletionInput{
+		KeyId:               aws.String(state.VolumeKeyARN),
+		PendingWindowInDays: aws.Int32(deletionWindowDays),
+	}); err != nil && !isNotFound(err) && !hasErrorCode(err, "KMSInvalidStateException") {
+		return fmt.Errorf("error scheduling deletion of KMS key: %w", err)
+	}
+	logger.Printf("KMS key %s scheduled for deletion in %d days", state.VolumeKeyARN, deletionWindowDays)
+	return nil
+}
+
+// UpdateVolumeKey sets up the key when volume encryption was added to the
+// config after the stack was applied, before the launch template refers to
+// it.
+func UpdateVolumeKey(ctx context.Context, logger *log.Logger, clients *Clients, cfg *Config, state *State) error {
+	encryption := cfg.VolumeEncryption
+	if encryption == nil {
+		return nil
+	}
+	if state.VolumeKeyARN != "" {
+		if !encryption.CreatesKey() && state.VolumeKeyARN != encryption.KeyARN || encryption.CreatesKey() && state.VolumeKeyAlias == "" {
+			return fmt.Errorf("the volumes are encrypted with key %s, switching keys in place is not supported, recreate the stack", state.VolumeKeyARN)
+		}
+		return nil
+	}
+
+	keyARN, aliasOrGrant, err := CreateVolumeKey(ctx, logger, clients, *encryption, cfg.StackTags())
+	if keyARN != "" {
+		if saveErr := RecordVolumeKey(state, *encryption, keyARN, aliasOrGrant); saveErr != nil {
+			return saveErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	DefaultWatchInterval = 30 * time.Second
+)
+
+// Watch polls the status of the stack every interval until ctx is done and
+// hands each status to observe. A poll that fails is logged and retried at
+// the next interval, so a throttled or unreachable API doesn't end the watch.
+func Watch(ctx context.Context, logger *log.Logger, clients *Clients, state *State, interval time.Duration, observe func(*StackStatus)) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := CollectStatus(ctx, clients, state)
+		switch {
+		case ctx.Err() != nil:
+			return nil
+		case err != nil:
+			logger.Printf("Error polling the stack: %v", err)
+		default:
+			observe(status)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// StatusChanges describes what changed between two polls of the stack:
+// instances launched, changing lifecycle state or leaving the group, target
+// health, capacity, scaling activities and alarm states.
+func StatusChanges(previous, current *StackStatus) []string {
+	var changes []string
+	report := func(format string, args ...any) {
+		changes = append(changes, fmt.Sprintf(format, args...))
+	}
+
+	if current.LoadBalancerState != previous.LoadBalancerState {
+		report("Load balancer is %s (was %s)", current.LoadBalancerState, orDash(previous.LoadBalancerState))
+	}
+	if current.Capacity != previous.Capacity {
+		report("Capacity is %s (was %s)", current.Capacity, previous.Capacity)
+	}
+	if current.Maintenance && !previous.Maintenance {
+		report("Maintenance mode is on")
+	} else if previous.Maintenance && !current.Maintenance {
+		report("Maintenance mode is off")
+	}
+	if current.CanaryWeight != previous.CanaryWeight && current.CanaryWeight >= 0 {
+		report("Canary gets %d%% of the traffic", current.CanaryWeight)
+	}
+
+	before := map[string]InstanceStatus{}
+	for _, instance := range previous.Instances {
+		before[instance.InstanceID] = instance
+	}
+	for _, instance := range current.Instances {
+		old, known := before[instance.InstanceID]
+		delete(before, instance.InstanceID)
+		switch {
+		case !known:
+			report("Instance %s launched in %s (%s)", instance.InstanceID, instance.AvailabilityZone, instance.LifecycleState)
+		case instance.LifecycleState != old.LifecycleState:
+			report("Instance %s is %s (was %s)", instance.InstanceID, instance.LifecycleState, old.LifecycleState)
+		}
+		if instance.TargetHealth != old.TargetHealth && instance.TargetHealth != "" {
+			if instance.TargetReason != "" {
+				report("Target %s is %s (%s)", instance.InstanceID, instance.TargetHealth, instance.TargetReason)
+			} else {
+				report("Target %s is %s", instance.InstanceID, instance.TargetHealth)
+			}
+		}
+	}
+	for _, instance := range previous.Instances {
+		if _, gone := before[instance.InstanceID]; gone {
+			report("Instance %s left the group", instance.InstanceID)
+		}
+	}
+
+	// Activities are listed newest first; report the new ones and the ones
+	// whose status changed oldest first.
+	activityKey := func(activity ScalingActivity) string {
+		return activity.StartTime.String() + activity.Description
+	}
+	activities := map[string]string{}
+	for _, activity := range previous.Activities {
+		activities[activityKey(activity)] = activity.StatusCode
+	}
+	for i := len(current.Activities) - 1; i >= 0; i-- {
+		activity := current.Activities[i]
+		if status, known := activities[activityKey(activity)]; !known || status != activity.StatusCode {
+			report("Scaling activity %s: %s", activity.StatusCode, activity.Description)
+		}
+	}
+
+	alarms := map[string]string{}
+	for _, alarm := range previous.Alarms {
+		alarms[alarm.Name] = alarm.State
+	}
+	for _, alarm := range current.Alarms {
+		if alarms[alarm.Name] != alarm.State {
+			report("Alarm %s is %s: %s", alarm.Name, alarm.State, alarm.Reason)
+		}
+	}
+	return changes
+}
+
+func runWatch(ctx context.Context, logger *log.Logger, args []string) error {
+	var opts GlobalOptions
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts.Register(fs)
+	interval := fs.Duration("interval", DefaultWatchInterval, "time between two polls of the stack")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics of the stack on this address, e.g. :9100")
+	notify := fs.Bool("notify", false, "also post the changes to the notifications of the config")
+	tui := fs.Bool("tui", false, "show a full-screen dashboard of the instances, scaling activities and changes when stderr is a terminal")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *interval <= 0 {
+		return errors.New("--interval must be positive")
+	}
+
+	cfg, state, clients, err := LoadStack(ctx, logger, &opts)
+	if err != nil {
+		return err
+	}
+
+	// SIGINT and SIGTERM end the watch.
+	ctx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	var dashboard *Dashboard
+	if *tui && term.IsTerminal(int(os.Stderr.Fd())) {
+		dashboard = NewDashboard(os.Stderr, "watch "+cfg.Naming.Stack, nil)
+		logger = dashboard.Logger()
+		dashboard.Start()
+		defer dashboard.Stop()
+	}
+
+	metrics := NewStackMetrics(cfg)
+	if *metricsAddr != "" {
+		if err := ServeMetrics(ctx, logger, *metricsAddr, metrics); err != nil {
+			return err
+		}
+	}
+	notifier := NewNotifier(logger, cfg, "watch")
+
+	var previous *StackStatus
+	observe := func(status *StackStatus) {
+		metrics.Update(status)
+		if dashboard != nil {
+			dashboard.SetStatus(status)
+		}
+		if previous == nil {
+			logger.Printf("%d instances in service, %d healthy targets, capacity %s", status.InServiceInstances(), status.HealthyTargets(), status.Capacity)
+		} else {
+			for _, change := range StatusChanges(previous, status) {
+				logger.Print(change)
+				if *notify {
+					notifier.Changed(change)
+				}
+			}
+		}
+		previous = status
+	}
+
+	logger.Printf("Watching %s every %s, interrupt to stop", state.AutoScalingGroupName, *interval)
+	return Watch(ctx, logger, clients, state, *interval, observe)
+}
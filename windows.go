@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// WindowsAMIParameter is the public SSM parameter of the latest Windows
+	// Server AMI of a version, e.g. 2022.
+	WindowsAMIParameter   = "/aws/service/ami-windows-latest/Windows_Server-%s-English-Full-Base"
+	DefaultWindowsVersion = "2022"
+	WindowsUserDataScript = "user_data.ps1"
+	// IIS serves the default web site on port 80.
+	DefaultWindowsTargetGroupPort = 80
+	// Windows instances take several minutes to boot and install IIS before
+	// they answer the health checks.
+	DefaultWindowsHealthCheckGracePeriod = 900
+	DefaultWindowsSmokeTestTimeout       = 900
+	RDPPort                              = 3389
+	// WindowsRootDeviceName is the root device of the Windows Server AMIs.
+	WindowsRootDeviceName = "/dev/sda1"
+)
+
+// WindowsVersions are the Windows Server versions with a public AMI
+// parameter.
+var WindowsVersions = []string{"2019", "2022", "2025"}
+
+// WindowsConfig runs Windows Server instances. The user data file is a
+// PowerShell script, run by EC2Launch on the first boot.
+type WindowsConfig struct {
+	// Version is the Windows Server version of the AMI: 2019, 2022 or 2025.
+	Version string `json:"version"`
+	// RDPCIDR is the network allowed to connect with remote desktop, no one
+	// by default.
+	RDPCIDR string `json:"rdpCidr"`
+}
+
+// AMIParameter returns the AMI parameter of the Windows Server version.
+func (w WindowsConfig) AMIParameter() string {
+	return fmt.Sprintf(WindowsAMIParameter, w.Version)
+}
+
+// RDPRule allows remote desktop from RDPCIDR.
+func (w WindowsConfig) RDPRule() SecurityGroupRule {
+	return SecurityGroupRule{
+		Protocol:    "tcp",
+		FromPort:    RDPPort,
+		ToPort:      RDPPort,
+		CIDRs:       []string{w.RDPCIDR},
+		Description: "RDP from " + w.RDPCIDR,
+	}
+}
+
+// powerShellQuote quotes s as a PowerShell string literal.
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// WindowsUserData wraps the PowerShell script in the tags EC2Launch looks
+// for, reading the secrets into environment variables first. A script that
+// brings its own tags keeps them.
+func WindowsUserData(secrets []SecretConfig, script []byte) []byte {
+	var secretLines strings.Builder
+	for _, secret := range secrets {
+		fmt.Fprintf(&secretLines, "$env:%s = %s\n", secret.Name, secret.PowerShellFetchCommand())
+	}
+
+	body := string(script)
+	if i := strings.Index(body, "<powershell>"); i >= 0 {
+		if len(secrets) == 0 {
+			return script
+		}
+		i += len("<powershell>")
+		return []byte(body[:i] + "\n" + secretLines.String() + strings.TrimLeft(body[i:], "\r\n"))
+	}
+	if body != "" && !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return []byte("<powershell>\n" + secretLines.String() + body + "</powershell>\n")
+}